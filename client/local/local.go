@@ -15,12 +15,17 @@
 	"fmt"
 	"io"
 	"iter"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/netip"
+	"net/textproto"
 	"net/url"
+	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -34,6 +39,7 @@
 	"tailscale.com/feature/buildfeatures"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netcheck/netchecktype"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/udprelay/status"
 	"tailscale.com/paths"
@@ -42,9 +48,11 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/appctype"
 	"tailscale.com/types/dnstype"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/eventbus"
+	"tailscale.com/wgengine/filter/filtertype"
 )
 
 // defaultClient is the default Client when using the legacy
@@ -723,7 +731,34 @@ func (lc *Client) DeleteWaitingFile(ctx context.Context, baseName string) error
 }
 
 func (lc *Client) GetWaitingFile(ctx context.Context, baseName string) (rc io.ReadCloser, size int64, err error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/files/"+url.PathEscape(baseName), nil)
+	return lc.getWaitingFile(ctx, url.PathEscape(baseName))
+}
+
+// GetWaitingFileTree returns the manifest of the received directory tree
+// baseName that's waiting in the Tailscale daemon's staging directory.
+// The manifest's entries don't include checksums.
+//
+// See [apitype.WaitingFile.IsDir].
+func (lc *Client) GetWaitingFileTree(ctx context.Context, baseName string) (*apitype.FileTreeManifest, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/files/"+url.PathEscape(baseName)+"/")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.FileTreeManifest](body)
+}
+
+// GetWaitingTreeFile opens the file at the slash-separated filePath in the
+// received directory tree baseName.
+func (lc *Client) GetWaitingTreeFile(ctx context.Context, baseName, filePath string) (rc io.ReadCloser, size int64, err error) {
+	elems := strings.Split(filePath, "/")
+	for i, e := range elems {
+		elems[i] = url.PathEscape(e)
+	}
+	return lc.getWaitingFile(ctx, url.PathEscape(baseName)+"/"+strings.Join(elems, "/"))
+}
+
+func (lc *Client) getWaitingFile(ctx context.Context, escapedPath string) (rc io.ReadCloser, size int64, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/files/"+escapedPath, nil)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -775,6 +810,77 @@ func (lc *Client) PushFile(ctx context.Context, target tailcfg.StableNodeID, siz
 	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
 }
 
+// PushFileTree sends the directory tree rooted at dir to target as a unit.
+//
+// The manifest tm describes the tree, with paths relative to dir, and must
+// include each regular file's size and SHA-256. The receiver verifies every
+// file against the manifest before making the tree visible, and files it
+// already has from an earlier, interrupted attempt aren't sent again.
+func (lc *Client) PushFileTree(ctx context.Context, target tailcfg.StableNodeID, dir string, tm *apitype.FileTreeManifest) (*apitype.FileTreeResult, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeFileTree(mw, dir, tm))
+	}()
+	defer pr.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-put-tree/"+string(target), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	all, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, bestError(fmt.Errorf("%s: %s", res.Status, all), all)
+	}
+	return decodeJSON[*apitype.FileTreeResult](all)
+}
+
+// writeFileTree writes the multipart body of a file-put-tree request for the
+// tree rooted at dir to mw.
+func writeFileTree(mw *multipart.Writer, dir string, tm *apitype.FileTreeManifest) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="manifest"`)
+	h.Set("Content-Type", "application/json")
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(tm); err != nil {
+		return err
+	}
+	for _, e := range tm.Entries {
+		if e.Mode.IsDir() {
+			continue
+		}
+		w, err := mw.CreateFormFile(e.Path, path.Base(e.Path))
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(e.Path)))
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(w, io.LimitReader(f, e.Size))
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if n != e.Size {
+			return fmt.Errorf("%s: file changed size while sending", e.Path)
+		}
+	}
+	return mw.Close()
+}
+
 // CheckIPForwarding asks the local Tailscale daemon whether it looks like the
 // machine is properly configured to forward IP packets as a subnet router
 // or exit node.
@@ -915,10 +1021,21 @@ func (lc *Client) GetDNSOSConfig(ctx context.Context) (*apitype.DNSOSConfig, err
 // It returns the raw DNS response bytes and the resolvers that were used to answer the query
 // (often just one, but can be more if we raced multiple resolvers).
 func (lc *Client) QueryDNS(ctx context.Context, name string, queryType string) (bytes []byte, resolvers []*dnstype.Resolver, err error) {
+	return lc.QueryDNSUpstream(ctx, name, queryType, "")
+}
+
+// QueryDNSUpstream is like QueryDNS, but if upstream is non-empty, the query
+// is forwarded to that resolver (such as "tls://dns.example.com") rather than
+// the ones configured for name.
+func (lc *Client) QueryDNSUpstream(ctx context.Context, name, queryType, upstream string) (bytes []byte, resolvers []*dnstype.Resolver, err error) {
 	if !buildfeatures.HasDNS {
 		return nil, nil, feature.ErrUnavailable
 	}
-	body, err := lc.get200(ctx, fmt.Sprintf("/localapi/v0/dns-query?name=%s&type=%s", url.QueryEscape(name), queryType))
+	path := fmt.Sprintf("/localapi/v0/dns-query?name=%s&type=%s", url.QueryEscape(name), queryType)
+	if upstream != "" {
+		path += "&resolver=" + url.QueryEscape(upstream)
+	}
+	body, err := lc.get200(ctx, path)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -1028,6 +1145,16 @@ func (lc *Client) CurrentDERPMap(ctx context.Context) (*tailcfg.DERPMap, error)
 	return &derpMap, nil
 }
 
+// NetcheckHistory returns the history of the local tailscaled's netcheck
+// reports, oldest first.
+func (lc *Client) NetcheckHistory(ctx context.Context) ([]netchecktype.HistoryEntry, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/netcheck-history")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]netchecktype.HistoryEntry](body)
+}
+
 // PingOpts contains options for the ping request.
 //
 // The zero value is valid, which means to use defaults.
@@ -1218,6 +1345,71 @@ func (lc *Client) DebugPacketFilterRules(ctx context.Context) ([]tailcfg.FilterR
 	return decodeJSON[[]tailcfg.FilterRule](body)
 }
 
+// DebugFilterTrace reports how the packet filter treats traffic from src to
+// dst using protocol proto, as if it were the first packet of a flow. The
+// traffic is from a peer if inbound is true, or to a peer otherwise.
+// A port of zero is fine for protocols without ports.
+func (lc *Client) DebugFilterTrace(ctx context.Context, src, dst netip.AddrPort, proto ipproto.Proto, inbound bool) (*filtertype.Trace, error) {
+	v := url.Values{
+		"src":   {src.String()},
+		"dst":   {dst.String()},
+		"proto": {proto.String()},
+		"dir":   {"out"},
+	}
+	if inbound {
+		v.Set("dir", "in")
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-filter-trace?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*filtertype.Trace](body)
+}
+
+// StreamFilterTraces returns an iterator of traces of sampled live packets
+// that the packet filter runs on, only those it drops if onlyDrops.
+// Each pair is a valid trace and a nil error, or a zero trace and a non-nil
+// error. In case of error, the iterator ends after the pair reporting the
+// error. Iteration stops if ctx ends.
+func (lc *Client) StreamFilterTraces(ctx context.Context, onlyDrops bool) iter.Seq2[filtertype.Trace, error] {
+	return func(yield func(filtertype.Trace, error) bool) {
+		v := url.Values{"live": {"true"}}
+		if onlyDrops {
+			v.Set("drops", "true")
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET",
+			"http://"+apitype.LocalAPIHost+"/localapi/v0/debug-filter-trace?"+v.Encode(), nil)
+		if err != nil {
+			yield(filtertype.Trace{}, err)
+			return
+		}
+		res, err := lc.doLocalRequestNiceError(req)
+		if err != nil {
+			yield(filtertype.Trace{}, err)
+			return
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+			yield(filtertype.Trace{}, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body)))
+			return
+		}
+		dec := json.NewDecoder(bufio.NewReader(res.Body))
+		for {
+			var tr filtertype.Trace
+			if err := dec.Decode(&tr); err == io.EOF {
+				return
+			} else if err != nil {
+				yield(filtertype.Trace{}, err)
+				return
+			}
+			if !yield(tr, nil) {
+				return
+			}
+		}
+	}
+}
+
 // DebugSetExpireIn marks the current node key to expire in d.
 //
 // This is meant primarily for debug and testing.
@@ -1237,12 +1429,58 @@ func (lc *Client) DebugPeerRelaySessions(ctx context.Context) (*status.ServerSta
 	return decodeJSON[*status.ServerStatus](body)
 }
 
+// DebugPeerPath returns how packets are sent to the peer with Tailscale IP
+// ip, why that path was picked, and its recent path changes.
+func (lc *Client) DebugPeerPath(ctx context.Context, ip netip.Addr) (*ipnstate.PeerPath, error) {
+	v := url.Values{"ip": {ip.String()}}
+	body, err := lc.send(ctx, "GET", "/localapi/v0/debug-peer-path?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*ipnstate.PeerPath](body)
+}
+
 // StreamDebugCapture streams a pcap-formatted packet capture.
 //
 // The provided context does not determine the lifetime of the
 // returned [io.ReadCloser].
 func (lc *Client) StreamDebugCapture(ctx context.Context) (io.ReadCloser, error) {
-	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/debug-capture", nil)
+	return lc.StreamDebugCaptureWithOpts(ctx, DebugCaptureOpts{})
+}
+
+// DebugCaptureOpts contains options for [Client.StreamDebugCaptureWithOpts].
+//
+// The zero value is valid, which means to stream all packets as pcap.
+type DebugCaptureOpts struct {
+	// Filter, if non-empty, is an expression selecting the packets to
+	// capture, such as "tcp port 22 and host 100.101.102.103". It
+	// supports a subset of tcpdump's filter language, plus "path <name>"
+	// to select where in tailscaled packets were captured: "from-local",
+	// "from-peer", "synthesized-to-local", "synthesized-to-peer" or
+	// "disco".
+	Filter string
+
+	// Format is the capture format: "pcap" (the default), which needs
+	// Tailscale's Lua dissector in Wireshark, or "pcapng", in which IP
+	// packets are readable by stock Wireshark and annotated with comments
+	// naming their capture path, peer and DERP or direct path.
+	Format string
+}
+
+// StreamDebugCaptureWithOpts streams a packet capture, as configured by
+// opts.
+//
+// The provided context does not determine the lifetime of the
+// returned [io.ReadCloser].
+func (lc *Client) StreamDebugCaptureWithOpts(ctx context.Context, opts DebugCaptureOpts) (io.ReadCloser, error) {
+	v := url.Values{}
+	if opts.Filter != "" {
+		v.Set("filter", opts.Filter)
+	}
+	if opts.Format != "" {
+		v.Set("format", opts.Format)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/debug-capture?"+v.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1251,7 +1489,11 @@ func (lc *Client) StreamDebugCapture(ctx context.Context) (io.ReadCloser, error)
 		return nil, err
 	}
 	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
 		res.Body.Close()
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			return nil, fmt.Errorf("%s: %s", res.Status, msg)
+		}
 		return nil, errors.New(res.Status)
 	}
 	return res.Body, nil
@@ -1365,6 +1607,33 @@ func (lc *Client) DriveShareList(ctx context.Context) ([]*drive.Share, error) {
 	return shares, err
 }
 
+// DriveMount mounts the Taildrive shares of remote nodes at the local
+// directory dir using FUSE. The mount belongs to the current user, who must
+// own dir. This is only supported on Linux.
+func (lc *Client) DriveMount(ctx context.Context, dir string) error {
+	_, err := lc.send(ctx, "PUT", "/localapi/v0/drive/mounts", http.StatusCreated, strings.NewReader(dir))
+	return err
+}
+
+// DriveUnmount unmounts the directory dir, previously mounted with
+// DriveMount.
+func (lc *Client) DriveUnmount(ctx context.Context, dir string) error {
+	_, err := lc.send(ctx, "DELETE", "/localapi/v0/drive/mounts", http.StatusNoContent, strings.NewReader(dir))
+	return err
+}
+
+// DriveMountList returns the directories at which Taildrive shares are
+// currently mounted.
+func (lc *Client) DriveMountList(ctx context.Context) ([]string, error) {
+	result, err := lc.get200(ctx, "/localapi/v0/drive/mounts")
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	err = json.Unmarshal(result, &dirs)
+	return dirs, err
+}
+
 // IPNBusWatcher is an active subscription (watch) of the local tailscaled IPN bus.
 // It's returned by [Client.WatchIPNBus].
 //
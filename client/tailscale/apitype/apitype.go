@@ -5,6 +5,8 @@
 package apitype
 
 import (
+	"io/fs"
+
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/ctxkey"
@@ -54,6 +56,65 @@ type FileTarget struct {
 type WaitingFile struct {
 	Name string
 	Size int64
+
+	// IsDir reports whether the waiting file is a directory tree that was
+	// sent as a whole (see FileTreeManifest). Its contents can be listed
+	// and fetched through the LocalAPI files/ endpoint by appending a slash
+	// and the entry's path to its escaped Name. Size is then the total size
+	// of all regular files in the tree.
+	IsDir bool `json:",omitempty"`
+}
+
+// FileTreeManifest describes a directory tree that is sent with Taildrop as a
+// unit. It's sent ahead of the files themselves so the receiver can verify
+// and resume the transfer file by file.
+type FileTreeManifest struct {
+	// Name is the base name of the tree's root directory.
+	Name string
+
+	// Entries are the directories and regular files in the tree, in the
+	// order they're sent. Parent directories needn't be listed explicitly
+	// unless they're empty or have a non-default mode.
+	Entries []FileTreeEntry
+}
+
+// FileTreeEntry is a directory or regular file in a FileTreeManifest.
+type FileTreeEntry struct {
+	// Path is the slash-separated path of the entry relative to the root of
+	// the tree. Each path element must be a valid Taildrop filename.
+	Path string
+
+	// Mode is the entry's type and permission bits. Only fs.ModeDir and the
+	// permission bits are meaningful; other bits are ignored.
+	Mode fs.FileMode
+
+	// Size is the size of a regular file in bytes.
+	// It is zero for directories.
+	Size int64 `json:",omitempty"`
+
+	// SHA256 is the hex-encoded SHA-256 of a regular file's contents.
+	// It is empty for directories.
+	SHA256 string `json:",omitempty"`
+}
+
+// FileTreeResult is the result of sending a directory tree with Taildrop.
+type FileTreeResult struct {
+	// Name is the name the tree was given on the receiver. It differs from
+	// the sent name if the receiver already had something by that name.
+	Name string
+
+	// Files and Bytes are the number of regular files in the tree and their
+	// total size.
+	Files int
+	Bytes int64
+
+	// Resumed is how many of the files were already present on the receiver
+	// from an earlier, interrupted attempt and so weren't sent again.
+	Resumed int
+
+	// Verified reports whether the receiver checked every file against its
+	// size and SHA-256 in the manifest before making the tree visible.
+	Verified bool
 }
 
 // SetPushDeviceTokenRequest is the body POSTed to the LocalAPI endpoint /set-device-token.
@@ -0,0 +1,712 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/tailscale/hujson"
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+	"tailscale.com/client/local"
+	"tailscale.com/ipn"
+)
+
+// configFileVersion is the only supported version of the config file format.
+const configFileVersion = "alpha0"
+
+// configFileEnvVars are the environment variables that configure settings
+// that the config file also configures. They can't be set if TS_CONFIG_FILE
+// is set.
+var configFileEnvVars = []string{
+	"TS_AUTHKEY",
+	"TS_AUTH_KEY",
+	"TS_CLIENT_ID",
+	"TS_CLIENT_SECRET",
+	"TS_ID_TOKEN",
+	"TS_AUDIENCE",
+	"TS_AUTH_ONCE",
+	"TS_HOSTNAME",
+	"TS_ROUTES",
+	"TS_ACCEPT_DNS",
+	"TS_USERSPACE",
+	"TS_STATE_DIR",
+	"TS_KUBE_SECRET",
+	"TS_SOCKS5_SERVER",
+	"TS_OUTBOUND_HTTP_PROXY_LISTEN",
+	"TS_SERVE_CONFIG",
+	"TS_LOCAL_ADDR_PORT",
+	"TS_ENABLE_METRICS",
+	"TS_ENABLE_HEALTH_CHECK",
+	"TS_HEALTHCHECK_ADDR_PORT",
+	"TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR",
+}
+
+// configFile is the containerboot config file, an alternative to configuring
+// containerboot with environment variables. It is HuJSON (JSON with comments
+// and trailing commas), or YAML if the file name ends in .yaml or .yml.
+//
+// The hostname, routes, DNS settings, serve endpoints and egress targets are
+// reloaded when the file changes. Changes to any other settings require a
+// restart of the container.
+type configFile struct {
+	// Version is the version of the config file format. It must be
+	// "alpha0".
+	Version string `json:"version"`
+
+	// Hostname is the hostname to request for the node.
+	Hostname string `json:"hostname,omitempty"`
+	// Auth configures how the node logs in.
+	Auth configFileAuth `json:"auth"`
+	// Routes are the subnet routes to advertise. An explicitly empty list
+	// stops advertising any previously advertised routes.
+	Routes []string `json:"routes,omitempty"`
+	// AcceptDNS is whether to use the tailnet's DNS configuration.
+	AcceptDNS *bool `json:"acceptDNS,omitempty"`
+	// Userspace is whether to run with userspace networking instead of
+	// kernel networking. Defaults to true.
+	Userspace *bool `json:"userspace,omitempty"`
+	// State configures where tailscaled stores its state.
+	State configFileState `json:"state"`
+	// SOCKS5Server is the address on which to listen for SOCKS5 proxying
+	// into the tailnet.
+	SOCKS5Server string `json:"socks5Server,omitempty"`
+	// OutboundHTTPProxyListen is the address on which to listen for HTTP
+	// proxying into the tailnet.
+	OutboundHTTPProxyListen string `json:"outboundHTTPProxyListen,omitempty"`
+	// Monitoring configures the local health check and metrics endpoints.
+	Monitoring configFileMonitoring `json:"monitoring"`
+	// Serve are the endpoints to serve on the tailnet, and optionally on
+	// the internet with Funnel.
+	Serve []serveEndpoint `json:"serve,omitempty"`
+	// Egress are the tailnet targets to forward local connections to.
+	Egress []egressTarget `json:"egress,omitempty"`
+}
+
+type configFileAuth struct {
+	// AuthKey is the auth key to log in with. If it begins with "file:",
+	// it is the path to a file that contains the key.
+	AuthKey string `json:"authKey,omitempty"`
+	// ClientID is the OAuth client ID to log in with.
+	ClientID string `json:"clientID,omitempty"`
+	// ClientSecret is the OAuth client secret to generate auth keys
+	// with. If it begins with "file:", it is the path to a file that
+	// contains the secret.
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// IDToken is the ID token for workload identity federation. If it
+	// begins with "file:", it is the path to a file that contains the
+	// token.
+	IDToken string `json:"idToken,omitempty"`
+	// Audience is the audience of the ID token to request from a
+	// well-known identity provider for workload identity federation.
+	Audience string `json:"audience,omitempty"`
+	// Once is whether to only log in if the node is not already logged in.
+	Once bool `json:"once,omitempty"`
+}
+
+type configFileState struct {
+	// Dir is the directory in which tailscaled stores its state. It
+	// should be persistent storage.
+	Dir string `json:"dir,omitempty"`
+	// KubeSecret is the name of the Kubernetes Secret in which to store
+	// state when running on Kubernetes. Defaults to "tailscale". Set it
+	// to an empty string to store state in Dir instead.
+	KubeSecret *string `json:"kubeSecret,omitempty"`
+}
+
+type configFileMonitoring struct {
+	// AddrPort is the address and port on which to serve the health
+	// check and metrics endpoints. Defaults to [::]:9002.
+	AddrPort string `json:"addrPort,omitempty"`
+	// HealthCheck is whether to serve a health check endpoint at /healthz.
+	HealthCheck bool `json:"healthCheck,omitempty"`
+	// Metrics is whether to serve a metrics endpoint at /metrics.
+	Metrics bool `json:"metrics,omitempty"`
+}
+
+// serveEndpoint is an endpoint that the node serves on the tailnet.
+type serveEndpoint struct {
+	// Port is the port to serve on.
+	Port uint16 `json:"port"`
+	// Protocol is one of "https" (the default), "http", "tcp" or
+	// "tls-terminated-tcp".
+	Protocol string `json:"protocol,omitempty"`
+	// Path is the path to mount HTTP and HTTPS endpoints at. Defaults to
+	// "/".
+	Path string `json:"path,omitempty"`
+	// Target is the port, address or URL to proxy connections to, e.g.
+	// "3000", "127.0.0.1:3000" or "http://127.0.0.1:3000/api". Targets
+	// on other hosts must include a scheme, e.g. "tcp://db:5432".
+	Target string `json:"target"`
+	// Funnel is whether to also serve the endpoint on the internet. It is
+	// only supported for HTTPS endpoints on ports 443, 8443 and 10000.
+	Funnel bool `json:"funnel,omitempty"`
+}
+
+// egressTarget is a tailnet target that connections to a local address are
+// forwarded to.
+type egressTarget struct {
+	// Listen is the local address to accept TCP connections on, e.g.
+	// ":5432".
+	Listen string `json:"listen"`
+	// Target is the tailnet host and port to forward connections to. The
+	// host is a MagicDNS name or a tailnet IP address, e.g.
+	// "db.tails-scales.ts.net:5432".
+	Target string `json:"target"`
+}
+
+// loadConfigFile reads, parses and validates the config file at path. Errors
+// are reported with the line and column in the file that they refer to.
+func loadConfigFile(path string) (*configFile, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	l := &configFileLocator{path: path, raw: raw}
+	var doc []byte
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		if doc, err = yaml.YAMLToJSON(raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		l.yamlRoot = new(yamlv3.Node)
+		if err := yamlv3.Unmarshal(raw, l.yamlRoot); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		// Offsets in errors from decoding doc are offsets into the
+		// JSON, not into the YAML.
+		v, err := hujson.Parse(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		l.yamlJSON = &v
+	default:
+		v, err := hujson.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		l.root = &v
+		// Standardizing replaces comments and trailing commas with
+		// whitespace, so offsets into doc are also offsets into raw.
+		std := v.Clone()
+		std.Standardize()
+		doc = std.Pack()
+	}
+
+	c := new(configFile)
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		var (
+			se *json.SyntaxError
+			te *json.UnmarshalTypeError
+		)
+		switch {
+		case errors.As(err, &se):
+			return nil, fmt.Errorf("%s: %v", l.posAtOffset(se.Offset), se)
+		case errors.As(err, &te):
+			return nil, fmt.Errorf("%s: %s: cannot use JSON %s as %s", l.posAtOffset(te.Offset), te.Field, te.Value, te.Type)
+		default:
+			return nil, fmt.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "json: "))
+		}
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("%s: trailing data after config", path)
+	}
+	if err := c.validate(l); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// configFileLocator reports positions in a config file.
+type configFileLocator struct {
+	path string
+	raw  []byte
+	root *hujson.Value // nil for YAML files
+
+	// For YAML files, yamlRoot is the parsed file and yamlJSON is the
+	// file converted to JSON.
+	yamlRoot *yamlv3.Node
+	yamlJSON *hujson.Value
+}
+
+// posAtOffset returns the file name, line and column of the byte offset in
+// the config file. For YAML files, off is an offset into the file converted
+// to JSON.
+func (l *configFileLocator) posAtOffset(off int64) string {
+	if l.yamlRoot != nil {
+		return l.yamlPos(jsonPointerAtOffset(l.yamlJSON, "", int(off)))
+	}
+	if l.root == nil || off < 0 || off > int64(len(l.raw)) {
+		return l.path
+	}
+	b := l.raw[:off]
+	line := 1 + bytes.Count(b, []byte("\n"))
+	col := 1 + len(b) - (bytes.LastIndexByte(b, '\n') + 1)
+	return fmt.Sprintf("%s:%d:%d", l.path, line, col)
+}
+
+// errorf returns an error for the value at the JSON pointer ptr in the config
+// file, e.g. "/serve/0/port".
+func (l *configFileLocator) errorf(ptr string, format string, args ...any) error {
+	pos := l.path
+	switch {
+	case l.root != nil:
+		// Fall back to the closest parent that exists, e.g. the
+		// object that a required field is missing from.
+		for p := ptr; ; p = p[:strings.LastIndexByte(p, '/')] {
+			if v := l.root.Find(p); v != nil {
+				pos = l.posAtOffset(int64(v.StartOffset))
+				break
+			}
+			if p == "" {
+				break
+			}
+		}
+	case l.yamlRoot != nil:
+		pos = l.yamlPos(ptr)
+	}
+	return fmt.Errorf("%s: %s: %s", pos, fieldName(ptr), fmt.Sprintf(format, args...))
+}
+
+// yamlPos returns the file name, line and column of the YAML node at the JSON
+// pointer ptr, or of its closest parent that exists.
+func (l *configFileLocator) yamlPos(ptr string) string {
+	n := l.yamlRoot
+	if n.Kind == yamlv3.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	for p := range strings.SplitSeq(strings.TrimPrefix(ptr, "/"), "/") {
+		if p == "" {
+			break
+		}
+		var next *yamlv3.Node
+		switch n.Kind {
+		case yamlv3.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == p {
+					next = n.Content[i+1]
+					break
+				}
+			}
+		case yamlv3.SequenceNode:
+			if i, err := strconv.Atoi(p); err == nil && i >= 0 && i < len(n.Content) {
+				next = n.Content[i]
+			}
+		}
+		if next == nil {
+			break
+		}
+		n = next
+	}
+	if n.Line == 0 {
+		return l.path
+	}
+	return fmt.Sprintf("%s:%d:%d", l.path, n.Line, n.Column)
+}
+
+// jsonPointerAtOffset returns the JSON pointer of the innermost value in v
+// that contains the byte offset off, where ptr is the pointer of v.
+func jsonPointerAtOffset(v *hujson.Value, ptr string, off int) string {
+	switch vt := v.Value.(type) {
+	case *hujson.Object:
+		for i := range vt.Members {
+			m := &vt.Members[i]
+			if m.Value.StartOffset <= off && off <= m.Value.EndOffset {
+				return jsonPointerAtOffset(&m.Value, ptr+"/"+m.Name.Value.(hujson.Literal).String(), off)
+			}
+		}
+	case *hujson.Array:
+		for i := range vt.Elements {
+			e := &vt.Elements[i]
+			if e.StartOffset <= off && off <= e.EndOffset {
+				return jsonPointerAtOffset(e, ptr+"/"+strconv.Itoa(i), off)
+			}
+		}
+	}
+	return ptr
+}
+
+// fieldName returns the field referred to by the JSON pointer ptr in the style
+// of a Go expression, e.g. "serve[0].port" for "/serve/0/port".
+func fieldName(ptr string) string {
+	var sb strings.Builder
+	for _, p := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		if _, err := strconv.Atoi(p); err == nil {
+			fmt.Fprintf(&sb, "[%s]", p)
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(p)
+	}
+	return sb.String()
+}
+
+// validate validates c, returning all errors found.
+func (c *configFile) validate(l *configFileLocator) error {
+	var errs []error
+	errorf := func(ptr, format string, args ...any) {
+		errs = append(errs, l.errorf(ptr, format, args...))
+	}
+
+	if c.Version != configFileVersion {
+		errorf("/version", "unsupported version %q, want %q", c.Version, configFileVersion)
+	}
+
+	a := c.Auth
+	switch {
+	case a.AuthKey != "" && (a.ClientID != "" || a.ClientSecret != "" || a.IDToken != "" || a.Audience != ""):
+		errorf("/auth/authKey", "cannot be used with clientID, clientSecret, idToken or audience")
+	case a.IDToken != "" && a.ClientID == "":
+		errorf("/auth/idToken", "requires clientID")
+	case a.Audience != "" && a.ClientID == "":
+		errorf("/auth/audience", "requires clientID")
+	case a.IDToken != "" && a.ClientSecret != "":
+		errorf("/auth/idToken", "cannot be used with clientSecret")
+	case a.IDToken != "" && a.Audience != "":
+		errorf("/auth/idToken", "cannot be used with audience")
+	case a.Audience != "" && a.ClientSecret != "":
+		errorf("/auth/audience", "cannot be used with clientSecret")
+	}
+
+	for i, r := range c.Routes {
+		if _, err := netip.ParsePrefix(r); err != nil {
+			errorf(fmt.Sprintf("/routes/%d", i), "invalid route: %v", err)
+		}
+	}
+	for _, f := range []struct{ ptr, addr string }{
+		{"/socks5Server", c.SOCKS5Server},
+		{"/outboundHTTPProxyListen", c.OutboundHTTPProxyListen},
+		{"/monitoring/addrPort", c.Monitoring.AddrPort},
+	} {
+		if f.addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(f.addr); err != nil {
+			errorf(f.ptr, "invalid address: %v", err)
+		}
+	}
+
+	type portPath struct {
+		port uint16
+		path string
+	}
+	protocols := make(map[uint16]string)
+	paths := make(map[portPath]bool)
+	for i, e := range c.Serve {
+		ptr := fmt.Sprintf("/serve/%d", i)
+		if e.Port == 0 {
+			errorf(ptr+"/port", "must be set")
+			continue
+		}
+		proto := e.protocol()
+		if prev, ok := protocols[e.Port]; ok && prev != proto {
+			errorf(ptr+"/protocol", "port %d is already served with protocol %q", e.Port, prev)
+			continue
+		}
+		protocols[e.Port] = proto
+		switch proto {
+		case "http", "https":
+			p := e.path()
+			if !strings.HasPrefix(p, "/") {
+				errorf(ptr+"/path", "must start with /")
+			} else if paths[portPath{e.Port, p}] {
+				errorf(ptr+"/path", "path %q is already served on port %d", p, e.Port)
+			}
+			paths[portPath{e.Port, p}] = true
+		case "tcp", "tls-terminated-tcp":
+			if e.Path != "" {
+				errorf(ptr+"/path", "is only supported for http and https endpoints")
+			}
+			if paths[portPath{e.Port, ""}] {
+				errorf(ptr+"/port", "port %d is already served", e.Port)
+			}
+			paths[portPath{e.Port, ""}] = true
+		default:
+			errorf(ptr+"/protocol", "unsupported protocol %q, want one of https, http, tcp or tls-terminated-tcp", proto)
+			continue
+		}
+		if _, err := e.proxyTarget(); err != nil {
+			errorf(ptr+"/target", "invalid target: %v", err)
+		}
+		if e.Funnel {
+			if proto != "https" {
+				errorf(ptr+"/funnel", "is only supported for https endpoints")
+			} else if !slices.Contains([]uint16{443, 8443, 10000}, e.Port) {
+				errorf(ptr+"/funnel", "is only supported on ports 443, 8443 and 10000")
+			}
+		}
+	}
+
+	listens := make(map[string]bool)
+	for i, e := range c.Egress {
+		ptr := fmt.Sprintf("/egress/%d", i)
+		if _, port, err := net.SplitHostPort(e.Listen); err != nil {
+			errorf(ptr+"/listen", "invalid address: %v", err)
+		} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			errorf(ptr+"/listen", "invalid port %q", port)
+		} else if listens[e.Listen] {
+			errorf(ptr+"/listen", "%s is already used by another egress target", e.Listen)
+		}
+		listens[e.Listen] = true
+		if _, _, err := e.hostPort(); err != nil {
+			errorf(ptr+"/target", "%v", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyTo sets the settings in s that are configured by c.
+func (c *configFile) applyTo(s *settings) {
+	s.Hostname = c.Hostname
+	s.AuthKey = c.Auth.AuthKey
+	s.ClientID = c.Auth.ClientID
+	s.ClientSecret = c.Auth.ClientSecret
+	s.IDToken = c.Auth.IDToken
+	s.Audience = c.Auth.Audience
+	s.AuthOnce = c.Auth.Once
+	s.Routes = nil
+	if c.Routes != nil {
+		routes := strings.Join(c.Routes, ",")
+		s.Routes = &routes
+	}
+	s.AcceptDNS = c.AcceptDNS
+	if c.Userspace != nil {
+		s.UserspaceMode = *c.Userspace
+	}
+	s.StateDir = c.State.Dir
+	if c.State.KubeSecret != nil {
+		s.KubeSecret = *c.State.KubeSecret
+	}
+	s.SOCKSProxyAddr = c.SOCKS5Server
+	s.HTTPProxyAddr = c.OutboundHTTPProxyListen
+	if c.Monitoring.AddrPort != "" {
+		s.LocalAddrPort = c.Monitoring.AddrPort
+	}
+	s.HealthCheckEnabled = c.Monitoring.HealthCheck
+	s.MetricsEnabled = c.Monitoring.Metrics
+	s.ConfigFile = c
+}
+
+// needsRestart reports whether changing the config file from c to n changes
+// any settings that are only applied when containerboot starts.
+func (c *configFile) needsRestart(n *configFile) bool {
+	a, b := *c, *n
+	for _, x := range []*configFile{&a, &b} {
+		x.Hostname, x.Routes, x.AcceptDNS, x.Serve, x.Egress = "", nil, nil, nil, nil
+	}
+	return !reflect.DeepEqual(a, b)
+}
+
+// upSettingsChanged reports whether changing the config file from c to n
+// changes any settings that are applied with 'tailscale set'.
+func (c *configFile) upSettingsChanged(n *configFile) bool {
+	return c.Hostname != n.Hostname ||
+		!reflect.DeepEqual(c.Routes, n.Routes) ||
+		!reflect.DeepEqual(c.AcceptDNS, n.AcceptDNS)
+}
+
+// serveConfig returns the serve config for c's serve endpoints on a node
+// with the given cert domain. It returns nil if c has no serve endpoints.
+func (c *configFile) serveConfig(certDomain string) *ipn.ServeConfig {
+	if len(c.Serve) == 0 {
+		return nil
+	}
+	sc := new(ipn.ServeConfig)
+	for _, e := range c.Serve {
+		target, err := e.proxyTarget()
+		if err != nil {
+			// Validated when the config file was loaded.
+			log.Printf("config file: [unexpected] invalid serve target %q: %v", e.Target, err)
+			continue
+		}
+		switch proto := e.protocol(); proto {
+		case "http", "https":
+			sc.SetWebHandler(&ipn.HTTPHandler{Proxy: target}, certDomain, e.Port, e.path(), proto == "https", "")
+		case "tcp", "tls-terminated-tcp":
+			sc.SetTCPForwarding(e.Port, target, proto == "tls-terminated-tcp", 0, certDomain)
+		}
+		if e.Funnel {
+			sc.SetFunnel(certDomain, e.Port, true)
+		}
+	}
+	return sc
+}
+
+func (e serveEndpoint) protocol() string {
+	return cmp.Or(e.Protocol, "https")
+}
+
+func (e serveEndpoint) path() string {
+	return cmp.Or(e.Path, "/")
+}
+
+// proxyTarget returns the expanded target of e, which is a URL for HTTP and
+// HTTPS endpoints and a host and port for TCP endpoints.
+func (e serveEndpoint) proxyTarget() (string, error) {
+	switch e.protocol() {
+	case "http", "https":
+		return ipn.ExpandProxyTargetValue(e.Target, []string{"http", "https", "https+insecure"}, "http")
+	default:
+		t, err := ipn.ExpandProxyTargetValue(e.Target, []string{"tcp"}, "tcp")
+		if err != nil {
+			return "", err
+		}
+		u, err := url.Parse(t)
+		if err != nil {
+			return "", err
+		}
+		return u.Host, nil
+	}
+}
+
+// hostPort returns the host and port of e's target.
+func (e egressTarget) hostPort() (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(e.Target)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid target: %v", err)
+	}
+	if host == "" {
+		return "", 0, errors.New("target must include a host")
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return "", 0, fmt.Errorf("invalid target port %q", portStr)
+	}
+	return host, uint16(port), nil
+}
+
+// configFileTickInterval is how often the config file is re-read when it
+// can't be watched with fsnotify.
+var configFileTickInterval = 5 * time.Second
+
+// watchConfigFileChanges applies the serve endpoints and egress targets of
+// the config file, and re-applies them along with the hostname, routes and DNS
+// settings whenever the file changes. Invalid changes are logged and ignored.
+// Serve endpoints are also re-applied when the cert domain changes, which is
+// signaled on cdChanged. Errors applying the initial config are sent to errCh.
+func watchConfigFileChanges(ctx context.Context, cdChanged <-chan bool, certDomainAtomic *atomic.Pointer[string], lc *local.Client, ef *egressForwarder, cfg *settings, errCh chan<- error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		// Creating a new fsnotify watcher would fail for example if inotify was not able to create a new file descriptor.
+		// See https://github.com/tailscale/tailscale/issues/15081
+		log.Printf("config file: failed to create fsnotify watcher, timer-only mode: %v", err)
+	} else {
+		defer w.Close()
+		if err := w.Add(filepath.Dir(cfg.ConfigFilePath)); err != nil {
+			errCh <- fmt.Errorf("failed to add fsnotify watch: %w", err)
+			return
+		}
+	}
+	runConfigFileWatcher(ctx, w, cdChanged, certDomainAtomic, lc, ef, cfg, errCh)
+}
+
+// runConfigFileWatcher implements watchConfigFileChanges. It re-reads the
+// config file on events from w, or every configFileTickInterval if w is nil.
+func runConfigFileWatcher(ctx context.Context, w *fsnotify.Watcher, cdChanged <-chan bool, certDomainAtomic *atomic.Pointer[string], lc *local.Client, ef *egressForwarder, cfg *settings, errCh chan<- error) {
+	var tickChan <-chan time.Time
+	var eventChan <-chan fsnotify.Event
+	if w == nil {
+		ticker := time.NewTicker(configFileTickInterval)
+		defer ticker.Stop()
+		tickChan = ticker.C
+	} else {
+		eventChan = w.Events
+	}
+
+	c := cfg.ConfigFile
+	if err := ef.set(c.Egress); err != nil {
+		errCh <- fmt.Errorf("error configuring egress targets: %w", err)
+		return
+	}
+	prevRaw, _ := os.ReadFile(cfg.ConfigFilePath)
+	// reload re-reads the config file and applies it if it has changed.
+	reload := func() {
+		raw, err := os.ReadFile(cfg.ConfigFilePath)
+		if err != nil {
+			log.Printf("config file: error reading config file, keeping previous config: %v", err)
+			return
+		}
+		if bytes.Equal(raw, prevRaw) {
+			return
+		}
+		prevRaw = raw
+		n, err := loadConfigFile(cfg.ConfigFilePath)
+		if err != nil {
+			log.Printf("config file: ignoring invalid config file: %v", err)
+			return
+		}
+		log.Printf("config file: applying changes")
+		if c.needsRestart(n) {
+			log.Printf("config file: [warning] some changed settings are only applied when the container restarts")
+		}
+		if c.upSettingsChanged(n) {
+			newCfg := *cfg
+			n.applyTo(&newCfg)
+			if err := tailscaleSet(ctx, &newCfg); err != nil {
+				log.Printf("config file: error applying settings: %v", err)
+			}
+		}
+		if err := ef.set(n.Egress); err != nil {
+			log.Printf("config file: error configuring egress targets: %v", err)
+		}
+		c = n
+	}
+	var certDomain string
+	var prevServeConfig *ipn.ServeConfig
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cdChanged:
+			certDomain = *certDomainAtomic.Load()
+		case <-tickChan:
+			reload()
+		case <-eventChan:
+			// Mounted files may be updated via symlinks, so re-read the
+			// file on any event in its directory.
+			reload()
+		}
+		if certDomain == "" {
+			// Wait for the cert domain to be known before configuring
+			// serve.
+			continue
+		}
+		sc := c.serveConfig(certDomain)
+		if sc == nil {
+			sc = new(ipn.ServeConfig)
+		}
+		if prevServeConfig != nil && reflect.DeepEqual(sc, prevServeConfig) {
+			continue
+		}
+		if err := updateServeConfig(ctx, sc, certDomain, lc); err != nil {
+			log.Printf("config file: error updating serve config: %v", err)
+			continue
+		}
+		prevServeConfig = sc
+	}
+}
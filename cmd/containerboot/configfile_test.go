@@ -0,0 +1,341 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/ipn"
+	"tailscale.com/types/ptr"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		want     *configFile
+		wantErrs []string
+	}{
+		{
+			name: "hujson",
+			file: "config.hujson",
+			contents: `{
+	// A comment.
+	"version": "alpha0",
+	"hostname": "web",
+	"auth": {"authKey": "tskey-key"},
+	"routes": [],
+	"serve": [
+		{"port": 443, "target": "8080", "funnel": true},
+		{"port": 443, "path": "/api", "target": "http://127.0.0.1:9090/api"},
+		{"port": 22, "protocol": "tcp", "target": "22"},
+	],
+	"egress": [{"listen": ":5432", "target": "db.tails-scales.ts.net:5432"}],
+}`,
+			want: &configFile{
+				Version:  "alpha0",
+				Hostname: "web",
+				Auth:     configFileAuth{AuthKey: "tskey-key"},
+				Routes:   []string{},
+				Serve: []serveEndpoint{
+					{Port: 443, Target: "8080", Funnel: true},
+					{Port: 443, Path: "/api", Target: "http://127.0.0.1:9090/api"},
+					{Port: 22, Protocol: "tcp", Target: "22"},
+				},
+				Egress: []egressTarget{{Listen: ":5432", Target: "db.tails-scales.ts.net:5432"}},
+			},
+		},
+		{
+			name: "yaml",
+			file: "config.yaml",
+			contents: `version: alpha0
+acceptDNS: true
+state:
+  dir: /var/lib/tailscale
+  kubeSecret: ""
+monitoring:
+  metrics: true
+`,
+			want: &configFile{
+				Version:    "alpha0",
+				AcceptDNS:  ptr.To(true),
+				State:      configFileState{Dir: "/var/lib/tailscale", KubeSecret: ptr.To("")},
+				Monitoring: configFileMonitoring{Metrics: true},
+			},
+		},
+		{
+			name: "hujson_syntax_error",
+			file: "config.hujson",
+			contents: `{
+	"version": "alpha0"
+	"hostname": "web",
+}`,
+			wantErrs: []string{"config.hujson: hujson: line 3, column 2: "},
+		},
+		{
+			name: "wrong_type",
+			file: "config.hujson",
+			contents: `{
+	"version": "alpha0",
+	"serve": [
+		{"port": 70000, "target": "8080"},
+	],
+}`,
+			wantErrs: []string{"config.hujson:4:17: serve.port: cannot use JSON number 70000 as uint16"},
+		},
+		{
+			name:     "unknown_field",
+			file:     "config.hujson",
+			contents: `{"version": "alpha0", "hostnmae": "web"}`,
+			wantErrs: []string{`config.hujson: unknown field "hostnmae"`},
+		},
+		{
+			name: "invalid",
+			file: "config.hujson",
+			contents: `{
+	"version": "alpha1",
+	"auth": {
+		"authKey": "tskey-key",
+		"clientID": "id",
+	},
+	"routes": ["10.0.0.0/24", "bad"],
+	"serve": [
+		{"port": 443, "target": "8080"},
+		{"port": 443, "protocol": "tcp", "target": "22"},
+		{"port": 80, "protocol": "http", "target": "ftp://foo", "funnel": true},
+		{"port": 8080, "protocol": "udp", "target": "80"},
+		{"target": "80"},
+	],
+	"egress": [
+		{"listen": ":5432", "target": "db:5432"},
+		{"listen": ":5432", "target": ":5432"},
+	],
+}`,
+			wantErrs: []string{
+				`config.hujson:2:13: version: unsupported version "alpha1", want "alpha0"`,
+				`config.hujson:4:14: auth.authKey: cannot be used with clientID, clientSecret, idToken or audience`,
+				`config.hujson:7:28: routes[1]: invalid route: `,
+				`config.hujson:10:29: serve[1].protocol: port 443 is already served with protocol "https"`,
+				`config.hujson:11:46: serve[2].target: invalid target: `,
+				`config.hujson:11:69: serve[2].funnel: is only supported for https endpoints`,
+				`config.hujson:12:30: serve[3].protocol: unsupported protocol "udp"`,
+				`config.hujson:13:3: serve[4].port: must be set`,
+				`config.hujson:17:14: egress[1].listen: :5432 is already used by another egress target`,
+				`config.hujson:17:33: egress[1].target: target must include a host`,
+			},
+		},
+		{
+			name:     "invalid_yaml",
+			file:     "config.yaml",
+			contents: `version: alpha0
+routes: [bad]
+serve:
+  - port: 443
+    target: "8080"
+  - target: "80"
+`,
+			wantErrs: []string{
+				`config.yaml:2:10: routes[0]: invalid route: `,
+				`config.yaml:6:5: serve[1].port: must be set`,
+			},
+		},
+		{
+			name: "yaml_wrong_type",
+			file: "config.yaml",
+			contents: `version: alpha0
+serve:
+  - port: 70000
+    target: "8080"
+`,
+			wantErrs: []string{"config.yaml:3:11: serve.port: cannot use JSON number 70000 as uint16"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadConfigFile(filepath.Join(dir, tt.file))
+			if len(tt.wantErrs) > 0 {
+				if err == nil {
+					t.Fatalf("got no error, want %q", tt.wantErrs)
+				}
+				gotErrs := strings.Split(strings.ReplaceAll(err.Error(), dir+"/", ""), "\n")
+				if len(gotErrs) != len(tt.wantErrs) {
+					t.Fatalf("got %d errors, want %d:\n%s", len(gotErrs), len(tt.wantErrs), strings.Join(gotErrs, "\n"))
+				}
+				for i, want := range tt.wantErrs {
+					if !strings.HasPrefix(gotErrs[i], want) {
+						t.Errorf("error %d = %q, want prefix %q", i, gotErrs[i], want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("unexpected config (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConfigFileApplyTo(t *testing.T) {
+	c := &configFile{
+		Version:    "alpha0",
+		Hostname:   "web",
+		Auth:       configFileAuth{ClientID: "id", ClientSecret: "file:/secret", Once: true},
+		Routes:     []string{"10.0.0.0/24", "10.1.0.0/24"},
+		Userspace:  ptr.To(false),
+		State:      configFileState{Dir: "/var/lib/tailscale", KubeSecret: ptr.To("")},
+		Monitoring: configFileMonitoring{HealthCheck: true},
+	}
+	s := &settings{
+		UserspaceMode: true,
+		KubeSecret:    "tailscale",
+		LocalAddrPort: "[::]:9002",
+	}
+	c.applyTo(s)
+	want := &settings{
+		Hostname:           "web",
+		ClientID:           "id",
+		ClientSecret:       "file:/secret",
+		AuthOnce:           true,
+		Routes:             ptr.To("10.0.0.0/24,10.1.0.0/24"),
+		StateDir:           "/var/lib/tailscale",
+		LocalAddrPort:      "[::]:9002",
+		HealthCheckEnabled: true,
+		ConfigFile:         c,
+	}
+	if diff := cmp.Diff(s, want, cmp.AllowUnexported(settings{})); diff != "" {
+		t.Errorf("unexpected settings (-got +want):\n%s", diff)
+	}
+
+	n := *c
+	n.Hostname = "web2"
+	n.Serve = []serveEndpoint{{Port: 443, Target: "8080"}}
+	if c.needsRestart(&n) {
+		t.Errorf("needsRestart = true for hostname and serve changes")
+	}
+	if !c.upSettingsChanged(&n) {
+		t.Errorf("upSettingsChanged = false for hostname change")
+	}
+	n.Auth.AuthKey = "tskey-key"
+	if !c.needsRestart(&n) {
+		t.Errorf("needsRestart = false for auth change")
+	}
+}
+
+func TestConfigFileServeConfig(t *testing.T) {
+	c := &configFile{
+		Serve: []serveEndpoint{
+			{Port: 443, Target: "8080", Funnel: true},
+			{Port: 443, Path: "/api", Target: "https+insecure://127.0.0.1:9090"},
+			{Port: 80, Protocol: "http", Target: "127.0.0.1:8080"},
+			{Port: 22, Protocol: "tcp", Target: "22"},
+			{Port: 5432, Protocol: "tls-terminated-tcp", Target: "tcp://db:5432"},
+		},
+	}
+	const cd = "web.tails-scales.ts.net"
+	want := &ipn.ServeConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{
+			443:  {HTTPS: true},
+			80:   {HTTP: true},
+			22:   {TCPForward: "127.0.0.1:22"},
+			5432: {TCPForward: "db:5432", TerminateTLS: cd},
+		},
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			cd + ":443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/":    {Proxy: "http://127.0.0.1:8080"},
+				"/api": {Proxy: "https+insecure://127.0.0.1:9090"},
+			}},
+			cd + ":80": {Handlers: map[string]*ipn.HTTPHandler{
+				"/": {Proxy: "http://127.0.0.1:8080"},
+			}},
+		},
+		AllowFunnel: map[ipn.HostPort]bool{cd + ":443": true},
+	}
+	if diff := cmp.Diff(c.serveConfig(cd), want); diff != "" {
+		t.Errorf("unexpected serve config (-got +want):\n%s", diff)
+	}
+	if sc := (&configFile{}).serveConfig(cd); sc != nil {
+		t.Errorf("got serve config %v for no serve endpoints, want nil", sc)
+	}
+}
+
+func TestWatchConfigFileChangesTimerMode(t *testing.T) {
+	defer func(d time.Duration) { configFileTickInterval = d }(configFileTickInterval)
+	configFileTickInterval = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "config.hujson")
+	writeConfig := func(target string) {
+		t.Helper()
+		contents := fmt.Sprintf(`{"version": "alpha0", "egress": [{"listen": "127.0.0.1:0", "target": %q}]}`, target)
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("a.tails-scales.ts.net:80")
+	c, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ef := &egressForwarder{}
+	defer ef.close()
+	cfg := &settings{ConfigFilePath: path, ConfigFile: c}
+	errCh := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// A nil watcher makes it fall back to re-reading the file on a
+		// timer.
+		runConfigFileWatcher(ctx, nil, nil, new(atomic.Pointer[string]), nil, ef, cfg, errCh)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	target := func() string {
+		ef.mu.Lock()
+		defer ef.mu.Unlock()
+		if l, ok := ef.listeners["127.0.0.1:0"]; ok {
+			return l.target.Load().Target
+		}
+		return ""
+	}
+	waitForTarget := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			select {
+			case err := <-errCh:
+				t.Fatal(err)
+			default:
+			}
+			if target() == want {
+				return
+			}
+		}
+		t.Fatalf("egress target = %q, want %q", target(), want)
+	}
+	waitForTarget("a.tails-scales.ts.net:80")
+	writeConfig("b.tails-scales.ts.net:80")
+	waitForTarget("b.tails-scales.ts.net:80")
+}
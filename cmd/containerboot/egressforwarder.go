@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"tailscale.com/syncs"
+	"tailscale.com/util/mak"
+)
+
+// tailnetDialer dials TCP connections to tailnet hosts. It is implemented by
+// [local.Client].
+type tailnetDialer interface {
+	DialTCP(ctx context.Context, host string, port uint16) (net.Conn, error)
+}
+
+// egressForwarder forwards TCP connections accepted on local addresses to
+// the egress targets of the config file. Connections are dialed via
+// tailscaled, so unlike the egress proxies configured with
+// TS_TAILNET_TARGET_IP or TS_TAILNET_TARGET_FQDN, this also works in
+// userspace networking mode.
+type egressForwarder struct {
+	dialer tailnetDialer
+
+	mu        sync.Mutex                 // protects following
+	listeners map[string]*egressListener // keyed by listen address
+}
+
+type egressListener struct {
+	ln     net.Listener
+	target syncs.AtomicValue[egressTarget]
+}
+
+// set starts and stops listeners so that connections are forwarded for
+// exactly the given targets. The target of an existing listener is updated in
+// place, which affects new connections only.
+func (ef *egressForwarder) set(targets []egressTarget) error {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	want := make(map[string]egressTarget)
+	for _, t := range targets {
+		want[t.Listen] = t
+	}
+	for addr, l := range ef.listeners {
+		if _, ok := want[addr]; !ok {
+			log.Printf("egress: no longer forwarding connections on %s", addr)
+			l.ln.Close()
+			delete(ef.listeners, addr)
+		}
+	}
+	var errs []error
+	for _, t := range targets {
+		if l, ok := ef.listeners[t.Listen]; ok {
+			if old := l.target.Swap(t); old != t {
+				log.Printf("egress: forwarding connections on %s to %s", t.Listen, t.Target)
+			}
+			continue
+		}
+		ln, err := net.Listen("tcp", t.Listen)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error listening on %s: %w", t.Listen, err))
+			continue
+		}
+		l := &egressListener{ln: ln}
+		l.target.Store(t)
+		mak.Set(&ef.listeners, t.Listen, l)
+		log.Printf("egress: forwarding connections on %s to %s", t.Listen, t.Target)
+		go ef.serve(l)
+	}
+	return errors.Join(errs...)
+}
+
+// close stops all listeners.
+func (ef *egressForwarder) close() {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	for addr, l := range ef.listeners {
+		l.ln.Close()
+		delete(ef.listeners, addr)
+	}
+}
+
+func (ef *egressForwarder) serve(l *egressListener) {
+	for {
+		c, err := l.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("egress: error accepting connections on %s: %v", l.ln.Addr(), err)
+			}
+			return
+		}
+		go ef.forward(c, l.target.Load())
+	}
+}
+
+// forward copies data between c and a new connection to t's target until
+// either side closes its connection.
+func (ef *egressForwarder) forward(c net.Conn, t egressTarget) {
+	defer c.Close()
+	host, port, err := t.hostPort()
+	if err != nil {
+		// Validated when the config file was loaded.
+		log.Printf("egress: [unexpected] %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	tc, err := ef.dialer.DialTCP(ctx, host, port)
+	cancel()
+	if err != nil {
+		log.Printf("egress: error dialing %s: %v", t.Target, err)
+		return
+	}
+	defer tc.Close()
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(tc, c)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(c, tc)
+		errc <- err
+	}()
+	<-errc
+}
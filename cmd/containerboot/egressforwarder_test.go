@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// fakeTailnetDialer dials hosts on localhost, recording the hosts dialed.
+type fakeTailnetDialer struct {
+	dialed chan string
+}
+
+func (d *fakeTailnetDialer) DialTCP(ctx context.Context, host string, port uint16) (net.Conn, error) {
+	d.dialed <- host
+	var nd net.Dialer
+	return nd.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+}
+
+func TestEgressForwarder(t *testing.T) {
+	// The tailnet target echoes lines back.
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				s := bufio.NewScanner(c)
+				for s.Scan() {
+					fmt.Fprintf(c, "%s\n", s.Text())
+				}
+			}()
+		}
+	}()
+	targetPort := target.Addr().(*net.TCPAddr).Port
+
+	d := &fakeTailnetDialer{dialed: make(chan string, 10)}
+	ef := &egressForwarder{dialer: d}
+	defer ef.close()
+	if err := ef.set([]egressTarget{{Listen: "127.0.0.1:0", Target: fmt.Sprintf("db.tails-scales.ts.net:%d", targetPort)}}); err != nil {
+		t.Fatal(err)
+	}
+	addr := ef.listeners["127.0.0.1:0"].ln.Addr().String()
+
+	roundTrip := func(wantHost string) {
+		t.Helper()
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		fmt.Fprintf(c, "hello\n")
+		got, err := bufio.NewReader(c).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "hello\n" {
+			t.Errorf("got %q, want %q", got, "hello\n")
+		}
+		if host := <-d.dialed; host != wantHost {
+			t.Errorf("dialed %q, want %q", host, wantHost)
+		}
+	}
+	roundTrip("db.tails-scales.ts.net")
+
+	// Changing the target of a listener applies to new connections.
+	if err := ef.set([]egressTarget{{Listen: "127.0.0.1:0", Target: fmt.Sprintf("100.64.0.2:%d", targetPort)}}); err != nil {
+		t.Fatal(err)
+	}
+	roundTrip("100.64.0.2")
+
+	// Removing the target stops the listener.
+	if err := ef.set(nil); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := net.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Errorf("listener on %s still accepting connections", addr)
+	}
+}
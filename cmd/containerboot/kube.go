@@ -29,6 +29,10 @@
 	"tailscale.com/util/set"
 )
 
+// reasonServeAccessDenied is the reason of the Events that containerboot
+// records on its Pod when tailscaled denies a peer access to a serve handler.
+const reasonServeAccessDenied = "ServeAccessDenied"
+
 // kubeClient is a wrapper around Tailscale's internal kube client that knows how to talk to the kube API server. We use
 // this rather than any of the upstream Kubernetes client libaries to avoid extra imports.
 type kubeClient struct {
@@ -101,6 +101,41 @@
 //     cluster using the same hostname (in this case, the MagicDNS name of the ingress proxy)
 //     as a non-cluster workload on tailnet.
 //     This is only meant to be configured by the Kubernetes operator.
+//   - TS_CONFIG_FILE: if specified, a path to a containerboot config file that
+//     configures containerboot instead of environment variables. See below.
+//
+// As an alternative to environment variables, containerboot can be configured
+// with a single config file at TS_CONFIG_FILE, written in HuJSON (JSON with
+// comments and trailing commas) or, if its name ends in .yaml or .yml, in
+// YAML. If set, TS_AUTHKEY, TS_AUTH_KEY, TS_CLIENT_ID, TS_CLIENT_SECRET,
+// TS_ID_TOKEN, TS_AUDIENCE, TS_AUTH_ONCE, TS_HOSTNAME, TS_ROUTES,
+// TS_ACCEPT_DNS, TS_USERSPACE, TS_STATE_DIR, TS_KUBE_SECRET, TS_SOCKS5_SERVER,
+// TS_OUTBOUND_HTTP_PROXY_LISTEN, TS_SERVE_CONFIG, TS_LOCAL_ADDR_PORT,
+// TS_ENABLE_METRICS, TS_ENABLE_HEALTH_CHECK, TS_HEALTHCHECK_ADDR_PORT and
+// TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR must not be set. For example:
+//
+//	{
+//		"version": "alpha0",
+//		"hostname": "web",
+//		"auth": {"authKey": "file:/run/secrets/authkey", "once": true},
+//		"state": {"dir": "/var/lib/tailscale"},
+//		"routes": ["10.0.0.0/24"],
+//		"monitoring": {"healthCheck": true, "metrics": true},
+//		// Serve a local web server on the tailnet and the internet.
+//		"serve": [
+//			{"port": 443, "target": "http://127.0.0.1:8080", "funnel": true},
+//			{"port": 5432, "protocol": "tcp", "target": "127.0.0.1:5432"},
+//		],
+//		// Forward local connections to a tailnet database.
+//		"egress": [
+//			{"listen": "127.0.0.1:3306", "target": "db.tails-scales.ts.net:3306"},
+//		],
+//	}
+//
+// The config file is validated on container start, and errors are reported
+// with their location in the file. The file is watched for changes, and
+// changes to the hostname, routes, acceptDNS, serve and egress settings are
+// applied without a restart. Invalid changes are logged and ignored.
 //
 // When running on Kubernetes, containerboot defaults to storing state in the
 // "tailscale" kube secret. To store state on local disk instead, set
@@ -389,7 +424,7 @@ func run() error {
 
 	// Remove any serve config and advertised HTTPS endpoint that may have been set by a previous run of
 	// containerboot, but only if we're providing a new one.
-	if cfg.ServeConfigPath != "" {
+	if cfg.ServeConfigPath != "" || cfg.ConfigFile != nil {
 		log.Printf("serve proxy: unsetting previous config")
 		if err := client.SetServeConfig(ctx, new(ipn.ServeConfig)); err != nil {
 			return fmt.Errorf("failed to unset serve config: %w", err)
@@ -416,6 +451,11 @@ func run() error {
 	if cfg.TailscaledConfigFilePath != "" {
 		go watchTailscaledConfigChanges(ctx, cfg.TailscaledConfigFilePath, client, cfgWatchErrChan)
 	}
+	// If containerboot was configured with a config file, forward
+	// connections to its egress targets.
+	ef := &egressForwarder{dialer: client}
+	defer ef.close()
+	cfgFileErrChan := make(chan error)
 
 	var (
 		startupTasksDone       = false
@@ -432,6 +472,7 @@ func run() error {
 		certDomainChanged = make(chan bool, 1)
 
 		triggerWatchServeConfigChanges sync.Once
+		triggerWatchConfigFileChanges  sync.Once
 	)
 
 	var nfr linuxfw.NetfilterRunner
@@ -513,6 +554,8 @@ func run() error {
 			return fmt.Errorf("failed to read from tailscaled: %w", err)
 		case err := <-cfgWatchErrChan:
 			return fmt.Errorf("failed to watch tailscaled config: %w", err)
+		case err := <-cfgFileErrChan:
+			return fmt.Errorf("failed to apply config file: %w", err)
 		case n := <-notifyChan:
 			if n.State != nil && *n.State != ipn.Running {
 				// Something's gone wrong and we've left the authenticated state.
@@ -522,6 +565,14 @@ func run() error {
 				// whereupon we'll go through initial auth again.
 				return fmt.Errorf("tailscaled left running state (now in state %q), exiting", *n.State)
 			}
+			if n.ServeAccessDenied != nil && kc != nil {
+				// Surface connections that were refused because of an
+				// AllowFrom restriction in the serve config as Events on
+				// the Pod, so that they're visible from the cluster.
+				if err := kc.Event(ctx, "Warning", reasonServeAccessDenied, n.ServeAccessDenied.String()); err != nil {
+					log.Printf("error recording access denied Event: %v", err)
+				}
+			}
 			if n.NetMap != nil {
 				addrs = n.NetMap.SelfNode.Addresses().AsSlice()
 				newCurrentIPs := deephash.Hash(&addrs)
@@ -597,7 +648,7 @@ func run() error {
 					resetTimer(false)
 					backendAddrs = newBackendAddrs
 				}
-				if cfg.ServeConfigPath != "" {
+				if cfg.ServeConfigPath != "" || cfg.ConfigFile != nil {
 					cd := certDomainFromNetmap(n.NetMap)
 					if cd == "" {
 						cd = kubetypes.ValueNoHTTPS
@@ -657,6 +708,11 @@ func run() error {
 						go watchServeConfigChanges(ctx, certDomainChanged, certDomain, client, kc, cfg)
 					})
 				}
+				if cfg.ConfigFile != nil {
+					triggerWatchConfigFileChanges.Do(func() {
+						go watchConfigFileChanges(ctx, certDomainChanged, certDomain, client, ef, cfg, cfgFileErrChan)
+					})
+				}
 
 				if egressSvcsNotify != nil {
 					egressSvcsNotify <- n
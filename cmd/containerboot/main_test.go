@@ -937,6 +937,44 @@ type testCase struct {
 				},
 			}
 		},
+		"config_file": func(env *testEnv) testCase {
+			return testCase{
+				Env: map[string]string{
+					"TS_CONFIG_FILE": filepath.Join(env.d, "etc/tailscaled/containerboot.hujson"),
+				},
+				Phases: []phase{
+					{
+						WantCmds: []string{
+							"/usr/bin/tailscaled --socket=/tmp/tailscaled.sock --state=mem: --statedir=/tmp --tun=userspace-networking",
+							"/usr/bin/tailscale --socket=/tmp/tailscaled.sock up --accept-dns=false --authkey=tskey-key --advertise-routes=10.0.0.0/24 --hostname=web",
+						},
+						EndpointStatuses: map[string]int{
+							healthURL(env.localAddrPort): 503, // Doesn't start passing until the next phase.
+						},
+					},
+					{
+						Notify: runningNotify,
+						EndpointStatuses: map[string]int{
+							healthURL(env.localAddrPort): 200,
+						},
+					},
+				},
+			}
+		},
+		"config_file_with_conflicting_env": func(env *testEnv) testCase {
+			return testCase{
+				Env: map[string]string{
+					"TS_CONFIG_FILE": filepath.Join(env.d, "etc/tailscaled/containerboot.hujson"),
+					"TS_HOSTNAME":    "other",
+				},
+				Phases: []phase{
+					{
+						WantLog:      "TS_CONFIG_FILE cannot be set in combination with TS_HOSTNAME",
+						WantExitCode: ptr.To(1),
+					},
+				},
+			}
+		},
 		"egress_svcs_config_kube": func(env *testEnv) testCase {
 			return testCase{
 				Env: map[string]string{
@@ -1688,6 +1726,18 @@ func newTestEnv(t *testing.T) testEnv {
 		*p = port
 	}
 
+	bootConf := fmt.Sprintf(`{
+	// Configures containerboot instead of env vars.
+	"version": "alpha0",
+	"hostname": "web",
+	"auth": {"authKey": "tskey-key"},
+	"routes": ["10.0.0.0/24"],
+	"monitoring": {"addrPort": "[::]:%d", "healthCheck": true},
+}`, localAddrPort)
+	if err := os.WriteFile(filepath.Join(d, "etc/tailscaled/containerboot.hujson"), []byte(bootConf), 0600); err != nil {
+		t.Fatal(err)
+	}
+
 	return testEnv{
 		kube:            &kube,
 		lapi:            &lapi,
@@ -85,6 +85,11 @@ type settings struct {
 	// certs) and 'rw' for Pods that should manage the TLS certs shared
 	// amongst the replicas.
 	CertShareMode string
+	// ConfigFilePath is the path to the containerboot config file, if
+	// containerboot is configured with one.
+	ConfigFilePath string
+	// ConfigFile is the parsed config file at ConfigFilePath, if set.
+	ConfigFile *configFile
 }
 
 func configFromEnv() (*settings, error) {
@@ -127,6 +132,24 @@ func configFromEnv() (*settings, error) {
 		PodUID:                                defaultEnv("POD_UID", ""),
 	}
 
+	if path := defaultEnv("TS_CONFIG_FILE", ""); path != "" {
+		var conflicting []string
+		for _, name := range configFileEnvVars {
+			if _, ok := os.LookupEnv(name); ok {
+				conflicting = append(conflicting, name)
+			}
+		}
+		if len(conflicting) > 0 {
+			return nil, fmt.Errorf("TS_CONFIG_FILE cannot be set in combination with %s", strings.Join(conflicting, ", "))
+		}
+		c, err := loadConfigFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error loading config file: %w", err)
+		}
+		cfg.ConfigFilePath = path
+		c.applyTo(cfg)
+	}
+
 	podIPs, ok := os.LookupEnv("POD_IPS")
 	if ok {
 		ips := strings.Split(podIPs, ",")
@@ -0,0 +1,1443 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+
+	"tailscale.com/internal/client/tailscale"
+	"tailscale.com/ipn"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/types/ptr"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+)
+
+const (
+	// gatewayControllerName is the controllerName of GatewayClasses
+	// implemented by the operator.
+	gatewayControllerName gatewayv1.GatewayController = "tailscale.com/gateway-controller"
+	// FinalizerNameGateway is the finalizer used by the GatewayReconciler.
+	FinalizerNameGateway = "tailscale.com/gateway-finalizer"
+
+	kindGateway    gatewayv1.Kind = "Gateway"
+	kindHTTPRoute  gatewayv1.Kind = "HTTPRoute"
+	kindTCPRoute   gatewayv1.Kind = "TCPRoute"
+	kindTLSRoute   gatewayv1.Kind = "TLSRoute"
+	kindProxyClass                = "ProxyClass"
+
+	// gatewayReasonInvalidParameters is the reason a Gateway isn't accepted
+	// if its GatewayClass's parametersRef is invalid. Gateway API v1.1 and
+	// later define it as GatewayReasonInvalidParameters.
+	gatewayReasonInvalidParameters gatewayv1.GatewayConditionReason = "InvalidParameters"
+)
+
+var gaugeGatewayResources = clientmetric.NewGauge(kubetypes.MetricGatewayResourceCount)
+
+// GatewayClassReconciler accepts GatewayClasses that are implemented by the
+// operator, i.e. those with controllerName tailscale.com/gateway-controller.
+type GatewayClassReconciler struct {
+	client.Client
+
+	logger *zap.SugaredLogger
+	clock  tstime.Clock
+}
+
+// Reconcile sets the Accepted condition of a GatewayClass implemented by the
+// operator. A GatewayClass is accepted if its parametersRef, if any, refers to
+// an existing ProxyClass.
+func (r *GatewayClassReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("GatewayClass", req.Name)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	gc := new(gatewayv1.GatewayClass)
+	err = r.Get(ctx, req.NamespacedName, gc)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("GatewayClass not found, assuming it was deleted")
+		return res, nil
+	} else if err != nil {
+		return res, fmt.Errorf("failed to get GatewayClass: %w", err)
+	}
+	if gc.Spec.ControllerName != gatewayControllerName {
+		return res, nil
+	}
+
+	oldStatus := gc.Status.DeepCopy()
+	status, reason, msg := metav1.ConditionTrue, string(gatewayv1.GatewayClassReasonAccepted), "GatewayClass is implemented by the Tailscale Kubernetes Operator"
+	if _, err := proxyClassForGatewayClass(ctx, r.Client, gc); err != nil {
+		var invalid *invalidParametersRefError
+		if !errors.As(err, &invalid) {
+			return res, err
+		}
+		status, reason, msg = metav1.ConditionFalse, string(gatewayv1.GatewayClassReasonInvalidParameters), err.Error()
+	}
+	setGatewayAPICondition(&gc.Status.Conditions, string(gatewayv1.GatewayClassConditionStatusAccepted), status, reason, msg, gc.Generation, r.clock)
+	if apiequality.Semantic.DeepEqual(oldStatus, &gc.Status) {
+		return res, nil
+	}
+	if err := r.Status().Update(ctx, gc); err != nil {
+		return res, fmt.Errorf("failed to update GatewayClass status: %w", err)
+	}
+	return res, nil
+}
+
+// invalidParametersRefError is returned by proxyClassForGatewayClass when a
+// GatewayClass's parametersRef is not for a ProxyClass or the ProxyClass does
+// not exist.
+type invalidParametersRefError struct {
+	msg string
+}
+
+func (e *invalidParametersRefError) Error() string {
+	return e.msg
+}
+
+// proxyClassForGatewayClass returns the name of the ProxyClass that gc's
+// parametersRef refers to, or an empty string if it has none. It returns an
+// *invalidParametersRefError if the parametersRef is not for a ProxyClass or
+// the ProxyClass does not exist, and any other error if the ProxyClass could
+// not be looked up.
+func proxyClassForGatewayClass(ctx context.Context, cl client.Client, gc *gatewayv1.GatewayClass) (string, error) {
+	ref := gc.Spec.ParametersRef
+	if ref == nil {
+		return "", nil
+	}
+	if string(ref.Group) != tsapi.SchemeGroupVersion.Group || ref.Kind != kindProxyClass {
+		return "", &invalidParametersRefError{fmt.Sprintf("parametersRef must refer to a %s.%s, got %s.%s", kindProxyClass, tsapi.SchemeGroupVersion.Group, ref.Kind, ref.Group)}
+	}
+	pc := new(tsapi.ProxyClass)
+	if err := cl.Get(ctx, client.ObjectKey{Name: ref.Name}, pc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", &invalidParametersRefError{fmt.Sprintf("ProxyClass %q referenced by parametersRef does not exist", ref.Name)}
+		}
+		return "", fmt.Errorf("error getting ProxyClass %q: %w", ref.Name, err)
+	}
+	return ref.Name, nil
+}
+
+// GatewayReconciler is a controller that exposes Gateways of a GatewayClass
+// implemented by the operator to the tailnet.
+//
+// Each such Gateway gets its own ingress ProxyGroup, named
+// <namespace>-<name>, and a Tailscale Service, named after the Gateway's
+// tailscale.com/hostname annotation or else the Gateway's name. Each listener
+// of the Gateway is a port of the Tailscale Service. HTTP and HTTPS listeners
+// serve the HTTPRoutes attached to them, TCP listeners forward to the backend
+// of the TCPRoute attached to them, and TLS listeners forward to the backend of
+// the TLSRoute attached to them, either terminating TLS or passing it
+// through. HTTPS listeners and terminating TLS listeners use TLS certificates
+// for the Tailscale Service's MagicDNS name, so any certificateRefs are
+// ignored.
+type GatewayReconciler struct {
+	client.Client
+
+	recorder    record.EventRecorder
+	logger      *zap.SugaredLogger
+	clock       tstime.Clock
+	tsClient    tsClient
+	tsNamespace string
+	lc          localClient
+	defaultTags []string
+	operatorID  string // stableID of the operator's Tailscale device
+
+	// tcpRoutes and tlsRoutes are whether the experimental TCPRoute and
+	// TLSRoute CRDs are installed.
+	tcpRoutes bool
+	tlsRoutes bool
+
+	mu sync.Mutex // protects following
+	// managedGateways is a set of all Gateway resources that we're currently
+	// managing. This is only used for metrics.
+	managedGateways set.Slice[types.UID]
+}
+
+// ha returns an HAIngressReconciler for the Tailscale Service, serve config
+// and TLS certificate helpers that Gateways share with HA Ingresses.
+func (r *GatewayReconciler) ha() *HAIngressReconciler {
+	return &HAIngressReconciler{
+		Client:      r.Client,
+		recorder:    r.recorder,
+		logger:      r.logger,
+		tsClient:    r.tsClient,
+		tsNamespace: r.tsNamespace,
+		lc:          r.lc,
+		operatorID:  r.operatorID,
+	}
+}
+
+// Reconcile reconciles Gateways. A Gateway that is being deleted, or whose
+// GatewayClass is not implemented by the operator, has its ProxyGroup and
+// Tailscale Service cleaned up. Otherwise, its ProxyGroup and Tailscale
+// Service are created or updated and the status of the Gateway and the routes
+// attached to it is updated.
+func (r *GatewayReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("Gateway", req.NamespacedName)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	gw := new(gatewayv1.Gateway)
+	err = r.Get(ctx, req.NamespacedName, gw)
+	if apierrors.IsNotFound(err) {
+		// Request object not found, could have been deleted after reconcile request.
+		logger.Debugf("Gateway not found, assuming it was deleted")
+		return res, nil
+	} else if err != nil {
+		return res, fmt.Errorf("failed to get Gateway: %w", err)
+	}
+
+	gc := new(gatewayv1.GatewayClass)
+	if err := r.Get(ctx, client.ObjectKey{Name: string(gw.Spec.GatewayClassName)}, gc); err != nil && !apierrors.IsNotFound(err) {
+		return res, fmt.Errorf("failed to get GatewayClass: %w", err)
+	} else if err != nil {
+		gc = nil
+	}
+
+	hostname := hostnameForGateway(gw)
+	logger = logger.With("hostname", hostname)
+
+	// needsRequeue is set to true if the underlying Tailscale Service has
+	// changed as a result of this reconcile, as for HA Ingresses.
+	needsRequeue := false
+	if !gw.DeletionTimestamp.IsZero() || gc == nil || gc.Spec.ControllerName != gatewayControllerName {
+		err = r.maybeCleanup(ctx, gw, logger)
+	} else {
+		needsRequeue, err = r.maybeProvision(ctx, hostname, gw, gc, logger)
+	}
+	if err != nil {
+		return res, err
+	}
+	if needsRequeue {
+		res = reconcile.Result{RequeueAfter: requeueInterval()}
+	}
+	return res, nil
+}
+
+// maybeProvision ensures that the ProxyGroup and Tailscale Service for gw
+// exist and are up to date, and that the ProxyGroup's serve config serves the
+// routes attached to gw. It returns true if the operation resulted in a
+// Tailscale Service update.
+func (r *GatewayReconciler) maybeProvision(ctx context.Context, hostname string, gw *gatewayv1.Gateway, gc *gatewayv1.GatewayClass, logger *zap.SugaredLogger) (svcsChanged bool, err error) {
+	oldStatus := gw.Status.DeepCopy()
+	defer func() {
+		if err != nil || apiequality.Semantic.DeepEqual(oldStatus, &gw.Status) {
+			return
+		}
+		if uerr := r.Status().Update(ctx, gw); uerr != nil {
+			err = fmt.Errorf("failed to update Gateway status: %w", uerr)
+		}
+	}()
+	notProgrammed := func(accepted metav1.ConditionStatus, reason gatewayv1.GatewayConditionReason, msg string) {
+		gw.Status.Addresses = nil
+		setGatewayAPICondition(&gw.Status.Conditions, string(gatewayv1.GatewayConditionAccepted), accepted, string(reason), msg, gw.Generation, r.clock)
+		setGatewayAPICondition(&gw.Status.Conditions, string(gatewayv1.GatewayConditionProgrammed), metav1.ConditionFalse, string(reason), msg, gw.Generation, r.clock)
+	}
+
+	if err := validateGateway(gw, hostname); err != nil {
+		logger.Infof("invalid Gateway configuration: %v", err)
+		r.recorder.Event(gw, corev1.EventTypeWarning, "InvalidGatewayConfiguration", err.Error())
+		notProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonInvalid, err.Error())
+		return false, nil
+	}
+	proxyClass, err := proxyClassForGatewayClass(ctx, r.Client, gc)
+	if err != nil {
+		var invalid *invalidParametersRefError
+		if !errors.As(err, &invalid) {
+			return false, err
+		}
+		notProgrammed(metav1.ConditionFalse, gatewayReasonInvalidParameters, err.Error())
+		return false, nil
+	}
+
+	if !slices.Contains(gw.Finalizers, FinalizerNameGateway) {
+		// This log line is printed exactly once during initial provisioning,
+		// because once the finalizer is in place this block gets skipped.
+		logger.Infof("exposing Gateway over tailscale")
+		// Patch rather than update the Gateway, so that fields that
+		// gatewayv1 doesn't know about yet aren't dropped.
+		orig := gw.DeepCopy()
+		gw.Finalizers = append(gw.Finalizers, FinalizerNameGateway)
+		if err := r.Patch(ctx, gw, client.MergeFromWithOptions(orig, client.MergeFromWithOptimisticLock{})); err != nil {
+			return false, fmt.Errorf("failed to add finalizer: %w", err)
+		}
+		r.mu.Lock()
+		r.managedGateways.Add(gw.UID)
+		gaugeGatewayResources.Set(int64(r.managedGateways.Len()))
+		r.mu.Unlock()
+	}
+
+	// 1. Ensure that the Gateway's ProxyGroup exists and is ready.
+	pg, err := r.ensureProxyGroup(ctx, gw, proxyClass)
+	if err != nil {
+		var conflict *gatewayProxyGroupConflictError
+		if errors.As(err, &conflict) {
+			r.recorder.Event(gw, corev1.EventTypeWarning, "ProxyGroupConflict", err.Error())
+			notProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonNoResources, err.Error())
+			return false, nil
+		}
+		return false, err
+	}
+	logger = logger.With("ProxyGroup", pg.Name)
+	if !tsoperator.ProxyGroupAvailable(pg) {
+		logger.Infof("ProxyGroup is not (yet) ready")
+		notProgrammed(metav1.ConditionTrue, gatewayv1.GatewayReasonPending, fmt.Sprintf("waiting for ProxyGroup %q to become ready", pg.Name))
+		return false, nil
+	}
+
+	// 2. Ensure that the Tailscale Service, if it exists, is owned by the
+	// operator.
+	ha := r.ha()
+	serviceName := tailcfg.ServiceName("svc:" + hostname)
+	existingTSSvc, err := r.tsClient.GetVIPService(ctx, serviceName)
+	if err != nil && !isErrorTailscaleServiceNotFound(err) {
+		return false, fmt.Errorf("error getting Tailscale Service %q: %w", hostname, err)
+	}
+	updatedAnnotations, err := ownerAnnotations(r.operatorID, existingTSSvc)
+	if err != nil {
+		const instr = "To proceed, you can either manually delete the existing Tailscale Service or choose a different hostname with the tailscale.com/hostname annotation"
+		msg := fmt.Sprintf("error ensuring ownership of Tailscale Service %s: %v. %s", hostname, err, instr)
+		logger.Warn(msg)
+		r.recorder.Event(gw, corev1.EventTypeWarning, "InvalidTailscaleService", msg)
+		notProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonInvalid, msg)
+		return false, nil
+	}
+
+	// 3. Work out the Gateway's serve config from its listeners and the
+	// routes attached to them.
+	tcd, err := tailnetCertDomain(ctx, r.lc)
+	if err != nil {
+		return false, fmt.Errorf("error determining DNS name base: %w", err)
+	}
+	dnsName := hostname + "." + tcd
+	routes, err := r.routesForGateway(ctx, gw)
+	if err != nil {
+		return false, err
+	}
+	gwCfg, err := r.gatewayServeConfig(ctx, gw, dnsName, routes)
+	if err != nil {
+		return false, err
+	}
+	if err := r.updateRouteStatuses(ctx, routes); err != nil {
+		return false, err
+	}
+	if len(gwCfg.ports) == 0 {
+		r.setListenerStatuses(gw, gwCfg, false)
+		notProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonListenersNotValid, "no valid listeners")
+		return false, nil
+	}
+
+	// 4. Ensure that TLS Secret and RBAC exists, if any listener needs
+	// them.
+	if gwCfg.needsCert {
+		if err := ha.ensureCertResources(ctx, pg, dnsName, gw); err != nil {
+			return false, fmt.Errorf("error ensuring cert resources: %w", err)
+		}
+	}
+
+	// 5. Ensure that the serve config for the ProxyGroup contains the
+	// Tailscale Service, and only it. Any other Tailscale Service is left
+	// over from a previous hostname of the Gateway.
+	cm, cfg, err := ha.proxyGroupServeConfig(ctx, pg.Name)
+	if err != nil {
+		return false, fmt.Errorf("error getting Gateway serve config: %w", err)
+	}
+	if cm == nil {
+		logger.Infof("no serve config ConfigMap found, unable to update serve config. Ensure that ProxyGroup is healthy.")
+		return false, nil
+	}
+	for name := range cfg.Services {
+		if name == serviceName {
+			continue
+		}
+		logger.Infof("Tailscale Service %q is no longer used by the Gateway, cleaning up", name)
+		changed, err := r.cleanupTailscaleService(ctx, pg.Name, name, logger)
+		if err != nil {
+			return false, err
+		}
+		svcsChanged = svcsChanged || changed
+		delete(cfg.Services, name)
+	}
+	var gotCfg *ipn.ServiceConfig
+	if cfg.Services != nil {
+		gotCfg = cfg.Services[serviceName]
+	}
+	if !reflect.DeepEqual(gotCfg, gwCfg.svc) || len(cfg.Services) != 1 {
+		logger.Infof("Updating serve config")
+		mak.Set(&cfg.Services, serviceName, gwCfg.svc)
+		cfgBytes, err := json.Marshal(cfg)
+		if err != nil {
+			return false, fmt.Errorf("error marshaling serve config: %w", err)
+		}
+		mak.Set(&cm.BinaryData, serveConfigKey, cfgBytes)
+		if err := r.Update(ctx, cm); err != nil {
+			return false, fmt.Errorf("error updating serve config: %w", err)
+		}
+	}
+
+	// 6. Ensure that the Tailscale Service exists and is up to date.
+	tags := r.defaultTags
+	if tstr, ok := gw.Annotations[AnnotationTags]; ok {
+		tags = strings.Split(tstr, ",")
+	}
+	tsSvc := &tailscale.VIPService{
+		Name:        serviceName,
+		Tags:        tags,
+		Ports:       gwCfg.ports,
+		Comment:     managedTSServiceComment,
+		Annotations: updatedAnnotations,
+	}
+	if existingTSSvc != nil {
+		tsSvc.Addrs = existingTSSvc.Addrs
+	}
+	if existingTSSvc == nil ||
+		!reflect.DeepEqual(tsSvc.Tags, existingTSSvc.Tags) ||
+		!reflect.DeepEqual(tsSvc.Ports, existingTSSvc.Ports) ||
+		!ownersAreSetAndEqual(tsSvc, existingTSSvc) {
+		logger.Infof("Ensuring Tailscale Service exists and is up to date")
+		if err := r.tsClient.CreateOrUpdateVIPService(ctx, tsSvc); err != nil {
+			return false, fmt.Errorf("error creating Tailscale Service: %w", err)
+		}
+	}
+
+	// 7. Advertise the Tailscale Service from the ProxyGroup's Pods. As for
+	// HA Ingresses, if every listener needs a TLS certificate it is only
+	// advertised once the certificate has been issued.
+	mode := serviceAdvertisementHTTPAndHTTPS
+	if gwCfg.onlyTLS {
+		mode = serviceAdvertisementHTTPS
+	}
+	if err := ha.maybeUpdateAdvertiseServicesConfig(ctx, pg.Name, serviceName, mode, logger); err != nil {
+		return false, fmt.Errorf("failed to update tailscaled config: %w", err)
+	}
+
+	// 8. Update the Gateway status.
+	count, err := numberPodsAdvertising(ctx, r.Client, r.tsNamespace, pg.Name, serviceName)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if any Pods are configured: %w", err)
+	}
+	programmed := count > 0
+	r.setListenerStatuses(gw, gwCfg, programmed)
+	setGatewayAPICondition(&gw.Status.Conditions, string(gatewayv1.GatewayConditionAccepted), metav1.ConditionTrue, string(gatewayv1.GatewayReasonAccepted), "", gw.Generation, r.clock)
+	if !programmed {
+		gw.Status.Addresses = nil
+		setGatewayAPICondition(&gw.Status.Conditions, string(gatewayv1.GatewayConditionProgrammed), metav1.ConditionFalse, string(gatewayv1.GatewayReasonPending), "no ProxyGroup Pods are advertising the Tailscale Service yet", gw.Generation, r.clock)
+		return svcsChanged, nil
+	}
+	gw.Status.Addresses = []gatewayv1.GatewayStatusAddress{{
+		Type:  ptr.To(gatewayv1.HostnameAddressType),
+		Value: dnsName,
+	}}
+	msg := fmt.Sprintf("%d ProxyGroup Pod(s) advertising Tailscale Service %s", count, serviceName)
+	setGatewayAPICondition(&gw.Status.Conditions, string(gatewayv1.GatewayConditionProgrammed), metav1.ConditionTrue, string(gatewayv1.GatewayReasonProgrammed), msg, gw.Generation, r.clock)
+	return svcsChanged, nil
+}
+
+// maybeCleanup ensures that the ProxyGroup and Tailscale Service for gw are
+// cleaned up when gw is being deleted or no longer implemented by the
+// operator.
+func (r *GatewayReconciler) maybeCleanup(ctx context.Context, gw *gatewayv1.Gateway, logger *zap.SugaredLogger) error {
+	ix := slices.Index(gw.Finalizers, FinalizerNameGateway)
+	if ix < 0 {
+		logger.Debugf("no finalizer, nothing to do")
+		return nil
+	}
+	logger.Infof("Ensuring that resources for the Gateway are cleaned up")
+
+	// 1. Clean up every Tailscale Service in the ProxyGroup's serve config,
+	// which will be the Gateway's current Tailscale Service and maybe one
+	// for a previous hostname.
+	pgName := pgNameForGateway(gw)
+	_, cfg, err := r.ha().proxyGroupServeConfig(ctx, pgName)
+	if err != nil {
+		return fmt.Errorf("error getting ProxyGroup serve config: %w", err)
+	}
+	svcNames := set.Of(tailcfg.ServiceName("svc:" + hostnameForGateway(gw)))
+	if cfg != nil {
+		for name := range cfg.Services {
+			svcNames.Add(name)
+		}
+	}
+	for name := range svcNames {
+		if _, err := r.cleanupTailscaleService(ctx, pgName, name, logger); err != nil {
+			return err
+		}
+	}
+
+	// 2. Delete the ProxyGroup, if it's ours.
+	pg := new(tsapi.ProxyGroup)
+	if err := r.Get(ctx, client.ObjectKey{Name: pgName}, pg); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error getting ProxyGroup: %w", err)
+	} else if err == nil && isGatewayProxyGroup(pg, gw) {
+		logger.Infof("Deleting ProxyGroup %q", pgName)
+		if err := r.Delete(ctx, pg); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting ProxyGroup: %w", err)
+		}
+	}
+
+	// 3. Remove the Gateway from the status of the routes attached to it.
+	routes, err := r.routesForGateway(ctx, gw)
+	if err != nil {
+		return err
+	}
+	for _, rt := range routes {
+		old := rt.status.DeepCopy()
+		rt.status.Parents = slices.DeleteFunc(rt.status.Parents, func(p gatewayv1.RouteParentStatus) bool {
+			return p.ControllerName == gatewayControllerName && refersToGateway(p.ParentRef, rt.GetNamespace(), gw)
+		})
+		if err := r.maybeUpdateRouteStatus(ctx, rt, old); err != nil {
+			return err
+		}
+	}
+
+	// 4. Remove the finalizer.
+	orig := gw.DeepCopy()
+	gw.Finalizers = slices.Delete(gw.Finalizers, ix, ix+1)
+	if err := r.Patch(ctx, gw, client.MergeFromWithOptions(orig, client.MergeFromWithOptimisticLock{})); err != nil {
+		return fmt.Errorf("failed to remove finalizer %q: %w", FinalizerNameGateway, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.managedGateways.Remove(gw.UID)
+	gaugeGatewayResources.Set(int64(r.managedGateways.Len()))
+	return nil
+}
+
+// cleanupTailscaleService deletes the Tailscale Service serviceName, unless
+// other operator instances own it, and stops pgName's Pods advertising it.
+// It returns true if an existing Tailscale Service was updated to remove
+// this operator's owner reference.
+func (r *GatewayReconciler) cleanupTailscaleService(ctx context.Context, pgName string, serviceName tailcfg.ServiceName, logger *zap.SugaredLogger) (svcChanged bool, err error) {
+	ha := r.ha()
+	svc, err := r.tsClient.GetVIPService(ctx, serviceName)
+	if err != nil && !isErrorTailscaleServiceNotFound(err) {
+		return false, fmt.Errorf("error getting Tailscale Service %q: %w", serviceName, err)
+	}
+	if svcChanged, err = ha.cleanupTailscaleService(ctx, svc, logger); err != nil {
+		return false, fmt.Errorf("error deleting Tailscale Service %q: %w", serviceName, err)
+	}
+	if err := ha.maybeUpdateAdvertiseServicesConfig(ctx, pgName, serviceName, serviceAdvertisementOff, logger); err != nil {
+		return false, fmt.Errorf("failed to update tailscaled config services: %w", err)
+	}
+	if err := cleanupCertResources(ctx, r.Client, r.lc, r.tsNamespace, pgName, serviceName); err != nil {
+		return false, fmt.Errorf("failed to clean up cert resources: %w", err)
+	}
+	return svcChanged, nil
+}
+
+// gatewayProxyGroupConflictError is returned by ensureProxyGroup when a
+// ProxyGroup with the Gateway's ProxyGroup's name exists but was not created
+// for the Gateway.
+type gatewayProxyGroupConflictError struct {
+	name string
+}
+
+func (e *gatewayProxyGroupConflictError) Error() string {
+	return fmt.Sprintf("ProxyGroup %q already exists and is not managed by this Gateway", e.name)
+}
+
+// ensureProxyGroup ensures that the ingress ProxyGroup for gw exists and is
+// up to date, and returns it.
+func (r *GatewayReconciler) ensureProxyGroup(ctx context.Context, gw *gatewayv1.Gateway, proxyClass string) (*tsapi.ProxyGroup, error) {
+	name := pgNameForGateway(gw)
+	labels := childResourceLabels(gw.Name, gw.Namespace, "gateway")
+	pg := new(tsapi.ProxyGroup)
+	err := r.Get(ctx, client.ObjectKey{Name: name}, pg)
+	switch {
+	case apierrors.IsNotFound(err):
+		pg = &tsapi.ProxyGroup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: labels,
+			},
+			Spec: tsapi.ProxyGroupSpec{
+				Type:       tsapi.ProxyGroupTypeIngress,
+				ProxyClass: proxyClass,
+			},
+		}
+		// Tags can't be changed once the ProxyGroup's devices exist,
+		// so are only set on creation.
+		if tstr, ok := gw.Annotations[AnnotationTags]; ok {
+			for _, t := range strings.Split(tstr, ",") {
+				pg.Spec.Tags = append(pg.Spec.Tags, tsapi.Tag(strings.TrimSpace(t)))
+			}
+		}
+		if err := r.Create(ctx, pg); err != nil {
+			return nil, fmt.Errorf("error creating ProxyGroup %q: %w", name, err)
+		}
+		return pg, nil
+	case err != nil:
+		return nil, fmt.Errorf("error getting ProxyGroup %q: %w", name, err)
+	case !isGatewayProxyGroup(pg, gw):
+		return nil, &gatewayProxyGroupConflictError{name: name}
+	}
+	if pg.Spec.ProxyClass != proxyClass {
+		pg.Spec.ProxyClass = proxyClass
+		if err := r.Update(ctx, pg); err != nil {
+			return nil, fmt.Errorf("error updating ProxyGroup %q: %w", name, err)
+		}
+	}
+	return pg, nil
+}
+
+// pgNameForGateway returns the name of the ProxyGroup for gw.
+func pgNameForGateway(gw *gatewayv1.Gateway) string {
+	return gw.Namespace + "-" + gw.Name
+}
+
+// isGatewayProxyGroup reports whether pg was created for gw.
+func isGatewayProxyGroup(pg *tsapi.ProxyGroup, gw *gatewayv1.Gateway) bool {
+	return isManagedByType(pg, "gateway") && parentFromObjectLabels(pg) == client.ObjectKeyFromObject(gw)
+}
+
+// hostnameForGateway returns the hostname of gw's Tailscale Service, which is
+// its tailscale.com/hostname annotation, if set, or else its name.
+func hostnameForGateway(gw *gatewayv1.Gateway) string {
+	if h := gw.Annotations[AnnotationHostname]; h != "" {
+		return h
+	}
+	return gw.Name
+}
+
+// validateGateway validates that gw, with the given hostname, is properly
+// configured. Listeners are validated separately, so that invalid listeners
+// don't stop valid ones from working.
+func validateGateway(gw *gatewayv1.Gateway, hostname string) error {
+	var errs []error
+	if violations := tagViolations(gw); len(violations) > 0 {
+		errs = append(errs, fmt.Errorf("Gateway contains invalid tags: %v", strings.Join(violations, ",")))
+	}
+	if err := dnsname.ValidLabel(hostname); err != nil {
+		errs = append(errs, fmt.Errorf("invalid hostname %q: %w. Ensure that the hostname is a valid DNS label", hostname, err))
+	}
+	if len(gw.Spec.Addresses) > 0 {
+		errs = append(errs, errors.New("spec.addresses is not supported; the Gateway's address is the MagicDNS name of its Tailscale Service"))
+	}
+	return errors.Join(errs...)
+}
+
+// gatewayRoute is an HTTPRoute, TCPRoute or TLSRoute that refers to a
+// Gateway.
+type gatewayRoute struct {
+	client.Object
+	kind       gatewayv1.Kind
+	parentRefs []gatewayv1.ParentReference
+	hostnames  []gatewayv1.Hostname
+	status     *gatewayv1.RouteStatus // in Object
+
+	httpRules []gatewayv1.HTTPRouteRule // for HTTPRoutes
+	backends  [][]gatewayv1.BackendRef  // backendRefs of each rule of TCPRoutes and TLSRoutes
+
+	// results are what happened to each of parentRefs that refers to the
+	// Gateway being reconciled, by index.
+	results map[int]*routeParentResult
+}
+
+// routeParentResult is the result of attaching a route to a Gateway, as
+// reported in the route's status.
+type routeParentResult struct {
+	err *routeError // or nil if the route is accepted and its refs resolved
+}
+
+// routeError is a reason that a route could not be attached to a Gateway.
+type routeError struct {
+	condType gatewayv1.RouteConditionType
+	reason   gatewayv1.RouteConditionReason
+	msg      string
+}
+
+func (e *routeError) Error() string { return e.msg }
+
+func notAccepted(reason gatewayv1.RouteConditionReason, format string, args ...any) *routeError {
+	return &routeError{condType: gatewayv1.RouteConditionAccepted, reason: reason, msg: fmt.Sprintf(format, args...)}
+}
+
+func refsNotResolved(reason gatewayv1.RouteConditionReason, format string, args ...any) *routeError {
+	return &routeError{condType: gatewayv1.RouteConditionResolvedRefs, reason: reason, msg: fmt.Sprintf(format, args...)}
+}
+
+// routesForGateway returns the routes that refer to gw, oldest first, which
+// is the order in which conflicts between them are resolved.
+func (r *GatewayReconciler) routesForGateway(ctx context.Context, gw *gatewayv1.Gateway) ([]*gatewayRoute, error) {
+	var routes []*gatewayRoute
+	hrs := new(gatewayv1.HTTPRouteList)
+	if err := r.List(ctx, hrs); err != nil {
+		return nil, fmt.Errorf("error listing HTTPRoutes: %w", err)
+	}
+	for i := range hrs.Items {
+		hr := &hrs.Items[i]
+		routes = append(routes, &gatewayRoute{
+			Object:     hr,
+			kind:       kindHTTPRoute,
+			parentRefs: hr.Spec.ParentRefs,
+			hostnames:  hr.Spec.Hostnames,
+			status:     &hr.Status.RouteStatus,
+			httpRules:  hr.Spec.Rules,
+		})
+	}
+	if r.tcpRoutes {
+		trs := new(gatewayv1alpha2.TCPRouteList)
+		if err := r.List(ctx, trs); err != nil {
+			return nil, fmt.Errorf("error listing TCPRoutes: %w", err)
+		}
+		for i := range trs.Items {
+			tr := &trs.Items[i]
+			rt := &gatewayRoute{
+				Object:     tr,
+				kind:       kindTCPRoute,
+				parentRefs: tr.Spec.ParentRefs,
+				status:     &tr.Status.RouteStatus,
+			}
+			for _, rule := range tr.Spec.Rules {
+				rt.backends = append(rt.backends, rule.BackendRefs)
+			}
+			routes = append(routes, rt)
+		}
+	}
+	if r.tlsRoutes {
+		trs := new(gatewayv1alpha2.TLSRouteList)
+		if err := r.List(ctx, trs); err != nil {
+			return nil, fmt.Errorf("error listing TLSRoutes: %w", err)
+		}
+		for i := range trs.Items {
+			tr := &trs.Items[i]
+			rt := &gatewayRoute{
+				Object:     tr,
+				kind:       kindTLSRoute,
+				parentRefs: tr.Spec.ParentRefs,
+				hostnames:  tr.Spec.Hostnames,
+				status:     &tr.Status.RouteStatus,
+			}
+			for _, rule := range tr.Spec.Rules {
+				rt.backends = append(rt.backends, rule.BackendRefs)
+			}
+			routes = append(routes, rt)
+		}
+	}
+	routes = slices.DeleteFunc(routes, func(rt *gatewayRoute) bool {
+		return !slices.ContainsFunc(rt.parentRefs, func(ref gatewayv1.ParentReference) bool {
+			return refersToGateway(ref, rt.GetNamespace(), gw)
+		})
+	})
+	slices.SortStableFunc(routes, func(a, b *gatewayRoute) int {
+		if c := a.GetCreationTimestamp().Compare(b.GetCreationTimestamp().Time); c != 0 {
+			return c
+		}
+		return strings.Compare(client.ObjectKeyFromObject(a).String(), client.ObjectKeyFromObject(b).String())
+	})
+	return routes, nil
+}
+
+// refersToGateway reports whether ref, of a route in namespace routeNS, refers
+// to gw.
+func refersToGateway(ref gatewayv1.ParentReference, routeNS string, gw *gatewayv1.Gateway) bool {
+	if ref.Group != nil && *ref.Group != gatewayv1.GroupName {
+		return false
+	}
+	if ref.Kind != nil && *ref.Kind != kindGateway {
+		return false
+	}
+	ns := routeNS
+	if ref.Namespace != nil {
+		ns = string(*ref.Namespace)
+	}
+	return ns == gw.Namespace && string(ref.Name) == gw.Name
+}
+
+// gatewayConfig is the serve config for a Gateway, and the results of
+// working it out for the Gateway's listeners.
+type gatewayConfig struct {
+	svc       *ipn.ServiceConfig
+	ports     []string // Tailscale Service ports, e.g. "tcp:443"
+	needsCert bool     // whether any listener uses a TLS certificate
+	onlyTLS   bool     // whether every listener needs a TLS certificate
+
+	listeners map[gatewayv1.SectionName]*listenerResult
+}
+
+// listenerResult is the result of working out the serve config for a
+// listener.
+type listenerResult struct {
+	supportedKinds []gatewayv1.RouteGroupKind
+	attachedRoutes int32
+
+	// conflicted, invalidKinds and notAccepted are the reasons, if any,
+	// that the listener is conflicted, has invalid allowedRoutes.kinds or
+	// is not accepted.
+	conflicted   gatewayv1.ListenerConditionReason
+	invalidKinds string
+	notAccepted  gatewayv1.ListenerConditionReason
+	msg          string
+
+	handlers  map[string]*ipn.HTTPHandler // for HTTP and HTTPS listeners
+	forward   string                      // for TCP and TLS listeners
+	allowFrom []string                    // for TCP and TLS listeners
+}
+
+func (l *listenerResult) accepted() bool {
+	return l.notAccepted == "" && l.conflicted == ""
+}
+
+// gatewayServeConfig works out the serve config for gw, whose Tailscale
+// Service has the MagicDNS name dnsName, from gw's listeners and routes. It
+// records the results for each route in the route.
+func (r *GatewayReconciler) gatewayServeConfig(ctx context.Context, gw *gatewayv1.Gateway, dnsName string, routes []*gatewayRoute) (*gatewayConfig, error) {
+	gwCfg := &gatewayConfig{
+		svc:     &ipn.ServiceConfig{},
+		onlyTLS: true,
+	}
+	ports := make(set.Set[gatewayv1.PortNumber])
+	for _, l := range gw.Spec.Listeners {
+		lr := &listenerResult{}
+		mak.Set(&gwCfg.listeners, l.Name, lr)
+		lr.supportedKinds, lr.invalidKinds = listenerRouteKinds(l)
+		switch {
+		case lr.supportedKinds == nil:
+			lr.notAccepted = gatewayv1.ListenerReasonUnsupportedProtocol
+			lr.msg = fmt.Sprintf("protocol %q is not supported; must be one of HTTP, HTTPS, TCP or TLS", l.Protocol)
+		case l.Hostname != nil && string(*l.Hostname) != dnsName:
+			lr.conflicted = gatewayv1.ListenerReasonHostnameConflict
+			lr.msg = fmt.Sprintf("hostname must be unset or %q, the MagicDNS name of the Gateway's Tailscale Service", dnsName)
+		case l.Protocol == gatewayv1.HTTPSProtocolType && l.TLS != nil && l.TLS.Mode != nil && *l.TLS.Mode == gatewayv1.TLSModePassthrough:
+			lr.notAccepted = gatewayv1.ListenerReasonUnsupportedProtocol
+			lr.msg = "HTTPS listeners can't use TLS passthrough; use a TLS listener instead"
+		case ports.Contains(l.Port):
+			lr.conflicted = gatewayv1.ListenerReasonProtocolConflict
+			lr.msg = fmt.Sprintf("port %d is used by another listener", l.Port)
+		}
+		if !lr.accepted() {
+			continue
+		}
+		ports.Add(l.Port)
+		gwCfg.ports = append(gwCfg.ports, fmt.Sprintf("tcp:%d", l.Port))
+		if listenerTerminatesTLS(l) {
+			gwCfg.needsCert = true
+		} else {
+			gwCfg.onlyTLS = false
+		}
+	}
+	if len(gwCfg.ports) == 0 {
+		gwCfg.onlyTLS = false
+	}
+
+	for _, rt := range routes {
+		for i, ref := range rt.parentRefs {
+			if !refersToGateway(ref, rt.GetNamespace(), gw) {
+				continue
+			}
+			res := &routeParentResult{}
+			mak.Set(&rt.results, i, res)
+			if err := r.attachRoute(ctx, gw, dnsName, rt, ref, gwCfg); err != nil {
+				var rerr *routeError
+				if !errors.As(err, &rerr) {
+					return nil, err
+				}
+				res.err = rerr
+			}
+		}
+	}
+
+	for _, l := range gw.Spec.Listeners {
+		lr := gwCfg.listeners[l.Name]
+		if !lr.accepted() {
+			continue
+		}
+		port := uint16(l.Port)
+		hp := ipn.HostPort(net.JoinHostPort(dnsName, strconv.Itoa(int(port))))
+		switch l.Protocol {
+		case gatewayv1.HTTPProtocolType, gatewayv1.HTTPSProtocolType:
+			if len(lr.handlers) == 0 {
+				continue
+			}
+			h := &ipn.TCPPortHandler{HTTP: true}
+			if l.Protocol == gatewayv1.HTTPSProtocolType {
+				h = &ipn.TCPPortHandler{HTTPS: true}
+			}
+			mak.Set(&gwCfg.svc.TCP, port, h)
+			mak.Set(&gwCfg.svc.Web, hp, &ipn.WebServerConfig{Handlers: lr.handlers})
+		case gatewayv1.TCPProtocolType, gatewayv1.TLSProtocolType:
+			if lr.forward == "" {
+				continue
+			}
+			h := &ipn.TCPPortHandler{TCPForward: lr.forward, AllowFrom: lr.allowFrom}
+			if listenerTerminatesTLS(l) {
+				h.TerminateTLS = dnsName
+			}
+			mak.Set(&gwCfg.svc.TCP, port, h)
+		}
+	}
+	return gwCfg, nil
+}
+
+// listenerRouteKinds returns the route kinds that l accepts, or nil if l's
+// protocol is not supported. If l's allowedRoutes.kinds includes kinds that
+// it can't accept, it also returns a message saying so.
+func listenerRouteKinds(l gatewayv1.Listener) (_ []gatewayv1.RouteGroupKind, invalid string) {
+	var kind gatewayv1.Kind
+	switch l.Protocol {
+	case gatewayv1.HTTPProtocolType, gatewayv1.HTTPSProtocolType:
+		kind = kindHTTPRoute
+	case gatewayv1.TCPProtocolType:
+		kind = kindTCPRoute
+	case gatewayv1.TLSProtocolType:
+		kind = kindTLSRoute
+	default:
+		return nil, ""
+	}
+	supported := []gatewayv1.RouteGroupKind{{Group: ptr.To(gatewayv1.Group(gatewayv1.GroupName)), Kind: kind}}
+	if l.AllowedRoutes == nil || len(l.AllowedRoutes.Kinds) == 0 {
+		return supported, ""
+	}
+	var invalids []string
+	var found bool
+	for _, k := range l.AllowedRoutes.Kinds {
+		if (k.Group == nil || *k.Group == gatewayv1.GroupName) && k.Kind == kind {
+			found = true
+		} else {
+			invalids = append(invalids, string(k.Kind))
+		}
+	}
+	if len(invalids) > 0 {
+		invalid = fmt.Sprintf("%s listeners only support %s, not %s", l.Protocol, kind, strings.Join(invalids, ", "))
+	}
+	if !found {
+		return []gatewayv1.RouteGroupKind{}, invalid
+	}
+	return supported, invalid
+}
+
+// listenerTerminatesTLS reports whether l terminates TLS and so needs a TLS
+// certificate.
+func listenerTerminatesTLS(l gatewayv1.Listener) bool {
+	switch l.Protocol {
+	case gatewayv1.HTTPSProtocolType:
+		return true
+	case gatewayv1.TLSProtocolType:
+		return l.TLS != nil && l.TLS.Mode != nil && *l.TLS.Mode == gatewayv1.TLSModeTerminate
+	}
+	return false
+}
+
+// attachRoute attaches rt to the listeners of gw that ref, one of rt's
+// parentRefs, selects, adding its backends to their results in gwCfg. It
+// returns a *routeError if rt can't be attached.
+func (r *GatewayReconciler) attachRoute(ctx context.Context, gw *gatewayv1.Gateway, dnsName string, rt *gatewayRoute, ref gatewayv1.ParentReference, gwCfg *gatewayConfig) error {
+	if len(rt.hostnames) > 0 && !slices.Contains(rt.hostnames, gatewayv1.Hostname(dnsName)) {
+		return notAccepted(gatewayv1.RouteReasonNoMatchingListenerHostname, "hostnames must include %q, the MagicDNS name of the Gateway's Tailscale Service", dnsName)
+	}
+	var listeners []gatewayv1.Listener
+	var matched bool
+	for _, l := range gw.Spec.Listeners {
+		if ref.SectionName != nil && *ref.SectionName != l.Name {
+			continue
+		}
+		if ref.Port != nil && *ref.Port != l.Port {
+			continue
+		}
+		matched = true
+		lr := gwCfg.listeners[l.Name]
+		if !lr.accepted() || !slices.ContainsFunc(lr.supportedKinds, func(k gatewayv1.RouteGroupKind) bool { return k.Kind == rt.kind }) {
+			continue
+		}
+		allowed, err := r.listenerAllowsNamespace(ctx, gw, l, rt.GetNamespace())
+		if err != nil {
+			return err
+		}
+		if allowed {
+			listeners = append(listeners, l)
+		}
+	}
+	if !matched {
+		return notAccepted(gatewayv1.RouteReasonNoMatchingParent, "no listener of the Gateway matches the parentRef's sectionName and port")
+	}
+	if len(listeners) == 0 {
+		return notAccepted(gatewayv1.RouteReasonNotAllowedByListeners, "no listener of the Gateway allows %s in namespace %q", rt.kind, rt.GetNamespace())
+	}
+
+	if rt.kind == kindHTTPRoute {
+		handlers, err := r.httpHandlers(ctx, rt)
+		if err != nil {
+			return err
+		}
+		for _, l := range listeners {
+			lr := gwCfg.listeners[l.Name]
+			lr.attachedRoutes++
+			for path, h := range handlers {
+				// Older routes win conflicts.
+				if _, ok := lr.handlers[path]; !ok {
+					mak.Set(&lr.handlers, path, h)
+				}
+			}
+		}
+		return nil
+	}
+
+	if len(rt.backends) != 1 {
+		return notAccepted(gatewayv1.RouteReasonUnsupportedValue, "%s must have exactly one rule", rt.kind)
+	}
+	addr, port, err := r.backendAddr(ctx, rt.GetNamespace(), rt.backends[0])
+	if err != nil {
+		return err
+	}
+	allowFrom, err := tailnetAccessAllowFrom(ctx, r.Client, rt.GetNamespace(), string(rt.backends[0][0].Name), port, r.logger)
+	if err != nil {
+		return err
+	}
+	var attached bool
+	for _, l := range listeners {
+		lr := gwCfg.listeners[l.Name]
+		if lr.forward != "" {
+			continue // older routes win conflicts
+		}
+		lr.forward = addr
+		lr.allowFrom = allowFrom
+		lr.attachedRoutes++
+		attached = true
+	}
+	if !attached {
+		return notAccepted(gatewayv1.RouteReasonNotAllowedByListeners, "the listeners that allow this route already have an older %s attached", rt.kind)
+	}
+	return nil
+}
+
+// listenerAllowsNamespace reports whether l of gw allows routes in namespace
+// ns.
+func (r *GatewayReconciler) listenerAllowsNamespace(ctx context.Context, gw *gatewayv1.Gateway, l gatewayv1.Listener, ns string) (bool, error) {
+	from := gatewayv1.NamespacesFromSame
+	var selector *metav1.LabelSelector
+	if l.AllowedRoutes != nil && l.AllowedRoutes.Namespaces != nil {
+		if l.AllowedRoutes.Namespaces.From != nil {
+			from = *l.AllowedRoutes.Namespaces.From
+		}
+		selector = l.AllowedRoutes.Namespaces.Selector
+	}
+	switch from {
+	case gatewayv1.NamespacesFromAll:
+		return true, nil
+	case gatewayv1.NamespacesFromSame:
+		return ns == gw.Namespace, nil
+	case gatewayv1.NamespacesFromSelector:
+		if selector == nil {
+			return false, nil
+		}
+		sel, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return false, nil
+		}
+		n := new(corev1.Namespace)
+		if err := r.Get(ctx, client.ObjectKey{Name: ns}, n); err != nil {
+			return false, fmt.Errorf("error getting Namespace %q: %w", ns, err)
+		}
+		return sel.Matches(klabels.Set(n.Labels)), nil
+	}
+	return false, nil
+}
+
+// httpHandlers returns the serve config handlers for the HTTPRoute rt, by
+// path.
+func (r *GatewayReconciler) httpHandlers(ctx context.Context, rt *gatewayRoute) (map[string]*ipn.HTTPHandler, error) {
+	var handlers map[string]*ipn.HTTPHandler
+	for _, rule := range rt.httpRules {
+		if len(rule.Filters) > 0 {
+			return nil, notAccepted(gatewayv1.RouteReasonUnsupportedValue, "filters are not supported")
+		}
+		refs := make([]gatewayv1.BackendRef, 0, len(rule.BackendRefs))
+		for _, br := range rule.BackendRefs {
+			if len(br.Filters) > 0 {
+				return nil, notAccepted(gatewayv1.RouteReasonUnsupportedValue, "filters are not supported")
+			}
+			refs = append(refs, br.BackendRef)
+		}
+		addr, port, err := r.backendAddr(ctx, rt.GetNamespace(), refs)
+		if err != nil {
+			return nil, err
+		}
+		allowFrom, err := tailnetAccessAllowFrom(ctx, r.Client, rt.GetNamespace(), string(refs[0].Name), port, r.logger)
+		if err != nil {
+			return nil, err
+		}
+		proto := "http://"
+		if port == 443 {
+			proto = "https+insecure://"
+		}
+
+		paths := []string{"/"}
+		if len(rule.Matches) > 0 {
+			paths = paths[:0]
+		}
+		for _, m := range rule.Matches {
+			if len(m.Headers) > 0 || len(m.QueryParams) > 0 || m.Method != nil {
+				return nil, notAccepted(gatewayv1.RouteReasonUnsupportedValue, "only path matches are supported")
+			}
+			path := "/"
+			if m.Path != nil {
+				if m.Path.Type != nil && *m.Path.Type == gatewayv1.PathMatchRegularExpression {
+					return nil, notAccepted(gatewayv1.RouteReasonUnsupportedValue, "RegularExpression path matches are not supported")
+				}
+				// As for Ingresses, Exact matches are routed as
+				// PathPrefix matches.
+				if m.Path.Value != nil {
+					path = *m.Path.Value
+				}
+			}
+			paths = append(paths, path)
+		}
+		for _, path := range paths {
+			if _, ok := handlers[path]; !ok {
+				mak.Set(&handlers, path, &ipn.HTTPHandler{Proxy: proto + addr + path, AllowFrom: allowFrom})
+			}
+		}
+	}
+	return handlers, nil
+}
+
+// backendAddr returns the IP:port address of the single Service in refs,
+// which are the backendRefs of a route rule in namespace ns, and its port.
+func (r *GatewayReconciler) backendAddr(ctx context.Context, ns string, refs []gatewayv1.BackendRef) (addr string, port int32, _ error) {
+	if len(refs) != 1 {
+		return "", 0, notAccepted(gatewayv1.RouteReasonUnsupportedValue, "each rule must have exactly one backendRef")
+	}
+	ref := refs[0]
+	if (ref.Group != nil && *ref.Group != "") || (ref.Kind != nil && *ref.Kind != "Service") {
+		return "", 0, refsNotResolved(gatewayv1.RouteReasonInvalidKind, "backendRef must be a Service")
+	}
+	if ref.Namespace != nil && string(*ref.Namespace) != ns {
+		return "", 0, refsNotResolved(gatewayv1.RouteReasonRefNotPermitted, "backendRef must be in the route's namespace")
+	}
+	if ref.Port == nil {
+		return "", 0, refsNotResolved(gatewayv1.RouteReasonBackendNotFound, "backendRef to Service %q must have a port", ref.Name)
+	}
+	svc := new(corev1.Service)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: string(ref.Name)}, svc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", 0, refsNotResolved(gatewayv1.RouteReasonBackendNotFound, "Service %q not found", ref.Name)
+		}
+		return "", 0, fmt.Errorf("error getting Service %q: %w", ref.Name, err)
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		return "", 0, refsNotResolved(gatewayv1.RouteReasonBackendNotFound, "Service %q has no ClusterIP", ref.Name)
+	}
+	port = int32(*ref.Port)
+	return net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(int(port))), port, nil
+}
+
+// setListenerStatuses sets the status of gw's listeners from gwCfg.
+func (r *GatewayReconciler) setListenerStatuses(gw *gatewayv1.Gateway, gwCfg *gatewayConfig, programmed bool) {
+	old := gw.Status.Listeners
+	gw.Status.Listeners = make([]gatewayv1.ListenerStatus, 0, len(gw.Spec.Listeners))
+	for _, l := range gw.Spec.Listeners {
+		lr := gwCfg.listeners[l.Name]
+		ls := gatewayv1.ListenerStatus{
+			Name:           l.Name,
+			SupportedKinds: lr.supportedKinds,
+			AttachedRoutes: lr.attachedRoutes,
+		}
+		if ls.SupportedKinds == nil {
+			ls.SupportedKinds = []gatewayv1.RouteGroupKind{}
+		}
+		if i := slices.IndexFunc(old, func(s gatewayv1.ListenerStatus) bool { return s.Name == l.Name }); i >= 0 {
+			ls.Conditions = old[i].Conditions
+		}
+		set := func(typ gatewayv1.ListenerConditionType, ok bool, reason gatewayv1.ListenerConditionReason, msg string) {
+			status := metav1.ConditionFalse
+			if ok {
+				status = metav1.ConditionTrue
+			}
+			setGatewayAPICondition(&ls.Conditions, string(typ), status, string(reason), msg, gw.Generation, r.clock)
+		}
+
+		if lr.conflicted != "" {
+			set(gatewayv1.ListenerConditionConflicted, true, lr.conflicted, lr.msg)
+		} else {
+			set(gatewayv1.ListenerConditionConflicted, false, gatewayv1.ListenerReasonNoConflicts, "")
+		}
+		if lr.invalidKinds != "" {
+			set(gatewayv1.ListenerConditionResolvedRefs, false, gatewayv1.ListenerReasonInvalidRouteKinds, lr.invalidKinds)
+		} else {
+			set(gatewayv1.ListenerConditionResolvedRefs, true, gatewayv1.ListenerReasonResolvedRefs, "")
+		}
+		switch {
+		case lr.notAccepted != "":
+			set(gatewayv1.ListenerConditionAccepted, false, lr.notAccepted, lr.msg)
+			set(gatewayv1.ListenerConditionProgrammed, false, gatewayv1.ListenerReasonInvalid, lr.msg)
+		case lr.conflicted != "":
+			set(gatewayv1.ListenerConditionAccepted, false, gatewayv1.ListenerReasonPending, lr.msg)
+			set(gatewayv1.ListenerConditionProgrammed, false, gatewayv1.ListenerReasonInvalid, lr.msg)
+		case !programmed:
+			set(gatewayv1.ListenerConditionAccepted, true, gatewayv1.ListenerReasonAccepted, "")
+			set(gatewayv1.ListenerConditionProgrammed, false, gatewayv1.ListenerReasonPending, "waiting for ProxyGroup Pods to advertise the Tailscale Service")
+		default:
+			set(gatewayv1.ListenerConditionAccepted, true, gatewayv1.ListenerReasonAccepted, "")
+			set(gatewayv1.ListenerConditionProgrammed, true, gatewayv1.ListenerReasonProgrammed, "")
+		}
+		gw.Status.Listeners = append(gw.Status.Listeners, ls)
+	}
+}
+
+// updateRouteStatuses updates the status of routes for the results of
+// attaching them to the Gateway being reconciled.
+func (r *GatewayReconciler) updateRouteStatuses(ctx context.Context, routes []*gatewayRoute) error {
+	for _, rt := range routes {
+		old := rt.status.DeepCopy()
+		for i, res := range rt.results {
+			ref := rt.parentRefs[i]
+			ix := slices.IndexFunc(rt.status.Parents, func(p gatewayv1.RouteParentStatus) bool {
+				return p.ControllerName == gatewayControllerName && reflect.DeepEqual(p.ParentRef, ref)
+			})
+			if ix < 0 {
+				rt.status.Parents = append(rt.status.Parents, gatewayv1.RouteParentStatus{
+					ParentRef:      ref,
+					ControllerName: gatewayControllerName,
+				})
+				ix = len(rt.status.Parents) - 1
+			}
+			conds := &rt.status.Parents[ix].Conditions
+			accepted := func(ok bool, reason gatewayv1.RouteConditionReason, msg string) {
+				setGatewayAPICondition(conds, string(gatewayv1.RouteConditionAccepted), condStatus(ok), string(reason), msg, rt.GetGeneration(), r.clock)
+			}
+			resolved := func(ok bool, reason gatewayv1.RouteConditionReason, msg string) {
+				setGatewayAPICondition(conds, string(gatewayv1.RouteConditionResolvedRefs), condStatus(ok), string(reason), msg, rt.GetGeneration(), r.clock)
+			}
+			switch {
+			case res.err == nil:
+				accepted(true, gatewayv1.RouteReasonAccepted, "")
+				resolved(true, gatewayv1.RouteReasonResolvedRefs, "")
+			case res.err.condType == gatewayv1.RouteConditionResolvedRefs:
+				accepted(true, gatewayv1.RouteReasonAccepted, "")
+				resolved(false, res.err.reason, res.err.msg)
+			default:
+				accepted(false, res.err.reason, res.err.msg)
+				resolved(true, gatewayv1.RouteReasonResolvedRefs, "")
+			}
+		}
+		if err := r.maybeUpdateRouteStatus(ctx, rt, old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maybeUpdateRouteStatus updates the status of rt if it has changed from old.
+func (r *GatewayReconciler) maybeUpdateRouteStatus(ctx context.Context, rt *gatewayRoute, old *gatewayv1.RouteStatus) error {
+	if apiequality.Semantic.DeepEqual(old, rt.status) {
+		return nil
+	}
+	if err := r.Status().Update(ctx, rt.Object); err != nil {
+		return fmt.Errorf("failed to update %s %s status: %w", rt.kind, client.ObjectKeyFromObject(rt), err)
+	}
+	return nil
+}
+
+func condStatus(ok bool) metav1.ConditionStatus {
+	if ok {
+		return metav1.ConditionTrue
+	}
+	return metav1.ConditionFalse
+}
+
+// setGatewayAPICondition sets the condition of type typ in conds, keeping its
+// last transition time if its status hasn't changed.
+func setGatewayAPICondition(conds *[]metav1.Condition, typ string, status metav1.ConditionStatus, reason, msg string, gen int64, clock tstime.Clock) {
+	apimeta.SetStatusCondition(conds, metav1.Condition{
+		Type:               typ,
+		Status:             status,
+		Reason:             reason,
+		Message:            msg,
+		ObservedGeneration: gen,
+		LastTransitionTime: metav1.NewTime(clock.Now().Truncate(time.Second)),
+	})
+}
+
+// parentGatewaysOfRoute returns reconcile requests for the Gateways that the
+// route o refers to.
+func parentGatewaysOfRoute(o client.Object) []reconcile.Request {
+	var refs []gatewayv1.ParentReference
+	switch rt := o.(type) {
+	case *gatewayv1.HTTPRoute:
+		refs = rt.Spec.ParentRefs
+	case *gatewayv1alpha2.TCPRoute:
+		refs = rt.Spec.ParentRefs
+	case *gatewayv1alpha2.TLSRoute:
+		refs = rt.Spec.ParentRefs
+	}
+	var reqs []reconcile.Request
+	for _, ref := range refs {
+		if (ref.Group != nil && *ref.Group != gatewayv1.GroupName) || (ref.Kind != nil && *ref.Kind != kindGateway) {
+			continue
+		}
+		ns := o.GetNamespace()
+		if ref.Namespace != nil {
+			ns = string(*ref.Namespace)
+		}
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: ns, Name: string(ref.Name)}})
+	}
+	return reqs
+}
+
+// gatewaysFromRoute is an event handler for HTTPRoutes, TCPRoutes and
+// TLSRoutes. It returns reconcile requests for the Gateways that the route
+// refers to.
+func gatewaysFromRoute(_ context.Context, o client.Object) []reconcile.Request {
+	return parentGatewaysOfRoute(o)
+}
+
+// gatewaysFromBackendService is an event handler for Services. It returns
+// reconcile requests for the Gateways that HTTPRoutes, and TCPRoutes and
+// TLSRoutes if tcpRoutes and tlsRoutes, with a backendRef to the Service refer
+// to.
+func gatewaysFromBackendService(cl client.Client, logger *zap.SugaredLogger, tcpRoutes, tlsRoutes bool) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		refersToSvc := func(ref gatewayv1.BackendRef) bool {
+			return (ref.Kind == nil || *ref.Kind == "Service") && string(ref.Name) == o.GetName() &&
+				(ref.Namespace == nil || string(*ref.Namespace) == o.GetNamespace())
+		}
+		var reqs []reconcile.Request
+		hrs := new(gatewayv1.HTTPRouteList)
+		if err := cl.List(ctx, hrs, client.InNamespace(o.GetNamespace())); err != nil {
+			logger.Infof("error listing HTTPRoutes: %v, skipping a reconcile for event on Service %s", err, o.GetName())
+			return nil
+		}
+		for _, hr := range hrs.Items {
+			for _, rule := range hr.Spec.Rules {
+				if slices.ContainsFunc(rule.BackendRefs, func(br gatewayv1.HTTPBackendRef) bool { return refersToSvc(br.BackendRef) }) {
+					reqs = append(reqs, parentGatewaysOfRoute(&hr)...)
+					break
+				}
+			}
+		}
+		if tcpRoutes {
+			trs := new(gatewayv1alpha2.TCPRouteList)
+			if err := cl.List(ctx, trs, client.InNamespace(o.GetNamespace())); err != nil {
+				logger.Infof("error listing TCPRoutes: %v, skipping a reconcile for event on Service %s", err, o.GetName())
+				return nil
+			}
+			for _, tr := range trs.Items {
+				if slices.ContainsFunc(tr.Spec.Rules, func(rule gatewayv1alpha2.TCPRouteRule) bool {
+					return slices.ContainsFunc(rule.BackendRefs, refersToSvc)
+				}) {
+					reqs = append(reqs, parentGatewaysOfRoute(&tr)...)
+				}
+			}
+		}
+		if tlsRoutes {
+			trs := new(gatewayv1alpha2.TLSRouteList)
+			if err := cl.List(ctx, trs, client.InNamespace(o.GetNamespace())); err != nil {
+				logger.Infof("error listing TLSRoutes: %v, skipping a reconcile for event on Service %s", err, o.GetName())
+				return nil
+			}
+			for _, tr := range trs.Items {
+				if slices.ContainsFunc(tr.Spec.Rules, func(rule gatewayv1alpha2.TLSRouteRule) bool {
+					return slices.ContainsFunc(rule.BackendRefs, refersToSvc)
+				}) {
+					reqs = append(reqs, parentGatewaysOfRoute(&tr)...)
+				}
+			}
+		}
+		return reqs
+	}
+}
+
+// gatewaysFromGatewayClass is an event handler for GatewayClasses. It returns
+// reconcile requests for all Gateways of the GatewayClass.
+func gatewaysFromGatewayClass(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		gws := new(gatewayv1.GatewayList)
+		if err := cl.List(ctx, gws); err != nil {
+			logger.Infof("error listing Gateways: %v, skipping a reconcile for event on GatewayClass %s", err, o.GetName())
+			return nil
+		}
+		var reqs []reconcile.Request
+		for _, gw := range gws.Items {
+			if string(gw.Spec.GatewayClassName) == o.GetName() {
+				reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&gw)})
+			}
+		}
+		return reqs
+	}
+}
+
+// gatewaysFromSecret is an event handler for Secrets. It returns a reconcile
+// request for the Gateway whose ProxyGroup a TLS Secret or ProxyGroup state
+// Secret belongs to, if any.
+func gatewaysFromSecret(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		secret, ok := o.(*corev1.Secret)
+		if !ok {
+			logger.Infof("[unexpected] Secret handler triggered for an object that is not a Secret")
+			return nil
+		}
+		var pgName string
+		switch {
+		case isTLSSecret(secret):
+			pgName = secret.Labels[labelProxyGroup]
+		case isPGStateSecret(secret):
+			pgName = secret.Labels[LabelParentName]
+		default:
+			return nil
+		}
+		pg := new(tsapi.ProxyGroup)
+		if err := cl.Get(ctx, client.ObjectKey{Name: pgName}, pg); err != nil {
+			if !apierrors.IsNotFound(err) {
+				logger.Infof("error getting ProxyGroup %s: %v, skipping a reconcile for event on Secret %s", pgName, err, secret.Name)
+			}
+			return nil
+		}
+		if !isManagedByType(pg, "gateway") {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: parentFromObjectLabels(pg)}}
+	}
+}
+
+// gatewayClassesFromProxyClass is an event handler for ProxyClasses. It returns
+// reconcile requests for all GatewayClasses whose parametersRef refers to the
+// ProxyClass.
+func gatewayClassesFromProxyClass(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		gcs := new(gatewayv1.GatewayClassList)
+		if err := cl.List(ctx, gcs); err != nil {
+			logger.Infof("error listing GatewayClasses: %v, skipping a reconcile for event on ProxyClass %s", err, o.GetName())
+			return nil
+		}
+		var reqs []reconcile.Request
+		for _, gc := range gcs.Items {
+			if ref := gc.Spec.ParametersRef; ref != nil && ref.Kind == kindProxyClass && ref.Name == o.GetName() {
+				reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&gc)})
+			}
+		}
+		return reqs
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/types/ptr"
+)
+
+func TestGatewayClassReconciler(t *testing.T) {
+	gc := &gatewayv1.GatewayClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "tailscale", Generation: 1},
+		Spec: gatewayv1.GatewayClassSpec{
+			ControllerName: gatewayControllerName,
+			ParametersRef: &gatewayv1.ParametersReference{
+				Group: gatewayv1.Group(tsapi.SchemeGroupVersion.Group),
+				Kind:  kindProxyClass,
+				Name:  "pc",
+			},
+		},
+	}
+	var proxyClassErr error // if non-nil, returned by Gets of ProxyClasses
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(gc).
+		WithStatusSubresource(gc).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, cl client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if _, ok := obj.(*tsapi.ProxyClass); ok && proxyClassErr != nil {
+					return proxyClassErr
+				}
+				return cl.Get(ctx, key, obj, opts...)
+			},
+		}).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &GatewayClassReconciler{
+		Client: fc,
+		logger: zl.Sugar(),
+		clock:  tstest.NewClock(tstest.ClockOpts{}),
+	}
+
+	expectAccepted := func(want metav1.ConditionStatus, wantReason gatewayv1.GatewayClassConditionReason) {
+		t.Helper()
+		expectReconciled(t, r, "", "tailscale")
+		if err := fc.Get(t.Context(), client.ObjectKeyFromObject(gc), gc); err != nil {
+			t.Fatal(err)
+		}
+		cond := apimeta.FindStatusCondition(gc.Status.Conditions, string(gatewayv1.GatewayClassConditionStatusAccepted))
+		if cond == nil || cond.Status != want || cond.Reason != string(wantReason) {
+			t.Fatalf("Accepted condition = %+v, want status %s reason %s", cond, want, wantReason)
+		}
+	}
+
+	// The ProxyClass doesn't exist yet.
+	expectAccepted(metav1.ConditionFalse, gatewayv1.GatewayClassReasonInvalidParameters)
+
+	mustCreate(t, fc, &tsapi.ProxyClass{ObjectMeta: metav1.ObjectMeta{Name: "pc"}})
+	expectAccepted(metav1.ConditionTrue, gatewayv1.GatewayClassReasonAccepted)
+
+	// A transient error looking up the ProxyClass is returned so that the
+	// GatewayClass is requeued, and doesn't change the Accepted condition.
+	proxyClassErr = apierrors.NewServiceUnavailable("try again")
+	expectError(t, r, "", "tailscale")
+	proxyClassErr = nil
+	expectAccepted(metav1.ConditionTrue, gatewayv1.GatewayClassReasonAccepted)
+}
+
+func TestGatewayReconciler(t *testing.T) {
+	gr, fc, ft := setupGatewayTest(t)
+
+	gw := &gatewayv1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "gw",
+			Namespace:   "default",
+			UID:         types.UID("1234-UID"),
+			Generation:  1,
+			Annotations: map[string]string{AnnotationHostname: "my-svc"},
+		},
+		Spec: gatewayv1.GatewaySpec{
+			GatewayClassName: "tailscale",
+			Listeners: []gatewayv1.Listener{
+				{Name: "http", Port: 80, Protocol: gatewayv1.HTTPProtocolType},
+				{Name: "https", Port: 443, Protocol: gatewayv1.HTTPSProtocolType},
+				{Name: "db", Port: 5432, Protocol: gatewayv1.TCPProtocolType},
+				{Name: "other-host", Port: 8080, Protocol: gatewayv1.HTTPProtocolType, Hostname: ptr.To(gatewayv1.Hostname("foo.example.com"))},
+				{Name: "udp", Port: 53, Protocol: gatewayv1.UDPProtocolType},
+			},
+		},
+	}
+	parentRef := gatewayv1.ParentReference{Name: "gw"}
+	hr := &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Generation: 1},
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{ParentRefs: []gatewayv1.ParentReference{parentRef}},
+			Rules: []gatewayv1.HTTPRouteRule{
+				{
+					Matches: []gatewayv1.HTTPRouteMatch{{Path: &gatewayv1.HTTPPathMatch{Value: ptr.To("/api")}}},
+					BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: gatewayv1.BackendRef{
+						BackendObjectReference: gatewayv1.BackendObjectReference{Name: "api", Port: ptr.To(gatewayv1.PortNumber(8080))},
+					}}},
+				},
+				{
+					BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: gatewayv1.BackendRef{
+						BackendObjectReference: gatewayv1.BackendObjectReference{Name: "frontend", Port: ptr.To(gatewayv1.PortNumber(443))},
+					}}},
+				},
+			},
+		},
+	}
+	tr := &gatewayv1alpha2.TCPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", Generation: 1},
+		Spec: gatewayv1alpha2.TCPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{ParentRefs: []gatewayv1.ParentReference{{Name: "gw", SectionName: ptr.To(gatewayv1.SectionName("db"))}}},
+			Rules: []gatewayv1alpha2.TCPRouteRule{{
+				BackendRefs: []gatewayv1.BackendRef{{BackendObjectReference: gatewayv1.BackendObjectReference{Name: "postgres", Port: ptr.To(gatewayv1.PortNumber(5432))}}},
+			}},
+		},
+	}
+	mustCreateAll(t, fc, gw, hr, tr,
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"}, Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.1"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"}, Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.2"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "default"}, Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.3"}},
+	)
+
+	// The first reconcile creates the ProxyGroup, which isn't ready yet.
+	expectReconciled(t, gr, "default", "gw")
+	pg := &tsapi.ProxyGroup{}
+	if err := fc.Get(t.Context(), client.ObjectKey{Name: "default-gw"}, pg); err != nil {
+		t.Fatalf("getting ProxyGroup: %v", err)
+	}
+	if pg.Spec.Type != tsapi.ProxyGroupTypeIngress || !isGatewayProxyGroup(pg, gw) {
+		t.Fatalf("unexpected ProxyGroup %+v", pg)
+	}
+	expectGatewayCondition(t, fc, gw, gatewayv1.GatewayConditionProgrammed, metav1.ConditionFalse, gatewayv1.GatewayReasonPending)
+
+	markGatewayProxyGroupReady(t, fc, "default-gw")
+	expectReconciled(t, gr, "default", "gw")
+	populateTLSSecret(t, fc, "default-gw", "my-svc.ts.net")
+	expectReconciled(t, gr, "default", "gw")
+
+	verifyTailscaleService(t, ft, "svc:my-svc", []string{"tcp:80", "tcp:443", "tcp:5432"})
+	verifyTailscaledConfig(t, fc, "default-gw", []string{"svc:my-svc"})
+	svc := gatewayServeConfig(t, fc, "default-gw", "svc:my-svc")
+	if h := svc.TCP[443]; h == nil || !h.HTTPS {
+		t.Errorf("port 443 handler = %+v, want HTTPS", h)
+	}
+	if h := svc.TCP[5432]; h == nil || h.TCPForward != "10.0.0.3:5432" {
+		t.Errorf("port 5432 handler = %+v, want TCPForward to 10.0.0.3:5432", h)
+	}
+	web := svc.Web["my-svc.ts.net:80"]
+	if web == nil {
+		t.Fatalf("no web config for port 80: %+v", svc.Web)
+	}
+	for path, want := range map[string]string{
+		"/api": "http://10.0.0.1:8080/api",
+		"/":    "https+insecure://10.0.0.2:443/",
+	} {
+		if h := web.Handlers[path]; h == nil || h.Proxy != want {
+			t.Errorf("handler for %q = %+v, want proxy to %q", path, h, want)
+		}
+	}
+
+	// No Pods advertise the Tailscale Service yet.
+	expectGatewayCondition(t, fc, gw, gatewayv1.GatewayConditionAccepted, metav1.ConditionTrue, gatewayv1.GatewayReasonAccepted)
+	expectGatewayCondition(t, fc, gw, gatewayv1.GatewayConditionProgrammed, metav1.ConditionFalse, gatewayv1.GatewayReasonPending)
+	for name, want := range map[gatewayv1.SectionName]struct {
+		accepted metav1.ConditionStatus
+		reason   gatewayv1.ListenerConditionReason
+		routes   int32
+	}{
+		"http":       {metav1.ConditionTrue, gatewayv1.ListenerReasonAccepted, 1},
+		"https":      {metav1.ConditionTrue, gatewayv1.ListenerReasonAccepted, 1},
+		"db":         {metav1.ConditionTrue, gatewayv1.ListenerReasonAccepted, 1},
+		"other-host": {metav1.ConditionFalse, gatewayv1.ListenerReasonPending, 0},
+		"udp":        {metav1.ConditionFalse, gatewayv1.ListenerReasonUnsupportedProtocol, 0},
+	} {
+		ls := listenerStatus(gw, name)
+		if ls == nil {
+			t.Errorf("no status for listener %q", name)
+			continue
+		}
+		cond := apimeta.FindStatusCondition(ls.Conditions, string(gatewayv1.ListenerConditionAccepted))
+		if cond == nil || cond.Status != want.accepted || cond.Reason != string(want.reason) {
+			t.Errorf("listener %q Accepted condition = %+v, want status %s reason %s", name, cond, want.accepted, want.reason)
+		}
+		if ls.AttachedRoutes != want.routes {
+			t.Errorf("listener %q AttachedRoutes = %d, want %d", name, ls.AttachedRoutes, want.routes)
+		}
+	}
+	expectRouteAccepted(t, fc, hr, metav1.ConditionTrue, gatewayv1.RouteReasonAccepted)
+
+	mustCreate(t, fc, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default-gw-0",
+			Namespace: "operator-ns",
+			Labels:    pgSecretLabels("default-gw", kubetypes.LabelSecretTypeState),
+		},
+		Data: map[string][]byte{
+			"_current-profile": []byte("profile-foo"),
+			"profile-foo":      []byte(`{"AdvertiseServices":["svc:my-svc"],"Config":{"NodeID":"node-foo"}}`),
+		},
+	})
+	expectReconciled(t, gr, "default", "gw")
+	expectGatewayCondition(t, fc, gw, gatewayv1.GatewayConditionProgrammed, metav1.ConditionTrue, gatewayv1.GatewayReasonProgrammed)
+	if len(gw.Status.Addresses) != 1 || gw.Status.Addresses[0].Value != "my-svc.ts.net" {
+		t.Errorf("Gateway addresses = %+v, want my-svc.ts.net", gw.Status.Addresses)
+	}
+
+	// Routes that use unsupported features aren't accepted.
+	mustUpdate(t, fc, "default", "web", func(hr *gatewayv1.HTTPRoute) {
+		hr.Spec.Rules[0].Matches[0].Method = ptr.To(gatewayv1.HTTPMethodGet)
+	})
+	expectReconciled(t, gr, "default", "gw")
+	expectRouteAccepted(t, fc, hr, metav1.ConditionFalse, gatewayv1.RouteReasonUnsupportedValue)
+	svc = gatewayServeConfig(t, fc, "default-gw", "svc:my-svc")
+	if _, ok := svc.TCP[80]; ok {
+		t.Errorf("port 80 still served without an accepted HTTPRoute")
+	}
+
+	// Deleting the Gateway cleans up its ProxyGroup, Tailscale Service and
+	// route statuses.
+	if err := fc.Delete(t.Context(), gw); err != nil {
+		t.Fatal(err)
+	}
+	expectReconciled(t, gr, "default", "gw")
+	expectMissing[gatewayv1.Gateway](t, fc, "default", "gw")
+	expectMissing[tsapi.ProxyGroup](t, fc, "", "default-gw")
+	if tsSvc, err := ft.GetVIPService(t.Context(), "svc:my-svc"); err == nil && tsSvc != nil {
+		t.Errorf("Tailscale Service not deleted: %+v", tsSvc)
+	}
+	if err := fc.Get(t.Context(), client.ObjectKeyFromObject(tr), tr); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.Status.Parents) != 0 {
+		t.Errorf("TCPRoute parent statuses not cleaned up: %+v", tr.Status.Parents)
+	}
+}
+
+func TestGatewayReconciler_ProxyGroupConflict(t *testing.T) {
+	gr, fc, _ := setupGatewayTest(t)
+	mustCreate(t, fc, &tsapi.ProxyGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "default-gw"},
+		Spec:       tsapi.ProxyGroupSpec{Type: tsapi.ProxyGroupTypeIngress},
+	})
+	gw := &gatewayv1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "default"},
+		Spec: gatewayv1.GatewaySpec{
+			GatewayClassName: "tailscale",
+			Listeners:        []gatewayv1.Listener{{Name: "http", Port: 80, Protocol: gatewayv1.HTTPProtocolType}},
+		},
+	}
+	mustCreate(t, fc, gw)
+	expectReconciled(t, gr, "default", "gw")
+	expectGatewayCondition(t, fc, gw, gatewayv1.GatewayConditionAccepted, metav1.ConditionFalse, gatewayv1.GatewayReasonNoResources)
+}
+
+func setupGatewayTest(t *testing.T) (*GatewayReconciler, client.Client, *fakeTSClient) {
+	t.Helper()
+	gc := &gatewayv1.GatewayClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "tailscale"},
+		Spec:       gatewayv1.GatewayClassSpec{ControllerName: gatewayControllerName},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(gc).
+		WithStatusSubresource(&tsapi.ProxyGroup{}, &gatewayv1.Gateway{}, &gatewayv1.HTTPRoute{}, &gatewayv1alpha2.TCPRoute{}).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := &fakeTSClient{}
+	gr := &GatewayReconciler{
+		Client:      fc,
+		tsClient:    ft,
+		defaultTags: []string{"tag:k8s"},
+		tsNamespace: "operator-ns",
+		logger:      zl.Sugar(),
+		recorder:    record.NewFakeRecorder(10),
+		clock:       tstest.NewClock(tstest.ClockOpts{}),
+		lc: &fakeLocalClient{
+			status: &ipnstate.Status{
+				CurrentTailnet: &ipnstate.TailnetStatus{
+					MagicDNSSuffix: "ts.net",
+				},
+			},
+		},
+		tcpRoutes: true,
+	}
+	return gr, fc, ft
+}
+
+// markGatewayProxyGroupReady creates the serve config ConfigMap and config
+// Secret that the ProxyGroup reconciler would create for the ProxyGroup
+// pgName, and marks it available.
+func markGatewayProxyGroupReady(t *testing.T, fc client.Client, pgName string) {
+	t.Helper()
+	mustCreate(t, fc, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pgIngressCMName(pgName),
+			Namespace: "operator-ns",
+		},
+		BinaryData: map[string][]byte{
+			serveConfigKey: []byte(`{"Services":{}}`),
+		},
+	})
+	mustCreate(t, fc, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pgConfigSecretName(pgName, 0),
+			Namespace: "operator-ns",
+			Labels:    pgSecretLabels(pgName, kubetypes.LabelSecretTypeConfig),
+		},
+		Data: map[string][]byte{
+			tsoperator.TailscaledConfigFileName(pgMinCapabilityVersion): []byte("{}"),
+		},
+	})
+	mustUpdateStatus(t, fc, "", pgName, func(pg *tsapi.ProxyGroup) {
+		pg.Status.Conditions = []metav1.Condition{{
+			Type:               string(tsapi.ProxyGroupAvailable),
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: pg.Generation,
+		}}
+	})
+}
+
+func gatewayServeConfig(t *testing.T, fc client.Client, pgName, serviceName string) *ipn.ServiceConfig {
+	t.Helper()
+	cm := &corev1.ConfigMap{}
+	if err := fc.Get(t.Context(), client.ObjectKey{Namespace: "operator-ns", Name: pgIngressCMName(pgName)}, cm); err != nil {
+		t.Fatalf("getting ConfigMap: %v", err)
+	}
+	cfg := &ipn.ServeConfig{}
+	if err := json.Unmarshal(cm.BinaryData[serveConfigKey], cfg); err != nil {
+		t.Fatalf("unmarshaling serve config: %v", err)
+	}
+	svc := cfg.Services[tailcfg.ServiceName(serviceName)]
+	if svc == nil {
+		t.Fatalf("service %q not found in serve config: %+v", serviceName, cfg)
+	}
+	return svc
+}
+
+func expectGatewayCondition(t *testing.T, fc client.Client, gw *gatewayv1.Gateway, typ gatewayv1.GatewayConditionType, want metav1.ConditionStatus, wantReason gatewayv1.GatewayConditionReason) {
+	t.Helper()
+	if err := fc.Get(t.Context(), client.ObjectKeyFromObject(gw), gw); err != nil {
+		t.Fatal(err)
+	}
+	cond := apimeta.FindStatusCondition(gw.Status.Conditions, string(typ))
+	if cond == nil || cond.Status != want || cond.Reason != string(wantReason) {
+		t.Fatalf("Gateway %s condition = %+v, want status %s reason %s", typ, cond, want, wantReason)
+	}
+}
+
+func expectRouteAccepted(t *testing.T, fc client.Client, hr *gatewayv1.HTTPRoute, want metav1.ConditionStatus, wantReason gatewayv1.RouteConditionReason) {
+	t.Helper()
+	if err := fc.Get(t.Context(), client.ObjectKeyFromObject(hr), hr); err != nil {
+		t.Fatal(err)
+	}
+	if len(hr.Status.Parents) != 1 {
+		t.Fatalf("HTTPRoute parent statuses = %+v, want 1", hr.Status.Parents)
+	}
+	cond := apimeta.FindStatusCondition(hr.Status.Parents[0].Conditions, string(gatewayv1.RouteConditionAccepted))
+	if cond == nil || cond.Status != want || cond.Reason != string(wantReason) {
+		t.Fatalf("HTTPRoute Accepted condition = %+v, want status %s reason %s", cond, want, wantReason)
+	}
+}
+
+func listenerStatus(gw *gatewayv1.Gateway, name gatewayv1.SectionName) *gatewayv1.ListenerStatus {
+	for i := range gw.Status.Listeners {
+		if gw.Status.Listeners[i].Name == name {
+			return &gw.Status.Listeners[i]
+		}
+	}
+	return nil
+}
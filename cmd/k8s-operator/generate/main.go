@@ -20,20 +20,26 @@
 )
 
 const (
-	operatorDeploymentFilesPath   = "cmd/k8s-operator/deploy"
-	connectorCRDPath              = operatorDeploymentFilesPath + "/crds/tailscale.com_connectors.yaml"
-	proxyClassCRDPath             = operatorDeploymentFilesPath + "/crds/tailscale.com_proxyclasses.yaml"
-	dnsConfigCRDPath              = operatorDeploymentFilesPath + "/crds/tailscale.com_dnsconfigs.yaml"
-	recorderCRDPath               = operatorDeploymentFilesPath + "/crds/tailscale.com_recorders.yaml"
-	proxyGroupCRDPath             = operatorDeploymentFilesPath + "/crds/tailscale.com_proxygroups.yaml"
-	tailnetCRDPath                = operatorDeploymentFilesPath + "/crds/tailscale.com_tailnets.yaml"
-	helmTemplatesPath             = operatorDeploymentFilesPath + "/chart/templates"
-	connectorCRDHelmTemplatePath  = helmTemplatesPath + "/connector.yaml"
-	proxyClassCRDHelmTemplatePath = helmTemplatesPath + "/proxyclass.yaml"
-	dnsConfigCRDHelmTemplatePath  = helmTemplatesPath + "/dnsconfig.yaml"
-	recorderCRDHelmTemplatePath   = helmTemplatesPath + "/recorder.yaml"
-	proxyGroupCRDHelmTemplatePath = helmTemplatesPath + "/proxygroup.yaml"
-	tailnetCRDHelmTemplatePath    = helmTemplatesPath + "/tailnet.yaml"
+	operatorDeploymentFilesPath            = "cmd/k8s-operator/deploy"
+	connectorCRDPath                       = operatorDeploymentFilesPath + "/crds/tailscale.com_connectors.yaml"
+	proxyClassCRDPath                      = operatorDeploymentFilesPath + "/crds/tailscale.com_proxyclasses.yaml"
+	dnsConfigCRDPath                       = operatorDeploymentFilesPath + "/crds/tailscale.com_dnsconfigs.yaml"
+	recorderCRDPath                        = operatorDeploymentFilesPath + "/crds/tailscale.com_recorders.yaml"
+	proxyGroupCRDPath                      = operatorDeploymentFilesPath + "/crds/tailscale.com_proxygroups.yaml"
+	tailnetCRDPath                         = operatorDeploymentFilesPath + "/crds/tailscale.com_tailnets.yaml"
+	tailnetAccessPolicyCRDPath             = operatorDeploymentFilesPath + "/crds/tailscale.com_tailnetaccesspolicies.yaml"
+	serviceExportCRDPath                   = operatorDeploymentFilesPath + "/crds/tailscale.com_serviceexports.yaml"
+	serviceImportCRDPath                   = operatorDeploymentFilesPath + "/crds/tailscale.com_serviceimports.yaml"
+	helmTemplatesPath                      = operatorDeploymentFilesPath + "/chart/templates"
+	connectorCRDHelmTemplatePath           = helmTemplatesPath + "/connector.yaml"
+	proxyClassCRDHelmTemplatePath          = helmTemplatesPath + "/proxyclass.yaml"
+	dnsConfigCRDHelmTemplatePath           = helmTemplatesPath + "/dnsconfig.yaml"
+	recorderCRDHelmTemplatePath            = helmTemplatesPath + "/recorder.yaml"
+	proxyGroupCRDHelmTemplatePath          = helmTemplatesPath + "/proxygroup.yaml"
+	tailnetCRDHelmTemplatePath             = helmTemplatesPath + "/tailnet.yaml"
+	tailnetAccessPolicyCRDHelmTemplatePath = helmTemplatesPath + "/tailnetaccesspolicy.yaml"
+	serviceExportCRDHelmTemplatePath       = helmTemplatesPath + "/serviceexport.yaml"
+	serviceImportCRDHelmTemplatePath       = helmTemplatesPath + "/serviceimport.yaml"
 
 	helmConditionalStart = "{{ if .Values.installCRDs -}}\n"
 	helmConditionalEnd   = "{{- end -}}"
@@ -157,6 +163,9 @@ func generate(baseDir string) error {
 		{recorderCRDPath, recorderCRDHelmTemplatePath},
 		{proxyGroupCRDPath, proxyGroupCRDHelmTemplatePath},
 		{tailnetCRDPath, tailnetCRDHelmTemplatePath},
+		{tailnetAccessPolicyCRDPath, tailnetAccessPolicyCRDHelmTemplatePath},
+		{serviceExportCRDPath, serviceExportCRDHelmTemplatePath},
+		{serviceImportCRDPath, serviceImportCRDHelmTemplatePath},
 	} {
 		if err := addCRDToHelm(crd.crdPath, crd.templatePath); err != nil {
 			return fmt.Errorf("error adding %s CRD to Helm templates: %w", crd.crdPath, err)
@@ -173,6 +182,10 @@ func cleanup(baseDir string) error {
 		dnsConfigCRDHelmTemplatePath,
 		recorderCRDHelmTemplatePath,
 		proxyGroupCRDHelmTemplatePath,
+		tailnetCRDHelmTemplatePath,
+		tailnetAccessPolicyCRDHelmTemplatePath,
+		serviceExportCRDHelmTemplatePath,
+		serviceImportCRDHelmTemplatePath,
 	} {
 		if err := os.Remove(filepath.Join(baseDir, path)); err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("error cleaning up %s: %w", path, err)
@@ -656,7 +656,7 @@ func (r *HAIngressReconciler) shouldExpose(ing *networkingv1.Ingress) bool {
 // Currently validates:
 // - Any tags provided via tailscale.com/tags annotation are valid Tailscale ACL tags
 // - The derived hostname is a valid DNS label
-// - The referenced ProxyGroup exists and is of type 'ingress'
+// - The referenced ProxyGroup exists, is of type 'ingress' and is not a Gateway's
 // - Ingress' TLS block is invalid
 func (r *HAIngressReconciler) validateIngress(ctx context.Context, ing *networkingv1.Ingress, pg *tsapi.ProxyGroup) error {
 	var errs []error
@@ -689,6 +689,11 @@ func (r *HAIngressReconciler) validateIngress(ctx context.Context, ing *networki
 		errs = append(errs, fmt.Errorf("ProxyGroup %q is not ready", pg.Name))
 	}
 
+	// ProxyGroups created for Gateways serve only the Gateway.
+	if isManagedByType(pg, "gateway") {
+		errs = append(errs, fmt.Errorf("ProxyGroup %q is managed by Gateway %s and can't be used for Ingresses", pg.Name, parentFromObjectLabels(pg)))
+	}
+
 	// It is invalid to have multiple Ingress resources for the same Tailscale Service in one cluster.
 	ingList := &networkingv1.IngressList{}
 	if err := r.List(ctx, ingList); err != nil {
@@ -941,15 +946,15 @@ func ownersAreSetAndEqual(a, b *tailscale.VIPService) bool {
 		strings.EqualFold(a.Annotations[ownerAnnotation], b.Annotations[ownerAnnotation])
 }
 
-// ensureCertResources ensures that the TLS Secret for an HA Ingress and RBAC
+// ensureCertResources ensures that the TLS Secret for an HA Ingress or Gateway and RBAC
 // resources that allow proxies to manage the Secret are created.
 // Note that Tailscale Service's name validation matches Kubernetes
 // resource name validation, so we can be certain that the Tailscale Service name
 // (domain) is a valid Kubernetes resource name.
 // https://github.com/tailscale/tailscale/blob/8b1e7f646ee4730ad06c9b70c13e7861b964949b/util/dnsname/dnsname.go#L99
 // https://kubernetes.io/docs/concepts/overview/working-with-objects/names/#dns-subdomain-names
-func (r *HAIngressReconciler) ensureCertResources(ctx context.Context, pg *tsapi.ProxyGroup, domain string, ing *networkingv1.Ingress) error {
-	secret := certSecret(pg.Name, r.tsNamespace, domain, ing)
+func (r *HAIngressReconciler) ensureCertResources(ctx context.Context, pg *tsapi.ProxyGroup, domain string, parent client.Object) error {
+	secret := certSecret(pg.Name, r.tsNamespace, domain, parent)
 	if _, err := createOrUpdate(ctx, r.Client, r.tsNamespace, secret, func(s *corev1.Secret) {
 		// Labels might have changed if the Ingress has been updated to use a
 		// different ProxyGroup.
@@ -321,28 +321,28 @@ func validateIngressClass(ctx context.Context, cl client.Client, ingressClassNam
 }
 
 func handlersForIngress(ctx context.Context, ing *networkingv1.Ingress, cl client.Client, rec record.EventRecorder, tlsHost string, logger *zap.SugaredLogger) (handlers map[string]*ipn.HTTPHandler, err error) {
-	addIngressBackend := func(b *networkingv1.IngressBackend, path string) {
+	addIngressBackend := func(b *networkingv1.IngressBackend, path string) error {
 		if path == "" {
 			path = "/"
 			rec.Eventf(ing, corev1.EventTypeNormal, "PathUndefined", "configured backend is missing a path, defaulting to '/'")
 		}
 
 		if b == nil {
-			return
+			return nil
 		}
 
 		if b.Service == nil {
 			rec.Eventf(ing, corev1.EventTypeWarning, "InvalidIngressBackend", "backend for path %q is missing service", path)
-			return
+			return nil
 		}
 		var svc corev1.Service
 		if err := cl.Get(ctx, types.NamespacedName{Namespace: ing.Namespace, Name: b.Service.Name}, &svc); err != nil {
 			rec.Eventf(ing, corev1.EventTypeWarning, "InvalidIngressBackend", "failed to get service %q for path %q: %v", b.Service.Name, path, err)
-			return
+			return nil
 		}
 		if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == "None" {
 			rec.Eventf(ing, corev1.EventTypeWarning, "InvalidIngressBackend", "backend for path %q has invalid ClusterIP", path)
-			return
+			return nil
 		}
 		var port int32
 		if b.Service.Port.Name != "" {
@@ -357,17 +357,25 @@ func handlersForIngress(ctx context.Context, ing *networkingv1.Ingress, cl clien
 		}
 		if port == 0 {
 			rec.Eventf(ing, corev1.EventTypeWarning, "InvalidIngressBackend", "backend for path %q has invalid port", path)
-			return
+			return nil
 		}
 		proto := "http://"
 		if port == 443 || b.Service.Port.Name == "https" {
 			proto = "https+insecure://"
 		}
+		allowFrom, err := tailnetAccessAllowFrom(ctx, cl, ing.Namespace, svc.Name, port, logger)
+		if err != nil {
+			return err
+		}
 		mak.Set(&handlers, path, &ipn.HTTPHandler{
-			Proxy: proto + svc.Spec.ClusterIP + ":" + fmt.Sprint(port) + path,
+			Proxy:     proto + svc.Spec.ClusterIP + ":" + fmt.Sprint(port) + path,
+			AllowFrom: allowFrom,
 		})
+		return nil
+	}
+	if err := addIngressBackend(ing.Spec.DefaultBackend, "/"); err != nil {
+		return nil, err
 	}
-	addIngressBackend(ing.Spec.DefaultBackend, "/")
 	for _, rule := range ing.Spec.Rules {
 		// Host is optional, but if it's present it must match the TLS host
 		// otherwise we ignore the rule.
@@ -385,7 +393,9 @@ func handlersForIngress(ctx context.Context, ing *networkingv1.Ingress, cl clien
 				logger.Warnf(fmt.Sprintf("Unsupported Path type exact for path %s. %s", p.Path, msg))
 				rec.Eventf(ing, corev1.EventTypeWarning, "UnsupportedPathTypeExact", msg)
 			}
-			addIngressBackend(&p.Backend, p.Path)
+			if err := addIngressBackend(&p.Backend, p.Path); err != nil {
+				return nil, err
+			}
 		}
 	}
 	return handlers, nil
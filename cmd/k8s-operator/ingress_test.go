@@ -30,7 +30,7 @@
 )
 
 func TestTailscaleIngress(t *testing.T) {
-	fc := fake.NewFakeClient(ingressClass())
+	fc := fake.NewClientBuilder().WithScheme(tsapi.GlobalScheme).WithObjects(ingressClass()).Build()
 	ft := &fakeTSClient{}
 	fakeTsnetServer := &fakeTSNetServer{certDomains: []string{"foo.com"}}
 	zl, err := zap.NewDevelopment()
@@ -119,7 +119,7 @@ func TestTailscaleIngress(t *testing.T) {
 }
 
 func TestTailscaleIngressHostname(t *testing.T) {
-	fc := fake.NewFakeClient(ingressClass())
+	fc := fake.NewClientBuilder().WithScheme(tsapi.GlobalScheme).WithObjects(ingressClass()).Build()
 	ft := &fakeTSClient{}
 	fakeTsnetServer := &fakeTSNetServer{certDomains: []string{"foo.com"}}
 	zl, err := zap.NewDevelopment()
@@ -697,7 +697,7 @@ func TestEmptyPath(t *testing.T) {
 
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
-			fc := fake.NewFakeClient(ingressClass())
+			fc := fake.NewClientBuilder().WithScheme(tsapi.GlobalScheme).WithObjects(ingressClass()).Build()
 			ft := &fakeTSClient{}
 			fr := record.NewFakeRecorder(3) // bump this if you expect a test case to throw more events
 			fakeTsnetServer := &fakeTSNetServer{certDomains: []string{"foo.com"}}
@@ -847,7 +847,7 @@ func backend() *networkingv1.IngressBackend {
 }
 
 func TestTailscaleIngressWithHTTPRedirect(t *testing.T) {
-	fc := fake.NewFakeClient(ingressClass())
+	fc := fake.NewClientBuilder().WithScheme(tsapi.GlobalScheme).WithObjects(ingressClass()).Build()
 	ft := &fakeTSClient{}
 	fakeTsnetServer := &fakeTSNetServer{certDomains: []string{"foo.com"}}
 	zl, err := zap.NewDevelopment()
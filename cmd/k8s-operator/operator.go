@@ -31,6 +31,7 @@
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	klabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	toolscache "k8s.io/client-go/tools/cache"
@@ -45,6 +46,8 @@
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
 
 	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale"
@@ -80,6 +83,13 @@ func main() {
 	// client lives in the same repo as this code.
 	tailscale.I_Acknowledge_This_API_Is_Unstable = true
 
+	// The operator image also runs the recordings sidecar of Recorders
+	// that use PVC storage.
+	if len(os.Args) > 1 && os.Args[1] == "recordings" {
+		runRecordings()
+		return
+	}
+
 	var (
 		tsNamespace           = defaultEnv("OPERATOR_NAMESPACE", "")
 		tslogging             = defaultEnv("OPERATOR_LOGGING", "info")
@@ -92,6 +102,8 @@ func main() {
 		isDefaultLoadBalancer = defaultBool("OPERATOR_DEFAULT_LOAD_BALANCER", false)
 		loginServer           = strings.TrimSuffix(defaultEnv("OPERATOR_LOGIN_SERVER", ""), "/")
 		ingressClassName      = defaultEnv("OPERATOR_INGRESS_CLASS_NAME", "tailscale")
+		operatorImage         = defaultEnv("OPERATOR_IMAGE", "")
+		operatorSA            = defaultEnv("OPERATOR_SERVICE_ACCOUNT", "operator")
 	)
 
 	var opts []kzap.Opts
@@ -162,6 +174,8 @@ func main() {
 		defaultProxyClass:             defaultProxyClass,
 		loginServer:                   loginServer,
 		ingressClassName:              ingressClassName,
+		operatorImage:                 operatorImage,
+		operatorUser:                  fmt.Sprintf("system:serviceaccount:%s:%s", tsNamespace, operatorSA),
 	}
 	runReconcilers(rOpts)
 }
@@ -317,6 +331,10 @@ func runReconcilers(opts reconcilerOpts) {
 				&rbacv1.Role{}:                              nsFilter,
 				&rbacv1.RoleBinding{}:                       nsFilter,
 				&apiextensionsv1.CustomResourceDefinition{}: serviceMonitorSelector,
+				// ClusterRoleBindings are only created for Recorders.
+				&rbacv1.ClusterRoleBinding{}: {
+					Label: klabels.SelectorFromSet(klabels.Set{"app.kubernetes.io/managed-by": "tailscale-operator"}),
+				},
 			},
 		},
 		Scheme: tsapi.GlobalScheme,
@@ -386,14 +404,28 @@ func runReconcilers(opts reconcilerOpts) {
 	proxyClassFilterForIngress := handler.EnqueueRequestsFromMapFunc(proxyClassHandlerForIngress(mgr.GetClient(), startlog))
 	// Enque Ingress if a managed Service or backend Service associated with a tailscale Ingress changes.
 	svcHandlerForIngress := handler.EnqueueRequestsFromMapFunc(serviceHandlerForIngress(mgr.GetClient(), startlog, opts.ingressClassName))
-	err = builder.
+	// If a TailnetAccessPolicy changes, enqueue all Ingresses in its
+	// namespace, as their backends' access may have changed.
+	tapFilterForIngress := handler.EnqueueRequestsFromMapFunc(ingressesFromTailnetAccessPolicy(mgr.GetClient(), startlog))
+	// The TailnetAccessPolicy CRD may not be installed if CRDs are managed
+	// separately from the operator, in which case there are no policies to
+	// watch.
+	taps := hasKind(mgr, tsapi.SchemeGroupVersion.WithKind("TailnetAccessPolicy"))
+	if !taps {
+		startlog.Infof("TailnetAccessPolicy CRD is not installed, not watching TailnetAccessPolicies")
+	}
+	ingressBuilder := builder.
 		ControllerManagedBy(mgr).
 		For(&networkingv1.Ingress{}).
 		Named("ingress-reconciler").
 		Watches(&appsv1.StatefulSet{}, ingressChildFilter).
 		Watches(&corev1.Secret{}, ingressChildFilter).
 		Watches(&corev1.Service{}, svcHandlerForIngress).
-		Watches(&tsapi.ProxyClass{}, proxyClassFilterForIngress).
+		Watches(&tsapi.ProxyClass{}, proxyClassFilterForIngress)
+	if taps {
+		ingressBuilder = ingressBuilder.Watches(&tsapi.TailnetAccessPolicy{}, tapFilterForIngress)
+	}
+	err = ingressBuilder.
 		Complete(&IngressReconciler{
 			ssr:               ssr,
 			recorder:          eventRecorder,
@@ -418,13 +450,17 @@ func runReconcilers(opts reconcilerOpts) {
 		startlog.Fatalf("error determining stable ID of the operator's Tailscale device: %v", err)
 	}
 	ingressProxyGroupFilter := handler.EnqueueRequestsFromMapFunc(ingressesFromIngressProxyGroup(mgr.GetClient(), opts.log))
-	err = builder.
+	ingressPGBuilder := builder.
 		ControllerManagedBy(mgr).
 		For(&networkingv1.Ingress{}).
 		Named("ingress-pg-reconciler").
 		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(serviceHandlerForIngressPG(mgr.GetClient(), startlog, opts.ingressClassName))).
 		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(HAIngressesFromSecret(mgr.GetClient(), startlog))).
-		Watches(&tsapi.ProxyGroup{}, ingressProxyGroupFilter).
+		Watches(&tsapi.ProxyGroup{}, ingressProxyGroupFilter)
+	if taps {
+		ingressPGBuilder = ingressPGBuilder.Watches(&tsapi.TailnetAccessPolicy{}, tapFilterForIngress)
+	}
+	err = ingressPGBuilder.
 		Complete(&HAIngressReconciler{
 			recorder:         eventRecorder,
 			tsClient:         opts.tsClient,
@@ -470,6 +506,66 @@ func runReconcilers(opts reconcilerOpts) {
 		startlog.Fatalf("failed setting up indexer for HA Services: %v", err)
 	}
 
+	// Gateway API support is only enabled if the Gateway API CRDs are
+	// installed. TCPRoutes and TLSRoutes are in the experimental channel, so
+	// may not be.
+	gatewayAPI := hasKind(mgr, gatewayv1.SchemeGroupVersion.WithKind(string(kindGateway)))
+	tcpRoutes := gatewayAPI && hasKind(mgr, gatewayv1alpha2.SchemeGroupVersion.WithKind(string(kindTCPRoute)))
+	tlsRoutes := gatewayAPI && hasKind(mgr, gatewayv1alpha2.SchemeGroupVersion.WithKind(string(kindTLSRoute)))
+	if gatewayAPI {
+		err = builder.
+			ControllerManagedBy(mgr).
+			For(&gatewayv1.GatewayClass{}).
+			Named("gatewayclass-reconciler").
+			Watches(&tsapi.ProxyClass{}, handler.EnqueueRequestsFromMapFunc(gatewayClassesFromProxyClass(mgr.GetClient(), startlog))).
+			Complete(&GatewayClassReconciler{
+				Client: mgr.GetClient(),
+				logger: opts.log.Named("gatewayclass-reconciler"),
+				clock:  tstime.DefaultClock{},
+			})
+		if err != nil {
+			startlog.Fatalf("could not create gatewayclass-reconciler: %v", err)
+		}
+
+		routeFilter := handler.EnqueueRequestsFromMapFunc(gatewaysFromRoute)
+		gatewayBuilder := builder.
+			ControllerManagedBy(mgr).
+			For(&gatewayv1.Gateway{}).
+			Named("gateway-reconciler").
+			Watches(&gatewayv1.GatewayClass{}, handler.EnqueueRequestsFromMapFunc(gatewaysFromGatewayClass(mgr.GetClient(), startlog))).
+			Watches(&gatewayv1.HTTPRoute{}, routeFilter).
+			Watches(&tsapi.ProxyGroup{}, handler.EnqueueRequestsFromMapFunc(managedResourceHandlerForType("gateway"))).
+			Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(gatewaysFromSecret(mgr.GetClient(), startlog))).
+			Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(gatewaysFromBackendService(mgr.GetClient(), startlog, tcpRoutes, tlsRoutes)))
+		if taps {
+			gatewayBuilder = gatewayBuilder.Watches(&tsapi.TailnetAccessPolicy{}, handler.EnqueueRequestsFromMapFunc(gatewaysFromTailnetAccessPolicy(mgr.GetClient(), startlog)))
+		}
+		if tcpRoutes {
+			gatewayBuilder = gatewayBuilder.Watches(&gatewayv1alpha2.TCPRoute{}, routeFilter)
+		}
+		if tlsRoutes {
+			gatewayBuilder = gatewayBuilder.Watches(&gatewayv1alpha2.TLSRoute{}, routeFilter)
+		}
+		err = gatewayBuilder.Complete(&GatewayReconciler{
+			recorder:    eventRecorder,
+			tsClient:    opts.tsClient,
+			defaultTags: strings.Split(opts.proxyTags, ","),
+			Client:      mgr.GetClient(),
+			logger:      opts.log.Named("gateway-reconciler"),
+			lc:          lc,
+			clock:       tstime.DefaultClock{},
+			operatorID:  id,
+			tsNamespace: opts.tailscaleNamespace,
+			tcpRoutes:   tcpRoutes,
+			tlsRoutes:   tlsRoutes,
+		})
+		if err != nil {
+			startlog.Fatalf("could not create gateway-reconciler: %v", err)
+		}
+	} else {
+		startlog.Infof("Gateway API CRDs not installed, Gateway API support is disabled")
+	}
+
 	connectorFilter := handler.EnqueueRequestsFromMapFunc(managedResourceHandlerForType("connector"))
 	// If a ProxyClassChanges, enqueue all Connectors that have
 	// .spec.proxyClass set to the name of this ProxyClass.
@@ -608,6 +704,79 @@ func runReconcilers(opts reconcilerOpts) {
 	if err != nil {
 		startlog.Fatal("could not create proxyclass reconciler: %v", err)
 	}
+	if taps {
+		// A TailnetAccessPolicy's targets may be exposed in new ways, or
+		// served by new proxies, whenever a Service or an Ingress in its
+		// namespace changes.
+		tapFilter := handler.EnqueueRequestsFromMapFunc(tailnetAccessPoliciesInNamespace(mgr.GetClient(), startlog))
+		err = builder.ControllerManagedBy(mgr).
+			For(&tsapi.TailnetAccessPolicy{}).
+			Named("tailnetaccesspolicy-reconciler").
+			Watches(&corev1.Service{}, tapFilter).
+			Watches(&networkingv1.Ingress{}, tapFilter).
+			Complete(&TailnetAccessPolicyReconciler{
+				Client:                mgr.GetClient(),
+				recorder:              eventRecorder,
+				logger:                opts.log.Named("tailnetaccesspolicy-reconciler"),
+				clock:                 tstime.DefaultClock{},
+				tsNamespace:           opts.tailscaleNamespace,
+				isDefaultLoadBalancer: opts.proxyActAsDefaultLoadBalancer,
+				gatewayAPI:            gatewayAPI,
+				tcpRoutes:             tcpRoutes,
+				tlsRoutes:             tlsRoutes,
+			})
+		if err != nil {
+			startlog.Fatalf("could not create tailnetaccesspolicy reconciler: %v", err)
+		}
+	}
+	err = builder.ControllerManagedBy(mgr).
+		For(&tsapi.ServiceExport{}).
+		Named("serviceexport-reconciler").
+		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(serviceExportsFromService)).
+		Watches(&discoveryv1.EndpointSlice{}, handler.EnqueueRequestsFromMapFunc(serviceExportsFromEndpointSlice)).
+		Watches(&tsapi.ProxyGroup{}, handler.EnqueueRequestsFromMapFunc(serviceExportsFromProxyGroup(mgr.GetClient(), startlog))).
+		Complete(&ServiceExportReconciler{
+			Client:      mgr.GetClient(),
+			recorder:    eventRecorder,
+			logger:      opts.log.Named("serviceexport-reconciler"),
+			clock:       tstime.DefaultClock{},
+			tsClient:    opts.tsClient,
+			lc:          lc,
+			tsNamespace: opts.tailscaleNamespace,
+			operatorID:  id,
+		})
+	if err != nil {
+		startlog.Fatalf("could not create serviceexport reconciler: %v", err)
+	}
+	err = builder.ControllerManagedBy(mgr).
+		For(&tsapi.ServiceImport{}).
+		Named("serviceimport-reconciler").
+		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(serviceImportsFromService)).
+		Watches(&tsapi.ProxyGroup{}, handler.EnqueueRequestsFromMapFunc(serviceImportsFromProxyGroup(mgr.GetClient(), startlog))).
+		Complete(&ServiceImportReconciler{
+			Client:      mgr.GetClient(),
+			recorder:    eventRecorder,
+			logger:      opts.log.Named("serviceimport-reconciler"),
+			clock:       tstime.DefaultClock{},
+			tsClient:    opts.tsClient,
+			lc:          lc,
+			tsNamespace: opts.tailscaleNamespace,
+		})
+	if err != nil {
+		startlog.Fatalf("could not create serviceimport reconciler: %v", err)
+	}
+	err = builder.ControllerManagedBy(mgr).
+		For(&tsapi.ProxyGroup{}).
+		Named("serviceimport-discovery-reconciler").
+		Complete(&ServiceImportDiscoveryReconciler{
+			Client:   mgr.GetClient(),
+			logger:   opts.log.Named("serviceimport-discovery-reconciler"),
+			clock:    tstime.DefaultClock{},
+			tsClient: opts.tsClient,
+		})
+	if err != nil {
+		startlog.Fatalf("could not create serviceimport-discovery reconciler: %v", err)
+	}
 	logger := startlog.Named("dns-records-reconciler-event-handlers")
 	// On EndpointSlice events, if it is an EndpointSlice for an
 	// ingress/egress proxy headless Service, reconcile the headless
@@ -647,14 +816,19 @@ func runReconcilers(opts reconcilerOpts) {
 		Watches(&corev1.Secret{}, recorderFilter).
 		Watches(&rbacv1.Role{}, recorderFilter).
 		Watches(&rbacv1.RoleBinding{}, recorderFilter).
+		Watches(&rbacv1.ClusterRoleBinding{}, recorderFilter).
 		Complete(&RecorderReconciler{
-			recorder:    eventRecorder,
-			tsNamespace: opts.tailscaleNamespace,
-			Client:      mgr.GetClient(),
-			log:         opts.log.Named("recorder-reconciler"),
-			clock:       tstime.DefaultClock{},
-			tsClient:    opts.tsClient,
-			loginServer: opts.loginServer,
+			recorder:            eventRecorder,
+			tsNamespace:         opts.tailscaleNamespace,
+			Client:              mgr.GetClient(),
+			log:                 opts.log.Named("recorder-reconciler"),
+			clock:               tstime.DefaultClock{},
+			tsClient:            opts.tsClient,
+			loginServer:         opts.loginServer,
+			httpClient:          &http.Client{Timeout: 10 * time.Second},
+			recordingsImage:     opts.operatorImage,
+			recordingsUser:      opts.operatorUser,
+			recordingsTokenPath: recordingsTokenPath,
 		})
 	if err != nil {
 		startlog.Fatalf("could not create Recorder reconciler: %v", err)
@@ -772,6 +946,12 @@ type reconcilerOpts struct {
 	// ingressClassName is the name of the ingress class used by reconcilers of Ingress resources. This defaults
 	// to "tailscale" but can be customised.
 	ingressClassName string
+	// operatorImage is the operator's own image, <operator-image-repo>:<operator-image-tag>,
+	// which also runs the recordings sidecar of Recorders with PVC storage.
+	operatorImage string
+	// operatorUser is the Kubernetes username of the operator's ServiceAccount,
+	// which the recordings sidecar of Recorders with PVC storage authenticates.
+	operatorUser string
 }
 
 // enqueueAllIngressEgressProxySvcsinNS returns a reconcile request for each
@@ -1617,6 +1797,13 @@ func podsFromEgressEps(cl client.Client, logger *zap.SugaredLogger, ns string) h
 	}
 }
 
+// hasKind reports whether the API server serves gvk, for example because
+// the CRD for it is installed.
+func hasKind(mgr manager.Manager, gvk schema.GroupVersionKind) bool {
+	_, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	return err == nil
+}
+
 // proxyClassesWithServiceMonitor returns an event handler that, given that the event is for the Prometheus
 // ServiceMonitor CRD, returns all ProxyClasses that define that a ServiceMonitor should be created.
 func proxyClassesWithServiceMonitor(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
@@ -34,6 +34,7 @@
 
 	"tailscale.com/client/tailscale"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/kubestore"
 	tsoperator "tailscale.com/k8s-operator"
 	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
 	"tailscale.com/kube/egressservices"
@@ -358,7 +359,13 @@ func (r *ProxyGroupReconciler) maybeProvision(ctx context.Context, tailscaleClie
 		}
 	}
 
-	role := pgRole(pg, r.tsNamespace)
+	// Keep granting access to shard Secrets after state sharding has been
+	// disabled, so that the proxies can move their state back out of them.
+	shards := &corev1.SecretList{}
+	if err := r.List(ctx, shards, client.InNamespace(r.tsNamespace), client.MatchingLabels(pgSecretLabels(pg.Name, kubetypes.LabelSecretTypeStateShard))); err != nil {
+		return r.notReadyErrf(pg, logger, "error listing state shard Secrets: %w", err)
+	}
+	role := pgRole(pg, r.tsNamespace, pg.Spec.StateSharding || len(shards.Items) > 0)
 	if _, err := createOrUpdate(ctx, r.Client, r.tsNamespace, role, func(r *rbacv1.Role) {
 		r.ObjectMeta.Labels = role.ObjectMeta.Labels
 		r.ObjectMeta.Annotations = role.ObjectMeta.Annotations
@@ -641,7 +648,7 @@ func (r *ProxyGroupReconciler) cleanupDanglingResources(ctx context.Context, tai
 			continue
 		}
 
-		// Dangling resource, delete the config + state Secrets, as well as
+		// Dangling resource, delete the config, state and shard Secrets, as well as
 		// deleting the device from the tailnet.
 		if err := r.deleteTailnetDevice(ctx, tailscaleClient, m.tsID, logger); err != nil {
 			return err
@@ -654,6 +661,17 @@ func (r *ProxyGroupReconciler) cleanupDanglingResources(ctx context.Context, tai
 		if err := r.Delete(ctx, configSecret); err != nil && !apierrors.IsNotFound(err) {
 			return fmt.Errorf("error deleting config Secret %q: %w", configSecret.Name, err)
 		}
+		for _, name := range kubestore.ShardSecretNames(m.stateSecret.Name) {
+			shard := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: m.stateSecret.Namespace,
+				},
+			}
+			if err := r.Delete(ctx, shard); err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("error deleting state shard Secret %q: %w", name, err)
+			}
+		}
 		// NOTE(ChaosInTheCRD): we shouldn't need to get the service first, checking for a not found error should be enough
 		svc := &corev1.Service{
 			ObjectMeta: metav1.ObjectMeta{
@@ -18,6 +18,7 @@
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/yaml"
+	"tailscale.com/ipn/store/kubestore"
 	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
 	"tailscale.com/kube/egressservices"
 	"tailscale.com/kube/ingressservices"
@@ -206,6 +207,13 @@ func pgStatefulSet(pg *tsapi.ProxyGroup, namespace, image, tsFirewallMode string
 			})
 		}
 
+		if pg.Spec.StateSharding {
+			envs = append(envs, corev1.EnvVar{
+				Name:  "TS_KUBE_STATE_SHARDING",
+				Value: "true",
+			})
+		}
+
 		if pg.Spec.Type == tsapi.ProxyGroupTypeEgress {
 			envs = append(envs,
 				// TODO(irbekrm): in 1.80 we deprecated TS_EGRESS_SERVICES_CONFIG_PATH in favour of
@@ -402,7 +410,9 @@ func pgServiceAccount(pg *tsapi.ProxyGroup, namespace string) *corev1.ServiceAcc
 	}
 }
 
-func pgRole(pg *tsapi.ProxyGroup, namespace string) *rbacv1.Role {
+// pgRole returns the Role for the ProxyGroup's proxies. If stateShards is
+// true, it grants access to the shard Secrets of the proxies' state Secrets.
+func pgRole(pg *tsapi.ProxyGroup, namespace string, stateShards bool) *rbacv1.Role {
 	return &rbacv1.Role{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            pg.Name,
@@ -433,6 +443,9 @@ func pgRole(pg *tsapi.ProxyGroup, namespace string) *rbacv1.Role {
 							pgConfigSecretName(pg.Name, i), // Config with auth key.
 							pgPodName(pg.Name, i),          // State.
 						)
+						if stateShards {
+							secrets = append(secrets, kubestore.ShardSecretNames(pgStateSecretName(pg.Name, i))...)
+						}
 					}
 					return secrets
 				}(),
@@ -493,6 +506,8 @@ func isAuthAPIServerProxy(pg *tsapi.ProxyGroup) bool {
 		*pg.Spec.KubeAPIServer.Mode == tsapi.APIServerProxyModeAuth
 }
 
+// pgStateSecrets returns the state Secrets of the ProxyGroup's proxies and,
+// if state sharding is enabled, their shard Secrets.
 func pgStateSecrets(pg *tsapi.ProxyGroup, namespace string) (secrets []*corev1.Secret) {
 	for i := range pgReplicas(pg) {
 		secrets = append(secrets, &corev1.Secret{
@@ -503,6 +518,19 @@ func pgStateSecrets(pg *tsapi.ProxyGroup, namespace string) (secrets []*corev1.S
 				OwnerReferences: pgOwnerReference(pg),
 			},
 		})
+		if !pg.Spec.StateSharding {
+			continue
+		}
+		for _, name := range kubestore.ShardSecretNames(pgStateSecretName(pg.Name, i)) {
+			secrets = append(secrets, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            name,
+					Namespace:       namespace,
+					Labels:          pgSecretLabels(pg.Name, kubetypes.LabelSecretTypeStateShard),
+					OwnerReferences: pgOwnerReference(pg),
+				},
+			})
+		}
 	}
 
 	return secrets
@@ -535,7 +563,7 @@ func pgIngressCM(pg *tsapi.ProxyGroup, namespace string) *corev1.ConfigMap {
 
 func pgSecretLabels(pgName, secretType string) map[string]string {
 	return pgLabels(pgName, map[string]string{
-		kubetypes.LabelSecretType: secretType, // "config" or "state".
+		kubetypes.LabelSecretType: secretType, // "config", "state" or "state-shard".
 	})
 }
 
@@ -28,6 +28,7 @@
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/kubestore"
 	kube "tailscale.com/k8s-operator"
 	tsoperator "tailscale.com/k8s-operator"
 	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
@@ -1021,6 +1022,96 @@ func TestProxyGroup(t *testing.T) {
 	})
 }
 
+func TestProxyGroupStateSharding(t *testing.T) {
+	pg := &tsapi.ProxyGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test",
+			UID:        "test-uid",
+			Finalizers: []string{"tailscale.com/finalizer"},
+		},
+		Spec: tsapi.ProxyGroupSpec{
+			Type:          tsapi.ProxyGroupTypeEgress,
+			StateSharding: true,
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(pg).
+		WithStatusSubresource(pg).
+		Build()
+	zl, _ := zap.NewDevelopment()
+	reconciler := &ProxyGroupReconciler{
+		tsNamespace:  tsNamespace,
+		tsProxyImage: testProxyImage,
+		Client:       fc,
+		log:          zl.Sugar(),
+		tsClient:     &fakeTSClient{},
+		clock:        tstest.NewClock(tstest.ClockOpts{}),
+	}
+	expectShards := func(t *testing.T, replicas ...int32) {
+		t.Helper()
+		var want []string
+		for _, i := range replicas {
+			want = append(want, kubestore.ShardSecretNames(pgStateSecretName(pg.Name, i))...)
+		}
+		secrets := &corev1.SecretList{}
+		if err := fc.List(t.Context(), secrets, client.MatchingLabels(pgSecretLabels(pg.Name, kubetypes.LabelSecretTypeStateShard))); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range secrets.Items {
+			if diff := cmp.Diff(s.OwnerReferences, pgOwnerReference(pg)); diff != "" {
+				t.Errorf("unexpected owner references of %s (-got +want):\n%s", s.Name, diff)
+			}
+			got = append(got, s.Name)
+		}
+		slices.Sort(got)
+		slices.Sort(want)
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("unexpected shard Secrets (-got +want):\n%s", diff)
+		}
+	}
+	getSTS := func(t *testing.T) *appsv1.StatefulSet {
+		t.Helper()
+		sts := &appsv1.StatefulSet{}
+		if err := fc.Get(t.Context(), client.ObjectKey{Namespace: tsNamespace, Name: pg.Name}, sts); err != nil {
+			t.Fatal(err)
+		}
+		return sts
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		expectReconciled(t, reconciler, "", pg.Name)
+		expectShards(t, 0, 1)
+		expectEqual(t, fc, pgRole(pg, tsNamespace, true))
+		verifyEnvVar(t, getSTS(t), "TS_KUBE_STATE_SHARDING", "true")
+	})
+
+	t.Run("scale_down", func(t *testing.T) {
+		addNodeIDToStateSecrets(t, fc, pg)
+		pg.Spec.Replicas = ptr.To[int32](1)
+		mustUpdate(t, fc, "", pg.Name, func(p *tsapi.ProxyGroup) {
+			p.Spec = pg.Spec
+		})
+		expectReconciled(t, reconciler, "", pg.Name)
+		expectShards(t, 0)
+		expectEqual(t, fc, pgRole(pg, tsNamespace, true))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		pg.Spec.StateSharding = false
+		mustUpdate(t, fc, "", pg.Name, func(p *tsapi.ProxyGroup) {
+			p.Spec = pg.Spec
+		})
+		expectReconciled(t, reconciler, "", pg.Name)
+		// The proxies keep access to the existing shards to move their
+		// state out of them.
+		expectShards(t, 0)
+		expectEqual(t, fc, pgRole(pg, tsNamespace, true))
+		verifyEnvVarNotPresent(t, getSTS(t), "TS_KUBE_STATE_SHARDING")
+	})
+}
+
 func TestProxyGroupTypes(t *testing.T) {
 	pc := &tsapi.ProxyClass{
 		ObjectMeta: metav1.ObjectMeta{
@@ -1747,7 +1838,7 @@ func verifyEnvVarNotPresent(t *testing.T, sts *appsv1.StatefulSet, name string)
 func expectProxyGroupResources(t *testing.T, fc client.WithWatch, pg *tsapi.ProxyGroup, shouldExist bool, proxyClass *tsapi.ProxyClass) {
 	t.Helper()
 
-	role := pgRole(pg, tsNamespace)
+	role := pgRole(pg, tsNamespace, pg.Spec.StateSharding)
 	roleBinding := pgRoleBinding(pg, tsNamespace)
 	serviceAccount := pgServiceAccount(pg, tsNamespace)
 	statefulSet, err := pgStatefulSet(pg, tsNamespace, testProxyImage, "auto", nil, proxyClass)
@@ -0,0 +1,225 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/k8s-operator/recordings"
+)
+
+const (
+	// recordingsPort is the port on which the recordings sidecar of
+	// Recorders with PVC storage serves its query API.
+	recordingsPort = 9080
+	// recordingsPruneInterval is how often the recordings sidecar applies
+	// the retention policy.
+	recordingsPruneInterval = time.Minute
+	// recentRecordingsLimit is the number of recordings listed in a
+	// Recorder's status.
+	recentRecordingsLimit = 10
+	// recordingsStatusInterval is how often the recent recordings in a
+	// Recorder's status are refreshed.
+	recordingsStatusInterval = 5 * time.Minute
+	// recordingsAudience is the audience of the service account tokens
+	// that the operator authenticates to the recordings sidecar with.
+	recordingsAudience = "tailscale.com/recordings"
+	// recordingsTokenPath is the path of the operator's projected service
+	// account token for recordingsAudience.
+	recordingsTokenPath = "/var/run/secrets/tailscale/recordings/token"
+	// recorderAuthClusterRole is the ClusterRole that allows the recordings
+	// sidecar to review the tokens that it is queried with.
+	recorderAuthClusterRole = "tailscale-recorder-auth"
+)
+
+// runRecordings runs the recordings sidecar that the operator adds to
+// Recorders with PVC storage. It applies the retention policy configured by
+// the RECORDINGS_MAX_AGE and RECORDINGS_MAX_SIZE env vars to the recordings in
+// RECORDINGS_DIR, and serves the recordings query API to the Kubernetes user
+// in RECORDINGS_ALLOWED_USER, i.e. the operator.
+func runRecordings() {
+	var (
+		dir         = defaultEnv("RECORDINGS_DIR", "/data/recordings")
+		maxAgeStr   = defaultEnv("RECORDINGS_MAX_AGE", "")
+		maxSizeStr  = defaultEnv("RECORDINGS_MAX_SIZE", "")
+		addr        = defaultEnv("RECORDINGS_ADDR", net.JoinHostPort("", strconv.Itoa(recordingsPort)))
+		allowedUser = defaultEnv("RECORDINGS_ALLOWED_USER", "")
+	)
+	if allowedUser == "" {
+		log.Fatalf("RECORDINGS_ALLOWED_USER must be set")
+	}
+	var ret recordings.Retention
+	if maxAgeStr != "" {
+		d, err := time.ParseDuration(maxAgeStr)
+		if err != nil {
+			log.Fatalf("invalid RECORDINGS_MAX_AGE %q: %v", maxAgeStr, err)
+		}
+		ret.MaxAge = d
+	}
+	if maxSizeStr != "" {
+		n, err := strconv.ParseInt(maxSizeStr, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid RECORDINGS_MAX_SIZE %q: %v", maxSizeStr, err)
+		}
+		ret.MaxSize = n
+	}
+
+	if ret != (recordings.Retention{}) {
+		go func() {
+			for {
+				prune(dir, ret)
+				time.Sleep(recordingsPruneInterval)
+			}
+		}()
+	}
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatalf("error getting in-cluster config: %v", err)
+	}
+	cs, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		log.Fatalf("error creating Kubernetes client: %v", err)
+	}
+	h := &recordingsAuthHandler{
+		next:         recordings.Handler(dir),
+		tokenReviews: cs.AuthenticationV1().TokenReviews(),
+		allowedUser:  allowedUser,
+	}
+
+	log.Printf("serving recordings in %s on %s", dir, addr)
+	log.Fatal(http.ListenAndServe(addr, h))
+}
+
+// tokenReviewer creates TokenReviews. It is implemented by client-go's
+// TokenReviewInterface.
+type tokenReviewer interface {
+	Create(ctx context.Context, tr *authenticationv1.TokenReview, opts metav1.CreateOptions) (*authenticationv1.TokenReview, error)
+}
+
+// recordingsAuthHandler only passes on requests to next that carry a bearer
+// token for recordingsAudience of allowedUser. Tokens are authenticated with
+// a TokenReview.
+type recordingsAuthHandler struct {
+	next         http.Handler
+	tokenReviews tokenReviewer
+	allowedUser  string
+}
+
+func (h *recordingsAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	tr, err := h.tokenReviews.Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: []string{recordingsAudience},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		log.Printf("error reviewing token: %v", err)
+		http.Error(w, "error reviewing token", http.StatusInternalServerError)
+		return
+	}
+	if !tr.Status.Authenticated || !slices.Contains(tr.Status.Audiences, recordingsAudience) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if tr.Status.User.Username != h.allowedUser {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+func prune(dir string, ret recordings.Retention) {
+	recs, err := recordings.List(dir)
+	if err != nil {
+		log.Printf("error listing recordings: %v", err)
+		return
+	}
+	deleted, err := recordings.Prune(dir, recs, ret, time.Now())
+	for _, r := range deleted {
+		log.Printf("deleted recording %s started at %v", r.Name, r.StartTime)
+	}
+	if err != nil {
+		log.Printf("error deleting recordings: %v", err)
+	}
+}
+
+// recentRecordings queries the recordings sidecar of tsr's Pod for its most
+// recent recordings. It returns ok false if the Pod is not running yet.
+func (r *RecorderReconciler) recentRecordings(ctx context.Context, tsr *tsapi.Recorder) (_ []tsapi.RecorderRecording, ok bool, _ error) {
+	pod := new(corev1.Pod)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.tsNamespace, Name: fmt.Sprintf("%s-0", tsr.Name)}, pod); err != nil {
+		return nil, false, client.IgnoreNotFound(err)
+	}
+	if pod.Status.PodIP == "" {
+		return nil, false, nil
+	}
+	u := fmt.Sprintf("http://%s/recordings?%s", net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(recordingsPort)), recordings.Query{Limit: recentRecordingsLimit}.Values().Encode())
+	token, err := os.ReadFile(r.recordingsTokenPath)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading recordings token: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("error querying recordings: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("error querying recordings: %s", resp.Status)
+	}
+	var recs []recordings.Recording
+	if err := json.NewDecoder(resp.Body).Decode(&recs); err != nil {
+		return nil, false, fmt.Errorf("error decoding recordings: %w", err)
+	}
+	return recorderRecordings(recs), true, nil
+}
+
+// recorderRecordings converts recs to their representation in a Recorder's
+// status.
+func recorderRecordings(recs []recordings.Recording) []tsapi.RecorderRecording {
+	if len(recs) == 0 {
+		return nil
+	}
+	out := make([]tsapi.RecorderRecording, 0, len(recs))
+	for _, rec := range recs {
+		out = append(out, tsapi.RecorderRecording{
+			Name:        rec.Name,
+			StartTime:   metav1.NewTime(rec.StartTime),
+			Size:        rec.Size,
+			SrcNode:     rec.Header.SrcNode,
+			SrcNodeUser: rec.Header.SrcNodeUser,
+			Target:      rec.Target(),
+		})
+	}
+	return out
+}
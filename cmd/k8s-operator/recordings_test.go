@@ -0,0 +1,200 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/k8s-operator/recordings"
+	"tailscale.com/tstest"
+)
+
+// fakeTokenReviewer authenticates the tokens in users as the corresponding
+// users, for recordingsAudience only.
+type fakeTokenReviewer struct {
+	users map[string]string // token => username
+}
+
+func (f *fakeTokenReviewer) Create(ctx context.Context, tr *authenticationv1.TokenReview, opts metav1.CreateOptions) (*authenticationv1.TokenReview, error) {
+	tr = tr.DeepCopy()
+	user, ok := f.users[tr.Spec.Token]
+	if !ok || !slices.Equal(tr.Spec.Audiences, []string{recordingsAudience}) {
+		return tr, nil
+	}
+	tr.Status = authenticationv1.TokenReviewStatus{
+		Authenticated: true,
+		User:          authenticationv1.UserInfo{Username: user},
+		Audiences:     tr.Spec.Audiences,
+	}
+	return tr, nil
+}
+
+func TestRecordingsAuth(t *testing.T) {
+	const operatorUser = "system:serviceaccount:tailscale:operator"
+	h := &recordingsAuthHandler{
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		}),
+		tokenReviews: &fakeTokenReviewer{users: map[string]string{
+			"operator-token": operatorUser,
+			"other-token":    "system:serviceaccount:tailscale:other",
+		}},
+		allowedUser: operatorUser,
+	}
+	for _, tt := range []struct {
+		name       string
+		authHeader string
+		wantStatus int
+	}{
+		{
+			name:       "no_token",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "not_bearer",
+			authHeader: "Basic b3BlcmF0b3I6cGFzc3dvcmQ=",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unknown_token",
+			authHeader: "Bearer unknown-token",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "other_user",
+			authHeader: "Bearer other-token",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "operator",
+			authHeader: "Bearer operator-token",
+			wantStatus: http.StatusOK,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/recordings", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// roundTripFunc is an http.RoundTripper that calls itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestRecorderPVCStorage(t *testing.T) {
+	tsr := &tsapi.Recorder{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test",
+			Finalizers: []string{"tailscale.com/finalizer"},
+		},
+		Spec: tsapi.RecorderSpec{
+			Storage: tsapi.Storage{
+				PVC: &tsapi.RecorderPVCStorage{
+					Size: resource.MustParse("10Gi"),
+				},
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-0",
+			Namespace: tsNamespace,
+		},
+		Status: corev1.PodStatus{
+			PodIP: "10.0.0.1",
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(tsr, pod).
+		WithStatusSubresource(tsr).
+		Build()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("operator-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	var gotURL, gotAuth string
+	httpClient := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		gotURL = r.URL.String()
+		gotAuth = r.Header.Get("Authorization")
+		w := httptest.NewRecorder()
+		json.NewEncoder(w).Encode([]recordings.Recording{{
+			Name:      "2026-10-01/session.cast",
+			Size:      1024,
+			StartTime: start,
+		}})
+		return w.Result(), nil
+	})}
+
+	zl, _ := zap.NewDevelopment()
+	reconciler := &RecorderReconciler{
+		tsNamespace:         tsNamespace,
+		Client:              fc,
+		tsClient:            &fakeTSClient{},
+		recorder:            record.NewFakeRecorder(1),
+		log:                 zl.Sugar(),
+		clock:               tstest.NewClock(tstest.ClockOpts{}),
+		loginServer:         tsLoginServer,
+		httpClient:          httpClient,
+		recordingsTokenPath: tokenPath,
+	}
+	// Recorders with PVC storage are requeued to refresh their recent recordings.
+	expectRequeue(t, reconciler, "", tsr.Name)
+
+	if err := fc.Get(context.Background(), client.ObjectKeyFromObject(tsr), tsr); err != nil {
+		t.Fatal(err)
+	}
+	expectRecorderResources(t, fc, tsr, true)
+
+	if wantURL := "http://10.0.0.1:9080/recordings?limit=10"; gotURL != wantURL {
+		t.Errorf("queried %q, want %q", gotURL, wantURL)
+	}
+	if gotAuth != "Bearer operator-token" {
+		t.Errorf("got Authorization header %q, want %q", gotAuth, "Bearer operator-token")
+	}
+	want := []tsapi.RecorderRecording{{
+		Name:      "2026-10-01/session.cast",
+		StartTime: metav1.NewTime(start),
+		Size:      1024,
+	}}
+	if diff := cmp.Diff(tsr.Status.RecentRecordings, want, cmp.Comparer(func(a, b metav1.Time) bool {
+		return a.Equal(&b)
+	})); diff != "" {
+		t.Errorf("unexpected recent recordings (-got +want):\n%s", diff)
+	}
+}
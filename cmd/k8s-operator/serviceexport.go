@@ -0,0 +1,662 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/internal/client/tailscale"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+)
+
+const (
+	reasonServiceExportInvalid  = "ServiceExportInvalid"
+	reasonServiceExportConflict = "ServiceExportConflict"
+	reasonServiceExportValid    = "ServiceExportValid"
+	reasonServiceExportPending  = "ServiceExportPending"
+	reasonServiceExportReady    = "ServiceExportReady"
+
+	// exportHealthAnnotation is the annotation on a Tailscale Service on
+	// which the operators of all clusters that export the Service publish
+	// its health in those clusters, keyed by operator ID.
+	exportHealthAnnotation = "tailscale.com/export-health"
+	// exportSourceAnnotation is the annotation on a Tailscale Service on
+	// which the exporting operators publish the namespace, name and ports
+	// of the Service that it exports.
+	exportSourceAnnotation = "tailscale.com/export-source"
+
+	// parentTypeServiceExport is the parent type label value of the
+	// ClusterIP Services created for ServiceExports.
+	parentTypeServiceExport = "serviceexport"
+
+	// exportHealthRefreshInterval is how often an operator republishes the
+	// health of its exports, even if it hasn't changed.
+	exportHealthRefreshInterval = 5 * time.Minute
+	// exportHealthTTL is how long published export health is valid for.
+	// Older entries, e.g. of clusters that were torn down without deleting
+	// their ServiceExports, are ignored by importing operators and removed
+	// by exporting ones.
+	exportHealthTTL = 3 * exportHealthRefreshInterval
+)
+
+// exportHealth is the health of an exported Service in one cluster, as
+// published on the Tailscale Service.
+type exportHealth struct {
+	// Operator is the MagicDNS name of the exporting operator.
+	Operator       string `json:"operator"`
+	ReadyEndpoints int32  `json:"readyEndpoints"`
+	ReadyProxies   int32  `json:"readyProxies"`
+	// Updated is when the operator last published its health.
+	Updated time.Time `json:"updated"`
+}
+
+// sameHealth reports whether a and b are the same, ignoring when they were
+// published.
+func sameHealth(a, b exportHealth) bool {
+	a.Updated, b.Updated = time.Time{}, time.Time{}
+	return a == b
+}
+
+// stale reports whether h was published longer than exportHealthTTL before
+// now.
+func (h exportHealth) stale(now time.Time) bool {
+	return now.Sub(h.Updated) > exportHealthTTL
+}
+
+// exportSource identifies the Service that a Tailscale Service exports, as
+// published on the Tailscale Service.
+type exportSource struct {
+	Namespace string                    `json:"namespace"`
+	Name      string                    `json:"name"`
+	Ports     []tsapi.ServiceImportPort `json:"ports,omitempty"`
+}
+
+// ServiceExportReconciler exports Services to other clusters connected to the
+// same tailnet. For each ServiceExport, it creates a ClusterIP Service with
+// the exported Service's selector and ports, which the HAServiceReconciler
+// exposes on the ServiceExport's ingress ProxyGroup as a Tailscale Service. It
+// reports the health of the export in the ServiceExport's status and publishes
+// it on the Tailscale Service for importing clusters.
+type ServiceExportReconciler struct {
+	client.Client
+
+	recorder    record.EventRecorder
+	logger      *zap.SugaredLogger
+	clock       tstime.Clock
+	tsClient    tsClient
+	lc          localClient
+	tsNamespace string
+	operatorID  string // stableID of the operator's Tailscale device
+
+	mu sync.Mutex // protects following
+
+	// managedExports is a set of all ServiceExport resources that we're
+	// currently managing. This is only used for metrics.
+	managedExports set.Slice[types.UID]
+}
+
+// gaugeServiceExportResources tracks the number of ServiceExport resources
+// that we're currently managing.
+var gaugeServiceExportResources = clientmetric.NewGauge(kubetypes.MetricServiceExportCount)
+
+func (r *ServiceExportReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("ServiceExport", req.NamespacedName)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	se := new(tsapi.ServiceExport)
+	err = r.Get(ctx, req.NamespacedName, se)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("ServiceExport not found, assuming it was deleted")
+		return reconcile.Result{}, nil
+	} else if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get tailscale.com ServiceExport: %w", err)
+	}
+	if !se.DeletionTimestamp.IsZero() {
+		logger.Debugf("ServiceExport is being deleted")
+		return reconcile.Result{}, r.maybeCleanup(ctx, logger, se)
+	}
+
+	if !slices.Contains(se.Finalizers, FinalizerName) {
+		logger.Infof("exporting Service")
+		se.Finalizers = append(se.Finalizers, FinalizerName)
+		if err := r.Update(ctx, se); err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to add finalizer: %w", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.managedExports.Add(se.UID)
+	gaugeServiceExportResources.Set(int64(r.managedExports.Len()))
+	r.mu.Unlock()
+
+	oldStatus := se.Status.DeepCopy()
+	defer func() {
+		if !apiequality.Semantic.DeepEqual(oldStatus, &se.Status) {
+			// An error encountered here should get returned by the Reconcile function.
+			err = errors.Join(err, r.Client.Status().Update(ctx, se))
+		}
+	}()
+
+	if err := r.maybeProvision(ctx, logger, se); err != nil {
+		return reconcile.Result{}, err
+	}
+	// Republish the export health before it goes stale.
+	return reconcile.Result{RequeueAfter: exportHealthRefreshInterval}, nil
+}
+
+func (r *ServiceExportReconciler) maybeProvision(ctx context.Context, logger *zap.SugaredLogger, se *tsapi.ServiceExport) error {
+	serviceName := exportedServiceName(se.Namespace, se.Name)
+	se.Status.TailscaleService = serviceName.String()
+
+	svc := new(corev1.Service)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(se), svc); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error getting Service: %w", err)
+		}
+		svc = nil
+	}
+	if msg, err := r.validate(ctx, se, svc); err != nil {
+		return err
+	} else if msg != "" {
+		r.recorder.Event(se, corev1.EventTypeWarning, reasonServiceExportInvalid, msg)
+		r.setNotReady(se, reasonServiceExportInvalid, msg, logger)
+		se.Status.ReadyEndpoints = 0
+		return r.deleteExportService(ctx, se, logger)
+	}
+	tsoperator.SetServiceExportCondition(se, tsapi.ServiceExportValid, metav1.ConditionTrue, reasonServiceExportValid, reasonServiceExportValid, se.Generation, r.clock, logger)
+
+	if msg, err := r.exportConflict(ctx, se, serviceName); err != nil {
+		return err
+	} else if msg != "" {
+		r.recorder.Event(se, corev1.EventTypeWarning, reasonServiceExportConflict, msg)
+		r.setNotReady(se, reasonServiceExportConflict, msg, logger)
+		se.Status.ReadyEndpoints = 0
+		return r.deleteExportService(ctx, se, logger)
+	}
+
+	exportSvc, err := r.ensureExportService(ctx, se, svc)
+	if err != nil {
+		return fmt.Errorf("error ensuring ClusterIP Service for export: %w", err)
+	}
+
+	readyEndpoints, err := r.readyEndpoints(ctx, svc)
+	if err != nil {
+		return err
+	}
+	se.Status.ReadyEndpoints = readyEndpoints
+
+	// The HAServiceReconciler validates the export Service and reports
+	// whether the ProxyGroup advertises the Tailscale Service on it.
+	if cond := tsoperator.GetServiceCondition(exportSvc, tsapi.IngressSvcValid); cond != nil && cond.Status == metav1.ConditionFalse {
+		r.setNotReady(se, reasonServiceExportInvalid, cond.Message, logger)
+		return nil
+	}
+	cond := tsoperator.GetServiceCondition(exportSvc, tsapi.IngressSvcConfigured)
+	if cond == nil {
+		tsoperator.SetServiceExportCondition(se, tsapi.ServiceExportReady, metav1.ConditionFalse, reasonServiceExportPending, "waiting for the ProxyGroup to expose the Service", se.Generation, r.clock, logger)
+		return nil
+	}
+	if cond.Status == metav1.ConditionTrue {
+		tsoperator.SetServiceExportCondition(se, tsapi.ServiceExportReady, metav1.ConditionTrue, reasonServiceExportReady, cond.Message, se.Generation, r.clock, logger)
+	} else {
+		tsoperator.SetServiceExportCondition(se, tsapi.ServiceExportReady, metav1.ConditionFalse, reasonServiceExportPending, cond.Message, se.Generation, r.clock, logger)
+	}
+
+	readyProxies, err := numberPodsAdvertising(ctx, r.Client, r.tsNamespace, se.Spec.ProxyGroup, serviceName)
+	if err != nil {
+		return fmt.Errorf("error getting number of Pods advertising the Tailscale Service: %w", err)
+	}
+	st, err := r.lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting tailscale status: %w", err)
+	}
+	if st.Self == nil {
+		return errors.New("[unexpected] tailscale status has no self node")
+	}
+	src := &exportSource{
+		Namespace: se.Namespace,
+		Name:      se.Name,
+	}
+	for _, p := range svc.Spec.Ports {
+		src.Ports = append(src.Ports, tsapi.ServiceImportPort{Name: p.Name, Protocol: cmp.Or(p.Protocol, corev1.ProtocolTCP), Port: p.Port})
+	}
+	h := &exportHealth{
+		Operator:       strings.TrimSuffix(st.Self.DNSName, "."),
+		ReadyEndpoints: readyEndpoints,
+		ReadyProxies:   int32(readyProxies),
+		Updated:        r.clock.Now().UTC().Truncate(time.Second),
+	}
+	return r.publishHealth(ctx, serviceName, src, h, logger)
+}
+
+// exportConflict returns a message describing why se can't be exported as the
+// Tailscale Service serviceName because the Tailscale Service is used for
+// something else, or an empty message if it can.
+func (r *ServiceExportReconciler) exportConflict(ctx context.Context, se *tsapi.ServiceExport, serviceName tailcfg.ServiceName) (string, error) {
+	tsSvc, err := r.tsClient.GetVIPService(ctx, serviceName)
+	if err != nil {
+		if isErrorTailscaleServiceNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("error getting Tailscale Service %q: %w", serviceName, err)
+	}
+	src, err := parseExportSource(tsSvc)
+	if err != nil {
+		return fmt.Sprintf("error parsing export source of Tailscale Service %q: %v", serviceName, err), nil
+	}
+	if src != nil {
+		if src.Namespace == se.Namespace && src.Name == se.Name {
+			return "", nil
+		}
+		return fmt.Sprintf("Tailscale Service %q already exports Service %s/%s", serviceName, src.Namespace, src.Name), nil
+	}
+	// The export source is published once the Tailscale Service is
+	// exposed, so a Tailscale Service without one is only ours to export
+	// as if no other operator owns it.
+	o, err := parseOwnerAnnotation(tsSvc)
+	if err != nil {
+		return "", fmt.Errorf("error parsing Tailscale Service owner annotation: %w", err)
+	}
+	if o != nil && !slices.ContainsFunc(o.OwnerRefs, func(or OwnerRef) bool { return or.OperatorID != r.operatorID }) {
+		return "", nil
+	}
+	return fmt.Sprintf("Tailscale Service %q already exists and is not used to export Service %s/%s", serviceName, se.Namespace, se.Name), nil
+}
+
+// validate returns a message describing why svc can't be exported as
+// configured by se, or an empty message if it can. svc is nil if the Service
+// does not exist.
+func (r *ServiceExportReconciler) validate(ctx context.Context, se *tsapi.ServiceExport, svc *corev1.Service) (string, error) {
+	if svc == nil {
+		return fmt.Sprintf("Service %q not found", se.Name), nil
+	}
+	if err := exportedServiceName(se.Namespace, se.Name).Validate(); err != nil {
+		return fmt.Sprintf("Service can't be exported as a Tailscale Service: %v", err), nil
+	}
+	var violations []string
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		violations = append(violations, "ExternalName Services can't be exported")
+	} else if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		violations = append(violations, "headless Services can't be exported")
+	}
+	if len(svc.Spec.Selector) == 0 {
+		violations = append(violations, "Services without a selector can't be exported")
+	}
+	pg := new(tsapi.ProxyGroup)
+	if err := r.Get(ctx, client.ObjectKey{Name: se.Spec.ProxyGroup}, pg); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("error getting ProxyGroup %q: %w", se.Spec.ProxyGroup, err)
+		}
+		violations = append(violations, fmt.Sprintf("ProxyGroup %q not found", se.Spec.ProxyGroup))
+	} else if pg.Spec.Type != tsapi.ProxyGroupTypeIngress {
+		violations = append(violations, fmt.Sprintf("ProxyGroup %q is of type %q but must be of type %q", pg.Name, pg.Spec.Type, tsapi.ProxyGroupTypeIngress))
+	}
+	return strings.Join(violations, ", "), nil
+}
+
+func (r *ServiceExportReconciler) setNotReady(se *tsapi.ServiceExport, reason, msg string, logger *zap.SugaredLogger) {
+	if reason == reasonServiceExportInvalid {
+		tsoperator.SetServiceExportCondition(se, tsapi.ServiceExportValid, metav1.ConditionFalse, reason, msg, se.Generation, r.clock, logger)
+	}
+	tsoperator.SetServiceExportCondition(se, tsapi.ServiceExportReady, metav1.ConditionFalse, reason, msg, se.Generation, r.clock, logger)
+}
+
+// ensureExportService creates or updates the ClusterIP Service that exposes
+// svc on se's ProxyGroup.
+func (r *ServiceExportReconciler) ensureExportService(ctx context.Context, se *tsapi.ServiceExport, svc *corev1.Service) (*corev1.Service, error) {
+	labels := exportSvcLabels(se)
+	existing, err := getSingleObject[corev1.Service](ctx, r.Client, se.Namespace, labels)
+	if err != nil {
+		return nil, err
+	}
+	annots := map[string]string{
+		AnnotationExpose:     "true",
+		AnnotationProxyGroup: se.Spec.ProxyGroup,
+		AnnotationHostname:   exportedServiceName(se.Namespace, se.Name).WithoutPrefix(),
+	}
+	if len(se.Spec.Tags) > 0 {
+		annots[AnnotationTags] = strings.Join(se.Spec.Tags.Stringify(), ",")
+	}
+	var ports []corev1.ServicePort
+	for _, p := range svc.Spec.Ports {
+		ports = append(ports, corev1.ServicePort{
+			Name:        p.Name,
+			Protocol:    p.Protocol,
+			AppProtocol: p.AppProtocol,
+			Port:        p.Port,
+			TargetPort:  p.TargetPort,
+		})
+	}
+
+	if existing == nil {
+		exportSvc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName:    fmt.Sprintf("%s-export-", se.Name),
+				Namespace:       se.Namespace,
+				Labels:          labels,
+				Annotations:     annots,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(se, tsapi.SchemeGroupVersion.WithKind("ServiceExport"))},
+			},
+			Spec: corev1.ServiceSpec{
+				Type:     corev1.ServiceTypeClusterIP,
+				Selector: svc.Spec.Selector,
+				Ports:    ports,
+			},
+		}
+		if err := r.Create(ctx, exportSvc); err != nil {
+			return nil, fmt.Errorf("error creating Service: %w", err)
+		}
+		return exportSvc, nil
+	}
+
+	annotsUpToDate := len(se.Spec.Tags) > 0 || existing.Annotations[AnnotationTags] == ""
+	for k, v := range annots {
+		annotsUpToDate = annotsUpToDate && existing.Annotations[k] == v
+	}
+	if annotsUpToDate &&
+		maps.Equal(existing.Spec.Selector, svc.Spec.Selector) &&
+		apiequality.Semantic.DeepEqual(existing.Spec.Ports, ports) {
+		return existing, nil
+	}
+	for k, v := range annots {
+		mak.Set(&existing.Annotations, k, v)
+	}
+	if len(se.Spec.Tags) == 0 {
+		delete(existing.Annotations, AnnotationTags)
+	}
+	existing.Spec.Selector = svc.Spec.Selector
+	existing.Spec.Ports = ports
+	if err := r.Update(ctx, existing); err != nil {
+		return nil, fmt.Errorf("error updating Service: %w", err)
+	}
+	return existing, nil
+}
+
+// deleteExportService deletes the ClusterIP Service created for se, if any.
+// The HAServiceReconciler then cleans up the Tailscale Service.
+func (r *ServiceExportReconciler) deleteExportService(ctx context.Context, se *tsapi.ServiceExport, logger *zap.SugaredLogger) error {
+	existing, err := getSingleObject[corev1.Service](ctx, r.Client, se.Namespace, exportSvcLabels(se))
+	if err != nil {
+		return fmt.Errorf("error getting ClusterIP Service for export: %w", err)
+	}
+	if existing == nil {
+		return nil
+	}
+	logger.Infof("deleting ClusterIP Service %s for export", existing.Name)
+	if err := r.Delete(ctx, existing); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting ClusterIP Service for export: %w", err)
+	}
+	return nil
+}
+
+// readyEndpoints returns the number of ready endpoints of svc. Endpoints are
+// counted once per target (usually a Pod), as dual-stack Services have an
+// EndpointSlice per IP family that each list the same targets.
+func (r *ServiceExportReconciler) readyEndpoints(ctx context.Context, svc *corev1.Service) (int32, error) {
+	epss := new(discoveryv1.EndpointSliceList)
+	if err := r.List(ctx, epss, client.InNamespace(svc.Namespace), client.MatchingLabels{discoveryv1.LabelServiceName: svc.Name}); err != nil {
+		return 0, fmt.Errorf("error listing EndpointSlices: %w", err)
+	}
+	ready := make(set.Set[string])
+	for _, eps := range epss.Items {
+		for _, ep := range eps.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			if ep.TargetRef != nil && ep.TargetRef.UID != "" {
+				ready.Add(string(ep.TargetRef.UID))
+			} else {
+				// Endpoints without a target are only identified by
+				// their addresses.
+				ready.Add(strings.Join(ep.Addresses, ","))
+			}
+		}
+	}
+	return int32(ready.Len()), nil
+}
+
+// publishHealthAttempts is the number of times publishHealth writes the
+// export health annotation before giving up.
+const publishHealthAttempts = 3
+
+// publishHealth sets this operator's entry in the export health annotation
+// of the Tailscale Service to h, or removes it if h is nil. If src is non-nil,
+// it also sets the export source annotation to src, unless the Tailscale
+// Service exports a different Service. The Tailscale Service is only updated
+// if it exists and is owned by this operator.
+//
+// An entry that is up to date is republished if it is older than
+// exportHealthRefreshInterval, and stale entries of other operators are
+// removed whenever the annotation is written.
+//
+// The Tailscale API has no conditional updates, so the operators of other
+// clusters that export the same Service may concurrently overwrite the
+// annotation with a stale copy. publishHealth therefore re-reads the
+// annotation after each write, and writes it again if its entry was lost.
+// As every operator does the same, an entry that this operator overwrote is
+// restored by the operator that owns it.
+func (r *ServiceExportReconciler) publishHealth(ctx context.Context, serviceName tailcfg.ServiceName, src *exportSource, h *exportHealth, logger *zap.SugaredLogger) error {
+	for attempt := 0; ; attempt++ {
+		tsSvc, err := r.tsClient.GetVIPService(ctx, serviceName)
+		if err != nil {
+			if isErrorTailscaleServiceNotFound(err) {
+				return nil
+			}
+			return fmt.Errorf("error getting Tailscale Service %q: %w", serviceName, err)
+		}
+		o, err := parseOwnerAnnotation(tsSvc)
+		if err != nil {
+			return fmt.Errorf("error parsing Tailscale Service owner annotation: %w", err)
+		}
+		if o == nil || !slices.ContainsFunc(o.OwnerRefs, func(or OwnerRef) bool { return or.OperatorID == r.operatorID }) {
+			// Not (yet) exposed by this operator.
+			return nil
+		}
+		writeSrc := false
+		if src != nil {
+			oldSrc, err := parseExportSource(tsSvc)
+			if err == nil && oldSrc != nil && (oldSrc.Namespace != src.Namespace || oldSrc.Name != src.Name) {
+				return fmt.Errorf("export source of Tailscale Service %q was changed to Service %s/%s", serviceName, oldSrc.Namespace, oldSrc.Name)
+			}
+			writeSrc = err != nil || oldSrc == nil || !slices.Equal(oldSrc.Ports, src.Ports)
+		}
+		health, err := parseExportHealth(tsSvc)
+		if err != nil {
+			// Overwrite a corrupted annotation rather than failing the
+			// export.
+			logger.Infof("error parsing export health of Tailscale Service %q, resetting it: %v", serviceName, err)
+			health = nil
+		}
+		now := r.clock.Now()
+		old, ok := health[r.operatorID]
+		fresh := ok && h != nil && sameHealth(old, *h) && now.Sub(old.Updated) < exportHealthRefreshInterval
+		if !writeSrc && (fresh || !ok && h == nil) {
+			return nil
+		}
+		if attempt == publishHealthAttempts {
+			return fmt.Errorf("export health of Tailscale Service %q was overwritten by concurrent updates %d times", serviceName, attempt)
+		}
+		if attempt > 0 {
+			logger.Infof("export health of Tailscale Service %q was overwritten by a concurrent update, publishing it again", serviceName)
+		}
+		for id, eh := range health {
+			if id != r.operatorID && eh.stale(now) {
+				logger.Infof("removing stale export health of operator %s from Tailscale Service %q", cmp.Or(eh.Operator, id), serviceName)
+				delete(health, id)
+			}
+		}
+		if h != nil {
+			mak.Set(&health, r.operatorID, *h)
+		} else {
+			delete(health, r.operatorID)
+		}
+		b, err := json.Marshal(health)
+		if err != nil {
+			return fmt.Errorf("error marshalling export health: %w", err)
+		}
+		mak.Set(&tsSvc.Annotations, exportHealthAnnotation, string(b))
+		if writeSrc {
+			b, err := json.Marshal(src)
+			if err != nil {
+				return fmt.Errorf("error marshalling export source: %w", err)
+			}
+			mak.Set(&tsSvc.Annotations, exportSourceAnnotation, string(b))
+		}
+		logger.Debugf("publishing export health on Tailscale Service %q", serviceName)
+		if err := r.tsClient.CreateOrUpdateVIPService(ctx, tsSvc); err != nil {
+			return fmt.Errorf("error updating Tailscale Service %q: %w", serviceName, err)
+		}
+	}
+}
+
+func (r *ServiceExportReconciler) maybeCleanup(ctx context.Context, logger *zap.SugaredLogger, se *tsapi.ServiceExport) error {
+	ix := slices.Index(se.Finalizers, FinalizerName)
+	if ix < 0 {
+		return nil
+	}
+	// Remove this cluster's health before the HAServiceReconciler removes
+	// this operator as an owner of the Tailscale Service, after which
+	// it is no longer allowed to update it.
+	if err := r.publishHealth(ctx, exportedServiceName(se.Namespace, se.Name), nil, nil, logger); err != nil {
+		return err
+	}
+	if err := r.deleteExportService(ctx, se, logger); err != nil {
+		return err
+	}
+	se.Finalizers = append(se.Finalizers[:ix], se.Finalizers[ix+1:]...)
+	if err := r.Update(ctx, se); err != nil {
+		return fmt.Errorf("failed to remove finalizer: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.managedExports.Remove(se.UID)
+	gaugeServiceExportResources.Set(int64(r.managedExports.Len()))
+	logger.Infof("ServiceExport resources have been cleaned up")
+	return nil
+}
+
+// exportedServiceName returns the name of the Tailscale Service that the
+// Service with the given namespace and name is exported as. The name is
+// <namespace>-<name>, truncated if necessary, followed by a hash of the
+// namespace and name, so that e.g. Service b-c in namespace a and Service c
+// in namespace a-b are exported as different Tailscale Services.
+func exportedServiceName(ns, name string) tailcfg.ServiceName {
+	const hashLen = 8
+	sum := sha256.Sum256([]byte(ns + "/" + name))
+	prefix := ns + "-" + name
+	if maxLen := 63 - len("-") - hashLen; len(prefix) > maxLen {
+		prefix = strings.TrimRight(prefix[:maxLen], "-")
+	}
+	return tailcfg.ServiceName("svc:" + prefix + "-" + hex.EncodeToString(sum[:])[:hashLen])
+}
+
+// parseExportSource returns the export source published on tsSvc, or nil if
+// there is none.
+func parseExportSource(tsSvc *tailscale.VIPService) (*exportSource, error) {
+	v := tsSvc.Annotations[exportSourceAnnotation]
+	if v == "" {
+		return nil, nil
+	}
+	src := new(exportSource)
+	if err := json.Unmarshal([]byte(v), src); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// parseExportHealth returns the export health published on tsSvc, keyed by
+// operator ID.
+func parseExportHealth(tsSvc *tailscale.VIPService) (map[string]exportHealth, error) {
+	v := tsSvc.Annotations[exportHealthAnnotation]
+	if v == "" {
+		return nil, nil
+	}
+	var health map[string]exportHealth
+	if err := json.Unmarshal([]byte(v), &health); err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+func exportSvcLabels(se *tsapi.ServiceExport) map[string]string {
+	return map[string]string{
+		LabelParentType:      parentTypeServiceExport,
+		LabelParentName:      se.Name,
+		LabelParentNamespace: se.Namespace,
+	}
+}
+
+// serviceExportsFromService is an event handler for Services. For the
+// ClusterIP Services created for ServiceExports it returns a reconcile
+// request for their ServiceExport, and for any other Service it returns a
+// reconcile request for the ServiceExport of the same name, if any.
+func serviceExportsFromService(ctx context.Context, o client.Object) []reconcile.Request {
+	ls := o.GetLabels()
+	if ls[LabelParentType] == parentTypeServiceExport {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: ls[LabelParentNamespace], Name: ls[LabelParentName]}}}
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(o)}}
+}
+
+// serviceExportsFromEndpointSlice is an event handler for EndpointSlices. It
+// returns a reconcile request for the ServiceExport of the EndpointSlice's
+// Service, if any.
+func serviceExportsFromEndpointSlice(ctx context.Context, o client.Object) []reconcile.Request {
+	svcName := o.GetLabels()[discoveryv1.LabelServiceName]
+	if svcName == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: o.GetNamespace(), Name: svcName}}}
+}
+
+// serviceExportsFromProxyGroup is an event handler for ProxyGroups. It returns
+// reconcile requests for all ServiceExports that use the ProxyGroup.
+func serviceExportsFromProxyGroup(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		ses := new(tsapi.ServiceExportList)
+		if err := cl.List(ctx, ses); err != nil {
+			logger.Infof("error listing ServiceExports: %v, skipping a reconcile for event on ProxyGroup %s", err, o.GetName())
+			return nil
+		}
+		var reqs []reconcile.Request
+		for _, se := range ses.Items {
+			if se.Spec.ProxyGroup == o.GetName() {
+				reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&se)})
+			}
+		}
+		return reqs
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"tailscale.com/internal/client/tailscale"
+	"tailscale.com/ipn/ipnstate"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+)
+
+func TestServiceExportReconciler(t *testing.T) {
+	pg := &tsapi.ProxyGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-pg"},
+		Spec:       tsapi.ProxyGroupSpec{Type: tsapi.ProxyGroupTypeIngress},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Type:      corev1.ServiceTypeClusterIP,
+			ClusterIP: "10.0.0.1",
+			Selector:  map[string]string{"app": "web"},
+			Ports:     []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt(8080)}},
+		},
+	}
+	se := &tsapi.ServiceExport{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("1234-UID")},
+		Spec:       tsapi.ServiceExportSpec{ProxyGroup: "ingress-pg"},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(pg, svc, se).
+		WithStatusSubresource(se, &corev1.Service{}).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cl := tstest.NewClock(tstest.ClockOpts{Start: now})
+	ft := &fakeTSClient{}
+	r := &ServiceExportReconciler{
+		Client:      fc,
+		recorder:    record.NewFakeRecorder(10),
+		logger:      zl.Sugar(),
+		clock:       cl,
+		tsClient:    ft,
+		tsNamespace: "operator-ns",
+		operatorID:  "self-id",
+		lc: &fakeLocalClient{
+			status: &ipnstate.Status{
+				Self: &ipnstate.PeerStatus{DNSName: "operator-a.tails.ts.net."},
+			},
+		},
+	}
+	serviceName := tailcfg.ServiceName("svc:default-web-82b3ade9")
+
+	// The exported Service is exposed via a ClusterIP Service that the
+	// HAServiceReconciler picks up.
+	expectRequeue(t, r, "default", "web")
+	exportSvc := mustGetExportService(t, fc, se)
+	wantAnnots := map[string]string{
+		AnnotationExpose:     "true",
+		AnnotationProxyGroup: "ingress-pg",
+		AnnotationHostname:   "default-web-82b3ade9",
+	}
+	if diff := cmp.Diff(exportSvc.Annotations, wantAnnots); diff != "" {
+		t.Errorf("unexpected annotations (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(exportSvc.Spec.Selector, svc.Spec.Selector); diff != "" {
+		t.Errorf("unexpected selector (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(exportSvc.Spec.Ports, svc.Spec.Ports); diff != "" {
+		t.Errorf("unexpected ports (-got +want):\n%s", diff)
+	}
+	expectServiceExportCondition(t, fc, tsapi.ServiceExportValid, metav1.ConditionTrue, reasonServiceExportValid)
+	expectServiceExportCondition(t, fc, tsapi.ServiceExportReady, metav1.ConditionFalse, reasonServiceExportPending)
+
+	// Once the Tailscale Service is exposed, the health of the export is
+	// published on it. The Service is dual-stack, so each Pod is listed in
+	// two EndpointSlices but counted once.
+	pod1 := &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-1", UID: "pod-1-UID"}
+	pod2 := &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-2", UID: "pod-2-UID"}
+	mustCreate(t, fc, &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-abc",
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "web"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"10.1.0.1"}, TargetRef: pod1, Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)}},
+			{Addresses: []string{"10.1.0.2"}, TargetRef: pod2, Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(false)}},
+		},
+	})
+	mustCreate(t, fc, &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-def",
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "web"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv6,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"fd00::1"}, TargetRef: pod1, Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)}},
+			{Addresses: []string{"fd00::2"}, TargetRef: pod2, Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(false)}},
+		},
+	})
+	mustUpdateStatus(t, fc, "default", exportSvc.Name, func(s *corev1.Service) {
+		tsoperator.SetServiceCondition(s, tsapi.IngressSvcConfigured, metav1.ConditionTrue, "Configured", "Configured", cl, zl.Sugar())
+	})
+	owners, err := json.Marshal(ownerAnnotationValue{OwnerRefs: []OwnerRef{{OperatorID: "other-id"}, {OperatorID: "self-id"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.vipServices = map[tailcfg.ServiceName]*tailscale.VIPService{
+		serviceName: {
+			Name: serviceName,
+			Annotations: map[string]string{
+				ownerAnnotation:        string(owners),
+				exportHealthAnnotation: `{"other-id":{"operator":"operator-b.tails.ts.net","readyEndpoints":3,"readyProxies":2,"updated":"2025-01-01T00:00:00Z"},"gone-id":{"operator":"operator-c.tails.ts.net","readyEndpoints":1,"readyProxies":1,"updated":"2024-12-31T23:00:00Z"}}`,
+				exportSourceAnnotation: `{"namespace":"default","name":"web"}`,
+			},
+		},
+	}
+	expectRequeue(t, r, "default", "web")
+	expectServiceExportCondition(t, fc, tsapi.ServiceExportReady, metav1.ConditionTrue, reasonServiceExportReady)
+	if got := mustGetServiceExport(t, fc).Status.ReadyEndpoints; got != 1 {
+		t.Errorf("got %d ready endpoints, want 1", got)
+	}
+	// The stale health of operator-c is removed.
+	wantHealth := map[string]exportHealth{
+		"other-id": {Operator: "operator-b.tails.ts.net", ReadyEndpoints: 3, ReadyProxies: 2, Updated: now},
+		"self-id":  {Operator: "operator-a.tails.ts.net", ReadyEndpoints: 1, Updated: now},
+	}
+	expectExportHealth(t, ft, serviceName, wantHealth)
+
+	// Unchanged health is republished once it is due for a refresh.
+	cl.Advance(exportHealthRefreshInterval / 2)
+	expectRequeue(t, r, "default", "web")
+	expectExportHealth(t, ft, serviceName, wantHealth)
+	cl.Advance(exportHealthRefreshInterval / 2)
+	expectRequeue(t, r, "default", "web")
+	wantHealth["self-id"] = exportHealth{Operator: "operator-a.tails.ts.net", ReadyEndpoints: 1, Updated: now.Add(exportHealthRefreshInterval)}
+	expectExportHealth(t, ft, serviceName, wantHealth)
+	wantSrc := &exportSource{
+		Namespace: "default",
+		Name:      "web",
+		Ports:     []tsapi.ServiceImportPort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80}},
+	}
+	if diff := cmp.Diff(mustParseExportSource(t, ft, serviceName), wantSrc); diff != "" {
+		t.Errorf("unexpected export source (-got +want):\n%s", diff)
+	}
+
+	// An invalid export removes the ClusterIP Service.
+	mustUpdate(t, fc, "default", "web", func(s *corev1.Service) {
+		s.Spec.Selector = nil
+	})
+	expectRequeue(t, r, "default", "web")
+	expectServiceExportCondition(t, fc, tsapi.ServiceExportValid, metav1.ConditionFalse, reasonServiceExportInvalid)
+	expectMissing[corev1.Service](t, fc, "default", exportSvc.Name)
+
+	// Deleting the ServiceExport removes its health from the Tailscale
+	// Service.
+	if err := fc.Delete(context.Background(), mustGetServiceExport(t, fc)); err != nil {
+		t.Fatal(err)
+	}
+	expectReconciled(t, r, "default", "web")
+	delete(wantHealth, "self-id")
+	expectExportHealth(t, ft, serviceName, wantHealth)
+	expectMissing[tsapi.ServiceExport](t, fc, "default", "web")
+}
+
+// racingTSClient is a fakeTSClient that calls afterUpdate, if set, once
+// after the next update of a Tailscale Service.
+type racingTSClient struct {
+	*fakeTSClient
+	afterUpdate func()
+}
+
+func (c *racingTSClient) CreateOrUpdateVIPService(ctx context.Context, svc *tailscale.VIPService) error {
+	if err := c.fakeTSClient.CreateOrUpdateVIPService(ctx, svc); err != nil {
+		return err
+	}
+	if f := c.afterUpdate; f != nil {
+		c.afterUpdate = nil
+		f()
+	}
+	return nil
+}
+
+func TestServiceExportPublishHealthConflict(t *testing.T) {
+	serviceName := tailcfg.ServiceName("svc:default-web-82b3ade9")
+	owners, err := json.Marshal(ownerAnnotationValue{OwnerRefs: []OwnerRef{{OperatorID: "other-id"}, {OperatorID: "self-id"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTSSvc := func(health string) *tailscale.VIPService {
+		return &tailscale.VIPService{
+			Name: serviceName,
+			Annotations: map[string]string{
+				ownerAnnotation:        string(owners),
+				exportHealthAnnotation: health,
+			},
+		}
+	}
+	ft := &racingTSClient{fakeTSClient: &fakeTSClient{
+		vipServices: map[tailcfg.ServiceName]*tailscale.VIPService{
+			serviceName: newTSSvc(`{}`),
+		},
+	}}
+	// The operator of another cluster read the Tailscale Service before
+	// this operator's update and writes its own entry after it, dropping
+	// this operator's entry.
+	ft.afterUpdate = func() {
+		ft.vipServices[serviceName] = newTSSvc(`{"other-id":{"operator":"operator-b.tails.ts.net","readyEndpoints":3,"readyProxies":2,"updated":"2025-01-01T00:00:00Z"}}`)
+	}
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &ServiceExportReconciler{
+		clock:      tstest.NewClock(tstest.ClockOpts{Start: now}),
+		tsClient:   ft,
+		operatorID: "self-id",
+	}
+	h := &exportHealth{Operator: "operator-a.tails.ts.net", ReadyEndpoints: 1, ReadyProxies: 1, Updated: now}
+	if err := r.publishHealth(context.Background(), serviceName, nil, h, zl.Sugar()); err != nil {
+		t.Fatal(err)
+	}
+	expectExportHealth(t, ft.fakeTSClient, serviceName, map[string]exportHealth{
+		"other-id": {Operator: "operator-b.tails.ts.net", ReadyEndpoints: 3, ReadyProxies: 2, Updated: now},
+		"self-id":  *h,
+	})
+}
+
+func TestServiceExportConflict(t *testing.T) {
+	pg := &tsapi.ProxyGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-pg"},
+		Spec:       tsapi.ProxyGroupSpec{Type: tsapi.ProxyGroupTypeIngress},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Type:      corev1.ServiceTypeClusterIP,
+			ClusterIP: "10.0.0.1",
+			Selector:  map[string]string{"app": "web"},
+			Ports:     []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80}},
+		},
+	}
+	se := &tsapi.ServiceExport{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       tsapi.ServiceExportSpec{ProxyGroup: "ingress-pg"},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(pg, svc, se).
+		WithStatusSubresource(se).
+		Build()
+	serviceName := exportedServiceName("default", "web")
+	owners, err := json.Marshal(ownerAnnotationValue{OwnerRefs: []OwnerRef{{OperatorID: "other-id"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := &fakeTSClient{}
+	r := &ServiceExportReconciler{
+		Client:      fc,
+		recorder:    record.NewFakeRecorder(10),
+		logger:      zap.NewNop().Sugar(),
+		clock:       tstest.NewClock(tstest.ClockOpts{}),
+		tsClient:    ft,
+		tsNamespace: "operator-ns",
+		operatorID:  "self-id",
+	}
+
+	for _, tt := range []struct {
+		name         string
+		annots       map[string]string
+		wantConflict bool
+	}{
+		{
+			name:   "same-source",
+			annots: map[string]string{ownerAnnotation: string(owners), exportSourceAnnotation: `{"namespace":"default","name":"web"}`},
+		},
+		{
+			name:         "other-source",
+			annots:       map[string]string{ownerAnnotation: string(owners), exportSourceAnnotation: `{"namespace":"default-web","name":"x"}`},
+			wantConflict: true,
+		},
+		{
+			// E.g. a Tailscale Service for an HA Ingress of another
+			// cluster.
+			name:         "not-an-export",
+			annots:       map[string]string{ownerAnnotation: string(owners)},
+			wantConflict: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ft.vipServices = map[tailcfg.ServiceName]*tailscale.VIPService{
+				serviceName: {Name: serviceName, Annotations: tt.annots},
+			}
+			expectRequeue(t, r, "default", "web")
+			if tt.wantConflict {
+				expectServiceExportCondition(t, fc, tsapi.ServiceExportReady, metav1.ConditionFalse, reasonServiceExportConflict)
+				if got, err := getSingleObject[corev1.Service](context.Background(), fc, se.Namespace, exportSvcLabels(se)); err != nil || got != nil {
+					t.Errorf("got ClusterIP Service for export %v (error %v), want none", got, err)
+				}
+			} else {
+				expectServiceExportCondition(t, fc, tsapi.ServiceExportReady, metav1.ConditionFalse, reasonServiceExportPending)
+				mustGetExportService(t, fc, se)
+			}
+		})
+	}
+}
+
+func TestExportedServiceName(t *testing.T) {
+	for _, tt := range []struct {
+		ns, name string
+		want     tailcfg.ServiceName
+	}{
+		{"default", "web", "svc:default-web-82b3ade9"},
+		{"a", "b-c", "svc:a-b-c-b88f83c8"},
+		{"a-b", "c", "svc:a-b-c-4e84717d"},
+	} {
+		if got := exportedServiceName(tt.ns, tt.name); got != tt.want {
+			t.Errorf("exportedServiceName(%q, %q) = %q, want %q", tt.ns, tt.name, got, tt.want)
+		}
+	}
+	long := exportedServiceName(strings.Repeat("n", 63), strings.Repeat("s", 63))
+	if err := long.Validate(); err != nil {
+		t.Errorf("exportedServiceName of long namespace and name = %q, invalid: %v", long, err)
+	}
+}
+
+func mustGetServiceExport(t *testing.T, cl client.Client) *tsapi.ServiceExport {
+	t.Helper()
+	se := new(tsapi.ServiceExport)
+	if err := cl.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web"}, se); err != nil {
+		t.Fatal(err)
+	}
+	return se
+}
+
+func mustGetExportService(t *testing.T, cl client.Client, se *tsapi.ServiceExport) *corev1.Service {
+	t.Helper()
+	svc, err := getSingleObject[corev1.Service](context.Background(), cl, se.Namespace, exportSvcLabels(se))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc == nil {
+		t.Fatal("ClusterIP Service for export not found")
+	}
+	return svc
+}
+
+func expectServiceExportCondition(t *testing.T, cl client.Client, typ tsapi.ConditionType, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	se := mustGetServiceExport(t, cl)
+	for _, c := range se.Status.Conditions {
+		if c.Type != string(typ) {
+			continue
+		}
+		if c.Status != status || c.Reason != reason {
+			t.Errorf("%s condition is %s/%s (%s), want %s/%s", typ, c.Status, c.Reason, c.Message, status, reason)
+		}
+		return
+	}
+	t.Errorf("%s condition not set", typ)
+}
+
+func mustParseExportSource(t *testing.T, ft *fakeTSClient, serviceName tailcfg.ServiceName) *exportSource {
+	t.Helper()
+	tsSvc, err := ft.GetVIPService(context.Background(), serviceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := parseExportSource(tsSvc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+func expectExportHealth(t *testing.T, ft *fakeTSClient, serviceName tailcfg.ServiceName, want map[string]exportHealth) {
+	t.Helper()
+	tsSvc, err := ft.GetVIPService(context.Background(), serviceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseExportHealth(tsSvc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected export health (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,568 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+)
+
+const (
+	reasonServiceImportInvalid  = "ServiceImportInvalid"
+	reasonServiceImportConflict = "ServiceImportConflict"
+	reasonServiceImportPending  = "ServiceImportPending"
+	reasonServiceImportReady    = "ServiceImportReady"
+
+	// serviceImportHealthInterval is how often the health of the exporting
+	// clusters in a ServiceImport's status is refreshed, and how often
+	// ProxyGroups annotated with AnnotationImportServices look for newly
+	// exported Services.
+	serviceImportHealthInterval = time.Minute
+
+	// AnnotationImportServices, if set to "true" on an egress ProxyGroup,
+	// makes the operator create a ServiceImport that uses the ProxyGroup
+	// for each Service that other clusters export, in the namespace of the
+	// exported Service if it exists in this cluster.
+	AnnotationImportServices = "tailscale.com/import-services"
+
+	// parentTypeProxyGroup is the parent type label value of the
+	// ServiceImports created for ProxyGroups annotated with
+	// AnnotationImportServices.
+	parentTypeProxyGroup = "proxygroup"
+)
+
+// ServiceImportReconciler imports Services exported from other clusters with
+// a ServiceExport. For each ServiceImport, it creates an egress ExternalName
+// Service of the same name that targets the exported Tailscale Service via the
+// ServiceImport's egress ProxyGroup. The egress Service reconcilers then
+// provision the ClusterIP Service that routes cluster traffic to the
+// ProxyGroup's Pods.
+type ServiceImportReconciler struct {
+	client.Client
+
+	recorder    record.EventRecorder
+	logger      *zap.SugaredLogger
+	clock       tstime.Clock
+	tsClient    tsClient
+	lc          localClient
+	tsNamespace string
+
+	mu sync.Mutex // protects following
+
+	// managedImports is a set of all ServiceImport resources that we're
+	// currently managing. This is only used for metrics.
+	managedImports set.Slice[types.UID]
+}
+
+// gaugeServiceImportResources tracks the number of ServiceImport resources
+// that we're currently managing.
+var gaugeServiceImportResources = clientmetric.NewGauge(kubetypes.MetricServiceImportCount)
+
+func (r *ServiceImportReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("ServiceImport", req.NamespacedName)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	si := new(tsapi.ServiceImport)
+	err = r.Get(ctx, req.NamespacedName, si)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("ServiceImport not found, assuming it was deleted")
+		return reconcile.Result{}, nil
+	} else if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get tailscale.com ServiceImport: %w", err)
+	}
+	if !si.DeletionTimestamp.IsZero() {
+		logger.Debugf("ServiceImport is being deleted")
+		return reconcile.Result{}, r.maybeCleanup(ctx, logger, si)
+	}
+
+	if !slices.Contains(si.Finalizers, FinalizerName) {
+		logger.Infof("importing Service")
+		si.Finalizers = append(si.Finalizers, FinalizerName)
+		if err := r.Update(ctx, si); err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to add finalizer: %w", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.managedImports.Add(si.UID)
+	gaugeServiceImportResources.Set(int64(r.managedImports.Len()))
+	r.mu.Unlock()
+
+	oldStatus := si.Status.DeepCopy()
+	defer func() {
+		if !apiequality.Semantic.DeepEqual(oldStatus, &si.Status) {
+			// An error encountered here should get returned by the Reconcile function.
+			err = errors.Join(err, r.Client.Status().Update(ctx, si))
+		}
+	}()
+
+	if err := r.maybeProvision(ctx, logger, si); err != nil {
+		return reconcile.Result{}, err
+	}
+	// The exporting clusters publish their health on the Tailscale
+	// Service, which we can't watch.
+	return reconcile.Result{RequeueAfter: serviceImportHealthInterval}, nil
+}
+
+func (r *ServiceImportReconciler) maybeProvision(ctx context.Context, logger *zap.SugaredLogger, si *tsapi.ServiceImport) error {
+	serviceName := importedServiceName(si)
+	si.Status.TailscaleService = serviceName.String()
+
+	if msg, err := r.validate(ctx, si); err != nil {
+		return err
+	} else if msg != "" {
+		r.recorder.Event(si, corev1.EventTypeWarning, reasonServiceImportInvalid, msg)
+		tsoperator.SetServiceImportCondition(si, tsapi.ServiceImportReady, metav1.ConditionFalse, reasonServiceImportInvalid, msg, si.Generation, r.clock, logger)
+		si.Status.IPs = nil
+		return nil
+	}
+
+	svc, err := r.ensureImportService(ctx, si, serviceName)
+	if err != nil {
+		return err
+	}
+	if svc == nil {
+		msg := fmt.Sprintf("Service %q already exists and is not managed by this ServiceImport", si.Name)
+		r.recorder.Event(si, corev1.EventTypeWarning, reasonServiceImportConflict, msg)
+		tsoperator.SetServiceImportCondition(si, tsapi.ServiceImportReady, metav1.ConditionFalse, reasonServiceImportConflict, msg, si.Generation, r.clock, logger)
+		si.Status.IPs = nil
+		return nil
+	}
+
+	// The ClusterIP Service that the egress Service reconciler routes
+	// cluster traffic through.
+	clusterIPSvc, err := getSingleObject[corev1.Service](ctx, r.Client, r.tsNamespace, egressSvcChildResourceLabels(svc))
+	if err != nil {
+		return fmt.Errorf("error getting ClusterIP Service: %w", err)
+	}
+	si.Status.IPs = nil
+	if clusterIPSvc != nil {
+		si.Status.IPs = clusterIPSvc.Spec.ClusterIPs
+	}
+
+	exporters, err := r.exporters(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	si.Status.Exporters = exporters
+
+	// The egress Service reconcilers validate the ExternalName Service and
+	// report whether the ProxyGroup is ready to route traffic to the
+	// Tailscale Service.
+	if cond := tsoperator.GetServiceCondition(svc, tsapi.EgressSvcValid); cond != nil && cond.Status == metav1.ConditionFalse {
+		tsoperator.SetServiceImportCondition(si, tsapi.ServiceImportReady, metav1.ConditionFalse, reasonServiceImportInvalid, cond.Message, si.Generation, r.clock, logger)
+		return nil
+	}
+	cond := tsoperator.GetServiceCondition(svc, tsapi.EgressSvcReady)
+	switch {
+	case cond == nil:
+		tsoperator.SetServiceImportCondition(si, tsapi.ServiceImportReady, metav1.ConditionFalse, reasonServiceImportPending, "waiting for the ProxyGroup to route traffic to the Tailscale Service", si.Generation, r.clock, logger)
+	case cond.Status == metav1.ConditionTrue:
+		tsoperator.SetServiceImportCondition(si, tsapi.ServiceImportReady, metav1.ConditionTrue, reasonServiceImportReady, cond.Message, si.Generation, r.clock, logger)
+	default:
+		tsoperator.SetServiceImportCondition(si, tsapi.ServiceImportReady, metav1.ConditionFalse, reasonServiceImportPending, cond.Message, si.Generation, r.clock, logger)
+	}
+	return nil
+}
+
+// validate returns a message describing why si is invalid, or an empty
+// message if it is valid.
+func (r *ServiceImportReconciler) validate(ctx context.Context, si *tsapi.ServiceImport) (string, error) {
+	if err := importedServiceName(si).Validate(); err != nil {
+		return fmt.Sprintf("invalid Tailscale Service name: %v", err), nil
+	}
+	pg := new(tsapi.ProxyGroup)
+	if err := r.Get(ctx, client.ObjectKey{Name: si.Spec.ProxyGroup}, pg); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("error getting ProxyGroup %q: %w", si.Spec.ProxyGroup, err)
+		}
+		return fmt.Sprintf("ProxyGroup %q not found", si.Spec.ProxyGroup), nil
+	}
+	if pg.Spec.Type != tsapi.ProxyGroupTypeEgress {
+		return fmt.Sprintf("ProxyGroup %q is of type %q but must be of type %q", pg.Name, pg.Spec.Type, tsapi.ProxyGroupTypeEgress), nil
+	}
+	return "", nil
+}
+
+// ensureImportService creates or updates the egress ExternalName Service for
+// si. It returns nil if a Service with the ServiceImport's name exists that is
+// not managed by si.
+func (r *ServiceImportReconciler) ensureImportService(ctx context.Context, si *tsapi.ServiceImport, serviceName tailcfg.ServiceName) (*corev1.Service, error) {
+	tcd, err := tailnetCertDomain(ctx, r.lc)
+	if err != nil {
+		return nil, err
+	}
+	annots := map[string]string{
+		AnnotationTailnetTargetFQDN: serviceName.WithoutPrefix() + "." + tcd,
+		AnnotationProxyGroup:        si.Spec.ProxyGroup,
+	}
+	var ports []corev1.ServicePort
+	for _, p := range si.Spec.Ports {
+		ports = append(ports, corev1.ServicePort{
+			Name:     p.Name,
+			Protocol: cmp.Or(p.Protocol, corev1.ProtocolTCP),
+			Port:     p.Port,
+		})
+	}
+
+	svc := new(corev1.Service)
+	err = r.Get(ctx, client.ObjectKeyFromObject(si), svc)
+	if apierrors.IsNotFound(err) {
+		svc = &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      si.Name,
+				Namespace: si.Namespace,
+				Labels: map[string]string{
+					LabelParentType:      "serviceimport",
+					LabelParentName:      si.Name,
+					LabelParentNamespace: si.Namespace,
+				},
+				Annotations:     annots,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(si, tsapi.SchemeGroupVersion.WithKind("ServiceImport"))},
+			},
+			Spec: corev1.ServiceSpec{
+				Type: corev1.ServiceTypeExternalName,
+				// The egress Service reconciler points the
+				// Service at the ClusterIP Service that it
+				// creates.
+				ExternalName: "placeholder",
+				Ports:        ports,
+			},
+		}
+		if err := r.Create(ctx, svc); err != nil {
+			return nil, fmt.Errorf("error creating Service: %w", err)
+		}
+		return svc, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting Service: %w", err)
+	}
+	if !metav1.IsControlledBy(svc, si) {
+		return nil, nil
+	}
+
+	annotsUpToDate := true
+	for k, v := range annots {
+		annotsUpToDate = annotsUpToDate && svc.Annotations[k] == v
+	}
+	if annotsUpToDate && portsEqualIgnoringTargetPort(svc.Spec.Ports, ports) {
+		return svc, nil
+	}
+	for k, v := range annots {
+		mak.Set(&svc.Annotations, k, v)
+	}
+	svc.Spec.Ports = ports
+	if err := r.Update(ctx, svc); err != nil {
+		return nil, fmt.Errorf("error updating Service: %w", err)
+	}
+	return svc, nil
+}
+
+// exporters returns the health of the Service in the clusters that export it,
+// as published on the Tailscale Service. Stale health, e.g. of clusters that
+// were torn down without deleting their ServiceExports, is ignored.
+func (r *ServiceImportReconciler) exporters(ctx context.Context, serviceName tailcfg.ServiceName) ([]tsapi.ServiceImportExporter, error) {
+	tsSvc, err := r.tsClient.GetVIPService(ctx, serviceName)
+	if err != nil {
+		if isErrorTailscaleServiceNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting Tailscale Service %q: %w", serviceName, err)
+	}
+	health, err := parseExportHealth(tsSvc)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing export health of Tailscale Service %q: %w", serviceName, err)
+	}
+	var exporters []tsapi.ServiceImportExporter
+	now := r.clock.Now()
+	for _, id := range slices.Sorted(maps.Keys(health)) {
+		h := health[id]
+		if h.stale(now) {
+			continue
+		}
+		exporters = append(exporters, tsapi.ServiceImportExporter{
+			Operator:       cmp.Or(h.Operator, id),
+			ReadyEndpoints: h.ReadyEndpoints,
+			ReadyProxies:   h.ReadyProxies,
+		})
+	}
+	return exporters, nil
+}
+
+func (r *ServiceImportReconciler) maybeCleanup(ctx context.Context, logger *zap.SugaredLogger, si *tsapi.ServiceImport) error {
+	ix := slices.Index(si.Finalizers, FinalizerName)
+	if ix < 0 {
+		return nil
+	}
+	svc := new(corev1.Service)
+	err := r.Get(ctx, client.ObjectKeyFromObject(si), svc)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error getting Service: %w", err)
+	}
+	if err == nil && metav1.IsControlledBy(svc, si) {
+		// The egress Service reconciler cleans up the ProxyGroup
+		// config and the ClusterIP Service.
+		logger.Infof("deleting Service %s for import", svc.Name)
+		if err := r.Delete(ctx, svc); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting Service: %w", err)
+		}
+	}
+	si.Finalizers = append(si.Finalizers[:ix], si.Finalizers[ix+1:]...)
+	if err := r.Update(ctx, si); err != nil {
+		return fmt.Errorf("failed to remove finalizer: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.managedImports.Remove(si.UID)
+	gaugeServiceImportResources.Set(int64(r.managedImports.Len()))
+	logger.Infof("ServiceImport resources have been cleaned up")
+	return nil
+}
+
+// importedServiceName returns the name of the Tailscale Service that si
+// imports.
+func importedServiceName(si *tsapi.ServiceImport) tailcfg.ServiceName {
+	if si.Spec.TailscaleService != "" {
+		return tailcfg.ServiceName(si.Spec.TailscaleService)
+	}
+	return exportedServiceName(si.Namespace, si.Name)
+}
+
+// portsEqualIgnoringTargetPort reports whether a and b are equal, ignoring
+// target ports, which the API server defaults.
+func portsEqualIgnoringTargetPort(a, b []corev1.ServicePort) bool {
+	return slices.EqualFunc(a, b, func(x, y corev1.ServicePort) bool {
+		return x.Name == y.Name && x.Protocol == y.Protocol && x.Port == y.Port
+	})
+}
+
+// serviceImportsFromService is an event handler for Services. It returns a
+// reconcile request for the ServiceImport of the same name, if any, so that
+// conflicts with existing Services are resolved once they are deleted and
+// the status of the ServiceImport reflects that of its egress Service. For the
+// ClusterIP Services of egress Services, it returns a reconcile request for
+// the ServiceImport of the egress Service, if any.
+func serviceImportsFromService(ctx context.Context, o client.Object) []reconcile.Request {
+	ls := o.GetLabels()
+	if ls[labelSvcType] == typeEgress && ls[LabelParentType] == "svc" {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: ls[LabelParentNamespace], Name: ls[LabelParentName]}}}
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(o)}}
+}
+
+// serviceImportsFromProxyGroup is an event handler for ProxyGroups. It returns
+// reconcile requests for all ServiceImports that use the ProxyGroup.
+func serviceImportsFromProxyGroup(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		sis := new(tsapi.ServiceImportList)
+		if err := cl.List(ctx, sis); err != nil {
+			logger.Infof("error listing ServiceImports: %v, skipping a reconcile for event on ProxyGroup %s", err, o.GetName())
+			return nil
+		}
+		var reqs []reconcile.Request
+		for _, si := range sis.Items {
+			if si.Spec.ProxyGroup == o.GetName() {
+				reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&si)})
+			}
+		}
+		return reqs
+	}
+}
+
+// ServiceImportDiscoveryReconciler creates ServiceImports for Services that
+// other clusters export, using egress ProxyGroups annotated with
+// AnnotationImportServices. It finds the exported Services from the export
+// source published on their Tailscale Services, and deletes the ServiceImports
+// it created once the Services are no longer exported.
+type ServiceImportDiscoveryReconciler struct {
+	client.Client
+
+	logger   *zap.SugaredLogger
+	clock    tstime.Clock
+	tsClient tsClient
+}
+
+func (r *ServiceImportDiscoveryReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("ProxyGroup", req.Name)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	pg := new(tsapi.ProxyGroup)
+	err = r.Get(ctx, req.NamespacedName, pg)
+	if apierrors.IsNotFound(err) {
+		// The ServiceImports are garbage collected with the ProxyGroup.
+		logger.Debugf("ProxyGroup not found, assuming it was deleted")
+		return reconcile.Result{}, nil
+	} else if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get tailscale.com ProxyGroup: %w", err)
+	}
+
+	var want map[types.NamespacedName]*exportSource
+	if pg.DeletionTimestamp.IsZero() && pg.Spec.Type == tsapi.ProxyGroupTypeEgress && pg.Annotations[AnnotationImportServices] == "true" {
+		if want, err = r.exportedServices(ctx, logger); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	existing := new(tsapi.ServiceImportList)
+	if err := r.List(ctx, existing, client.MatchingLabels(autoImportLabels(pg))); err != nil {
+		return reconcile.Result{}, fmt.Errorf("error listing ServiceImports: %w", err)
+	}
+	for _, si := range existing.Items {
+		if _, ok := want[client.ObjectKeyFromObject(&si)]; ok {
+			continue
+		}
+		logger.Infof("deleting ServiceImport %s/%s, as the Service is no longer exported", si.Namespace, si.Name)
+		if err := r.Delete(ctx, &si); err != nil && !apierrors.IsNotFound(err) {
+			return reconcile.Result{}, fmt.Errorf("error deleting ServiceImport: %w", err)
+		}
+	}
+	for nn, src := range want {
+		if err := r.ensureServiceImport(ctx, pg, nn, src, logger); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	if want == nil {
+		return reconcile.Result{}, nil
+	}
+	// Exports are published on Tailscale Services, which we can't watch.
+	return reconcile.Result{RequeueAfter: serviceImportHealthInterval}, nil
+}
+
+// exportedServices returns the Services that other clusters export and that
+// can be imported into this cluster, keyed by namespace and name.
+func (r *ServiceImportDiscoveryReconciler) exportedServices(ctx context.Context, logger *zap.SugaredLogger) (map[types.NamespacedName]*exportSource, error) {
+	tsSvcs, err := r.tsClient.ListVIPServices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing Tailscale Services: %w", err)
+	}
+	want := make(map[types.NamespacedName]*exportSource)
+	now := r.clock.Now()
+	for _, tsSvc := range tsSvcs.VIPServices {
+		src, err := parseExportSource(&tsSvc)
+		if err != nil {
+			logger.Infof("error parsing export source of Tailscale Service %q, not importing it: %v", tsSvc.Name, err)
+			continue
+		}
+		if src == nil || len(src.Ports) == 0 || exportedServiceName(src.Namespace, src.Name) != tsSvc.Name {
+			continue
+		}
+		health, err := parseExportHealth(&tsSvc)
+		if err != nil {
+			logger.Infof("error parsing export health of Tailscale Service %q, not importing it: %v", tsSvc.Name, err)
+			continue
+		}
+		live := false
+		for _, h := range health {
+			live = live || !h.stale(now)
+		}
+		if !live {
+			// All exporting clusters are gone.
+			continue
+		}
+		nn := types.NamespacedName{Namespace: src.Namespace, Name: src.Name}
+		ok, err := r.canImport(ctx, nn)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			want[nn] = src
+		}
+	}
+	return want, nil
+}
+
+// canImport reports whether the Service nn can be imported into this cluster,
+// which requires the Service's namespace to exist and the Service not to be
+// exported from this cluster.
+func (r *ServiceImportDiscoveryReconciler) canImport(ctx context.Context, nn types.NamespacedName) (bool, error) {
+	if err := r.Get(ctx, client.ObjectKey{Name: nn.Namespace}, new(corev1.Namespace)); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error getting Namespace %q: %w", nn.Namespace, err)
+	}
+	if err := r.Get(ctx, nn, new(tsapi.ServiceExport)); err == nil {
+		return false, nil
+	} else if !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("error getting ServiceExport %s: %w", nn, err)
+	}
+	return true, nil
+}
+
+// ensureServiceImport creates or updates the ServiceImport nn for the
+// exported Service src, unless a ServiceImport nn exists that pg didn't
+// create.
+func (r *ServiceImportDiscoveryReconciler) ensureServiceImport(ctx context.Context, pg *tsapi.ProxyGroup, nn types.NamespacedName, src *exportSource, logger *zap.SugaredLogger) error {
+	si := new(tsapi.ServiceImport)
+	err := r.Get(ctx, nn, si)
+	if apierrors.IsNotFound(err) {
+		logger.Infof("creating ServiceImport %s for exported Service", nn)
+		si = &tsapi.ServiceImport{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            nn.Name,
+				Namespace:       nn.Namespace,
+				Labels:          autoImportLabels(pg),
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(pg, tsapi.SchemeGroupVersion.WithKind("ProxyGroup"))},
+			},
+			Spec: tsapi.ServiceImportSpec{
+				ProxyGroup: pg.Name,
+				Ports:      src.Ports,
+			},
+		}
+		if err := r.Create(ctx, si); err != nil {
+			return fmt.Errorf("error creating ServiceImport %s: %w", nn, err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error getting ServiceImport %s: %w", nn, err)
+	}
+	if !metav1.IsControlledBy(si, pg) {
+		// Imported by hand or via another ProxyGroup.
+		return nil
+	}
+	if slices.Equal(si.Spec.Ports, src.Ports) {
+		return nil
+	}
+	si.Spec.Ports = src.Ports
+	if err := r.Update(ctx, si); err != nil {
+		return fmt.Errorf("error updating ServiceImport %s: %w", nn, err)
+	}
+	return nil
+}
+
+func autoImportLabels(pg *tsapi.ProxyGroup) map[string]string {
+	return map[string]string{
+		kubetypes.LabelManaged: "true",
+		LabelParentType:        parentTypeProxyGroup,
+		LabelParentName:        pg.Name,
+	}
+}
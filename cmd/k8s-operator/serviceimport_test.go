@@ -0,0 +1,322 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"tailscale.com/internal/client/tailscale"
+	"tailscale.com/ipn/ipnstate"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+)
+
+func TestServiceImportReconciler(t *testing.T) {
+	pg := &tsapi.ProxyGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "egress-pg"},
+		Spec:       tsapi.ProxyGroupSpec{Type: tsapi.ProxyGroupTypeEgress},
+	}
+	si := &tsapi.ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("1234-UID")},
+		Spec: tsapi.ServiceImportSpec{
+			ProxyGroup: "egress-pg",
+			Ports:      []tsapi.ServiceImportPort{{Name: "http", Port: 80}},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(pg, si).
+		WithStatusSubresource(si, &corev1.Service{}).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := tstest.NewClock(tstest.ClockOpts{Start: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)})
+	ft := &fakeTSClient{}
+	r := &ServiceImportReconciler{
+		Client:      fc,
+		recorder:    record.NewFakeRecorder(10),
+		logger:      zl.Sugar(),
+		clock:       cl,
+		tsClient:    ft,
+		tsNamespace: "operator-ns",
+		lc: &fakeLocalClient{
+			status: &ipnstate.Status{
+				CurrentTailnet: &ipnstate.TailnetStatus{MagicDNSSuffix: "tails.ts.net"},
+			},
+		},
+	}
+
+	// The imported Service is an egress Service for the Tailscale Service.
+	expectRequeue(t, r, "default", "web")
+	svc := new(corev1.Service)
+	if err := fc.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web"}, svc); err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(svc, si) {
+		t.Errorf("Service is not controlled by the ServiceImport")
+	}
+	wantAnnots := map[string]string{
+		AnnotationTailnetTargetFQDN: "default-web-82b3ade9.tails.ts.net",
+		AnnotationProxyGroup:        "egress-pg",
+	}
+	if diff := cmp.Diff(svc.Annotations, wantAnnots); diff != "" {
+		t.Errorf("unexpected annotations (-got +want):\n%s", diff)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeExternalName {
+		t.Errorf("got Service type %q, want %q", svc.Spec.Type, corev1.ServiceTypeExternalName)
+	}
+	wantPorts := []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80}}
+	if diff := cmp.Diff(svc.Spec.Ports, wantPorts); diff != "" {
+		t.Errorf("unexpected ports (-got +want):\n%s", diff)
+	}
+	expectServiceImportCondition(t, fc, metav1.ConditionFalse, reasonServiceImportPending)
+
+	// Once the egress Service is ready, the status reports its cluster IPs
+	// and the exporting clusters.
+	mustUpdateStatus(t, fc, "default", "web", func(s *corev1.Service) {
+		tsoperator.SetServiceCondition(s, tsapi.EgressSvcReady, metav1.ConditionTrue, "Ready", "Ready", cl, zl.Sugar())
+	})
+	mustCreate(t, fc, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ts-web-abcde",
+			Namespace: "operator-ns",
+			Labels:    egressSvcChildResourceLabels(svc),
+		},
+		Spec: corev1.ServiceSpec{ClusterIPs: []string{"10.0.0.2"}},
+	})
+	serviceName := tailcfg.ServiceName("svc:default-web-82b3ade9")
+	ft.vipServices = map[tailcfg.ServiceName]*tailscale.VIPService{
+		serviceName: {
+			Name: serviceName,
+			Annotations: map[string]string{
+				// The health of operator-c is stale and ignored.
+				exportHealthAnnotation: `{"id-b":{"operator":"operator-b.tails.ts.net","readyEndpoints":3,"readyProxies":2,"updated":"2025-01-01T00:00:00Z"},"id-a":{"operator":"operator-a.tails.ts.net","readyEndpoints":1,"readyProxies":1,"updated":"2024-12-31T23:55:00Z"},"id-c":{"operator":"operator-c.tails.ts.net","readyEndpoints":1,"readyProxies":1,"updated":"2024-12-31T23:00:00Z"}}`,
+			},
+		},
+	}
+	expectRequeue(t, r, "default", "web")
+	expectServiceImportCondition(t, fc, metav1.ConditionTrue, reasonServiceImportReady)
+	got := mustGetServiceImport(t, fc)
+	if diff := cmp.Diff(got.Status.IPs, []string{"10.0.0.2"}); diff != "" {
+		t.Errorf("unexpected IPs (-got +want):\n%s", diff)
+	}
+	wantExporters := []tsapi.ServiceImportExporter{
+		{Operator: "operator-a.tails.ts.net", ReadyEndpoints: 1, ReadyProxies: 1},
+		{Operator: "operator-b.tails.ts.net", ReadyEndpoints: 3, ReadyProxies: 2},
+	}
+	if diff := cmp.Diff(got.Status.Exporters, wantExporters); diff != "" {
+		t.Errorf("unexpected exporters (-got +want):\n%s", diff)
+	}
+	if got.Status.TailscaleService != serviceName.String() {
+		t.Errorf("got Tailscale Service %q, want %q", got.Status.TailscaleService, serviceName)
+	}
+
+	// Deleting the ServiceImport deletes the Service.
+	if err := fc.Delete(context.Background(), got); err != nil {
+		t.Fatal(err)
+	}
+	expectReconciled(t, r, "default", "web")
+	expectMissing[corev1.Service](t, fc, "default", "web")
+	expectMissing[tsapi.ServiceImport](t, fc, "default", "web")
+}
+
+func TestServiceImportConflict(t *testing.T) {
+	pg := &tsapi.ProxyGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "egress-pg"},
+		Spec:       tsapi.ProxyGroupSpec{Type: tsapi.ProxyGroupTypeEgress},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.1"},
+	}
+	si := &tsapi.ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: types.UID("1234-UID")},
+		Spec: tsapi.ServiceImportSpec{
+			ProxyGroup: "egress-pg",
+			Ports:      []tsapi.ServiceImportPort{{Port: 80}},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(pg, svc, si).
+		WithStatusSubresource(si).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &ServiceImportReconciler{
+		Client:      fc,
+		recorder:    record.NewFakeRecorder(10),
+		logger:      zl.Sugar(),
+		clock:       tstest.NewClock(tstest.ClockOpts{}),
+		tsClient:    &fakeTSClient{},
+		tsNamespace: "operator-ns",
+		lc: &fakeLocalClient{
+			status: &ipnstate.Status{
+				CurrentTailnet: &ipnstate.TailnetStatus{MagicDNSSuffix: "tails.ts.net"},
+			},
+		},
+	}
+
+	// An existing Service is left alone.
+	expectRequeue(t, r, "default", "web")
+	expectServiceImportCondition(t, fc, metav1.ConditionFalse, reasonServiceImportConflict)
+	expectEqual(t, fc, svc)
+
+	// An egress ProxyGroup is required.
+	mustUpdate(t, fc, "", "egress-pg", func(pg *tsapi.ProxyGroup) {
+		pg.Spec.Type = tsapi.ProxyGroupTypeIngress
+	})
+	expectRequeue(t, r, "default", "web")
+	expectServiceImportCondition(t, fc, metav1.ConditionFalse, reasonServiceImportInvalid)
+}
+
+func TestServiceImportDiscovery(t *testing.T) {
+	pg := &tsapi.ProxyGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "egress-pg",
+			UID:         types.UID("pg-UID"),
+			Annotations: map[string]string{AnnotationImportServices: "true"},
+		},
+		Spec: tsapi.ProxyGroupSpec{Type: tsapi.ProxyGroupTypeEgress},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(
+			pg,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "local"}},
+			// Exported from this cluster, so not imported.
+			&tsapi.ServiceExport{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "local"}},
+			// Imported by hand, so left alone.
+			&tsapi.ServiceImport{
+				ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+				Spec:       tsapi.ServiceImportSpec{ProxyGroup: "other-pg", Ports: []tsapi.ServiceImportPort{{Port: 5432}}},
+			},
+		).
+		Build()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ft := &fakeTSClient{vipServices: map[tailcfg.ServiceName]*tailscale.VIPService{}}
+	export := func(ns, name, health string, ports ...int32) {
+		src := exportSource{Namespace: ns, Name: name}
+		for _, p := range ports {
+			src.Ports = append(src.Ports, tsapi.ServiceImportPort{Protocol: corev1.ProtocolTCP, Port: p})
+		}
+		b, err := json.Marshal(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serviceName := exportedServiceName(ns, name)
+		ft.vipServices[serviceName] = &tailscale.VIPService{
+			Name: serviceName,
+			Annotations: map[string]string{
+				exportSourceAnnotation: string(b),
+				exportHealthAnnotation: health,
+			},
+		}
+	}
+	const live = `{"id-b":{"operator":"operator-b.tails.ts.net","readyEndpoints":1,"readyProxies":1,"updated":"2025-01-01T00:00:00Z"}}`
+	const stale = `{"id-b":{"operator":"operator-b.tails.ts.net","readyEndpoints":1,"readyProxies":1,"updated":"2024-12-31T23:00:00Z"}}`
+	export("default", "web", live, 80)
+	export("default", "db", live, 5432)
+	export("default", "old", stale, 80)
+	export("local", "api", live, 80)
+	export("missing", "web", live, 80)
+	r := &ServiceImportDiscoveryReconciler{
+		Client:   fc,
+		logger:   zap.NewNop().Sugar(),
+		clock:    tstest.NewClock(tstest.ClockOpts{Start: now}),
+		tsClient: ft,
+	}
+
+	expectImports := func(want ...string) {
+		t.Helper()
+		sis := new(tsapi.ServiceImportList)
+		if err := fc.List(context.Background(), sis, client.MatchingLabels(autoImportLabels(pg))); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, si := range sis.Items {
+			got = append(got, fmt.Sprintf("%s/%s:%v", si.Namespace, si.Name, si.Spec.Ports))
+		}
+		slices.Sort(got)
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("unexpected ServiceImports (-got +want):\n%s", diff)
+		}
+	}
+
+	expectRequeue(t, r, "", "egress-pg")
+	expectImports("default/web:[{ TCP 80}]")
+	si := new(tsapi.ServiceImport)
+	if err := fc.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web"}, si); err != nil {
+		t.Fatal(err)
+	}
+	if si.Spec.ProxyGroup != "egress-pg" || !metav1.IsControlledBy(si, pg) {
+		t.Errorf("ServiceImport has ProxyGroup %q and owners %v, want it to be created for egress-pg", si.Spec.ProxyGroup, si.OwnerReferences)
+	}
+
+	// Port changes are picked up.
+	export("default", "web", live, 80, 443)
+	expectRequeue(t, r, "", "egress-pg")
+	expectImports("default/web:[{ TCP 80} { TCP 443}]")
+
+	// The ServiceImport is deleted once the Service is no longer exported.
+	delete(ft.vipServices, exportedServiceName("default", "web"))
+	expectRequeue(t, r, "", "egress-pg")
+	expectImports()
+
+	// As are all ServiceImports once the annotation is removed.
+	export("default", "web", live, 80)
+	expectRequeue(t, r, "", "egress-pg")
+	expectImports("default/web:[{ TCP 80}]")
+	mustUpdate(t, fc, "", "egress-pg", func(pg *tsapi.ProxyGroup) {
+		delete(pg.Annotations, AnnotationImportServices)
+	})
+	expectReconciled(t, r, "", "egress-pg")
+	expectImports()
+}
+
+func mustGetServiceImport(t *testing.T, cl client.Client) *tsapi.ServiceImport {
+	t.Helper()
+	si := new(tsapi.ServiceImport)
+	if err := cl.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web"}, si); err != nil {
+		t.Fatal(err)
+	}
+	return si
+}
+
+func expectServiceImportCondition(t *testing.T, cl client.Client, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	si := mustGetServiceImport(t, cl)
+	for _, c := range si.Status.Conditions {
+		if c.Type != string(tsapi.ServiceImportReady) {
+			continue
+		}
+		if c.Status != status || c.Reason != reason {
+			t.Errorf("ServiceImportReady condition is %s/%s (%s), want %s/%s", c.Status, c.Reason, c.Message, status, reason)
+		}
+		return
+	}
+	t.Error("ServiceImportReady condition not set")
+}
@@ -807,6 +807,9 @@ func (r *HAServiceReconciler) validateService(ctx context.Context, svc *corev1.S
 		errs = append(errs, fmt.Errorf("ProxyGroup %q is of type %q but must be of type %q",
 			pg.Name, pg.Spec.Type, tsapi.ProxyGroupTypeIngress))
 	}
+	if isManagedByType(pg, "gateway") {
+		errs = append(errs, fmt.Errorf("ProxyGroup %q is managed by Gateway %s and can't be used for Services", pg.Name, parentFromObjectLabels(pg)))
+	}
 	if violations := validateService(svc); len(violations) > 0 {
 		errs = append(errs, fmt.Errorf("invalid Service: %s", strings.Join(violations, ", ")))
 	}
@@ -0,0 +1,492 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/set"
+)
+
+const (
+	reasonTailnetAccessPolicyInvalid      = "TailnetAccessPolicyInvalid"
+	reasonTailnetAccessPolicyValid        = "TailnetAccessPolicyValid"
+	reasonTailnetAccessPolicyNotEnforced  = "TailnetAccessPolicyNotEnforced"
+	reasonTailnetAccessTargetNotFound     = "TailnetAccessTargetNotFound"
+	messageTailnetAccessPolicyInvalid     = "TailnetAccessPolicy is not valid: %v"
+	messageTailnetAccessPolicyNotEnforced = "TailnetAccessPolicy can't be enforced: %s"
+
+	// tailnetAccessMinCapVer is the capability version from which proxies
+	// enforce the AllowFrom restrictions of their serve config. Older
+	// proxies ignore them.
+	tailnetAccessMinCapVer tailcfg.CapabilityVersion = 135
+
+	// tailnetAccessRecheckInterval is how often a TailnetAccessPolicy that
+	// can't be enforced is checked again, to notice proxies being
+	// upgraded.
+	tailnetAccessRecheckInterval = 5 * time.Minute
+)
+
+// TailnetAccessPolicyReconciler validates TailnetAccessPolicies and reports
+// their status. The policies themselves are compiled into the serve configs of
+// the ingress proxies by the Ingress and Gateway reconcilers, see
+// tailnetAccessAllowFrom. Policies that target Services that are also exposed
+// in ways that don't enforce them, or that are served by proxies too old to
+// enforce them, are reported as not Ready.
+type TailnetAccessPolicyReconciler struct {
+	client.Client
+
+	recorder              record.EventRecorder
+	logger                *zap.SugaredLogger
+	clock                 tstime.Clock
+	tsNamespace           string
+	isDefaultLoadBalancer bool // whether the operator's proxies are the default LoadBalancer implementation
+	gatewayAPI            bool // whether the Gateway API CRDs are installed
+	tcpRoutes             bool // whether the TCPRoute CRD is installed
+	tlsRoutes             bool // whether the TLSRoute CRD is installed
+
+	mu sync.Mutex // protects following
+
+	// managedPolicies is a set of all TailnetAccessPolicy resources that
+	// we're currently managing. This is only used for metrics.
+	managedPolicies set.Slice[types.UID]
+}
+
+// gaugeTailnetAccessPolicyResources tracks the number of TailnetAccessPolicy
+// resources that we're currently managing.
+var gaugeTailnetAccessPolicyResources = clientmetric.NewGauge(kubetypes.MetricTailnetAccessPolicyCount)
+
+func (r *TailnetAccessPolicyReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("TailnetAccessPolicy", req.NamespacedName)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	tap := new(tsapi.TailnetAccessPolicy)
+	err = r.Get(ctx, req.NamespacedName, tap)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("TailnetAccessPolicy not found, assuming it was deleted")
+		return reconcile.Result{}, nil
+	} else if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get tailscale.com TailnetAccessPolicy: %w", err)
+	}
+	if !tap.DeletionTimestamp.IsZero() {
+		logger.Debugf("TailnetAccessPolicy is being deleted")
+		return reconcile.Result{}, r.maybeCleanup(ctx, logger, tap)
+	}
+
+	// Add a finalizer so that we can ensure that metrics get updated when
+	// this TailnetAccessPolicy is deleted.
+	if !slices.Contains(tap.Finalizers, FinalizerName) {
+		logger.Debugf("updating TailnetAccessPolicy finalizers")
+		tap.Finalizers = append(tap.Finalizers, FinalizerName)
+		if err := r.Update(ctx, tap); err != nil {
+			return res, fmt.Errorf("failed to add finalizer: %w", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.managedPolicies.Add(tap.UID)
+	gaugeTailnetAccessPolicyResources.Set(int64(r.managedPolicies.Len()))
+	r.mu.Unlock()
+
+	oldStatus := tap.Status.DeepCopy()
+	if errs := validateTailnetAccessPolicy(tap); errs != nil {
+		msg := fmt.Sprintf(messageTailnetAccessPolicyInvalid, errs.ToAggregate().Error())
+		r.recorder.Event(tap, corev1.EventTypeWarning, reasonTailnetAccessPolicyInvalid, msg)
+		tsoperator.SetTailnetAccessPolicyCondition(tap, tsapi.TailnetAccessPolicyReady, metav1.ConditionFalse, reasonTailnetAccessPolicyInvalid, msg, tap.Generation, r.clock, logger)
+	} else {
+		problems, err := r.checkTargets(ctx, tap, logger)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if len(problems) > 0 {
+			msg := fmt.Sprintf(messageTailnetAccessPolicyNotEnforced, strings.Join(problems, "; "))
+			r.recorder.Event(tap, corev1.EventTypeWarning, reasonTailnetAccessPolicyNotEnforced, msg)
+			tsoperator.SetTailnetAccessPolicyCondition(tap, tsapi.TailnetAccessPolicyReady, metav1.ConditionFalse, reasonTailnetAccessPolicyNotEnforced, msg, tap.Generation, r.clock, logger)
+			res.RequeueAfter = tailnetAccessRecheckInterval
+		} else {
+			tsoperator.SetTailnetAccessPolicyCondition(tap, tsapi.TailnetAccessPolicyReady, metav1.ConditionTrue, reasonTailnetAccessPolicyValid, reasonTailnetAccessPolicyValid, tap.Generation, r.clock, logger)
+		}
+	}
+	if !apiequality.Semantic.DeepEqual(oldStatus, &tap.Status) {
+		if err := r.Client.Status().Update(ctx, tap); err != nil {
+			logger.Errorf("error updating TailnetAccessPolicy status: %v", err)
+			return reconcile.Result{}, err
+		}
+	}
+	return res, nil
+}
+
+// checkTargets records a warning Event on tap for each Service or Service
+// port that it targets that doesn't exist. Such rules are still applied, so
+// that access is restricted as soon as the Service is created.
+//
+// It returns the reasons why tap can't be enforced, if any: a targeted Service
+// is also exposed on the tailnet by proxies that don't enforce
+// TailnetAccessPolicies, or one of the ingress proxies that serve a targeted
+// Service is too old to enforce them.
+func (r *TailnetAccessPolicyReconciler) checkTargets(ctx context.Context, tap *tsapi.TailnetAccessPolicy, logger *zap.SugaredLogger) (problems []string, _ error) {
+	targets := make(set.Set[string])
+	for _, rule := range tap.Spec.Rules {
+		for _, to := range rule.To {
+			if targets.Contains(to.Service) {
+				continue
+			}
+			targets.Add(to.Service)
+			svc := new(corev1.Service)
+			if err := r.Get(ctx, client.ObjectKey{Namespace: tap.Namespace, Name: to.Service}, svc); err != nil {
+				if !apierrors.IsNotFound(err) {
+					return nil, fmt.Errorf("error getting Service %q: %w", to.Service, err)
+				}
+				r.recorder.Eventf(tap, corev1.EventTypeWarning, reasonTailnetAccessTargetNotFound, "Service %q not found", to.Service)
+				continue
+			}
+			for _, port := range to.Ports {
+				if !slices.ContainsFunc(svc.Spec.Ports, func(p corev1.ServicePort) bool { return p.Port == port }) {
+					r.recorder.Eventf(tap, corev1.EventTypeWarning, reasonTailnetAccessTargetNotFound, "Service %q has no port %d", to.Service, port)
+				}
+			}
+			if isTailscaleLoadBalancerService(svc, r.isDefaultLoadBalancer) || hasExposeAnnotation(svc) {
+				problems = append(problems, fmt.Sprintf("Service %q is exposed on the tailnet as a Tailscale LoadBalancer Service or with the %s annotation, which don't enforce TailnetAccessPolicies", to.Service, AnnotationExpose))
+			}
+		}
+	}
+
+	// ServiceExports expose Services of the same name as Tailscale
+	// Services, which don't enforce TailnetAccessPolicies either.
+	ses := new(tsapi.ServiceExportList)
+	if err := r.List(ctx, ses, client.InNamespace(tap.Namespace)); err != nil && !isKindMissingErr(err) {
+		return nil, fmt.Errorf("error listing ServiceExports: %w", err)
+	}
+	for _, se := range ses.Items {
+		if targets.Contains(se.Name) {
+			problems = append(problems, fmt.Sprintf("Service %q is exported by a ServiceExport, which doesn't enforce TailnetAccessPolicies", se.Name))
+		}
+	}
+
+	selectors, err := r.proxySecretSelectors(ctx, tap.Namespace, targets)
+	if err != nil {
+		return nil, err
+	}
+	for _, sel := range selectors {
+		secrets := new(corev1.SecretList)
+		if err := r.List(ctx, secrets, client.InNamespace(r.tsNamespace), client.MatchingLabels(sel)); err != nil {
+			return nil, fmt.Errorf("error listing proxy state Secrets: %w", err)
+		}
+		for _, sec := range secrets.Items {
+			pod := new(corev1.Pod)
+			if err := r.Get(ctx, client.ObjectKey{Namespace: sec.Namespace, Name: sec.Name}, pod); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, fmt.Errorf("error getting proxy Pod %q: %w", sec.Name, err)
+			}
+			// A capability version of -1 means that the Pod hasn't
+			// reported it yet.
+			if cv := proxyCapVer(&sec, string(pod.UID), logger); cv >= 0 && cv < tailnetAccessMinCapVer {
+				problems = append(problems, fmt.Sprintf("proxy Pod %s/%s runs a Tailscale version too old to enforce TailnetAccessPolicies, upgrade it", pod.Namespace, pod.Name))
+			}
+		}
+	}
+	slices.Sort(problems)
+	return slices.Compact(problems), nil
+}
+
+// proxySecretSelectors returns the label selectors for the state Secrets of
+// the ingress proxies that serve any of the Services named in svcNames in
+// namespace ns, as backends of Ingresses or Gateway API routes.
+func (r *TailnetAccessPolicyReconciler) proxySecretSelectors(ctx context.Context, ns string, svcNames set.Set[string]) ([]map[string]string, error) {
+	var sels []map[string]string
+	pgs := make(set.Set[string])
+
+	ings := new(networkingv1.IngressList)
+	if err := r.List(ctx, ings, client.InNamespace(ns)); err != nil {
+		return nil, fmt.Errorf("error listing Ingresses: %w", err)
+	}
+	for _, ing := range ings.Items {
+		if !ingressHasBackendIn(&ing, svcNames) {
+			continue
+		}
+		if pg := ing.Annotations[AnnotationProxyGroup]; pg != "" {
+			pgs.Add(pg)
+		} else {
+			sels = append(sels, childResourceLabels(ing.Name, ing.Namespace, "ingress"))
+		}
+	}
+
+	// Each Gateway is served by its own ProxyGroup.
+	var parentRefs []gatewayv1.ParentReference
+	if r.gatewayAPI {
+		hrs := new(gatewayv1.HTTPRouteList)
+		if err := r.List(ctx, hrs, client.InNamespace(ns)); err != nil {
+			return nil, fmt.Errorf("error listing HTTPRoutes: %w", err)
+		}
+		for _, hr := range hrs.Items {
+			for _, rule := range hr.Spec.Rules {
+				if slices.ContainsFunc(rule.BackendRefs, func(ref gatewayv1.HTTPBackendRef) bool { return backendRefIn(ref.BackendRef, svcNames) }) {
+					parentRefs = append(parentRefs, hr.Spec.ParentRefs...)
+					break
+				}
+			}
+		}
+	}
+	if r.tcpRoutes {
+		trs := new(gatewayv1alpha2.TCPRouteList)
+		if err := r.List(ctx, trs, client.InNamespace(ns)); err != nil {
+			return nil, fmt.Errorf("error listing TCPRoutes: %w", err)
+		}
+		for _, tr := range trs.Items {
+			for _, rule := range tr.Spec.Rules {
+				if slices.ContainsFunc(rule.BackendRefs, func(ref gatewayv1.BackendRef) bool { return backendRefIn(ref, svcNames) }) {
+					parentRefs = append(parentRefs, tr.Spec.ParentRefs...)
+					break
+				}
+			}
+		}
+	}
+	if r.tlsRoutes {
+		trs := new(gatewayv1alpha2.TLSRouteList)
+		if err := r.List(ctx, trs, client.InNamespace(ns)); err != nil {
+			return nil, fmt.Errorf("error listing TLSRoutes: %w", err)
+		}
+		for _, tr := range trs.Items {
+			for _, rule := range tr.Spec.Rules {
+				if slices.ContainsFunc(rule.BackendRefs, func(ref gatewayv1.BackendRef) bool { return backendRefIn(ref, svcNames) }) {
+					parentRefs = append(parentRefs, tr.Spec.ParentRefs...)
+					break
+				}
+			}
+		}
+	}
+	for _, ref := range parentRefs {
+		if ref.Group != nil && *ref.Group != gatewayv1.GroupName || ref.Kind != nil && *ref.Kind != kindGateway {
+			continue
+		}
+		gwNS := ns
+		if ref.Namespace != nil {
+			gwNS = string(*ref.Namespace)
+		}
+		pgs.Add(pgNameForGateway(&gatewayv1.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: gwNS, Name: string(ref.Name)}}))
+	}
+
+	pgNames := pgs.Slice()
+	slices.Sort(pgNames)
+	for _, pg := range pgNames {
+		sels = append(sels, pgSecretLabels(pg, kubetypes.LabelSecretTypeState))
+	}
+	return sels, nil
+}
+
+// ingressHasBackendIn reports whether any of ing's backends is a Service
+// named in svcNames.
+func ingressHasBackendIn(ing *networkingv1.Ingress, svcNames set.Set[string]) bool {
+	in := func(b *networkingv1.IngressBackend) bool {
+		return b != nil && b.Service != nil && svcNames.Contains(b.Service.Name)
+	}
+	if in(ing.Spec.DefaultBackend) {
+		return true
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, p := range rule.HTTP.Paths {
+			if in(&p.Backend) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// backendRefIn reports whether ref, of a route, refers to a Service in the
+// route's namespace that is named in svcNames.
+func backendRefIn(ref gatewayv1.BackendRef, svcNames set.Set[string]) bool {
+	if ref.Group != nil && *ref.Group != "" || ref.Kind != nil && *ref.Kind != "Service" || ref.Namespace != nil {
+		return false
+	}
+	return svcNames.Contains(string(ref.Name))
+}
+
+func (r *TailnetAccessPolicyReconciler) maybeCleanup(ctx context.Context, logger *zap.SugaredLogger, tap *tsapi.TailnetAccessPolicy) error {
+	ix := slices.Index(tap.Finalizers, FinalizerName)
+	if ix >= 0 {
+		tap.Finalizers = append(tap.Finalizers[:ix], tap.Finalizers[ix+1:]...)
+		if err := r.Update(ctx, tap); err != nil {
+			return fmt.Errorf("failed to remove finalizer: %w", err)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.managedPolicies.Remove(tap.UID)
+	gaugeTailnetAccessPolicyResources.Set(int64(r.managedPolicies.Len()))
+	logger.Infof("TailnetAccessPolicy resources have been cleaned up")
+	return nil
+}
+
+// validateTailnetAccessPolicy validates the parts of tap that the CRD's
+// schema doesn't.
+func validateTailnetAccessPolicy(tap *tsapi.TailnetAccessPolicy) (violations field.ErrorList) {
+	for i, rule := range tap.Spec.Rules {
+		for j, from := range rule.From {
+			p := field.NewPath("spec", "rules").Index(i).Child("from").Index(j)
+			switch {
+			case from.Tag != "":
+				if err := tailcfg.CheckTag(string(from.Tag)); err != nil {
+					violations = append(violations, field.Invalid(p.Child("tag"), from.Tag, err.Error()))
+				}
+			case from.User != "":
+				if !strings.Contains(from.User, "@") || strings.ContainsAny(from.User, " \t") {
+					violations = append(violations, field.Invalid(p.Child("user"), from.User, "must be a login name, e.g. alice@example.com"))
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// tailnetAccessAllowFrom returns the tailnet identities that the
+// TailnetAccessPolicies in namespace ns allow to access port of the Service
+// named svcName, in the format of ipn.HTTPHandler.AllowFrom. It returns nil if
+// no policy targets the Service port, in which case access is not restricted
+// by the proxy.
+//
+// If the TailnetAccessPolicy CRD is not installed, for example because CRDs
+// are managed separately from the operator and haven't been upgraded yet,
+// there can't be any policies, so it returns nil.
+func tailnetAccessAllowFrom(ctx context.Context, cl client.Client, ns, svcName string, port int32, logger *zap.SugaredLogger) ([]string, error) {
+	taps := new(tsapi.TailnetAccessPolicyList)
+	if err := cl.List(ctx, taps, client.InNamespace(ns)); err != nil {
+		if isKindMissingErr(err) {
+			logTailnetAccessPolicyCRDMissing.Do(func() {
+				logger.Infof("TailnetAccessPolicy CRD is not installed, not restricting tailnet access to backends: %v", err)
+			})
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error listing TailnetAccessPolicies: %w", err)
+	}
+	var targeted bool
+	allow := make(set.Set[string])
+	for _, tap := range taps.Items {
+		if !tap.DeletionTimestamp.IsZero() {
+			continue
+		}
+		for _, rule := range tap.Spec.Rules {
+			if !slices.ContainsFunc(rule.To, func(to tsapi.TailnetAccessTarget) bool {
+				return to.Service == svcName && (len(to.Ports) == 0 || slices.Contains(to.Ports, port))
+			}) {
+				continue
+			}
+			targeted = true
+			for _, from := range rule.From {
+				if from.Tag != "" {
+					allow.Add(string(from.Tag))
+				} else if from.User != "" {
+					allow.Add(from.User)
+				}
+			}
+		}
+	}
+	if !targeted {
+		return nil, nil
+	}
+	s := allow.Slice()
+	slices.Sort(s)
+	return s, nil
+}
+
+// logTailnetAccessPolicyCRDMissing is used to log only once that the
+// TailnetAccessPolicy CRD is not installed.
+var logTailnetAccessPolicyCRDMissing sync.Once
+
+// isKindMissingErr reports whether err is from a request for a kind that the
+// API server or the client doesn't know about.
+func isKindMissingErr(err error) bool {
+	return meta.IsNoMatchError(err) || apierrors.IsNotFound(err) || runtime.IsNotRegisteredError(err)
+}
+
+// ingressesFromTailnetAccessPolicy is an event handler for
+// TailnetAccessPolicies. It returns reconcile requests for all Ingresses in
+// the TailnetAccessPolicy's namespace.
+func ingressesFromTailnetAccessPolicy(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		ingList := new(networkingv1.IngressList)
+		if err := cl.List(ctx, ingList, client.InNamespace(o.GetNamespace())); err != nil {
+			logger.Infof("error listing Ingresses: %v, skipping a reconcile for event on TailnetAccessPolicy %s", err, o.GetName())
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0, len(ingList.Items))
+		for _, ing := range ingList.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ing)})
+		}
+		return reqs
+	}
+}
+
+// tailnetAccessPoliciesInNamespace is an event handler for Services and
+// Ingresses. It returns reconcile requests for all TailnetAccessPolicies in
+// the object's namespace, which may target the Service or the Ingress's
+// backends.
+func tailnetAccessPoliciesInNamespace(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		taps := new(tsapi.TailnetAccessPolicyList)
+		if err := cl.List(ctx, taps, client.InNamespace(o.GetNamespace())); err != nil {
+			logger.Infof("error listing TailnetAccessPolicies: %v, skipping a reconcile for event on %s", err, client.ObjectKeyFromObject(o))
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0, len(taps.Items))
+		for _, tap := range taps.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&tap)})
+		}
+		return reqs
+	}
+}
+
+// gatewaysFromTailnetAccessPolicy is an event handler for
+// TailnetAccessPolicies. It returns reconcile requests for all Gateways, as
+// routes in the TailnetAccessPolicy's namespace may be attached to Gateways in
+// any namespace.
+func gatewaysFromTailnetAccessPolicy(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		gws := new(gatewayv1.GatewayList)
+		if err := cl.List(ctx, gws); err != nil {
+			logger.Infof("error listing Gateways: %v, skipping a reconcile for event on TailnetAccessPolicy %s", err, o.GetName())
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0, len(gws.Items))
+		for _, gw := range gws.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&gw)})
+		}
+		return reqs
+	}
+}
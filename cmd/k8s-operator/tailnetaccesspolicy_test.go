@@ -0,0 +1,307 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tstest"
+)
+
+func TestTailnetAccessPolicyReconciler(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+	tap := &tsapi.TailnetAccessPolicy{
+		TypeMeta: metav1.TypeMeta{Kind: "TailnetAccessPolicy", APIVersion: "tailscale.com/v1alpha1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test",
+			Namespace:  "default",
+			UID:        types.UID("1234-UID"),
+			Finalizers: []string{"tailscale.com/finalizer"},
+		},
+		Spec: tsapi.TailnetAccessPolicySpec{
+			Rules: []tsapi.TailnetAccessRule{{
+				From: []tsapi.TailnetAccessPeer{{Tag: "tag:prod"}, {User: "alice@example.com"}},
+				To:   []tsapi.TailnetAccessTarget{{Service: "backend", Ports: []int32{80}}},
+			}},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(svc, tap).
+		WithStatusSubresource(tap).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := record.NewFakeRecorder(3)
+	cl := tstest.NewClock(tstest.ClockOpts{})
+	r := &TailnetAccessPolicyReconciler{
+		Client:   fc,
+		logger:   zl.Sugar(),
+		clock:    cl,
+		recorder: fr,
+	}
+
+	// 1. A valid TailnetAccessPolicy gets its status updated to Ready.
+	expectReconciled(t, r, "default", "test")
+	tsoperator.SetTailnetAccessPolicyCondition(tap, tsapi.TailnetAccessPolicyReady, metav1.ConditionTrue, reasonTailnetAccessPolicyValid, reasonTailnetAccessPolicyValid, 0, cl, zl.Sugar())
+	expectEqual(t, fc, tap)
+
+	// 2. Targets that don't exist are reported as Events, but the
+	// TailnetAccessPolicy is still Ready.
+	tap.Spec.Rules[0].To = []tsapi.TailnetAccessTarget{
+		{Service: "backend", Ports: []int32{8080}},
+		{Service: "missing"},
+	}
+	mustUpdate(t, fc, "default", "test", func(p *tsapi.TailnetAccessPolicy) {
+		p.Spec.Rules[0].To = tap.Spec.Rules[0].To
+	})
+	expectReconciled(t, r, "default", "test")
+	expectEqual(t, fc, tap)
+	expectEvents(t, fr, []string{
+		`Warning TailnetAccessTargetNotFound Service "backend" has no port 8080`,
+		`Warning TailnetAccessTargetNotFound Service "missing" not found`,
+	})
+
+	// 3. A TailnetAccessPolicy with an invalid tag gets its status updated
+	// to not Ready with an error message.
+	tap.Spec.Rules[0].From[0].Tag = "prod"
+	mustUpdate(t, fc, "default", "test", func(p *tsapi.TailnetAccessPolicy) {
+		p.Spec.Rules[0].From[0].Tag = tap.Spec.Rules[0].From[0].Tag
+	})
+	expectReconciled(t, r, "default", "test")
+	msg := `TailnetAccessPolicy is not valid: spec.rules[0].from[0].tag: Invalid value: "prod": tags must start with 'tag:'`
+	tsoperator.SetTailnetAccessPolicyCondition(tap, tsapi.TailnetAccessPolicyReady, metav1.ConditionFalse, reasonTailnetAccessPolicyInvalid, msg, 0, cl, zl.Sugar())
+	expectEqual(t, fc, tap)
+	expectEvents(t, fr, []string{"Warning TailnetAccessPolicyInvalid " + msg})
+}
+
+func TestTailnetAccessPolicyNotEnforced(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+	tap := &tsapi.TailnetAccessPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test",
+			Namespace:  "default",
+			Finalizers: []string{"tailscale.com/finalizer"},
+		},
+		Spec: tsapi.TailnetAccessPolicySpec{
+			Rules: []tsapi.TailnetAccessRule{{
+				From: []tsapi.TailnetAccessPeer{{Tag: "tag:prod"}},
+				To:   []tsapi.TailnetAccessTarget{{Service: "backend"}},
+			}},
+		},
+	}
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			Annotations: map[string]string{AnnotationProxyGroup: "ingress-pg"},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ptr.To("tailscale"),
+			DefaultBackend: &networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{Name: "backend", Port: networkingv1.ServiceBackendPort{Number: 80}},
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-pg-0", Namespace: "operator-ns", UID: "pod-UID"},
+	}
+	stateSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ingress-pg-0",
+			Namespace: "operator-ns",
+			Labels:    pgSecretLabels("ingress-pg", kubetypes.LabelSecretTypeState),
+		},
+		Data: map[string][]byte{
+			kubetypes.KeyCapVer: []byte(fmt.Sprint(tailnetAccessMinCapVer - 1)),
+			kubetypes.KeyPodUID: []byte("pod-UID"),
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(svc, tap, ing, pod, stateSecret).
+		WithStatusSubresource(tap).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := record.NewFakeRecorder(3)
+	cl := tstest.NewClock(tstest.ClockOpts{})
+	r := &TailnetAccessPolicyReconciler{
+		Client:      fc,
+		logger:      zl.Sugar(),
+		clock:       cl,
+		recorder:    fr,
+		tsNamespace: "operator-ns",
+	}
+	expectNotEnforced := func(problem string) {
+		t.Helper()
+		expectRequeue(t, r, "default", "test")
+		msg := fmt.Sprintf(messageTailnetAccessPolicyNotEnforced, problem)
+		tsoperator.SetTailnetAccessPolicyCondition(tap, tsapi.TailnetAccessPolicyReady, metav1.ConditionFalse, reasonTailnetAccessPolicyNotEnforced, msg, 0, cl, zl.Sugar())
+		expectEqual(t, fc, tap)
+		expectEvents(t, fr, []string{"Warning " + reasonTailnetAccessPolicyNotEnforced + " " + msg})
+	}
+
+	// 1. An ingress proxy that's too old to enforce the policy makes it
+	// not Ready.
+	expectNotEnforced(`proxy Pod operator-ns/ingress-pg-0 runs a Tailscale version too old to enforce TailnetAccessPolicies, upgrade it`)
+
+	// 2. Once the proxy is upgraded, the policy is Ready.
+	mustUpdate(t, fc, "operator-ns", "ingress-pg-0", func(s *corev1.Secret) {
+		s.Data[kubetypes.KeyCapVer] = []byte(fmt.Sprint(tailnetAccessMinCapVer))
+	})
+	expectReconciled(t, r, "default", "test")
+	tsoperator.SetTailnetAccessPolicyCondition(tap, tsapi.TailnetAccessPolicyReady, metav1.ConditionTrue, reasonTailnetAccessPolicyValid, reasonTailnetAccessPolicyValid, 0, cl, zl.Sugar())
+	expectEqual(t, fc, tap)
+
+	// 3. Exposing the Service with a Tailscale LoadBalancer, whose proxy
+	// doesn't enforce the policy, makes it not Ready.
+	mustUpdate(t, fc, "default", "backend", func(s *corev1.Service) {
+		s.Spec.Type = corev1.ServiceTypeLoadBalancer
+		s.Spec.LoadBalancerClass = ptr.To("tailscale")
+	})
+	expectNotEnforced(`Service "backend" is exposed on the tailnet as a Tailscale LoadBalancer Service or with the tailscale.com/expose annotation, which don't enforce TailnetAccessPolicies`)
+}
+
+func TestTailnetAccessAllowFrom(t *testing.T) {
+	taps := []*tsapi.TailnetAccessPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: tsapi.TailnetAccessPolicySpec{
+				Rules: []tsapi.TailnetAccessRule{
+					{
+						From: []tsapi.TailnetAccessPeer{{Tag: "tag:prod"}},
+						To:   []tsapi.TailnetAccessTarget{{Service: "web"}},
+					},
+					{
+						From: []tsapi.TailnetAccessPeer{{User: "alice@example.com"}},
+						To:   []tsapi.TailnetAccessTarget{{Service: "web", Ports: []int32{8080}}},
+					},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-admin", Namespace: "default"},
+			Spec: tsapi.TailnetAccessPolicySpec{
+				Rules: []tsapi.TailnetAccessRule{{
+					From: []tsapi.TailnetAccessPeer{{Tag: "tag:admin"}, {Tag: "tag:prod"}},
+					To:   []tsapi.TailnetAccessTarget{{Service: "web", Ports: []int32{8080}}},
+				}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "other"},
+			Spec: tsapi.TailnetAccessPolicySpec{
+				Rules: []tsapi.TailnetAccessRule{{
+					From: []tsapi.TailnetAccessPeer{{Tag: "tag:other"}},
+					To:   []tsapi.TailnetAccessTarget{{Service: "web"}},
+				}},
+			},
+		},
+	}
+	b := fake.NewClientBuilder().WithScheme(tsapi.GlobalScheme)
+	for _, tap := range taps {
+		b = b.WithObjects(tap)
+	}
+	fc := b.Build()
+
+	tests := []struct {
+		ns, svc string
+		port    int32
+		want    []string
+	}{
+		{"default", "web", 80, []string{"tag:prod"}},
+		{"default", "web", 8080, []string{"alice@example.com", "tag:admin", "tag:prod"}},
+		{"default", "api", 80, nil},
+		{"other", "web", 443, []string{"tag:other"}},
+		{"empty", "web", 80, nil},
+	}
+	for _, tt := range tests {
+		got, err := tailnetAccessAllowFrom(context.Background(), fc, tt.ns, tt.svc, tt.port, zap.NewNop().Sugar())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("tailnetAccessAllowFrom(%s/%s:%d) = %q, want %q", tt.ns, tt.svc, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestTailnetAccessAllowFromCRDMissing(t *testing.T) {
+	noMatch := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, cl client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				if _, ok := list.(*tsapi.TailnetAccessPolicyList); ok {
+					return &meta.NoKindMatchError{GroupKind: schema.GroupKind{Group: tsapi.SchemeGroupVersion.Group, Kind: "TailnetAccessPolicy"}}
+				}
+				return cl.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+	forbidden := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, cl client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				return apierrors.NewForbidden(schema.GroupResource{Group: tsapi.SchemeGroupVersion.Group, Resource: "tailnetaccesspolicies"}, "", nil)
+			},
+		}).
+		Build()
+
+	for _, tt := range []struct {
+		name    string
+		cl      client.Client
+		wantErr bool
+	}{
+		{"scheme-without-tsapi", fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build(), false},
+		{"no-kind-match", noMatch, false},
+		{"forbidden", forbidden, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tailnetAccessAllowFrom(context.Background(), tt.cl, "default", "web", 80, zap.NewNop().Sugar())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tailnetAccessAllowFrom error = %v, want error: %v", err, tt.wantErr)
+			}
+			if got != nil {
+				t.Errorf("tailnetAccessAllowFrom = %q, want nil", got)
+			}
+		})
+	}
+}
@@ -63,6 +63,17 @@ type RecorderReconciler struct {
 	tsNamespace string
 	tsClient    tsClient
 	loginServer string
+	// recordingsImage is the image to run the recordings sidecar of
+	// Recorders with PVC storage with, i.e. the operator's own image.
+	recordingsImage string
+	// recordingsUser is the Kubernetes user that the recordings sidecar
+	// allows to query it, i.e. the operator's ServiceAccount.
+	recordingsUser string
+	// recordingsTokenPath is the path of the token that the operator
+	// authenticates to the recordings sidecar with.
+	recordingsTokenPath string
+	// httpClient is used to query the recordings sidecar.
+	httpClient *http.Client
 
 	mu        sync.Mutex           // protects following
 	recorders set.Slice[types.UID] // for recorders gauge
@@ -164,7 +175,12 @@ func (r *RecorderReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 	}
 
 	logger.Info("Recorder resources synced")
-	return setStatusReady(tsr, metav1.ConditionTrue, reasonRecorderCreated, reasonRecorderCreated)
+	res, err := setStatusReady(tsr, metav1.ConditionTrue, reasonRecorderCreated, reasonRecorderCreated)
+	if err == nil && tsr.Spec.Storage.PVC != nil {
+		// Periodically refresh the recent recordings in the status.
+		res.RequeueAfter = recordingsStatusInterval
+	}
+	return res, err
 }
 
 func (r *RecorderReconciler) maybeProvision(ctx context.Context, tailscaleClient tsClient, tsr *tsapi.Recorder) error {
@@ -234,7 +250,20 @@ func (r *RecorderReconciler) maybeProvision(ctx context.Context, tailscaleClient
 		return fmt.Errorf("error creating RoleBinding: %w", err)
 	}
 
-	ss := tsrStatefulSet(tsr, r.tsNamespace, r.loginServer)
+	if tsr.Spec.Storage.PVC != nil {
+		crb := tsrClusterRoleBinding(tsr, r.tsNamespace)
+		_, err = createOrUpdate(ctx, r.Client, "", crb, func(b *rbacv1.ClusterRoleBinding) {
+			b.ObjectMeta.Labels = crb.ObjectMeta.Labels
+			b.ObjectMeta.Annotations = crb.ObjectMeta.Annotations
+			b.RoleRef = crb.RoleRef
+			b.Subjects = crb.Subjects
+		})
+		if err != nil {
+			return fmt.Errorf("error creating ClusterRoleBinding: %w", err)
+		}
+	}
+
+	ss := tsrStatefulSet(tsr, r.tsNamespace, r.loginServer, r.recordingsImage, r.recordingsUser)
 	_, err = createOrUpdate(ctx, r.Client, r.tsNamespace, ss, func(s *appsv1.StatefulSet) {
 		s.ObjectMeta.Labels = ss.ObjectMeta.Labels
 		s.ObjectMeta.Annotations = ss.ObjectMeta.Annotations
@@ -273,6 +302,20 @@ func (r *RecorderReconciler) maybeProvision(ctx context.Context, tailscaleClient
 
 	tsr.Status.Devices = devices
 
+	if tsr.Spec.Storage.PVC == nil {
+		tsr.Status.RecentRecordings = nil
+		return nil
+	}
+	recs, ok, err := r.recentRecordings(ctx, tsr)
+	switch {
+	case err != nil:
+		// The recent recordings are informational, so don't fail the
+		// reconcile over them.
+		logger.Infof("error getting recent recordings: %v", err)
+	case ok:
+		tsr.Status.RecentRecordings = recs
+	}
+
 	return nil
 }
 
@@ -479,8 +522,8 @@ func (r *RecorderReconciler) ensureAuthSecretsCreated(ctx context.Context, tails
 }
 
 func (r *RecorderReconciler) validate(ctx context.Context, tsr *tsapi.Recorder) error {
-	if !tsr.Spec.EnableUI && tsr.Spec.Storage.S3 == nil {
-		return errors.New("must either enable UI or use S3 storage to ensure recordings are accessible")
+	if !tsr.Spec.EnableUI && tsr.Spec.Storage.S3 == nil && tsr.Spec.Storage.PVC == nil {
+		return errors.New("must either enable UI or use S3 or PVC storage to ensure recordings are accessible")
 	}
 
 	if tsr.Spec.Replicas != nil && *tsr.Spec.Replicas > 1 && tsr.Spec.Storage.S3 == nil {
@@ -18,7 +18,7 @@
 	"tailscale.com/version"
 )
 
-func tsrStatefulSet(tsr *tsapi.Recorder, namespace string, loginServer string) *appsv1.StatefulSet {
+func tsrStatefulSet(tsr *tsapi.Recorder, namespace, loginServer, recordingsImage, recordingsUser string) *appsv1.StatefulSet {
 	var replicas int32 = 1
 	if tsr.Spec.Replicas != nil {
 		replicas = *tsr.Spec.Replicas
@@ -102,6 +102,15 @@ func tsrStatefulSet(tsr *tsapi.Recorder, namespace string, loginServer string) *
 		},
 	}
 
+	if pvc := tsr.Spec.Storage.PVC; pvc != nil {
+		// Store recordings in a PersistentVolumeClaim instead of the
+		// ephemeral volume, and run the recordings sidecar to apply the
+		// retention policy and serve the recordings query API.
+		ss.Spec.Template.Spec.Volumes = nil
+		ss.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{tsrVolumeClaimTemplate(tsr, pvc)}
+		ss.Spec.Template.Spec.Containers = append(ss.Spec.Template.Spec.Containers, tsrRecordingsContainer(tsr, pvc, recordingsImage, recordingsUser))
+	}
+
 	for replica := range replicas {
 		volumeName := fmt.Sprintf("authkey-%d", replica)
 
@@ -125,6 +134,81 @@ func tsrStatefulSet(tsr *tsapi.Recorder, namespace string, loginServer string) *
 	return ss
 }
 
+func tsrVolumeClaimTemplate(tsr *tsapi.Recorder, pvc *tsapi.RecorderPVCStorage) corev1.PersistentVolumeClaim {
+	claim := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "data",
+			Labels: tsrLabels("recorder", tsr.Name, nil),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: pvc.Size,
+				},
+			},
+		},
+	}
+	if pvc.StorageClassName != "" {
+		claim.Spec.StorageClassName = ptr.To(pvc.StorageClassName)
+	}
+
+	return claim
+}
+
+// tsrRecordingsContainer returns the recordings sidecar container for a
+// Recorder with PVC storage, which runs the operator image. Only allowedUser
+// can query the recordings.
+func tsrRecordingsContainer(tsr *tsapi.Recorder, pvc *tsapi.RecorderPVCStorage, image, allowedUser string) corev1.Container {
+	if image == "" {
+		image = fmt.Sprintf("tailscale/k8s-operator:%s", selfVersionImageTag())
+	}
+	env := []corev1.EnvVar{
+		{
+			Name:  "RECORDINGS_DIR",
+			Value: "/data/recordings",
+		},
+		{
+			Name:  "RECORDINGS_ALLOWED_USER",
+			Value: allowedUser,
+		},
+	}
+	if maxAge := pvc.Retention.MaxAge; maxAge != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  "RECORDINGS_MAX_AGE",
+			Value: maxAge.Duration.String(),
+		})
+	}
+	if maxSize := pvc.Retention.MaxSize; maxSize != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  "RECORDINGS_MAX_SIZE",
+			Value: fmt.Sprint(maxSize.Value()),
+		})
+	}
+
+	return corev1.Container{
+		Name:            "recordings",
+		Image:           image,
+		ImagePullPolicy: tsr.Spec.StatefulSet.Pod.Container.ImagePullPolicy,
+		SecurityContext: tsr.Spec.StatefulSet.Pod.Container.SecurityContext,
+		Command:         []string{"/usr/local/bin/operator", "recordings"},
+		Env:             env,
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "recordings",
+				ContainerPort: recordingsPort,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "data",
+				MountPath: "/data",
+			},
+		},
+	}
+}
+
 func tsrServiceAccount(tsr *tsapi.Recorder, namespace string) *corev1.ServiceAccount {
 	return &corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
@@ -214,6 +298,30 @@ func tsrRoleBinding(tsr *tsapi.Recorder, namespace string) *rbacv1.RoleBinding {
 	}
 }
 
+// tsrClusterRoleBinding returns the ClusterRoleBinding that allows the
+// recordings sidecar of a Recorder with PVC storage to authenticate the
+// operator with TokenReviews.
+func tsrClusterRoleBinding(tsr *tsapi.Recorder, namespace string) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            fmt.Sprintf("%s-%s", recorderAuthClusterRole, tsr.Name),
+			Labels:          tsrLabels("recorder", tsr.Name, nil),
+			OwnerReferences: tsrOwnerReference(tsr),
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      tsrServiceAccountName(tsr),
+				Namespace: namespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: "ClusterRole",
+			Name: recorderAuthClusterRole,
+		},
+	}
+}
+
 func tsrAuthSecret(tsr *tsapi.Recorder, namespace string, authKey string, replica int32) *corev1.Secret {
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
@@ -7,6 +7,7 @@
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	corev1 "k8s.io/api/core/v1"
@@ -92,7 +93,7 @@ func TestRecorderSpecs(t *testing.T) {
 			},
 		}
 
-		ss := tsrStatefulSet(tsr, tsNamespace, tsLoginServer)
+		ss := tsrStatefulSet(tsr, tsNamespace, tsLoginServer, "", "")
 
 		// StatefulSet-level.
 		if diff := cmp.Diff(ss.Annotations, tsr.Spec.StatefulSet.Annotations); diff != "" {
@@ -154,4 +155,69 @@ func TestRecorderSpecs(t *testing.T) {
 			t.Errorf("expected %d volume mounts, got %d", *tsr.Spec.Replicas+1, len(ss.Spec.Template.Spec.Containers[0].VolumeMounts))
 		}
 	})
+
+	t.Run("PVC storage", func(t *testing.T) {
+		tsr := &tsapi.Recorder{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "test",
+			},
+			Spec: tsapi.RecorderSpec{
+				Storage: tsapi.Storage{
+					PVC: &tsapi.RecorderPVCStorage{
+						Size:             resource.MustParse("10Gi"),
+						StorageClassName: "fast",
+						Retention: tsapi.RecordingRetention{
+							MaxAge:  &metav1.Duration{Duration: 720 * time.Hour},
+							MaxSize: ptr.To(resource.MustParse("8Gi")),
+						},
+					},
+				},
+			},
+		}
+
+		ss := tsrStatefulSet(tsr, tsNamespace, tsLoginServer, "tailscale/k8s-operator:test", "system:serviceaccount:operator-ns:operator")
+
+		wantClaim := corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "data",
+				Labels: tsrLabels("recorder", "test", nil),
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				StorageClassName: ptr.To("fast"),
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceStorage: resource.MustParse("10Gi"),
+					},
+				},
+			},
+		}
+		if diff := cmp.Diff(ss.Spec.VolumeClaimTemplates, []corev1.PersistentVolumeClaim{wantClaim}); diff != "" {
+			t.Errorf("(-got +want):\n%s", diff)
+		}
+
+		// The ephemeral data volume is replaced by the claim.
+		for _, v := range ss.Spec.Template.Spec.Volumes {
+			if v.Name == "data" {
+				t.Errorf("unexpected data volume %+v", v)
+			}
+		}
+
+		if len(ss.Spec.Template.Spec.Containers) != 2 {
+			t.Fatalf("expected 2 containers, got %d", len(ss.Spec.Template.Spec.Containers))
+		}
+		c := ss.Spec.Template.Spec.Containers[1]
+		if c.Image != "tailscale/k8s-operator:test" {
+			t.Errorf("expected recordings image %q, got %q", "tailscale/k8s-operator:test", c.Image)
+		}
+		wantEnv := []corev1.EnvVar{
+			{Name: "RECORDINGS_DIR", Value: "/data/recordings"},
+			{Name: "RECORDINGS_ALLOWED_USER", Value: "system:serviceaccount:operator-ns:operator"},
+			{Name: "RECORDINGS_MAX_AGE", Value: "720h0m0s"},
+			{Name: "RECORDINGS_MAX_SIZE", Value: "8589934592"},
+		}
+		if diff := cmp.Diff(c.Env, wantEnv); diff != "" {
+			t.Errorf("(-got +want):\n%s", diff)
+		}
+	})
 }
@@ -66,7 +66,7 @@ func TestRecorder(t *testing.T) {
 	t.Run("invalid_spec_gives_an_error_condition", func(t *testing.T) {
 		expectReconciled(t, reconciler, "", tsr.Name)
 
-		msg := "Recorder is invalid: must either enable UI or use S3 storage to ensure recordings are accessible"
+		msg := "Recorder is invalid: must either enable UI or use S3 or PVC storage to ensure recordings are accessible"
 		tsoperator.SetRecorderCondition(tsr, tsapi.RecorderReady, metav1.ConditionFalse, reasonRecorderInvalid, msg, 0, cl, zl.Sugar())
 		expectEqual(t, fc, tsr)
 		if expected := 0; reconciler.recorders.Len() != expected {
@@ -74,7 +74,7 @@ func TestRecorder(t *testing.T) {
 		}
 		expectRecorderResources(t, fc, tsr, false)
 
-		expectedEvent := "Warning RecorderInvalid Recorder is invalid: must either enable UI or use S3 storage to ensure recordings are accessible"
+		expectedEvent := "Warning RecorderInvalid Recorder is invalid: must either enable UI or use S3 or PVC storage to ensure recordings are accessible"
 		expectEvents(t, fr, []string{expectedEvent})
 
 		tsr.Spec.EnableUI = true
@@ -270,7 +270,8 @@ func expectRecorderResources(t *testing.T, fc client.WithWatch, tsr *tsapi.Recor
 	role := tsrRole(tsr, tsNamespace)
 	roleBinding := tsrRoleBinding(tsr, tsNamespace)
 	serviceAccount := tsrServiceAccount(tsr, tsNamespace)
-	statefulSet := tsrStatefulSet(tsr, tsNamespace, tsLoginServer)
+	statefulSet := tsrStatefulSet(tsr, tsNamespace, tsLoginServer, "", "")
+	clusterRoleBinding := tsrClusterRoleBinding(tsr, tsNamespace)
 
 	if shouldExist {
 		expectEqual(t, fc, role)
@@ -283,6 +284,11 @@ func expectRecorderResources(t *testing.T, fc client.WithWatch, tsr *tsapi.Recor
 		expectMissing[corev1.ServiceAccount](t, fc, serviceAccount.Namespace, serviceAccount.Name)
 		expectMissing[appsv1.StatefulSet](t, fc, statefulSet.Namespace, statefulSet.Name)
 	}
+	if shouldExist && tsr.Spec.Storage.PVC != nil {
+		expectEqual(t, fc, clusterRoleBinding)
+	} else {
+		expectMissing[rbacv1.ClusterRoleBinding](t, fc, "", clusterRoleBinding.Name)
+	}
 
 	for replica := range replicas {
 		auth := tsrAuthSecret(tsr, tsNamespace, "secret-authkey", replica)
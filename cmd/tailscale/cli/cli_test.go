@@ -995,6 +995,10 @@ func TestPrefFlagMapping(t *testing.T) {
 			// Handled by the tailscale share subcommand, we don't want a CLI
 			// flag for this.
 			continue
+		case "TaildropRules":
+			// Too structured for a CLI flag; set via the config file or
+			// LocalAPI.
+			continue
 		case "AdvertiseServices":
 			// Handled by the tailscale serve subcommand, we don't want a
 			// CLI flag for this.
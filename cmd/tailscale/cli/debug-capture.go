@@ -14,6 +14,7 @@
 	"os/exec"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/local"
 	"tailscale.com/feature/capture/dissector"
 )
 
@@ -30,6 +31,8 @@ func mkDebugCaptureCmd() *ffcli.Command {
 		FlagSet: (func() *flag.FlagSet {
 			fs := newFlagSet("capture")
 			fs.StringVar(&captureArgs.outFile, "o", "", "path to stream the pcap (or - for stdout), leave empty to start wireshark")
+			fs.StringVar(&captureArgs.filter, "filter", "", `only capture packets matching this tcpdump-style expression, such as "tcp port 22 and host 100.101.102.103"; "path from-peer" and similar select where packets were captured`)
+			fs.StringVar(&captureArgs.format, "format", "pcap", `capture format: "pcap", or "pcapng" to annotate packets with their peer and path, readable without the Tailscale dissector`)
 			return fs
 		})(),
 	}
@@ -37,10 +40,15 @@ func mkDebugCaptureCmd() *ffcli.Command {
 
 var captureArgs struct {
 	outFile string
+	filter  string
+	format  string
 }
 
 func runCapture(ctx context.Context, args []string) error {
-	stream, err := localClient.StreamDebugCapture(ctx)
+	stream, err := localClient.StreamDebugCaptureWithOpts(ctx, local.DebugCaptureOpts{
+		Filter: captureArgs.filter,
+		Format: captureArgs.format,
+	})
 	if err != nil {
 		return err
 	}
@@ -12,6 +12,8 @@
 	"fmt"
 	"net/netip"
 	"slices"
+	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/net/udprelay/status"
@@ -27,6 +29,19 @@ func mkDebugPeerRelaySessionsCmd() *ffcli.Command {
 		ShortUsage: "tailscale debug peer-relay-sessions",
 		Exec:       runPeerRelaySessions,
 		ShortHelp:  "Print the current set of active peer relay sessions relayed through this node",
+		LongHelp: strings.TrimSpace(`
+The 'tailscale debug peer-relay-sessions' subcommand prints the peer relay
+server's port, its local policy, the sessions it's relaying and its recent
+allocation log.
+
+The local policy can only be set with environment variables of tailscaled,
+which it reads when it starts:
+
+  TS_PEER_RELAY_ALLOW_CLIENTS            comma-separated Tailscale IPs, tags and node names
+  TS_PEER_RELAY_MAX_SESSIONS_PER_CLIENT  concurrent sessions per client
+  TS_PEER_RELAY_MAX_BYTES_PER_SECOND     forwarded bytes per second per session and client
+  TS_PEER_RELAY_IDLE_TIMEOUT             how long a session lasts without packets
+`),
 	}
 }
 
@@ -46,11 +61,24 @@ func runPeerRelaySessions(ctx context.Context, args []string) error {
 		f("%d", *srv.UDPPort)
 	}
 	f("\n")
-	f("Sessions count: %d\n", len(srv.Sessions))
-	if len(srv.Sessions) == 0 {
-		Stdout.Write(buf.Bytes())
-		return nil
+	if p := srv.Policy; p != nil {
+		orNone := func(v any, zero bool) any {
+			if zero {
+				return "none"
+			}
+			return v
+		}
+		allow := "all"
+		if len(p.AllowClients) > 0 {
+			allow = strings.Join(p.AllowClients, ", ")
+		}
+		f("Policy (from tailscaled's TS_PEER_RELAY_* environment variables):\n")
+		f("  Allowed clients: %s\n", allow)
+		f("  Max sessions per client: %v\n", orNone(p.MaxSessionsPerClient, p.MaxSessionsPerClient <= 0))
+		f("  Max bytes per second: %v\n", orNone(p.MaxBytesPerSecond, p.MaxBytesPerSecond <= 0))
+		f("  Idle timeout: %v\n", orNone(p.IdleTimeout, p.IdleTimeout <= 0))
 	}
+	f("Sessions count: %d\n", len(srv.Sessions))
 
 	fmtSessionDirection := func(a, z status.ClientInfo) string {
 		fmtEndpoint := func(ap netip.AddrPort) string {
@@ -59,19 +87,38 @@ func runPeerRelaySessions(ctx context.Context, args []string) error {
 			}
 			return "<no handshake>"
 		}
-		return fmt.Sprintf("%s(%s) --> %s(%s), Packets: %d Bytes: %d",
+		s := fmt.Sprintf("%s(%s) --> %s(%s), Packets: %d Bytes: %d",
 			fmtEndpoint(a.Endpoint), a.ShortDisco,
 			fmtEndpoint(z.Endpoint), z.ShortDisco,
 			a.PacketsTx, a.BytesTx)
+		if a.PacketsDropped > 0 {
+			s += fmt.Sprintf(" Dropped: %d", a.PacketsDropped)
+		}
+		return s
 	}
 
-	f("\n")
+	if len(srv.Sessions) > 0 {
+		f("\n")
+	}
 	slices.SortFunc(srv.Sessions, func(s1, s2 status.ServerSession) int { return cmp.Compare(s1.VNI, s2.VNI) })
 	for _, s := range srv.Sessions {
 		f("VNI: %d\n", s.VNI)
 		f("  %s\n", fmtSessionDirection(s.Client1, s.Client2))
 		f("  %s\n", fmtSessionDirection(s.Client2, s.Client1))
 	}
+	if len(srv.Allocations) > 0 {
+		f("\nRecent allocations:\n")
+		for _, ev := range srv.Allocations {
+			f("  %s %-9s VNI: %d %s <-> %s", ev.When.Format(time.DateTime), ev.Event, ev.VNI, ev.ShortDisco[0], ev.ShortDisco[1])
+			if ev.Event == "expired" {
+				f(" Packets: %d Bytes: %d", ev.Packets, ev.Bytes)
+			}
+			if ev.Reason != "" {
+				f(" (%s)", ev.Reason)
+			}
+			f("\n")
+		}
+	}
 	Stdout.Write(buf.Bytes())
 	return nil
 }
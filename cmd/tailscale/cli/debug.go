@@ -36,6 +36,7 @@
 	"tailscale.com/health"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/ace"
 	"tailscale.com/net/dnscache"
 	"tailscale.com/net/netmon"
@@ -44,10 +45,12 @@
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/eventbus"
 	"tailscale.com/util/must"
+	"tailscale.com/wgengine/filter/filtertype"
 )
 
 var (
@@ -342,6 +345,23 @@ func debugCmd() *ffcli.Command {
 				Exec:       runPeerEndpointChanges,
 				ShortHelp:  "Print debug information about a peer's endpoint changes",
 			},
+			{
+				Name:       "peer-path",
+				ShortUsage: "tailscale debug peer-path [--json] <hostname-or-IP>",
+				Exec:       runPeerPath,
+				ShortHelp:  "Explain how packets are sent to a peer and why",
+				LongHelp: strings.TrimSpace(`
+The 'tailscale debug peer-path' command reports whether packets to a peer
+go over DERP, a direct UDP path or a peer relay, and why. It also lists
+the peer's candidate UDP endpoints with their last pings, pongs and
+latencies, and the peer's recent path changes with the reason for each.
+`),
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("peer-path")
+					fs.BoolVar(&peerPathArgs.json, "json", false, "output in JSON format")
+					return fs
+				})(),
+			},
 			{
 				Name:       "dial-types",
 				ShortUsage: "tailscale debug dial-types <hostname-or-IP> <port>",
@@ -353,6 +373,31 @@ func debugCmd() *ffcli.Command {
 					return fs
 				})(),
 			},
+			{
+				Name:       "filter-trace",
+				ShortUsage: "tailscale debug filter-trace [--proto=tcp] [--out] <src-ip[:port]> <dst-ip[:port]>\n  tailscale debug filter-trace --live [--drops]",
+				Exec:       runDebugFilterTrace,
+				ShortHelp:  "Explain the packet filter's verdict on a packet",
+				LongHelp: strings.TrimSpace(`
+The 'tailscale debug filter-trace' command reports why the packet filter
+accepts or drops a packet: the index of the rule (in 'tailscale debug
+packet-filter-matches') that accepted it, the node capabilities tested, and
+whether it was accepted as a reply to a connection this node started.
+
+By default, the packet is a synthetic one from a peer at <src> to this node
+at <dst>, starting a connection. With --live, sampled live packets are
+traced as they pass through the filter instead.
+`),
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("filter-trace")
+					fs.StringVar(&filterTraceArgs.proto, "proto", "tcp", `IP protocol of the packet, such as "tcp", "udp" or "icmp"`)
+					fs.BoolVar(&filterTraceArgs.out, "out", false, "trace a packet from this node to a peer, rather than from a peer")
+					fs.BoolVar(&filterTraceArgs.live, "live", false, "trace sampled live packets until interrupted")
+					fs.BoolVar(&filterTraceArgs.drops, "drops", false, "with --live, only trace dropped packets")
+					fs.BoolVar(&filterTraceArgs.json, "json", false, "output in JSON format")
+					return fs
+				})(),
+			},
 			{
 				Name:       "resolve",
 				ShortUsage: "tailscale debug resolve <hostname>",
@@ -1284,6 +1329,91 @@ func runPeerEndpointChanges(ctx context.Context, args []string) error {
 	return nil
 }
 
+var peerPathArgs struct {
+	json bool
+}
+
+func runPeerPath(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: tailscale debug peer-path [--json] <hostname-or-IP>")
+	}
+	hostOrIP := args[0]
+	ipStr, self, err := tailscaleIPFromArg(ctx, hostOrIP)
+	if err != nil {
+		return err
+	}
+	if self {
+		printf("%v is local Tailscale IP\n", ipStr)
+		return nil
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	pp, err := localClient.DebugPeerPath(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if peerPathArgs.json {
+		j, _ := json.MarshalIndent(pp, "", "  ")
+		outln(string(j))
+		return nil
+	}
+
+	switch {
+	case pp.Path != ipnstate.PathDERP:
+		printf("path: %s %s\n", pp.Path, pp.Addr)
+	case pp.DERPRegionCode != "":
+		printf("path: derp %s (region %d)\n", pp.DERPRegionCode, pp.DERPRegionID)
+	default:
+		printf("path: derp\n")
+	}
+	printf("reason: %s\n", pp.Reason)
+	if pp.BestAddr != "" && pp.BestAddr != pp.Addr {
+		printf("best UDP path: %s (%v latency, trusted until %v)\n",
+			pp.BestAddr, secondsDuration(pp.BestAddrLatencySeconds), pp.BestAddrTrustedUntil.Format(time.TimeOnly))
+	}
+
+	printf("\ncandidates:\n")
+	if len(pp.Candidates) == 0 {
+		printf("  (none)\n")
+	}
+	for _, c := range pp.Candidates {
+		printf("  %s (from %s)", c.Addr, c.Source)
+		if !c.LastPing.IsZero() {
+			printf(", pinged %v ago", time.Since(c.LastPing).Round(time.Second))
+		}
+		if c.Pongs > 0 {
+			printf(", %d pongs, last %v ago with %v latency",
+				c.Pongs, time.Since(c.LastPong).Round(time.Second), secondsDuration(c.LatencySeconds))
+		}
+		printf("\n")
+	}
+
+	printf("\nhistory:\n")
+	if len(pp.History) == 0 {
+		printf("  (no path changes)\n")
+	}
+	for _, h := range pp.History {
+		printf("  %s  %s -> %s: %s\n", h.When.Format(time.TimeOnly),
+			peerPathString(h.From, h.FromAddr), peerPathString(h.To, h.ToAddr), h.Reason)
+	}
+	return nil
+}
+
+// peerPathString formats a path in a peer-path change.
+func peerPathString(t ipnstate.PathType, addr string) string {
+	if addr == "" {
+		return string(t)
+	}
+	return string(t) + " " + addr
+}
+
+// secondsDuration returns s seconds as a duration rounded for display.
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(10 * time.Microsecond)
+}
+
 func debugControlKnobs(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unexpected arguments")
@@ -1298,6 +1428,85 @@ func debugControlKnobs(ctx context.Context, args []string) error {
 	return nil
 }
 
+var filterTraceArgs struct {
+	proto string
+	out   bool
+	live  bool
+	drops bool
+	json  bool
+}
+
+func runDebugFilterTrace(ctx context.Context, args []string) error {
+	if filterTraceArgs.live {
+		if len(args) != 0 {
+			return errors.New("--live takes no arguments")
+		}
+		for tr, err := range localClient.StreamFilterTraces(ctx, filterTraceArgs.drops) {
+			if err != nil {
+				return err
+			}
+			printFilterTrace(tr)
+		}
+		return nil
+	}
+	if filterTraceArgs.drops {
+		return errors.New("--drops requires --live")
+	}
+
+	if len(args) != 2 {
+		return errors.New("usage: tailscale debug filter-trace [flags] <src-ip[:port]> <dst-ip[:port]>")
+	}
+	var addrs [2]netip.AddrPort
+	for i, arg := range args {
+		if ip, err := netip.ParseAddr(arg); err == nil {
+			addrs[i] = netip.AddrPortFrom(ip, 0)
+			continue
+		}
+		ap, err := netip.ParseAddrPort(arg)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: want ip or ip:port", arg)
+		}
+		addrs[i] = ap
+	}
+	var proto ipproto.Proto
+	if err := proto.UnmarshalText([]byte(filterTraceArgs.proto)); err != nil {
+		return err
+	}
+	tr, err := localClient.DebugFilterTrace(ctx, addrs[0], addrs[1], proto, !filterTraceArgs.out)
+	if err != nil {
+		return err
+	}
+	printFilterTrace(*tr)
+	return nil
+}
+
+func printFilterTrace(tr filtertype.Trace) {
+	if filterTraceArgs.json {
+		j, _ := json.Marshal(tr)
+		outln(string(j))
+		return
+	}
+	printf("%s %s: %s (%s)\n", tr.Dir, tr.Packet, tr.Verdict, tr.Reason)
+	if tr.Jailed {
+		printf("  peer is jailed; used the jailed filter\n")
+	}
+	if tr.ShieldsUp {
+		printf("  shields up; incoming connections are blocked\n")
+	}
+	for _, c := range tr.Caps {
+		printf("  %v has capability %q: %v\n", c.Src, c.Cap, c.Has)
+	}
+	if tr.Rule >= 0 {
+		printf("  matched rule #%d: %s\n", tr.Rule, tr.Match)
+	}
+	if tr.Conntrack {
+		printf("  reply to a flow this node started\n")
+	}
+	if tr.PeerAPI {
+		printf("  accepted anyway: PeerAPI does its own access control\n")
+	}
+}
+
 var debugDialTypesArgs struct {
 	network string
 }
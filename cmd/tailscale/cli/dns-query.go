@@ -19,7 +19,7 @@
 
 var dnsQueryCmd = &ffcli.Command{
 	Name:       "query",
-	ShortUsage: "tailscale dns query <name> [a|aaaa|cname|mx|ns|opt|ptr|srv|txt]",
+	ShortUsage: "tailscale dns query [--resolver=<addr>] <name> [a|aaaa|cname|mx|ns|opt|ptr|srv|txt]",
 	Exec:       runDNSQuery,
 	ShortHelp:  "Perform a DNS query",
 	LongHelp: strings.TrimSpace(`
@@ -31,7 +31,22 @@
 
 The output also provides information about the resolver(s) used to resolve the
 query.
+
+The --resolver flag sends the query to the given upstream resolver instead of
+the ones configured for the name, which is useful for testing a resolver before
+using it in a DNS route. It accepts a plain IP address or IP:port, or
+"tls://host[:port]" for DNS over TLS.
 `),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("query")
+		fs.StringVar(&dnsQueryArgs.resolver, "resolver", "", `upstream resolver to query instead of the configured ones, such as "tls://1.1.1.1"`)
+		return fs
+	})(),
+}
+
+// dnsQueryArgs are the arguments for the "dns query" subcommand.
+var dnsQueryArgs struct {
+	resolver string
 }
 
 func runDNSQuery(ctx context.Context, args []string) error {
@@ -43,9 +58,13 @@ func runDNSQuery(ctx context.Context, args []string) error {
 	if len(args) >= 2 {
 		queryType = args[1]
 	}
-	fmt.Printf("DNS query for %q (%s) using internal resolver:\n", name, queryType)
+	if dnsQueryArgs.resolver != "" {
+		fmt.Printf("DNS query for %q (%s) using resolver %s:\n", name, queryType, dnsQueryArgs.resolver)
+	} else {
+		fmt.Printf("DNS query for %q (%s) using internal resolver:\n", name, queryType)
+	}
 	fmt.Println()
-	bytes, resolvers, err := localClient.QueryDNS(ctx, name, queryType)
+	bytes, resolvers, err := localClient.QueryDNSUpstream(ctx, name, queryType, dnsQueryArgs.resolver)
 	if err != nil {
 		fmt.Printf("failed to query DNS: %v\n", err)
 		return nil
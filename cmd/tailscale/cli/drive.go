@@ -7,19 +7,27 @@
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/drive"
 )
 
 const (
-	driveShareUsage   = "tailscale drive share <name> <path>"
+	driveShareUsage   = "tailscale drive share [--read-only] [--quota=<size>] [--snapshot] <name> <path>"
 	driveRenameUsage  = "tailscale drive rename <oldname> <newname>"
 	driveUnshareUsage = "tailscale drive unshare <name>"
 	driveListUsage    = "tailscale drive list"
+	driveMountUsage   = "tailscale drive mount [<dir>]"
+	driveUnmountUsage = "tailscale drive unmount <dir>"
 )
 
 func init() {
@@ -35,6 +43,8 @@ func driveCmd() *ffcli.Command {
 			driveRenameUsage,
 			driveUnshareUsage,
 			driveListUsage,
+			driveMountUsage,
+			driveUnmountUsage,
 		}, "\n"),
 		LongHelp:  buildShareLongHelp(),
 		UsageFunc: usageFuncNoDefaultValues,
@@ -44,6 +54,13 @@ func driveCmd() *ffcli.Command {
 				ShortUsage: driveShareUsage,
 				Exec:       runDriveShare,
 				ShortHelp:  "[ALPHA] Create or modify a share",
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("share")
+					fs.BoolVar(&driveShareArgs.readOnly, "read-only", false, "don't let anyone write to the share, regardless of their access")
+					fs.StringVar(&driveShareArgs.quota, "quota", "", "limit the total size of files in the share, e.g. 500M or 10G")
+					fs.BoolVar(&driveShareArgs.snapshot, "snapshot", false, "share a read-only copy of the directory as it is now")
+					return fs
+				})(),
 			},
 			{
 				Name:       "rename",
@@ -63,10 +80,28 @@ func driveCmd() *ffcli.Command {
 				ShortHelp:  "[ALPHA] List current shares",
 				Exec:       runDriveList,
 			},
+			{
+				Name:       "mount",
+				ShortUsage: driveMountUsage,
+				ShortHelp:  "[ALPHA] Mount remote shares at a local directory, or list mounts (Linux only)",
+				Exec:       runDriveMount,
+			},
+			{
+				Name:       "unmount",
+				ShortUsage: driveUnmountUsage,
+				ShortHelp:  "[ALPHA] Unmount remote shares from a local directory",
+				Exec:       runDriveUnmount,
+			},
 		},
 	}
 }
 
+var driveShareArgs struct {
+	readOnly bool
+	quota    string
+	snapshot bool
+}
+
 // runDriveShare is the entry point for the "tailscale drive share" command.
 func runDriveShare(ctx context.Context, args []string) error {
 	if len(args) != 2 {
@@ -80,14 +115,140 @@ func runDriveShare(ctx context.Context, args []string) error {
 		return err
 	}
 
-	err = localClient.DriveShareSet(ctx, &drive.Share{
-		Name: name,
-		Path: absolutePath,
-	})
-	if err == nil {
+	share := &drive.Share{
+		Name:     name,
+		Path:     absolutePath,
+		ReadOnly: driveShareArgs.readOnly,
+	}
+	if driveShareArgs.quota != "" {
+		share.QuotaBytes, err = parseByteSize(driveShareArgs.quota)
+		if err != nil {
+			return fmt.Errorf("invalid --quota: %w", err)
+		}
+	}
+	if driveShareArgs.snapshot {
+		share.SnapshotTime = time.Now().Truncate(time.Second)
+		share.SnapshotOf = absolutePath
+		share.Path, err = snapshotDir(name, absolutePath, share.SnapshotTime)
+		if err != nil {
+			return fmt.Errorf("creating snapshot: %w", err)
+		}
+	}
+
+	err = localClient.DriveShareSet(ctx, share)
+	if err != nil {
+		if share.SnapshotOf != "" {
+			os.RemoveAll(share.Path)
+		}
+		return err
+	}
+	if share.SnapshotOf != "" {
+		fmt.Printf("Sharing snapshot of %q taken at %v as %q\n", path, share.SnapshotTime.Format(time.DateTime), name)
+	} else {
 		fmt.Printf("Sharing %q as %q\n", path, name)
 	}
-	return err
+	return nil
+}
+
+// parseByteSize parses a size in bytes, optionally followed by one of the
+// binary unit suffixes K, M, G or T (optionally followed by "B" or "iB").
+func parseByteSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	var shift uint
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGT", num[n-1]); i >= 0 {
+			shift = 10 * uint(i+1)
+			num = num[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("%q is not a positive size", s)
+	}
+	if v > (1<<63-1)>>shift {
+		return 0, fmt.Errorf("%q is too large", s)
+	}
+	return v << shift, nil
+}
+
+// driveSnapshotRoot returns the directory under which snapshots of shared
+// directories are kept.
+func driveSnapshotRoot() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "Tailscale", "drive-snapshots"), nil
+}
+
+// snapshotDir copies the directory src into a new directory under
+// driveSnapshotRoot and returns the path of the copy. Symlinks are skipped,
+// as what they point to isn't part of the snapshot.
+func snapshotDir(name, src string, at time.Time) (string, error) {
+	root, err := driveSnapshotRoot()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return "", err
+	}
+	dst, err := os.MkdirTemp(root, fmt.Sprintf("%s-%d-", strings.ReplaceAll(name, " ", "_"), at.Unix()))
+	if err != nil {
+		return "", err
+	}
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			if rel == "." {
+				return nil
+			}
+			return os.Mkdir(target, 0o700)
+		case d.Type().IsRegular():
+			return copySnapshotFile(p, target)
+		default:
+			fmt.Fprintf(Stderr, "skipping %q in snapshot: not a regular file or directory\n", p)
+			return nil
+		}
+	})
+	if err != nil {
+		os.RemoveAll(dst)
+		return "", err
+	}
+	return dst, nil
+}
+
+// copySnapshotFile copies the regular file src to dst, preserving its
+// permission bits and modification time.
+func copySnapshotFile(src, dst string) error {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	fi, err := sf.Stat()
+	if err != nil {
+		return err
+	}
+	df, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(df, sf); err != nil {
+		df.Close()
+		return err
+	}
+	if err := df.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
 }
 
 // runDriveUnshare is the entry point for the "tailscale drive unshare" command.
@@ -97,11 +258,39 @@ func runDriveUnshare(ctx context.Context, args []string) error {
 	}
 	name := args[0]
 
+	// Look up the share first, so that the copy backing a snapshot share
+	// can be removed along with it.
+	var snapshotPath string
+	normalized, _ := drive.NormalizeShareName(name)
+	if shares, err := localClient.DriveShareList(ctx); err == nil {
+		for _, share := range shares {
+			if share.Name == normalized && share.SnapshotOf != "" {
+				snapshotPath = share.Path
+			}
+		}
+	}
+
 	err := localClient.DriveShareRemove(ctx, name)
-	if err == nil {
-		fmt.Printf("No longer sharing %q\n", name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("No longer sharing %q\n", name)
+	if snapshotPath != "" {
+		removeDriveSnapshot(snapshotPath)
+	}
+	return nil
+}
+
+// removeDriveSnapshot removes the snapshot at path, as long as it's one of
+// ours.
+func removeDriveSnapshot(path string) {
+	root, err := driveSnapshotRoot()
+	if err != nil || filepath.Dir(path) != root {
+		return
+	}
+	if err := os.RemoveAll(path); err != nil {
+		fmt.Fprintf(Stderr, "failed to remove snapshot %q: %v\n", path, err)
 	}
-	return err
 }
 
 // runDriveRename is the entry point for the "tailscale drive rename" command.
@@ -133,7 +322,9 @@ func runDriveList(ctx context.Context, args []string) error {
 	longestName := 4 // "name"
 	longestPath := 4 // "path"
 	longestAs := 2   // "as"
-	for _, share := range shares {
+	options := make([]string, len(shares))
+	for i, share := range shares {
+		options[i] = driveShareOptions(share)
 		if len(share.Name) > longestName {
 			longestName = len(share.Name)
 		}
@@ -144,16 +335,84 @@ func runDriveList(ctx context.Context, args []string) error {
 			longestAs = len(share.As)
 		}
 	}
-	formatString := fmt.Sprintf("%%-%ds    %%-%ds    %%s\n", longestName, longestPath)
-	fmt.Printf(formatString, "name", "path", "as")
-	fmt.Printf(formatString, strings.Repeat("-", longestName), strings.Repeat("-", longestPath), strings.Repeat("-", longestAs))
-	for _, share := range shares {
-		fmt.Printf(formatString, share.Name, share.Path, share.As)
+	formatString := fmt.Sprintf("%%-%ds    %%-%ds    %%-%ds    %%s\n", longestName, longestPath, longestAs)
+	fmt.Printf(formatString, "name", "path", "as", "options")
+	fmt.Printf(formatString, strings.Repeat("-", longestName), strings.Repeat("-", longestPath), strings.Repeat("-", longestAs), strings.Repeat("-", 7))
+	for i, share := range shares {
+		fmt.Printf(formatString, share.Name, share.Path, share.As, options[i])
 	}
 
 	return nil
 }
 
+// driveShareOptions returns a short description of the options set on
+// share, for display by "tailscale drive list".
+func driveShareOptions(share *drive.Share) string {
+	var opts []string
+	if share.IsReadOnly() {
+		opts = append(opts, "read-only")
+	}
+	if share.QuotaBytes > 0 {
+		opts = append(opts, fmt.Sprintf("quota=%d", share.QuotaBytes))
+	}
+	if share.SnapshotOf != "" {
+		opts = append(opts, fmt.Sprintf("snapshot of %s at %s", share.SnapshotOf, share.SnapshotTime.Local().Format(time.DateTime)))
+	}
+	return strings.Join(opts, ", ")
+}
+
+// runDriveMount is the entry point for the "tailscale drive mount" command.
+func runDriveMount(ctx context.Context, args []string) error {
+	switch len(args) {
+	case 0:
+		dirs, err := localClient.DriveMountList(ctx)
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			fmt.Println(dir)
+		}
+		return nil
+	case 1:
+	default:
+		return fmt.Errorf("usage: %s", driveMountUsage)
+	}
+
+	// tailscaled refuses to mount on paths with symlinks in them, so
+	// resolve them here.
+	dir, err := filepath.Abs(args[0])
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return err
+	}
+	if err := localClient.DriveMount(ctx, dir); err != nil {
+		return err
+	}
+	fmt.Printf("Mounted remote shares at %q\n", dir)
+	return nil
+}
+
+// runDriveUnmount is the entry point for the "tailscale drive unmount" command.
+func runDriveUnmount(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", driveUnmountUsage)
+	}
+	dir, err := filepath.Abs(args[0])
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return err
+	}
+	if err := localClient.DriveUnmount(ctx, dir); err != nil {
+		return err
+	}
+	fmt.Printf("Unmounted %q\n", dir)
+	return nil
+}
+
 func buildShareLongHelp() string {
 	longHelpAs := ""
 	if drive.AllowShareAs() {
@@ -225,6 +484,14 @@ func buildShareLongHelp() string {
 	  }
 	}]
 
+Shares can be made read-only for everyone, regardless of the access granted to them in ACLs, with --read-only. The total size of the files in a share can be limited with --quota, for example:
+
+  $ tailscale drive share --quota=10G docs /Users/me/Documents
+
+To share a directory as it is right now, use --snapshot. This shares a read-only copy of the directory, which is deleted when the share is removed.
+
+Remote access to shares is recorded in the file drive-audit.log in tailscaled's state directory.
+
 You can rename shares, for example you could rename the above share by running:
 
   $ tailscale drive rename docs newdocs
@@ -235,7 +502,15 @@ func buildShareLongHelp() string {
 
 You can get a list of currently published shares by running:
 
-  $ tailscale drive list`
+  $ tailscale drive list
+
+On Linux, instead of using a WebDAV client, you can mount the shares available to you as a regular filesystem at a directory that you own, for example:
+
+  $ tailscale drive mount ~/taildrive
+
+The mount belongs to you, and lasts until you unmount it or tailscaled stops:
+
+  $ tailscale drive unmount ~/taildrive`
 
 const shareLongHelpAs = `
 
@@ -8,10 +8,13 @@
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime"
 	"net/http"
@@ -69,7 +72,7 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 
 var fileCpCmd = &ffcli.Command{
 	Name:       "cp",
-	ShortUsage: "tailscale file cp <files...> <target>:",
+	ShortUsage: "tailscale file cp [--recursive] <files...> <target>:",
 	ShortHelp:  "Copy file(s) to a host",
 	Exec:       runCp,
 	FlagSet: (func() *flag.FlagSet {
@@ -77,14 +80,16 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 		fs.StringVar(&cpArgs.name, "name", "", "alternate filename to use, especially useful when <file> is \"-\" (stdin)")
 		fs.BoolVar(&cpArgs.verbose, "verbose", false, "verbose output")
 		fs.BoolVar(&cpArgs.targets, "targets", false, "list possible file cp targets")
+		fs.BoolVar(&cpArgs.recursive, "recursive", false, "send directories as a whole, preserving their structure")
 		return fs
 	})(),
 }
 
 var cpArgs struct {
-	name    string
-	verbose bool
-	targets bool
+	name      string
+	verbose   bool
+	targets   bool
+	recursive bool
 }
 
 func runCp(ctx context.Context, args []string) error {
@@ -159,7 +164,16 @@ func runCp(ctx context.Context, args []string) error {
 				return err
 			}
 			if fi.IsDir() {
-				return errors.New("directories not supported")
+				if !cpArgs.recursive {
+					return fmt.Errorf("%s is a directory; use --recursive to send directories", fileArg)
+				}
+				if name == "" {
+					name = filepath.Base(filepath.Clean(fileArg))
+				}
+				if err := sendDir(ctx, stableID, fileArg, name); err != nil {
+					return err
+				}
+				continue
 			}
 			contentLength = fi.Size()
 			fileContents = &countingReader{Reader: io.LimitReader(f, contentLength)}
@@ -196,6 +210,80 @@ func runCp(ctx context.Context, args []string) error {
 	return nil
 }
 
+// sendDir sends the directory dir to the node stableID as a single tree named
+// name.
+func sendDir(ctx context.Context, stableID tailcfg.StableNodeID, dir, name string) error {
+	tm, err := buildFileTreeManifest(dir, name)
+	if err != nil {
+		return err
+	}
+	if cpArgs.verbose {
+		log.Printf("sending directory %q (%d entries) ...", name, len(tm.Entries))
+	}
+	res, err := localClient.PushFileTree(ctx, stableID, dir, tm)
+	if err != nil {
+		return err
+	}
+	if !res.Verified {
+		return fmt.Errorf("sent %q, but the receiver could not verify it", name)
+	}
+	msg := fmt.Sprintf("sent %s as %q: %d files, %s, verified", dir, res.Name, res.Files, formatIEC(float64(res.Bytes), "B"))
+	if res.Resumed > 0 {
+		msg += fmt.Sprintf(" (%d already present)", res.Resumed)
+	}
+	printf("%s\n", msg)
+	return nil
+}
+
+// buildFileTreeManifest walks dir and returns a manifest of it for sending
+// as a tree named name. Anything other than regular files and directories,
+// such as symlinks, is skipped with a warning.
+func buildFileTreeManifest(dir, name string) (*apitype.FileTreeManifest, error) {
+	tm := &apitype.FileTreeManifest{Name: name}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			fmt.Fprintf(Stderr, "# skipping %s: not a regular file or directory\n", p)
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e := apitype.FileTreeEntry{
+			Path: filepath.ToSlash(rel),
+			Mode: fi.Mode() & (fs.ModeDir | fs.ModePerm),
+		}
+		if !d.IsDir() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			h := sha256.New()
+			if e.Size, err = io.Copy(h, f); err != nil {
+				return err
+			}
+			e.SHA256 = hex.EncodeToString(h.Sum(nil))
+		}
+		tm.Entries = append(tm.Entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tm, nil
+}
+
 func progressPrinter(ctx context.Context, name string, contentCount func() int64, contentLength int64) {
 	var rateValueFast, rateValueSlow tsrate.Value
 	rateValueFast.HalfLife = 1 * time.Second  // fast response for rate measurement
@@ -520,7 +608,101 @@ func openFileOrSubstitute(dir, base string, action onConflict) (*os.File, error)
 	}
 }
 
+// mkdirOrSubstitute is like openFileOrSubstitute, but for directories.
+func mkdirOrSubstitute(dir, base string, action onConflict) (string, error) {
+	target := filepath.Join(dir, base)
+	err := os.Mkdir(target, 0755)
+	if err == nil || !os.IsExist(err) {
+		return target, err
+	}
+	switch action {
+	default:
+		return "", fmt.Errorf("refusing to overwrite directory: %w", err)
+	case overwriteExisting:
+		if err := os.RemoveAll(target); err != nil {
+			return "", fmt.Errorf("unable to remove target directory: %w", err)
+		}
+		return target, os.Mkdir(target, 0755)
+	case createNumberedFiles:
+		const maxAttempts = 100
+		for i := 1; i < maxAttempts; i++ {
+			target = numberedFileName(dir, base, i)
+			if err = os.Mkdir(target, 0755); err == nil {
+				return target, nil
+			}
+		}
+		return "", fmt.Errorf("unable to find a name for writing %v, final attempt: %w", base, err)
+	}
+}
+
+// receiveTree moves the directory tree wf out of the inbox into dir.
+func receiveTree(ctx context.Context, wf apitype.WaitingFile, dir string) (targetDir string, size int64, err error) {
+	tm, err := localClient.GetWaitingFileTree(ctx, wf.Name)
+	if err != nil {
+		return "", 0, fmt.Errorf("opening inbox directory %q: %w", wf.Name, err)
+	}
+	targetDir, err = mkdirOrSubstitute(dir, wf.Name, getArgs.conflict)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, e := range tm.Entries {
+		rel := filepath.FromSlash(e.Path)
+		if !filepath.IsLocal(rel) {
+			return "", 0, fmt.Errorf("invalid path %q in inbox directory %q", e.Path, wf.Name)
+		}
+		p := filepath.Join(targetDir, rel)
+		if e.Mode.IsDir() {
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return "", 0, err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return "", 0, err
+		}
+		n, err := receiveTreeFile(ctx, wf.Name, e.Path, p)
+		if err != nil {
+			return "", 0, err
+		}
+		size += n
+	}
+	// Restore the sent modes once everything's written, deepest first, so
+	// that read-only directories don't get in the way.
+	for i := len(tm.Entries) - 1; i >= 0; i-- {
+		e := tm.Entries[i]
+		if err := os.Chmod(filepath.Join(targetDir, filepath.FromSlash(e.Path)), e.Mode.Perm()); err != nil {
+			return "", 0, err
+		}
+	}
+	return targetDir, size, nil
+}
+
+func receiveTreeFile(ctx context.Context, treeName, path, targetFile string) (int64, error) {
+	rc, _, err := localClient.GetWaitingTreeFile(ctx, treeName, path)
+	if err != nil {
+		return 0, fmt.Errorf("opening inbox file %q in %q: %w", path, treeName, err)
+	}
+	defer rc.Close()
+	f, err := os.OpenFile(targetFile, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if err := quarantine.SetOnFile(f); err != nil {
+		f.Close()
+		return 0, fmt.Errorf("failed to apply quarantine attribute to file %v: %v", f.Name(), err)
+	}
+	n, err := io.Copy(f, rc)
+	if err != nil {
+		f.Close()
+		return 0, fmt.Errorf("failed to write %v: %v", f.Name(), err)
+	}
+	return n, f.Close()
+}
+
 func receiveFile(ctx context.Context, wf apitype.WaitingFile, dir string) (targetFile string, size int64, err error) {
+	if wf.IsDir {
+		return receiveTree(ctx, wf, dir)
+	}
 	rc, size, err := localClient.GetWaitingFile(ctx, wf.Name)
 	if err != nil {
 		return "", 0, fmt.Errorf("opening inbox file %q: %w", wf.Name, err)
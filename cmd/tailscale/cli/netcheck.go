@@ -11,6 +11,7 @@
 	"io"
 	"log"
 	"net/http"
+	"net/netip"
 	"sort"
 	"strings"
 	"time"
@@ -20,6 +21,7 @@
 	"tailscale.com/feature/buildfeatures"
 	"tailscale.com/ipn"
 	"tailscale.com/net/netcheck"
+	"tailscale.com/net/netcheck/netchecktype"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/portmapper/portmappertype"
 	"tailscale.com/net/tlsdial"
@@ -46,6 +48,8 @@
 		fs.StringVar(&netcheckArgs.format, "format", "", `output format; empty (for human-readable), "json" or "json-line"`)
 		fs.DurationVar(&netcheckArgs.every, "every", 0, "if non-zero, do an incremental report with the given frequency")
 		fs.BoolVar(&netcheckArgs.verbose, "verbose", false, "verbose logs")
+		fs.BoolVar(&netcheckArgs.history, "history", false, "print the history of tailscaled's reports instead of doing a report")
+		fs.BoolVar(&netcheckArgs.diff, "diff", false, "print what changed between tailscaled's reports instead of doing a report")
 		return fs
 	})(),
 }
@@ -54,9 +58,15 @@
 	format  string
 	every   time.Duration
 	verbose bool
+	history bool
+	diff    bool
 }
 
 func runNetcheck(ctx context.Context, args []string) error {
+	if netcheckArgs.history || netcheckArgs.diff {
+		return runNetcheckHistory(ctx)
+	}
+
 	logf := logger.WithPrefix(log.Printf, "portmap: ")
 	bus := eventbus.New()
 	defer bus.Close()
@@ -221,6 +231,84 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 	return nil
 }
 
+// runNetcheckHistory prints the history of tailscaled's netcheck reports,
+// or with --diff, what changed between them.
+func runNetcheckHistory(ctx context.Context) error {
+	hist, err := localClient.NetcheckHistory(ctx)
+	if err != nil {
+		return err
+	}
+	switch netcheckArgs.format {
+	case "":
+	case "json":
+		j, err := json.MarshalIndent(hist, "", "\t")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q for --history or --diff", netcheckArgs.format)
+	}
+	if len(hist) == 0 {
+		outln("No netcheck reports yet.")
+		return nil
+	}
+	for i := range hist {
+		e := &hist[i]
+		printf("%s", netcheckHistoryTime(e))
+		if i == 0 || !netcheckArgs.diff {
+			printf("\n")
+			printNetcheckHistoryEntry(e)
+			continue
+		}
+		changes := netchecktype.Diff(&hist[i-1], e)
+		printf(", %d changes:\n", len(changes))
+		for _, c := range changes {
+			printf("\t* %v\n", c)
+		}
+	}
+	return nil
+}
+
+// netcheckHistoryTime returns when the reports of e were made.
+func netcheckHistoryTime(e *netchecktype.HistoryEntry) string {
+	first := e.First.Local().Format(time.DateTime)
+	if e.Count == 1 {
+		return first + " (1 report)"
+	}
+	return fmt.Sprintf("%s to %s (%d reports)", first, e.Last.Local().Format(time.DateTime), e.Count)
+}
+
+func printNetcheckHistoryEntry(e *netchecktype.HistoryEntry) {
+	s, n := &e.Summary, &e.Network
+	orUnknown := func(v any) any {
+		if v == "" || v == 0 {
+			return "unknown"
+		}
+		return v
+	}
+	ipStatus := func(ok bool, ip netip.Addr) string {
+		switch {
+		case ip.IsValid():
+			return "yes, " + ip.String()
+		case ok:
+			return "(no addr found)"
+		}
+		return "no"
+	}
+	printf("\t* UDP: %v\n", s.UDP)
+	printf("\t* IPv4: %s\n", ipStatus(s.IPv4, s.GlobalV4))
+	printf("\t* IPv6: %s\n", ipStatus(s.IPv6, s.GlobalV6))
+	printf("\t* NAT: %v\n", orUnknown(s.NAT))
+	printf("\t* Preferred DERP: %v\n", orUnknown(s.PreferredDERP))
+	printf("\t* PortMapping: %v\n", orUnknown(s.PortMapping))
+	if s.CaptivePortal != "" {
+		printf("\t* CaptivePortal: %v\n", s.CaptivePortal)
+	}
+	printf("\t* Network: %v %v (IPv4: %v, IPv6: %v, expensive: %v)\n", orUnknown(n.DefaultRoute), n.Addrs, n.HaveV4, n.HaveV6, n.Expensive)
+}
+
 func portMapping(r *netcheck.Report) string {
 	if !buildfeatures.HasPortMapper {
 		return "binary built without portmapper support"
@@ -198,7 +198,7 @@ func main() {
 	}
 	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
 	flag.Var(flagtype.PortValue(&args.port, defaultPort()), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
-	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
+	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'enc:<path>' to encrypt the state file with a key from $TS_STATE_KEY_FILE, $TS_STATE_KEY or $TS_STATE_KEY_COMMAND; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
 	if buildfeatures.HasTPM {
 		flag.Var(&args.encryptState, "encrypt-state", `encrypt the state file on disk; when not set encryption will be enabled if supported on this platform; uses TPM on Linux and Windows, on all other platforms this flag is not supported`)
 	}
@@ -979,9 +979,10 @@ func canUseHardwareAttestation() error {
 
 // isPortableStore reports whether the given state path refers to a portable
 // state store where state may be loaded on different machines.
-// All stores apart from file store and TPM store are portable.
+// All stores apart from file store, TPM store and encrypted file store are
+// portable.
 func isPortableStore(path string) bool {
-	if store.HasKnownProviderPrefix(path) && !strings.HasPrefix(path, store.TPMPrefix) {
+	if store.HasKnownProviderPrefix(path) && !strings.HasPrefix(path, store.TPMPrefix) && !strings.HasPrefix(path, store.EncryptedFilePrefix) {
 		return true
 	}
 	// In most cases Kubernetes Secret and AWS SSM stores would have been caught
@@ -110,6 +110,11 @@ func TestIsPortableStore(t *testing.T) {
 			path: "tpmseal:/var/lib/tailscale/tailscaled.state",
 			want: false,
 		},
+		{
+			name: "encrypted_file_store",
+			path: "enc:/var/lib/tailscale/tailscaled.state",
+			want: false,
+		},
 		{
 			name: "local_file_store",
 			path: "/var/lib/tailscale/tailscaled.state",
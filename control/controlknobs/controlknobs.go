@@ -113,6 +113,10 @@ type Knobs struct {
 	// resolver on Windows or when the host is domain-joined and its primary domain
 	// takes precedence over MagicDNS. As of 2026-02-13, it is only used on Windows.
 	DisableHostsFileUpdates atomic.Bool
+
+	// DNSForwarderCache is whether the DNS forwarder should cache responses
+	// from upstream resolvers.
+	DNSForwarderCache atomic.Bool
 }
 
 // UpdateFromNodeAttributes updates k (if non-nil) based on the provided self
@@ -144,6 +148,7 @@ func (k *Knobs) UpdateFromNodeAttributes(capMap tailcfg.NodeCapMap) {
 		disableCaptivePortalDetection        = has(tailcfg.NodeAttrDisableCaptivePortalDetection)
 		disableSkipStatusQueue               = has(tailcfg.NodeAttrDisableSkipStatusQueue)
 		disableHostsFileUpdates              = has(tailcfg.NodeAttrDisableHostsFileUpdates)
+		dnsForwarderCache                    = has(tailcfg.NodeAttrDNSForwarderCache)
 	)
 
 	if has(tailcfg.NodeAttrOneCGNATEnable) {
@@ -171,6 +176,7 @@ func (k *Knobs) UpdateFromNodeAttributes(capMap tailcfg.NodeCapMap) {
 	k.DisableCaptivePortalDetection.Store(disableCaptivePortalDetection)
 	k.DisableSkipStatusQueue.Store(disableSkipStatusQueue)
 	k.DisableHostsFileUpdates.Store(disableHostsFileUpdates)
+	k.DNSForwarderCache.Store(dnsForwarderCache)
 
 	// If both attributes are present, then "enable" should win.  This reflects
 	// the history of seamless key renewal.
@@ -0,0 +1,110 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package drive
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes a single operation performed by a remote node on a
+// local share.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+
+	// NodeID and Node are the stable ID and MagicDNS name of the remote node.
+	NodeID string `json:"nodeID"`
+	Node   string `json:"node"`
+
+	// User is the login name of the remote node's user, or empty if the
+	// node is tagged.
+	User string `json:"user,omitempty"`
+
+	// Method is the WebDAV method, e.g. "GET", "PUT" or "MOVE".
+	Method string `json:"method"`
+
+	// Path is the requested path, starting with the share name.
+	Path string `json:"path"`
+
+	// Destination is the destination path of COPY and MOVE operations,
+	// starting with the share name.
+	Destination string `json:"destination,omitempty"`
+
+	// Status is the HTTP status code of the response.
+	Status int `json:"status"`
+
+	// BytesRead and BytesWritten are the number of bytes that the remote node
+	// sent and received, respectively.
+	BytesRead    int64 `json:"bytesRead,omitempty"`
+	BytesWritten int64 `json:"bytesWritten,omitempty"`
+}
+
+// auditLogMaxSize is the size at which an AuditLog is rotated.
+const auditLogMaxSize = 10 << 20
+
+// AuditLog writes AuditRecords as JSON lines to a file. Once the file
+// reaches auditLogMaxSize, it's renamed with a ".1" suffix, replacing any
+// earlier such file, and a new one is started.
+//
+// It is safe for concurrent use.
+type AuditLog struct {
+	path string
+
+	mu   sync.Mutex
+	f    *os.File // nil until first write
+	size int64
+}
+
+// NewAuditLog returns an AuditLog that writes to the file at path.
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Log appends rec to the log.
+func (l *AuditLog) Log(rec *AuditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil && l.size+int64(len(b)) > auditLogMaxSize {
+		l.f.Close()
+		l.f = nil
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	}
+	if l.f == nil {
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		l.f, l.size = f, fi.Size()
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+// Close closes the log file, if open.
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
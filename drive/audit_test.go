@@ -0,0 +1,41 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package drive
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := NewAuditLog(path)
+	defer l.Close()
+	for _, method := range []string{"PUT", "DELETE"} {
+		if err := l.Log(&AuditRecord{NodeID: "n1", Node: "laptop", Method: method, Path: "/docs/a.txt", Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			t.Fatalf("line %q: %v", s.Text(), err)
+		}
+		got = append(got, rec.Method)
+	}
+	if len(got) != 2 || got[0] != "PUT" || got[1] != "DELETE" {
+		t.Errorf("logged methods = %q; want [PUT DELETE]", got)
+	}
+}
@@ -8,6 +8,7 @@
 import (
 	jsonv1 "encoding/json"
 	"errors"
+	"time"
 
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
@@ -105,10 +106,31 @@ func (v ShareView) BookmarkData() views.ByteSlice[[]byte] {
 	return views.ByteSliceOf(v.ж.BookmarkData)
 }
 
+// ReadOnly, if true, makes the share read-only for all remote nodes,
+// regardless of the access their grants give them.
+func (v ShareView) ReadOnly() bool { return v.ж.ReadOnly }
+
+// QuotaBytes, if positive, is the maximum total size in bytes of the
+// files in the share. Remote writes that would exceed it are rejected.
+func (v ShareView) QuotaBytes() int64 { return v.ж.QuotaBytes }
+
+// SnapshotOf, if non-empty, is the path of the directory of which Path
+// is a point-in-time copy, taken at SnapshotTime. Snapshot shares are
+// always read-only.
+func (v ShareView) SnapshotOf() string { return v.ж.SnapshotOf }
+
+// SnapshotTime is when the snapshot in Path was taken. It's only set if
+// SnapshotOf is.
+func (v ShareView) SnapshotTime() time.Time { return v.ж.SnapshotTime }
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ShareViewNeedsRegeneration = Share(struct {
 	Name         string
 	Path         string
 	As           string
 	BookmarkData []byte
+	ReadOnly     bool
+	QuotaBytes   int64
+	SnapshotOf   string
+	SnapshotTime time.Time
 }{})
@@ -112,6 +112,9 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// showing stale stats.
 		// TODO(oxtoacart): maybe only invalidate specific paths
 		h.StatCache.invalidate()
+		// Stats may be cached while the modification is in progress (e.g.
+		// during a long PUT), so invalidate again once it's done.
+		defer h.StatCache.invalidate()
 	}
 
 	if len(pathComponents) >= mpl {
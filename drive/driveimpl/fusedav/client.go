@@ -0,0 +1,259 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package fusedav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/drive/driveimpl/shared"
+)
+
+// metadataTimeout bounds how long WebDAV requests other than GET and PUT,
+// whose bodies are streamed, may take.
+const metadataTimeout = time.Minute
+
+// statusError is returned for WebDAV requests that fail with an unexpected
+// HTTP status.
+type statusError struct {
+	method string
+	path   string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.method, e.path, e.code, http.StatusText(e.code))
+}
+
+// fileInfo describes a file or directory on the WebDAV server.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+// davClient makes the WebDAV requests that back the FUSE filesystem. Paths
+// are slash-separated and absolute, as returned by [shared.Join].
+type davClient struct {
+	hc      *http.Client
+	baseURL string // without trailing slash
+}
+
+func (c *davClient) url(p string) string {
+	return c.baseURL + shared.JoinEscaped(shared.CleanAndSplit(p)...)
+}
+
+func (c *davClient) do(ctx context.Context, method, p string, body io.Reader, hdr http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url(p), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vv := range hdr {
+		req.Header[k] = vv
+	}
+	return c.hc.Do(req)
+}
+
+// doSimple makes a request without a body whose response body is of no
+// interest, returning a *statusError unless the response status is one of ok.
+func (c *davClient) doSimple(method, p string, hdr http.Header, ok ...int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+	defer cancel()
+	res, err := c.do(ctx, method, p, nil, hdr)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	for _, code := range ok {
+		if res.StatusCode == code {
+			return nil
+		}
+	}
+	return &statusError{method, p, res.StatusCode}
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/></D:prop></D:propfind>`
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind returns the files described by a PROPFIND of p at the given
+// depth, keyed by their normalized paths.
+func (c *davClient) propfind(p string, depth int) (map[string]*fileInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+	defer cancel()
+	res, err := c.do(ctx, "PROPFIND", p, strings.NewReader(propfindBody), http.Header{
+		"Depth":        {strconv.Itoa(depth)},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, &statusError{"PROPFIND", p, res.StatusCode}
+	}
+	var ms multistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: %w", p, err)
+	}
+
+	files := make(map[string]*fileInfo, len(ms.Responses))
+	for _, r := range ms.Responses {
+		u, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			fp := shared.Normalize(u.Path)
+			fi := &fileInfo{
+				name:  path.Base(fp),
+				isDir: ps.Prop.ResourceType.Collection != nil,
+			}
+			if !fi.isDir {
+				fi.size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			}
+			fi.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+			files[fp] = fi
+		}
+	}
+	return files, nil
+}
+
+// stat returns information about the file at p.
+func (c *davClient) stat(p string) (*fileInfo, error) {
+	files, err := c.propfind(p, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, ok := files[shared.Normalize(p)]
+	if !ok {
+		return nil, &statusError{"PROPFIND", p, http.StatusNotFound}
+	}
+	return fi, nil
+}
+
+// readDir returns the contents of the directory at p.
+func (c *davClient) readDir(p string) ([]*fileInfo, error) {
+	files, err := c.propfind(p, 1)
+	if err != nil {
+		return nil, err
+	}
+	self := shared.Normalize(p)
+	if fi, ok := files[self]; ok && !fi.isDir {
+		return nil, &statusError{"PROPFIND", p, http.StatusMethodNotAllowed}
+	}
+	delete(files, self)
+	fis := make([]*fileInfo, 0, len(files))
+	for _, fi := range files {
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
+
+// get returns the contents of the file at p starting at offset off. It
+// returns io.EOF if off is at or past the end of the file.
+func (c *davClient) get(p string, off int64) (io.ReadCloser, error) {
+	var hdr http.Header
+	if off > 0 {
+		hdr = http.Header{"Range": {fmt.Sprintf("bytes=%d-", off)}}
+	}
+	res, err := c.do(context.Background(), "GET", p, nil, hdr)
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		return res.Body, nil
+	case http.StatusOK:
+		// The server ignored the Range header.
+		if _, err := io.CopyN(io.Discard, res.Body, off); err != nil {
+			res.Body.Close()
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		return res.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		res.Body.Close()
+		return nil, io.EOF
+	}
+	res.Body.Close()
+	return nil, &statusError{"GET", p, res.StatusCode}
+}
+
+// put replaces the contents of the file at p with body. If size is -1, the
+// body is streamed without knowing its length up front.
+func (c *davClient) put(p string, body io.Reader, size int64) error {
+	req, err := http.NewRequest("PUT", c.url(p), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	}
+	return &statusError{"PUT", p, res.StatusCode}
+}
+
+// mkdir creates the directory p.
+func (c *davClient) mkdir(p string) error {
+	return c.doSimple("MKCOL", p, nil, http.StatusCreated)
+}
+
+// delete removes the file or directory p, including anything in it.
+func (c *davClient) delete(p string) error {
+	return c.doSimple("DELETE", p, nil, http.StatusOK, http.StatusNoContent)
+}
+
+// move renames the file or directory from to to, replacing anything already
+// at to if overwrite is set.
+func (c *davClient) move(from, to string, overwrite bool) error {
+	hdr := http.Header{
+		"Destination": {c.url(to)},
+		"Overwrite":   {"F"},
+	}
+	if overwrite {
+		hdr.Set("Overwrite", "T")
+	}
+	return c.doSimple("MOVE", from, hdr, http.StatusCreated, http.StatusNoContent)
+}
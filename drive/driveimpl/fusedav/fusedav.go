@@ -0,0 +1,42 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package fusedav mounts a WebDAV service as a local filesystem using FUSE.
+// It speaks the Linux FUSE kernel protocol directly and serves each
+// filesystem operation with one or more WebDAV requests. The kernel caches
+// file attributes and directory entries for Config.AttrTTL, which should
+// match how long the WebDAV service caches the PROPFIND results they come
+// from.
+//
+// Because WebDAV can only replace whole files, files opened with O_TRUNC or
+// newly created are streamed to the server as they're written and must be
+// written sequentially. Other writes are made to a local copy of the file
+// that's uploaded when the file is closed.
+package fusedav
+
+import (
+	"net/http"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+// Config configures a mount.
+type Config struct {
+	// Logf specifies a logging function to use.
+	Logf logger.Logf
+
+	// Transport is used to make requests to the WebDAV service at BaseURL.
+	Transport http.RoundTripper
+
+	// BaseURL is the URL of the root of the WebDAV service.
+	BaseURL string
+
+	// UID and GID are the user and group that own all files in the mount.
+	// Only that user may access them.
+	UID, GID uint32
+
+	// AttrTTL is how long the kernel may cache file attributes and
+	// directory entries. If zero, it's one second.
+	AttrTTL time.Duration
+}
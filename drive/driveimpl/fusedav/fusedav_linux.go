@@ -0,0 +1,1217 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package fusedav
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// defaultAttrTTL is how long the kernel may cache file attributes and
+	// directory entries if Config.AttrTTL is zero.
+	defaultAttrTTL = time.Second
+
+	// maxWrite is the largest write the kernel may send us.
+	maxWrite = 128 << 10
+
+	// readBufSize is the size of the buffer for reading requests from the
+	// kernel, which must fit the largest write plus its headers.
+	readBufSize = maxWrite + 4096
+
+	// fattrFh is set in setattrIn.Valid if setattrIn.Fh is set.
+	fattrFh = 1 << 6
+)
+
+var errUploadEnded = errors.New("upload ended")
+
+// FS is a mounted filesystem.
+type FS struct {
+	dir       string
+	source    string // unique mount source, by which to find the mount
+	attrTTL   time.Duration
+	logf      func(format string, args ...any)
+	c         *davClient
+	uid, gid  uint32
+	fd        int
+	mountTime time.Time
+	done      chan struct{}  // closed when the kernel connection ends
+	inflight  sync.WaitGroup // requests being served
+
+	mu      sync.Mutex
+	nodes   map[uint64]*node
+	byPath  map[string]uint64
+	nextID  uint64
+	handles map[uint64]*handle
+	nextFh  uint64
+	pending map[string]int64 // sizes of files being written but not yet uploaded
+}
+
+// node is a file or directory that the kernel knows about.
+type node struct {
+	path    string
+	lookups uint64
+}
+
+// Mount mounts the WebDAV service described by cfg at the local directory
+// dir, which must be an absolute path with no symlinks in it. To keep users
+// from hiding each other's files, dir must be owned by cfg.UID unless that's
+// root.
+//
+// The mount lasts until Close is called, or it's unmounted by other means.
+func Mount(dir string, cfg Config) (*FS, error) {
+	// Check and mount the directory through a file descriptor, so that it
+	// can't be swapped for another one in between.
+	dirFd, err := openMountDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(dirFd)
+	var st unix.Stat_t
+	if err := unix.Fstat(dirFd, &st); err != nil {
+		return nil, fmt.Errorf("stat %s: %w", dir, err)
+	}
+	if cfg.UID != 0 && st.Uid != cfg.UID {
+		return nil, fmt.Errorf("%s is not owned by uid %d", dir, cfg.UID)
+	}
+
+	fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening /dev/fuse: %w", err)
+	}
+	opts := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,allow_other,default_permissions,max_read=%d", fd, cfg.UID, cfg.GID, maxWrite)
+	target := fmt.Sprintf("/proc/self/fd/%d", dirFd)
+	source := "taildrive-" + strings.ToLower(rand.Text())
+	if err := unix.Mount(source, target, "fuse.taildrive", unix.MS_NOSUID|unix.MS_NODEV, opts); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("mounting %s: %w", dir, err)
+	}
+
+	logf := cfg.Logf
+	if logf == nil {
+		logf = log.Printf
+	}
+	fs := &FS{
+		dir:     dir,
+		source:  source,
+		attrTTL: cmp.Or(cfg.AttrTTL, defaultAttrTTL),
+		logf:    logf,
+		c: &davClient{
+			hc:      &http.Client{Transport: cfg.Transport},
+			baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		},
+		uid:       cfg.UID,
+		gid:       cfg.GID,
+		fd:        fd,
+		mountTime: time.Now(),
+		done:      make(chan struct{}),
+		nodes:     map[uint64]*node{rootID: {path: "/", lookups: 1}},
+		byPath:    map[string]uint64{"/": rootID},
+		nextID:    rootID,
+		handles:   make(map[uint64]*handle),
+		pending:   make(map[string]int64),
+	}
+	go fs.serve()
+	return fs, nil
+}
+
+// openMountDir returns an O_PATH file descriptor for the directory dir. It
+// opens each element of dir in turn without following symlinks, so that
+// none of them can be replaced by a symlink to somewhere else.
+func openMountDir(dir string) (int, error) {
+	if !filepath.IsAbs(dir) {
+		return -1, fmt.Errorf("%s is not an absolute path", dir)
+	}
+	const flags = unix.O_PATH | unix.O_NOFOLLOW | unix.O_DIRECTORY | unix.O_CLOEXEC
+	fd, err := unix.Open("/", flags, 0)
+	if err != nil {
+		return -1, err
+	}
+	for _, elem := range strings.Split(filepath.Clean(dir), "/") {
+		if elem == "" {
+			continue
+		}
+		next, err := unix.Openat(fd, elem, flags, 0)
+		unix.Close(fd)
+		if err == unix.ENOTDIR || err == unix.ELOOP {
+			// With O_NOFOLLOW, a symlink isn't a directory.
+			return -1, fmt.Errorf("%s is not a directory or contains a symlink", dir)
+		}
+		if err != nil {
+			return -1, &os.PathError{Op: "open", Path: dir, Err: err}
+		}
+		fd = next
+	}
+	return fd, nil
+}
+
+// Close unmounts fs, if it's still mounted. It's detached from the
+// filesystem hierarchy right away, and files still open in it are served
+// until they're closed.
+//
+// The mount is found by its source in /proc/self/mountinfo rather than by
+// the directory it was mounted at, whose ancestors may have been renamed or
+// replaced with symlinks since, and it's unmounted through a file
+// descriptor for its root, after checking that it's the mount's.
+func (fs *FS) Close() error {
+	dir, dev, ok, err := findMount(fs.source)
+	if err != nil {
+		return fmt.Errorf("unmounting %s: %w", fs.dir, err)
+	}
+	if !ok {
+		// Unmounted by other means.
+		return nil
+	}
+	fd, err := openMountDir(dir)
+	if err != nil {
+		return fmt.Errorf("unmounting %s: %w", dir, err)
+	}
+	defer unix.Close(fd)
+	var stx unix.Statx_t
+	// Don't ask the FUSE server for the attributes, since only the device
+	// and mount root attribute matter, which the kernel knows.
+	if err := unix.Statx(fd, "", unix.AT_EMPTY_PATH|unix.AT_STATX_DONT_SYNC, unix.STATX_TYPE, &stx); err != nil {
+		return fmt.Errorf("unmounting %s: stat: %w", dir, err)
+	}
+	isRoot := stx.Attributes_mask&unix.STATX_ATTR_MOUNT_ROOT == 0 || stx.Attributes&unix.STATX_ATTR_MOUNT_ROOT != 0
+	if unix.Mkdev(stx.Dev_major, stx.Dev_minor) != dev || !isRoot {
+		return fmt.Errorf("unmounting %s: it's no longer the mount's root", dir)
+	}
+	// Our own descriptor keeps the mount busy, so detach it; it's released
+	// once the descriptor and any open files in it are closed.
+	target := fmt.Sprintf("/proc/self/fd/%d", fd)
+	if err := unix.Unmount(target, unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmounting %s: %w", dir, err)
+	}
+	return nil
+}
+
+// Mounted reports whether fs is still mounted. It's not once it's been
+// unmounted, whether by Close or by other means.
+func (fs *FS) Mounted() bool {
+	_, _, ok, err := findMount(fs.source)
+	return ok || err != nil
+}
+
+// findMount returns the mount point and device number of the mount with the
+// given source, per /proc/self/mountinfo. ok is false if there's none.
+func findMount(source string) (dir string, dev uint64, ok bool, err error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer f.Close()
+	return findMountIn(f, source)
+}
+
+// findMountIn is like findMount, reading the mountinfo from r.
+func findMountIn(r io.Reader, source string) (dir string, dev uint64, ok bool, err error) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		// The fields are described in proc_pid_mountinfo(5). Optional
+		// fields end with a "-", after which come the filesystem type
+		// and source.
+		f := strings.Fields(sc.Text())
+		sep := slices.Index(f, "-")
+		if sep < 6 || sep+2 >= len(f) || f[sep+2] != source {
+			continue
+		}
+		var major, minor uint32
+		if _, err := fmt.Sscanf(f[2], "%d:%d", &major, &minor); err != nil {
+			return "", 0, false, fmt.Errorf("parsing mountinfo: %w", err)
+		}
+		return unescapeMountInfo(f[4]), unix.Mkdev(major, minor), true, nil
+	}
+	return "", 0, false, sc.Err()
+}
+
+// unescapeMountInfo undoes the octal escaping of spaces, tabs, newlines and
+// backslashes in paths in /proc/self/mountinfo.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			b.WriteByte((s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isOctal(c byte) bool { return '0' <= c && c <= '7' }
+
+var bufPool = sync.Pool{
+	New: func() any { return make([]byte, readBufSize) },
+}
+
+// serve reads requests from the kernel until the connection ends.
+func (fs *FS) serve() {
+	defer close(fs.done)
+	defer fs.cleanup()
+	for {
+		buf := bufPool.Get().([]byte)
+		n, err := unix.Read(fs.fd, buf)
+		switch err {
+		case nil:
+		case unix.EINTR, unix.EAGAIN, unix.ENOENT:
+			// ENOENT means the request was interrupted before we read it.
+			bufPool.Put(buf)
+			continue
+		case unix.ENODEV:
+			// Unmounted.
+			return
+		default:
+			fs.logf("fusedav: reading from /dev/fuse: %v", err)
+			return
+		}
+		var hdr inHeader
+		if n < inHeaderSize || !decode(buf, &hdr) || int(hdr.Len) > n {
+			fs.logf("fusedav: short request of %d bytes", n)
+			bufPool.Put(buf)
+			continue
+		}
+		body := buf[inHeaderSize:hdr.Len]
+		if hdr.Opcode == opInit {
+			fs.init(&hdr, body)
+			bufPool.Put(buf)
+			continue
+		}
+		fs.inflight.Go(func() {
+			defer bufPool.Put(buf)
+			fs.dispatch(&hdr, body)
+		})
+	}
+}
+
+// cleanup releases any handles still open once the kernel connection has
+// ended, which only happens if it was aborted.
+func (fs *FS) cleanup() {
+	// Requests still being served will reply to fs.fd, which mustn't be
+	// closed and reused by then.
+	fs.inflight.Wait()
+	unix.Close(fs.fd)
+	fs.mu.Lock()
+	handles := fs.handles
+	fs.handles = map[uint64]*handle{}
+	fs.mu.Unlock()
+	for _, h := range handles {
+		go h.release()
+	}
+}
+
+func decode(b []byte, v any) bool {
+	return binary.Read(bytes.NewReader(b), binary.NativeEndian, v) == nil
+}
+
+func encode(v any) []byte {
+	b, err := binary.Append(nil, binary.NativeEndian, v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// cstring returns the NUL-terminated string at the start of b, and what
+// follows it.
+func cstring(b []byte) (s string, rest []byte) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return string(b), nil
+	}
+	return string(b[:i]), b[i+1:]
+}
+
+// reply sends the reply to the request identified by unique. out is either
+// a []byte or a fixed-size value to be encoded.
+func (fs *FS) reply(unique uint64, out any, errno unix.Errno) {
+	var body []byte
+	if errno == 0 {
+		switch out := out.(type) {
+		case nil:
+		case []byte:
+			body = out
+		default:
+			body = encode(out)
+		}
+	}
+	buf := encode(outHeader{
+		Len:    uint32(outHeaderSize + len(body)),
+		Error:  -int32(errno),
+		Unique: unique,
+	})
+	buf = append(buf, body...)
+	if _, err := unix.Write(fs.fd, buf); err != nil && err != unix.ENOENT {
+		fs.logf("fusedav: writing reply: %v", err)
+	}
+}
+
+func (fs *FS) init(hdr *inHeader, body []byte) {
+	var in initIn
+	if !decode(body, &in) {
+		fs.reply(hdr.Unique, nil, unix.EIO)
+		return
+	}
+	out := initOut{
+		Major: protoMajor,
+		Minor: min(in.Minor, protoMinor),
+	}
+	if in.Major != protoMajor {
+		// If the kernel speaks a newer major version, it'll try again with
+		// ours. If it only speaks an older one, there's nothing to be done.
+		if in.Major < protoMajor {
+			fs.reply(hdr.Unique, nil, unix.EPROTO)
+			return
+		}
+		fs.reply(hdr.Unique, out, 0)
+		return
+	}
+	out.MaxReadahead = in.MaxReadahead
+	out.Flags = in.Flags & (initAtomicOTrunc | initBigWrites)
+	out.MaxBackground = 16
+	out.CongestionThreshold = 12
+	out.MaxWrite = maxWrite
+	out.TimeGran = 1
+	b := encode(out)
+	if in.Minor < 23 {
+		b = b[:initOutCompat22Size]
+	}
+	fs.reply(hdr.Unique, b, 0)
+}
+
+func (fs *FS) dispatch(hdr *inHeader, body []byte) {
+	var out any
+	var errno unix.Errno
+	switch hdr.Opcode {
+	case opForget:
+		var in forgetIn
+		if decode(body, &in) {
+			fs.forget(hdr.NodeID, in.Nlookup)
+		}
+		return // no reply
+	case opBatchForget:
+		var in batchForgetIn
+		if decode(body, &in) {
+			body = body[8:]
+			for range in.Count {
+				var one forgetOne
+				if !decode(body, &one) {
+					break
+				}
+				fs.forget(one.NodeID, one.Nlookup)
+				body = body[16:]
+			}
+		}
+		return // no reply
+	case opInterrupt:
+		// We can't interrupt requests in progress, so the kernel will just
+		// have to wait for our reply.
+		return
+	case opLookup:
+		out, errno = fs.lookup(hdr, body)
+	case opGetattr:
+		out, errno = fs.getattr(hdr)
+	case opSetattr:
+		out, errno = fs.setattr(hdr, body)
+	case opMkdir:
+		out, errno = fs.mkdir(hdr, body)
+	case opUnlink:
+		errno = fs.unlink(hdr, body, false)
+	case opRmdir:
+		errno = fs.unlink(hdr, body, true)
+	case opRename:
+		var in renameIn
+		if !decode(body, &in) {
+			errno = unix.EINVAL
+			break
+		}
+		errno = fs.rename(hdr, in.Newdir, 0, body[8:])
+	case opRename2:
+		var in rename2In
+		if !decode(body, &in) {
+			errno = unix.EINVAL
+			break
+		}
+		errno = fs.rename(hdr, in.Newdir, in.Flags, body[16:])
+	case opOpen, opOpendir:
+		out, errno = fs.open(hdr, body)
+	case opCreate:
+		out, errno = fs.create(hdr, body)
+	case opRead:
+		out, errno = fs.read(body)
+	case opReaddir:
+		out, errno = fs.readdir(body)
+	case opWrite:
+		out, errno = fs.write(body)
+	case opFlush:
+		var in flushIn
+		if !decode(body, &in) {
+			errno = unix.EINVAL
+			break
+		}
+		if h := fs.handle(in.Fh); h != nil {
+			errno = h.flush()
+		}
+	case opFsync:
+		var in fsyncIn
+		if !decode(body, &in) {
+			errno = unix.EINVAL
+			break
+		}
+		if h := fs.handle(in.Fh); h != nil {
+			errno = h.sync()
+		}
+	case opRelease, opReleasedir:
+		var in releaseIn
+		if !decode(body, &in) {
+			errno = unix.EINVAL
+			break
+		}
+		fs.release(in.Fh)
+	case opStatfs:
+		out = statfsOut{Bsize: 4096, Frsize: 4096, Namelen: 255}
+	case opAccess, opFsyncdir, opDestroy:
+		// Permissions are checked by the kernel and the WebDAV service.
+	default:
+		errno = unix.ENOSYS
+	}
+	fs.reply(hdr.Unique, out, errno)
+}
+
+// errnoOf returns the errno to report for err.
+func (fs *FS) errnoOf(err error) unix.Errno {
+	var se *statusError
+	if errors.As(err, &se) {
+		switch se.code {
+		case http.StatusNotFound, http.StatusConflict:
+			return unix.ENOENT
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return unix.EACCES
+		case http.StatusPreconditionFailed:
+			return unix.EEXIST
+		case http.StatusLocked:
+			return unix.EBUSY
+		case http.StatusInsufficientStorage, http.StatusRequestEntityTooLarge:
+			return unix.ENOSPC
+		}
+	}
+	fs.logf("fusedav: %v", err)
+	return unix.EIO
+}
+
+// addLookup records a lookup by the kernel of the file at p and returns its
+// node ID.
+func (fs *FS) addLookup(p string) uint64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if id, ok := fs.byPath[p]; ok {
+		fs.nodes[id].lookups++
+		return id
+	}
+	fs.nextID++
+	id := fs.nextID
+	fs.nodes[id] = &node{path: p, lookups: 1}
+	fs.byPath[p] = id
+	return id
+}
+
+func (fs *FS) forget(id, n uint64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	nd, ok := fs.nodes[id]
+	if !ok || id == rootID {
+		return
+	}
+	if nd.lookups > n {
+		nd.lookups -= n
+		return
+	}
+	delete(fs.nodes, id)
+	if fs.byPath[nd.path] == id {
+		delete(fs.byPath, nd.path)
+	}
+}
+
+// pathOf returns the path of the node with the given ID.
+func (fs *FS) pathOf(id uint64) (string, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	nd, ok := fs.nodes[id]
+	if !ok {
+		return "", false
+	}
+	return nd.path, true
+}
+
+// childPath returns the path of the file named by the NUL-terminated name at
+// the start of b in the directory with the given node ID.
+func (fs *FS) childPath(parent uint64, b []byte) (p string, rest []byte, errno unix.Errno) {
+	dir, ok := fs.pathOf(parent)
+	if !ok {
+		return "", nil, unix.ESTALE
+	}
+	name, rest := cstring(b)
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", nil, unix.EINVAL
+	}
+	return path.Join(dir, name), rest, 0
+}
+
+// removed updates the nodes after the file at p was removed.
+func (fs *FS) removed(p string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.byPath, p)
+}
+
+// renamed updates the nodes after the file at from was renamed to to.
+func (fs *FS) renamed(from, to string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for id, nd := range fs.nodes {
+		if nd.path != from && !strings.HasPrefix(nd.path, from+"/") {
+			continue
+		}
+		if fs.byPath[nd.path] == id {
+			delete(fs.byPath, nd.path)
+		}
+		nd.path = to + nd.path[len(from):]
+		fs.byPath[nd.path] = id
+	}
+	if size, ok := fs.pending[from]; ok {
+		delete(fs.pending, from)
+		fs.pending[to] = size
+	}
+}
+
+func (fs *FS) setPending(p string, size int64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.pending[p] = size
+}
+
+func (fs *FS) clearPending(p string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.pending, p)
+}
+
+// stat returns information about the file at p, taking into account local
+// changes not yet uploaded.
+func (fs *FS) stat(p string) (*fileInfo, error) {
+	fs.mu.Lock()
+	size, ok := fs.pending[p]
+	fs.mu.Unlock()
+	if ok {
+		return &fileInfo{name: path.Base(p), size: size, modTime: time.Now()}, nil
+	}
+	return fs.c.stat(p)
+}
+
+func (fs *FS) attr(id uint64, fi *fileInfo) attr {
+	a := attr{
+		Ino:     id,
+		Size:    uint64(fi.size),
+		Blocks:  (uint64(fi.size) + 511) / 512,
+		Nlink:   1,
+		UID:     fs.uid,
+		GID:     fs.gid,
+		Blksize: 4096,
+		Mode:    unix.S_IFREG | 0o600,
+	}
+	if fi.isDir {
+		a.Mode = unix.S_IFDIR | 0o700
+		a.Nlink = 2
+	}
+	t := fi.modTime
+	if t.IsZero() {
+		t = fs.mountTime
+	}
+	a.Atime, a.Mtime, a.Ctime = uint64(t.Unix()), uint64(t.Unix()), uint64(t.Unix())
+	a.AtimeNsec, a.MtimeNsec, a.CtimeNsec = uint32(t.Nanosecond()), uint32(t.Nanosecond()), uint32(t.Nanosecond())
+	return a
+}
+
+// entry records a lookup of the file at p and returns the entry for it.
+func (fs *FS) entry(p string, fi *fileInfo) entryOut {
+	id := fs.addLookup(p)
+	return entryOut{
+		NodeID:         id,
+		EntryValid:     uint64(fs.attrTTL / time.Second),
+		EntryValidNsec: uint32(fs.attrTTL % time.Second),
+		AttrValid:      uint64(fs.attrTTL / time.Second),
+		AttrValidNsec:  uint32(fs.attrTTL % time.Second),
+		Attr:           fs.attr(id, fi),
+	}
+}
+
+func (fs *FS) lookup(hdr *inHeader, body []byte) (any, unix.Errno) {
+	p, _, errno := fs.childPath(hdr.NodeID, body)
+	if errno != 0 {
+		return nil, errno
+	}
+	fi, err := fs.stat(p)
+	if err != nil {
+		return nil, fs.errnoOf(err)
+	}
+	return fs.entry(p, fi), 0
+}
+
+func (fs *FS) getattr(hdr *inHeader) (any, unix.Errno) {
+	p, ok := fs.pathOf(hdr.NodeID)
+	if !ok {
+		return nil, unix.ESTALE
+	}
+	fi, err := fs.stat(p)
+	if err != nil {
+		return nil, fs.errnoOf(err)
+	}
+	return attrOut{
+		AttrValid:     uint64(fs.attrTTL / time.Second),
+		AttrValidNsec: uint32(fs.attrTTL % time.Second),
+		Attr:          fs.attr(hdr.NodeID, fi),
+	}, 0
+}
+
+// setattr only supports changing the size of files. Other changes, like to
+// the mode or times of files, are silently ignored, as WebDAV has no way of
+// making them.
+func (fs *FS) setattr(hdr *inHeader, body []byte) (any, unix.Errno) {
+	var in setattrIn
+	if !decode(body, &in) {
+		return nil, unix.EINVAL
+	}
+	if in.Valid&fattrSize != 0 {
+		p, ok := fs.pathOf(hdr.NodeID)
+		if !ok {
+			return nil, unix.ESTALE
+		}
+		var h *handle
+		if in.Valid&fattrFh != 0 {
+			h = fs.handle(in.Fh)
+		}
+		if h == nil {
+			// Truncate the file without it being open.
+			h = &handle{fs: fs, node: hdr.NodeID}
+			defer h.release()
+		}
+		if errno := h.truncate(p, int64(in.Size)); errno != 0 {
+			return nil, errno
+		}
+		if in.Valid&fattrFh == 0 {
+			if errno := h.flush(); errno != 0 {
+				return nil, errno
+			}
+		}
+	}
+	return fs.getattr(hdr)
+}
+
+func (fs *FS) mkdir(hdr *inHeader, body []byte) (any, unix.Errno) {
+	if len(body) < 8 {
+		return nil, unix.EINVAL
+	}
+	p, _, errno := fs.childPath(hdr.NodeID, body[8:])
+	if errno != 0 {
+		return nil, errno
+	}
+	if err := fs.c.mkdir(p); err != nil {
+		var se *statusError
+		if errors.As(err, &se) && se.code == http.StatusMethodNotAllowed {
+			return nil, unix.EEXIST
+		}
+		return nil, fs.errnoOf(err)
+	}
+	return fs.entry(p, &fileInfo{name: path.Base(p), isDir: true, modTime: time.Now()}), 0
+}
+
+func (fs *FS) unlink(hdr *inHeader, body []byte, dir bool) unix.Errno {
+	p, _, errno := fs.childPath(hdr.NodeID, body)
+	if errno != 0 {
+		return errno
+	}
+	if dir {
+		// WebDAV deletes directories along with their contents, so check
+		// that there are none first.
+		fis, err := fs.c.readDir(p)
+		if err != nil {
+			return fs.errnoOf(err)
+		}
+		if len(fis) > 0 {
+			return unix.ENOTEMPTY
+		}
+	}
+	if err := fs.c.delete(p); err != nil {
+		return fs.errnoOf(err)
+	}
+	fs.removed(p)
+	return 0
+}
+
+func (fs *FS) rename(hdr *inHeader, newDir uint64, flags uint32, body []byte) unix.Errno {
+	if flags&renameExchange != 0 {
+		return unix.EINVAL
+	}
+	from, rest, errno := fs.childPath(hdr.NodeID, body)
+	if errno != 0 {
+		return errno
+	}
+	to, _, errno := fs.childPath(newDir, rest)
+	if errno != 0 {
+		return errno
+	}
+	if err := fs.c.move(from, to, flags&renameNoReplace == 0); err != nil {
+		var se *statusError
+		if errors.As(err, &se) && (se.code == http.StatusBadRequest || se.code == http.StatusBadGateway) {
+			// Moves across shares aren't supported, so make the caller
+			// copy instead.
+			return unix.EXDEV
+		}
+		return fs.errnoOf(err)
+	}
+	fs.renamed(from, to)
+	return 0
+}
+
+// handle is an open file or directory.
+type handle struct {
+	fs   *FS
+	node uint64
+
+	mu sync.Mutex
+
+	// entries is the contents of an open directory.
+	entries []dirEntry
+
+	// rbody is the body of the GET we're reading from, which is at offset
+	// roff. It's nil if there's none.
+	rbody io.ReadCloser
+	roff  int64
+
+	// stream is whether writes are streamed to the server, which is the case
+	// for files that were created or truncated when opened, until the
+	// first flush. upload is the upload in progress, if any.
+	stream bool
+	upload *upload
+
+	// spool is the local copy of the file that other writes are made to,
+	// once it's been written to. dirty is whether it has changes that
+	// haven't been uploaded.
+	spool *os.File
+	dirty bool
+}
+
+type dirEntry struct {
+	name  string
+	isDir bool
+}
+
+// upload is a PUT whose body is being streamed from the writes to a file.
+type upload struct {
+	path string
+	pw   *io.PipeWriter
+	n    int64      // bytes written so far
+	done chan error // receives the result of the PUT
+}
+
+func (fs *FS) startUpload(p string) *upload {
+	pr, pw := io.Pipe()
+	u := &upload{path: p, pw: pw, done: make(chan error, 1)}
+	fs.setPending(p, 0)
+	go func() {
+		err := fs.c.put(p, pr, -1)
+		pr.CloseWithError(errUploadEnded)
+		u.done <- err
+	}()
+	return u
+}
+
+func (fs *FS) handle(fh uint64) *handle {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.handles[fh]
+}
+
+func (fs *FS) addHandle(h *handle) uint64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.nextFh++
+	fs.handles[fs.nextFh] = h
+	return fs.nextFh
+}
+
+func (fs *FS) release(fh uint64) {
+	fs.mu.Lock()
+	h := fs.handles[fh]
+	delete(fs.handles, fh)
+	fs.mu.Unlock()
+	if h != nil {
+		h.release()
+	}
+}
+
+func (fs *FS) open(hdr *inHeader, body []byte) (any, unix.Errno) {
+	var in openIn
+	if !decode(body, &in) {
+		return nil, unix.EINVAL
+	}
+	p, ok := fs.pathOf(hdr.NodeID)
+	if !ok {
+		return nil, unix.ESTALE
+	}
+	h := &handle{fs: fs, node: hdr.NodeID}
+	if hdr.Opcode == opOpendir {
+		fis, err := fs.c.readDir(p)
+		if err != nil {
+			return nil, fs.errnoOf(err)
+		}
+		h.entries = []dirEntry{{".", true}, {"..", true}}
+		for _, fi := range fis {
+			h.entries = append(h.entries, dirEntry{fi.name, fi.isDir})
+		}
+		slices.SortFunc(h.entries[2:], func(a, b dirEntry) int {
+			return strings.Compare(a.name, b.name)
+		})
+	} else if in.Flags&unix.O_ACCMODE != unix.O_RDONLY && in.Flags&unix.O_TRUNC != 0 {
+		h.stream = true
+		h.upload = fs.startUpload(p)
+	}
+	return openOut{Fh: fs.addHandle(h)}, 0
+}
+
+func (fs *FS) create(hdr *inHeader, body []byte) (any, unix.Errno) {
+	var in createIn
+	if !decode(body, &in) {
+		return nil, unix.EINVAL
+	}
+	p, _, errno := fs.childPath(hdr.NodeID, body[16:])
+	if errno != 0 {
+		return nil, errno
+	}
+	if in.Flags&unix.O_EXCL != 0 {
+		if _, err := fs.stat(p); err == nil {
+			return nil, unix.EEXIST
+		}
+	}
+	entry := fs.entry(p, &fileInfo{name: path.Base(p), modTime: time.Now()})
+	h := &handle{fs: fs, node: entry.NodeID, stream: true, upload: fs.startUpload(p)}
+	return struct {
+		Entry entryOut
+		Open  openOut
+	}{entry, openOut{Fh: fs.addHandle(h)}}, 0
+}
+
+func (fs *FS) read(body []byte) (any, unix.Errno) {
+	var in readIn
+	if !decode(body, &in) {
+		return nil, unix.EINVAL
+	}
+	h := fs.handle(in.Fh)
+	if h == nil {
+		return nil, unix.EBADF
+	}
+	return h.read(int64(in.Offset), int(in.Size))
+}
+
+func (fs *FS) write(body []byte) (any, unix.Errno) {
+	var in writeIn
+	if !decode(body, &in) || len(body) < writeInSize+int(in.Size) {
+		return nil, unix.EINVAL
+	}
+	h := fs.handle(in.Fh)
+	if h == nil {
+		return nil, unix.EBADF
+	}
+	n, errno := h.write(int64(in.Offset), body[writeInSize:writeInSize+int(in.Size)])
+	if errno != 0 {
+		return nil, errno
+	}
+	return writeOut{Size: uint32(n)}, 0
+}
+
+func (fs *FS) readdir(body []byte) (any, unix.Errno) {
+	var in readIn
+	if !decode(body, &in) {
+		return nil, unix.EINVAL
+	}
+	h := fs.handle(in.Fh)
+	if h == nil {
+		return nil, unix.EBADF
+	}
+	dir, ok := fs.pathOf(h.node)
+	if !ok {
+		return nil, unix.ESTALE
+	}
+
+	var out []byte
+	for i := int(in.Offset); i < len(h.entries); i++ {
+		e := h.entries[i]
+		size := (direntHeaderSize + len(e.name) + 7) &^ 7
+		if len(out)+size > int(in.Size) {
+			break
+		}
+		typ := uint32(unix.DT_REG)
+		if e.isDir {
+			typ = unix.DT_DIR
+		}
+		out = binary.NativeEndian.AppendUint64(out, fs.ino(path.Join(dir, e.name)))
+		out = binary.NativeEndian.AppendUint64(out, uint64(i+1))
+		out = binary.NativeEndian.AppendUint32(out, uint32(len(e.name)))
+		out = binary.NativeEndian.AppendUint32(out, typ)
+		out = append(out, e.name...)
+		out = append(out, make([]byte, size-direntHeaderSize-len(e.name))...)
+	}
+	return out, 0
+}
+
+// ino returns the inode number to report for the file at p in a directory
+// listing. That's its node ID if the kernel knows about it, or else a hash
+// of its path.
+func (fs *FS) ino(p string) uint64 {
+	fs.mu.Lock()
+	id, ok := fs.byPath[p]
+	fs.mu.Unlock()
+	if ok {
+		return id
+	}
+	h := fnv.New64a()
+	io.WriteString(h, p)
+	return h.Sum64() | 1<<63
+}
+
+func (h *handle) read(off int64, size int) (any, unix.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buf := make([]byte, size)
+	if h.spool != nil {
+		n, err := h.spool.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return nil, h.fs.errnoOf(err)
+		}
+		return buf[:n], 0
+	}
+
+	if h.rbody == nil || off != h.roff {
+		h.closeReaderLocked()
+		p, ok := h.fs.pathOf(h.node)
+		if !ok {
+			return nil, unix.ESTALE
+		}
+		rc, err := h.fs.c.get(p, off)
+		if err == io.EOF {
+			return []byte{}, 0
+		}
+		if err != nil {
+			return nil, h.fs.errnoOf(err)
+		}
+		h.rbody, h.roff = rc, off
+	}
+	n, err := io.ReadFull(h.rbody, buf)
+	h.roff += int64(n)
+	if err != nil {
+		h.closeReaderLocked()
+		if err != io.EOF && err != io.ErrUnexpectedEOF && n == 0 {
+			return nil, h.fs.errnoOf(err)
+		}
+	}
+	return buf[:n], 0
+}
+
+func (h *handle) write(off int64, data []byte) (int, unix.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.fs.pathOf(h.node)
+	if !ok {
+		return 0, unix.ESTALE
+	}
+	if h.stream {
+		u := h.upload
+		if u == nil || off != u.n {
+			h.fs.logf("[v1] fusedav: unsupported non-sequential write to %s", p)
+			return 0, unix.EOPNOTSUPP
+		}
+		n, err := u.pw.Write(data)
+		u.n += int64(n)
+		h.fs.setPending(u.path, u.n)
+		if err != nil {
+			if errno := h.finishUploadLocked(); errno != 0 {
+				return 0, errno
+			}
+			return 0, unix.EIO
+		}
+		return n, 0
+	}
+
+	if errno := h.loadSpoolLocked(p, true); errno != 0 {
+		return 0, errno
+	}
+	n, err := h.spool.WriteAt(data, off)
+	if err != nil {
+		return 0, h.fs.errnoOf(err)
+	}
+	h.dirty = true
+	h.setSpoolPendingLocked(p)
+	return n, 0
+}
+
+// truncate changes the size of the file at p, which is open in h, to size.
+func (h *handle) truncate(p string, size int64) unix.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stream {
+		if h.upload != nil && h.upload.n == size {
+			return 0
+		}
+		return unix.EOPNOTSUPP
+	}
+	if errno := h.loadSpoolLocked(p, size > 0); errno != 0 {
+		return errno
+	}
+	if err := h.spool.Truncate(size); err != nil {
+		return h.fs.errnoOf(err)
+	}
+	h.dirty = true
+	h.setSpoolPendingLocked(p)
+	return 0
+}
+
+// loadSpoolLocked creates the local copy of the file at p to write to, if
+// it doesn't exist yet. If keep is false, the copy starts out empty.
+func (h *handle) loadSpoolLocked(p string, keep bool) unix.Errno {
+	if h.spool != nil {
+		return 0
+	}
+	f, err := os.CreateTemp("", "taildrive-fuse-")
+	if err != nil {
+		return h.fs.errnoOf(err)
+	}
+	os.Remove(f.Name())
+	if keep {
+		rc, err := h.fs.c.get(p, 0)
+		if err == nil {
+			_, err = io.Copy(f, rc)
+			rc.Close()
+		}
+		if err != nil && err != io.EOF {
+			f.Close()
+			return h.fs.errnoOf(err)
+		}
+	}
+	h.closeReaderLocked()
+	h.spool = f
+	return 0
+}
+
+func (h *handle) setSpoolPendingLocked(p string) {
+	if fi, err := h.spool.Stat(); err == nil {
+		h.fs.setPending(p, fi.Size())
+	}
+}
+
+// finishUploadLocked completes the upload in progress, if any, returning
+// the errno to report for it.
+func (h *handle) finishUploadLocked() unix.Errno {
+	u := h.upload
+	if u == nil {
+		return 0
+	}
+	h.upload = nil
+	u.pw.Close()
+	err := <-u.done
+	h.fs.clearPending(u.path)
+	if err != nil {
+		return h.fs.errnoOf(err)
+	}
+	return 0
+}
+
+// flush uploads any changes made through h. It's called each time a file
+// descriptor referring to h is closed.
+func (h *handle) flush() unix.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stream {
+		// The file is complete as far as the server is concerned, so any
+		// further writes, as when a descriptor was duplicated before the
+		// original was closed, have to go through a local copy.
+		h.stream = false
+		return h.finishUploadLocked()
+	}
+	return h.uploadSpoolLocked()
+}
+
+// sync uploads any changes made to the local copy of the file. Streamed
+// writes can't be synced before the file is closed.
+func (h *handle) sync() unix.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.uploadSpoolLocked()
+}
+
+func (h *handle) uploadSpoolLocked() unix.Errno {
+	if h.spool == nil || !h.dirty {
+		return 0
+	}
+	p, ok := h.fs.pathOf(h.node)
+	if !ok {
+		return unix.ESTALE
+	}
+	fi, err := h.spool.Stat()
+	if err != nil {
+		return h.fs.errnoOf(err)
+	}
+	err = h.fs.c.put(p, io.NewSectionReader(h.spool, 0, fi.Size()), fi.Size())
+	h.fs.clearPending(p)
+	if err != nil {
+		return h.fs.errnoOf(err)
+	}
+	h.dirty = false
+	return 0
+}
+
+// release uploads any remaining changes made through h and releases its
+// resources.
+func (h *handle) release() {
+	h.flush()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closeLocked()
+}
+
+func (h *handle) closeLocked() {
+	h.closeReaderLocked()
+	if h.spool != nil {
+		h.spool.Close()
+		h.spool = nil
+	}
+}
+
+func (h *handle) closeReaderLocked() {
+	if h.rbody != nil {
+		h.rbody.Close()
+		h.rbody = nil
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package fusedav
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tailscale/xnet/webdav"
+	"golang.org/x/sys/unix"
+)
+
+func TestMount(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skipf("FUSE not available: %v", err)
+	}
+
+	remote := t.TempDir()
+	srv := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.Dir(remote),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer srv.Close()
+
+	dir := t.TempDir()
+	fs, err := Mount(dir, Config{Logf: t.Logf, BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := fs.Close(); err != nil {
+			t.Error(err)
+		}
+		select {
+		case <-fs.done:
+		case <-time.After(10 * time.Second):
+			t.Error("timed out waiting for unmount")
+		}
+	}()
+
+	// Files in the mount are read and written by a separate process, since
+	// the runtime registers files it opens with its poller, which asks the
+	// FUSE server that's serving them from this process.
+	sh := func(script string) string {
+		t.Helper()
+		cmd := exec.Command("sh", "-c", script)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", script, err, out)
+		}
+		return string(out)
+	}
+	readRemote := func(name string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(remote, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// Creating a file streams it to the server.
+	sh("printf hello > a.txt")
+	if got := readRemote("a.txt"); got != "hello" {
+		t.Errorf("remote a.txt = %q; want %q", got, "hello")
+	}
+
+	// Appending goes through a local copy of the file.
+	sh("printf ', world' >> a.txt")
+	if got := readRemote("a.txt"); got != "hello, world" {
+		t.Errorf("remote a.txt = %q; want %q", got, "hello, world")
+	}
+
+	// Reads, including from an offset.
+	if got := sh("dd if=a.txt bs=1 skip=7 status=none"); got != "world" {
+		t.Errorf("read from offset 7 = %q; want %q", got, "world")
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "a.txt"), filepath.Join(dir, "sub", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRemote("sub/b.txt"); got != "hello, world" {
+		t.Errorf("remote sub/b.txt = %q; want %q", got, "hello, world")
+	}
+	des, err := os.ReadDir(filepath.Join(dir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, de := range des {
+		names = append(names, de.Name())
+	}
+	if !slices.Equal(names, []string{"b.txt"}) {
+		t.Errorf("ReadDir(sub) = %q; want [b.txt]", names)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "sub", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != int64(len("hello, world")) || fi.Mode().Perm() != 0o600 {
+		t.Errorf("Stat(sub/b.txt) = size %d, mode %v", fi.Size(), fi.Mode())
+	}
+
+	if err := os.Remove(filepath.Join(dir, "sub")); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("removing non-empty directory: %v; want ENOTEMPTY", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "sub", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "sub")); !os.IsNotExist(err) {
+		t.Errorf("remote sub still exists: %v", err)
+	}
+}
+
+func TestOpenMountDir(t *testing.T) {
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "dir")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "file"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(tmp, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(tmp, filepath.Join(dir, "parent-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		dir     string
+		wantErr bool
+	}{
+		{"dir", dir, false},
+		{"unclean", dir + "/./", false},
+		{"relative", "dir", true},
+		{"file", filepath.Join(tmp, "file"), true},
+		{"symlink", filepath.Join(tmp, "link"), true},
+		{"symlink-parent", filepath.Join(dir, "parent-link", "dir"), true},
+		{"missing", filepath.Join(tmp, "missing"), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fd, err := openMountDir(tt.dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openMountDir(%q) error = %v, want error: %v", tt.dir, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer syscall.Close(fd)
+			var got, want syscall.Stat_t
+			if err := syscall.Fstat(fd, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := syscall.Stat(dir, &want); err != nil {
+				t.Fatal(err)
+			}
+			if got.Ino != want.Ino || got.Dev != want.Dev {
+				t.Errorf("openMountDir(%q) opened the wrong directory", tt.dir)
+			}
+		})
+	}
+}
+
+func TestCloseAfterMove(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skipf("FUSE not available: %v", err)
+	}
+
+	srv := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.Dir(t.TempDir()),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer srv.Close()
+
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "a", "mnt")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := Mount(dir, Config{Logf: t.Logf, BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fs.Mounted() {
+		t.Fatal("not mounted after Mount")
+	}
+
+	// Move the mount out from under the directory it was mounted at, and
+	// put a symlink to somewhere else in its place.
+	other := filepath.Join(tmp, "other")
+	if err := os.MkdirAll(filepath.Join(other, "mnt"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(tmp, "a"), filepath.Join(tmp, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(other, filepath.Join(tmp, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fs.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for unmount")
+	}
+	if fs.Mounted() {
+		t.Error("still mounted after Close")
+	}
+	if err := fs.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestFindMountIn(t *testing.T) {
+	const mountinfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+95 22 0:52 / /home/a\040b/mnt rw,nosuid,nodev,relatime shared:50 - fuse.taildrive taildrive-abc rw,user_id=1000,group_id=1000
+96 22 0:53 / /tmp/x rw,relatime - fuse.taildrive taildrive-def rw,user_id=0,group_id=0
+`
+	dir, dev, ok, err := findMountIn(strings.NewReader(mountinfo), "taildrive-abc")
+	if err != nil || !ok {
+		t.Fatalf("findMountIn = %v, %v", ok, err)
+	}
+	if dir != "/home/a b/mnt" || dev != unix.Mkdev(0, 52) {
+		t.Errorf("findMountIn = %q, %v; want %q, %v", dir, dev, "/home/a b/mnt", unix.Mkdev(0, 52))
+	}
+	if _, _, ok, err := findMountIn(strings.NewReader(mountinfo), "taildrive-xyz"); ok || err != nil {
+		t.Errorf("findMountIn of missing mount = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package fusedav
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// FS is a mounted filesystem.
+type FS struct{}
+
+// Mount is not supported on this platform.
+func Mount(dir string, cfg Config) (*FS, error) {
+	return nil, fmt.Errorf("FUSE mounts not supported on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
+
+// Close unmounts fs.
+func (fs *FS) Close() error {
+	return nil
+}
+
+// Mounted reports whether fs is still mounted.
+func (fs *FS) Mounted() bool {
+	return false
+}
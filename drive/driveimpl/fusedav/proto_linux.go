@@ -0,0 +1,275 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package fusedav
+
+// This file contains the subset of the Linux FUSE kernel protocol (see
+// include/uapi/linux/fuse.h) that we implement.
+
+const (
+	protoMajor = 7
+	protoMinor = 31
+
+	rootID = 1 // FUSE_ROOT_ID
+)
+
+// Opcodes.
+const (
+	opLookup      = 1
+	opForget      = 2
+	opGetattr     = 3
+	opSetattr     = 4
+	opMkdir       = 9
+	opUnlink      = 10
+	opRmdir       = 11
+	opRename      = 12
+	opOpen        = 14
+	opRead        = 15
+	opWrite       = 16
+	opStatfs      = 17
+	opRelease     = 18
+	opFsync       = 20
+	opFlush       = 25
+	opInit        = 26
+	opOpendir     = 27
+	opReaddir     = 28
+	opReleasedir  = 29
+	opFsyncdir    = 30
+	opAccess      = 34
+	opCreate      = 35
+	opInterrupt   = 36
+	opDestroy     = 38
+	opBatchForget = 42
+	opRename2     = 45
+)
+
+// Flags for initIn and initOut.
+const (
+	initAtomicOTrunc = 1 << 3
+	initBigWrites    = 1 << 5
+)
+
+// Bits of setattrIn.Valid.
+const (
+	fattrSize = 1 << 3
+)
+
+// Flags for renameIn2.
+const (
+	renameNoReplace = 1 << 0
+	renameExchange  = 1 << 1
+)
+
+type inHeader struct {
+	Len         uint32
+	Opcode      uint32
+	Unique      uint64
+	NodeID      uint64
+	UID         uint32
+	GID         uint32
+	PID         uint32
+	TotalExtlen uint16
+	Padding     uint16
+}
+
+const inHeaderSize = 40
+
+type outHeader struct {
+	Len    uint32
+	Error  int32
+	Unique uint64
+}
+
+const outHeaderSize = 16
+
+type initIn struct {
+	Major        uint32
+	Minor        uint32
+	MaxReadahead uint32
+	Flags        uint32
+}
+
+type initOut struct {
+	Major               uint32
+	Minor               uint32
+	MaxReadahead        uint32
+	Flags               uint32
+	MaxBackground       uint16
+	CongestionThreshold uint16
+	MaxWrite            uint32
+	TimeGran            uint32
+	MaxPages            uint16
+	MapAlignment        uint16
+	Flags2              uint32
+	Unused              [7]uint32
+}
+
+// initOutCompat22Size is the size of initOut understood by kernels that
+// speak protocol versions before 7.23.
+const initOutCompat22Size = 24
+
+type attr struct {
+	Ino       uint64
+	Size      uint64
+	Blocks    uint64
+	Atime     uint64
+	Mtime     uint64
+	Ctime     uint64
+	AtimeNsec uint32
+	MtimeNsec uint32
+	CtimeNsec uint32
+	Mode      uint32
+	Nlink     uint32
+	UID       uint32
+	GID       uint32
+	Rdev      uint32
+	Blksize   uint32
+	Flags     uint32
+}
+
+type entryOut struct {
+	NodeID         uint64
+	Generation     uint64
+	EntryValid     uint64
+	AttrValid      uint64
+	EntryValidNsec uint32
+	AttrValidNsec  uint32
+	Attr           attr
+}
+
+type attrOut struct {
+	AttrValid     uint64
+	AttrValidNsec uint32
+	Dummy         uint32
+	Attr          attr
+}
+
+type forgetIn struct {
+	Nlookup uint64
+}
+
+type batchForgetIn struct {
+	Count uint32
+	Dummy uint32
+}
+
+type forgetOne struct {
+	NodeID  uint64
+	Nlookup uint64
+}
+
+type setattrIn struct {
+	Valid     uint32
+	Padding   uint32
+	Fh        uint64
+	Size      uint64
+	LockOwner uint64
+	Atime     uint64
+	Mtime     uint64
+	Ctime     uint64
+	AtimeNsec uint32
+	MtimeNsec uint32
+	CtimeNsec uint32
+	Mode      uint32
+	Unused4   uint32
+	UID       uint32
+	GID       uint32
+	Unused5   uint32
+}
+
+type mkdirIn struct {
+	Mode  uint32
+	Umask uint32
+}
+
+type renameIn struct {
+	Newdir uint64
+}
+
+type rename2In struct {
+	Newdir  uint64
+	Flags   uint32
+	Padding uint32
+}
+
+type openIn struct {
+	Flags     uint32
+	OpenFlags uint32
+}
+
+type createIn struct {
+	Flags     uint32
+	Mode      uint32
+	Umask     uint32
+	OpenFlags uint32
+}
+
+type openOut struct {
+	Fh        uint64
+	OpenFlags uint32
+	Padding   uint32
+}
+
+type releaseIn struct {
+	Fh           uint64
+	Flags        uint32
+	ReleaseFlags uint32
+	LockOwner    uint64
+}
+
+type flushIn struct {
+	Fh        uint64
+	Unused    uint32
+	Padding   uint32
+	LockOwner uint64
+}
+
+type fsyncIn struct {
+	Fh         uint64
+	FsyncFlags uint32
+	Padding    uint32
+}
+
+type readIn struct {
+	Fh        uint64
+	Offset    uint64
+	Size      uint32
+	ReadFlags uint32
+	LockOwner uint64
+	Flags     uint32
+	Padding   uint32
+}
+
+type writeIn struct {
+	Fh         uint64
+	Offset     uint64
+	Size       uint32
+	WriteFlags uint32
+	LockOwner  uint64
+	Flags      uint32
+	Padding    uint32
+}
+
+const writeInSize = 40
+
+type writeOut struct {
+	Size    uint32
+	Padding uint32
+}
+
+type statfsOut struct {
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Bsize   uint32
+	Namelen uint32
+	Frsize  uint32
+	Padding uint32
+	Spare   [6]uint32
+}
+
+// direntHeaderSize is the size of a struct fuse_dirent, not counting the
+// name that follows it.
+const direntHeaderSize = 24
@@ -5,14 +5,19 @@
 package driveimpl
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 
 	"tailscale.com/drive"
 	"tailscale.com/drive/driveimpl/compositedav"
 	"tailscale.com/drive/driveimpl/dirfs"
+	"tailscale.com/drive/driveimpl/fusedav"
 	"tailscale.com/types/logger"
 )
 
@@ -21,6 +26,8 @@
 	// avoid excessive network roundtrips. This is similar to the
 	// DirectoryCacheLifetime setting of Windows' built-in SMB client,
 	// see https://learn.microsoft.com/en-us/previous-versions/windows/it-pro/windows-7/ff686200(v=ws.10)
+	// FUSE mounts, whose metadata comes from the proxy, cache it in the
+	// kernel for as long.
 	statCacheTTL = 10 * time.Second
 )
 
@@ -52,6 +59,14 @@ type FileSystemForLocal struct {
 	logf     logger.Logf
 	h        *compositedav.Handler
 	listener *connListener
+
+	mountsMu sync.Mutex
+	mounts   map[string]*mount // by directory
+}
+
+type mount struct {
+	fs  *fusedav.FS
+	uid int
 }
 
 func (s *FileSystemForLocal) startServing() {
@@ -89,8 +104,100 @@ func (s *FileSystemForLocal) SetRemotes(domain string, remotes []*drive.Remote,
 	s.h.SetChildren(domain, children...)
 }
 
+// Mount mounts the unified view of remote shares at the local directory dir
+// using FUSE, with all files owned by the given user and group.
+func (s *FileSystemForLocal) Mount(dir string, uid, gid int) error {
+	s.mountsMu.Lock()
+	defer s.mountsMu.Unlock()
+	s.pruneMountsLocked()
+	if _, ok := s.mounts[dir]; ok {
+		return fmt.Errorf("%s is already mounted", dir)
+	}
+	fs, err := fusedav.Mount(dir, fusedav.Config{
+		Logf: s.logf,
+		Transport: &http.Transport{
+			// Serve requests in-process, as if they came from a local
+			// WebDAV client.
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				client, server := net.Pipe()
+				if err := s.HandleConn(server, server.RemoteAddr()); err != nil {
+					client.Close()
+					return nil, err
+				}
+				return client, nil
+			},
+		},
+		BaseURL: "http://taildrive",
+		UID:     uint32(uid),
+		GID:     uint32(gid),
+		AttrTTL: statCacheTTL,
+	})
+	if err != nil {
+		return err
+	}
+	if s.mounts == nil {
+		s.mounts = make(map[string]*mount)
+	}
+	s.mounts[dir] = &mount{fs: fs, uid: uid}
+	return nil
+}
+
+// Unmount unmounts the directory dir, previously mounted with Mount, on
+// behalf of the user uid. Only the user that the mount belongs to, or root,
+// may unmount it.
+func (s *FileSystemForLocal) Unmount(dir string, uid int) error {
+	s.mountsMu.Lock()
+	defer s.mountsMu.Unlock()
+	s.pruneMountsLocked()
+	m, ok := s.mounts[dir]
+	if !ok {
+		return drive.ErrNotMounted
+	}
+	if uid != 0 && uid != m.uid {
+		return drive.ErrNotMountOwner
+	}
+	if err := m.fs.Close(); err != nil {
+		return err
+	}
+	delete(s.mounts, dir)
+	return nil
+}
+
+// Mounts returns the directories mounted with Mount, sorted.
+func (s *FileSystemForLocal) Mounts() []string {
+	s.mountsMu.Lock()
+	defer s.mountsMu.Unlock()
+	s.pruneMountsLocked()
+	dirs := make([]string, 0, len(s.mounts))
+	for dir := range s.mounts {
+		dirs = append(dirs, dir)
+	}
+	slices.Sort(dirs)
+	return dirs
+}
+
+// pruneMountsLocked forgets the mounts that were unmounted by other means
+// than Unmount.
+func (s *FileSystemForLocal) pruneMountsLocked() {
+	for dir, m := range s.mounts {
+		if !m.fs.Mounted() {
+			s.logf("taildrive: %s was unmounted", dir)
+			delete(s.mounts, dir)
+		}
+	}
+}
+
 // Close() stops serving the WebDAV content
 func (s *FileSystemForLocal) Close() error {
+	s.mountsMu.Lock()
+	for dir, m := range s.mounts {
+		if err := m.fs.Close(); err != nil {
+			s.logf("taildrive: %v", err)
+		}
+		delete(s.mounts, dir)
+	}
+	s.mountsMu.Unlock()
+
 	err := s.listener.Close()
 	s.h.Close()
 	return err
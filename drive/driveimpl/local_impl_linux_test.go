@@ -0,0 +1,51 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+
+	"tailscale.com/drive"
+)
+
+func TestMountUnmountedByOtherMeans(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skipf("FUSE not available: %v", err)
+	}
+
+	fs := newFileSystemForLocal(t.Logf, nil)
+	defer fs.Close()
+	dir := t.TempDir()
+	if err := fs.Mount(dir, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Mounts(); !slices.Equal(got, []string{dir}) {
+		t.Fatalf("Mounts = %q; want [%q]", got, dir)
+	}
+
+	if err := syscall.Unmount(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Mounts(); len(got) != 0 {
+		t.Errorf("Mounts after unmount = %q; want none", got)
+	}
+	if err := fs.Unmount(dir, 0); !errors.Is(err, drive.ErrNotMounted) {
+		t.Errorf("Unmount after unmount = %v; want ErrNotMounted", err)
+	}
+
+	// The directory can be mounted again.
+	if err := fs.Mount(dir, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Unmount(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+}
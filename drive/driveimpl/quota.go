@@ -0,0 +1,154 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"tailscale.com/drive"
+	"tailscale.com/drive/driveimpl/shared"
+	"tailscale.com/types/logger"
+)
+
+// usageCacheTTL is how long the computed disk usage of a share is trusted
+// before it's computed again.
+const usageCacheTTL = 30 * time.Second
+
+var errQuotaExceeded = errors.New("share quota exceeded")
+
+// shareUsage tracks the disk usage of a share with a quota.
+type shareUsage struct {
+	mu    sync.Mutex
+	bytes int64
+	at    time.Time // when bytes was computed; zero if unknown
+}
+
+// get returns the total size of the regular files under root, computing it
+// if the cached value is missing or too old.
+func (u *shareUsage) get(root string) (int64, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.at.IsZero() && time.Since(u.at) < usageCacheTTL {
+		return u.bytes, nil
+	}
+	var total int64
+	err := filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	u.bytes, u.at = total, time.Now()
+	return total, nil
+}
+
+// add adjusts the cached usage by delta bytes.
+func (u *shareUsage) add(delta int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bytes += delta
+}
+
+// invalidate forces the usage to be computed again on the next call to get.
+func (u *shareUsage) invalidate() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.at = time.Time{}
+}
+
+// quotaReader wraps a request body, failing once more than limit bytes have
+// been read from it.
+type quotaReader struct {
+	io.ReadCloser
+	limit int64
+	n     int64
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	if r.n > r.limit {
+		return n, errQuotaExceeded
+	}
+	return n, err
+}
+
+// localPath returns the local path of the file at the WebDAV path p, which
+// starts with the name of share.
+func localPath(share *drive.Share, p string) string {
+	parts := shared.CleanAndSplit(p)
+	return filepath.Join(share.Path, filepath.FromSlash(path.Join(parts[1:]...)))
+}
+
+// enforceQuota checks the write request r against the quota of share, whose
+// usage is tracked in u. If r may not proceed, it writes an error to w and
+// returns ok false. Otherwise, the returned done func must be called once r
+// has been served. The body of r may be wrapped so that uploads of unknown
+// length fail once they'd exceed the quota.
+func enforceQuota(logf logger.Logf, share *drive.Share, u *shareUsage, w http.ResponseWriter, r *http.Request) (done func(), ok bool) {
+	switch r.Method {
+	case "PUT", "MKCOL", "COPY":
+	case "DELETE", "MOVE":
+		// These never use up more space, but change what's there.
+		return u.invalidate, true
+	default:
+		return func() {}, true
+	}
+
+	used, err := u.get(share.Path)
+	if err != nil {
+		logf("taildrive: can't determine usage of share %q: %v", share.Name, err)
+		http.Error(w, "unable to enforce share quota", http.StatusInsufficientStorage)
+		return nil, false
+	}
+	remaining := share.QuotaBytes - used
+
+	if r.Method != "PUT" {
+		// We can't cheaply tell how much a COPY will use, so only let it,
+		// and MKCOL, proceed while there's space left.
+		if remaining <= 0 {
+			http.Error(w, errQuotaExceeded.Error(), http.StatusInsufficientStorage)
+			return nil, false
+		}
+		return u.invalidate, true
+	}
+
+	// Overwriting a file frees up the space it used.
+	var oldSize int64
+	if fi, err := os.Stat(localPath(share, r.URL.Path)); err == nil && fi.Mode().IsRegular() {
+		oldSize = fi.Size()
+	}
+	remaining += oldSize
+	if r.ContentLength > remaining {
+		http.Error(w, errQuotaExceeded.Error(), http.StatusInsufficientStorage)
+		return nil, false
+	}
+	qr := &quotaReader{ReadCloser: r.Body, limit: remaining}
+	r.Body = qr
+	return func() {
+		if qr.n > qr.limit {
+			u.invalidate()
+			return
+		}
+		u.add(qr.n - oldSize)
+	}, true
+}
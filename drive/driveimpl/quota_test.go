@@ -0,0 +1,58 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/drive"
+)
+
+func TestEnforceQuota(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "existing"), make([]byte, 60), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	share := &drive.Share{Name: "share", Path: dir, QuotaBytes: 100}
+	u := &shareUsage{}
+
+	serve := func(method, p, body string, contentLength int64) (status int, readErr error) {
+		r := httptest.NewRequest(method, p, strings.NewReader(body))
+		r.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		done, ok := enforceQuota(t.Logf, share, u, w, r)
+		if !ok {
+			return w.Code, nil
+		}
+		defer done()
+		_, readErr = io.ReadAll(r.Body)
+		return http.StatusOK, readErr
+	}
+
+	if status, _ := serve("PUT", "/share/new", "", 41); status != http.StatusInsufficientStorage {
+		t.Errorf("PUT over quota: status %d; want %d", status, http.StatusInsufficientStorage)
+	}
+	if status, _ := serve("PUT", "/share/existing", strings.Repeat("x", 60), 100); status != http.StatusOK {
+		t.Errorf("PUT overwriting existing file: status %d; want %d", status, http.StatusOK)
+	}
+	if _, err := serve("PUT", "/share/new", strings.Repeat("x", 41), -1); err != errQuotaExceeded {
+		t.Errorf("PUT of unknown length over quota: read error %v; want %v", err, errQuotaExceeded)
+	}
+	if status, err := serve("PUT", "/share/new", strings.Repeat("x", 40), -1); status != http.StatusOK || err != nil {
+		t.Errorf("PUT within quota: status %d, read error %v", status, err)
+	}
+	// The usage is now 100 bytes, so nothing more fits.
+	if status, _ := serve("MKCOL", "/share/dir", "", 0); status != http.StatusInsufficientStorage {
+		t.Errorf("MKCOL when full: status %d; want %d", status, http.StatusInsufficientStorage)
+	}
+	if status, _ := serve("GET", "/share/existing", "", 0); status != http.StatusOK {
+		t.Errorf("GET when full: status %d; want %d", status, http.StatusOK)
+	}
+}
@@ -57,6 +57,7 @@ type FileSystemForRemote struct {
 	shares                 []*drive.Share
 	children               map[string]*compositedav.Child
 	userServers            map[string]*userServer
+	usage                  map[string]*shareUsage // by share name, for shares with quotas
 }
 
 // SetFileServerAddr implements drive.FileSystemForRemote.
@@ -97,12 +98,17 @@ func (s *FileSystemForRemote) SetShares(shares []*drive.Share) {
 	}
 
 	children := make(map[string]*compositedav.Child, len(shares))
+	usage := make(map[string]*shareUsage)
 	for _, share := range shares {
 		children[share.Name] = s.buildChild(share)
+		if share.QuotaBytes > 0 {
+			usage[share.Name] = new(shareUsage)
+		}
 	}
 
 	s.mu.Lock()
 	s.shares = shares
+	s.usage = usage
 	oldUserServers := s.userServers
 	oldChildren := s.children
 	s.children = children
@@ -213,6 +219,29 @@ func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions drive.Permissions,
 			http.Error(w, "permission denied", http.StatusForbidden)
 			return
 		}
+
+		s.mu.RLock()
+		i, found := slices.BinarySearchFunc(s.shares, share, func(s *drive.Share, name string) int {
+			return strings.Compare(s.Name, name)
+		})
+		var sh *drive.Share
+		if found {
+			sh = s.shares[i]
+		}
+		usage := s.usage[share]
+		s.mu.RUnlock()
+
+		if sh != nil && sh.IsReadOnly() {
+			http.Error(w, "share is read-only", http.StatusForbidden)
+			return
+		}
+		if usage != nil {
+			done, ok := enforceQuota(s.logf, sh, usage, w, r)
+			if !ok {
+				return
+			}
+			defer done()
+		}
 	}
 
 	s.mu.RLock()
@@ -10,10 +10,20 @@
 package drive
 
 import (
+	"errors"
 	"net"
 	"net/http"
 )
 
+var (
+	// ErrNotMounted is returned when unmounting a directory that isn't
+	// mounted.
+	ErrNotMounted = errors.New("not mounted")
+	// ErrNotMountOwner is returned when unmounting a directory that's
+	// mounted for another user.
+	ErrNotMountOwner = errors.New("mounted by another user")
+)
+
 // Remote represents a remote Taildrive node.
 type Remote struct {
 	Name      string
@@ -32,6 +42,20 @@ type FileSystemForLocal interface {
 	// will be used to connect to these remotes.
 	SetRemotes(domain string, remotes []*Remote, transport http.RoundTripper)
 
+	// Mount mounts the unified view of remote shares at the local directory
+	// dir using FUSE, with all files owned by the given user and group. It
+	// returns an error wrapping errors.ErrUnsupported on platforms other
+	// than Linux.
+	Mount(dir string, uid, gid int) error
+
+	// Unmount unmounts the directory dir, previously mounted with Mount, on
+	// behalf of the user uid. Only the user that the mount belongs to, or
+	// root, may unmount it. It returns ErrNotMounted if dir isn't mounted.
+	Unmount(dir string, uid int) error
+
+	// Mounts returns the directories mounted with Mount, sorted.
+	Mounts() []string
+
 	// Close() stops serving the WebDAV content
 	Close() error
 }
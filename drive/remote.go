@@ -10,6 +10,7 @@
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 )
 
 var (
@@ -46,6 +47,28 @@ type Share struct {
 	// hold on to a security-scoped bookmark. That bookmark is stored here. See
 	// https://developer.apple.com/documentation/security/app_sandbox/accessing_files_from_the_macos_app_sandbox#4144043
 	BookmarkData []byte `json:"bookmarkData,omitempty"`
+
+	// ReadOnly, if true, makes the share read-only for all remote nodes,
+	// regardless of the access their grants give them.
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// QuotaBytes, if positive, is the maximum total size in bytes of the
+	// files in the share. Remote writes that would exceed it are rejected.
+	QuotaBytes int64 `json:"quotaBytes,omitempty"`
+
+	// SnapshotOf, if non-empty, is the path of the directory of which Path
+	// is a point-in-time copy, taken at SnapshotTime. Snapshot shares are
+	// always read-only.
+	SnapshotOf string `json:"snapshotOf,omitempty"`
+
+	// SnapshotTime is when the snapshot in Path was taken. It's only set if
+	// SnapshotOf is.
+	SnapshotTime time.Time `json:"snapshotTime,omitzero"`
+}
+
+// IsReadOnly reports whether s may never be written to by remote nodes.
+func (s *Share) IsReadOnly() bool {
+	return s.ReadOnly || s.SnapshotOf != ""
 }
 
 func ShareViewsEqual(a, b ShareView) bool {
@@ -55,7 +78,7 @@ func ShareViewsEqual(a, b ShareView) bool {
 	if !a.Valid() || !b.Valid() {
 		return false
 	}
-	return a.Name() == b.Name() && a.Path() == b.Path() && a.As() == b.As() && a.BookmarkData().Equal(b.ж.BookmarkData)
+	return SharesEqual(a.ж, b.ж)
 }
 
 func SharesEqual(a, b *Share) bool {
@@ -65,7 +88,14 @@ func SharesEqual(a, b *Share) bool {
 	if a == nil || b == nil {
 		return false
 	}
-	return a.Name == b.Name && a.Path == b.Path && a.As == b.As && bytes.Equal(a.BookmarkData, b.BookmarkData)
+	return a.Name == b.Name &&
+		a.Path == b.Path &&
+		a.As == b.As &&
+		bytes.Equal(a.BookmarkData, b.BookmarkData) &&
+		a.ReadOnly == b.ReadOnly &&
+		a.QuotaBytes == b.QuotaBytes &&
+		a.SnapshotOf == b.SnapshotOf &&
+		a.SnapshotTime.Equal(b.SnapshotTime)
 }
 
 func CompareShares(a, b *Share) int {
@@ -0,0 +1,13 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Code generated by gen.go; DO NOT EDIT.
+
+//go:build !ts_omit_packetprocessor
+
+package buildfeatures
+
+// HasPacketProcessor is whether the binary was built with support for modular feature "Packet processors registered by custom builds of tailscaled".
+// Specifically, it's whether the binary was NOT built with the "ts_omit_packetprocessor" build tag.
+// It's a const so it can be used for dead code elimination.
+const HasPacketProcessor = true
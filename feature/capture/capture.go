@@ -1,21 +1,27 @@
 // Copyright (c) Tailscale Inc & contributors
 // SPDX-License-Identifier: BSD-3-Clause
 
-// Package capture formats packet logging into a debug pcap stream.
+// Package capture formats packet logging into a debug pcap or pcapng
+// stream.
 package capture
 
 import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net/http"
+	"net/netip"
+	"strings"
 	"sync"
 	"time"
 
 	"tailscale.com/feature"
+	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/localapi"
 	"tailscale.com/net/packet"
+	"tailscale.com/syncs"
 	"tailscale.com/util/set"
 )
 
@@ -34,6 +40,23 @@ func serveLocalAPIDebugCapture(h *localapi.Handler, w http.ResponseWriter, r *ht
 		http.Error(w, "POST required", http.StatusMethodNotAllowed)
 		return
 	}
+	o := &output{w: w}
+	switch format := r.FormValue("format"); format {
+	case "", "pcap":
+	case "pcapng":
+		o.pcapng = true
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+	if expr := r.FormValue("filter"); expr != "" {
+		f, err := parseFilter(expr)
+		if err != nil {
+			http.Error(w, "invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		o.filter = f
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.(http.Flusher).Flush()
@@ -41,7 +64,26 @@ func serveLocalAPIDebugCapture(h *localapi.Handler, w http.ResponseWriter, r *ht
 	b := h.LocalBackend()
 	s := b.GetOrSetCaptureSink(newSink)
 
-	unregister := s.RegisterOutput(w)
+	if o.pcapng {
+		// Peer names and paths are looked up periodically, rather than
+		// for each packet, to keep the data path from waiting on them.
+		o.peers = new(syncs.AtomicValue[map[netip.Addr]peerInfo])
+		o.peers.Store(peerTable(b))
+		go func() {
+			t := time.NewTicker(peerTableRefreshPeriod)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					o.peers.Store(peerTable(b))
+				}
+			}
+		}()
+	}
+
+	unregister := s.(*Sink).registerOutput(o)
 
 	select {
 	case <-ctx.Done():
@@ -52,6 +94,32 @@ func serveLocalAPIDebugCapture(h *localapi.Handler, w http.ResponseWriter, r *ht
 	b.ClearCaptureSink()
 }
 
+// peerTableRefreshPeriod is how often the peer names and paths in pcapng
+// packet comments are updated.
+const peerTableRefreshPeriod = 2 * time.Second
+
+// peerTable returns the current peers of b, by Tailscale IP.
+func peerTable(b *ipnlocal.LocalBackend) map[netip.Addr]peerInfo {
+	nm := b.NetMap()
+	if nm == nil {
+		return nil
+	}
+	mc := b.MagicConn()
+	peers := make(map[netip.Addr]peerInfo)
+	for _, p := range nm.Peers {
+		pi := peerInfo{name: strings.TrimSuffix(p.Name(), ".")}
+		if mc != nil {
+			pi.path = mc.PeerPathOfNodeKey(p.Key())
+		}
+		for _, pfx := range p.Addresses().All() {
+			if pfx.IsSingleIP() {
+				peers[pfx.Addr()] = pi
+			}
+		}
+	}
+	return peers
+}
+
 var bufferPool = sync.Pool{
 	New: func() any {
 		return new(bytes.Buffer)
@@ -97,10 +165,21 @@ type Sink struct {
 	ctxCancel context.CancelFunc
 
 	mu         sync.Mutex
-	outputs    set.HandleSet[io.Writer]
+	outputs    set.HandleSet[*output]
 	flushTimer *time.Timer // or nil if none running
 }
 
+// output is a stream a Sink writes captured packets to.
+type output struct {
+	w      io.Writer
+	pcapng bool   // whether to write pcapng, rather than pcap
+	filter filter // or nil to write all packets
+
+	// peers, if non-nil, describes the peers that packets are to or from,
+	// for pcapng packet comments.
+	peers *syncs.AtomicValue[map[netip.Addr]peerInfo]
+}
+
 // RegisterOutput connects an output to this sink, which
 // will be written to with a pcap stream as packets are logged.
 // A function is returned which unregisters the output when
@@ -110,15 +189,23 @@ type Sink struct {
 // or when the sink is closed. If w implements http.Flusher,
 // it will be flushed periodically.
 func (s *Sink) RegisterOutput(w io.Writer) (unregister func()) {
+	return s.registerOutput(&output{w: w})
+}
+
+func (s *Sink) registerOutput(o *output) (unregister func()) {
 	select {
 	case <-s.ctx.Done():
 		return func() {}
 	default:
 	}
 
-	writePcapHeader(w)
+	if o.pcapng {
+		writePcapngHeader(o.w)
+	} else {
+		writePcapHeader(o.w)
+	}
 	s.mu.Lock()
-	hnd := s.outputs.Add(w)
+	hnd := s.outputs.Add(o)
 	s.mu.Unlock()
 
 	return func() {
@@ -152,7 +239,7 @@ func (s *Sink) Close() error {
 	}
 
 	for _, o := range s.outputs {
-		if o, ok := o.(io.Closer); ok {
+		if o, ok := o.w.(io.Closer); ok {
 			o.Close()
 		}
 	}
@@ -177,25 +264,9 @@ func customDataLen(meta packet.CaptureMeta) int {
 	return length
 }
 
-// LogPacket is called to insert a packet into the capture.
-//
-// This function does not take ownership of the provided data slice.
-func (s *Sink) LogPacket(path packet.CapturePath, when time.Time, data []byte, meta packet.CaptureMeta) {
-	select {
-	case <-s.ctx.Done():
-		return
-	default:
-	}
-
-	extraLen := customDataLen(meta)
-	b := bufferPool.Get().(*bytes.Buffer)
-	b.Reset()
-	b.Grow(16 + extraLen + len(data)) // 16b pcap header + len(metadata) + len(payload)
-	defer bufferPool.Put(b)
-
-	writePktHeader(b, when, len(data)+extraLen)
-
-	// Custom tailscale debugging data
+// writeCustomData writes the custom Tailscale debugging data that precedes
+// each packet in a pcap capture.
+func writeCustomData(b *bytes.Buffer, path packet.CapturePath, meta packet.CaptureMeta) {
 	binary.Write(b, binary.LittleEndian, uint16(path))
 	if meta.DidSNAT {
 		binary.Write(b, binary.LittleEndian, uint8(meta.OriginalSrc.Addr().BitLen()/8))
@@ -209,21 +280,80 @@ func (s *Sink) LogPacket(path packet.CapturePath, when time.Time, data []byte, m
 	} else {
 		binary.Write(b, binary.LittleEndian, uint8(0)) // DNAT addr len == 0
 	}
+}
 
-	b.Write(data)
+// LogPacket is called to insert a packet into the capture.
+//
+// This function does not take ownership of the provided data slice.
+func (s *Sink) LogPacket(path packet.CapturePath, when time.Time, data []byte, meta packet.CaptureMeta) {
+	select {
+	case <-s.ctx.Done():
+		return
+	default:
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// The packet is only decoded if an output needs it.
+	var pkt *capturedPacket
+	decoded := func() *capturedPacket {
+		if pkt == nil {
+			pkt = &capturedPacket{path: path, meta: meta}
+			if path != packet.PathDisco {
+				p := new(packet.Parsed)
+				p.Decode(data)
+				if p.IPVersion != 0 {
+					pkt.p = p
+				}
+			}
+		}
+		return pkt
+	}
+
+	var (
+		pcapRec   *bytes.Buffer // the pcap record, shared by pcap outputs
+		pcapngRec *bytes.Buffer // reused for each pcapng output's record
+	)
 	var hadError []set.Handle
 	for hnd, o := range s.outputs {
-		if _, err := o.Write(b.Bytes()); err != nil {
+		if o.filter != nil && !o.filter(decoded()) {
+			continue
+		}
+		var rec *bytes.Buffer
+		if o.pcapng {
+			if pcapngRec == nil {
+				pcapngRec = bufferPool.Get().(*bytes.Buffer)
+				defer bufferPool.Put(pcapngRec)
+			}
+			pcapngRec.Reset()
+			var peers map[netip.Addr]peerInfo
+			if o.peers != nil {
+				peers = o.peers.Load()
+			}
+			writePcapngPacket(pcapngRec, decoded(), when, data, peers)
+			rec = pcapngRec
+		} else {
+			if pcapRec == nil {
+				pcapRec = bufferPool.Get().(*bytes.Buffer)
+				pcapRec.Reset()
+				extraLen := customDataLen(meta)
+				pcapRec.Grow(16 + extraLen + len(data)) // 16b pcap header + len(metadata) + len(payload)
+				defer bufferPool.Put(pcapRec)
+
+				writePktHeader(pcapRec, when, len(data)+extraLen)
+				writeCustomData(pcapRec, path, meta)
+				pcapRec.Write(data)
+			}
+			rec = pcapRec
+		}
+		if _, err := o.w.Write(rec.Bytes()); err != nil {
 			hadError = append(hadError, hnd)
 			continue
 		}
 	}
 	for _, hnd := range hadError {
-		if o, ok := s.outputs[hnd].(io.Closer); ok {
+		if o, ok := s.outputs[hnd].w.(io.Closer); ok {
 			o.Close()
 		}
 		delete(s.outputs, hnd)
@@ -234,7 +364,7 @@ func (s *Sink) LogPacket(path packet.CapturePath, when time.Time, data []byte, m
 			s.mu.Lock()
 			defer s.mu.Unlock()
 			for _, o := range s.outputs {
-				if f, ok := o.(http.Flusher); ok {
+				if f, ok := o.w.(http.Flusher); ok {
 					f.Flush()
 				}
 			}
@@ -0,0 +1,204 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package capture
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"tailscale.com/disco"
+	"tailscale.com/net/packet"
+	"tailscale.com/syncs"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/key"
+)
+
+func mustParsed(t *testing.T, proto ipproto.Proto, src, dst string) *packet.Parsed {
+	t.Helper()
+	h := packet.UDP4Header{
+		IP4Header: packet.IP4Header{
+			IPProto: proto,
+			Src:     netip.MustParseAddrPort(src).Addr(),
+			Dst:     netip.MustParseAddrPort(dst).Addr(),
+		},
+		SrcPort: netip.MustParseAddrPort(src).Port(),
+		DstPort: netip.MustParseAddrPort(dst).Port(),
+	}
+	buf := make([]byte, h.Len())
+	if err := h.Marshal(buf); err != nil {
+		t.Fatal(err)
+	}
+	p := new(packet.Parsed)
+	p.Decode(buf)
+	return p
+}
+
+func TestFilter(t *testing.T) {
+	udp := &capturedPacket{
+		path: packet.FromPeer,
+		p:    mustParsed(t, ipproto.UDP, "100.64.0.1:41641", "100.64.0.2:53"),
+	}
+	natted := &capturedPacket{
+		path: packet.FromLocal,
+		p:    mustParsed(t, ipproto.UDP, "100.64.0.2:1234", "10.0.0.5:80"),
+		meta: packet.CaptureMeta{
+			DidDNAT:     true,
+			OriginalDst: netip.MustParseAddrPort("100.99.0.1:80"),
+		},
+	}
+	discoFrame := &capturedPacket{path: packet.PathDisco}
+
+	tests := []struct {
+		expr string
+		pkt  *capturedPacket
+		want bool
+	}{
+		{"host 100.64.0.1", udp, true},
+		{"src host 100.64.0.1", udp, true},
+		{"dst host 100.64.0.1", udp, false},
+		{"net 100.64.0.0/10", udp, true},
+		{"dst net 10.0.0.0/8", udp, false},
+		{"port 53", udp, true},
+		{"src port 53", udp, false},
+		{"udp port 53", udp, true},
+		{"tcp port 53", udp, false},
+		{"udp dst port 53", udp, true},
+		{"proto 17", udp, true},
+		{"ip", udp, true},
+		{"ip6", udp, false},
+		{"path from-peer", udp, true},
+		{"path from-local", udp, false},
+		{"not path from-local", udp, true},
+		{"!(port 53 && host 100.64.0.9)", udp, true},
+		{"port 80 or port 53", udp, true},
+		{"port 80 or port 443 and host 100.64.0.1", udp, false},
+		{"(port 80 or port 53) and host 100.64.0.1", udp, true},
+		{"dst host 100.99.0.1", natted, true},
+		{"dst host 10.0.0.5", natted, true},
+		{"path disco", discoFrame, true},
+		{"host 100.64.0.1", discoFrame, false},
+		{"not port 53", discoFrame, true},
+	}
+	for _, tt := range tests {
+		f, err := parseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := f(tt.pkt); got != tt.want {
+			t.Errorf("filter %q = %v; want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"host",
+		"host foo",
+		"port 70000",
+		"src tcp",
+		"path elsewhere",
+		"(port 53",
+		"port 53)",
+		"port 53 and",
+		"tcp port 53 udp",
+		"bogus",
+	} {
+		if _, err := parseFilter(expr); err == nil {
+			t.Errorf("parseFilter(%q) succeeded; want error", expr)
+		}
+	}
+}
+
+func TestPcapng(t *testing.T) {
+	s := newSink().(*Sink)
+	defer s.Close()
+
+	f, err := parseFilter("not port 22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	o := &output{w: &buf, pcapng: true, filter: f}
+	peers := map[netip.Addr]peerInfo{
+		netip.MustParseAddr("100.64.0.1"): {name: "peer.example.ts.net", path: "derp-nyc"},
+	}
+	o.peers = new(syncs.AtomicValue[map[netip.Addr]peerInfo])
+	o.peers.Store(peers)
+	unregister := s.registerOutput(o)
+	defer unregister()
+
+	when := time.Unix(1700000000, 123456789)
+	in := mustParsed(t, ipproto.UDP, "100.64.0.1:41641", "100.64.0.2:53")
+	s.LogPacket(packet.FromPeer, when, in.Buffer(), packet.CaptureMeta{})
+	ssh := mustParsed(t, ipproto.TCP, "100.64.0.2:1234", "100.64.0.1:22")
+	s.LogPacket(packet.FromLocal, when, ssh.Buffer(), packet.CaptureMeta{}) // filtered out
+	discoSrc := netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 3)
+	s.LogPacket(packet.PathDisco, when, disco.ToPCAPFrame(discoSrc, key.NewNode().Public(), []byte("ping")), packet.CaptureMeta{})
+
+	r, err := pcapgo.NewNgReader(&buf, pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ci, err := r.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, in.Buffer()) || ci.InterfaceIndex != pcapngIfaceIP || !ci.Timestamp.Equal(when) {
+		t.Errorf("first packet = %x on interface %d at %v; want %x on %d at %v", data, ci.InterfaceIndex, ci.Timestamp, in.Buffer(), pcapngIfaceIP, when)
+	}
+	data, ci, err = r.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.InterfaceIndex != pcapngIfaceDisco || !bytes.HasSuffix(data, []byte("ping")) {
+		t.Errorf("second packet = %x on interface %d; want disco frame", data, ci.InterfaceIndex)
+	}
+	if _, _, err := r.ReadPacketData(); err == nil {
+		t.Error("filtered packet was captured")
+	}
+
+	// The reader only parses interface descriptions as it reaches them.
+	if n := r.NInterfaces(); n != 2 {
+		t.Fatalf("got %d interfaces; want 2", n)
+	}
+	for i, want := range []layers.LinkType{layers.LinkTypeRaw, layers.LinkType(linkTypeUser0)} {
+		iface, err := r.Interface(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if iface.LinkType != want {
+			t.Errorf("interface %d has link type %v; want %v", i, iface.LinkType, want)
+		}
+	}
+}
+
+func TestPacketComment(t *testing.T) {
+	peers := map[netip.Addr]peerInfo{
+		netip.MustParseAddr("100.64.0.1"): {name: "peer.example.ts.net", path: "direct 192.0.2.1:41641"},
+	}
+	pkt := &capturedPacket{
+		path: packet.FromPeer,
+		p:    mustParsed(t, ipproto.UDP, "100.64.0.1:41641", "100.64.0.2:53"),
+		meta: packet.CaptureMeta{
+			DidSNAT:     true,
+			OriginalSrc: netip.MustParseAddrPort("100.64.0.9:41641"),
+		},
+	}
+	got := packetComment(pkt, pkt.p.Buffer(), peers)
+	want := "path=from-peer snat-src=100.64.0.9:41641 peer=peer.example.ts.net via=direct:192.0.2.1:41641"
+	if got != want {
+		t.Errorf("comment = %q; want %q", got, want)
+	}
+
+	frame := disco.ToPCAPFrame(netip.MustParseAddrPort("192.0.2.1:41641"), key.NodePublic{}, []byte("ping"))
+	got = packetComment(&capturedPacket{path: packet.PathDisco}, frame, nil)
+	if want := "path=disco via=direct:192.0.2.1:41641"; got != want {
+		t.Errorf("disco comment = %q; want %q", got, want)
+	}
+}
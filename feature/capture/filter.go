@@ -0,0 +1,347 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package capture
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+// capturedPacket is a packet being considered by a filter.
+type capturedPacket struct {
+	path packet.CapturePath
+	meta packet.CaptureMeta
+	p    *packet.Parsed // or nil if the packet isn't an IP packet
+}
+
+// filter reports whether a packet is wanted in a capture.
+type filter func(*capturedPacket) bool
+
+// pathNames are the names of capture paths in filter expressions and
+// pcapng packet comments.
+var pathNames = map[packet.CapturePath]string{
+	packet.FromLocal:          "from-local",
+	packet.FromPeer:           "from-peer",
+	packet.SynthesizedToLocal: "synthesized-to-local",
+	packet.SynthesizedToPeer:  "synthesized-to-peer",
+	packet.PathDisco:          "disco",
+}
+
+// parseFilter parses a capture filter expression, in a small language
+// modelled on tcpdump's:
+//
+//	expr   = term { ("or" | "||") term }
+//	term   = factor { ("and" | "&&") factor }
+//	factor = ("not" | "!") factor | "(" expr ")" | prim
+//	prim   = [dir] "host" addr | [dir] "net" prefix | [dir] "port" num
+//	       | "ip" | "ip6" | "proto" name-or-num
+//	       | ("tcp" | "udp" | "sctp") [[dir] "port" num]
+//	       | "icmp" | "icmp6" | "tsmp"
+//	       | "path" ("from-local" | "from-peer" | "synthesized-to-local" |
+//	                 "synthesized-to-peer" | "disco")
+//	dir    = "src" | "dst"
+//
+// Addresses match the addresses before SNAT or DNAT, too. Only "path"
+// matches disco frames.
+func parseFilter(expr string) (filter, error) {
+	p := &filterParser{toks: tokenizeFilter(expr)}
+	if len(p.toks) == 0 {
+		return nil, fmt.Errorf("empty filter")
+	}
+	f, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok != "" {
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+	return f, nil
+}
+
+// tokenizeFilter splits expr into words and operators.
+func tokenizeFilter(expr string) []string {
+	var toks []string
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == '!':
+			toks = append(toks, expr[i:i+1])
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			toks = append(toks, expr[i:i+2])
+			i += 2
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t\n()!&|", rune(expr[j])) {
+				j++
+			}
+			if j == i { // a lone '&' or '|'
+				j++
+			}
+			toks = append(toks, expr[i:j])
+			i = j
+		}
+	}
+	return toks
+}
+
+type filterParser struct {
+	toks []string
+}
+
+// peek returns the next token, or "" at the end of the expression.
+func (p *filterParser) peek() string {
+	if len(p.toks) == 0 {
+		return ""
+	}
+	return p.toks[0]
+}
+
+// next consumes and returns the next token, or "" at the end.
+func (p *filterParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.toks = p.toks[1:]
+	}
+	return tok
+}
+
+// arg consumes and returns the argument of the primitive named by prim.
+func (p *filterParser) arg(prim string) (string, error) {
+	tok := p.next()
+	if tok == "" {
+		return "", fmt.Errorf("missing argument to %q", prim)
+	}
+	return tok, nil
+}
+
+func (p *filterParser) expr() (filter, error) {
+	f, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" || p.peek() == "||" {
+		p.next()
+		g, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		f = orFilter(f, g)
+	}
+	return f, nil
+}
+
+func (p *filterParser) term() (filter, error) {
+	f, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" || p.peek() == "&&" {
+		p.next()
+		g, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		f = andFilter(f, g)
+	}
+	return f, nil
+}
+
+func (p *filterParser) factor() (filter, error) {
+	switch tok := p.next(); tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of filter")
+	case "not", "!":
+		f, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return func(pkt *capturedPacket) bool { return !f(pkt) }, nil
+	case "(":
+		f, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok != ")" {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		return f, nil
+	default:
+		return p.prim(tok)
+	}
+}
+
+// dir is the direction qualifier of a primitive.
+type dir int
+
+const (
+	dirAny dir = iota
+	dirSrc
+	dirDst
+)
+
+func (d dir) String() string {
+	switch d {
+	case dirSrc:
+		return "src"
+	case dirDst:
+		return "dst"
+	}
+	return ""
+}
+
+func (p *filterParser) prim(tok string) (filter, error) {
+	d := dirAny
+	switch tok {
+	case "src":
+		d = dirSrc
+		tok = p.next()
+	case "dst":
+		d = dirDst
+		tok = p.next()
+	}
+
+	switch tok {
+	case "host":
+		s, err := p.arg(tok)
+		if err != nil {
+			return nil, err
+		}
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host: %w", err)
+		}
+		return addrFilter(d, func(a netip.Addr) bool { return a == ip }), nil
+	case "net":
+		s, err := p.arg(tok)
+		if err != nil {
+			return nil, err
+		}
+		pfx, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid net: %w", err)
+		}
+		pfx = pfx.Masked()
+		return addrFilter(d, pfx.Contains), nil
+	case "port":
+		return p.port(d)
+	}
+	if d != dirAny {
+		return nil, fmt.Errorf("%q must be followed by host, net or port", d)
+	}
+
+	switch tok {
+	case "ip":
+		return func(pkt *capturedPacket) bool { return pkt.p != nil && pkt.p.IPVersion == 4 }, nil
+	case "ip6":
+		return func(pkt *capturedPacket) bool { return pkt.p != nil && pkt.p.IPVersion == 6 }, nil
+	case "icmp":
+		return protoFilter(ipproto.ICMPv4), nil
+	case "icmp6":
+		return protoFilter(ipproto.ICMPv6), nil
+	case "tsmp":
+		return protoFilter(ipproto.TSMP), nil
+	case "tcp", "udp", "sctp":
+		var proto ipproto.Proto
+		proto.UnmarshalText([]byte(tok))
+		f := protoFilter(proto)
+		// As in tcpdump, "tcp port 443" is "tcp and port 443".
+		switch p.peek() {
+		case "port", "src", "dst":
+			g, err := p.prim(p.next())
+			if err != nil {
+				return nil, err
+			}
+			return andFilter(f, g), nil
+		}
+		return f, nil
+	case "proto":
+		s, err := p.arg(tok)
+		if err != nil {
+			return nil, err
+		}
+		var proto ipproto.Proto
+		if err := proto.UnmarshalText([]byte(s)); err != nil {
+			return nil, err
+		}
+		return protoFilter(proto), nil
+	case "path":
+		s, err := p.arg(tok)
+		if err != nil {
+			return nil, err
+		}
+		for path, name := range pathNames {
+			if name == s {
+				return func(pkt *capturedPacket) bool { return pkt.path == path }, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown path %q", s)
+	case "":
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	return nil, fmt.Errorf("unknown filter primitive %q", tok)
+}
+
+func (p *filterParser) port(d dir) (filter, error) {
+	s, err := p.arg("port")
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", s)
+	}
+	want := uint16(port)
+	return func(pkt *capturedPacket) bool {
+		if pkt.p == nil {
+			return false
+		}
+		switch pkt.p.IPProto {
+		case ipproto.TCP, ipproto.UDP, ipproto.SCTP:
+		default:
+			return false
+		}
+		return (d != dirDst && pkt.p.Src.Port() == want) ||
+			(d != dirSrc && pkt.p.Dst.Port() == want)
+	}, nil
+}
+
+// addrFilter returns a filter for IP packets with a source or destination
+// address, as selected by d, for which match returns true.
+func addrFilter(d dir, match func(netip.Addr) bool) filter {
+	return func(pkt *capturedPacket) bool {
+		if pkt.p == nil {
+			return false
+		}
+		if d != dirDst {
+			if match(pkt.p.Src.Addr()) || (pkt.meta.DidSNAT && match(pkt.meta.OriginalSrc.Addr())) {
+				return true
+			}
+		}
+		if d != dirSrc {
+			if match(pkt.p.Dst.Addr()) || (pkt.meta.DidDNAT && match(pkt.meta.OriginalDst.Addr())) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func protoFilter(proto ipproto.Proto) filter {
+	return func(pkt *capturedPacket) bool { return pkt.p != nil && pkt.p.IPProto == proto }
+}
+
+func andFilter(f, g filter) filter {
+	return func(pkt *capturedPacket) bool { return f(pkt) && g(pkt) }
+}
+
+func orFilter(f, g filter) filter {
+	return func(pkt *capturedPacket) bool { return f(pkt) || g(pkt) }
+}
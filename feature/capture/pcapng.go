@@ -0,0 +1,215 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/tailcfg"
+)
+
+// pcapng block types, option codes and link types, from
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-03.html.
+const (
+	pcapngSHB = 0x0A0D0D0A // Section Header Block
+	pcapngIDB = 1          // Interface Description Block
+	pcapngEPB = 6          // Enhanced Packet Block
+
+	pcapngOptEnd      = 0
+	pcapngOptComment  = 1
+	pcapngOptUserAppl = 4 // shb_userappl
+	pcapngOptIfName   = 2 // if_name
+	pcapngOptTSResol  = 9 // if_tsresol
+	pcapngOptEPBFlags = 2 // epb_flags
+
+	linkTypeRaw   = 101 // raw IPv4 or IPv6
+	linkTypeUser0 = 147 // the Tailscale debug format of writePcapHeader
+)
+
+// pcapng interface IDs. IP packets are written as raw IP, which stock
+// Wireshark can dissect. Disco frames are written in the same format as in
+// pcap captures, for the Lua dissector.
+const (
+	pcapngIfaceIP    = 0
+	pcapngIfaceDisco = 1
+)
+
+// writePcapngHeader writes the section header and interface descriptions
+// that start a pcapng stream.
+func writePcapngHeader(w io.Writer) {
+	var b bytes.Buffer
+	var opts bytes.Buffer
+	appendPcapngOpt(&opts, pcapngOptUserAppl, []byte("tailscaled"))
+	appendPcapngOpt(&opts, pcapngOptEnd, nil)
+	body := binary.LittleEndian.AppendUint32(nil, 0x1A2B3C4D) // byte-order magic
+	body = binary.LittleEndian.AppendUint16(body, 1)          // version major
+	body = binary.LittleEndian.AppendUint16(body, 0)          // version minor
+	body = binary.LittleEndian.AppendUint64(body, ^uint64(0)) // section length: unspecified
+	body = append(body, opts.Bytes()...)
+	appendPcapngBlock(&b, pcapngSHB, body)
+
+	for _, iface := range []struct {
+		name     string
+		linkType uint16
+	}{
+		pcapngIfaceIP:    {"tailscale", linkTypeRaw},
+		pcapngIfaceDisco: {"tailscale-disco", linkTypeUser0},
+	} {
+		opts.Reset()
+		appendPcapngOpt(&opts, pcapngOptIfName, []byte(iface.name))
+		appendPcapngOpt(&opts, pcapngOptTSResol, []byte{9}) // nanoseconds
+		appendPcapngOpt(&opts, pcapngOptEnd, nil)
+		body := binary.LittleEndian.AppendUint16(nil, iface.linkType)
+		body = binary.LittleEndian.AppendUint16(body, 0)     // reserved
+		body = binary.LittleEndian.AppendUint32(body, 65535) // snap length
+		body = append(body, opts.Bytes()...)
+		appendPcapngBlock(&b, pcapngIDB, body)
+	}
+	w.Write(b.Bytes())
+}
+
+// appendPcapngBlock appends a block of type typ with the given body, which
+// must be a multiple of 4 bytes long, to b.
+func appendPcapngBlock(b *bytes.Buffer, typ uint32, body []byte) {
+	n := uint32(12 + len(body))
+	binary.Write(b, binary.LittleEndian, typ)
+	binary.Write(b, binary.LittleEndian, n)
+	b.Write(body)
+	binary.Write(b, binary.LittleEndian, n)
+}
+
+// appendPcapngOpt appends an option to b, padded to 4 bytes.
+func appendPcapngOpt(b *bytes.Buffer, code uint16, val []byte) {
+	binary.Write(b, binary.LittleEndian, code)
+	binary.Write(b, binary.LittleEndian, uint16(len(val)))
+	b.Write(val)
+	b.Write(make([]byte, pad4(len(val))))
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+// peerInfo describes a peer, for pcapng packet comments.
+type peerInfo struct {
+	name string // the peer's MagicDNS name, without the trailing dot
+	path string // as returned by magicsock.Conn.PeerPathOfNodeKey
+}
+
+// writePcapngPacket writes an Enhanced Packet Block for the captured packet
+// to b. The packet's metadata is written as a comment, and its direction as
+// epb_flags. If peers is non-nil, it's used to describe the peer the packet
+// is from or to.
+func writePcapngPacket(b *bytes.Buffer, pkt *capturedPacket, when time.Time, data []byte, peers map[netip.Addr]peerInfo) {
+	iface := uint32(pcapngIfaceIP)
+	var disco []byte // the whole record, for disco frames
+	if pkt.path == packet.PathDisco {
+		iface = pcapngIfaceDisco
+		var rec bytes.Buffer
+		writeCustomData(&rec, pkt.path, pkt.meta)
+		rec.Write(data)
+		disco = rec.Bytes()
+	}
+
+	var opts bytes.Buffer
+	appendPcapngOpt(&opts, pcapngOptComment, []byte(packetComment(pkt, data, peers)))
+	var flags uint32
+	switch pkt.path {
+	case packet.FromPeer, packet.SynthesizedToLocal:
+		flags = 1 // inbound
+	case packet.FromLocal, packet.SynthesizedToPeer:
+		flags = 2 // outbound
+	}
+	if flags != 0 {
+		appendPcapngOpt(&opts, pcapngOptEPBFlags, binary.LittleEndian.AppendUint32(nil, flags))
+	}
+	appendPcapngOpt(&opts, pcapngOptEnd, nil)
+
+	if disco != nil {
+		data = disco
+	}
+	ts := uint64(when.UnixNano())
+	n := uint32(12 + 20 + len(data) + pad4(len(data)) + opts.Len())
+	binary.Write(b, binary.LittleEndian, uint32(pcapngEPB))
+	binary.Write(b, binary.LittleEndian, n)
+	binary.Write(b, binary.LittleEndian, iface)
+	binary.Write(b, binary.LittleEndian, uint32(ts>>32))
+	binary.Write(b, binary.LittleEndian, uint32(ts))
+	binary.Write(b, binary.LittleEndian, uint32(len(data))) // captured length
+	binary.Write(b, binary.LittleEndian, uint32(len(data))) // original length
+	b.Write(data)
+	b.Write(make([]byte, pad4(len(data))))
+	b.Write(opts.Bytes())
+	binary.Write(b, binary.LittleEndian, n)
+}
+
+// packetComment returns the comment describing pkt in a pcapng capture, as
+// space-separated key=value pairs.
+func packetComment(pkt *capturedPacket, data []byte, peers map[netip.Addr]peerInfo) string {
+	var sb strings.Builder
+	sb.WriteString("path=")
+	sb.WriteString(pathNames[pkt.path])
+	if pkt.meta.DidSNAT {
+		sb.WriteString(" snat-src=")
+		sb.WriteString(pkt.meta.OriginalSrc.String())
+	}
+	if pkt.meta.DidDNAT {
+		sb.WriteString(" dnat-dst=")
+		sb.WriteString(pkt.meta.OriginalDst.String())
+	}
+
+	if pkt.path == packet.PathDisco {
+		if via := discoFrameVia(data); via != "" {
+			sb.WriteString(" via=")
+			sb.WriteString(via)
+		}
+		return sb.String()
+	}
+	if pkt.p == nil {
+		return sb.String()
+	}
+	peerAddr := pkt.p.Dst.Addr()
+	if pkt.path == packet.FromPeer || pkt.path == packet.SynthesizedToLocal {
+		peerAddr = pkt.p.Src.Addr()
+	}
+	if pi, ok := peers[peerAddr]; ok {
+		sb.WriteString(" peer=")
+		sb.WriteString(pi.name)
+		if pi.path != "" {
+			sb.WriteString(" via=")
+			sb.WriteString(strings.ReplaceAll(pi.path, " ", ":"))
+		}
+	}
+	return sb.String()
+}
+
+// discoFrameVia returns how the disco frame data, as produced by
+// disco.ToPCAPFrame, was received: "derp-<region ID>" or
+// "direct:<ip:port>". It returns the empty string if data is malformed.
+func discoFrameVia(data []byte) string {
+	const addrOff = 1 + 32 + 2 + 2 // flags, DERP key, port, address length
+	if len(data) < addrOff {
+		return ""
+	}
+	port := binary.LittleEndian.Uint16(data[33:])
+	addrLen := int(binary.LittleEndian.Uint16(data[35:]))
+	if len(data) < addrOff+addrLen {
+		return ""
+	}
+	var ip netip.Addr
+	if err := ip.UnmarshalBinary(data[addrOff : addrOff+addrLen]); err != nil {
+		return ""
+	}
+	if ip == tailcfg.DerpMagicIPAddr {
+		return "derp-" + strconv.Itoa(int(port))
+	}
+	return "direct:" + netip.AddrPortFrom(ip, port).String()
+}
@@ -0,0 +1,8 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_encstore
+
+package condregister
+
+import _ "tailscale.com/ipn/store/encstore"
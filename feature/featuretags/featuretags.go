@@ -160,6 +160,7 @@ type FeatureMeta struct {
 	"desktop_sessions": {Sym: "DesktopSessions", Desc: "Desktop sessions support"},
 	"doctor":           {Sym: "Doctor", Desc: "Diagnose possible issues with Tailscale and its host environment"},
 	"drive":            {Sym: "Drive", Desc: "Tailscale Drive (file server) support"},
+	"encstore":         {Sym: "EncStore", Desc: "Encrypted state file store (enc: state path prefix)"},
 	"gro": {
 		Sym:  "GRO",
 		Desc: "Generic Receive Offload support (performance)",
@@ -210,6 +211,10 @@ type FeatureMeta struct {
 		Desc:                 "PeerAPI server support",
 		ImplementationDetail: true,
 	},
+	"packetprocessor": {
+		Sym:  "PacketProcessor",
+		Desc: "Packet processors registered by custom builds of tailscaled",
+	},
 	"portlist":   {Sym: "PortList", Desc: "Optionally advertise listening service ports"},
 	"portmapper": {Sym: "PortMapper", Desc: "NAT-PMP/PCP/UPnP port mapping support"},
 	"posture":    {Sym: "Posture", Desc: "Device posture checking support"},
@@ -0,0 +1,105 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package packetprocessor registers the packet processor feature and
+// implements its associated ipnext.Extension, which adds the packet
+// processors registered with [Register] to tailscaled's TUN wrapper.
+//
+// It lets custom builds of tailscaled inspect, modify or drop the packets
+// that it exchanges with its peers, e.g. to rate limit them. Such builds
+// link in a package that calls [Register] from an init function.
+package packetprocessor
+
+import (
+	"errors"
+	"sync"
+
+	"tailscale.com/feature"
+	"tailscale.com/ipn/ipnext"
+	"tailscale.com/net/tstun"
+	"tailscale.com/types/logger"
+)
+
+// featureName is the name of the feature implemented by this package.
+// It is also the [extension] name and the log prefix.
+const featureName = "packetprocessor"
+
+func init() {
+	feature.Register(featureName)
+	ipnext.RegisterExtension(featureName, newExtension)
+}
+
+// processor is a packet processor registered with [Register].
+type processor struct {
+	name string
+	dir  tstun.Direction
+	fn   tstun.PacketProcessor
+}
+
+var (
+	mu         sync.Mutex
+	processors []processor
+)
+
+// Register registers fn to process the packets flowing in direction dir
+// between tailscaled and its peers. See [tstun.PacketProcessor] and
+// [tstun.Wrapper.AddPacketProcessor] for what processors can do and which
+// packets they see. Processors run in the order they were registered. The
+// name identifies the processor in logs.
+//
+// Register must be called before tailscaled starts, typically from an init
+// function.
+func Register(name string, dir tstun.Direction, fn tstun.PacketProcessor) {
+	mu.Lock()
+	defer mu.Unlock()
+	processors = append(processors, processor{name, dir, fn})
+}
+
+func newExtension(logf logger.Logf, sb ipnext.SafeBackend) (ipnext.Extension, error) {
+	return &extension{
+		logf: logger.WithPrefix(logf, featureName+": "),
+		sb:   sb,
+	}, nil
+}
+
+// extension is an [ipnext.Extension] that adds the registered packet
+// processors to the TUN wrapper.
+type extension struct {
+	logf logger.Logf
+	sb   ipnext.SafeBackend
+
+	removes []func() // from Init
+}
+
+// Name implements [ipnext.Extension].
+func (e *extension) Name() string {
+	return featureName
+}
+
+// Init implements [ipnext.Extension].
+func (e *extension) Init(ipnext.Host) error {
+	mu.Lock()
+	procs := processors
+	mu.Unlock()
+	if len(procs) == 0 {
+		return ipnext.SkipExtension
+	}
+	tun, ok := e.sb.Sys().Tun.GetOK()
+	if !ok {
+		return errors.New("no TUN wrapper to add packet processors to")
+	}
+	for _, p := range procs {
+		e.removes = append(e.removes, tun.AddPacketProcessor(p.dir, p.fn))
+		e.logf("added %v packet processor %q", p.dir, p.name)
+	}
+	return nil
+}
+
+// Shutdown implements [ipnext.Extension].
+func (e *extension) Shutdown() error {
+	for _, remove := range e.removes {
+		remove()
+	}
+	e.removes = nil
+	return nil
+}
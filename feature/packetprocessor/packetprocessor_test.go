@@ -0,0 +1,90 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package packetprocessor
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/ipn/ipnext"
+	"tailscale.com/net/packet"
+	"tailscale.com/net/tstun"
+	"tailscale.com/tsd"
+	"tailscale.com/tstime"
+	"tailscale.com/util/eventbus/eventbustest"
+	"tailscale.com/util/usermetric"
+	"tailscale.com/wgengine/filter"
+)
+
+type mockSafeBackend struct {
+	sys *tsd.System
+}
+
+func (m mockSafeBackend) Sys() *tsd.System       { return m.sys }
+func (mockSafeBackend) Clock() tstime.Clock      { return nil }
+func (mockSafeBackend) TailscaleVarRoot() string { return "" }
+
+func TestExtension(t *testing.T) {
+	oldProcessors := processors
+	t.Cleanup(func() { processors = oldProcessors })
+	processors = nil
+
+	sys := tsd.NewSystem()
+	ext, err := newExtension(t.Logf, mockSafeBackend{sys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ext.Init(nil); err != ipnext.SkipExtension {
+		t.Fatalf("Init without processors = %v, want %v", err, ipnext.SkipExtension)
+	}
+
+	tun := tstun.Wrap(t.Logf, tstun.NewFake(), new(usermetric.Registry), eventbustest.NewBus(t))
+	defer tun.Close()
+	tun.Start()
+	sys.Set(tun)
+
+	var processed int
+	Register("drop-all", tstun.Outbound, func(p *packet.Parsed) filter.Response {
+		processed++
+		return filter.Drop
+	})
+	ext, err = newExtension(t.Logf, mockSafeBackend{sys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ext.Init(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func() (sent int) {
+		t.Helper()
+		pkt := packet.Generate(&packet.UDP4Header{
+			IP4Header: packet.IP4Header{
+				Src: netip.MustParseAddr("100.64.0.1"),
+				Dst: netip.MustParseAddr("100.64.0.2"),
+			},
+			SrcPort: 1234,
+			DstPort: 5678,
+		}, []byte("payload"))
+		if err := tun.InjectOutbound(pkt); err != nil {
+			t.Fatal(err)
+		}
+		var buf [tstun.MaxPacketSize]byte
+		n, err := tun.Read([][]byte{buf[:]}, make([]int, 1), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := send(); n != 0 || processed != 1 {
+		t.Errorf("sent %d packets after %d were processed, want 0 after 1", n, processed)
+	}
+
+	if err := ext.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if n := send(); n != 1 || processed != 1 {
+		t.Errorf("after Shutdown, sent %d packets after %d were processed, want 1 after 1", n, processed)
+	}
+}
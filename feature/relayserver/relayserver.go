@@ -7,11 +7,15 @@
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/netip"
+	"slices"
+	"strings"
 
 	"tailscale.com/disco"
+	"tailscale.com/envknob"
 	"tailscale.com/feature"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnext"
@@ -39,6 +43,36 @@ func init() {
 	localapi.Register("debug-peer-relay-sessions", servePeerRelayDebugSessions)
 }
 
+// The following knobs set the relay server's local [udprelay.Policy]. See
+// [status.ServerPolicy] for what they mean.
+//
+// The policy can only be set with these environment variables, which are read
+// once when tailscaled starts. Unlike the relay server's port, it's not part
+// of [ipn.Prefs] and can't be changed with 'tailscale set'.
+var (
+	// allowClients is a comma-separated list of Tailscale IPs, tags and
+	// node names.
+	allowClients         = envknob.RegisterString("TS_PEER_RELAY_ALLOW_CLIENTS")
+	maxSessionsPerClient = envknob.RegisterInt("TS_PEER_RELAY_MAX_SESSIONS_PER_CLIENT")
+	maxBytesPerSecond    = envknob.RegisterInt("TS_PEER_RELAY_MAX_BYTES_PER_SECOND")
+	idleTimeout          = envknob.RegisterDuration("TS_PEER_RELAY_IDLE_TIMEOUT")
+)
+
+// policyFromEnv returns the relay server policy set by the environment.
+func policyFromEnv() status.ServerPolicy {
+	p := status.ServerPolicy{
+		MaxSessionsPerClient: maxSessionsPerClient(),
+		MaxBytesPerSecond:    maxBytesPerSecond(),
+		IdleTimeout:          idleTimeout(),
+	}
+	for _, c := range strings.Split(allowClients(), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			p.AllowClients = append(p.AllowClients, c)
+		}
+	}
+	return p
+}
+
 // servePeerRelayDebugSessions is an HTTP handler for the Local API that
 // returns debug/status information for peer relay sessions being relayed by
 // this Tailscale node. It writes a JSON-encoded [status.ServerStatus] into the
@@ -72,7 +106,8 @@ func newExtension(logf logger.Logf, sb ipnext.SafeBackend) (ipnext.Extension, er
 		newServerFn: func(logf logger.Logf, port uint16, onlyStaticAddrPorts bool) (relayServer, error) {
 			return udprelay.NewServer(logf, port, onlyStaticAddrPorts, sb.Sys().UserMetricsRegistry())
 		},
-		logf: logger.WithPrefix(logf, featureName+": "),
+		logf:   logger.WithPrefix(logf, featureName+": "),
+		policy: policyFromEnv(),
 	}
 	e.ec = sb.Sys().Bus.Get().Client("relayserver.extension")
 	e.respPub = eventbus.Publish[magicsock.UDPRelayAllocResp](e.ec)
@@ -86,6 +121,8 @@ type relayServer interface {
 	Close() error
 	AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.ServerEndpoint, error)
 	GetSessions() []status.ServerSession
+	GetAllocationLog() []status.AllocationEvent
+	SetPolicy(udprelay.Policy)
 	SetDERPMapView(tailcfg.DERPMapView)
 	SetStaticAddrPorts(addrPorts views.Slice[netip.AddrPort])
 }
@@ -97,6 +134,12 @@ type extension struct {
 	logf        logger.Logf
 	ec          *eventbus.Client
 	respPub     *eventbus.Publisher[magicsock.UDPRelayAllocResp]
+	policy      status.ServerPolicy // set once from the environment
+
+	// host and self are used to resolve clients for policy.AllowClients.
+	// host is set once by Init, and self is the latest self node.
+	host ipnext.Host
+	self syncs.AtomicValue[tailcfg.NodeView]
 
 	mu                            syncs.Mutex                 // guards the following fields
 	shutdown                      bool                        // true if Shutdown() has been called
@@ -115,6 +158,7 @@ func (e *extension) Name() string {
 // Init implements [ipnext.Extension] by registering callbacks and providers
 // for the duration of the extension's lifetime.
 func (e *extension) Init(host ipnext.Host) error {
+	e.host = host
 	profile, prefs := host.Profiles().CurrentProfileState()
 	e.profileStateChanged(profile, prefs, false)
 	host.Hooks().ProfileStateChange.Add(e.profileStateChanged)
@@ -182,6 +226,65 @@ func (e *extension) tryStartRelayServerLocked() {
 	}
 	e.rs = rs
 	e.rs.SetDERPMapView(e.derpMapView)
+	e.rs.SetPolicy(e.serverPolicy())
+}
+
+// serverPolicy returns the [udprelay.Policy] for e.policy.
+func (e *extension) serverPolicy() udprelay.Policy {
+	p := udprelay.Policy{
+		MaxEndpointsPerClient: e.policy.MaxSessionsPerClient,
+		MaxBytesPerSecond:     e.policy.MaxBytesPerSecond,
+		IdleTimeout:           e.policy.IdleTimeout,
+	}
+	if len(e.policy.AllowClients) > 0 {
+		p.AllowClient = e.allowClient
+	}
+	return p
+}
+
+// allowClient implements [udprelay.Policy.AllowClient] for the clients in
+// e.policy.AllowClients. It must not acquire e.mu, which is held around
+// endpoint allocation.
+func (e *extension) allowClient(d key.DiscoPublic) error {
+	if self := e.self.Load(); self.Valid() && self.DiscoKey() == d {
+		// The relay server's own node.
+		return nil
+	}
+	if e.host == nil {
+		return errors.New("no netmap to resolve client")
+	}
+	peers := e.host.NodeBackend().AppendMatchingPeers(nil, func(n tailcfg.NodeView) bool {
+		return n.DiscoKey() == d
+	})
+	if len(peers) == 0 {
+		return errors.New("unknown client")
+	}
+	for _, n := range peers {
+		if slices.ContainsFunc(e.policy.AllowClients, func(c string) bool { return nodeMatches(n, c) }) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v not in allowed clients %q", peers[0].Name(), e.policy.AllowClients)
+}
+
+// nodeMatches reports whether n is the node c names by Tailscale IP, tag,
+// or MagicDNS name, in full or its first label.
+func nodeMatches(n tailcfg.NodeView, c string) bool {
+	if ip, err := netip.ParseAddr(c); err == nil {
+		for _, pfx := range n.Addresses().All() {
+			if pfx.Addr() == ip {
+				return true
+			}
+		}
+		return false
+	}
+	if strings.HasPrefix(c, "tag:") {
+		return n.Tags().ContainsFunc(func(t string) bool { return t == c })
+	}
+	name := strings.TrimSuffix(n.Name(), ".")
+	short, _, _ := strings.Cut(name, ".")
+	c = strings.TrimSuffix(c, ".")
+	return strings.EqualFold(c, name) || strings.EqualFold(c, short)
 }
 
 func (e *extension) relayServerShouldBeRunningLocked() bool {
@@ -208,6 +311,7 @@ func (e *extension) handleRelayServerStaticAddrPortsLocked() {
 }
 
 func (e *extension) selfNodeViewChanged(nodeView tailcfg.NodeView) {
+	e.self.Store(nodeView)
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.hasNodeAttrDisableRelayServer = nodeView.HasCap(tailcfg.NodeAttrDisableRelayServer)
@@ -266,5 +370,7 @@ func (e *extension) serverStatus() status.ServerStatus {
 	}
 	st.UDPPort = ptr.To(*e.port)
 	st.Sessions = e.rs.GetSessions()
+	st.Policy = ptr.To(e.policy)
+	st.Allocations = e.rs.GetAllocationLog()
 	return st
 }
@@ -11,6 +11,7 @@
 	"testing"
 
 	"tailscale.com/ipn"
+	"tailscale.com/net/udprelay"
 	"tailscale.com/net/udprelay/endpoint"
 	"tailscale.com/net/udprelay/status"
 	"tailscale.com/tailcfg"
@@ -253,8 +254,10 @@ func (m *mockRelayServer) Close() error { return nil }
 func (m *mockRelayServer) AllocateEndpoint(_, _ key.DiscoPublic) (endpoint.ServerEndpoint, error) {
 	return endpoint.ServerEndpoint{}, errors.New("not implemented")
 }
-func (m *mockRelayServer) GetSessions() []status.ServerSession { return nil }
-func (m *mockRelayServer) SetDERPMapView(tailcfg.DERPMapView)  { return }
+func (m *mockRelayServer) GetSessions() []status.ServerSession        { return nil }
+func (m *mockRelayServer) GetAllocationLog() []status.AllocationEvent { return nil }
+func (m *mockRelayServer) SetPolicy(udprelay.Policy)                  {}
+func (m *mockRelayServer) SetDERPMapView(tailcfg.DERPMapView)         { return }
 func (m *mockRelayServer) SetStaticAddrPorts(aps views.Slice[netip.AddrPort]) {
 	m.addrPorts = aps
 }
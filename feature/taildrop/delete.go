@@ -7,6 +7,7 @@
 	"container/list"
 	"context"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -106,9 +107,40 @@ func (d *fileDeleter) Init(m *manager, eventHook func(string)) {
 				d.Insert(filename)
 			}
 		}
+
+		// Also enqueue the staging directories of interrupted tree
+		// transfers, which ListFiles doesn't include.
+		dfo, ok := d.fs.(dirFileOps)
+		if !ok {
+			return
+		}
+		des, err := os.ReadDir(dfo.RootDir())
+		if err != nil {
+			d.logf("deleter: ReadDir error: %v", redactError(err))
+			return
+		}
+		for _, de := range des {
+			if d.shutdownCtx.Err() != nil {
+				return
+			}
+			if de.IsDir() && strings.HasSuffix(de.Name(), partialSuffix) {
+				d.Insert(de.Name())
+			}
+		}
 	})
 }
 
+// remove removes the file baseName, or if it's the staging directory of a
+// tree transfer, the directory and its contents.
+func (d *fileDeleter) remove(baseName string) error {
+	if dfo, ok := d.fs.(dirFileOps); ok && strings.HasSuffix(baseName, partialSuffix) {
+		if fi, err := d.fs.Stat(baseName); err == nil && fi.IsDir() {
+			return os.RemoveAll(filepath.Join(dfo.RootDir(), baseName))
+		}
+	}
+	return d.fs.Remove(baseName)
+}
+
 // Insert enqueues baseName for eventual deletion.
 func (d *fileDeleter) Insert(baseName string) {
 	d.mu.Lock()
@@ -158,7 +190,7 @@ func (d *fileDeleter) waitAndDelete(wait time.Duration) {
 					continue
 				}
 			}
-			if err := d.fs.Remove(file.name); err != nil && !os.IsNotExist(err) {
+			if err := d.remove(file.name); err != nil && !os.IsNotExist(err) {
 				d.logf("could not delete: %v", redactError(err))
 				failed = append(failed, elem)
 				continue
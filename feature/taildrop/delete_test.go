@@ -5,6 +5,7 @@
 
 import (
 	"os"
+	"path/filepath"
 	"slices"
 	"testing"
 	"time"
@@ -33,6 +34,9 @@ func TestDeleter(t *testing.T) {
 	must.Do(m.touchFile("fizz"))
 	must.Do(m.touchFile("fizz.deleted"))
 	must.Do(m.touchFile("buzz.deleted")) // lacks a matching "buzz" file
+	// The staging directory of an interrupted tree transfer.
+	must.Do(os.MkdirAll(filepath.Join(dir, "photos.n1.partial", "2024"), 0o700))
+	must.Do(os.WriteFile(filepath.Join(dir, "photos.n1.partial", "2024", "a.jpg"), []byte("jpg"), 0o600))
 
 	checkDirectory := func(want ...string) {
 		t.Helper()
@@ -97,12 +101,12 @@ func TestDeleter(t *testing.T) {
 
 	checkEvents("start full-scan")
 	checkEvents("end full-scan", "start waitAndDelete")
-	checkDirectory("foo.partial", "bar.partial", "buzz.deleted")
+	checkDirectory("foo.partial", "bar.partial", "buzz.deleted", "photos.n1.partial")
 
 	advance(deleteDelay / 2)
-	checkDirectory("foo.partial", "bar.partial", "buzz.deleted")
+	checkDirectory("foo.partial", "bar.partial", "buzz.deleted", "photos.n1.partial")
 	advance(deleteDelay / 2)
-	checkEvents("deleted foo.partial", "deleted bar.partial", "deleted buzz.deleted")
+	checkEvents("deleted foo.partial", "deleted bar.partial", "deleted buzz.deleted", "deleted photos.n1.partial")
 	checkEvents("end waitAndDelete")
 	checkDirectory()
 
@@ -135,7 +135,7 @@ func (e *Extension) setMgrLocked(mgr *manager) {
 	}
 }
 
-func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, _ ipn.PrefsView, sameNode bool) {
+func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, prefs ipn.PrefsView, sameNode bool) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -149,6 +149,7 @@ func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, _ ipn.PrefsVie
 	}
 
 	if sameNode && e.manager() != nil {
+		e.manager().SetRules(prefs.TaildropRules())
 		return
 	}
 
@@ -174,14 +175,16 @@ func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, _ ipn.PrefsVie
 		}
 	}
 
-	e.setMgrLocked(managerOptions{
+	mgr := managerOptions{
 		Logf:           e.logf,
 		Clock:          tstime.DefaultClock{Clock: e.sb.Clock()},
 		State:          e.stateStore,
 		DirectFileMode: isDirectFileMode,
 		fileOps:        fops,
 		SendFileNotify: e.sendFileNotify,
-	}.New())
+	}.New()
+	mgr.SetRules(prefs.TaildropRules())
+	e.setMgrLocked(mgr)
 }
 
 // fileRoot returns where to store Taildrop files for the given user and whether
@@ -38,4 +38,13 @@ type FileOps interface {
 	OpenReader(name string) (io.ReadCloser, error)
 }
 
+// dirFileOps is implemented by FileOps that are backed by a directory on the
+// local filesystem. Receiving directory trees requires it.
+type dirFileOps interface {
+	FileOps
+
+	// RootDir returns the absolute path of the receiver's root directory.
+	RootDir() string
+}
+
 var newFileOps func(dir string) (FileOps, error)
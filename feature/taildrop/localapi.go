@@ -7,6 +7,7 @@
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"maps"
@@ -29,16 +30,19 @@
 	"tailscale.com/util/mak"
 	"tailscale.com/util/progresstracking"
 	"tailscale.com/util/rands"
+	"tailscale.com/util/set"
 )
 
 func init() {
 	localapi.Register("file-put/", serveFilePut)
+	localapi.Register("file-put-tree/", serveFilePutTree)
 	localapi.Register("files/", serveFiles)
 	localapi.Register("file-targets", serveFileTargets)
 }
 
 var (
-	metricFilePutCalls = clientmetric.NewCounter("localapi_file_put")
+	metricFilePutCalls     = clientmetric.NewCounter("localapi_file_put")
+	metricFilePutTreeCalls = clientmetric.NewCounter("localapi_file_put_tree")
 )
 
 // serveFilePut sends a file to another node.
@@ -86,12 +90,6 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fts, err := ext.FileTargets()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	upath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-put/")
 	if !ok {
 		http.Error(w, "misconfigured", http.StatusInternalServerError)
@@ -110,20 +108,8 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	}
 	peerID := tailcfg.StableNodeID(peerIDStr)
 
-	var ft *apitype.FileTarget
-	for _, x := range fts {
-		if x.Node.StableID == peerID {
-			ft = x
-			break
-		}
-	}
-	if ft == nil {
-		http.Error(w, "node not found", http.StatusNotFound)
-		return
-	}
-	dstURL, err := url.Parse(ft.PeerAPIURL)
-	if err != nil {
-		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
+	dstURL, ok := fileTargetURL(ext, w, peerID)
+	if !ok {
 		return
 	}
 
@@ -166,6 +152,34 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// fileTargetURL returns the PeerAPI base URL of the file target peerID.
+// If peerID isn't a valid file target, it writes an error to w and returns
+// false.
+func fileTargetURL(ext *Extension, w http.ResponseWriter, peerID tailcfg.StableNodeID) (_ *url.URL, ok bool) {
+	fts, err := ext.FileTargets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	var ft *apitype.FileTarget
+	for _, x := range fts {
+		if x.Node.StableID == peerID {
+			ft = x
+			break
+		}
+	}
+	if ft == nil {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return nil, false
+	}
+	dstURL, err := url.Parse(ft.PeerAPIURL)
+	if err != nil {
+		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
+		return nil, false
+	}
+	return dstURL, true
+}
+
 func multiFilePost(h *localapi.Handler, progressUpdates chan (ipn.OutgoingFile), w http.ResponseWriter, r *http.Request, peerID tailcfg.StableNodeID, dstURL *url.URL) {
 	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
@@ -356,6 +370,190 @@ func singleFilePut(
 	return true
 }
 
+// serveFilePutTree sends a directory tree to another node.
+//
+// The request body is multipart/form-data. The first part must be the
+// application/json apitype.FileTreeManifest of the tree. It's followed by one
+// part per regular file in the manifest, whose form name is the file's path.
+// Files that the receiver already has from an earlier attempt are read but not
+// sent again. The response is the JSON apitype.FileTreeResult.
+//
+// URL format:
+//
+//   - POST /localapi/v0/file-put-tree/:stableID
+func serveFilePutTree(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	metricFilePutTreeCalls.Add(1)
+
+	if !h.PermitWrite {
+		http.Error(w, "file access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST to put directory", http.StatusBadRequest)
+		return
+	}
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "misconfigured taildrop extension", http.StatusInternalServerError)
+		return
+	}
+	peerIDStr, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-put-tree/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	peerID := tailcfg.StableNodeID(peerIDStr)
+	dstURL, ok := fileTargetURL(ext, w, peerID)
+	if !ok {
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid Content-Type for multipart POST: %s", err), http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode multipart/form-data: %s", err), http.StatusBadRequest)
+		return
+	}
+	if part.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "first MIME part must be a JSON manifest", http.StatusBadRequest)
+		return
+	}
+	var tm apitype.FileTreeManifest
+	if err := json.NewDecoder(part).Decode(&tm); err != nil {
+		http.Error(w, fmt.Sprintf("invalid manifest: %s", err), http.StatusBadRequest)
+		return
+	}
+	files := make(map[string]apitype.FileTreeEntry)
+	var totalSize int64
+	for _, e := range tm.Entries {
+		if !e.Mode.IsDir() {
+			files[e.Path] = e
+			totalSize += e.Size
+		}
+	}
+
+	outgoing := &ipn.OutgoingFile{
+		ID:           rands.HexString(30),
+		PeerID:       peerID,
+		Name:         tm.Name,
+		Started:      time.Now(),
+		DeclaredSize: totalSize,
+	}
+	updateProgress := func() {
+		f := *outgoing
+		ext.updateOutgoingFiles(map[string]*ipn.OutgoingFile{f.ID: &f})
+	}
+	defer func() {
+		outgoing.Finished = true
+		updateProgress()
+	}()
+
+	pc := &peerTreeClient{
+		ctx:     r.Context(),
+		baseURL: dstURL.String() + "/v0/put-tree/" + url.PathEscape(tm.Name),
+		client:  &http.Client{Transport: h.LocalBackend().Dialer().PeerAPITransport()},
+	}
+	manifest, err := json.Marshal(tm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var begin treeBeginResponse
+	if err := pc.do("PUT", "", bytes.NewReader(manifest), int64(len(manifest)), &begin); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	have := set.Of(begin.Have...)
+	updateProgress()
+
+	resumed := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode multipart/form-data: %s", err), http.StatusBadRequest)
+			return
+		}
+		p := part.FormName()
+		e, ok := files[p]
+		if !ok {
+			http.Error(w, fmt.Sprintf("file %q not in manifest", p), http.StatusBadRequest)
+			return
+		}
+		if have.Contains(p) {
+			io.Copy(io.Discard, part)
+			resumed++
+		} else {
+			if err := pc.do("PUT", "/"+escapeTreePath(p), part, e.Size, nil); err != nil {
+				http.Error(w, fmt.Sprintf("sending %q: %v", p, err), http.StatusBadGateway)
+				return
+			}
+		}
+		outgoing.Sent += e.Size
+		updateProgress()
+	}
+
+	var res apitype.FileTreeResult
+	if err := pc.do("POST", "", nil, 0, &res); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	res.Resumed = resumed
+	outgoing.Succeeded = res.Verified
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// escapeTreePath URL path-escapes each element of the slash-separated path p.
+func escapeTreePath(p string) string {
+	elems := strings.Split(p, "/")
+	for i, e := range elems {
+		elems[i] = url.PathEscape(e)
+	}
+	return strings.Join(elems, "/")
+}
+
+// peerTreeClient makes requests to a peer's /v0/put-tree/ PeerAPI handler.
+type peerTreeClient struct {
+	ctx     context.Context
+	baseURL string // through the tree's escaped name
+	client  *http.Client
+}
+
+// do sends a request with the given method to the tree's URL with suffix
+// appended, and decodes the JSON response into res, if non-nil.
+func (pc *peerTreeClient) do(method, suffix string, body io.Reader, size int64, res any) error {
+	req, err := http.NewRequestWithContext(pc.ctx, method, pc.baseURL+suffix, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	resp, err := pc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && suffix == "" && method == "PUT" {
+		return errors.New("peer does not support receiving directories")
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if res == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
 func serveFiles(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "file access denied", http.StatusForbidden)
@@ -407,11 +605,16 @@ func serveFiles(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(wfs)
 		return
 	}
+	suffix, treePath, inTree := strings.Cut(suffix, "/")
 	name, err := url.PathUnescape(suffix)
 	if err != nil {
 		http.Error(w, "bad filename", http.StatusBadRequest)
 		return
 	}
+	if inTree {
+		serveTreeFile(ext, w, r, name, treePath)
+		return
+	}
 	if r.Method == "DELETE" {
 		if err := ext.DeleteFile(name); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -431,6 +634,40 @@ func serveFiles(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, rc)
 }
 
+// serveTreeFile serves the contents of the waiting directory tree name.
+// If escapedPath is empty, it serves the tree's JSON apitype.FileTreeManifest.
+// Otherwise, it serves the file at that path in the tree.
+func serveTreeFile(ext *Extension, w http.ResponseWriter, r *http.Request, name, escapedPath string) {
+	if r.Method != "GET" {
+		http.Error(w, "want GET to read directory", http.StatusBadRequest)
+		return
+	}
+	if escapedPath == "" {
+		tm, err := ext.manager().WaitingTree(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tm)
+		return
+	}
+	p, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		http.Error(w, "bad filename", http.StatusBadRequest)
+		return
+	}
+	rc, size, err := ext.manager().OpenTreeFile(name, p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Length", fmt.Sprint(size))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, rc)
+}
+
 func serveFileTargets(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "access denied", http.StatusForbidden)
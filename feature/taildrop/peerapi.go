@@ -5,6 +5,7 @@
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@
 	"strings"
 	"time"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
@@ -21,10 +23,12 @@
 
 func init() {
 	ipnlocal.RegisterPeerAPIHandler("/v0/put/", handlePeerPut)
+	ipnlocal.RegisterPeerAPIHandler("/v0/put-tree/", handlePeerPutTree)
 }
 
 var (
-	metricPutCalls = clientmetric.NewCounter("peerapi_put")
+	metricPutCalls     = clientmetric.NewCounter("peerapi_put")
+	metricPutTreeCalls = clientmetric.NewCounter("peerapi_put_tree")
 )
 
 // canPutFile reports whether h can put a file ("Taildrop") to this node.
@@ -51,6 +55,7 @@ type extensionForPut interface {
 	manager() *manager
 	hasCapFileSharing() bool
 	Clock() tstime.Clock
+	fileSender(ipnlocal.PeerAPIHandler) fileSender
 }
 
 func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter, r *http.Request) {
@@ -138,13 +143,13 @@ func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w
 			}
 			offset = ranges[0].Start
 		}
-		n, err := taildropMgr.PutFile(clientID(fmt.Sprint(id)), baseName, r.Body, offset, r.ContentLength)
+		n, err := taildropMgr.PutFileFrom(clientID(fmt.Sprint(id)), ext.fileSender(h), baseName, r.Body, offset, r.ContentLength)
 		switch err {
 		case nil:
 			d := ext.Clock().Since(t0).Round(time.Second / 10)
 			h.Logf("got put of %s in %v from %v/%v", approxSize(n), d, h.RemoteAddr().Addr(), h.Peer().ComputedName)
 			io.WriteString(w, "{}\n")
-		case ErrNoTaildrop:
+		case ErrNoTaildrop, ErrRejected:
 			http.Error(w, err.Error(), http.StatusForbidden)
 		case ErrInvalidFileName:
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -158,6 +163,127 @@ func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w
 	}
 }
 
+// fileSender returns the sender of a file being put over h, for matching
+// against the receiver's Taildrop rules.
+func (e *Extension) fileSender(h ipnlocal.PeerAPIHandler) fileSender {
+	s := fileSender{node: h.Peer()}
+	if _, u, ok := h.LocalBackend().WhoIs("tcp", h.RemoteAddr()); ok {
+		s.login = u.LoginName
+	}
+	return s
+}
+
+func handlePeerPutTree(h ipnlocal.PeerAPIHandler, w http.ResponseWriter, r *http.Request) {
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "miswired", http.StatusInternalServerError)
+		return
+	}
+	handlePeerPutTreeWithBackend(h, ext, w, r)
+}
+
+// treeBeginResponse is the response to a PUT of a tree manifest to
+// /v0/put-tree/:name.
+type treeBeginResponse struct {
+	// Have are the paths of files that the receiver already has from an
+	// earlier attempt, which needn't be sent again.
+	Have []string `json:",omitempty"`
+}
+
+// handlePeerPutTreeWithBackend handles the receiving side of a directory tree
+// transfer, which consists of:
+//
+//   - PUT /v0/put-tree/:name with an apitype.FileTreeManifest body, responding
+//     with a treeBeginResponse
+//   - PUT /v0/put-tree/:name/:path for each file in the manifest that the
+//     receiver doesn't already have
+//   - POST /v0/put-tree/:name to verify the tree and move it into place,
+//     responding with an apitype.FileTreeResult
+//
+// Each path element is URL path-escaped.
+func handlePeerPutTreeWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter, r *http.Request) {
+	metricPutTreeCalls.Add(1)
+
+	taildropMgr := ext.manager()
+	if taildropMgr == nil {
+		h.Logf("taildrop: no taildrop manager")
+		http.Error(w, "failed to get taildrop manager", http.StatusInternalServerError)
+		return
+	}
+	if !canPutFile(h) || !ext.hasCapFileSharing() {
+		http.Error(w, ErrNoTaildrop.Error(), http.StatusForbidden)
+		return
+	}
+	rawPath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/v0/put-tree/")
+	if !ok {
+		http.Error(w, "misconfigured internals", http.StatusForbidden)
+		return
+	}
+	rawName, rawFilePath, isFile := strings.Cut(rawPath, "/")
+	name, err := url.PathUnescape(rawName)
+	if err != nil {
+		http.Error(w, ErrInvalidFileName.Error(), http.StatusBadRequest)
+		return
+	}
+	filePath, err := url.PathUnescape(rawFilePath)
+	if err != nil {
+		http.Error(w, ErrInvalidFileName.Error(), http.StatusBadRequest)
+		return
+	}
+	id := clientID(h.Peer().StableID())
+
+	var res any
+	switch {
+	case r.Method == "PUT" && !isFile:
+		var tm apitype.FileTreeManifest
+		if err = json.NewDecoder(io.LimitReader(r.Body, 64<<20)).Decode(&tm); err != nil {
+			http.Error(w, "invalid manifest: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if tm.Name != name {
+			http.Error(w, "manifest name does not match URL", http.StatusBadRequest)
+			return
+		}
+		var have []string
+		have, err = taildropMgr.BeginTree(id, &tm)
+		res = treeBeginResponse{Have: have}
+	case r.Method == "PUT":
+		t0 := ext.Clock().Now()
+		var n int64
+		n, err = taildropMgr.PutTreeFile(id, name, filePath, r.Body)
+		if err == nil {
+			d := ext.Clock().Since(t0).Round(time.Second / 10)
+			h.Logf("got tree file put of %s in %v from %v/%v", approxSize(n), d, h.RemoteAddr().Addr(), h.Peer().ComputedName)
+		}
+		res = struct{}{}
+	case r.Method == "POST" && !isFile:
+		var tr apitype.FileTreeResult
+		tr, err = taildropMgr.FinishTree(id, name)
+		if err == nil {
+			h.Logf("got tree of %d files (%s) from %v/%v", tr.Files, approxSize(tr.Bytes), h.RemoteAddr().Addr(), h.Peer().ComputedName)
+		}
+		res = tr
+	default:
+		http.Error(w, "expected method PUT or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	case errors.Is(err, ErrNoTaildrop), errors.Is(err, ErrTreesUnsupported):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrTreeNotStarted):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrTreeIncomplete):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidFileName), errors.Is(err, ErrInvalidManifest), errors.Is(err, ErrTreeFileMismatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func approxSize(n int64) string {
 	if n <= 1<<10 {
 		return "<=1KB"
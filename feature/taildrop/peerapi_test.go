@@ -66,6 +66,9 @@ func (lb *fakeExtension) Clock() tstime.Clock { return lb.clock }
 func (lb *fakeExtension) hasCapFileSharing() bool {
 	return lb.capFileSharing
 }
+func (lb *fakeExtension) fileSender(h ipnlocal.PeerAPIHandler) fileSender {
+	return fileSender{node: h.Peer()}
+}
 
 type peerAPITestEnv struct {
 	taildrop *manager
@@ -39,6 +39,9 @@ func (m *manager) HasFilesWaiting() bool {
 	if err != nil {
 		return false
 	}
+	if trees, _ := m.waitingTrees(); len(trees) > 0 {
+		return true
+	}
 
 	// Build a set of filenames present in Dir
 	fileSet := set.Of(files...)
@@ -91,6 +94,11 @@ func (m *manager) WaitingFiles() ([]apitype.WaitingFile, error) {
 			Size: fi.Size(),
 		})
 	}
+	trees, err := m.waitingTrees()
+	if err != nil {
+		return nil, err
+	}
+	ret = append(ret, trees...)
 	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
 	return ret, nil
 }
@@ -105,6 +113,10 @@ func (m *manager) DeleteFile(baseName string) error {
 		return errors.New("deletes not allowed in direct mode")
 	}
 
+	if fi, err := m.opts.fileOps.Stat(baseName); err == nil && fi.IsDir() {
+		return m.deleteTree(baseName)
+	}
+
 	var bo *backoff.Backoff
 	logf := m.opts.Logf
 	t0 := m.opts.Clock.Now()
@@ -0,0 +1,170 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/views"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/dnsname"
+)
+
+// ErrRejected is returned when an incoming file is rejected by one of the
+// receiver's Taildrop rules.
+var ErrRejected = errors.New("file rejected by receiver")
+
+// ruleCommandTimeout is how long a command run by a TaildropActionExec rule
+// may run before it's killed.
+const ruleCommandTimeout = time.Minute
+
+// fileSender identifies the sender of an incoming file, for matching against
+// [ipn.TaildropRule.From].
+type fileSender struct {
+	node  tailcfg.NodeView // or invalid if unknown
+	login string           // login name of node's user; empty if unknown
+}
+
+// matches reports whether s is described by from, as documented on
+// [ipn.TaildropRule.From].
+func (s fileSender) matches(from string) bool {
+	if !s.node.Valid() {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(from, "tag:"):
+		return views.SliceContains(s.node.Tags(), from)
+	case strings.Contains(from, "@"):
+		return !s.node.IsTagged() && s.login != "" && strings.EqualFold(s.login, from)
+	default:
+		name := strings.TrimSuffix(s.node.Name(), ".")
+		from = strings.TrimSuffix(from, ".")
+		return name != "" && (strings.EqualFold(name, from) || strings.EqualFold(dnsname.FirstLabel(name), from))
+	}
+}
+
+// ruleMatches reports whether the rule r applies to the file baseName of the
+// given size from the sender from.
+func ruleMatches(r ipn.TaildropRuleView, from fileSender, baseName string, size int64) bool {
+	if r.From().Len() > 0 && !r.From().ContainsFunc(from.matches) {
+		return false
+	}
+	if r.Ext().Len() > 0 {
+		ext := strings.TrimPrefix(filepath.Ext(baseName), ".")
+		if ext == "" || !r.Ext().ContainsFunc(func(want string) bool {
+			return strings.EqualFold(strings.TrimPrefix(want, "."), ext)
+		}) {
+			return false
+		}
+	}
+	if r.MinSize() > 0 && size < r.MinSize() {
+		return false
+	}
+	if r.MaxSize() > 0 && size > r.MaxSize() {
+		return false
+	}
+	return true
+}
+
+// SetRules sets the rules for handling incoming files.
+func (m *manager) SetRules(rules views.SliceView[*ipn.TaildropRule, ipn.TaildropRuleView]) {
+	if m != nil {
+		m.rules.Store(rules)
+	}
+}
+
+// matchRule returns the first of m's rules that applies to the file baseName
+// of the given size from the sender from, if any.
+func (m *manager) matchRule(from fileSender, baseName string, size int64) (_ ipn.TaildropRuleView, ok bool) {
+	for _, r := range m.rules.Load().All() {
+		if ruleMatches(r, from, baseName, size) {
+			return r, true
+		}
+	}
+	return ipn.TaildropRuleView{}, false
+}
+
+// ruleMoveMu serializes moves by TaildropActionMove rules, so that two files
+// with the same name can't both be moved to the same destination.
+var ruleMoveMu sync.Mutex
+
+// moveToRuleDir moves the fully received file at partialPath into dir as
+// baseName, picking a new name if a file by that name already exists.
+// It returns the path of the moved file.
+func moveToRuleDir(partialPath, dir, baseName string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	ruleMoveMu.Lock()
+	defer ruleMoveMu.Unlock()
+	name := baseName
+	for i := 0; ; i++ {
+		if _, err := os.Lstat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			break
+		}
+		if i == 10 {
+			return "", fmt.Errorf("too many retries trying to move %q", baseName)
+		}
+		name = nextFilename(name)
+	}
+	dst := filepath.Join(dir, name)
+	if err := os.Rename(partialPath, dst); err == nil {
+		return dst, nil
+	}
+
+	// The rename most likely failed because dir is on another filesystem,
+	// so copy instead.
+	src, err := os.Open(partialPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		os.Remove(dst)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	src.Close()
+	return dst, os.Remove(partialPath)
+}
+
+// runRuleCommand runs the command of a TaildropActionExec rule for the
+// received file at path.
+func (m *manager) runRuleCommand(command views.Slice[string], path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), ruleCommandTimeout)
+	defer cancel()
+	args := append(command.AsSlice()[1:], path)
+	out, err := exec.CommandContext(ctx, command.At(0), args...).CombinedOutput()
+	if err != nil {
+		metricRuleCommandErrors.Add(1)
+		m.opts.Logf("rule command %q failed: %v; output: %q", command.At(0), err, out)
+	}
+}
+
+var (
+	metricRuleMoved         = clientmetric.NewCounter("taildrop_rule_moved")
+	metricRuleRejected      = clientmetric.NewCounter("taildrop_rule_rejected")
+	metricRuleCommands      = clientmetric.NewCounter("taildrop_rule_commands")
+	metricRuleCommandErrors = clientmetric.NewCounter("taildrop_rule_command_errors")
+)
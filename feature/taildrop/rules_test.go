@@ -0,0 +1,102 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/views"
+	"tailscale.com/util/must"
+)
+
+func TestRuleMatches(t *testing.T) {
+	alice := fileSender{
+		node:  (&tailcfg.Node{Name: "laptop.example.ts.net."}).View(),
+		login: "alice@example.com",
+	}
+	server := fileSender{
+		node: (&tailcfg.Node{Name: "server.example.ts.net.", Tags: []string{"tag:server"}}).View(),
+	}
+	tests := []struct {
+		name string
+		rule ipn.TaildropRule
+		from fileSender
+		file string
+		size int64
+		want bool
+	}{
+		{"empty", ipn.TaildropRule{}, alice, "a.txt", 1, true},
+		{"login", ipn.TaildropRule{From: []string{"ALICE@example.com"}}, alice, "a.txt", 1, true},
+		{"login-tagged", ipn.TaildropRule{From: []string{"alice@example.com"}}, fileSender{node: server.node, login: "alice@example.com"}, "a.txt", 1, false},
+		{"tag", ipn.TaildropRule{From: []string{"tag:server"}}, server, "a.txt", 1, true},
+		{"tag-mismatch", ipn.TaildropRule{From: []string{"tag:server"}}, alice, "a.txt", 1, false},
+		{"node-base", ipn.TaildropRule{From: []string{"laptop"}}, alice, "a.txt", 1, true},
+		{"node-fqdn", ipn.TaildropRule{From: []string{"laptop.example.ts.net"}}, alice, "a.txt", 1, true},
+		{"unknown-sender", ipn.TaildropRule{From: []string{"laptop"}}, fileSender{}, "a.txt", 1, false},
+		{"ext", ipn.TaildropRule{Ext: []string{".jpg", "png"}}, alice, "a.PNG", 1, true},
+		{"ext-mismatch", ipn.TaildropRule{Ext: []string{".jpg"}}, alice, "a.jpg.txt", 1, false},
+		{"ext-none", ipn.TaildropRule{Ext: []string{".jpg"}}, alice, "jpg", 1, false},
+		{"min-size", ipn.TaildropRule{MinSize: 10}, alice, "a.txt", 9, false},
+		{"max-size", ipn.TaildropRule{MaxSize: 10}, alice, "a.txt", 10, true},
+		{"max-size-exceeded", ipn.TaildropRule{MaxSize: 10}, alice, "a.txt", 11, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ruleMatches(tt.rule.View(), tt.from, tt.file, tt.size); got != tt.want {
+				t.Errorf("ruleMatches = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPutFileRules(t *testing.T) {
+	dir := t.TempDir()
+	moveDir := filepath.Join(t.TempDir(), "photos")
+	m := managerOptions{Logf: t.Logf, fileOps: must.Get(newFileOps(dir))}.New()
+	defer m.Shutdown()
+	m.SetRules(views.SliceOfViews([]*ipn.TaildropRule{
+		{Ext: []string{".exe"}, Action: ipn.TaildropActionReject},
+		{Ext: []string{".jpg"}, MaxSize: 5, Action: ipn.TaildropActionInbox},
+		{Ext: []string{".jpg"}, Action: ipn.TaildropActionMove, Dir: moveDir},
+	}))
+
+	put := func(name, content string) error {
+		_, err := m.PutFileFrom("", fileSender{}, name, strings.NewReader(content), 0, int64(len(content)))
+		return err
+	}
+	if err := put("setup.exe", "MZ"); err != ErrRejected {
+		t.Errorf("PutFile of rejected file = %v; want %v", err, ErrRejected)
+	}
+	must.Do(put("small.jpg", "tiny"))
+	must.Do(put("big.jpg", "big photo"))
+	must.Do(put("big.jpg", "another big photo"))
+
+	// The rejected file isn't counted as received; the moved ones are.
+	if got := m.totalReceived.Load(); got != 3 {
+		t.Errorf("totalReceived = %d; want 3", got)
+	}
+	if got := must.Get(m.WaitingFiles()); len(got) != 1 || got[0].Name != "small.jpg" {
+		t.Errorf("WaitingFiles = %+v; want just small.jpg", got)
+	}
+	des := must.Get(os.ReadDir(dir))
+	for _, de := range des {
+		if de.Name() != "small.jpg" {
+			t.Errorf("unexpected file %q left in inbox", de.Name())
+		}
+	}
+	for name, want := range map[string]string{
+		"big.jpg":     "big photo",
+		"big (1).jpg": "another big photo",
+	} {
+		got := must.Get(os.ReadFile(filepath.Join(moveDir, name)))
+		if string(got) != want {
+			t.Errorf("%s = %q; want %q", name, got, want)
+		}
+	}
+}
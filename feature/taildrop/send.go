@@ -71,7 +71,15 @@ func (f *incomingFile) Write(p []byte) (n int, err error) {
 // a partial file. While resuming, PutFile may be called again with a non-zero
 // offset to specify where to resume receiving data at.
 func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, length int64) (fileLength int64, err error) {
+	return m.PutFileFrom(id, fileSender{}, baseName, r, offset, length)
+}
 
+// PutFileFrom is like PutFile, but also applies the receiver's rules (see
+// SetRules) for files from the sender from to the file once it's been
+// received.
+//
+// If a rule rejects the file, it returns ErrRejected.
+func (m *manager) PutFileFrom(id clientID, from fileSender, baseName string, r io.Reader, offset, length int64) (fileLength int64, err error) {
 	switch {
 	case m == nil || m.opts.fileOps == nil:
 		return 0, ErrNoTaildrop
@@ -152,6 +160,33 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	inFile.done = true
 	inFile.mu.Unlock()
 
+	rule, matched := m.matchRule(from, baseName, fileLength)
+	if matched {
+		switch rule.Action() {
+		case ipn.TaildropActionReject:
+			metricRuleRejected.Add(1)
+			m.opts.Logf("rejected %q by rule", redactString(baseName))
+			if err := m.opts.fileOps.Remove(partialName); err != nil {
+				m.opts.Logf("failed to remove rejected file: %v", redactError(err))
+			}
+			return 0, ErrRejected
+		case ipn.TaildropActionMove:
+			if _, ok := m.opts.fileOps.(dirFileOps); !ok {
+				m.opts.Logf("can't move files by rule on this platform; keeping in inbox")
+				break
+			}
+			finalPath, err := moveToRuleDir(partialPath, rule.Dir(), baseName)
+			if err != nil {
+				return 0, m.redactAndLogError("Move", err)
+			}
+			metricRuleMoved.Add(1)
+			inFile.finalPath = finalPath
+			m.totalReceived.Add(1)
+			m.opts.SendFileNotify()
+			return fileLength, nil
+		}
+	}
+
 	// 6) Finalize (rename/move) the partial into place via FileOps.Rename
 	finalPath, err := m.opts.fileOps.Rename(partialPath, baseName)
 	if err != nil {
@@ -159,6 +194,11 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	}
 	inFile.finalPath = finalPath
 
+	if matched && rule.Action() == ipn.TaildropActionExec {
+		metricRuleCommands.Add(1)
+		go m.runRuleCommand(rule.Command(), finalPath)
+	}
+
 	m.totalReceived.Add(1)
 	m.opts.SendFileNotify()
 	return fileLength, nil
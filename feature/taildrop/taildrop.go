@@ -27,6 +27,7 @@
 	"tailscale.com/syncs"
 	"tailscale.com/tstime"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/views"
 	"tailscale.com/util/multierr"
 )
 
@@ -109,6 +110,9 @@ type manager struct {
 	// emptySince specifies that there were no waiting files
 	// since this value of totalReceived.
 	emptySince atomic.Int64
+
+	// rules are the rules for handling incoming files; see SetRules.
+	rules syncs.AtomicValue[views.SliceView[*ipn.TaildropRule, ipn.TaildropRuleView]]
 }
 
 // New initializes a new taildrop manager.
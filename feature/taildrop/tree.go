@@ -0,0 +1,485 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// Directory trees are received into a staging directory next to where the
+// tree will end up, named like a partial file (e.g. "photos.n12345CNTRL.partial"),
+// so that it's invisible to [manager.WaitingFiles] and [manager.PartialFiles].
+// The staging directory holds the manifest the tree is received against, and
+// each file of the tree at its relative path once its size and SHA-256 have
+// been verified. Files still being received carry a partialSuffix.
+//
+// Once every file is present, [manager.FinishTree] fixes up modes, removes
+// the manifest and renames the staging directory into place in one step, so
+// the tree never appears half-written.
+//
+// Like partial files, staging directories found on startup are removed by
+// the [fileDeleter] after deleteDelay, unless their transfer is resumed.
+
+// treeManifestName is the name of the file in a tree's staging directory that
+// holds the manifest it's being received against.
+const treeManifestName = ".taildrop-manifest.json"
+
+// maxTreeEntries is the maximum number of entries in a received tree.
+const maxTreeEntries = 100_000
+
+// maxTreeSize is the maximum total size, in bytes, of the files in a
+// received tree.
+const maxTreeSize = 64 << 30
+
+var (
+	ErrTreesUnsupported = errors.New("directory transfers not supported by this node")
+	ErrTreeNotStarted   = errors.New("no such directory transfer in progress")
+	ErrTreeIncomplete   = errors.New("directory transfer is missing files")
+	ErrTreeFileMismatch = errors.New("file does not match manifest")
+	ErrInvalidManifest  = errors.New("invalid manifest")
+)
+
+// treeRenameMu serializes the final renames of trees, so that two trees with
+// the same name can't both be moved to the same destination.
+var treeRenameMu sync.Mutex
+
+// treeRoot returns the local directory that trees are received into.
+func (m *manager) treeRoot() (string, error) {
+	if m == nil || m.opts.fileOps == nil {
+		return "", ErrNoTaildrop
+	}
+	dfo, ok := m.opts.fileOps.(dirFileOps)
+	if !ok {
+		return "", ErrTreesUnsupported
+	}
+	return dfo.RootDir(), nil
+}
+
+// validateTreePath reports whether p is a valid slash-separated path of an
+// entry in a tree.
+func validateTreePath(p string) error {
+	if p == "" || len(p) > 4096 {
+		return ErrInvalidFileName
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if err := validateBaseName(elem); err != nil {
+			return err
+		}
+		if elem == treeManifestName {
+			return ErrInvalidFileName
+		}
+	}
+	return nil
+}
+
+// validateTreeManifest checks that tm is well-formed and safe to receive, and
+// returns its entries keyed by path.
+func validateTreeManifest(tm *apitype.FileTreeManifest) (map[string]apitype.FileTreeEntry, error) {
+	if err := validateBaseName(tm.Name); err != nil {
+		return nil, err
+	}
+	if len(tm.Entries) > maxTreeEntries {
+		return nil, fmt.Errorf("%w: too many entries: %d > %d", ErrInvalidManifest, len(tm.Entries), maxTreeEntries)
+	}
+	entries := make(map[string]apitype.FileTreeEntry, len(tm.Entries))
+	var size int64
+	for _, e := range tm.Entries {
+		if err := validateTreePath(e.Path); err != nil {
+			return nil, fmt.Errorf("%w: %q", err, e.Path)
+		}
+		if _, dup := entries[e.Path]; dup {
+			return nil, fmt.Errorf("%w: duplicate entry %q", ErrInvalidManifest, e.Path)
+		}
+		if !e.Mode.IsDir() {
+			if e.Size < 0 {
+				return nil, fmt.Errorf("%w: invalid size for %q", ErrInvalidManifest, e.Path)
+			}
+			if b, err := hex.DecodeString(e.SHA256); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("%w: invalid SHA-256 for %q", ErrInvalidManifest, e.Path)
+			}
+			if e.Size > maxTreeSize-size {
+				return nil, fmt.Errorf("%w: files total more than %d bytes", ErrInvalidManifest, int64(maxTreeSize))
+			}
+			size += e.Size
+		}
+		entries[e.Path] = e
+	}
+	// Make sure no file is also used as a directory.
+	for p := range entries {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if e, ok := entries[dir]; ok && !e.Mode.IsDir() {
+				return nil, fmt.Errorf("%w: entry %q is both a file and a directory", ErrInvalidManifest, dir)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// treeStagingDir returns the staging directory for the tree name from id.
+func treeStagingDir(root string, id clientID, name string) string {
+	return filepath.Join(root, name+id.partialSuffix())
+}
+
+// readTreeManifest reads the manifest from the staging directory dir.
+func readTreeManifest(dir string) (*apitype.FileTreeManifest, map[string]apitype.FileTreeEntry, error) {
+	b, err := os.ReadFile(filepath.Join(dir, treeManifestName))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrTreeNotStarted
+	} else if err != nil {
+		return nil, nil, redactError(err)
+	}
+	tm := new(apitype.FileTreeManifest)
+	if err := json.Unmarshal(b, tm); err != nil {
+		return nil, nil, err
+	}
+	entries, err := validateTreeManifest(tm)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tm, entries, nil
+}
+
+// fileMatches reports whether the regular file at path has the size and
+// SHA-256 of e.
+func fileMatches(path string, e apitype.FileTreeEntry) bool {
+	fi, err := os.Lstat(path)
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() != e.Size {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == e.SHA256
+}
+
+// BeginTree starts receiving the directory tree described by tm from the
+// client id, or resumes receiving it if an earlier attempt with the same
+// manifest was interrupted.
+//
+// It returns the paths of the files that were already received and verified,
+// which the client needn't send again.
+func (m *manager) BeginTree(id clientID, tm *apitype.FileTreeManifest) (have []string, err error) {
+	root, err := m.treeRoot()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := validateTreeManifest(tm); err != nil {
+		return nil, err
+	}
+	manifest, err := json.Marshal(tm)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := treeStagingDir(root, id, tm.Name)
+	// Don't let the deleter remove a staging directory left from before a
+	// restart, now that its transfer is being resumed.
+	m.deleter.Remove(filepath.Base(dir))
+	if old, err := os.ReadFile(filepath.Join(dir, treeManifestName)); err == nil && !bytes.Equal(old, manifest) {
+		// The client is sending something else under the same name;
+		// start over.
+		m.opts.Logf("tree manifest changed; discarding earlier partial transfer")
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, m.redactAndLogError("RemoveAll", err)
+		}
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, m.redactAndLogError("Mkdir", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, treeManifestName), manifest, 0o600); err != nil {
+		return nil, m.redactAndLogError("WriteManifest", err)
+	}
+
+	for _, e := range tm.Entries {
+		if e.Mode.IsDir() {
+			continue
+		}
+		if fileMatches(filepath.Join(dir, filepath.FromSlash(e.Path)), e) {
+			have = append(have, e.Path)
+		}
+	}
+	return have, nil
+}
+
+// PutTreeFile receives the file at the slash-separated path p of the tree
+// name that's being received from the client id. The file's contents are read
+// from r and verified against the size and SHA-256 in the tree's manifest.
+func (m *manager) PutTreeFile(id clientID, name, p string, r io.Reader) (n int64, err error) {
+	root, err := m.treeRoot()
+	if err != nil {
+		return 0, err
+	}
+	if err := validateBaseName(name); err != nil {
+		return 0, err
+	}
+	dir := treeStagingDir(root, id, name)
+	_, entries, err := readTreeManifest(dir)
+	if err != nil {
+		return 0, err
+	}
+	e, ok := entries[p]
+	if !ok || e.Mode.IsDir() {
+		return 0, fmt.Errorf("%w: %q not in manifest", ErrInvalidFileName, p)
+	}
+
+	dst := filepath.Join(dir, filepath.FromSlash(p))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return 0, m.redactAndLogError("Mkdir", err)
+	}
+	partial := dst + partialSuffix
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, m.redactAndLogError("Create", err)
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(partial)
+		}
+	}()
+
+	h := sha256.New()
+	// Read one byte more than expected so that overlong files are caught.
+	n, err = io.Copy(io.MultiWriter(f, h), io.LimitReader(r, e.Size+1))
+	if err != nil {
+		return 0, m.redactAndLogError("Copy", err)
+	}
+	if n != e.Size || hex.EncodeToString(h.Sum(nil)) != e.SHA256 {
+		return 0, fmt.Errorf("%w: %q", ErrTreeFileMismatch, p)
+	}
+	if err := f.Close(); err != nil {
+		return 0, m.redactAndLogError("Close", err)
+	}
+	if err := os.Rename(partial, dst); err != nil {
+		return 0, m.redactAndLogError("Rename", err)
+	}
+	return n, nil
+}
+
+// FinishTree completes receiving the tree name from the client id once all of
+// its files have been received. It applies the modes from the manifest and
+// atomically moves the tree into place, picking a new name if one by the same
+// name already exists.
+func (m *manager) FinishTree(id clientID, name string) (res apitype.FileTreeResult, err error) {
+	root, err := m.treeRoot()
+	if err != nil {
+		return res, err
+	}
+	if err := validateBaseName(name); err != nil {
+		return res, err
+	}
+	dir := treeStagingDir(root, id, name)
+	tm, _, err := readTreeManifest(dir)
+	if err != nil {
+		return res, err
+	}
+
+	// Check that everything is present before modifying anything.
+	for _, e := range tm.Entries {
+		if e.Mode.IsDir() {
+			continue
+		}
+		fi, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(e.Path)))
+		if err != nil || !fi.Mode().IsRegular() || fi.Size() != e.Size {
+			return res, fmt.Errorf("%w: %q", ErrTreeIncomplete, e.Path)
+		}
+		res.Files++
+		res.Bytes += e.Size
+	}
+	if err := os.Remove(filepath.Join(dir, treeManifestName)); err != nil {
+		return res, m.redactAndLogError("RemoveManifest", err)
+	}
+
+	// Apply modes deepest-first, so that restrictive directory modes don't
+	// prevent fixing up their contents.
+	entries := append([]apitype.FileTreeEntry(nil), tm.Entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.Count(entries[i].Path, "/") > strings.Count(entries[j].Path, "/")
+	})
+	for _, e := range entries {
+		p := filepath.Join(dir, filepath.FromSlash(e.Path))
+		if e.Mode.IsDir() {
+			if err := os.MkdirAll(p, 0o700); err != nil {
+				return res, m.redactAndLogError("Mkdir", err)
+			}
+		}
+		// Only the permission bits, without setuid and friends, are kept.
+		if err := os.Chmod(p, e.Mode.Perm()); err != nil {
+			return res, m.redactAndLogError("Chmod", err)
+		}
+	}
+	if err := os.Chmod(dir, 0o755); err != nil {
+		return res, m.redactAndLogError("Chmod", err)
+	}
+
+	treeRenameMu.Lock()
+	defer treeRenameMu.Unlock()
+	final := name
+	for i := 0; ; i++ {
+		if _, err := os.Lstat(filepath.Join(root, final)); os.IsNotExist(err) {
+			break
+		}
+		if i == 10 {
+			return res, fmt.Errorf("too many retries trying to rename %q", name)
+		}
+		final = nextFilename(final)
+	}
+	if err := os.Rename(dir, filepath.Join(root, final)); err != nil {
+		return res, m.redactAndLogError("Rename", err)
+	}
+	res.Name = final
+	res.Verified = true
+
+	m.totalReceived.Add(1)
+	m.opts.SendFileNotify()
+	return res, nil
+}
+
+// waitingTrees returns the directory trees waiting in [Handler.Dir].
+func (m *manager) waitingTrees() ([]apitype.WaitingFile, error) {
+	root, err := m.treeRoot()
+	if err == ErrTreesUnsupported {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	des, err := os.ReadDir(root)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	var ret []apitype.WaitingFile
+	for _, de := range des {
+		if !de.IsDir() || isPartialOrDeleted(de.Name()) {
+			continue
+		}
+		wf := apitype.WaitingFile{Name: de.Name(), IsDir: true}
+		filepath.WalkDir(filepath.Join(root, de.Name()), func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				if fi, err := d.Info(); err == nil {
+					wf.Size += fi.Size()
+				}
+			}
+			return nil
+		})
+		ret = append(ret, wf)
+	}
+	return ret, nil
+}
+
+// waitingTreeDir returns the path of the waiting tree name.
+// This method is only allowed when [Handler.DirectFileMode] is false.
+func (m *manager) waitingTreeDir(name string) (string, error) {
+	root, err := m.treeRoot()
+	if err != nil {
+		return "", err
+	}
+	if m.opts.DirectFileMode {
+		return "", errors.New("opens not allowed in direct mode")
+	}
+	if err := validateBaseName(name); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, name)
+	if fi, err := os.Lstat(dir); err != nil {
+		return "", redactError(err)
+	} else if !fi.IsDir() {
+		return "", fmt.Errorf("%w: not a directory", ErrInvalidFileName)
+	}
+	return dir, nil
+}
+
+// WaitingTree returns a manifest of the waiting tree name, without checksums.
+// This method is only allowed when [Handler.DirectFileMode] is false.
+func (m *manager) WaitingTree(name string) (*apitype.FileTreeManifest, error) {
+	dir, err := m.waitingTreeDir(name)
+	if err != nil {
+		return nil, err
+	}
+	tm := &apitype.FileTreeManifest{Name: name}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir || !(d.IsDir() || d.Type().IsRegular()) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		e := apitype.FileTreeEntry{
+			Path: filepath.ToSlash(rel),
+			Mode: fi.Mode() & (fs.ModeDir | fs.ModePerm),
+		}
+		if !d.IsDir() {
+			e.Size = fi.Size()
+		}
+		tm.Entries = append(tm.Entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, redactError(err)
+	}
+	return tm, nil
+}
+
+// OpenTreeFile opens the file at the slash-separated path p in the waiting
+// tree name.
+// This method is only allowed when [Handler.DirectFileMode] is false.
+func (m *manager) OpenTreeFile(name, p string) (rc io.ReadCloser, size int64, err error) {
+	dir, err := m.waitingTreeDir(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := validateTreePath(p); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(p)))
+	if err != nil {
+		return nil, 0, redactError(err)
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		f.Close()
+		if err == nil {
+			err = fmt.Errorf("%w: not a regular file", ErrInvalidFileName)
+		}
+		return nil, 0, redactError(err)
+	}
+	return f, fi.Size(), nil
+}
+
+// deleteTree deletes the waiting tree name.
+func (m *manager) deleteTree(name string) error {
+	dir, err := m.waitingTreeDir(name)
+	if err != nil {
+		return err
+	}
+	return redactError(os.RemoveAll(dir))
+}
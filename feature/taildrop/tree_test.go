@@ -0,0 +1,162 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/util/must"
+)
+
+func treeEntry(p, content string) apitype.FileTreeEntry {
+	sum := sha256.Sum256([]byte(content))
+	return apitype.FileTreeEntry{
+		Path:   p,
+		Mode:   0o644,
+		Size:   int64(len(content)),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+func TestTreeTransfer(t *testing.T) {
+	files := map[string]string{
+		"a.txt":     "hello",
+		"sub/b.txt": "world",
+	}
+	tm := &apitype.FileTreeManifest{
+		Name: "photos",
+		Entries: []apitype.FileTreeEntry{
+			treeEntry("a.txt", files["a.txt"]),
+			{Path: "empty", Mode: fs.ModeDir | 0o755},
+			{Path: "sub", Mode: fs.ModeDir | 0o755},
+			treeEntry("sub/b.txt", files["sub/b.txt"]),
+		},
+	}
+
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, fileOps: must.Get(newFileOps(dir))}.New()
+	defer m.Shutdown()
+	id := clientID("0")
+
+	if _, err := m.FinishTree(id, tm.Name); !errors.Is(err, ErrTreeNotStarted) {
+		t.Fatalf("FinishTree before BeginTree = %v; want %v", err, ErrTreeNotStarted)
+	}
+
+	have := must.Get(m.BeginTree(id, tm))
+	if len(have) != 0 {
+		t.Fatalf("have = %q; want none", have)
+	}
+	must.Get(m.PutTreeFile(id, tm.Name, "a.txt", strings.NewReader(files["a.txt"])))
+	if _, err := m.PutTreeFile(id, tm.Name, "sub/b.txt", strings.NewReader("wrong")); !errors.Is(err, ErrTreeFileMismatch) {
+		t.Fatalf("PutTreeFile with wrong content = %v; want %v", err, ErrTreeFileMismatch)
+	}
+	if _, err := m.PutTreeFile(id, tm.Name, "c.txt", strings.NewReader("")); !errors.Is(err, ErrInvalidFileName) {
+		t.Fatalf("PutTreeFile not in manifest = %v; want %v", err, ErrInvalidFileName)
+	}
+	if _, err := m.FinishTree(id, tm.Name); !errors.Is(err, ErrTreeIncomplete) {
+		t.Fatalf("FinishTree with missing file = %v; want %v", err, ErrTreeIncomplete)
+	}
+	if m.HasFilesWaiting() {
+		t.Fatalf("partially received tree is visible")
+	}
+
+	// Resume the interrupted transfer.
+	have = must.Get(m.BeginTree(id, tm))
+	if want := []string{"a.txt"}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("have = %q; want %q", have, want)
+	}
+	must.Get(m.PutTreeFile(id, tm.Name, "sub/b.txt", strings.NewReader(files["sub/b.txt"])))
+	res := must.Get(m.FinishTree(id, tm.Name))
+	want := apitype.FileTreeResult{Name: "photos", Files: 2, Bytes: 10, Verified: true}
+	if res != want {
+		t.Fatalf("FinishTree = %+v; want %+v", res, want)
+	}
+
+	for p, content := range files {
+		got := must.Get(os.ReadFile(filepath.Join(dir, "photos", filepath.FromSlash(p))))
+		if string(got) != content {
+			t.Errorf("%s = %q; want %q", p, got, content)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "photos", "empty")); err != nil || !fi.IsDir() {
+		t.Errorf("empty directory not created: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photos", treeManifestName)); !os.IsNotExist(err) {
+		t.Errorf("manifest left behind: %v", err)
+	}
+
+	wfs := must.Get(m.WaitingFiles())
+	if want := []apitype.WaitingFile{{Name: "photos", Size: 10, IsDir: true}}; !reflect.DeepEqual(wfs, want) {
+		t.Errorf("WaitingFiles = %+v; want %+v", wfs, want)
+	}
+	rc, size := must.Get2(m.OpenTreeFile("photos", "sub/b.txt"))
+	got := must.Get(io.ReadAll(rc))
+	rc.Close()
+	if string(got) != files["sub/b.txt"] || size != int64(len(got)) {
+		t.Errorf("OpenTreeFile = %q, %d", got, size)
+	}
+	if _, _, err := m.OpenTreeFile("photos", "../photos/a.txt"); err == nil {
+		t.Errorf("OpenTreeFile outside of tree succeeded")
+	}
+
+	// Receiving a tree of the same name again doesn't clobber the first.
+	must.Get(m.BeginTree(id, tm))
+	for p, content := range files {
+		must.Get(m.PutTreeFile(id, tm.Name, p, strings.NewReader(content)))
+	}
+	res = must.Get(m.FinishTree(id, tm.Name))
+	if res.Name != "photos (1)" {
+		t.Errorf("second tree name = %q; want %q", res.Name, "photos (1)")
+	}
+
+	must.Do(m.DeleteFile("photos"))
+	must.Do(m.DeleteFile("photos (1)"))
+	if wfs := must.Get(m.WaitingFiles()); len(wfs) != 0 {
+		t.Errorf("WaitingFiles after delete = %+v", wfs)
+	}
+}
+
+func TestValidateTreeManifest(t *testing.T) {
+	good := treeEntry("a/b.txt", "x")
+	big := func(p string) apitype.FileTreeEntry {
+		e := treeEntry(p, "x")
+		e.Size = maxTreeSize/2 + 1
+		return e
+	}
+	tests := []struct {
+		name    string
+		entries []apitype.FileTreeEntry
+		wantErr bool
+	}{
+		{"ok", []apitype.FileTreeEntry{good}, false},
+		{"dot-dot", []apitype.FileTreeEntry{treeEntry("../b.txt", "x")}, true},
+		{"absolute", []apitype.FileTreeEntry{treeEntry("/b.txt", "x")}, true},
+		{"empty-elem", []apitype.FileTreeEntry{treeEntry("a//b.txt", "x")}, true},
+		{"partial", []apitype.FileTreeEntry{treeEntry("b.txt.partial", "x")}, true},
+		{"manifest", []apitype.FileTreeEntry{treeEntry(treeManifestName, "x")}, true},
+		{"duplicate", []apitype.FileTreeEntry{good, good}, true},
+		{"file-as-dir", []apitype.FileTreeEntry{good, treeEntry("a", "x")}, true},
+		{"bad-hash", []apitype.FileTreeEntry{{Path: "b.txt", Mode: 0o644, SHA256: "abc"}}, true},
+		{"max-size", []apitype.FileTreeEntry{big("a.bin"), treeEntry("b.bin", "")}, false},
+		{"too-large", []apitype.FileTreeEntry{big("a.bin"), big("b.bin")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateTreeManifest(&apitype.FileTreeManifest{Name: "tree", Entries: tt.entries})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTreeManifest = %v; wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
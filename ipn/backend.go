@@ -161,6 +161,11 @@ type Notify struct {
 	// be the best exit node for the current network conditions.
 	SuggestedExitNode *tailcfg.StableNodeID `json:",omitzero"`
 
+	// ServeAccessDenied, if non-nil, describes a connection or request that
+	// serve refused because the peer wasn't allowed by the AllowFrom of the
+	// handler. Repeated denials of the same peer are rate limited.
+	ServeAccessDenied *ServeAccessDenial `json:",omitzero"`
+
 	// type is mirrored in xcode/IPN/Core/LocalAPI/Model/LocalAPIModel.swift
 }
 
@@ -203,6 +208,9 @@ func (n Notify) String() string {
 	if n.SuggestedExitNode != nil {
 		fmt.Fprintf(&sb, "SuggestedExitNode=%v ", *n.SuggestedExitNode)
 	}
+	if n.ServeAccessDenied != nil {
+		fmt.Fprintf(&sb, "ServeAccessDenied=%v ", n.ServeAccessDenied)
+	}
 
 	s := sb.String()
 	if s == "Notify{" {
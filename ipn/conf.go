@@ -50,6 +50,10 @@ type ConfigVAlpha struct {
 	// should advertise amongst its wireguard endpoints.
 	StaticEndpoints []netip.AddrPort `json:",omitempty"`
 
+	// TaildropRules are receiver-side rules for handling incoming Taildrop
+	// files. See [TaildropRule].
+	TaildropRules []*TaildropRule `json:",omitempty"`
+
 	// TODO(bradfitz,maisem): future something like:
 	// Profile map[string]*Config // keyed by alice@gmail.com, corp.com (TailnetSID)
 }
@@ -145,6 +149,13 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.AppConnector = *c.AppConnector
 		mp.AppConnectorSet = true
 	}
+	if c.TaildropRules != nil {
+		if err := CheckTaildropRules(c.TaildropRules); err != nil {
+			return mp, err
+		}
+		mp.TaildropRules = c.TaildropRules
+		mp.TaildropRulesSet = true
+	}
 	// Configfile should be the source of truth for whether this node
 	// advertises any services.  We need to ensure that each reload updates
 	// currently advertised services as else the transition from 'some
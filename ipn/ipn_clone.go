@@ -65,6 +65,16 @@ func (src *Prefs) Clone() *Prefs {
 		dst.RelayServerPort = ptr.To(*src.RelayServerPort)
 	}
 	dst.RelayServerStaticEndpoints = append(src.RelayServerStaticEndpoints[:0:0], src.RelayServerStaticEndpoints...)
+	if src.TaildropRules != nil {
+		dst.TaildropRules = make([]*TaildropRule, len(src.TaildropRules))
+		for i := range dst.TaildropRules {
+			if src.TaildropRules[i] == nil {
+				dst.TaildropRules[i] = nil
+			} else {
+				dst.TaildropRules[i] = src.TaildropRules[i].Clone()
+			}
+		}
+	}
 	dst.Persist = src.Persist.Clone()
 	return dst
 }
@@ -104,6 +114,7 @@ func (src *Prefs) Clone() *Prefs {
 	DriveShares                []*drive.Share
 	RelayServerPort            *uint16
 	RelayServerStaticEndpoints []netip.AddrPort
+	TaildropRules              []*TaildropRule
 	AllowSingleHosts           marshalAsTrueInJSON
 	Persist                    *persist.Persist
 }{})
@@ -216,6 +227,7 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	}
 	dst := new(TCPPortHandler)
 	*dst = *src
+	dst.AllowFrom = append(src.AllowFrom[:0:0], src.AllowFrom...)
 	return dst
 }
 
@@ -226,6 +238,7 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	TCPForward    string
 	TerminateTLS  string
 	ProxyProtocol int
+	AllowFrom     []string
 }{})
 
 // Clone makes a deep copy of HTTPHandler.
@@ -237,6 +250,7 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	dst := new(HTTPHandler)
 	*dst = *src
 	dst.AcceptAppCaps = append(src.AcceptAppCaps[:0:0], src.AcceptAppCaps...)
+	dst.AllowFrom = append(src.AllowFrom[:0:0], src.AllowFrom...)
 	return dst
 }
 
@@ -246,6 +260,7 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	Proxy         string
 	Text          string
 	AcceptAppCaps []tailcfg.PeerCapability
+	AllowFrom     []string
 	Redirect      string
 }{})
 
@@ -274,3 +289,28 @@ func (src *WebServerConfig) Clone() *WebServerConfig {
 var _WebServerConfigCloneNeedsRegeneration = WebServerConfig(struct {
 	Handlers map[string]*HTTPHandler
 }{})
+
+// Clone makes a deep copy of TaildropRule.
+// The result aliases no memory with the original.
+func (src *TaildropRule) Clone() *TaildropRule {
+	if src == nil {
+		return nil
+	}
+	dst := new(TaildropRule)
+	*dst = *src
+	dst.From = append(src.From[:0:0], src.From...)
+	dst.Ext = append(src.Ext[:0:0], src.Ext...)
+	dst.Command = append(src.Command[:0:0], src.Command...)
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _TaildropRuleCloneNeedsRegeneration = TaildropRule(struct {
+	From    []string
+	Ext     []string
+	MinSize int64
+	MaxSize int64
+	Action  TaildropAction
+	Dir     string
+	Command []string
+}{})
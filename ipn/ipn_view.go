@@ -20,7 +20,7 @@
 	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=LoginProfile,Prefs,ServeConfig,ServiceConfig,TCPPortHandler,HTTPHandler,WebServerConfig
+//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=LoginProfile,Prefs,ServeConfig,ServiceConfig,TCPPortHandler,HTTPHandler,WebServerConfig,TaildropRule
 
 // View returns a read-only view of LoginProfile.
 func (p *LoginProfile) View() LoginProfileView {
@@ -453,6 +453,12 @@ func (v PrefsView) RelayServerStaticEndpoints() views.Slice[netip.AddrPort] {
 	return views.SliceOf(v.ж.RelayServerStaticEndpoints)
 }
 
+// TaildropRules are receiver-side rules for handling incoming Taildrop
+// files, evaluated in order. See [TaildropRule].
+func (v PrefsView) TaildropRules() views.SliceView[*TaildropRule, TaildropRuleView] {
+	return views.SliceOfViews[*TaildropRule, TaildropRuleView](v.ж.TaildropRules)
+}
+
 // AllowSingleHosts was a legacy field that was always true
 // for the past 4.5 years. It controlled whether Tailscale
 // peers got /32 or /128 routes for each other.
@@ -506,6 +512,7 @@ func (v PrefsView) Persist() persist.PersistView { return v.ж.Persist.View() }
 	DriveShares                []*drive.Share
 	RelayServerPort            *uint16
 	RelayServerStaticEndpoints []netip.AddrPort
+	TaildropRules              []*TaildropRule
 	AllowSingleHosts           marshalAsTrueInJSON
 	Persist                    *persist.Persist
 }{})
@@ -826,6 +833,12 @@ func (v TCPPortHandlerView) TerminateTLS() string { return v.ж.TerminateTLS }
 // This is only valid if TCPForward is non-empty.
 func (v TCPPortHandlerView) ProxyProtocol() int { return v.ж.ProxyProtocol }
 
+// AllowFrom, if non-empty, restricts which peers may connect to this
+// port. Each entry is either a tag (e.g. "tag:prod") or a user login name
+// (e.g. "alice@example.com"). Connections from peers that match none of
+// the entries, including funneled connections, are closed.
+func (v TCPPortHandlerView) AllowFrom() views.Slice[string] { return views.SliceOf(v.ж.AllowFrom) }
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _TCPPortHandlerViewNeedsRegeneration = TCPPortHandler(struct {
 	HTTPS         bool
@@ -833,6 +846,7 @@ func (v TCPPortHandlerView) ProxyProtocol() int { return v.ж.ProxyProtocol }
 	TCPForward    string
 	TerminateTLS  string
 	ProxyProtocol int
+	AllowFrom     []string
 }{})
 
 // View returns a read-only view of HTTPHandler.
@@ -916,6 +930,11 @@ func (v HTTPHandlerView) AcceptAppCaps() views.Slice[tailcfg.PeerCapability] {
 	return views.SliceOf(v.ж.AcceptAppCaps)
 }
 
+// AllowFrom, if non-empty, restricts which peers may use this handler,
+// in the same format as TCPPortHandler.AllowFrom. Requests from other
+// peers get a 403 Forbidden response.
+func (v HTTPHandlerView) AllowFrom() views.Slice[string] { return views.SliceOf(v.ж.AllowFrom) }
+
 // Redirect, if not empty, is the target URL to redirect requests to.
 // By default, we redirect with HTTP 302 (Found) status.
 // If Redirect starts with '<httpcode>:', then we use that status instead.
@@ -931,6 +950,7 @@ func (v HTTPHandlerView) Redirect() string { return v.ж.Redirect }
 	Proxy         string
 	Text          string
 	AcceptAppCaps []tailcfg.PeerCapability
+	AllowFrom     []string
 	Redirect      string
 }{})
 
@@ -1012,3 +1032,119 @@ func (v WebServerConfigView) Handlers() views.MapFn[string, *HTTPHandler, HTTPHa
 var _WebServerConfigViewNeedsRegeneration = WebServerConfig(struct {
 	Handlers map[string]*HTTPHandler
 }{})
+
+// View returns a read-only view of TaildropRule.
+func (p *TaildropRule) View() TaildropRuleView {
+	return TaildropRuleView{ж: p}
+}
+
+// TaildropRuleView provides a read-only view over TaildropRule.
+//
+// Its methods should only be called if `Valid()` returns true.
+type TaildropRuleView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *TaildropRule
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v TaildropRuleView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v TaildropRuleView) AsStruct() *TaildropRule {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+// MarshalJSON implements [jsonv1.Marshaler].
+func (v TaildropRuleView) MarshalJSON() ([]byte, error) {
+	return jsonv1.Marshal(v.ж)
+}
+
+// MarshalJSONTo implements [jsonv2.MarshalerTo].
+func (v TaildropRuleView) MarshalJSONTo(enc *jsontext.Encoder) error {
+	return jsonv2.MarshalEncode(enc, v.ж)
+}
+
+// UnmarshalJSON implements [jsonv1.Unmarshaler].
+func (v *TaildropRuleView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x TaildropRule
+	if err := jsonv1.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// UnmarshalJSONFrom implements [jsonv2.UnmarshalerFrom].
+func (v *TaildropRuleView) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	var x TaildropRule
+	if err := jsonv2.UnmarshalDecode(dec, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// From, if non-empty, limits the rule to files sent by any of the given
+// senders. Each is either a user's login name (e.g. "alice@example.com"),
+// which matches that user's untagged nodes, a tag (e.g. "tag:server"), or
+// a node's MagicDNS name (e.g. "laptop" or "laptop.tailnet.ts.net").
+func (v TaildropRuleView) From() views.Slice[string] {
+	return views.SliceOf(v.ж.From)
+}
+
+// Ext, if non-empty, limits the rule to files whose names end in any of
+// the given extensions (e.g. ".jpg"), compared case-insensitively.
+func (v TaildropRuleView) Ext() views.Slice[string] {
+	return views.SliceOf(v.ж.Ext)
+}
+
+// MinSize, if positive, limits the rule to files of at least that many
+// bytes.
+func (v TaildropRuleView) MinSize() int64 { return v.ж.MinSize }
+
+// MaxSize, if positive, limits the rule to files of at most that many
+// bytes.
+func (v TaildropRuleView) MaxSize() int64 { return v.ж.MaxSize }
+
+// Action is what to do with matching files.
+func (v TaildropRuleView) Action() TaildropAction { return v.ж.Action }
+
+// Dir is the absolute path of the directory to move matching files into.
+// It's only used with TaildropActionMove, which only a local administrator
+// can configure because tailscaled moves the files as its own user.
+func (v TaildropRuleView) Dir() string { return v.ж.Dir }
+
+// Command is the program and arguments to run for matching files, with
+// the path of the received file appended as the final argument. It's
+// only used with TaildropActionExec, and runs as the same user as
+// tailscaled.
+func (v TaildropRuleView) Command() views.Slice[string] {
+	return views.SliceOf(v.ж.Command)
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _TaildropRuleViewNeedsRegeneration = TaildropRule(struct {
+	From    []string
+	Ext     []string
+	MinSize int64
+	MaxSize int64
+	Action  TaildropAction
+	Dir     string
+	Command []string
+}{})
@@ -12,6 +12,7 @@
 	"net/http"
 	"net/netip"
 	"os"
+	"path/filepath"
 	"slices"
 
 	"tailscale.com/drive"
@@ -32,6 +33,18 @@ func setNetMapLockedDrive(b *LocalBackend, nm *netmap.NetworkMap) {
 	b.driveNotifyCurrentSharesLocked()
 }
 
+// driveAudit returns the log of operations performed by remote nodes on
+// local shares, or nil if there's nowhere to keep it.
+func (b *LocalBackend) driveAudit() *drive.AuditLog {
+	return b.driveAuditLog.Get(func() *drive.AuditLog {
+		vr := b.TailscaleVarRoot()
+		if vr == "" {
+			return nil
+		}
+		return drive.NewAuditLog(filepath.Join(vr, "drive-audit.log"))
+	})
+}
+
 // DriveSetServerAddr tells Taildrive to use the given address for connecting
 // to the drive.FileServer that's exposing local files as an unprivileged
 // user.
@@ -285,6 +298,35 @@ func (b *LocalBackend) DriveGetShares() views.SliceView[*drive.Share, drive.Shar
 	return b.pm.prefs.DriveShares()
 }
 
+// DriveMount mounts the Taildrive shares of remote nodes at the local
+// directory dir using FUSE, with all files owned by the given user and group.
+func (b *LocalBackend) DriveMount(dir string, uid, gid int) error {
+	fs, ok := b.sys.DriveForLocal.GetOK()
+	if !ok {
+		return drive.ErrDriveNotEnabled
+	}
+	return fs.Mount(dir, uid, gid)
+}
+
+// DriveUnmount unmounts the directory dir, previously mounted with
+// DriveMount, on behalf of the user uid.
+func (b *LocalBackend) DriveUnmount(dir string, uid int) error {
+	fs, ok := b.sys.DriveForLocal.GetOK()
+	if !ok {
+		return drive.ErrDriveNotEnabled
+	}
+	return fs.Unmount(dir, uid)
+}
+
+// DriveMounts returns the directories mounted with DriveMount, sorted.
+func (b *LocalBackend) DriveMounts() []string {
+	fs, ok := b.sys.DriveForLocal.GetOK()
+	if !ok {
+		return nil
+	}
+	return fs.Mounts()
+}
+
 // updateDrivePeersLocked sets all applicable peers from the netmap as Taildrive
 // remotes.
 func (b *LocalBackend) updateDrivePeersLocked(nm *netmap.NetworkMap) {
@@ -71,10 +71,13 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstime"
+	"tailscale.com/tstime/rate"
 	"tailscale.com/types/appctype"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/empty"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
+	"tailscale.com/types/lazy"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
@@ -253,6 +256,8 @@ type LocalBackend struct {
 
 	peerAPIPorts syncs.AtomicValue[map[netip.Addr]int] // can be read without b.mu held; TODO(nickkhyl): remove or move to nodeBackend?
 
+	driveAuditLog lazy.SyncValue[*drive.AuditLog] // see driveAudit
+
 	// The mutex protects the following elements.
 	mu syncs.Mutex
 
@@ -326,6 +331,9 @@ type LocalBackend struct {
 	serveListeners     map[netip.AddrPort]*localListener // listeners for local serve traffic
 	serveProxyHandlers sync.Map                          // string (HTTPHandler.Proxy) => *reverseProxy
 
+	serveDenialsMu sync.Mutex
+	serveDenials   map[ipn.ServeAccessDenial]time.Time // last time each denial was reported; PeerAddr port zeroed
+
 	// dialPlan is any dial plan that we've received from the control
 	// server during a previous connection; it is cleared on logout.
 	dialPlan atomic.Pointer[tailcfg.ControlDialPlan] // TODO(nickkhyl): maybe move to nodeBackend?
@@ -406,6 +414,9 @@ type LocalBackend struct {
 	// bind the node identity to this device.
 	hardwareAttested atomic.Bool
 
+	// watchingFilterTraces is whether WatchFilterTraces is running.
+	watchingFilterTraces atomic.Bool
+
 	// getCertForTest is used to retrieve TLS certificates in tests.
 	// See [LocalBackend.ConfigureCertsForTest].
 	getCertForTest func(hostname string) (*TLSCertKeyPair, error)
@@ -451,6 +462,10 @@ type metrics struct {
 	// approvedRoutes is a metric that reports the number of network routes served by the local node and approved
 	// by the control server.
 	approvedRoutes *usermetric.Gauge
+
+	// filterRules counts the packets accepted and dropped by each rule of
+	// the packet filter. It's nil if user metrics are omitted.
+	filterRules *filter.RuleMetrics
 }
 
 // clientGen is a func that creates a control plane client.
@@ -502,6 +517,7 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 			"tailscaled_advertised_routes", "Number of advertised network routes (e.g. by a subnet router)"),
 		approvedRoutes: sys.UserMetricsRegistry().NewGauge(
 			"tailscaled_approved_routes", "Number of approved network routes (e.g. by a subnet router)"),
+		filterRules: filter.NewRuleMetrics(sys.UserMetricsRegistry()),
 	}
 
 	b := &LocalBackend{
@@ -781,7 +797,10 @@ func (b *LocalBackend) GetDNSOSConfig() (dns.OSConfig, error) {
 // QueryDNS performs a DNS query for name and queryType using the built-in DNS resolver, and returns
 // the raw DNS response and the resolvers that are were able to handle the query (the internal forwarder
 // may race multiple resolvers).
-func (b *LocalBackend) QueryDNS(name string, queryType dnsmessage.Type) (res []byte, resolvers []*dnstype.Resolver, err error) {
+//
+// If upstream is non-nil, the query is forwarded to it instead of the
+// resolvers configured for name.
+func (b *LocalBackend) QueryDNS(name string, queryType dnsmessage.Type, upstream *dnstype.Resolver) (res []byte, resolvers []*dnstype.Resolver, err error) {
 	if !buildfeatures.HasDNS {
 		return nil, nil, feature.ErrUnavailable
 	}
@@ -816,11 +835,18 @@ func (b *LocalBackend) QueryDNS(name string, queryType dnsmessage.Type) (res []b
 		b.logf("DNSQuery: failed to build query: %v", err)
 		return nil, nil, err
 	}
-	res, err = manager.Query(b.ctx, q, "tcp", from)
+	if upstream != nil {
+		res, err = manager.Resolver().QueryUpstream(b.ctx, q, "tcp", from, upstream)
+	} else {
+		res, err = manager.Query(b.ctx, q, "tcp", from)
+	}
 	if err != nil {
 		b.logf("DNSQuery: failed to query %q: %v", name, err)
 		return nil, nil, err
 	}
+	if upstream != nil {
+		return res, []*dnstype.Resolver{upstream}, nil
+	}
 	rr := manager.Resolver().GetUpstreamResolvers(fqdn)
 	return res, rr, nil
 }
@@ -2918,6 +2944,7 @@ func packetFilterPermitsUnlockedNodes(peers map[tailcfg.NodeID]tailcfg.NodeView,
 // TODO(nickkhyl): this should be non-existent with a proper [LocalBackend.updateFilterLocked].
 // See the comment in that function for more details.
 func (b *LocalBackend) setFilter(f *filter.Filter) {
+	b.metrics.filterRules.Track(f)
 	b.currentNode().setFilter(f)
 	b.e.SetFilter(f)
 	if ms, ok := b.sys.MagicSock.GetOK(); ok {
@@ -4131,6 +4158,9 @@ func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
 	if err := b.checkAutoUpdatePrefsLocked(p); err != nil {
 		errs = append(errs, err)
 	}
+	if err := ipn.CheckTaildropRules(p.TaildropRules); err != nil {
+		errs = append(errs, err)
+	}
 	return errors.Join(errs...)
 }
 
@@ -4359,6 +4389,12 @@ func (b *LocalBackend) checkEditPrefsAccessLocked(actor ipnauth.Actor, prefs ipn
 		errs = append(errs, errors.New("Tailscale SSH server administratively disabled"))
 	}
 
+	// Rules that move files or run commands do so as tailscaled's user, so
+	// only let local admins configure them.
+	if mp.TaildropRulesSet && slices.ContainsFunc(mp.TaildropRules, needsAdmin) && !actor.IsLocalAdmin("") {
+		errs = append(errs, errors.New("only a local administrator can set Taildrop rules that move files or run commands"))
+	}
+
 	// Check if the user is allowed to disconnect Tailscale.
 	if mp.WantRunningSet && !mp.WantRunning && b.pm.CurrentPrefs().WantRunning() {
 		if err := actor.CheckProfileAccess(b.pm.CurrentProfile(), ipnauth.Disconnect, b.extHost.AuditLogger()); err != nil {
@@ -4390,6 +4426,13 @@ func (b *LocalBackend) checkEditPrefsAccessLocked(actor ipnauth.Actor, prefs ipn
 	return errors.Join(errs...)
 }
 
+// needsAdmin reports whether r is a Taildrop rule that only a local admin may
+// set: one that moves files to an arbitrary directory or runs a command, both
+// of which happen as tailscaled's user.
+func needsAdmin(r *ipn.TaildropRule) bool {
+	return r != nil && (r.Action == ipn.TaildropActionMove || r.Action == ipn.TaildropActionExec)
+}
+
 // changeDisablesExitNodeLocked reports whether applying the change
 // to the given prefs would disable exit node usage.
 //
@@ -6806,6 +6849,71 @@ func (b *LocalBackend) DebugReSTUN() error {
 	return nil
 }
 
+// TraceFilter reports how the packet filter treats traffic from src to dst
+// using protocol proto, as if it were the first packet of a flow. The
+// traffic is from a peer if inbound is true, or to a peer otherwise.
+func (b *LocalBackend) TraceFilter(src, dst netip.AddrPort, proto ipproto.Proto, inbound bool) (filter.Trace, error) {
+	tunWrap, ok := b.sys.Tun.GetOK()
+	if !ok {
+		return filter.Trace{}, errors.New("no tun device")
+	}
+	p := filter.SynthesizePacket(src, dst, proto)
+	if p == nil {
+		return filter.Trace{}, errors.New("source and destination address families differ")
+	}
+	return tunWrap.TraceFilter(p, inbound), nil
+}
+
+// maxFilterTracesPerSecond is how many traces of live packets
+// WatchFilterTraces reports per second, at most.
+const maxFilterTracesPerSecond = 20
+
+// WatchFilterTraces calls fn with traces of live packets that the packet
+// filter runs on, until ctx is done. If onlyDrops, only packets that the
+// filter drops are reported. Packets are sampled: at most
+// maxFilterTracesPerSecond are reported, and packets that arrive while fn is
+// busy are skipped.
+//
+// Only one WatchFilterTraces call may run at a time.
+func (b *LocalBackend) WatchFilterTraces(ctx context.Context, onlyDrops bool, fn func(filter.Trace)) error {
+	tunWrap, ok := b.sys.Tun.GetOK()
+	if !ok {
+		return errors.New("no tun device")
+	}
+	if !b.watchingFilterTraces.CompareAndSwap(false, true) {
+		return errors.New("filter traces are already being watched")
+	}
+	defer b.watchingFilterTraces.Store(false)
+
+	lim := rate.NewLimiter(maxFilterTracesPerSecond, maxFilterTracesPerSecond)
+	traces := make(chan filter.Trace, maxFilterTracesPerSecond)
+	tunWrap.InstallFilterTraceHook(func(p *packet.Parsed, inbound bool) {
+		// Tracing allocates, so unless we need the verdict to decide
+		// whether to report the packet, sample first.
+		if !onlyDrops && !lim.Allow() {
+			return
+		}
+		tr := tunWrap.TraceFilter(p, inbound)
+		if onlyDrops && (tr.Verdict == filter.Accept.String() || !lim.Allow()) {
+			return
+		}
+		select {
+		case traces <- tr:
+		default:
+		}
+	})
+	defer tunWrap.InstallFilterTraceHook(nil)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case tr := <-traces:
+			fn(tr)
+		}
+	}
+}
+
 func (b *LocalBackend) DebugRotateDiscoKey() error {
 	if !buildfeatures.HasDebug {
 		return nil
@@ -7190,6 +7298,24 @@ func (b *LocalBackend) GetPeerEndpointChanges(ctx context.Context, ip netip.Addr
 	return chs, nil
 }
 
+// GetPeerPath returns how packets are sent to the peer with Tailscale IP ip,
+// why, and the recent changes of that path.
+func (b *LocalBackend) GetPeerPath(ctx context.Context, ip netip.Addr) (*ipnstate.PeerPath, error) {
+	pip, ok := b.e.PeerForIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no matching peer")
+	}
+	if pip.IsSelf {
+		return nil, fmt.Errorf("%v is local Tailscale IP", ip)
+	}
+
+	pp, err := b.MagicConn().PeerPath(pip.Node)
+	if err != nil {
+		return nil, fmt.Errorf("getting peer path: %w", err)
+	}
+	return pp, nil
+}
+
 var breakTCPConns func() error
 
 func (b *LocalBackend) DebugBreakTCPConns() error {
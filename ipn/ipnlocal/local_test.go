@@ -1452,6 +1452,38 @@ func TestPrefsChangeDisablesExitNode(t *testing.T) {
 	}
 }
 
+func TestTaildropRulesAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    ipn.TaildropRule
+		admin   bool
+		wantErr bool
+	}{
+		{"reject", ipn.TaildropRule{Action: ipn.TaildropActionReject}, false, false},
+		{"inbox", ipn.TaildropRule{Action: ipn.TaildropActionInbox}, false, false},
+		{"move", ipn.TaildropRule{Action: ipn.TaildropActionMove, Dir: "/etc/cron.d"}, false, true},
+		{"move-admin", ipn.TaildropRule{Action: ipn.TaildropActionMove, Dir: "/srv/photos"}, true, false},
+		{"exec", ipn.TaildropRule{Action: ipn.TaildropActionExec, Command: []string{"/usr/bin/true"}}, false, true},
+		{"exec-admin", ipn.TaildropRule{Action: ipn.TaildropActionExec, Command: []string{"/usr/bin/true"}}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := newTestLocalBackend(t)
+			mp := &ipn.MaskedPrefs{
+				Prefs:            ipn.Prefs{TaildropRules: []*ipn.TaildropRule{&tt.rule}},
+				TaildropRulesSet: true,
+			}
+			_, err := lb.EditPrefsAs(mp, &ipnauth.TestActor{LocalAdmin: tt.admin})
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("EditPrefsAs error = %v, want error: %v", err, tt.wantErr)
+			}
+			if got := lb.Prefs().TaildropRules().Len(); !tt.wantErr && got != 1 {
+				t.Errorf("got %d Taildrop rules, want 1", got)
+			}
+		})
+	}
+}
+
 func TestExitNodeNotifyOrder(t *testing.T) {
 	const controlURL = "https://localhost:1/"
 
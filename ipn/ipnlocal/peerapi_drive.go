@@ -6,7 +6,9 @@
 package ipnlocal
 
 import (
+	"cmp"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strings"
 
@@ -67,6 +69,11 @@ func handleServeDrive(hi PeerAPIHandler, w http.ResponseWriter, r *http.Request)
 	}
 	r.Body = bw
 
+	// Note what was requested before the request is rewritten on its way
+	// to the file server.
+	auditPath := strings.TrimPrefix(r.URL.Path, taildrivePrefix)
+	auditDest := r.Header.Get("Destination")
+
 	defer func() {
 		switch wr.statusCode {
 		case 304:
@@ -83,12 +90,53 @@ func handleServeDrive(hi PeerAPIHandler, w http.ResponseWriter, r *http.Request)
 
 			log("taildrive: share: %s from %s to %s: status-code=%d ext=%q content-type=%q tx=%.f rx=%.f", r.Method, h.peerNode.Key().ShortString(), h.selfNode.Key().ShortString(), wr.statusCode, parseDriveFileExtensionForLog(r.URL.Path), contentType, roundTraffic(wr.contentLength), roundTraffic(bw.bytesRead))
 		}
+		h.auditDrive(r.Method, auditPath, auditDest, wr, bw)
 	}()
 
 	r.URL.Path = strings.TrimPrefix(r.URL.Path, taildrivePrefix)
 	fs.ServeHTTPWithPerms(p, wr, r)
 }
 
+// driveUnauditedMethods are the WebDAV methods that are too chatty, and reveal
+// too little, to be worth recording in the Taildrive audit log.
+var driveUnauditedMethods = map[string]bool{
+	"OPTIONS":  true,
+	"PROPFIND": true,
+	"HEAD":     true,
+}
+
+// auditDrive records a Taildrive request in the audit log. The path is the
+// requested path without taildrivePrefix, and dest is the value of the
+// request's Destination header, if any.
+func (h *peerAPIHandler) auditDrive(method, path, dest string, wr *httpResponseWrapper, bw *requestBodyWrapper) {
+	if driveUnauditedMethods[method] {
+		return
+	}
+	al := h.ps.b.driveAudit()
+	if al == nil {
+		return
+	}
+	rec := &drive.AuditRecord{
+		Time:         h.ps.b.clock.Now(),
+		NodeID:       string(h.peerNode.StableID()),
+		Node:         h.peerNode.Name(),
+		Method:       method,
+		Path:         path,
+		Status:       cmp.Or(wr.statusCode, http.StatusOK),
+		BytesRead:    bw.bytesRead,
+		BytesWritten: wr.contentLength,
+	}
+	if !h.peerNode.IsTagged() {
+		rec.User = h.peerUser.LoginName
+	}
+	if u, err := url.Parse(dest); err == nil && u.Path != "" {
+		rec.Destination = strings.TrimPrefix(u.Path, taildrivePrefix)
+	}
+	if err := al.Log(rec); err != nil {
+		h.logf("taildrive: failed to write audit log: %v", err)
+	}
+}
+
 // parseDriveFileExtensionForLog parses the file extension, if available.
 // If a file extension is not present or parsable, the file extension is
 // set to "unknown". If the file extension contains a double quote, it is
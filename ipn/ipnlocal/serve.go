@@ -534,6 +534,66 @@ func (b *LocalBackend) vipServicesFromPrefsLocked(prefs ipn.PrefsView) []*tailcf
 	return servicesList
 }
 
+// serveAccessDenialInterval is how often the same denial, ignoring the
+// source port, is logged and sent on the IPN bus.
+const serveAccessDenialInterval = time.Minute
+
+// closeConn is a serve TCP handler that closes the connection.
+func closeConn(c net.Conn) error { return c.Close() }
+
+// serveAllowed reports whether the peer at srcAddr is allowed by allowFrom,
+// the AllowFrom of a serve handler. An empty allowFrom allows everyone. It
+// also returns the peer's identity as matched against allowFrom: its tags,
+// comma-separated, or its user's login name.
+func (b *LocalBackend) serveAllowed(allowFrom views.Slice[string], srcAddr netip.AddrPort) (peer string, ok bool) {
+	if allowFrom.Len() == 0 {
+		return "", true
+	}
+	node, user, ok := b.WhoIs("tcp", srcAddr)
+	if !ok {
+		return "", false
+	}
+	if node.IsTagged() {
+		tags := node.Tags()
+		for _, t := range tags.All() {
+			if views.SliceContains(allowFrom, t) {
+				return t, true
+			}
+		}
+		return strings.Join(tags.AsSlice(), ","), false
+	}
+	return user.LoginName, allowFrom.ContainsFunc(func(a string) bool {
+		return strings.EqualFold(a, user.LoginName)
+	})
+}
+
+// noteServeAccessDenied logs d and sends it on the IPN bus, unless the same
+// peer was denied access to the same handler in the last
+// serveAccessDenialInterval.
+func (b *LocalBackend) noteServeAccessDenied(d ipn.ServeAccessDenial) {
+	metricServeAccessDenied.Add(1)
+	key := d
+	key.PeerAddr = netip.AddrPortFrom(d.PeerAddr.Addr(), 0)
+	now := b.clock.Now()
+	b.serveDenialsMu.Lock()
+	if last, ok := b.serveDenials[key]; ok && now.Sub(last) < serveAccessDenialInterval {
+		b.serveDenialsMu.Unlock()
+		return
+	}
+	for k, t := range b.serveDenials {
+		if now.Sub(t) >= serveAccessDenialInterval {
+			delete(b.serveDenials, k)
+		}
+	}
+	mak.Set(&b.serveDenials, key, now)
+	b.serveDenialsMu.Unlock()
+
+	b.logf("serve: %v", &d)
+	b.send(ipn.Notify{ServeAccessDenied: &d})
+}
+
+var metricServeAccessDenied = clientmetric.NewCounter("serve_access_denied")
+
 // tcpHandlerForVIPService returns a handler for a TCP connection to a VIP service
 // that is being served via the ipn.ServeConfig. It returns nil if the destination
 // address is not a VIP service or if the VIP service does not have a TCP handler set.
@@ -559,6 +619,10 @@ func (b *LocalBackend) tcpHandlerForVIPService(dstAddr, srcAddr netip.AddrPort)
 		b.logf("The destination service doesn't have a TCP handler set.")
 		return nil
 	}
+	if peer, ok := b.serveAllowed(tcph.AllowFrom(), srcAddr); !ok {
+		b.noteServeAccessDenied(ipn.ServeAccessDenial{Service: dstSvc, Port: dport, Peer: peer, PeerAddr: srcAddr})
+		return closeConn
+	}
 
 	if tcph.HTTPS() || tcph.HTTP() {
 		hs := &http.Server{
@@ -640,6 +704,15 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort,
 	if !ok {
 		return nil
 	}
+	if f == nil {
+		if peer, ok := b.serveAllowed(tcph.AllowFrom(), srcAddr); !ok {
+			b.noteServeAccessDenied(ipn.ServeAccessDenial{Port: dport, Peer: peer, PeerAddr: srcAddr})
+			return closeConn
+		}
+	} else if tcph.AllowFrom().Len() > 0 {
+		b.noteServeAccessDenied(ipn.ServeAccessDenial{Port: dport, PeerAddr: srcAddr})
+		return closeConn
+	}
 
 	if tcph.HTTPS() || tcph.HTTP() {
 		hs := &http.Server{
@@ -1144,6 +1217,31 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if allow := h.AllowFrom(); allow.Len() > 0 {
+		c, ok := serveHTTPContextKey.ValueOk(r.Context())
+		if !ok {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		// Funneled requests come from outside the tailnet, so are never
+		// allowed.
+		var peer string
+		allowed := false
+		if c.Funnel == nil {
+			peer, allowed = b.serveAllowed(allow, c.SrcAddr)
+		}
+		if !allowed {
+			b.noteServeAccessDenied(ipn.ServeAccessDenial{
+				Service:  c.ForVIPService,
+				Port:     c.DestPort,
+				Path:     mountPoint,
+				Peer:     peer,
+				PeerAddr: c.SrcAddr,
+			})
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
 	if s := h.Text(); s != "" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		io.WriteString(w, s)
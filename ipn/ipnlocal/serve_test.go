@@ -801,6 +801,88 @@ type headerCheck struct {
 	}
 }
 
+func TestServeHTTPAllowFrom(t *testing.T) {
+	b := newTestBackend(t)
+
+	testServ := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer testServ.Close()
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/":      {Proxy: testServ.URL},
+				"/user":  {Proxy: testServ.URL, AllowFrom: []string{"Someone@example.com"}},
+				"/admin": {Proxy: testServ.URL, AllowFrom: []string{"tag:test"}},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		srcIP      string
+		path       string
+		wantStatus int
+		wantDenial *ipn.ServeAccessDenial
+	}{
+		{"unrestricted", "100.160.161.162", "/", http.StatusOK, nil},
+		{"user-allowed", "100.150.151.152", "/user", http.StatusOK, nil},
+		{"user-denied", "100.150.151.152", "/admin", http.StatusForbidden, &ipn.ServeAccessDenial{
+			Port: 443, Path: "/admin", Peer: "someone@example.com", PeerAddr: netip.MustParseAddrPort("100.150.151.152:1234"),
+		}},
+		{"tag-allowed", "100.150.151.153", "/admin", http.StatusOK, nil},
+		{"tag-denied", "100.150.151.153", "/user", http.StatusForbidden, &ipn.ServeAccessDenial{
+			Port: 443, Path: "/user", Peer: "tag:server,tag:test", PeerAddr: netip.MustParseAddrPort("100.150.151.153:1234"),
+		}},
+		{"outside-tailnet-denied", "100.160.161.162", "/user", http.StatusForbidden, &ipn.ServeAccessDenial{
+			Port: 443, Path: "/user", PeerAddr: netip.MustParseAddrPort("100.160.161.162:1234"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *ipn.ServeAccessDenial
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			watching := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				b.WatchNotifications(ctx, 0, func() { close(watching) }, func(n *ipn.Notify) bool {
+					if n.ServeAccessDenied != nil {
+						got = n.ServeAccessDenied
+						return false
+					}
+					return true
+				})
+			}()
+			<-watching
+
+			req := &http.Request{
+				URL: &url.URL{Path: tt.path},
+				TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
+			}
+			req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+				DestPort: 443,
+				SrcAddr:  netip.MustParseAddrPort(tt.srcIP + ":1234"),
+			}))
+			w := httptest.NewRecorder()
+			b.serveWebHandler(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantDenial == nil {
+				cancel()
+			}
+			<-done
+			if !reflect.DeepEqual(got, tt.wantDenial) {
+				t.Errorf("denial = %+v, want %+v", got, tt.wantDenial)
+			}
+		})
+	}
+}
+
 func TestServeHTTPProxyGrantHeader(t *testing.T) {
 	b := newTestBackend(t)
 
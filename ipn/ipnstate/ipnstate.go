@@ -329,6 +329,54 @@ type PeerStatus struct {
 	KeyExpiry *time.Time `json:",omitempty"`
 
 	Location *tailcfg.Location `json:",omitempty"`
+
+	// Multipath is the state of the paths to the peer over each of
+	// several local network interfaces. It's nil unless multipath mode
+	// is turned on.
+	Multipath *PeerMultipath `json:",omitempty"`
+}
+
+// PeerMultipath is the state of magicsock's multipath mode for a peer, in
+// which packets to the peer can go over any of several local network
+// interfaces rather than only the one holding the default route.
+type PeerMultipath struct {
+	// Active is the path that packets to the peer go over, as
+	// "interface/ip:port". It's empty if no path is up, in which case
+	// packets go over the regular path (CurAddr, PeerRelay or DERP).
+	Active string `json:",omitempty"`
+
+	// Duplicate is whether the peer is configured as critical, so each
+	// packet to it is also sent over a second path, on another interface.
+	Duplicate bool `json:",omitempty"`
+
+	// Second is the path that duplicate packets go over, in the same
+	// form as Active. It's empty if Duplicate is false or there's no
+	// second path up.
+	Second string `json:",omitempty"`
+
+	// Paths are the known paths to the peer's direct UDP endpoints over
+	// each local interface, sorted by interface and then address.
+	Paths []PeerMultipathPath `json:",omitempty"`
+}
+
+// PeerMultipathPath is a path to one of a peer's direct UDP endpoints over
+// one local network interface.
+type PeerMultipathPath struct {
+	Interface string // local network interface name
+	Addr      string // peer's ip:port
+	Up        bool   // whether the path recently answered probes
+
+	// LatencySeconds is the round-trip time of the last answered probe.
+	LatencySeconds float64 `json:",omitempty"`
+
+	// LastPong is when the path last answered a probe.
+	LastPong time.Time `json:",omitzero"`
+
+	// Loss is the fraction of recent probes left unanswered, from 0 to 1.
+	Loss float64
+
+	TxPackets int64 // packets sent to the peer over the path
+	TxBytes   int64 // bytes sent to the peer over the path
 }
 
 type TaildropTargetStatus int
@@ -546,6 +594,9 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if v := st.TaildropTarget; v != TaildropTargetUnknown {
 		e.TaildropTarget = v
 	}
+	if v := st.Multipath; v != nil {
+		e.Multipath = v
+	}
 	e.Location = st.Location
 }
 
@@ -788,6 +839,85 @@ type DebugDERPRegionReport struct {
 	Errors   []string
 }
 
+// PathType is the kind of path over which packets are sent to a peer.
+type PathType string
+
+const (
+	PathDERP      PathType = "derp"       // relayed via the peer's home DERP region
+	PathDirect    PathType = "direct"     // direct UDP
+	PathPeerRelay PathType = "peer-relay" // via a peer relay (udprelay server)
+)
+
+// PeerPath is the result of a "tailscale debug peer-path" command. It
+// explains how magicsock currently sends packets to a peer and how it came
+// to choose that path.
+type PeerPath struct {
+	// Path is the path currently in use. While a direct or peer relay path
+	// is being re-confirmed, packets are sent over DERP as well, and Path
+	// is PathDERP.
+	Path PathType
+
+	// Addr is the UDP address of a direct path, of the form "{ip}:{port}",
+	// or of a peer relay path, of the form "{ip}:{port}:vni:{vni}". It's
+	// empty if Path is PathDERP.
+	Addr string `json:",omitempty"`
+
+	// BestAddr is the best direct or peer relay path known, even if it's
+	// not currently trusted, in the same form as Addr.
+	BestAddr string `json:",omitempty"`
+
+	// BestAddrLatencySeconds is the round-trip latency of BestAddr.
+	BestAddrLatencySeconds float64 `json:",omitempty"`
+
+	// BestAddrTrustedUntil is when BestAddr stops being trusted unless
+	// it's re-confirmed by a pong.
+	BestAddrTrustedUntil time.Time `json:",omitzero"`
+
+	// DERPRegionID and DERPRegionCode are the peer's home DERP region.
+	DERPRegionID   int    `json:",omitempty"`
+	DERPRegionCode string `json:",omitempty"`
+
+	// Reason is a human-readable explanation of why Path is in use.
+	Reason string
+
+	// Candidates are the peer's direct UDP endpoints magicsock knows of,
+	// sorted by address.
+	Candidates []PeerPathCandidate
+
+	// History is the most recent path changes, oldest first.
+	History []PeerPathChange
+}
+
+// PeerPathCandidate is a candidate direct UDP endpoint of a peer.
+type PeerPathCandidate struct {
+	Addr string // "{ip}:{port}"
+
+	// Source is where the endpoint came from: "netmap" for the endpoints
+	// control sent, "call-me-maybe" for ones the peer sent over DERP, or
+	// "disco-ping" for ones learned from a ping the peer sent.
+	Source string
+
+	LastPing       time.Time `json:",omitzero"`  // when we last pinged it
+	LastPong       time.Time `json:",omitzero"`  // when we last got a pong from it
+	LatencySeconds float64   `json:",omitempty"` // of the last pong
+	Pongs          int       `json:",omitempty"` // number of recent pongs
+}
+
+// PeerPathChange is a change of the path used to send packets to a peer.
+type PeerPathChange struct {
+	When     time.Time
+	From     PathType
+	FromAddr string `json:",omitempty"`
+	To       PathType
+	ToAddr   string `json:",omitempty"`
+
+	// LatencySeconds is the round-trip latency of the new path, if known.
+	LatencySeconds float64 `json:",omitempty"`
+
+	// Reason is a human-readable explanation of the change.
+	Reason string
+}
+
 type SelfUpdateStatus string
 
 const (
@@ -24,9 +24,11 @@
 	"tailscale.com/feature"
 	"tailscale.com/feature/buildfeatures"
 	"tailscale.com/ipn"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/eventbus"
 	"tailscale.com/util/httpm"
+	"tailscale.com/wgengine/filter/filtertype"
 )
 
 func init() {
@@ -39,10 +41,12 @@ func init() {
 	Register("debug-bus-queues", (*Handler).serveDebugBusQueues)
 	Register("debug-derp-region", (*Handler).serveDebugDERPRegion)
 	Register("debug-dial-types", (*Handler).serveDebugDialTypes)
+	Register("debug-filter-trace", (*Handler).serveDebugFilterTrace)
 	Register("debug-log", (*Handler).serveDebugLog)
 	Register("debug-packet-filter-matches", (*Handler).serveDebugPacketFilterMatches)
 	Register("debug-packet-filter-rules", (*Handler).serveDebugPacketFilterRules)
 	Register("debug-peer-endpoint-changes", (*Handler).serveDebugPeerEndpointChanges)
+	Register("debug-peer-path", (*Handler).serveDebugPeerPath)
 	Register("debug-optional-features", (*Handler).serveDebugOptionalFeatures)
 }
 
@@ -74,6 +78,28 @@ func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.R
 	e.Encode(chs)
 }
 
+func (h *Handler) serveDebugPeerPath(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'ip' parameter", http.StatusBadRequest)
+		return
+	}
+	pp, err := h.b.GetPeerPath(r.Context(), ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(pp)
+}
+
 func (h *Handler) serveComponentDebugLogging(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
@@ -284,6 +310,78 @@ func (h *Handler) serveDebugPacketFilterRules(w http.ResponseWriter, r *http.Req
 	enc.Encode(nm.PacketFilterRules)
 }
 
+// serveDebugFilterTrace reports how the packet filter treats a packet from
+// the "src" to the "dst" ip[:port] using protocol "proto" (default tcp),
+// from a peer, or to one if "dir" is "out". With "live=true", it instead
+// streams traces of sampled live packets, only dropped ones if
+// "drops=true".
+func (h *Handler) serveDebugFilterTrace(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+
+	if r.FormValue("live") == "true" {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		enc := json.NewEncoder(w)
+		err := h.b.WatchFilterTraces(r.Context(), r.FormValue("drops") == "true", func(tr filtertype.Trace) {
+			if enc.Encode(tr) == nil {
+				f.Flush()
+			}
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	src, err := parseFilterTraceAddr(r.FormValue("src"))
+	if err != nil {
+		http.Error(w, "invalid 'src': "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dst, err := parseFilterTraceAddr(r.FormValue("dst"))
+	if err != nil {
+		http.Error(w, "invalid 'dst': "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	proto := ipproto.TCP
+	if v := r.FormValue("proto"); v != "" {
+		if err := proto.UnmarshalText([]byte(v)); err != nil {
+			http.Error(w, "invalid 'proto': "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var inbound bool
+	switch r.FormValue("dir") {
+	case "", "in":
+		inbound = true
+	case "out":
+	default:
+		http.Error(w, "'dir' must be in or out", http.StatusBadRequest)
+		return
+	}
+	tr, err := h.b.TraceFilter(src, dst, proto, inbound)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tr)
+}
+
+// parseFilterTraceAddr parses s as an ip:port or, with port 0, an IP.
+func parseFilterTraceAddr(s string) (netip.AddrPort, error) {
+	if ip, err := netip.ParseAddr(s); err == nil {
+		return netip.AddrPortFrom(ip, 0), nil
+	}
+	return netip.ParseAddrPort(s)
+}
+
 func (h *Handler) serveDebugPacketFilterMatches(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
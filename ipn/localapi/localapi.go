@@ -41,6 +41,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
 	"tailscale.com/types/appctype"
+	"tailscale.com/types/dnstype"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -80,6 +81,7 @@
 	"goroutines":           (*Handler).serveGoroutines,
 	"login-interactive":    (*Handler).serveLoginInteractive,
 	"logout":               (*Handler).serveLogout,
+	"netcheck-history":     (*Handler).serveNetcheckHistory,
 	"ping":                 (*Handler).servePing,
 	"prefs":                (*Handler).servePrefs,
 	"reload-config":        (*Handler).reloadConfig,
@@ -1103,6 +1105,23 @@ func (h *Handler) serveDERPMap(w http.ResponseWriter, r *http.Request) {
 	e.Encode(h.b.DERPMap())
 }
 
+// serveNetcheckHistory returns the history of tailscaled's netcheck reports
+// as a JSON []netchecktype.HistoryEntry, oldest first.
+func (h *Handler) serveNetcheckHistory(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "netcheck history access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.MagicConn().NetcheckHistory())
+}
+
 // serveSetExpirySooner sets the expiry date on the current machine, specified
 // by an `expiry` unix timestamp as POST or query param.
 func (h *Handler) serveSetExpirySooner(w http.ResponseWriter, r *http.Request) {
@@ -1605,6 +1624,9 @@ func (h *Handler) serveDNSOSConfig(w http.ResponseWriter, r *http.Request) {
 // URL parameters:
 //   - name: the domain name to query
 //   - type: the DNS record type to query as a number (default if empty: A = '1')
+//   - resolver: optionally, the upstream resolver to query instead of the
+//     configured ones, in any form of [dnstype.Resolver.Addr], such as
+//     "tls://dns.example.com"
 //
 // The response if successful is a DNSQueryResponse JSON object.
 func (h *Handler) serveDNSQuery(w http.ResponseWriter, r *http.Request) {
@@ -1634,7 +1656,12 @@ func (h *Handler) serveDNSQuery(w http.ResponseWriter, r *http.Request) {
 		qt = t
 	}
 
-	res, rrs, err := h.b.QueryDNS(name, qt)
+	var upstream *dnstype.Resolver
+	if r := q.Get("resolver"); r != "" {
+		upstream = &dnstype.Resolver{Addr: r}
+	}
+
+	res, rrs, err := h.b.QueryDNS(name, qt, upstream)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
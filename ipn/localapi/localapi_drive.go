@@ -8,18 +8,24 @@
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
 
 	"tailscale.com/drive"
 	"tailscale.com/util/httpm"
+	"tailscale.com/util/osuser"
 )
 
 func init() {
 	Register("drive/fileserver-address", (*Handler).serveDriveServerAddr)
 	Register("drive/shares", (*Handler).serveShares)
+	Register("drive/mounts", (*Handler).serveMounts)
 }
 
 // serveDriveServerAddr handles updates of the Taildrive file server address.
@@ -139,3 +145,89 @@ func (h *Handler) serveShares(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
 	}
 }
+
+// serveMounts handles the management of FUSE mounts of remote Taildrive
+// shares. Mounts belong to the connected user, who must own the directory
+// being mounted.
+//
+// PUT - mounts remote shares at the directory given in the body
+// DELETE - unmounts the directory given in the body
+// GET - gets a list of all mounted directories, sorted
+func (h *Handler) serveMounts(w http.ResponseWriter, r *http.Request) {
+	if !h.b.DriveAccessEnabled() {
+		http.Error(w, `taildrive access not enabled, please add the attribute "drive:access" to this node in your ACLs' "nodeAttrs" section`, http.StatusForbidden)
+		return
+	}
+	if r.Method == httpm.GET {
+		if err := json.NewEncoder(w).Encode(h.b.DriveMounts()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if r.Method != httpm.PUT && r.Method != httpm.DELETE {
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+	if runtime.GOOS != "linux" {
+		http.Error(w, "mounting shares is only supported on Linux", http.StatusNotImplemented)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dir := filepath.Clean(string(b))
+	if !filepath.IsAbs(dir) {
+		http.Error(w, "mount directory must be an absolute path", http.StatusBadRequest)
+		return
+	}
+	uid, gid, err := h.actorIDs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if r.Method == httpm.PUT {
+		if err := h.b.DriveMount(dir, uid, gid); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if err := h.b.DriveUnmount(dir, uid); err != nil {
+		switch {
+		case errors.Is(err, drive.ErrNotMounted):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, drive.ErrNotMountOwner):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// actorIDs returns the numeric user and group IDs of the connected user.
+func (h *Handler) actorIDs() (uid, gid int, err error) {
+	username, err := h.Actor.Username()
+	if err != nil {
+		return 0, 0, err
+	}
+	u, err := osuser.LookupByUsername(username)
+	if err != nil {
+		return 0, 0, err
+	}
+	uid, err = strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected uid %q", u.Uid)
+	}
+	gid, err = strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected gid %q", u.Gid)
+	}
+	return uid, gid, nil
+}
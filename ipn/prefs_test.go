@@ -70,6 +70,7 @@ func TestPrefsEqual(t *testing.T) {
 		"DriveShares",
 		"RelayServerPort",
 		"RelayServerStaticEndpoints",
+		"TaildropRules",
 		"AllowSingleHosts",
 		"Persist",
 	}
@@ -157,6 +157,12 @@ type TCPPortHandler struct {
 	//
 	// This is only valid if TCPForward is non-empty.
 	ProxyProtocol int `json:",omitzero"`
+
+	// AllowFrom, if non-empty, restricts which peers may connect to this
+	// port. Each entry is either a tag (e.g. "tag:prod") or a user login name
+	// (e.g. "alice@example.com"). Connections from peers that match none of
+	// the entries, including funneled connections, are closed.
+	AllowFrom []string `json:",omitempty"`
 }
 
 // HTTPHandler is either a path or a proxy to serve.
@@ -170,6 +176,11 @@ type HTTPHandler struct {
 
 	AcceptAppCaps []tailcfg.PeerCapability `json:",omitempty"` // peer capabilities to forward in grant header, e.g. example.com/cap/mon
 
+	// AllowFrom, if non-empty, restricts which peers may use this handler,
+	// in the same format as TCPPortHandler.AllowFrom. Requests from other
+	// peers get a 403 Forbidden response.
+	AllowFrom []string `json:",omitempty"`
+
 	// Redirect, if not empty, is the target URL to redirect requests to.
 	// By default, we redirect with HTTP 302 (Found) status.
 	// If Redirect starts with '<httpcode>:', then we use that status instead.
@@ -183,6 +194,35 @@ type HTTPHandler struct {
 	// temporary ones? Error codes?
 }
 
+// ServeAccessDenial describes a connection or HTTP request that serve denied
+// because of an AllowFrom restriction.
+type ServeAccessDenial struct {
+	Service tailcfg.ServiceName `json:",omitempty"` // or empty for the node's own serve config
+	Port    uint16
+	Path    string `json:",omitempty"` // mount point of the HTTP handler, or empty if denied at the TCP level
+
+	// Peer is the identity that was denied: the peer's tags, comma-separated,
+	// or its user's login name, or empty for connections from outside the
+	// tailnet.
+	Peer     string `json:",omitempty"`
+	PeerAddr netip.AddrPort
+}
+
+func (d *ServeAccessDenial) String() string {
+	who := d.Peer
+	if who == "" {
+		who = "unknown peer"
+	}
+	dst := fmt.Sprintf("port %d", d.Port)
+	if d.Service != "" {
+		dst = fmt.Sprintf("%s %s", d.Service, dst)
+	}
+	if d.Path != "" {
+		dst += " path " + d.Path
+	}
+	return fmt.Sprintf("%s (%v) denied access to %s", who, d.PeerAddr, dst)
+}
+
 // WebHandlerExists reports whether if the ServeConfig Web handler exists for
 // the given host:port and mount point.
 func (sc *ServeConfig) WebHandlerExists(svcName tailcfg.ServiceName, hp HostPort, mount string) bool {
@@ -0,0 +1,142 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_encstore
+
+package encstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"tailscale.com/envknob"
+)
+
+// Environment variables that configure the KeyProvider of stores created
+// with New. Exactly one of them must be set.
+const (
+	// envKeyFile is the path to a file that contains the keys.
+	envKeyFile = "TS_STATE_KEY_FILE"
+	// envKey contains the keys.
+	envKey = "TS_STATE_KEY"
+	// envKeyCommand is a command, and its space separated arguments, that
+	// prints the keys.
+	envKeyCommand = "TS_STATE_KEY_COMMAND"
+)
+
+// keyCommandTimeout is how long a KeyCommand may run for.
+const keyCommandTimeout = 30 * time.Second
+
+// KeyProvider provides the keys to encrypt and decrypt the state file with.
+type KeyProvider interface {
+	// Keys returns one or more 32 byte AES-256 keys. The first key is used
+	// to encrypt the state file. The other keys are only used to decrypt
+	// it, which allows rotating keys by adding a new first key: the state
+	// file is encrypted with the new key when it's next opened.
+	Keys() ([][]byte, error)
+
+	// String describes where the keys come from, for logging.
+	String() string
+}
+
+// KeyFile is a KeyProvider that reads the keys from the file at the given
+// path. The file contains the base64 encoded keys, separated by whitespace.
+type KeyFile string
+
+func (p KeyFile) Keys() ([][]byte, error) {
+	b, err := os.ReadFile(string(p))
+	if err != nil {
+		return nil, err
+	}
+	return parseKeys(string(b))
+}
+
+func (p KeyFile) String() string { return fmt.Sprintf("key file %q", string(p)) }
+
+// KeyEnv is a KeyProvider that reads the keys from the environment variable
+// with the given name. The variable contains the base64 encoded keys,
+// separated by whitespace.
+type KeyEnv string
+
+func (p KeyEnv) Keys() ([][]byte, error) {
+	v, ok := os.LookupEnv(string(p))
+	if !ok {
+		return nil, fmt.Errorf("%s is not set", string(p))
+	}
+	return parseKeys(v)
+}
+
+func (p KeyEnv) String() string { return fmt.Sprintf("environment variable %s", string(p)) }
+
+// KeyCommand is a KeyProvider that runs a command and reads the keys from
+// its output, for example to fetch them from a secrets manager. The first
+// element is the program to run, and the rest are its arguments. The command
+// prints the base64 encoded keys, separated by whitespace.
+type KeyCommand []string
+
+func (p KeyCommand) Keys() ([][]byte, error) {
+	if len(p) == 0 {
+		return nil, errors.New("no key command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), keyCommandTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p[0], p[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %q: %w; stderr: %s", p[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return parseKeys(string(out))
+}
+
+func (p KeyCommand) String() string { return fmt.Sprintf("key command %q", strings.Join(p, " ")) }
+
+// parseKeys parses whitespace separated base64 encoded 32 byte keys.
+func parseKeys(s string) ([][]byte, error) {
+	var keys [][]byte
+	for i, f := range strings.Fields(s) {
+		k, err := base64.StdEncoding.DecodeString(f)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
+		}
+		if len(k) != 32 {
+			return nil, fmt.Errorf("key %d is %d bytes, want 32", i+1, len(k))
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no keys found")
+	}
+	return keys, nil
+}
+
+// keyProviderFromEnv returns the KeyProvider configured with environment
+// variables.
+func keyProviderFromEnv() (KeyProvider, error) {
+	var kps []KeyProvider
+	if v := envknob.String(envKeyFile); v != "" {
+		kps = append(kps, KeyFile(v))
+	}
+	if v := envknob.String(envKey); v != "" {
+		kps = append(kps, KeyEnv(envKey))
+	}
+	if v := envknob.String(envKeyCommand); v != "" {
+		kps = append(kps, KeyCommand(strings.Fields(v)))
+	}
+	switch len(kps) {
+	case 0:
+		return nil, fmt.Errorf("no encryption key configured; set one of %s, %s or %s", envKeyFile, envKey, envKeyCommand)
+	case 1:
+		return kps[0], nil
+	default:
+		return nil, fmt.Errorf("only one of %s, %s and %s can be set", envKeyFile, envKey, envKeyCommand)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_encstore
+
+package encstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestKeyProviders(t *testing.T) {
+	keys := newKeys(2)
+	want := make([][]byte, len(keys))
+	for i, k := range keys {
+		want[i], _ = base64.StdEncoding.DecodeString(k)
+	}
+
+	t.Setenv("TEST_STATE_KEYS", strings.Join(keys, " "))
+	providers := []KeyProvider{
+		writeKeyFile(t, keys...),
+		KeyEnv("TEST_STATE_KEYS"),
+	}
+	if _, err := exec.LookPath("echo"); err == nil {
+		providers = append(providers, KeyCommand{"echo", keys[0], keys[1]})
+	}
+	for _, kp := range providers {
+		t.Run(fmt.Sprintf("%T", kp), func(t *testing.T) {
+			got, err := kp.Keys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got %d keys, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if !bytes.Equal(got[i], want[i]) {
+					t.Errorf("key %d = %x, want %x", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	short := base64.StdEncoding.EncodeToString(make([]byte, 16))
+	for _, tt := range []struct {
+		in      string
+		want    int
+		wantErr string
+	}{
+		{in: newKeys(1)[0], want: 1},
+		{in: strings.Join(newKeys(3), "\n") + "\n", want: 3},
+		{in: " \n", wantErr: "no keys found"},
+		{in: "not base64!", wantErr: "key 1"},
+		{in: newKeys(1)[0] + " " + short, wantErr: "key 2 is 16 bytes, want 32"},
+	} {
+		got, err := parseKeys(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseKeys(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseKeys(%q): %v", tt.in, err)
+			continue
+		}
+		if len(got) != tt.want {
+			t.Errorf("parseKeys(%q) returned %d keys, want %d", tt.in, len(got), tt.want)
+		}
+	}
+}
+
+func TestKeyProviderFromEnv(t *testing.T) {
+	t.Setenv(envKeyFile, "")
+	t.Setenv(envKey, "")
+	t.Setenv(envKeyCommand, "")
+	if _, err := keyProviderFromEnv(); err == nil {
+		t.Errorf("keyProviderFromEnv succeeded with no keys configured")
+	}
+
+	t.Setenv(envKeyCommand, "get-key --name state")
+	kp, err := keyProviderFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(kp), fmt.Sprint(KeyCommand{"get-key", "--name", "state"}); got != want {
+		t.Errorf("keyProviderFromEnv = %v, want %v", got, want)
+	}
+
+	t.Setenv(envKeyFile, "/path/to/keys")
+	if _, err := keyProviderFromEnv(); err == nil {
+		t.Errorf("keyProviderFromEnv succeeded with two key sources")
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_encstore
+
+// Package encstore contains an ipn.StateStore implementation that stores the
+// state in a local file encrypted with AES-256-GCM, using keys from a file,
+// the environment or an external command.
+package encstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store"
+	"tailscale.com/paths"
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	store.Register(store.EncryptedFilePrefix, New)
+}
+
+// keyIDLen is the length of key IDs, which are the first bytes of the
+// SHA-256 of the key.
+const keyIDLen = 8
+
+// New returns a new Store that stores the state in the file at path, which
+// may have a store.EncryptedFilePrefix prefix. The keys are read from the
+// file named by $TS_STATE_KEY_FILE, from $TS_STATE_KEY, or from the output
+// of the command in $TS_STATE_KEY_COMMAND.
+func New(logf logger.Logf, path string) (ipn.StateStore, error) {
+	kp, err := keyProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewWithKeyProvider(logf, path, kp)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewWithKeyProvider returns a new Store that stores the state in the file
+// at path, which may have a store.EncryptedFilePrefix prefix, encrypted with
+// keys from kp.
+//
+// If the file was encrypted with a key other than the first key from kp, it
+// is encrypted again with the first key.
+func NewWithKeyProvider(logf logger.Logf, path string, kp KeyProvider) (*Store, error) {
+	path = strings.TrimPrefix(path, store.EncryptedFilePrefix)
+	if err := paths.MkStateDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+	keys, err := kp.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("reading keys from %v: %w", kp, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys from %v", kp)
+	}
+	var aeads []keyAEAD
+	for _, k := range keys {
+		aead, err := newAEAD(k)
+		if err != nil {
+			return nil, err
+		}
+		aeads = append(aeads, aead)
+	}
+	s := &Store{
+		logf:  logf,
+		path:  path,
+		keys:  aeads,
+		cache: make(map[ipn.StateKey][]byte),
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to open %q: %w", path, err)
+		}
+		logf("encstore: initializing state file")
+		if err := s.writeEncrypted(); err != nil {
+			return nil, fmt.Errorf("failed to write initial state file: %w", err)
+		}
+		return s, nil
+	}
+
+	var enc encryptedData
+	if err := json.Unmarshal(bs, &enc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state file: %w", err)
+	}
+	if len(enc.KeyID) != keyIDLen || len(enc.Nonce) == 0 || len(enc.Ciphertext) == 0 {
+		return nil, fmt.Errorf("state file %q has not been encrypted or is corrupt", path)
+	}
+	data, stale, err := s.decrypt(enc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt state file: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.cache); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if stale {
+		logf("encstore: encrypting state file with new key %x", s.keys[0].id)
+		if err := s.writeEncrypted(); err != nil {
+			return nil, fmt.Errorf("failed to re-encrypt state file: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Store is an ipn.StateStore that stores the state in a file encrypted with
+// AES-256-GCM.
+type Store struct {
+	ipn.EncryptedStateStore
+
+	logf logger.Logf
+	path string
+	keys []keyAEAD // keys[0] encrypts; all keys decrypt
+
+	mu    sync.RWMutex
+	cache map[ipn.StateKey][]byte
+}
+
+type keyAEAD struct {
+	id [keyIDLen]byte
+	cipher.AEAD
+}
+
+func newAEAD(key []byte) (keyAEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return keyAEAD{}, err
+	}
+	aead, err := cipher.NewGCM(b)
+	if err != nil {
+		return keyAEAD{}, err
+	}
+	k := keyAEAD{AEAD: aead}
+	h := sha256.Sum256(key)
+	copy(k.id[:], h[:])
+	return k, nil
+}
+
+// encryptedData is the format of the state file. The key ID is authenticated
+// along with the ciphertext. All fields are required.
+type encryptedData struct {
+	KeyID      []byte `json:"keyID"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// decrypt decrypts enc. It also reports whether enc needs to be encrypted
+// again because it was encrypted with a key other than the first key.
+func (s *Store) decrypt(enc encryptedData) (_ []byte, stale bool, _ error) {
+	for i, k := range s.keys {
+		if !bytes.Equal(k.id[:], enc.KeyID) {
+			continue
+		}
+		if len(enc.Nonce) != k.NonceSize() {
+			return nil, false, fmt.Errorf("nonce is %d bytes, want %d", len(enc.Nonce), k.NonceSize())
+		}
+		pt, err := k.Open(nil, enc.Nonce, enc.Ciphertext, enc.KeyID)
+		if err != nil {
+			return nil, false, err
+		}
+		return pt, i != 0, nil
+	}
+	return nil, false, fmt.Errorf("encrypted with unknown key %x", enc.KeyID)
+}
+
+func (s *Store) ReadState(k ipn.StateKey) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.cache[k]
+	if !ok {
+		return nil, ipn.ErrStateNotExist
+	}
+	return bytes.Clone(v), nil
+}
+
+func (s *Store) WriteState(k ipn.StateKey, bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes.Equal(s.cache[k], bs) {
+		return nil
+	}
+	s.cache[k] = bytes.Clone(bs)
+
+	return s.writeEncrypted()
+}
+
+// writeEncrypted writes the cached state to the state file, encrypted with
+// the first key.
+func (s *Store) writeEncrypted() error {
+	bs, err := json.Marshal(s.cache)
+	if err != nil {
+		return err
+	}
+	k := s.keys[0]
+	nonce := make([]byte, k.NonceSize())
+	// crypto/rand.Read never returns an error.
+	rand.Read(nonce)
+	buf, err := json.Marshal(encryptedData{
+		KeyID:      k.id[:],
+		Nonce:      nonce,
+		Ciphertext: k.Seal(nil, nonce, bs, k.id[:]),
+	})
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.path, buf, 0600)
+}
+
+func (s *Store) All() iter.Seq2[ipn.StateKey, []byte] {
+	return func(yield func(ipn.StateKey, []byte) bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for k, v := range s.cache {
+			if !yield(k, v) {
+				break
+			}
+		}
+	}
+}
+
+// Ensure Store implements store.ExportableStore for migration to/from
+// store.FileStore.
+var _ store.ExportableStore = (*Store)(nil)
@@ -0,0 +1,204 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_encstore
+
+package encstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store"
+)
+
+// newKeys returns n new random base64 encoded keys.
+func newKeys(n int) []string {
+	var keys []string
+	for range n {
+		var k [32]byte
+		rand.Read(k[:])
+		keys = append(keys, base64.StdEncoding.EncodeToString(k[:]))
+	}
+	return keys
+}
+
+// writeKeyFile writes keys to a new key file and returns its KeyFile.
+func writeKeyFile(t *testing.T, keys ...string) KeyFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(strings.Join(keys, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return KeyFile(path)
+}
+
+func checkState(t *testing.T, s ipn.StateStore, k ipn.StateKey, want []byte) {
+	t.Helper()
+	got, err := s.ReadState(k)
+	if err != nil {
+		t.Errorf("ReadState(%q): %v", k, err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("ReadState(%q): got %q, want %q", k, got, want)
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := store.EncryptedFilePrefix + filepath.Join(t.TempDir(), "state")
+	kp := writeKeyFile(t, newKeys(1)...)
+	s, err := NewWithKeyProvider(t.Logf, path, kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k1, k2 := ipn.StateKey("k1"), ipn.StateKey("k2")
+	v1, v2 := []byte("v1"), []byte("v2")
+
+	if _, err := s.ReadState(k1); !errors.Is(err, ipn.ErrStateNotExist) {
+		t.Errorf("ReadState(%q) = %v, want %v", k1, err, ipn.ErrStateNotExist)
+	}
+	for k, v := range map[ipn.StateKey][]byte{k1: v1, k2: v2} {
+		if err := s.WriteState(k, v); err != nil {
+			t.Fatalf("WriteState(%q, %q): %v", k, v, err)
+		}
+		checkState(t, s, k, v)
+	}
+
+	buf, err := os.ReadFile(strings.TrimPrefix(path, store.EncryptedFilePrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf, v1) || bytes.Contains(buf, []byte(k1)) {
+		t.Errorf("state file contains plaintext state: %s", buf)
+	}
+
+	s, err = NewWithKeyProvider(t.Logf, path, kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkState(t, s, k1, v1)
+	checkState(t, s, k2, v2)
+
+	if _, err := NewWithKeyProvider(t.Logf, path, writeKeyFile(t, newKeys(1)...)); err == nil {
+		t.Errorf("opening state file with wrong key succeeded")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	keys := newKeys(2)
+	oldKey, newKey := keys[0], keys[1]
+	k, v := ipn.StateKey("k"), []byte("v")
+
+	s, err := NewWithKeyProvider(t.Logf, path, writeKeyFile(t, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteState(k, v); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the store with the new key first should re-encrypt the state
+	// file with it.
+	s, err = NewWithKeyProvider(t.Logf, path, writeKeyFile(t, newKey, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkState(t, s, k, v)
+
+	// The old key is no longer needed.
+	s, err = NewWithKeyProvider(t.Logf, path, writeKeyFile(t, newKey))
+	if err != nil {
+		t.Fatalf("opening state file with only new key: %v", err)
+	}
+	checkState(t, s, k, v)
+	if _, err := NewWithKeyProvider(t.Logf, path, writeKeyFile(t, oldKey)); err == nil {
+		t.Errorf("opening state file with only old key succeeded")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	t.Setenv(envKey, newKeys(1)[0])
+
+	storePath := filepath.Join(t.TempDir(), "store")
+	// Make sure migration doesn't cause a failure when no state file exists.
+	if _, err := store.New(t.Logf, store.EncryptedFilePrefix+storePath); err != nil {
+		t.Fatalf("store.New failed for new encrypted store: %v", err)
+	}
+	os.Remove(storePath)
+
+	initial, err := store.New(t.Logf, storePath)
+	if err != nil {
+		t.Fatalf("store.New failed for new file store: %v", err)
+	}
+	content := map[ipn.StateKey][]byte{
+		"foo": []byte("bar"),
+		"baz": []byte("qux"),
+	}
+	for k, v := range content {
+		if err := initial.WriteState(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Expected file keys for plaintext and encrypted versions of state.
+	keysPlaintext := []string{"baz", "foo"}
+	keysEncrypted := []string{"ciphertext", "keyID", "nonce"}
+
+	for _, tt := range []struct {
+		desc     string
+		path     string
+		wantKeys []string
+	}{
+		{
+			desc:     "plaintext-to-encrypted",
+			path:     store.EncryptedFilePrefix + storePath,
+			wantKeys: keysEncrypted,
+		},
+		{
+			desc:     "encrypted-to-encrypted",
+			path:     store.EncryptedFilePrefix + storePath,
+			wantKeys: keysEncrypted,
+		},
+		{
+			desc:     "encrypted-to-plaintext",
+			path:     storePath,
+			wantKeys: keysPlaintext,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			s, err := store.New(t.Logf, tt.path)
+			if err != nil {
+				t.Fatalf("migration failed: %v", err)
+			}
+			gotContent := maps.Collect(s.(store.ExportableStore).All())
+			if diff := cmp.Diff(content, gotContent); diff != "" {
+				t.Errorf("unexpected content after migration, diff:\n%s", diff)
+			}
+
+			buf, err := os.ReadFile(storePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var data map[string]any
+			if err := json.Unmarshal(buf, &data); err != nil {
+				t.Fatal(err)
+			}
+			gotKeys := slices.Sorted(maps.Keys(data))
+			if diff := cmp.Diff(gotKeys, tt.wantKeys); diff != "" {
+				t.Errorf("unexpected content keys after migration, diff:\n%s", diff)
+			}
+		})
+	}
+}
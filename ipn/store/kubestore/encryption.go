@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package kubestore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedValuePrefix marks state values that are encrypted. It starts with
+// a NUL byte so that it can't be mistaken for the start of a plaintext state
+// value.
+const encryptedValuePrefix = "\x00tsenc1"
+
+const (
+	keyIDLen      = 8
+	nonceLen      = 12
+	dataKeyLen    = 32
+	wrappedKeyLen = dataKeyLen + 16 // AES-GCM tag
+)
+
+// keyring encrypts and decrypts state values with envelope encryption: each
+// value is encrypted with a new random data key, which is in turn encrypted
+// with a key encryption key from the keyring.
+//
+// An encrypted value is encryptedValuePrefix, followed by the ID of the key
+// encryption key, the nonce and encrypted data key, and the nonce and
+// encrypted value. The name of the value is authenticated, so that encrypted
+// values can't be swapped between names.
+type keyring struct {
+	keys []*encryptionKey // keys[0] encrypts; all keys decrypt
+}
+
+type encryptionKey struct {
+	id   [keyIDLen]byte // first bytes of the SHA-256 of the key
+	aead cipher.AEAD
+}
+
+// loadKeyring loads the keys in the file at path. The file contains one or
+// more base64 encoded 32 byte keys, one per line. The first key is used to
+// encrypt values. The other keys are only used to decrypt values, which
+// allows rotating keys by adding a new first key.
+func loadKeyring(path string) (*keyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading encryption key file: %w", err)
+	}
+	kr := new(keyring)
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		k, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: error decoding key: %w", path, i+1, err)
+		}
+		if len(k) != 32 {
+			return nil, fmt.Errorf("%s:%d: key is %d bytes, want 32", path, i+1, len(k))
+		}
+		aead, err := newAEAD(k)
+		if err != nil {
+			return nil, err
+		}
+		ek := &encryptionKey{aead: aead}
+		h := sha256.Sum256(k)
+		copy(ek.id[:], h[:])
+		kr.keys = append(kr.keys, ek)
+	}
+	if len(kr.keys) == 0 {
+		return nil, fmt.Errorf("%s: no encryption keys found", path)
+	}
+	return kr, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// isEncrypted reports whether v is an encrypted state value.
+func isEncrypted(v []byte) bool {
+	return bytes.HasPrefix(v, []byte(encryptedValuePrefix))
+}
+
+// encrypt encrypts the value with the given name.
+func (kr *keyring) encrypt(name string, v []byte) ([]byte, error) {
+	kek := kr.keys[0]
+	dataKey := make([]byte, dataKeyLen)
+	rand.Read(dataKey)
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedValuePrefix)+keyIDLen+2*nonceLen+wrappedKeyLen+len(v)+aead.Overhead())
+	out = append(out, encryptedValuePrefix...)
+	out = append(out, kek.id[:]...)
+	out = appendNonce(out)
+	out = kek.aead.Seal(out, out[len(out)-nonceLen:], dataKey, kek.id[:])
+	out = appendNonce(out)
+	return aead.Seal(out, out[len(out)-nonceLen:], v, []byte(name)), nil
+}
+
+func appendNonce(b []byte) []byte {
+	b = append(b, make([]byte, nonceLen)...)
+	rand.Read(b[len(b)-nonceLen:])
+	return b
+}
+
+// decrypt decrypts the encrypted value with the given name. It also reports
+// whether the value needs to be encrypted again because it was encrypted
+// with a key other than the first key.
+func (kr *keyring) decrypt(name string, v []byte) (_ []byte, stale bool, _ error) {
+	v = v[len(encryptedValuePrefix):]
+	if len(v) < keyIDLen+2*nonceLen+wrappedKeyLen {
+		return nil, false, errors.New("encrypted value is too short")
+	}
+	id, v := v[:keyIDLen], v[keyIDLen:]
+	nonce, v := v[:nonceLen], v[nonceLen:]
+	wrapped, v := v[:wrappedKeyLen], v[wrappedKeyLen:]
+	for i, k := range kr.keys {
+		if !bytes.Equal(k.id[:], id) {
+			continue
+		}
+		dataKey, err := k.aead.Open(nil, nonce, wrapped, id)
+		if err != nil {
+			return nil, false, fmt.Errorf("error decrypting data key: %w", err)
+		}
+		aead, err := newAEAD(dataKey)
+		if err != nil {
+			return nil, false, err
+		}
+		pt, err := aead.Open(nil, v[:nonceLen], v[nonceLen:], []byte(name))
+		if err != nil {
+			return nil, false, fmt.Errorf("error decrypting value: %w", err)
+		}
+		return pt, i != 0, nil
+	}
+	return nil, false, fmt.Errorf("encrypted with unknown key %x", id)
+}
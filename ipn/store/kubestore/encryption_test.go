@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package kubestore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyring(t *testing.T) {
+	dir := t.TempDir()
+	key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	mustLoad := func(contents string) *keyring {
+		t.Helper()
+		p := filepath.Join(dir, "keys")
+		if err := os.WriteFile(p, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		kr, err := loadKeyring(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kr
+	}
+
+	kr1 := mustLoad(key1 + "\n")
+	v, err := kr1.encrypt("profile-a", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted(v) || bytes.Contains(v, []byte("secret")) {
+		t.Fatalf("value not encrypted: %q", v)
+	}
+	if got, stale, err := kr1.decrypt("profile-a", v); err != nil || stale || string(got) != "secret" {
+		t.Errorf("decrypt = %q, %v, %v; want %q, false, nil", got, stale, err, "secret")
+	}
+	if _, _, err := kr1.decrypt("profile-b", v); err == nil {
+		t.Errorf("decrypt with a different name succeeded")
+	}
+	if _, _, err := kr1.decrypt("profile-a", v[:len(v)-1]); err == nil {
+		t.Errorf("decrypt of truncated value succeeded")
+	}
+
+	// After rotation, values encrypted with the old key are stale.
+	kr21 := mustLoad("\n" + key2 + "\n" + key1 + "\n")
+	if got, stale, err := kr21.decrypt("profile-a", v); err != nil || !stale || string(got) != "secret" {
+		t.Errorf("decrypt after rotation = %q, %v, %v; want %q, true, nil", got, stale, err, "secret")
+	}
+	kr2 := mustLoad(key2)
+	if _, _, err := kr2.decrypt("profile-a", v); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Errorf("decrypt with unknown key: got error %v, want unknown key error", err)
+	}
+
+	for _, bad := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		p := filepath.Join(dir, "bad")
+		if err := os.WriteFile(p, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadKeyring(p); err == nil {
+			t.Errorf("loadKeyring(%q) succeeded, want error", bad)
+		}
+	}
+}
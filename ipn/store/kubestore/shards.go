@@ -0,0 +1,209 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package kubestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"tailscale.com/kube/kubeclient"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/util/mak"
+)
+
+// manifestKey is the key in the state Secret of the manifest of the state
+// values that are stored in shard Secrets, as a JSON object of the state
+// keys to the names of the shard Secrets. The manifest is never encrypted.
+const manifestKey = "_shards"
+
+// NumShards is the number of shard Secrets that TLS certs and keys are
+// spread across when sharding is enabled.
+const NumShards = 8
+
+// ShardSecretNames returns the names of the shard Secrets of the state Secret
+// named stateSecret. They are fixed, so that whoever sets up the state Secret,
+// such as the Kubernetes operator, can also create the shards and grant
+// access to them by name.
+func ShardSecretNames(stateSecret string) []string {
+	names := make([]string, NumShards)
+	for i := range names {
+		names[i] = shardSecretNameAt(stateSecret, i)
+	}
+	return names
+}
+
+func shardSecretNameAt(stateSecret string, i int) string {
+	return fmt.Sprintf("%s-shard-%d", stateSecret, i)
+}
+
+// shardSecretName returns the name of the shard Secret for the given
+// sanitized state key, and whether the key is stored in a shard Secret when
+// sharding is enabled. The TLS cert and key for each domain are stored
+// together in the shard Secret picked by a hash of the domain.
+func (s *Store) shardSecretName(key string) (string, bool) {
+	domain, ok := strings.CutSuffix(key, ".crt")
+	if !ok {
+		domain, ok = strings.CutSuffix(key, ".key")
+	}
+	if !ok || domain == "" {
+		return "", false
+	}
+	h := sha256.Sum256([]byte(domain))
+	return shardSecretNameAt(s.secretName, int(binary.BigEndian.Uint32(h[:])%NumShards)), true
+}
+
+// isShardSecret reports whether the named Secret is a shard of the state
+// Secret.
+func (s *Store) isShardSecret(secret string) bool {
+	return slices.Contains(ShardSecretNames(s.secretName), secret)
+}
+
+// secretLabels returns the labels for a new Secret with the given name.
+func (s *Store) secretLabels(secret string) map[string]string {
+	if !s.isShardSecret(secret) {
+		return nil
+	}
+	return map[string]string{kubetypes.LabelSecretType: kubetypes.LabelSecretTypeStateShard}
+}
+
+// writeState writes data to the state Secret, encrypting the values if
+// encryption is enabled. If sharding is enabled, TLS certs and keys are
+// written to shard Secrets instead, and the manifest is updated to match.
+// Keys that were in a shard Secret but now go to the state Secret are removed
+// from the shard Secret once the state Secret no longer lists them there.
+func (s *Store) writeState(data map[string][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	main := make(map[string][]byte)
+	shards := make(map[string]map[string][]byte) // by shard Secret name
+	manifest := maps.Clone(s.shards)
+	var moved []string                 // keys moved from the state Secret to shards
+	unsharded := map[string][]string{} // keys moved out of shards, by shard Secret name
+	for k, v := range data {
+		k = sanitizeKey(k)
+		if s.keyring != nil {
+			var err error
+			if v, err = s.keyring.encrypt(k, v); err != nil {
+				return fmt.Errorf("error encrypting %q: %w", k, err)
+			}
+		}
+		if name, ok := s.shardSecretName(k); ok && s.sharding {
+			if shards[name] == nil {
+				shards[name] = make(map[string][]byte)
+			}
+			shards[name][k] = v
+			moved = append(moved, k)
+			mak.Set(&manifest, k, name)
+			continue
+		}
+		main[k] = v
+		// A key is either in the state Secret or in a shard, never both.
+		if name, ok := manifest[k]; ok {
+			unsharded[name] = append(unsharded[name], k)
+			delete(manifest, k)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(shards)) {
+		if err := s.updateSecret(shards[name], name); err != nil {
+			return err
+		}
+	}
+	changed := !maps.Equal(manifest, s.shards)
+	if changed {
+		b, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		main[manifestKey] = b
+	}
+	if len(main) == 0 {
+		return nil
+	}
+	if err := s.updateSecret(main, s.secretName, moved...); err != nil {
+		return err
+	}
+	s.shards = manifest
+	for _, name := range slices.Sorted(maps.Keys(unsharded)) {
+		// The state Secret has the values now, so failing to remove them
+		// from the shard only leaves stale copies behind.
+		if err := s.updateSecret(nil, name, unsharded[name]...); err != nil {
+			s.logf("kubestore: error removing %d state values from shard Secret %s: %v", len(unsharded[name]), name, err)
+		}
+	}
+	return nil
+}
+
+// readState returns the state in the data of the state Secret and in the
+// shard Secrets listed in its manifest, with any encrypted values decrypted.
+// It also returns the keys that need to be written again to match the
+// current encryption and sharding settings.
+func (s *Store) readState(ctx context.Context, secretData map[string][]byte) (data map[string][]byte, rewrite []string, _ error) {
+	data = make(map[string][]byte, len(secretData))
+	var manifest map[string]string
+	for k, v := range secretData {
+		if k == manifestKey {
+			if err := json.Unmarshal(v, &manifest); err != nil {
+				return nil, nil, fmt.Errorf("error parsing shard manifest: %w", err)
+			}
+			continue
+		}
+		data[k] = v
+	}
+
+	byShard := make(map[string][]string)
+	for k, name := range manifest {
+		byShard[name] = append(byShard[name], k)
+	}
+	for _, name := range slices.Sorted(maps.Keys(byShard)) {
+		secret, err := s.client.GetSecret(ctx, name)
+		if err != nil {
+			if kubeclient.IsNotFoundErr(err) {
+				s.logf("kubestore: [unexpected] shard Secret %s not found", name)
+				continue
+			}
+			return nil, nil, fmt.Errorf("error getting shard Secret %s: %w", name, err)
+		}
+		for _, k := range byShard[name] {
+			if v, ok := secret.Data[k]; ok {
+				data[k] = v
+			}
+		}
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(data)) {
+		_, inShard := manifest[k]
+		_, shardable := s.shardSecretName(k)
+		needsRewrite := inShard != (shardable && s.sharding)
+		v := data[k]
+		switch {
+		case isEncrypted(v):
+			if s.keyring == nil {
+				return nil, nil, fmt.Errorf("state value %q is encrypted, but %s is not set", k, envEncryptionKeyFile)
+			}
+			pt, stale, err := s.keyring.decrypt(k, v)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error decrypting state value %q: %w", k, err)
+			}
+			data[k] = pt
+			needsRewrite = needsRewrite || stale
+		case s.keyring != nil:
+			needsRewrite = true
+		}
+		if needsRewrite {
+			rewrite = append(rewrite, k)
+		}
+	}
+
+	s.mu.Lock()
+	s.shards = manifest
+	s.mu.Unlock()
+	return data, rewrite, nil
+}
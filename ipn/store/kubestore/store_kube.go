@@ -12,6 +12,7 @@
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"tailscale.com/envknob"
@@ -47,6 +48,22 @@ func init() {
 
 	keyTLSCert = "tls.crt"
 	keyTLSKey  = "tls.key"
+
+	// envEncryptionKeyFile is the environment variable that, if set, is
+	// the path to a file with the keys to encrypt state values with. See
+	// loadKeyring for the format of the file.
+	envEncryptionKeyFile = "TS_KUBE_STATE_ENCRYPTION_KEY_FILE"
+	// envSharding is the environment variable that, if set to true, makes
+	// the store write TLS certs and keys to separate Secrets, so that the
+	// state Secret does not grow past the 1MiB Secret size limit when
+	// serving many domains. The node needs permissions to get and patch
+	// those Secrets, and to create them unless they already exist, see
+	// ShardSecretNames.
+	//
+	// It has no effect in cert share mode (as used by Ingress ProxyGroups),
+	// where TLS certs and keys are already written to a Secret per domain
+	// rather than to the state Secret.
+	envSharding = "TS_KUBE_STATE_SHARDING"
 )
 
 // Store is an ipn.StateStore that uses a Kubernetes Secret for persistence.
@@ -59,9 +76,19 @@ type Store struct {
 
 	logf logger.Logf
 
+	// keyring, if non-nil, encrypts the values written to the state Secret
+	// and its shards.
+	keyring *keyring
+	// sharding is whether TLS certs and keys are written to shard Secrets
+	// instead of the state Secret.
+	sharding bool
+
 	// memory holds the latest tailscale state. Writes write state to a kube
 	// Secret and memory, Reads read from memory.
 	memory mem.Store
+
+	mu     sync.Mutex        // protects following
+	shards map[string]string // sanitized state key => shard Secret name, as in the manifest
 }
 
 // New returns a new Store that persists state to Kubernets Secret(s).
@@ -105,6 +132,15 @@ func newWithClient(logf logger.Logf, c kubeclient.Client, secretName string) (*S
 	} else if envknob.IsCertShareReadOnlyMode() {
 		s.certShareMode = "ro"
 	}
+	if p := envknob.String(envEncryptionKeyFile); p != "" {
+		if s.keyring, err = loadKeyring(p); err != nil {
+			return nil, err
+		}
+	}
+	s.sharding = envknob.Bool(envSharding)
+	if s.sharding && s.certShareMode != "" {
+		s.logf("kubestore: %s has no effect in cert share mode, which stores TLS certs in a Secret per domain", envSharding)
+	}
 
 	// Load latest state from kube Secret if it already exists.
 	if err := s.loadState(); err != nil && err != ipn.ErrStateNotExist {
@@ -147,7 +183,7 @@ func (s *Store) WriteState(id ipn.StateKey, bs []byte) (err error) {
 			s.memory.WriteState(ipn.StateKey(sanitizeKey(id)), bs)
 		}
 	}()
-	return s.updateSecret(map[string][]byte{string(id): bs}, s.secretName)
+	return s.writeState(map[string][]byte{string(id): bs})
 }
 
 // WriteTLSCertAndKey writes a TLS cert and key to domain.crt, domain.key fields
@@ -159,21 +195,20 @@ func (s *Store) WriteTLSCertAndKey(domain string, cert, key []byte) (err error)
 	if err := dnsname.ValidHostname(domain); err != nil {
 		return fmt.Errorf("invalid domain name %q: %w", domain, err)
 	}
-	secretName := s.secretName
-	data := map[string][]byte{
-		domain + ".crt": cert,
-		domain + ".key": key,
-	}
 	// If we run in cert share mode, cert and key for a DNS name are written
 	// to a separate Secret.
 	if s.certShareMode == "rw" {
-		secretName = domain
-		data = map[string][]byte{
+		err = s.updateSecret(map[string][]byte{
 			keyTLSCert: cert,
 			keyTLSKey:  key,
-		}
+		}, domain)
+	} else {
+		err = s.writeState(map[string][]byte{
+			domain + ".crt": cert,
+			domain + ".key": key,
+		})
 	}
-	if err := s.updateSecret(data, secretName); err != nil {
+	if err != nil {
 		return fmt.Errorf("error writing TLS cert and key to Secret: %w", err)
 	}
 	// TODO(irbekrm): certs for write replicas are currently not
@@ -260,7 +295,10 @@ func (s *Store) ReadTLSCertAndKey(domain string) (cert, key []byte, err error) {
 	return cert, key, nil
 }
 
-func (s *Store) updateSecret(data map[string][]byte, secretName string) (err error) {
+// updateSecret writes data to the named Secret, creating it if it's the state
+// Secret or one of its shards. Any of the keys in remove that the Secret has
+// are removed from it.
+func (s *Store) updateSecret(data map[string][]byte, secretName string, remove ...string) (err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer func() {
 		if err != nil {
@@ -276,6 +314,9 @@ func (s *Store) updateSecret(data map[string][]byte, secretName string) (err err
 	}()
 	secret, err := s.client.GetSecret(ctx, secretName)
 	if err != nil {
+		if kubeclient.IsNotFoundErr(err) && len(data) == 0 {
+			return nil // nothing to write or remove
+		}
 		// If the Secret does not exist, create it with the required data.
 		if kubeclient.IsNotFoundErr(err) && s.canCreateSecret(secretName) {
 			return s.client.CreateSecret(ctx, &kubeapi.Secret{
@@ -284,7 +325,8 @@ func (s *Store) updateSecret(data map[string][]byte, secretName string) (err err
 					Kind:       "Secret",
 				},
 				ObjectMeta: kubeapi.ObjectMeta{
-					Name: secretName,
+					Name:   secretName,
+					Labels: s.secretLabels(secretName),
 				},
 				Data: func(m map[string][]byte) map[string][]byte {
 					d := make(map[string][]byte, len(m))
@@ -323,6 +365,17 @@ func (s *Store) updateSecret(data map[string][]byte, secretName string) (err err
 					Value: val,
 				})
 			}
+			for _, key := range remove {
+				if _, ok := secret.Data[sanitizeKey(key)]; ok {
+					m = append(m, kubeclient.JSONPatch{
+						Op:   "remove",
+						Path: "/data/" + sanitizeKey(key),
+					})
+				}
+			}
+		}
+		if len(m) == 0 {
+			return nil
 		}
 		if err := s.client.JSONPatchResource(ctx, secretName, kubeclient.TypeSecrets, m); err != nil {
 			return fmt.Errorf("error patching Secret %s: %w", secretName, err)
@@ -333,6 +386,9 @@ func (s *Store) updateSecret(data map[string][]byte, secretName string) (err err
 	for key, val := range data {
 		mak.Set(&secret.Data, sanitizeKey(key), val)
 	}
+	for _, key := range remove {
+		delete(secret.Data, sanitizeKey(key))
+	}
 	if err := s.client.UpdateSecret(ctx, secret); err != nil {
 		return fmt.Errorf("error updating Secret %s: %w", s.secretName, err)
 	}
@@ -356,11 +412,27 @@ func (s *Store) loadState() (err error) {
 	if err := s.client.Event(ctx, eventTypeNormal, reasonTailscaleStateLoaded, "Successfully loaded tailscaled state from Secret"); err != nil {
 		s.logf("kubestore: error creating Event: %v", err)
 	}
-	data, err := s.maybeStripAttestationKeyFromProfile(secret.Data)
+	data, rewrite, err := s.readState(ctx, secret.Data)
+	if err != nil {
+		return err
+	}
+	data, err = s.maybeStripAttestationKeyFromProfile(data)
 	if err != nil {
 		return fmt.Errorf("error attempting to strip attestation data from state Secret: %w", err)
 	}
 	s.memory.LoadFromMap(data)
+	if len(rewrite) > 0 {
+		m := make(map[string][]byte, len(rewrite))
+		for _, k := range rewrite {
+			m[k] = data[k]
+		}
+		// Don't error out, we'll try again on the next restart.
+		if err := s.writeState(m); err != nil {
+			s.logf("kubestore: error migrating state: %v", err)
+		} else {
+			s.logf("kubestore: migrated %d state values to the current encryption and sharding settings", len(rewrite))
+		}
+	}
 	return nil
 }
 
@@ -398,7 +470,7 @@ func (s *Store) maybeStripAttestationKeyFromProfile(data map[string][]byte) (map
 		return nil, fmt.Errorf("[unexpected] kube store: failed to marshal profile after removing attestation key: %v", err)
 	}
 	data[prefsKey] = prefsBytes
-	if err := s.updateSecret(map[string][]byte{prefsKey: prefsBytes}, s.secretName); err != nil {
+	if err := s.writeState(map[string][]byte{prefsKey: prefsBytes}); err != nil {
 		// don't error out - this might have been a temporary kube API server
 		// connection issue. The key will be removed from the in-memory cache
 		// and we'll retry updating the Secret on the next restart.
@@ -463,8 +535,9 @@ func (s *Store) loadCerts(ctx context.Context, sel map[string]string) error {
 // canCreateSecret returns true if this node should be allowed to create the given
 // Secret in its namespace.
 func (s *Store) canCreateSecret(secret string) bool {
-	// Only allow creating the state Secret (and not TLS Secrets).
-	return secret == s.secretName
+	// Only allow creating the state Secret and its shards (and not TLS
+	// Secrets).
+	return secret == s.secretName || s.isShardSecret(secret)
 }
 
 // canPatchSecret returns true if this node should be allowed to patch the given
@@ -6,8 +6,14 @@
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -812,3 +818,191 @@ func TestNewWithClient(t *testing.T) {
 		})
 	}
 }
+
+func TestShardingAndEncryption(t *testing.T) {
+	const (
+		secretName = "ts-state"
+		domain1    = "app1.tailnetxyz.ts.net"
+		domain2    = "app2.tailnetxyz.ts.net"
+	)
+	envknob.Setenv("TS_CERT_SHARE_MODE", "")
+
+	// secrets holds the Secrets in the namespace, by name.
+	secrets := map[string]*kubeapi.Secret{
+		secretName: {Data: map[string][]byte{
+			"_machinekey":      []byte("privkey:abc"),
+			"_current-profile": []byte("profile-a"),
+			"profile-a":        []byte(`{"Config":{"NodeID":"n123"}}`),
+			domain1 + ".crt":   []byte("cert1"),
+			domain1 + ".key":   []byte("key1"),
+		}},
+	}
+	client := &kubeclient.FakeClient{
+		GetSecretImpl: func(ctx context.Context, name string) (*kubeapi.Secret, error) {
+			s, ok := secrets[name]
+			if !ok {
+				return nil, &kubeapi.Status{Code: 404}
+			}
+			return &kubeapi.Secret{ObjectMeta: s.ObjectMeta, Data: maps.Clone(s.Data)}, nil
+		},
+		CheckSecretPermissionsImpl: func(ctx context.Context, name string) (bool, bool, error) {
+			return true, true, nil
+		},
+		CreateSecretImpl: func(ctx context.Context, s *kubeapi.Secret) error {
+			secrets[s.Name] = s
+			return nil
+		},
+		JSONPatchResourceImpl: func(ctx context.Context, name, resourceType string, patches []kubeclient.JSONPatch) error {
+			s := secrets[name]
+			for _, p := range patches {
+				key, ok := strings.CutPrefix(p.Path, "/data/")
+				switch {
+				case p.Op == "add" && p.Path == "/data":
+					s.Data = p.Value.(map[string][]byte)
+				case p.Op == "add" && ok:
+					s.Data[key] = p.Value.([]byte)
+				case p.Op == "remove" && ok:
+					if _, found := s.Data[key]; !found {
+						t.Fatalf("removing missing key %q from Secret %s", key, name)
+					}
+					delete(s.Data, key)
+				default:
+					t.Fatalf("unexpected patch %+v", p)
+				}
+			}
+			return nil
+		},
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	setKeys := func(keys ...string) {
+		if err := os.WriteFile(keyFile, []byte(strings.Join(keys, "\n")+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantState := map[ipn.StateKey][]byte{
+		"_machinekey":      []byte("privkey:abc"),
+		"_current-profile": []byte("profile-a"),
+		"profile-a":        []byte(`{"Config":{"NodeID":"n123"}}`),
+		domain1 + ".crt":   []byte("cert1"),
+		domain1 + ".key":   []byte("key1"),
+	}
+	mustLoad := func() *Store {
+		t.Helper()
+		s, err := newWithClient(t.Logf, client, secretName)
+		if err != nil {
+			t.Fatalf("newWithClient: %v", err)
+		}
+		gotJSON, err := s.memory.ExportToJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[ipn.StateKey][]byte
+		if err := json.Unmarshal(gotJSON, &got); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, wantState); diff != "" {
+			t.Errorf("memory store contents mismatch (-got +want):\n%s", diff)
+		}
+		return s
+	}
+	// expectSecrets checks which keys each Secret has, and that all values
+	// other than the manifest are encrypted with the given key, or are
+	// plaintext if key is empty.
+	expectSecrets := func(key string, want map[string][]string) {
+		t.Helper()
+		got := make(map[string][]string)
+		for name, s := range secrets {
+			got[name] = slices.Sorted(maps.Keys(s.Data))
+			for k, v := range s.Data {
+				if k == manifestKey {
+					continue
+				}
+				if key == "" {
+					if isEncrypted(v) {
+						t.Errorf("%s/%s is encrypted, want plaintext", name, k)
+					}
+					continue
+				}
+				kr, err := loadKeyring(keyFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				kb, _ := base64.StdEncoding.DecodeString(key)
+				kid := sha256.Sum256(kb)
+				if !isEncrypted(v) || !bytes.Equal(v[len(encryptedValuePrefix):][:keyIDLen], kid[:keyIDLen]) {
+					t.Errorf("%s/%s is not encrypted with the expected key", name, k)
+				} else if _, _, err := kr.decrypt(k, v); err != nil {
+					t.Errorf("%s/%s: %v", name, k, err)
+				}
+			}
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Secret keys mismatch (-got +want):\n%s", diff)
+		}
+	}
+	shard1, _ := (&Store{secretName: secretName}).shardSecretName(domain1 + ".crt")
+	shard2, _ := (&Store{secretName: secretName}).shardSecretName(domain2 + ".crt")
+	if shard1 == shard2 {
+		t.Fatalf("test domains are in the same shard %s; pick other domains", shard1)
+	}
+
+	// Enabling sharding and encryption migrates existing single Secret state.
+	t.Setenv(envSharding, "true")
+	t.Setenv(envEncryptionKeyFile, keyFile)
+	setKeys(oldKey)
+	s := mustLoad()
+	expectSecrets(oldKey, map[string][]string{
+		secretName: {"_current-profile", "_machinekey", manifestKey, "profile-a"},
+		shard1:     {domain1 + ".crt", domain1 + ".key"},
+	})
+	if got := secrets[shard1].Labels[kubetypes.LabelSecretType]; got != kubetypes.LabelSecretTypeStateShard {
+		t.Errorf("shard Secret type = %q, want %q", got, kubetypes.LabelSecretTypeStateShard)
+	}
+
+	// New certs are written to their own shard.
+	if err := s.WriteTLSCertAndKey(domain2, []byte("cert2"), []byte("key2")); err != nil {
+		t.Fatal(err)
+	}
+	wantState[domain2+".crt"] = []byte("cert2")
+	wantState[domain2+".key"] = []byte("key2")
+	expectSecrets(oldKey, map[string][]string{
+		secretName: {"_current-profile", "_machinekey", manifestKey, "profile-a"},
+		shard1:     {domain1 + ".crt", domain1 + ".key"},
+		shard2:     {domain2 + ".crt", domain2 + ".key"},
+	})
+	mustLoad()
+
+	// Encrypted state can't be loaded without the keys.
+	t.Setenv(envEncryptionKeyFile, "")
+	if _, err := newWithClient(t.Logf, client, secretName); err == nil || !strings.Contains(err.Error(), "is encrypted") {
+		t.Errorf("newWithClient without keys: got error %v, want encrypted state error", err)
+	}
+
+	// Rotating the key re-encrypts the state with the new key.
+	t.Setenv(envEncryptionKeyFile, keyFile)
+	setKeys(newKey, oldKey)
+	mustLoad()
+	setKeys(newKey)
+	expectSecrets(newKey, map[string][]string{
+		secretName: {"_current-profile", "_machinekey", manifestKey, "profile-a"},
+		shard1:     {domain1 + ".crt", domain1 + ".key"},
+		shard2:     {domain2 + ".crt", domain2 + ".key"},
+	})
+
+	// Disabling sharding moves the certs back into the state Secret and
+	// removes them from the shards, which are left behind empty.
+	t.Setenv(envSharding, "")
+	mustLoad()
+	expectSecrets(newKey, map[string][]string{
+		secretName: {"_current-profile", "_machinekey", manifestKey, domain1 + ".crt", domain1 + ".key", domain2 + ".crt", domain2 + ".key", "profile-a"},
+		shard1:     nil,
+		shard2:     nil,
+	})
+	if got := string(secrets[secretName].Data[manifestKey]); got != "{}" {
+		t.Errorf("manifest = %s, want {}", got)
+	}
+	mustLoad()
+}
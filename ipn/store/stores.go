@@ -40,6 +40,11 @@ func init() {
 // TPMPrefix is the path prefix used for TPM-encrypted StateStore.
 const TPMPrefix = "tpmseal:"
 
+// EncryptedFilePrefix is the path prefix used for the StateStore that
+// encrypts the state file with a key from a file, the environment or an
+// external command.
+const EncryptedFilePrefix = "enc:"
+
 // New returns a StateStore based on the provided arg
 // and registered stores.
 // The arg is of the form "prefix:rest", where prefix was previously
@@ -55,18 +60,21 @@ func init() {
 //     the suffix is a Kubernetes secret name
 //   - (Linux or Windows) if the string begins with "tpmseal:", the suffix is
 //     filepath that is sealed with the local TPM device.
+//   - if the string begins with "enc:", the suffix is a filepath that is
+//     encrypted with a key from a file, the environment or an external
+//     command.
 //   - In all other cases, the path is treated as a filepath.
 func New(logf logger.Logf, path string) (ipn.StateStore, error) {
 	for prefix, sf := range knownStores {
 		if strings.HasPrefix(path, prefix) {
 			// We can't strip the prefix here as some NewStoreFunc (like arn:)
 			// expect the prefix.
-			if prefix == TPMPrefix {
+			if prefix == TPMPrefix || prefix == EncryptedFilePrefix {
 				if runtime.GOOS == "windows" {
-					path = TPMPrefix + TryWindowsAppDataMigration(logf, strings.TrimPrefix(path, TPMPrefix))
+					path = prefix + TryWindowsAppDataMigration(logf, strings.TrimPrefix(path, prefix))
 				}
 				if err := maybeMigrateLocalStateFile(logf, path); err != nil {
-					return nil, fmt.Errorf("failed to migrate existing state file to TPM-sealed format: %w", err)
+					return nil, fmt.Errorf("failed to migrate existing state file to %s format: %w", stateFileFormat(prefix), err)
 				}
 			}
 			return sf(logf, path)
@@ -76,7 +84,7 @@ func New(logf logger.Logf, path string) (ipn.StateStore, error) {
 		path = TryWindowsAppDataMigration(logf, path)
 	}
 	if err := maybeMigrateLocalStateFile(logf, path); err != nil {
-		return nil, fmt.Errorf("failed to migrate existing TPM-sealed state file to plaintext format: %w", err)
+		return nil, fmt.Errorf("failed to migrate existing state file to plaintext format: %w", err)
 	}
 	return NewFileStore(logf, path)
 }
@@ -252,8 +260,30 @@ type ExportableStore interface {
 	All() iter.Seq2[ipn.StateKey, []byte]
 }
 
+// stateFileFormat returns the name of the format of state files of the
+// store with the given prefix, which is empty for FileStore.
+func stateFileFormat(prefix string) string {
+	switch prefix {
+	case TPMPrefix:
+		return "TPM-sealed"
+	case EncryptedFilePrefix:
+		return "encrypted"
+	}
+	return "plaintext"
+}
+
+// maybeMigrateLocalStateFile migrates the state file at path, which may have
+// a TPMPrefix or EncryptedFilePrefix prefix, to the format of the store that
+// path refers to if the file on disk is in a different format.
 func maybeMigrateLocalStateFile(logf logger.Logf, path string) error {
-	path, toTPM := strings.CutPrefix(path, TPMPrefix)
+	// want is the prefix of the store that the file should be in the format
+	// of, or empty for FileStore.
+	var want string
+	for _, prefix := range []string{TPMPrefix, EncryptedFilePrefix} {
+		if p, ok := strings.CutPrefix(path, prefix); ok {
+			path, want = p, prefix
+		}
+	}
 
 	// Extract JSON keys from the file on disk and guess what kind it is.
 	bs, err := os.ReadFile(path)
@@ -268,49 +298,49 @@ func maybeMigrateLocalStateFile(logf logger.Logf, path string) error {
 		return fmt.Errorf("failed to unmarshal %q: %w", path, err)
 	}
 	keys := slices.Sorted(maps.Keys(content))
-	tpmKeys := []string{"key", "nonce", "data"}
-	slices.Sort(tpmKeys)
-	// TPM-sealed files will have exactly these keys.
-	existingFileSealed := slices.Equal(keys, tpmKeys)
-	// Plaintext files for nodes that registered at least once will have this
-	// key, plus other dynamic ones.
-	_, existingFilePlaintext := content["_machinekey"]
-	isTPM := existingFileSealed && !existingFilePlaintext
-
-	if isTPM == toTPM {
+	// have is the prefix of the store that the file is in the format of.
+	var have string
+	switch {
+	case slices.Contains(keys, "_machinekey"):
+		// Plaintext files for nodes that registered at least once will
+		// have this key, plus other dynamic ones.
+	case slices.Equal(keys, []string{"data", "key", "nonce"}):
+		// TPM-sealed files will have exactly these keys.
+		have = TPMPrefix
+	case slices.Equal(keys, []string{"ciphertext", "keyID", "nonce"}):
+		// Encrypted files will have exactly these keys.
+		have = EncryptedFilePrefix
+	}
+
+	if have == want {
 		// No migration needed.
 		return nil
 	}
 
-	newTPMStore, ok := knownStores[TPMPrefix]
-	if !ok {
-		return errors.New("this build does not support TPM integration")
+	openStore := func(prefix, path string) (ipn.StateStore, error) {
+		if prefix == "" {
+			return NewFileStore(logf, path)
+		}
+		newStore, ok := knownStores[prefix]
+		if !ok {
+			if prefix == TPMPrefix {
+				return nil, errors.New("this build does not support TPM integration")
+			}
+			return nil, fmt.Errorf("this build does not support %s state files", stateFileFormat(prefix))
+		}
+		return newStore(logf, prefix+path)
 	}
 
 	// Open from (old format) and to (new format) stores for migration. The
 	// "to" store will be at tmpPath.
-	var from, to ipn.StateStore
+	from, err := openStore(have, path)
+	if err != nil {
+		return fmt.Errorf("opening %s state file %q: %w", stateFileFormat(have), path, err)
+	}
 	tmpPath := path + ".tmp"
-	if toTPM {
-		// Migrate plaintext file to be TPM-sealed.
-		from, err = NewFileStore(logf, path)
-		if err != nil {
-			return fmt.Errorf("NewFileStore(%q): %w", path, err)
-		}
-		to, err = newTPMStore(logf, TPMPrefix+tmpPath)
-		if err != nil {
-			return fmt.Errorf("newTPMStore(%q): %w", tmpPath, err)
-		}
-	} else {
-		// Migrate TPM-selaed file to plaintext.
-		from, err = newTPMStore(logf, TPMPrefix+path)
-		if err != nil {
-			return fmt.Errorf("newTPMStore(%q): %w", path, err)
-		}
-		to, err = NewFileStore(logf, tmpPath)
-		if err != nil {
-			return fmt.Errorf("NewFileStore(%q): %w", tmpPath, err)
-		}
+	to, err := openStore(want, tmpPath)
+	if err != nil {
+		return fmt.Errorf("opening %s state file %q: %w", stateFileFormat(want), tmpPath, err)
 	}
 	defer os.Remove(tmpPath)
 
@@ -334,10 +364,6 @@ func maybeMigrateLocalStateFile(logf logger.Logf, path string) error {
 		return err
 	}
 
-	if toTPM {
-		logf("migrated %q from plaintext to TPM-sealed format", path)
-	} else {
-		logf("migrated %q from TPM-sealed to plaintext format", path)
-	}
+	logf("migrated %q from %s to %s format", path, stateFileFormat(have), stateFileFormat(want))
 	return nil
 }
@@ -0,0 +1,133 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// TaildropAction is what to do with an incoming Taildrop file that matches a
+// [TaildropRule].
+type TaildropAction string
+
+const (
+	// TaildropActionInbox puts the file in the Taildrop inbox, as if no rule
+	// had matched. It can be used to exempt files from later rules.
+	TaildropActionInbox TaildropAction = "inbox"
+
+	// TaildropActionMove moves the file into [TaildropRule.Dir] instead of
+	// the inbox.
+	TaildropActionMove TaildropAction = "move"
+
+	// TaildropActionReject rejects the file. The sender gets an error and
+	// nothing is kept on the receiver.
+	TaildropActionReject TaildropAction = "reject"
+
+	// TaildropActionExec puts the file in the inbox and then runs
+	// [TaildropRule.Command] with the path of the file appended.
+	TaildropActionExec TaildropAction = "exec"
+)
+
+// TaildropRule is a receiver-side rule for handling incoming Taildrop files.
+//
+// Rules are evaluated in order once a file has been completely received, but
+// before it's made visible. The first rule that matches the file decides what
+// happens to it. Files that match no rule go to the inbox as usual.
+//
+// Rules apply to individual files, not to directory trees.
+type TaildropRule struct {
+	// From, if non-empty, limits the rule to files sent by any of the given
+	// senders. Each is either a user's login name (e.g. "alice@example.com"),
+	// which matches that user's untagged nodes, a tag (e.g. "tag:server"), or
+	// a node's MagicDNS name (e.g. "laptop" or "laptop.tailnet.ts.net").
+	From []string `json:",omitempty"`
+
+	// Ext, if non-empty, limits the rule to files whose names end in any of
+	// the given extensions (e.g. ".jpg"), compared case-insensitively.
+	Ext []string `json:",omitempty"`
+
+	// MinSize, if positive, limits the rule to files of at least that many
+	// bytes.
+	MinSize int64 `json:",omitempty"`
+
+	// MaxSize, if positive, limits the rule to files of at most that many
+	// bytes.
+	MaxSize int64 `json:",omitempty"`
+
+	// Action is what to do with matching files.
+	Action TaildropAction
+
+	// Dir is the absolute path of the directory to move matching files into.
+	// It's only used with TaildropActionMove, which only a local administrator
+	// can configure because tailscaled moves the files as its own user.
+	Dir string `json:",omitempty"`
+
+	// Command is the program and arguments to run for matching files, with
+	// the path of the received file appended as the final argument. It's
+	// only used with TaildropActionExec, and runs as the same user as
+	// tailscaled.
+	Command []string `json:",omitempty"`
+}
+
+// Check reports whether r is a valid rule.
+func (r *TaildropRule) Check() error {
+	if r == nil {
+		return errors.New("nil rule")
+	}
+	for _, ext := range r.Ext {
+		if ext == "" || ext == "." || strings.ContainsAny(ext, `/\`) {
+			return fmt.Errorf("invalid extension %q", ext)
+		}
+	}
+	for _, from := range r.From {
+		if from == "" {
+			return errors.New("empty sender")
+		}
+	}
+	if r.MinSize < 0 || r.MaxSize < 0 || (r.MaxSize > 0 && r.MinSize > r.MaxSize) {
+		return fmt.Errorf("invalid size range [%d, %d]", r.MinSize, r.MaxSize)
+	}
+	switch r.Action {
+	case TaildropActionInbox, TaildropActionReject:
+	case TaildropActionMove:
+		if !filepath.IsAbs(r.Dir) {
+			return fmt.Errorf("move action needs an absolute Dir; got %q", r.Dir)
+		}
+	case TaildropActionExec:
+		if len(r.Command) == 0 || !filepath.IsAbs(r.Command[0]) {
+			return errors.New("exec action needs a Command starting with an absolute path")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	return nil
+}
+
+// Equal reports whether r and r2 are equal.
+func (r *TaildropRule) Equal(r2 *TaildropRule) bool {
+	if r == nil || r2 == nil {
+		return r == r2
+	}
+	return slices.Equal(r.From, r2.From) &&
+		slices.Equal(r.Ext, r2.Ext) &&
+		r.MinSize == r2.MinSize &&
+		r.MaxSize == r2.MaxSize &&
+		r.Action == r2.Action &&
+		r.Dir == r2.Dir &&
+		slices.Equal(r.Command, r2.Command)
+}
+
+// CheckTaildropRules reports whether all of rules are valid.
+func CheckTaildropRules(rules []*TaildropRule) error {
+	for i, r := range rules {
+		if err := r.Check(); err != nil {
+			return fmt.Errorf("invalid Taildrop rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
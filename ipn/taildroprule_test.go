@@ -0,0 +1,33 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import "testing"
+
+func TestTaildropRuleCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    TaildropRule
+		wantErr bool
+	}{
+		{"inbox", TaildropRule{Action: TaildropActionInbox}, false},
+		{"reject", TaildropRule{Ext: []string{".exe"}, Action: TaildropActionReject}, false},
+		{"move", TaildropRule{Action: TaildropActionMove, Dir: "/srv/photos"}, false},
+		{"move-relative", TaildropRule{Action: TaildropActionMove, Dir: "photos"}, true},
+		{"exec", TaildropRule{Action: TaildropActionExec, Command: []string{"/usr/bin/true"}}, false},
+		{"exec-no-command", TaildropRule{Action: TaildropActionExec}, true},
+		{"exec-relative", TaildropRule{Action: TaildropActionExec, Command: []string{"true"}}, true},
+		{"no-action", TaildropRule{}, true},
+		{"bad-ext", TaildropRule{Ext: []string{"a/b"}, Action: TaildropActionInbox}, true},
+		{"empty-from", TaildropRule{From: []string{""}, Action: TaildropActionInbox}, true},
+		{"bad-sizes", TaildropRule{MinSize: 10, MaxSize: 5, Action: TaildropActionInbox}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Check(); (err != nil) != tt.wantErr {
+				t.Errorf("Check = %v; wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -15,6 +15,8 @@
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/scheme"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
 )
 
 // SchemeGroupVersion is group version used to register these objects
@@ -52,6 +54,14 @@ func init() {
 	if err := apiextensionsv1.AddToScheme(GlobalScheme); err != nil {
 		panic(fmt.Sprintf("failed to add apiextensions.k8s.io scheme: %s", err))
 	}
+	// Add Gateway API types (GatewayClasses, Gateways, HTTPRoutes,
+	// TCPRoutes and TLSRoutes)
+	if err := gatewayv1.Install(GlobalScheme); err != nil {
+		panic(fmt.Sprintf("failed to add gateway.networking.k8s.io/v1 scheme: %s", err))
+	}
+	if err := gatewayv1alpha2.Install(GlobalScheme); err != nil {
+		panic(fmt.Sprintf("failed to add gateway.networking.k8s.io/v1alpha2 scheme: %s", err))
+	}
 }
 
 // Adds the list of known types to api.Scheme.
@@ -71,6 +81,12 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&TailnetList{},
 		&ProxyGroupPolicy{},
 		&ProxyGroupPolicyList{},
+		&TailnetAccessPolicy{},
+		&TailnetAccessPolicyList{},
+		&ServiceExport{},
+		&ServiceExportList{},
+		&ServiceImport{},
+		&ServiceImportList{},
 	)
 
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
@@ -103,6 +103,19 @@ type ProxyGroupSpec struct {
 	// +optional
 	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="ProxyGroup tailnet is immutable"
 	Tailnet string `json:"tailnet,omitempty"`
+
+	// StateSharding, if true, makes the ProxyGroup's proxies store TLS
+	// certificates and keys in a fixed set of shard Secrets next to each
+	// replica's state Secret, instead of in the state Secret itself. This
+	// keeps state Secrets under the Kubernetes Secret size limit when
+	// proxies serve many HTTPS endpoints. The operator creates the shard
+	// Secrets, which are deleted along with the ProxyGroup. If sharding is
+	// disabled again, the proxies move the certificates back into their
+	// state Secrets the next time they start.
+	// It has no effect for ProxyGroups of type ingress, whose proxies already
+	// store each certificate in a Secret of its own.
+	// +optional
+	StateSharding bool `json:"stateSharding,omitempty"`
 }
 
 type ProxyGroupStatus struct {
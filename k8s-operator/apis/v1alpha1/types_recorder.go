@@ -7,6 +7,7 @@
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -19,7 +20,8 @@
 
 // Recorder defines a tsrecorder device for recording SSH sessions. By default,
 // it will store recordings in a local ephemeral volume. If you want to persist
-// recordings, you can configure an S3-compatible API for storage.
+// recordings, you can configure an S3-compatible API or a PersistentVolumeClaim
+// for storage.
 //
 // More info: https://tailscale.com/kb/1484/kubernetes-operator-deploying-tsrecorder
 type Recorder struct {
@@ -46,6 +48,7 @@ type RecorderList struct {
 
 // RecorderSpec describes a tsrecorder instance to be deployed in the cluster
 // +kubebuilder:validation:XValidation:rule="!(self.replicas > 1 && (!has(self.storage) || !has(self.storage.s3)))",message="S3 storage must be used when deploying multiple Recorder replicas"
+// +kubebuilder:validation:XValidation:rule="(has(self.storage) && has(oldSelf.storage)) || (has(self.storage) && has(self.storage.pvc)) == (has(oldSelf.storage) && has(oldSelf.storage.pvc))",message="PVC storage cannot be added to or removed from an existing Recorder"
 type RecorderSpec struct {
 	// Configuration parameters for the Recorder's StatefulSet. The operator
 	// deploys a StatefulSet for each Recorder resource.
@@ -226,11 +229,54 @@ type RecorderContainer struct {
 	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
 }
 
+// +kubebuilder:validation:XValidation:rule="!(has(self.s3) && has(self.pvc))",message="only one of s3 or pvc storage can be configured"
+// +kubebuilder:validation:XValidation:rule="has(self.pvc) == has(oldSelf.pvc)",message="PVC storage cannot be added to or removed from an existing Recorder"
 type Storage struct {
 	// Configure an S3-compatible API for storage. Required if the UI is not
 	// enabled, to ensure that recordings are accessible.
 	// +optional
 	S3 *S3 `json:"s3,omitempty"`
+
+	// Configure a PersistentVolumeClaim for storage. The operator creates a
+	// PersistentVolumeClaim for the Recorder from this config, and deletes
+	// recordings from it according to the retention policy. Recent
+	// recordings are listed in the Recorder's status. Can only be used with
+	// a single replica, and cannot be added to or removed from an existing
+	// Recorder.
+	// +optional
+	PVC *RecorderPVCStorage `json:"pvc,omitempty"`
+}
+
+type RecorderPVCStorage struct {
+	// Size of the PersistentVolumeClaim to request, e.g. 10Gi.
+	// https://kubernetes.io/docs/concepts/storage/persistent-volumes/#resources
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="PVC size is immutable"
+	Size resource.Quantity `json:"size"`
+
+	// Name of the StorageClass to request the PersistentVolumeClaim from. If
+	// not set, the cluster's default StorageClass is used.
+	// https://kubernetes.io/docs/concepts/storage/persistent-volumes/#class-1
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="PVC storageClassName is immutable"
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+
+	// Retention policy for recordings stored in the PersistentVolumeClaim.
+	// By default, recordings are kept until the volume is full.
+	// +optional
+	Retention RecordingRetention `json:"retention,omitempty"`
+}
+
+type RecordingRetention struct {
+	// Recordings older than MaxAge are deleted, e.g. 720h for 30 days.
+	// +optional
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+
+	// If the recordings take up more than MaxSize in total, the oldest
+	// recordings are deleted until they take up less, e.g. 8Gi. To leave
+	// room for recordings in progress, this should be smaller than the
+	// PersistentVolumeClaim's size.
+	// +optional
+	MaxSize *resource.Quantity `json:"maxSize,omitempty"`
 }
 
 type S3 struct {
@@ -278,6 +324,39 @@ type RecorderStatus struct {
 	// +listMapKey=hostname
 	// +optional
 	Devices []RecorderTailnetDevice `json:"devices,omitempty"`
+
+	// The most recent session recordings stored by the Recorder, newest
+	// first. Only set if the Recorder uses PVC storage.
+	// +listType=atomic
+	// +optional
+	RecentRecordings []RecorderRecording `json:"recentRecordings,omitempty"`
+}
+
+type RecorderRecording struct {
+	// Name of the recording, which is its path in the Recorder's storage.
+	Name string `json:"name"`
+
+	// Time the recorded session started.
+	StartTime metav1.Time `json:"startTime"`
+
+	// Size of the recording in bytes.
+	// +optional
+	Size int64 `json:"size,omitempty"`
+
+	// MagicDNS name of the tailnet device that the session was initiated
+	// from.
+	// +optional
+	SrcNode string `json:"srcNode,omitempty"`
+
+	// Login name of the user that initiated the session. Empty if the
+	// session was initiated from a tagged device.
+	// +optional
+	SrcNodeUser string `json:"srcNodeUser,omitempty"`
+
+	// For Tailscale SSH sessions, the local user on the SSH server. For
+	// kubectl exec and attach sessions, the namespace/name of the Pod.
+	// +optional
+	Target string `json:"target,omitempty"`
 }
 
 type RecorderTailnetDevice struct {
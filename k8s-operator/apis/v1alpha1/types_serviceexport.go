@@ -0,0 +1,105 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Code comments on these types should be treated as user facing documentation-
+// they will appear on the ServiceExport CRD i.e. if someone runs kubectl explain serviceexport.
+
+var ServiceExportKind = "ServiceExport"
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=svcex
+// +kubebuilder:printcolumn:name="TailscaleService",type="string",JSONPath=`.status.tailscaleService`,description="Name of the Tailscale Service that the Service is exported as."
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=`.status.conditions[?(@.type == "ServiceExportReady")].reason`,description="Status of the ServiceExport."
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ServiceExport exports the Service with the same name in its namespace to
+// other clusters connected to the same tailnet, following the Kubernetes
+// Multi-Cluster Services API. The operator exposes the Service on an ingress
+// ProxyGroup as a Tailscale Service named svc:<namespace>-<name>-<hash>, where
+// <hash> is derived from the namespace and name, and <namespace>-<name> is
+// truncated if the name would otherwise be too long. Operators in other
+// clusters can then import it with a ServiceImport of the same name in the
+// same namespace. The Service is not exported if the Tailscale Service
+// already exists for a different purpose.
+//
+// Exporting the same Service from multiple clusters makes the proxies of all
+// of them backends of the same Tailscale Service. Each exporting operator
+// publishes the health of its endpoints on the Tailscale Service, which
+// importing operators report in the ServiceImport's status.
+//
+// The Service must have a selector and a cluster IP. The operator creates a
+// ClusterIP Service with the same selector and ports for the ProxyGroup to
+// forward traffic to.
+type ServiceExport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitzero"`
+
+	// Spec describes how the Service is exported.
+	// More info:
+	// https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	Spec ServiceExportSpec `json:"spec"`
+
+	// Status describes the status of the ServiceExport. This is set
+	// and managed by the Tailscale operator.
+	// +optional
+	Status ServiceExportStatus `json:"status"`
+}
+
+// +kubebuilder:object:root=true
+
+type ServiceExportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ServiceExport `json:"items"`
+}
+
+type ServiceExportSpec struct {
+	// ProxyGroup is the name of the ingress ProxyGroup that exposes the
+	// Service on the tailnet.
+	// +kubebuilder:validation:MinLength=1
+	ProxyGroup string `json:"proxyGroup"`
+
+	// Tags that the Tailscale Service will be tagged with. Defaults to the
+	// operator's default proxy tags. Tags must be consistent across all
+	// clusters that export the Service.
+	// +optional
+	Tags Tags `json:"tags,omitempty"`
+}
+
+type ServiceExportStatus struct {
+	// List of status conditions to indicate the status of the
+	// ServiceExport. Known condition types are `ServiceExportValid` and
+	// `ServiceExportReady`.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions"`
+
+	// TailscaleService is the name of the Tailscale Service that the
+	// Service is exported as.
+	// +optional
+	TailscaleService string `json:"tailscaleService,omitempty"`
+
+	// ReadyEndpoints is the number of ready endpoints of the Service in
+	// this cluster.
+	// +optional
+	ReadyEndpoints int32 `json:"readyEndpoints"`
+}
+
+// ServiceExportValid is set to True if the exported Service and the
+// ServiceExport's ProxyGroup are valid for export.
+const ServiceExportValid ConditionType = `ServiceExportValid`
+
+// ServiceExportReady is set to True if at least one Pod of the ProxyGroup is
+// advertising the Tailscale Service on the tailnet.
+const ServiceExportReady ConditionType = `ServiceExportReady`
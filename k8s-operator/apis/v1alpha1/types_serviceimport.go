@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Code comments on these types should be treated as user facing documentation-
+// they will appear on the ServiceImport CRD i.e. if someone runs kubectl explain serviceimport.
+
+var ServiceImportKind = "ServiceImport"
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=svcim
+// +kubebuilder:printcolumn:name="TailscaleService",type="string",JSONPath=`.status.tailscaleService`,description="Name of the imported Tailscale Service."
+// +kubebuilder:printcolumn:name="Exporters",type="string",JSONPath=`.status.exporters[*].operator`,description="Operators that export the Service."
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=`.status.conditions[?(@.type == "ServiceImportReady")].reason`,description="Status of the ServiceImport."
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ServiceImport imports a Service that is exported from other clusters with
+// a ServiceExport, following the Kubernetes Multi-Cluster Services API. The
+// operator creates a Service with the same name in the ServiceImport's
+// namespace that routes cluster traffic to the exported Tailscale Service via
+// the Pods of an egress ProxyGroup.
+//
+// The Service is of type ExternalName and resolves to a ClusterIP Service in
+// the operator's namespace, whose endpoints are the ProxyGroup's Pods. The
+// cluster IPs of that Service are listed in the ServiceImport's status.
+//
+// ServiceImports are created automatically for all Services that other
+// clusters export if an egress ProxyGroup is annotated with
+// tailscale.com/import-services: "true". They are created in the namespace of
+// the exported Service, if it exists, and deleted once no cluster exports the
+// Service anymore.
+type ServiceImport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitzero"`
+
+	// Spec describes how the Service is imported.
+	// More info:
+	// https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	Spec ServiceImportSpec `json:"spec"`
+
+	// Status describes the status of the ServiceImport. This is set
+	// and managed by the Tailscale operator.
+	// +optional
+	Status ServiceImportStatus `json:"status"`
+}
+
+// +kubebuilder:object:root=true
+
+type ServiceImportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ServiceImport `json:"items"`
+}
+
+type ServiceImportSpec struct {
+	// ProxyGroup is the name of the egress ProxyGroup that proxies cluster
+	// traffic to the Tailscale Service.
+	// +kubebuilder:validation:MinLength=1
+	ProxyGroup string `json:"proxyGroup"`
+
+	// Ports of the exported Service to make available in this cluster.
+	// +kubebuilder:validation:MinItems=1
+	// +listType=map
+	// +listMapKey=port
+	// +listMapKey=protocol
+	Ports []ServiceImportPort `json:"ports"`
+
+	// TailscaleService is the name of the Tailscale Service to import, e.g.
+	// svc:web. Defaults to the name that a ServiceExport of the same name in
+	// the same namespace exports a Service as.
+	// +kubebuilder:validation:Pattern=`^svc:[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`
+	// +optional
+	TailscaleService string `json:"tailscaleService,omitempty"`
+}
+
+type ServiceImportPort struct {
+	// Name of the port in the imported Service.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Protocol of the port. Defaults to TCP.
+	// +kubebuilder:validation:Enum=TCP;UDP
+	// +kubebuilder:default=TCP
+	// +optional
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+
+	// Port of the exported Service.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+}
+
+type ServiceImportStatus struct {
+	// List of status conditions to indicate the status of the
+	// ServiceImport. Known condition types are `ServiceImportReady`.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions"`
+
+	// TailscaleService is the name of the imported Tailscale Service.
+	// +optional
+	TailscaleService string `json:"tailscaleService,omitempty"`
+
+	// IPs are the cluster IPs of the Service in the operator's namespace
+	// that cluster traffic for the imported Service is routed through.
+	// +optional
+	IPs []string `json:"ips,omitempty"`
+
+	// Exporters are the clusters that export the Service, as published on
+	// the Tailscale Service by their operators. Operators republish the
+	// health of their exports every 5 minutes, and clusters whose operators
+	// haven't done so for 15 minutes are omitted.
+	// +listType=map
+	// +listMapKey=operator
+	// +optional
+	Exporters []ServiceImportExporter `json:"exporters,omitempty"`
+}
+
+type ServiceImportExporter struct {
+	// Operator is the MagicDNS name of the Tailscale operator that exports
+	// the Service from its cluster.
+	Operator string `json:"operator"`
+
+	// ReadyEndpoints is the number of ready endpoints of the Service in the
+	// exporting cluster.
+	ReadyEndpoints int32 `json:"readyEndpoints"`
+
+	// ReadyProxies is the number of Pods of the exporting cluster's
+	// ProxyGroup that advertise the Tailscale Service.
+	ReadyProxies int32 `json:"readyProxies"`
+}
+
+// ServiceImportReady is set to True if the egress ProxyGroup is ready to
+// route cluster traffic to the Tailscale Service.
+const ServiceImportReady ConditionType = `ServiceImportReady`
@@ -0,0 +1,118 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Code comments on these types should be treated as user facing documentation-
+// they will appear on the TailnetAccessPolicy CRD i.e. if someone runs kubectl explain tailnetaccesspolicy.
+
+var TailnetAccessPolicyKind = "TailnetAccessPolicy"
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=tap
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=`.status.conditions[?(@.type == "TailnetAccessPolicyReady")].reason`,description="Status of the TailnetAccessPolicy."
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TailnetAccessPolicy restricts which tailnet devices can reach Services in
+// its namespace through the Tailscale operator's ingress proxies, that is,
+// Services that are backends of Tailscale Ingresses and of Tailscale Gateway
+// API routes.
+//
+// Access to a Service port that is targeted by any TailnetAccessPolicy rule in
+// the namespace is only allowed from the tags and users of the rules that
+// target it. The ingress proxies enforce this locally, in addition to the
+// tailnet policy file, and record a Warning Event on the proxy Pod when they
+// deny a connection or request. Funnel traffic to such a Service port is always
+// denied. Access to Service ports that no rule targets is governed by the
+// tailnet policy file only, as is access to Services exposed by other means,
+// such as LoadBalancer Services.
+//
+// A TailnetAccessPolicy is not Ready if it can't be enforced: if a Service
+// that it targets is also exposed on the tailnet as a Tailscale LoadBalancer
+// Service, with the tailscale.com/expose annotation or by a ServiceExport, or
+// if an ingress proxy that serves a targeted Service runs a Tailscale version
+// too old to enforce it.
+type TailnetAccessPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitzero"`
+
+	// Spec describes the desired access to Services in the namespace.
+	// More info:
+	// https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	Spec TailnetAccessPolicySpec `json:"spec"`
+
+	// Status describes the status of the TailnetAccessPolicy. This is set
+	// and managed by the Tailscale operator.
+	// +optional
+	Status TailnetAccessPolicyStatus `json:"status"`
+}
+
+// +kubebuilder:object:root=true
+
+type TailnetAccessPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []TailnetAccessPolicy `json:"items"`
+}
+
+type TailnetAccessPolicySpec struct {
+	// Rules that allow tailnet devices to reach Service ports in the
+	// namespace.
+	// +kubebuilder:validation:MinItems=1
+	Rules []TailnetAccessRule `json:"rules"`
+}
+
+type TailnetAccessRule struct {
+	// From are the tailnet devices that this rule allows, by tag or by user.
+	// +kubebuilder:validation:MinItems=1
+	From []TailnetAccessPeer `json:"from"`
+
+	// To are the Service ports that this rule allows access to.
+	// +kubebuilder:validation:MinItems=1
+	To []TailnetAccessTarget `json:"to"`
+}
+
+// +kubebuilder:validation:XValidation:rule="has(self.tag) != has(self.user)",message="exactly one of tag or user must be set"
+type TailnetAccessPeer struct {
+	// Tag matches devices with this tag, e.g. tag:prod.
+	// +optional
+	Tag Tag `json:"tag,omitempty"`
+
+	// User matches devices of the user with this login name, e.g.
+	// alice@example.com. Tagged devices never match a user.
+	// +optional
+	User string `json:"user,omitempty"`
+}
+
+type TailnetAccessTarget struct {
+	// Service is the name of a Service in the TailnetAccessPolicy's namespace.
+	// +kubebuilder:validation:MinLength=1
+	Service string `json:"service"`
+
+	// Ports of the Service that the rule applies to. If empty, the rule
+	// applies to all ports of the Service.
+	// +optional
+	Ports []int32 `json:"ports,omitempty"`
+}
+
+type TailnetAccessPolicyStatus struct {
+	// List of status conditions to indicate the status of the
+	// TailnetAccessPolicy. Known condition types are
+	// `TailnetAccessPolicyReady`.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions"`
+}
+
+// TailnetAccessPolicyReady is set to True if the TailnetAccessPolicy is valid
+// and applied to the ingress proxies of the Services it targets.
+const TailnetAccessPolicyReady ConditionType = "TailnetAccessPolicyReady"
@@ -1082,6 +1082,23 @@ func (in *RecorderList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RecorderPVCStorage) DeepCopyInto(out *RecorderPVCStorage) {
+	*out = *in
+	out.Size = in.Size.DeepCopy()
+	in.Retention.DeepCopyInto(&out.Retention)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RecorderPVCStorage.
+func (in *RecorderPVCStorage) DeepCopy() *RecorderPVCStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(RecorderPVCStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RecorderPod) DeepCopyInto(out *RecorderPod) {
 	*out = *in
@@ -1142,6 +1159,22 @@ func (in *RecorderPod) DeepCopy() *RecorderPod {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RecorderRecording) DeepCopyInto(out *RecorderRecording) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RecorderRecording.
+func (in *RecorderRecording) DeepCopy() *RecorderRecording {
+	if in == nil {
+		return nil
+	}
+	out := new(RecorderRecording)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RecorderServiceAccount) DeepCopyInto(out *RecorderServiceAccount) {
 	*out = *in
@@ -1238,6 +1271,13 @@ func (in *RecorderStatus) DeepCopyInto(out *RecorderStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.RecentRecordings != nil {
+		in, out := &in.RecentRecordings, &out.RecentRecordings
+		*out = make([]RecorderRecording, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RecorderStatus.
@@ -1270,6 +1310,31 @@ func (in *RecorderTailnetDevice) DeepCopy() *RecorderTailnetDevice {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RecordingRetention) DeepCopyInto(out *RecordingRetention) {
+	*out = *in
+	if in.MaxAge != nil {
+		in, out := &in.MaxAge, &out.MaxAge
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxSize != nil {
+		in, out := &in.MaxSize, &out.MaxSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RecordingRetention.
+func (in *RecordingRetention) DeepCopy() *RecordingRetention {
+	if in == nil {
+		return nil
+	}
+	out := new(RecordingRetention)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in Routes) DeepCopyInto(out *Routes) {
 	{
@@ -1336,6 +1401,248 @@ func (in *S3Secret) DeepCopy() *S3Secret {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceExport) DeepCopyInto(out *ServiceExport) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceExport.
+func (in *ServiceExport) DeepCopy() *ServiceExport {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceExport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ServiceExport) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceExportList) DeepCopyInto(out *ServiceExportList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ServiceExport, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceExportList.
+func (in *ServiceExportList) DeepCopy() *ServiceExportList {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceExportList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ServiceExportList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceExportSpec) DeepCopyInto(out *ServiceExportSpec) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(Tags, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceExportSpec.
+func (in *ServiceExportSpec) DeepCopy() *ServiceExportSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceExportSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceExportStatus) DeepCopyInto(out *ServiceExportStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceExportStatus.
+func (in *ServiceExportStatus) DeepCopy() *ServiceExportStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceExportStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceImport) DeepCopyInto(out *ServiceImport) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceImport.
+func (in *ServiceImport) DeepCopy() *ServiceImport {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceImport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ServiceImport) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceImportExporter) DeepCopyInto(out *ServiceImportExporter) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceImportExporter.
+func (in *ServiceImportExporter) DeepCopy() *ServiceImportExporter {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceImportExporter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceImportList) DeepCopyInto(out *ServiceImportList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ServiceImport, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceImportList.
+func (in *ServiceImportList) DeepCopy() *ServiceImportList {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceImportList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ServiceImportList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceImportPort) DeepCopyInto(out *ServiceImportPort) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceImportPort.
+func (in *ServiceImportPort) DeepCopy() *ServiceImportPort {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceImportPort)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceImportSpec) DeepCopyInto(out *ServiceImportSpec) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]ServiceImportPort, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceImportSpec.
+func (in *ServiceImportSpec) DeepCopy() *ServiceImportSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceImportSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceImportStatus) DeepCopyInto(out *ServiceImportStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IPs != nil {
+		in, out := &in.IPs, &out.IPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exporters != nil {
+		in, out := &in.Exporters, &out.Exporters
+		*out = make([]ServiceImportExporter, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceImportStatus.
+func (in *ServiceImportStatus) DeepCopy() *ServiceImportStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceImportStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceMonitor) DeepCopyInto(out *ServiceMonitor) {
 	*out = *in
@@ -1420,6 +1727,11 @@ func (in *Storage) DeepCopyInto(out *Storage) {
 		*out = new(S3)
 		**out = **in
 	}
+	if in.PVC != nil {
+		in, out := &in.PVC, &out.PVC
+		*out = new(RecorderPVCStorage)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Storage.
@@ -1498,6 +1810,171 @@ func (in *Tailnet) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TailnetAccessPeer) DeepCopyInto(out *TailnetAccessPeer) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TailnetAccessPeer.
+func (in *TailnetAccessPeer) DeepCopy() *TailnetAccessPeer {
+	if in == nil {
+		return nil
+	}
+	out := new(TailnetAccessPeer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TailnetAccessPolicy) DeepCopyInto(out *TailnetAccessPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TailnetAccessPolicy.
+func (in *TailnetAccessPolicy) DeepCopy() *TailnetAccessPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(TailnetAccessPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TailnetAccessPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TailnetAccessPolicyList) DeepCopyInto(out *TailnetAccessPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TailnetAccessPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TailnetAccessPolicyList.
+func (in *TailnetAccessPolicyList) DeepCopy() *TailnetAccessPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(TailnetAccessPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TailnetAccessPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TailnetAccessPolicySpec) DeepCopyInto(out *TailnetAccessPolicySpec) {
+	*out = *in
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]TailnetAccessRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TailnetAccessPolicySpec.
+func (in *TailnetAccessPolicySpec) DeepCopy() *TailnetAccessPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TailnetAccessPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TailnetAccessPolicyStatus) DeepCopyInto(out *TailnetAccessPolicyStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TailnetAccessPolicyStatus.
+func (in *TailnetAccessPolicyStatus) DeepCopy() *TailnetAccessPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TailnetAccessPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TailnetAccessRule) DeepCopyInto(out *TailnetAccessRule) {
+	*out = *in
+	if in.From != nil {
+		in, out := &in.From, &out.From
+		*out = make([]TailnetAccessPeer, len(*in))
+		copy(*out, *in)
+	}
+	if in.To != nil {
+		in, out := &in.To, &out.To
+		*out = make([]TailnetAccessTarget, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TailnetAccessRule.
+func (in *TailnetAccessRule) DeepCopy() *TailnetAccessRule {
+	if in == nil {
+		return nil
+	}
+	out := new(TailnetAccessRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TailnetAccessTarget) DeepCopyInto(out *TailnetAccessTarget) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TailnetAccessTarget.
+func (in *TailnetAccessTarget) DeepCopy() *TailnetAccessTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(TailnetAccessTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TailnetCredentials) DeepCopyInto(out *TailnetCredentials) {
 	*out = *in
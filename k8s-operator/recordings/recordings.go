@@ -0,0 +1,306 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+// Package recordings manages the session recordings that tsrecorder stores in
+// a local directory. It lists and searches recordings, deletes them according
+// to a retention policy, and serves a small HTTP API for querying them, which
+// the operator uses to surface recent recordings in the Recorder's status.
+package recordings
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/sessionrecording"
+)
+
+// castExt is the file extension of the asciinema recordings written by
+// tsrecorder.
+const castExt = ".cast"
+
+// maxHeaderSize is the maximum size of a recording's header line.
+const maxHeaderSize = 64 << 10
+
+// activeWindow is how recently a recording must have been written to for it
+// to be considered in progress. Recordings in progress are never deleted.
+const activeWindow = time.Minute
+
+// Recording is a session recording stored by tsrecorder.
+type Recording struct {
+	// Name is the path of the recording relative to the recordings
+	// directory, with forward slashes.
+	Name string `json:"name"`
+	// Size is the size of the recording in bytes.
+	Size int64 `json:"size"`
+	// StartTime is when the recorded session started.
+	StartTime time.Time `json:"startTime"`
+	// ModTime is when the recording was last written to.
+	ModTime time.Time `json:"modTime"`
+	// Header is the recording's asciinema header.
+	Header sessionrecording.CastHeader `json:"header"`
+}
+
+// Target returns what the recorded session was connected to: the local user
+// for Tailscale SSH sessions and the namespace/name of the Pod for kubectl
+// exec and attach sessions.
+func (r Recording) Target() string {
+	if k := r.Header.Kubernetes; k != nil {
+		return k.Namespace + "/" + k.PodName
+	}
+	return r.Header.LocalUser
+}
+
+// List returns the recordings in dir, newest first. Files that are not
+// recordings or whose header can't be read are skipped.
+func List(dir string) ([]Recording, error) {
+	var recs []Recording
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), castExt) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // deleted while listing
+			}
+			return err
+		}
+		hdr, err := readHeader(path)
+		if err != nil {
+			// Likely a recording that has only just been created.
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rec := Recording{
+			Name:    filepath.ToSlash(rel),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+			Header:  hdr,
+		}
+		if hdr.Timestamp != 0 {
+			rec.StartTime = time.Unix(hdr.Timestamp, 0)
+		} else {
+			rec.StartTime = fi.ModTime()
+		}
+		recs = append(recs, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(recs, func(a, b Recording) int {
+		return cmp.Or(b.StartTime.Compare(a.StartTime), strings.Compare(a.Name, b.Name))
+	})
+	return recs, nil
+}
+
+func readHeader(path string) (hdr sessionrecording.CastHeader, _ error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return hdr, err
+	}
+	defer f.Close()
+	br := bufio.NewReaderSize(f, maxHeaderSize)
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return hdr, fmt.Errorf("reading header of %s: %w", path, err)
+	}
+	if err := json.Unmarshal(line, &hdr); err != nil {
+		return hdr, fmt.Errorf("parsing header of %s: %w", path, err)
+	}
+	return hdr, nil
+}
+
+// Query selects recordings. The zero value matches all recordings.
+type Query struct {
+	// User, if non-empty, matches recordings of sessions initiated by the
+	// user with this login name.
+	User string
+	// Node, if non-empty, matches recordings of sessions initiated from
+	// the device with this MagicDNS name, or whose MagicDNS name has this
+	// as its first label.
+	Node string
+	// Target, if non-empty, matches recordings whose [Recording.Target]
+	// contains this.
+	Target string
+	// Since and Until, if non-zero, match recordings of sessions that
+	// started in [Since, Until).
+	Since, Until time.Time
+	// Limit, if positive, is the maximum number of recordings to return.
+	Limit int
+}
+
+// Match reports whether q matches r, ignoring q.Limit.
+func (q Query) Match(r Recording) bool {
+	if q.User != "" && !strings.EqualFold(r.Header.SrcNodeUser, q.User) {
+		return false
+	}
+	if q.Node != "" {
+		first, _, _ := strings.Cut(r.Header.SrcNode, ".")
+		if !strings.EqualFold(r.Header.SrcNode, q.Node) && !strings.EqualFold(first, q.Node) {
+			return false
+		}
+	}
+	if q.Target != "" && !strings.Contains(r.Target(), q.Target) {
+		return false
+	}
+	if !q.Since.IsZero() && r.StartTime.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !r.StartTime.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Search returns the recordings in recs that q matches, in order, up to
+// q.Limit.
+func Search(recs []Recording, q Query) []Recording {
+	var out []Recording
+	for _, r := range recs {
+		if q.Limit > 0 && len(out) >= q.Limit {
+			break
+		}
+		if q.Match(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Values returns q encoded as URL query parameters, for use with [Handler].
+func (q Query) Values() url.Values {
+	v := url.Values{}
+	if q.User != "" {
+		v.Set("user", q.User)
+	}
+	if q.Node != "" {
+		v.Set("node", q.Node)
+	}
+	if q.Target != "" {
+		v.Set("target", q.Target)
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.UTC().Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.UTC().Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// ParseQuery parses a Query from URL query parameters, as encoded by
+// [Query.Values].
+func ParseQuery(v url.Values) (q Query, err error) {
+	q.User = v.Get("user")
+	q.Node = v.Get("node")
+	q.Target = v.Get("target")
+	if s := v.Get("since"); s != "" {
+		if q.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if s := v.Get("until"); s != "" {
+		if q.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+	}
+	return q, nil
+}
+
+// Retention is a retention policy for recordings.
+type Retention struct {
+	// MaxAge, if non-zero, is how long to keep recordings for after they
+	// were last written to.
+	MaxAge time.Duration
+	// MaxSize, if non-zero, is the maximum total size of recordings in
+	// bytes. The oldest recordings are deleted to stay below it.
+	MaxSize int64
+}
+
+// Prune deletes the recordings in dir that ret doesn't retain, given recs,
+// the recordings in dir as returned by List. Recordings that are in progress
+// are never deleted. It returns the recordings that were deleted.
+func Prune(dir string, recs []Recording, ret Retention, now time.Time) (deleted []Recording, _ error) {
+	var total int64
+	for _, r := range recs {
+		total += r.Size
+	}
+	var errs []error
+	// recs is newest first, so walk it backwards to delete the oldest
+	// recordings first.
+	for i := len(recs) - 1; i >= 0; i-- {
+		r := recs[i]
+		if now.Sub(r.ModTime) < activeWindow {
+			continue
+		}
+		tooOld := ret.MaxAge > 0 && now.Sub(r.ModTime) > ret.MaxAge
+		tooBig := ret.MaxSize > 0 && total > ret.MaxSize
+		if !tooOld && !tooBig {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, filepath.FromSlash(r.Name))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		total -= r.Size
+		deleted = append(deleted, r)
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// Handler returns an HTTP handler that serves the recordings in dir that
+// match a [Query] as JSON, newest first, at GET /recordings.
+func Handler(dir string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /recordings", func(w http.ResponseWriter, r *http.Request) {
+		q, err := ParseQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recs, err := List(dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recs = Search(recs, q)
+		if recs == nil {
+			recs = []Recording{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recs)
+	})
+	return mux
+}
@@ -0,0 +1,224 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package recordings
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/sessionrecording"
+)
+
+var now = time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+
+// writeRecording writes a recording with the given header and size to
+// dir/name, last modified at modTime.
+func writeRecording(t *testing.T, dir, name string, hdr sessionrecording.CastHeader, size int, modTime time.Time) {
+	t.Helper()
+	b, err := json.Marshal(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = append(b, '\n')
+	if len(b) < size {
+		b = append(b, strings.Repeat("x", size-len(b))...)
+	}
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setup(t *testing.T) string {
+	dir := t.TempDir()
+	writeRecording(t, dir, "node1/a.cast", sessionrecording.CastHeader{
+		Timestamp:   now.Add(-3 * time.Hour).Unix(),
+		SrcNode:     "laptop.tail-scale.ts.net",
+		SrcNodeUser: "alice@example.com",
+		LocalUser:   "root",
+	}, 1000, now.Add(-2*time.Hour))
+	writeRecording(t, dir, "node1/b.cast", sessionrecording.CastHeader{
+		Timestamp:   now.Add(-2 * time.Hour).Unix(),
+		SrcNode:     "desktop.tail-scale.ts.net",
+		SrcNodeUser: "bob@example.com",
+		Kubernetes: &sessionrecording.Kubernetes{
+			PodName:   "nginx",
+			Namespace: "default",
+		},
+	}, 1000, now.Add(-time.Hour))
+	writeRecording(t, dir, "node2/c.cast", sessionrecording.CastHeader{
+		Timestamp:   now.Add(-10 * time.Second).Unix(),
+		SrcNode:     "laptop.tail-scale.ts.net",
+		SrcNodeUser: "alice@example.com",
+		LocalUser:   "ubuntu",
+	}, 1000, now)
+	// Not recordings.
+	if err := os.WriteFile(filepath.Join(dir, "node2", "empty.cast"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("hi\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func names(recs []Recording) []string {
+	var out []string
+	for _, r := range recs {
+		out = append(out, r.Name)
+	}
+	return out
+}
+
+func TestList(t *testing.T) {
+	dir := setup(t)
+	recs, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(names(recs), []string{"node2/c.cast", "node1/b.cast", "node1/a.cast"}); diff != "" {
+		t.Errorf("(-got +want):\n%s", diff)
+	}
+	if got, want := recs[1].Target(), "default/nginx"; got != want {
+		t.Errorf("Target() = %q, want %q", got, want)
+	}
+	if got, want := recs[2].Target(), "root"; got != want {
+		t.Errorf("Target() = %q, want %q", got, want)
+	}
+
+	recs, err = List(filepath.Join(dir, "missing"))
+	if err != nil || len(recs) != 0 {
+		t.Errorf("List(missing) = %v, %v; want no recordings", recs, err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	recs, err := List(setup(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"all", Query{}, []string{"node2/c.cast", "node1/b.cast", "node1/a.cast"}},
+		{"limit", Query{Limit: 1}, []string{"node2/c.cast"}},
+		{"user", Query{User: "Alice@example.com"}, []string{"node2/c.cast", "node1/a.cast"}},
+		{"node_short", Query{Node: "desktop"}, []string{"node1/b.cast"}},
+		{"node_fqdn", Query{Node: "laptop.tail-scale.ts.net", Limit: 1}, []string{"node2/c.cast"}},
+		{"target", Query{Target: "nginx"}, []string{"node1/b.cast"}},
+		{"since_until", Query{Since: now.Add(-3 * time.Hour), Until: now.Add(-2 * time.Hour)}, []string{"node1/a.cast"}},
+		{"none", Query{User: "carol@example.com"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(names(Search(recs, tt.q)), tt.want); diff != "" {
+				t.Errorf("(-got +want):\n%s", diff)
+			}
+			q, err := ParseQuery(tt.q.Values())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !q.Since.Equal(tt.q.Since) || !q.Until.Equal(tt.q.Until) {
+				t.Errorf("ParseQuery(Values()) times = %v, %v; want %v, %v", q.Since, q.Until, tt.q.Since, tt.q.Until)
+			}
+			q.Since, q.Until = tt.q.Since, tt.q.Until
+			if q != tt.q {
+				t.Errorf("ParseQuery(Values()) = %+v, want %+v", q, tt.q)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name        string
+		ret         Retention
+		wantDeleted []string
+	}{
+		{"none", Retention{}, nil},
+		{"max_age", Retention{MaxAge: 90 * time.Minute}, []string{"node1/a.cast"}},
+		{"max_size", Retention{MaxSize: 1500}, []string{"node1/a.cast", "node1/b.cast"}},
+		// The recording in progress is kept even though it's over the limit.
+		{"in_progress", Retention{MaxSize: 1}, []string{"node1/a.cast", "node1/b.cast"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setup(t)
+			recs, err := List(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			deleted, err := Prune(dir, recs, tt.ret, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(names(deleted), tt.wantDeleted); diff != "" {
+				t.Errorf("deleted (-got +want):\n%s", diff)
+			}
+			left, err := List(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.wantDeleted {
+				if slices.Contains(names(left), name) {
+					t.Errorf("%s was not deleted", name)
+				}
+			}
+			if len(left)+len(deleted) != len(recs) {
+				t.Errorf("got %d recordings left, want %d", len(left), len(recs)-len(deleted))
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	srv := httptest.NewServer(Handler(setup(t)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/recordings?" + Query{User: "alice@example.com", Limit: 1}.Values().Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %s, want 200", resp.Status)
+	}
+	var recs []Recording
+	if err := json.NewDecoder(resp.Body).Decode(&recs); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(names(recs), []string{"node2/c.cast"}); diff != "" {
+		t.Errorf("(-got +want):\n%s", diff)
+	}
+	if got, want := recs[0].Header.LocalUser, "ubuntu"; got != want {
+		t.Errorf("LocalUser = %q, want %q", got, want)
+	}
+
+	resp, err = http.Get(srv.URL + "/recordings?limit=-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %s for invalid query, want 400", resp.Status)
+	}
+}
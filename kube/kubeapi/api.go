@@ -8,6 +8,7 @@
 package kubeapi
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -161,6 +162,55 @@ type SecretList struct {
 	Items []Secret `json:"items,omitempty"`
 }
 
+// ConfigMap holds configuration data for pods to consume.
+type ConfigMap struct {
+	TypeMeta   `json:",inline"`
+	ObjectMeta `json:"metadata"`
+
+	// Data contains the configuration data. Each key must consist of
+	// alphanumeric characters, '-', '_' or '.'.
+	// +optional
+	Data map[string]string `json:"data,omitempty"`
+
+	// BinaryData contains the binary configuration data. The keys must not
+	// overlap with the keys in Data.
+	// +optional
+	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+}
+
+// ConfigMapList is a list of ConfigMap objects.
+type ConfigMapList struct {
+	TypeMeta   `json:",inline"`
+	ObjectMeta `json:"metadata"`
+
+	Items []ConfigMap `json:"items,omitempty"`
+}
+
+// WatchEventType is the type of a WatchEvent.
+type WatchEventType string
+
+const (
+	WatchEventAdded    WatchEventType = "ADDED"
+	WatchEventModified WatchEventType = "MODIFIED"
+	WatchEventDeleted  WatchEventType = "DELETED"
+	// WatchEventBookmark events only carry the resource version of the
+	// watched resources, to allow resuming the watch from it.
+	WatchEventBookmark WatchEventType = "BOOKMARK"
+	// WatchEventError events carry a Status describing why the watch ended.
+	WatchEventError WatchEventType = "ERROR"
+)
+
+// WatchEvent is a single event in a watch stream. It is a copy of
+// metav1.WatchEvent.
+// https://github.com/kubernetes/apimachinery/blob/v0.31.0/pkg/apis/meta/v1/watch.go#L31
+type WatchEvent struct {
+	Type WatchEventType `json:"type"`
+
+	// Object is the JSON encoded object that the event is about. For ERROR
+	// events it is a Status.
+	Object json.RawMessage `json:"object"`
+}
+
 // Event contains a subset of fields from corev1.Event.
 // https://github.com/kubernetes/api/blob/6cc44b8953ae704d6d9ec2adf32e7ae19199ea9f/core/v1/types.go#L7034
 // It is copied here to avoid having to import kube libraries.
@@ -36,8 +36,9 @@
 	saPath     = "/var/run/secrets/kubernetes.io/serviceaccount"
 	defaultURL = "https://kubernetes.default.svc"
 
-	TypeSecrets = "secrets"
-	typeEvents  = "events"
+	TypeSecrets    = "secrets"
+	TypeConfigMaps = "configmaps"
+	typeEvents     = "events"
 )
 
 // rootPathForTests is set by tests to override the root path to the
@@ -71,6 +72,13 @@ type Client interface {
 	StrategicMergePatchSecret(context.Context, string, *kubeapi.Secret, string) error
 	JSONPatchResource(_ context.Context, resourceName string, resourceType string, patches []JSONPatch) error
 	CheckSecretPermissions(context.Context, string) (bool, bool, error)
+	// ListResources fetches the resources of the given type that match the
+	// label selector and decodes the list into out.
+	ListResources(_ context.Context, resourceType string, selector map[string]string, out any) error
+	// Watch watches resources of the given type and calls fn for each
+	// event. See WatchOptions for the events that are watched. It blocks
+	// until ctx is done, fn returns an error or the watch fails.
+	Watch(_ context.Context, resourceType string, opts WatchOptions, fn func(kubeapi.WatchEvent) error) error
 	SetDialer(dialer func(context.Context, string, string) (net.Conn, error))
 	SetURL(string)
 }
@@ -269,6 +277,16 @@ func (c *client) ListSecrets(ctx context.Context, selector map[string]string) (*
 	return sl, nil
 }
 
+// ListResources fetches the resources of the given type that match the label
+// selector from the Kubernetes API and decodes the list into out.
+func (c *client) ListResources(ctx context.Context, typ string, selector map[string]string, out any) error {
+	u := c.resourceURL("", typ, "")
+	if len(selector) > 0 {
+		u += "?" + url.Values{"labelSelector": {labelSelector(selector)}}.Encode()
+	}
+	return c.kubeAPIRequest(ctx, "GET", u, nil, out)
+}
+
 // CreateSecret creates a secret in the Kubernetes API.
 func (c *client) CreateSecret(ctx context.Context, s *kubeapi.Secret) error {
 	s.Namespace = c.ns
@@ -419,6 +437,17 @@ func IsNotFoundErr(err error) bool {
 	return false
 }
 
+// IsResourceExpiredErr reports whether err is the error the Kubernetes API
+// returns when a watch is started or resumed from a resource version that is
+// too old. The resources need to be listed again to get a current resource
+// version to watch from.
+func IsResourceExpiredErr(err error) bool {
+	if st, ok := err.(*kubeapi.Status); ok && st.Code == 410 {
+		return true
+	}
+	return false
+}
+
 // setEventPerms checks whether this client will be able to write tailscaled Events to its Pod and updates the state
 // accordingly. If it determines that the client can not write Events, any subsequent calls to client.Event will be a
 // no-op.
@@ -8,9 +8,13 @@
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"tailscale.com/kube/kubeapi"
@@ -126,6 +130,94 @@ func TestReturnsKubeStatusError(t *testing.T) {
 	}
 }
 
+func TestWatch(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		gotQueries []url.Values
+	)
+	cl := clientForKubeHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/test-namespace/secrets" {
+			t.Errorf("unexpected request path %q", r.URL.Path)
+		}
+		q := r.URL.Query()
+		mu.Lock()
+		gotQueries = append(gotQueries, q)
+		mu.Unlock()
+		enc := json.NewEncoder(w)
+		switch q.Get("resourceVersion") {
+		case "1":
+			enc.Encode(kubeapi.WatchEvent{Type: kubeapi.WatchEventAdded, Object: json.RawMessage(`{"metadata":{"name":"foo","resourceVersion":"2"}}`)})
+			enc.Encode(kubeapi.WatchEvent{Type: kubeapi.WatchEventBookmark, Object: json.RawMessage(`{"metadata":{"resourceVersion":"3"}}`)})
+			// End the watch, like the API server does after a timeout.
+		case "3":
+			enc.Encode(kubeapi.WatchEvent{Type: kubeapi.WatchEventError, Object: json.RawMessage(`{"code":410,"reason":"Expired"}`)})
+		}
+	}))
+
+	var got []kubeapi.WatchEventType
+	err := cl.Watch(t.Context(), TypeSecrets, WatchOptions{
+		LabelSelector:   map[string]string{"app": "web", "tier": "frontend"},
+		ResourceVersion: "1",
+	}, func(ev kubeapi.WatchEvent) error {
+		got = append(got, ev.Type)
+		return nil
+	})
+	if !IsResourceExpiredErr(err) {
+		t.Fatalf("got error %v, want resource expired error", err)
+	}
+	if want := []kubeapi.WatchEventType{kubeapi.WatchEventAdded, kubeapi.WatchEventBookmark}; !slices.Equal(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+	wantQueries := []url.Values{
+		{"watch": {"true"}, "allowWatchBookmarks": {"true"}, "labelSelector": {"app=web,tier=frontend"}, "resourceVersion": {"1"}},
+		{"watch": {"true"}, "allowWatchBookmarks": {"true"}, "labelSelector": {"app=web,tier=frontend"}, "resourceVersion": {"3"}},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(gotQueries, wantQueries); diff != "" {
+		t.Errorf("unexpected watch queries (-got +want):\n%s", diff)
+	}
+}
+
+func TestWatchWithoutEvents(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		gotQueries []url.Values
+		gotTimes   []time.Time
+	)
+	cl := clientForKubeHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gotQueries = append(gotQueries, r.URL.Query())
+		gotTimes = append(gotTimes, time.Now())
+		n := len(gotQueries)
+		mu.Unlock()
+		// End the first watch immediately, without any events.
+		if n > 1 {
+			json.NewEncoder(w).Encode(kubeapi.WatchEvent{Type: kubeapi.WatchEventError, Object: json.RawMessage(`{"code":410,"reason":"Expired"}`)})
+		}
+	}))
+
+	err := cl.Watch(t.Context(), TypeSecrets, WatchOptions{ResourceVersion: "5"}, func(ev kubeapi.WatchEvent) error {
+		t.Errorf("unexpected event %v", ev.Type)
+		return nil
+	})
+	if !IsResourceExpiredErr(err) {
+		t.Fatalf("got error %v, want resource expired error", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	wantQueries := []url.Values{
+		{"watch": {"true"}, "allowWatchBookmarks": {"true"}, "resourceVersion": {"5"}},
+		{"watch": {"true"}, "allowWatchBookmarks": {"true"}, "resourceVersion": {"5"}},
+	}
+	if diff := cmp.Diff(gotQueries, wantQueries); diff != "" {
+		t.Fatalf("unexpected watch queries (-got +want):\n%s", diff)
+	}
+	if d := gotTimes[1].Sub(gotTimes[0]); d < minWatchRetryDelay {
+		t.Errorf("watch resumed after %v, want at least %v", d, minWatchRetryDelay)
+	}
+}
+
 // clientForKubeHandler creates a client using the externally accessible package
 // API to ensure it's testing behaviour as close to prod as possible. The passed
 // in handler mocks the Kubernetes API server's responses to any HTTP requests
@@ -5,7 +5,9 @@
 
 import (
 	"context"
+	"encoding/json"
 	"net"
+	"sync"
 
 	"tailscale.com/kube/kubeapi"
 )
@@ -20,6 +22,9 @@ type FakeClient struct {
 	JSONPatchResourceImpl         func(context.Context, string, string, []JSONPatch) error
 	ListSecretsImpl               func(context.Context, map[string]string) (*kubeapi.SecretList, error)
 	StrategicMergePatchSecretImpl func(context.Context, string, *kubeapi.Secret, string) error
+	ListResourcesImpl             func(context.Context, string, map[string]string, any) error
+	// WatchImpl is usually set to the Watch method of a FakeWatcher.
+	WatchImpl func(context.Context, string, WatchOptions, func(kubeapi.WatchEvent) error) error
 }
 
 func (fc *FakeClient) CheckSecretPermissions(ctx context.Context, name string) (bool, bool, error) {
@@ -53,3 +58,97 @@ func (fc *FakeClient) ListSecrets(ctx context.Context, selector map[string]strin
 	}
 	return nil, nil
 }
+func (fc *FakeClient) ListResources(ctx context.Context, typ string, selector map[string]string, out any) error {
+	if fc.ListResourcesImpl != nil {
+		return fc.ListResourcesImpl(ctx, typ, selector, out)
+	}
+	return nil
+}
+func (fc *FakeClient) Watch(ctx context.Context, typ string, opts WatchOptions, fn func(kubeapi.WatchEvent) error) error {
+	if fc.WatchImpl != nil {
+		return fc.WatchImpl(ctx, typ, opts, fn)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// FakeWatcher simulates the watch events received by FakeClient.Watch. Set
+// FakeClient.WatchImpl to its Watch method and send events with Send.
+type FakeWatcher struct {
+	events chan fakeWatchEvent
+
+	mu   sync.Mutex   // protects following
+	opts WatchOptions // of the most recent watch
+}
+
+type fakeWatchEvent struct {
+	ev   kubeapi.WatchEvent
+	done chan struct{} // closed once the event has been handled
+}
+
+// NewFakeWatcher returns a new FakeWatcher.
+func NewFakeWatcher() *FakeWatcher {
+	return &FakeWatcher{events: make(chan fakeWatchEvent)}
+}
+
+// Watch calls fn for each event sent with Send until ctx is done, fn returns
+// an error or an ERROR event is sent, like Client.Watch.
+func (w *FakeWatcher) Watch(ctx context.Context, _ string, opts WatchOptions, fn func(kubeapi.WatchEvent) error) error {
+	w.mu.Lock()
+	w.opts = opts
+	w.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-w.events:
+			_, err := handleWatchEvent(e.ev, fn)
+			close(e.done)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Send sends an event of the given type for obj, which is JSON encoded, to
+// the watch. It blocks until the watch has handled the event.
+func (w *FakeWatcher) Send(ctx context.Context, typ kubeapi.WatchEventType, obj any) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	e := fakeWatchEvent{
+		ev:   kubeapi.WatchEvent{Type: typ, Object: b},
+		done: make(chan struct{}),
+	}
+	select {
+	case w.events <- e:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Expire ends the watch with the error the Kubernetes API returns when the
+// resource version that the watch started from has expired.
+func (w *FakeWatcher) Expire(ctx context.Context) error {
+	return w.Send(ctx, kubeapi.WatchEventError, &kubeapi.Status{
+		Status:  "Failure",
+		Reason:  "Expired",
+		Message: "too old resource version",
+		Code:    410,
+	})
+}
+
+// LastOptions returns the options of the most recent watch.
+func (w *FakeWatcher) LastOptions() WatchOptions {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.opts
+}
@@ -0,0 +1,252 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package kubeclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/kube/kubeapi"
+)
+
+const (
+	informerMinBackoff = time.Second
+	informerMaxBackoff = 30 * time.Second
+)
+
+// Informer maintains an in-memory cache of the resources of a single type in
+// the client's namespace and keeps it up to date by watching them. T is the
+// API type of the resources, for example kubeapi.Secret or
+// kubeapi.ConfigMap.
+//
+// Use NewInformer to create an Informer, register any handlers with
+// AddEventHandler and then call Run.
+type Informer[T any] struct {
+	kc       Client
+	typ      string
+	selector map[string]string
+	handlers []func(kubeapi.WatchEventType, *T)
+
+	syncedOnce sync.Once
+	synced     chan struct{} // closed once the resources have been listed
+
+	mu    sync.Mutex                 // protects following
+	items map[string]informerItem[T] // keyed by name
+	rv    string                     // resource version to watch from; empty to list
+}
+
+type informerItem[T any] struct {
+	obj *T
+	rv  string
+}
+
+// NewInformer returns an Informer for the resources of type typ, for example
+// TypeSecrets, that have all of the given labels.
+func NewInformer[T any](kc Client, typ string, labelSelector map[string]string) *Informer[T] {
+	return &Informer[T]{
+		kc:       kc,
+		typ:      typ,
+		selector: labelSelector,
+		synced:   make(chan struct{}),
+		items:    make(map[string]informerItem[T]),
+	}
+}
+
+// AddEventHandler registers fn to be called with each resource that is
+// added, modified or deleted. The resource must not be modified. Handlers are
+// called sequentially from the goroutine calling Run, after the cache has
+// been updated. AddEventHandler must be called before Run.
+func (inf *Informer[T]) AddEventHandler(fn func(typ kubeapi.WatchEventType, obj *T)) {
+	inf.handlers = append(inf.handlers, fn)
+}
+
+// Run lists the resources and then watches them for changes until ctx is
+// done. Failed watches are resumed from the last resource version seen, and
+// the resources are listed again if that resource version has expired. Run
+// always returns ctx's error.
+func (inf *Informer[T]) Run(ctx context.Context) error {
+	backoff := informerMinBackoff
+	for {
+		start := time.Now()
+		err := inf.listAndWatch(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if IsResourceExpiredErr(err) {
+			log.Printf("kubeclient: %s watch expired, listing again", inf.typ)
+			inf.mu.Lock()
+			inf.rv = ""
+			inf.mu.Unlock()
+			continue
+		}
+		if time.Since(start) > informerMaxBackoff {
+			backoff = informerMinBackoff
+		}
+		log.Printf("kubeclient: error watching %s, retrying in %v: %v", inf.typ, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, informerMaxBackoff)
+	}
+}
+
+// WaitForSync blocks until the resources have been listed for the first
+// time, or ctx is done.
+func (inf *Informer[T]) WaitForSync(ctx context.Context) error {
+	select {
+	case <-inf.synced:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// HasSynced reports whether the resources have been listed.
+func (inf *Informer[T]) HasSynced() bool {
+	select {
+	case <-inf.synced:
+		return true
+	default:
+		return false
+	}
+}
+
+// Get returns the cached resource with the given name, if any. The resource
+// must not be modified.
+func (inf *Informer[T]) Get(name string) (_ *T, ok bool) {
+	inf.mu.Lock()
+	defer inf.mu.Unlock()
+	it, ok := inf.items[name]
+	return it.obj, ok
+}
+
+// List returns the cached resources, sorted by name. The resources must not
+// be modified.
+func (inf *Informer[T]) List() []*T {
+	inf.mu.Lock()
+	defer inf.mu.Unlock()
+	objs := make([]*T, 0, len(inf.items))
+	for _, name := range slices.Sorted(maps.Keys(inf.items)) {
+		objs = append(objs, inf.items[name].obj)
+	}
+	return objs
+}
+
+func (inf *Informer[T]) listAndWatch(ctx context.Context) error {
+	inf.mu.Lock()
+	rv := inf.rv
+	inf.mu.Unlock()
+	if rv == "" {
+		var err error
+		if rv, err = inf.list(ctx); err != nil {
+			return err
+		}
+	}
+	return inf.kc.Watch(ctx, inf.typ, WatchOptions{LabelSelector: inf.selector, ResourceVersion: rv}, inf.handleEvent)
+}
+
+// list replaces the cache with the current resources and returns the resource
+// version to watch from. Handlers are called for the differences between the
+// old and new cache, as events may have been missed since the last watch.
+func (inf *Informer[T]) list(ctx context.Context) (resourceVersion string, _ error) {
+	var l struct {
+		kubeapi.ObjectMeta `json:"metadata"`
+		Items              []json.RawMessage `json:"items"`
+	}
+	if err := inf.kc.ListResources(ctx, inf.typ, inf.selector, &l); err != nil {
+		return "", fmt.Errorf("error listing %s: %w", inf.typ, err)
+	}
+	items := make(map[string]informerItem[T], len(l.Items))
+	for _, raw := range l.Items {
+		name, it, err := decodeInformerItem[T](raw)
+		if err != nil {
+			return "", err
+		}
+		items[name] = it
+	}
+
+	inf.mu.Lock()
+	old := inf.items
+	inf.items = items
+	inf.rv = l.ResourceVersion
+	inf.mu.Unlock()
+	inf.syncedOnce.Do(func() { close(inf.synced) })
+
+	for _, name := range slices.Sorted(maps.Keys(items)) {
+		it := items[name]
+		if o, ok := old[name]; !ok {
+			inf.notify(kubeapi.WatchEventAdded, it.obj)
+		} else if o.rv != it.rv {
+			inf.notify(kubeapi.WatchEventModified, it.obj)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(old)) {
+		if _, ok := items[name]; !ok {
+			inf.notify(kubeapi.WatchEventDeleted, old[name].obj)
+		}
+	}
+	return l.ResourceVersion, nil
+}
+
+func (inf *Informer[T]) handleEvent(ev kubeapi.WatchEvent) error {
+	if ev.Type == kubeapi.WatchEventBookmark {
+		var obj struct {
+			kubeapi.ObjectMeta `json:"metadata"`
+		}
+		if err := json.Unmarshal(ev.Object, &obj); err != nil {
+			return fmt.Errorf("error decoding %s bookmark: %w", inf.typ, err)
+		}
+		inf.mu.Lock()
+		inf.rv = obj.ResourceVersion
+		inf.mu.Unlock()
+		return nil
+	}
+	name, it, err := decodeInformerItem[T](ev.Object)
+	if err != nil {
+		return err
+	}
+	inf.mu.Lock()
+	switch ev.Type {
+	case kubeapi.WatchEventAdded, kubeapi.WatchEventModified:
+		inf.items[name] = it
+	case kubeapi.WatchEventDeleted:
+		delete(inf.items, name)
+	default:
+		inf.mu.Unlock()
+		log.Printf("kubeclient: ignoring %s watch event of unknown type %q", inf.typ, ev.Type)
+		return nil
+	}
+	inf.rv = it.rv
+	inf.mu.Unlock()
+	inf.notify(ev.Type, it.obj)
+	return nil
+}
+
+func (inf *Informer[T]) notify(typ kubeapi.WatchEventType, obj *T) {
+	for _, fn := range inf.handlers {
+		fn(typ, obj)
+	}
+}
+
+func decodeInformerItem[T any](raw json.RawMessage) (name string, _ informerItem[T], _ error) {
+	var meta struct {
+		kubeapi.ObjectMeta `json:"metadata"`
+	}
+	obj := new(T)
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return "", informerItem[T]{}, fmt.Errorf("error decoding metadata: %w", err)
+	}
+	if err := json.Unmarshal(raw, obj); err != nil {
+		return "", informerItem[T]{}, fmt.Errorf("error decoding %s: %w", meta.Name, err)
+	}
+	return meta.Name, informerItem[T]{obj: obj, rv: meta.ResourceVersion}, nil
+}
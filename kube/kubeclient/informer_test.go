@@ -0,0 +1,127 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package kubeclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"tailscale.com/kube/kubeapi"
+)
+
+func TestInformer(t *testing.T) {
+	cm := func(name, rv, val string) *kubeapi.ConfigMap {
+		return &kubeapi.ConfigMap{
+			ObjectMeta: kubeapi.ObjectMeta{Name: name, ResourceVersion: rv},
+			Data:       map[string]string{"val": val},
+		}
+	}
+	var (
+		mu   sync.Mutex
+		list = &kubeapi.ConfigMapList{
+			ObjectMeta: kubeapi.ObjectMeta{ResourceVersion: "10"},
+			Items:      []kubeapi.ConfigMap{*cm("a", "1", "a1"), *cm("b", "2", "b1")},
+		}
+		events []string
+	)
+	fw := NewFakeWatcher()
+	kc := &FakeClient{
+		ListResourcesImpl: func(_ context.Context, typ string, sel map[string]string, out any) error {
+			if typ != TypeConfigMaps || sel["app"] != "web" {
+				t.Errorf("unexpected list of %s with selector %v", typ, sel)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			b, err := json.Marshal(list)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(b, out)
+		},
+		WatchImpl: fw.Watch,
+	}
+	inf := NewInformer[kubeapi.ConfigMap](kc, TypeConfigMaps, map[string]string{"app": "web"})
+	inf.AddEventHandler(func(typ kubeapi.WatchEventType, obj *kubeapi.ConfigMap) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, fmt.Sprintf("%s %s=%s", typ, obj.Name, obj.Data["val"]))
+	})
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() { done <- inf.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	if err := inf.WaitForSync(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	expectEvents := func(want ...string) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if !slices.Equal(events, want) {
+			t.Errorf("got events %q, want %q", events, want)
+		}
+		events = nil
+	}
+	expectCache := func(want ...string) {
+		t.Helper()
+		var got []string
+		for _, c := range inf.List() {
+			got = append(got, c.Name+"="+c.Data["val"])
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("got cache %q, want %q", got, want)
+		}
+	}
+	mustSend := func(typ kubeapi.WatchEventType, obj any) {
+		t.Helper()
+		if err := fw.Send(t.Context(), typ, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectEvents("ADDED a=a1", "ADDED b=b1")
+	expectCache("a=a1", "b=b1")
+
+	mustSend(kubeapi.WatchEventModified, cm("a", "11", "a2"))
+	mustSend(kubeapi.WatchEventAdded, cm("c", "12", "c1"))
+	mustSend(kubeapi.WatchEventDeleted, cm("b", "13", "b1"))
+	expectEvents("MODIFIED a=a2", "ADDED c=c1", "DELETED b=b1")
+	expectCache("a=a2", "c=c1")
+	if got, ok := inf.Get("c"); !ok || got.Data["val"] != "c1" {
+		t.Errorf("Get(c) = %v, %v; want c1", got, ok)
+	}
+	if _, ok := inf.Get("b"); ok {
+		t.Errorf("Get(b) found deleted ConfigMap")
+	}
+	if got := fw.LastOptions().ResourceVersion; got != "10" {
+		t.Errorf("watch started from resource version %q, want %q", got, "10")
+	}
+
+	// After the watch expires, the informer lists again and reports the
+	// changes it missed.
+	mustSend(kubeapi.WatchEventBookmark, &kubeapi.ConfigMap{ObjectMeta: kubeapi.ObjectMeta{ResourceVersion: "14"}})
+	mu.Lock()
+	list = &kubeapi.ConfigMapList{
+		ObjectMeta: kubeapi.ObjectMeta{ResourceVersion: "20"},
+		Items:      []kubeapi.ConfigMap{*cm("a", "11", "a2"), *cm("d", "15", "d1")},
+	}
+	mu.Unlock()
+	if err := fw.Expire(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	mustSend(kubeapi.WatchEventModified, cm("d", "21", "d2"))
+	expectEvents("ADDED d=d1", "DELETED c=c1", "MODIFIED d=d2")
+	expectCache("a=a2", "d=d2")
+	if got := fw.LastOptions().ResourceVersion; got != "20" {
+		t.Errorf("watch resumed from resource version %q, want %q", got, "20")
+	}
+}
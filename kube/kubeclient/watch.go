@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package kubeclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/kube/kubeapi"
+)
+
+// Watches that end without any events are resumed after a delay that starts
+// at minWatchRetryDelay and doubles up to maxWatchRetryDelay, so that an API
+// server (or proxy) that ends watches immediately isn't hammered with
+// requests.
+const (
+	minWatchRetryDelay = time.Second
+	maxWatchRetryDelay = 30 * time.Second
+)
+
+// WatchOptions configures a watch started with Client.Watch.
+type WatchOptions struct {
+	// LabelSelector limits the watch to resources with these labels.
+	LabelSelector map[string]string
+	// ResourceVersion is the resource version to start the watch from,
+	// usually the resource version of a previous list or of the last event
+	// seen by a previous watch. Only changes made after it are watched.
+	// If empty, the watch starts with synthetic ADDED events for all
+	// existing resources.
+	ResourceVersion string
+}
+
+// Watch watches resources of the given type in the client's namespace and
+// calls fn for each ADDED, MODIFIED, DELETED and BOOKMARK event. The API
+// server ends watches after a timeout; Watch transparently resumes them from
+// the resource version of the last event seen, backing off if they end
+// without any events. It returns the error from fn, or the error that ended
+// the watch otherwise. If the resource version to resume from has expired,
+// the error satisfies IsResourceExpiredErr.
+func (c *client) Watch(ctx context.Context, typ string, opts WatchOptions, fn func(kubeapi.WatchEvent) error) error {
+	var delay time.Duration
+	for {
+		events, err := c.watch(ctx, typ, &opts, fn)
+		if err != nil {
+			return err
+		}
+		if events > 0 {
+			delay = 0
+			continue
+		}
+		delay = min(max(2*delay, minWatchRetryDelay), maxWatchRetryDelay)
+		tc, timerChannel := c.cl.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			tc.Stop()
+			return ctx.Err()
+		case <-timerChannel:
+		}
+	}
+}
+
+// watch runs a single watch request, updating opts.ResourceVersion as events
+// are received. It returns the number of events received if the API server
+// ended the watch.
+func (c *client) watch(ctx context.Context, typ string, opts *WatchOptions, fn func(kubeapi.WatchEvent) error) (events int, _ error) {
+	q := url.Values{
+		"watch":               {"true"},
+		"allowWatchBookmarks": {"true"},
+	}
+	if opts.ResourceVersion != "" {
+		q.Set("resourceVersion", opts.ResourceVersion)
+	}
+	if len(opts.LabelSelector) > 0 {
+		q.Set("labelSelector", labelSelector(opts.LabelSelector))
+	}
+	req, err := c.newRequest(ctx, "GET", c.resourceURL("", typ, "")+"?"+q.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := getError(resp); err != nil {
+		if st, ok := err.(*kubeapi.Status); ok && st.Code == 401 {
+			c.expireToken()
+		}
+		return 0, err
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev kubeapi.WatchEvent
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) {
+				return events, nil
+			}
+			if ctx.Err() != nil {
+				return events, ctx.Err()
+			}
+			return events, fmt.Errorf("error reading %s watch: %w", typ, err)
+		}
+		rv, err := handleWatchEvent(ev, fn)
+		if err != nil {
+			return events, err
+		}
+		events++
+		if rv != "" {
+			opts.ResourceVersion = rv
+		}
+	}
+}
+
+// handleWatchEvent calls fn for ev and returns the resource version of ev's
+// object. ERROR events are returned as a *kubeapi.Status error instead.
+func handleWatchEvent(ev kubeapi.WatchEvent, fn func(kubeapi.WatchEvent) error) (resourceVersion string, _ error) {
+	if ev.Type == kubeapi.WatchEventError {
+		st := new(kubeapi.Status)
+		if err := json.Unmarshal(ev.Object, st); err != nil {
+			return "", fmt.Errorf("error decoding watch error: %w", err)
+		}
+		return "", st
+	}
+	var obj struct {
+		kubeapi.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(ev.Object, &obj); err != nil {
+		return "", fmt.Errorf("error decoding %s watch event: %w", ev.Type, err)
+	}
+	if err := fn(ev); err != nil {
+		return "", err
+	}
+	return obj.ResourceVersion, nil
+}
+
+// labelSelector returns the label selector query parameter value that
+// selects resources with all of the given labels.
+func labelSelector(labels map[string]string) string {
+	s := make([]string, 0, len(labels))
+	for k, v := range labels {
+		s = append(s, k+"="+v)
+	}
+	slices.Sort(s)
+	return strings.Join(s, ",")
+}
@@ -21,6 +21,7 @@
 	MetricIngressProxyCount              = "k8s_ingress_proxies"      // L3
 	MetricIngressResourceCount           = "k8s_ingress_resources"    // L7
 	MetricIngressPGResourceCount         = "k8s_ingress_pg_resources" // L7 on ProxyGroup
+	MetricGatewayResourceCount           = "k8s_gateway_resources"
 	MetricServicePGResourceCount         = "k8s_service_pg_resources" // L3 on ProxyGroup
 	MetricEgressProxyCount               = "k8s_egress_proxies"
 	MetricConnectorResourceCount         = "k8s_connector_resources"
@@ -34,6 +35,9 @@
 	MetricProxyGroupIngressCount         = "k8s_proxygroup_ingress_resources"
 	MetricProxyGroupAPIServerCount       = "k8s_proxygroup_kube_apiserver_resources"
 	MetricTailnetCount                   = "k8s_tailnet_resources"
+	MetricTailnetAccessPolicyCount       = "k8s_tailnetaccesspolicy_resources"
+	MetricServiceExportCount             = "k8s_serviceexport_resources"
+	MetricServiceImportCount             = "k8s_serviceimport_resources"
 
 	// Keys that containerboot writes to state file that can be used to determine its state.
 	// fields set in Tailscale state Secret. These are mostly used by the Tailscale Kubernetes operator to determine
@@ -56,11 +60,12 @@
 	EgessServicesPreshutdownEP = "/internal-egress-services-preshutdown"
 
 	LabelManaged    = "tailscale.com/managed"
-	LabelSecretType = "tailscale.com/secret-type" // "config", "state" "certs"
+	LabelSecretType = "tailscale.com/secret-type" // "config", "state", "state-shard" "certs"
 
-	LabelSecretTypeConfig = "config"
-	LabelSecretTypeState  = "state"
-	LabelSecretTypeCerts  = "certs"
+	LabelSecretTypeConfig     = "config"
+	LabelSecretTypeState      = "state"
+	LabelSecretTypeStateShard = "state-shard" // TLS certs and keys split off from a state Secret
+	LabelSecretTypeCerts      = "certs"
 
 	KubeAPIServerConfigFile                     = "config.hujson"
 	APIServerProxyModeAuth   APIServerProxyMode = "auth"
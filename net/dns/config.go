@@ -118,7 +118,14 @@ func (c Config) hasDefaultIPResolversOnly() bool {
 	if !c.hasDefaultResolvers() || c.hasRoutes() {
 		return false
 	}
-	for _, r := range c.DefaultResolvers {
+	return allPlainIPResolvers(c.DefaultResolvers)
+}
+
+// allPlainIPResolvers reports whether resolvers are all simple IP addresses
+// that speak regular port 53 DNS, rather than DoH or DoT servers, which
+// only quad-100 can forward to.
+func allPlainIPResolvers(resolvers []*dnstype.Resolver) bool {
+	for _, r := range resolvers {
 		if ipp, ok := r.IPPort(); !ok || ipp.Port() != 53 || publicdns.IPIsDoHOnlyServer(ipp.Addr()) {
 			return false
 		}
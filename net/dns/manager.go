@@ -361,9 +361,9 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// workaround.
 	isWindows := m.goos == "windows"
 	isApple := (m.goos == "darwin" || m.goos == "ios")
-	if len(cfg.singleResolverSet()) > 0 && m.os.SupportsSplitDNS() && !isWindows && !isApple {
+	if rs := cfg.singleResolverSet(); len(rs) > 0 && allPlainIPResolvers(rs) && m.os.SupportsSplitDNS() && !isWindows && !isApple {
 		// Split DNS configuration requested, where all split domains
-		// go to the same resolvers. We can let the OS do it.
+		// go to the same plain DNS resolvers. We can let the OS do it.
 		ocfg.Nameservers = toIPsOnly(cfg.singleResolverSet())
 		ocfg.MatchDomains = cfg.matchDomains()
 		return rcfg, ocfg, nil
@@ -419,6 +419,22 @@ func TestManager(t *testing.T) {
 				MatchDomains:  fqdns("corp.com"),
 			},
 		},
+		{
+			name: "routes-split-dot",
+			in: Config{
+				Routes:        upstreams("corp.com", "tls://2.2.2.2"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+			},
+			split: true,
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+				MatchDomains:  fqdns("corp.com"),
+			},
+			rs: resolver.Config{
+				Routes: upstreams("corp.com.", "tls://2.2.2.2"),
+			},
+		},
 		{
 			name: "routes-multi",
 			in: Config{
@@ -1030,7 +1046,7 @@ func upstreams(strs ...string) (ret map[dnsname.FQDN][]*dnstype.Resolver) {
 				panic("IPPort provided before suffix")
 			}
 			ret[key] = append(ret[key], &dnstype.Resolver{Addr: s})
-		} else if strings.HasPrefix(s, "http") {
+		} else if strings.HasPrefix(s, "http") || strings.HasPrefix(s, "tls://") {
 			ret[key] = append(ret[key], &dnstype.Resolver{Addr: s})
 		} else {
 			fqdn, err := dnsname.ToFQDN(s)
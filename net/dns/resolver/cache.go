@@ -0,0 +1,365 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/syncs"
+	"tailscale.com/util/lru"
+	"tailscale.com/util/set"
+)
+
+const (
+	// maxCacheEntries is the maximum number of responses kept in a
+	// respCache before the least recently used ones are evicted.
+	maxCacheEntries = 4096
+
+	// maxCacheTTL caps how long a positive response is cached, regardless
+	// of the TTLs in it.
+	maxCacheTTL = 24 * time.Hour
+
+	// maxNegativeCacheTTL caps how long a negative (NXDOMAIN or NODATA)
+	// response is cached. RFC 2308 recommends between one and three hours,
+	// but names that are missing are often just about to be created.
+	maxNegativeCacheTTL = 15 * time.Minute
+
+	// prefetchMinHits is how many times a cached response must have been
+	// used before it's worth refreshing it before it expires.
+	prefetchMinHits = 3
+
+	// prefetchMinTTL is the smallest TTL for which responses are
+	// prefetched. Shorter TTLs are usually there to make clients ask again.
+	prefetchMinTTL = 10 * time.Second
+)
+
+// cacheKey identifies the cached response to a query.
+type cacheKey struct {
+	upstreams string // comma-separated addresses of the resolvers queried
+	name      string // lowercase
+	qtype     dns.Type
+	qclass    dns.Class
+	dnssecOK  bool // the EDNS DO bit
+	cd        bool // the Checking Disabled bit
+}
+
+// cacheQuery is a query that can be answered from a respCache.
+type cacheQuery struct {
+	key      cacheKey
+	id       uint16
+	question dns.Question // as sent, including the case of the name
+}
+
+// newCacheQuery returns the cacheQuery for the query bs, which is to be
+// forwarded to resolvers. It reports false if the query can't be answered
+// from the cache.
+func newCacheQuery(bs []byte, resolvers []resolverAndDelay) (cq cacheQuery, ok bool) {
+	var p dns.Parser
+	h, err := p.Start(bs)
+	if err != nil || h.Response || h.OpCode != 0 {
+		return cq, false
+	}
+	qs, err := p.AllQuestions()
+	if err != nil || len(qs) != 1 {
+		return cq, false
+	}
+	q := qs[0]
+	switch q.Type {
+	case dns.TypeALL, dns.TypeAXFR:
+		return cq, false
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		return cq, false
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return cq, false
+	}
+	var dnssecOK bool
+	for {
+		rh, err := p.AdditionalHeader()
+		if err == dns.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return cq, false
+		}
+		if rh.Type == dns.TypeOPT {
+			dnssecOK = rh.DNSSECAllowed()
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return cq, false
+		}
+	}
+
+	var upstreams strings.Builder
+	for i, rr := range resolvers {
+		if i > 0 {
+			upstreams.WriteByte(',')
+		}
+		upstreams.WriteString(rr.name.Addr)
+	}
+	return cacheQuery{
+		key: cacheKey{
+			upstreams: upstreams.String(),
+			name:      strings.ToLower(q.Name.String()),
+			qtype:     q.Type,
+			qclass:    q.Class,
+			dnssecOK:  dnssecOK,
+			cd:        h.CheckingDisabled,
+		},
+		id:       h.ID,
+		question: q,
+	}, true
+}
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	msg      dns.Message
+	stored   time.Time
+	expires  time.Time
+	ttl      time.Duration // expires - stored
+	negative bool
+
+	hits        int  // times used since stored
+	prefetching bool // whether a refresh is in progress
+}
+
+// response returns the response to send for cq at time now, with TTLs
+// reduced by the time the entry has been in the cache.
+func (e *cacheEntry) response(cq cacheQuery, now time.Time) ([]byte, error) {
+	age := uint32(now.Sub(e.stored) / time.Second)
+	remaining := uint32(e.expires.Sub(now) / time.Second)
+	msg := dns.Message{
+		Header:      e.msg.Header,
+		Questions:   []dns.Question{cq.question},
+		Answers:     agedResources(e.msg.Answers, age, remaining),
+		Authorities: agedResources(e.msg.Authorities, age, remaining),
+		Additionals: agedResources(e.msg.Additionals, age, remaining),
+	}
+	msg.Header.ID = cq.id
+	return msg.Pack()
+}
+
+// agedResources returns a copy of rrs with their TTLs reduced by age
+// seconds, and capped at remaining seconds.
+func agedResources(rrs []dns.Resource, age, remaining uint32) []dns.Resource {
+	if len(rrs) == 0 {
+		return nil
+	}
+	ret := make([]dns.Resource, len(rrs))
+	for i, rr := range rrs {
+		if rr.Header.Type != dns.TypeOPT { // OPT's TTL holds flags
+			rr.Header.TTL = min(max(rr.Header.TTL, age)-age, remaining)
+		}
+		ret[i] = rr
+	}
+	return ret
+}
+
+// cacheStats are counters describing a respCache's effectiveness.
+type cacheStats struct {
+	Hits         int64 // queries answered from the cache
+	NegativeHits int64 // of Hits, how many were NXDOMAIN or NODATA
+	Misses       int64 // cacheable queries that weren't in the cache
+	Stores       int64 // responses added to the cache
+	Prefetches   int64 // responses refreshed before they expired
+	Evictions    int64 // unexpired responses evicted to make room
+}
+
+// respCache caches the responses to forwarded queries, honoring their TTLs
+// and caching negative responses as described in RFC 2308.
+type respCache struct {
+	mu    syncs.Mutex
+	ents  lru.Cache[cacheKey, *cacheEntry]
+	stats cacheStats
+}
+
+func newRespCache() *respCache {
+	c := &respCache{}
+	c.ents.MaxEntries = maxCacheEntries
+	return c
+}
+
+// get returns the cached response to cq at time now, or nil if there's
+// none. If prefetch is true, the response is popular and about to expire,
+// and the caller should refresh it with put, or call prefetchDone if it
+// can't.
+func (c *respCache) get(cq cacheQuery, now time.Time) (res []byte, prefetch bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.ents.GetOk(cq.key)
+	if ok && !now.Before(e.expires) {
+		c.ents.Delete(cq.key)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		metricDNSFwdCacheMiss.Add(1)
+		return nil, false
+	}
+	res, err := e.response(cq, now)
+	if err != nil {
+		c.ents.Delete(cq.key)
+		c.stats.Misses++
+		metricDNSFwdCacheMiss.Add(1)
+		return nil, false
+	}
+	e.hits++
+	c.stats.Hits++
+	metricDNSFwdCacheHit.Add(1)
+	if e.negative {
+		c.stats.NegativeHits++
+	}
+	if !e.negative && !e.prefetching && e.hits >= prefetchMinHits &&
+		e.ttl >= prefetchMinTTL && e.expires.Sub(now) < e.ttl/10 {
+		e.prefetching = true
+		c.stats.Prefetches++
+		metricDNSFwdCachePrefetch.Add(1)
+		prefetch = true
+	}
+	return res, prefetch
+}
+
+// prefetchDone records that the refresh of key that get asked for has
+// finished, whether or not it succeeded.
+func (c *respCache) prefetchDone(key cacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.ents.PeekOk(key); ok {
+		e.prefetching = false
+	}
+}
+
+// put caches the response res to the query identified by key, received at
+// time now, if it's cacheable.
+func (c *respCache) put(key cacheKey, res []byte, now time.Time) {
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		return
+	}
+	ttl, negative, ok := cacheTTL(key, &msg)
+	if !ok {
+		return
+	}
+	// EDNS options such as cookies are specific to the client that sent
+	// the query, so drop them.
+	for i, rr := range msg.Additionals {
+		if rr.Header.Type == dns.TypeOPT {
+			msg.Additionals[i].Body = &dns.OPTResource{}
+		}
+	}
+	e := &cacheEntry{
+		msg:      msg,
+		stored:   now,
+		expires:  now.Add(ttl),
+		ttl:      ttl,
+		negative: negative,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.ents.Contains(key) && c.ents.Len() >= maxCacheEntries {
+		c.stats.Evictions++
+	}
+	c.ents.Set(key, e)
+	c.stats.Stores++
+}
+
+// cacheTTL reports how long msg, the response to the query identified by
+// key, may be cached, and whether it's a negative response. It reports
+// false if msg mustn't be cached.
+func cacheTTL(key cacheKey, msg *dns.Message) (ttl time.Duration, negative, ok bool) {
+	if !msg.Response || msg.Truncated || len(msg.Questions) != 1 {
+		return 0, false, false
+	}
+	q := msg.Questions[0]
+	if !strings.EqualFold(q.Name.String(), key.name) || q.Type != key.qtype || q.Class != key.qclass {
+		return 0, false, false
+	}
+
+	switch msg.RCode {
+	case dns.RCodeSuccess:
+		if len(msg.Answers) > 0 {
+			minTTL := msg.Answers[0].Header.TTL
+			for _, rr := range msg.Answers[1:] {
+				minTTL = min(minTTL, rr.Header.TTL)
+			}
+			ttl = min(time.Duration(minTTL)*time.Second, maxCacheTTL)
+			return ttl, false, ttl > 0
+		}
+		// NODATA; cached like NXDOMAIN.
+	case dns.RCodeNameError:
+	default:
+		return 0, false, false
+	}
+
+	// RFC 2308, section 5: negative responses are cached for the smaller
+	// of the SOA record's TTL and its MINIMUM field. Without an SOA, they
+	// aren't cached at all.
+	for _, rr := range msg.Authorities {
+		soa, isSOA := rr.Body.(*dns.SOAResource)
+		if !isSOA {
+			continue
+		}
+		ttl = time.Duration(min(rr.Header.TTL, soa.MinTTL)) * time.Second
+		ttl = min(ttl, maxNegativeCacheTTL)
+		return ttl, true, ttl > 0
+	}
+	return 0, false, false
+}
+
+// flush removes all cached responses.
+func (c *respCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ents.Clear()
+}
+
+// getStats returns c's counters and the number of responses in it.
+func (c *respCache) getStats() (stats cacheStats, entries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats, c.ents.Len()
+}
+
+var (
+	debugCachesMu syncs.Mutex
+	debugCaches   set.Set[*respCache] // caches in use, for the debug handler
+)
+
+func registerDebugCache(c *respCache) {
+	debugCachesMu.Lock()
+	defer debugCachesMu.Unlock()
+	if debugCaches == nil {
+		debugCaches = set.Set[*respCache]{}
+	}
+	debugCaches.Add(c)
+}
+
+func unregisterDebugCache(c *respCache) {
+	debugCachesMu.Lock()
+	defer debugCachesMu.Unlock()
+	debugCaches.Delete(c)
+}
+
+// writeDebugCacheStats writes the stats of the caches in use to w as HTML.
+func writeDebugCacheStats(w io.Writer) {
+	debugCachesMu.Lock()
+	caches := debugCaches.Slice()
+	debugCachesMu.Unlock()
+	if len(caches) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "<h1>Response cache</h1>\n")
+	for _, c := range caches {
+		st, n := c.getStats()
+		fmt.Fprintf(w, "entries: %d, hits: %d (negative: %d), misses: %d, stores: %d, prefetches: %d, evictions: %d<br>\n",
+			n, st.Hits, st.NegativeHits, st.Misses, st.Stores, st.Prefetches, st.Evictions)
+	}
+}
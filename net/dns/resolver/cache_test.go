@@ -0,0 +1,274 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/control/controlknobs"
+	"tailscale.com/health"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/tstest"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/eventbus/eventbustest"
+)
+
+// makeNegativeTestResponse returns an A response for domain with the given
+// code and no answers. If soaTTL is non-zero, it includes an SOA record with
+// that TTL and a MINIMUM of minTTL.
+func makeNegativeTestResponse(tb testing.TB, domain string, code dns.RCode, soaTTL, minTTL uint32) []byte {
+	tb.Helper()
+	name := dns.MustNewName(domain)
+	b := dns.NewBuilder(nil, dns.Header{Response: true, RCode: code})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: name, Type: dns.TypeA, Class: dns.ClassINET})
+	if soaTTL != 0 {
+		b.StartAuthorities()
+		b.SOAResource(dns.ResourceHeader{
+			Name:  dns.MustNewName("example.com."),
+			Class: dns.ClassINET,
+			TTL:   soaTTL,
+		}, dns.SOAResource{
+			NS:     dns.MustNewName("ns.example.com."),
+			MBox:   dns.MustNewName("hostmaster.example.com."),
+			MinTTL: minTTL,
+		})
+	}
+	res, err := b.Finish()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return res
+}
+
+func mustCacheQuery(tb testing.TB, query []byte) cacheQuery {
+	tb.Helper()
+	cq, ok := newCacheQuery(query, []resolverAndDelay{{name: &dnstype.Resolver{Addr: "192.0.2.1"}}})
+	if !ok {
+		tb.Fatal("query not cacheable")
+	}
+	return cq
+}
+
+func TestRespCache(t *testing.T) {
+	const domain = "cache.example.com."
+	start := time.Now()
+
+	tests := []struct {
+		name    string
+		res     []byte
+		wantTTL time.Duration // or 0 if not cacheable
+	}{
+		{
+			name:    "positive",
+			res:     makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("192.0.2.10")),
+			wantTTL: 120 * time.Second,
+		},
+		{
+			name:    "nxdomain",
+			res:     makeNegativeTestResponse(t, domain, dns.RCodeNameError, 3600, 60),
+			wantTTL: 60 * time.Second,
+		},
+		{
+			name:    "nodata",
+			res:     makeNegativeTestResponse(t, domain, dns.RCodeSuccess, 30, 300),
+			wantTTL: 30 * time.Second,
+		},
+		{
+			name:    "nxdomain-capped",
+			res:     makeNegativeTestResponse(t, domain, dns.RCodeNameError, 86400, 86400),
+			wantTTL: maxNegativeCacheTTL,
+		},
+		{
+			name: "nxdomain-without-soa",
+			res:  makeNegativeTestResponse(t, domain, dns.RCodeNameError, 0, 0),
+		},
+		{
+			name: "servfail",
+			res:  makeNegativeTestResponse(t, domain, dns.RCodeServerFailure, 3600, 60),
+		},
+		{
+			name: "other-name",
+			res:  makeTestResponse(t, "other.example.com.", dns.RCodeSuccess, netip.MustParseAddr("192.0.2.10")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newRespCache()
+			query := makeTestRequest(t, domain, dns.TypeA, 0)
+			cq := mustCacheQuery(t, query)
+			c.put(cq.key, tt.res, start)
+
+			res, _ := c.get(cq, start.Add(time.Second))
+			if tt.wantTTL == 0 {
+				if res != nil {
+					t.Fatal("response was cached")
+				}
+				return
+			}
+			if res == nil {
+				t.Fatal("response wasn't cached")
+			}
+			if res, _ := c.get(cq, start.Add(tt.wantTTL-time.Second)); res == nil {
+				t.Error("response expired early")
+			}
+			if res, _ := c.get(cq, start.Add(tt.wantTTL)); res != nil {
+				t.Error("response didn't expire")
+			}
+		})
+	}
+}
+
+func TestRespCacheResponse(t *testing.T) {
+	const domain = "cache.example.com."
+	start := time.Now()
+	c := newRespCache()
+
+	stored := makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("192.0.2.10"))
+	cq := mustCacheQuery(t, makeTestRequest(t, domain, dns.TypeA, 0))
+	c.put(cq.key, stored, start)
+
+	// A later query for the same name, with a different ID and case.
+	query := makeTestRequest(t, "CACHE.example.com.", dns.TypeA, 0)
+	query[0], query[1] = 0x12, 0x34
+	cq2 := mustCacheQuery(t, query)
+	if cq2.key != cq.key {
+		t.Fatalf("keys differ: %+v, %+v", cq2.key, cq.key)
+	}
+	res, _ := c.get(cq2, start.Add(30*time.Second))
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 0x1234 {
+		t.Errorf("ID = %#x; want 0x1234", msg.ID)
+	}
+	if got := msg.Questions[0].Name.String(); got != "CACHE.example.com." {
+		t.Errorf("question name = %q; want %q", got, "CACHE.example.com.")
+	}
+	if len(msg.Answers) != 1 || msg.Answers[0].Header.TTL != 90 {
+		t.Errorf("answers = %+v; want one with TTL 90", msg.Answers)
+	}
+
+	// Queries for other types, or sent to other resolvers, miss.
+	if res, _ := c.get(mustCacheQuery(t, makeTestRequest(t, domain, dns.TypeAAAA, 0)), start); res != nil {
+		t.Error("AAAA query answered from A response")
+	}
+	other, _ := newCacheQuery(query, []resolverAndDelay{{name: &dnstype.Resolver{Addr: "192.0.2.2"}}})
+	if res, _ := c.get(other, start); res != nil {
+		t.Error("query to other resolver answered from cache")
+	}
+}
+
+func TestRespCachePrefetch(t *testing.T) {
+	const domain = "cache.example.com."
+	start := time.Now()
+	c := newRespCache()
+
+	res := makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("192.0.2.10"))
+	cq := mustCacheQuery(t, makeTestRequest(t, domain, dns.TypeA, 0))
+	c.put(cq.key, res, start)
+
+	// Popular, but not close to expiring.
+	for range prefetchMinHits {
+		if _, prefetch := c.get(cq, start.Add(time.Minute)); prefetch {
+			t.Fatal("prefetch requested early")
+		}
+	}
+	// Close to expiring: only one refresh is requested at a time.
+	late := start.Add(115 * time.Second)
+	if _, prefetch := c.get(cq, late); !prefetch {
+		t.Fatal("prefetch not requested")
+	}
+	if _, prefetch := c.get(cq, late); prefetch {
+		t.Fatal("prefetch requested twice")
+	}
+	c.prefetchDone(cq.key)
+	if _, prefetch := c.get(cq, late); !prefetch {
+		t.Fatal("prefetch not requested after previous one failed")
+	}
+
+	// A refresh replaces the entry.
+	c.put(cq.key, res, late)
+	if res, _ := c.get(cq, start.Add(200*time.Second)); res == nil {
+		t.Error("refreshed response expired")
+	}
+	if st, _ := c.getStats(); st.Prefetches != 2 {
+		t.Errorf("Prefetches = %d; want 2", st.Prefetches)
+	}
+}
+
+// TestForwarderNilNetMon verifies that a forwarder can be built and closed
+// without a netmon.Monitor, as New allows.
+func TestForwarderNilNetMon(t *testing.T) {
+	bus := eventbustest.NewBus(t)
+	var dialer tsdial.Dialer
+	dialer.SetBus(bus)
+	fwd := newForwarder(t.Logf, nil, nil, &dialer, health.NewTracker(bus), nil)
+	if err := fwd.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForwarderCache(t *testing.T) {
+	const domain = "cache.example.com."
+	response := makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("192.0.2.10"))
+	var requests atomic.Int32
+	port := runDNSServer(t, &testDNSServerOptions{SkipTCP: true}, response, func(isTCP bool, _ []byte) {
+		requests.Add(1)
+	})
+
+	logf := tstest.WhileTestRunningLogger(t)
+	bus := eventbustest.NewBus(t)
+	netMon, err := netmon.New(bus, logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	dialer.SetBus(bus)
+	knobs := new(controlknobs.Knobs)
+	fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), knobs)
+	defer fwd.Close()
+	resolvers := []resolverAndDelay{{name: &dnstype.Resolver{Addr: fmt.Sprintf("127.0.0.1:%d", port)}}}
+
+	query := func() {
+		t.Helper()
+		ch := make(chan packet, 1)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		q := packet{makeTestRequest(t, domain, dns.TypeA, 0), "udp", netip.MustParseAddrPort("127.0.0.1:12345")}
+		if err := fwd.forwardWithDestChan(ctx, q, ch, resolvers...); err != nil {
+			t.Fatal(err)
+		}
+		res := <-ch
+		if len(res.bs) == 0 || getRCode(res.bs) != dns.RCodeSuccess {
+			t.Fatalf("bad response %x", res.bs)
+		}
+	}
+
+	query()
+	query()
+	if got := requests.Load(); got != 2 {
+		t.Fatalf("with cache disabled, upstream got %d requests; want 2", got)
+	}
+
+	knobs.DNSForwarderCache.Store(true)
+	query()
+	query()
+	query()
+	if got := requests.Load(); got != 3 {
+		t.Fatalf("with cache enabled, upstream got %d requests; want 3", got)
+	}
+	if st, n := fwd.cache.getStats(); st.Hits != 2 || n != 1 {
+		t.Errorf("cache has %d entries and stats %+v; want 1 entry and 2 hits", n, st)
+	}
+}
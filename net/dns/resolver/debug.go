@@ -33,6 +33,7 @@ func init() {
 			fwdLogAtomic.Store(fl)
 		}
 		fl.ServeHTTP(w, r)
+		writeDebugCacheStats(w)
 	}))
 }
 
@@ -0,0 +1,494 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/net/dnscache"
+	"tailscale.com/net/netx"
+	"tailscale.com/net/sockstats"
+	"tailscale.com/syncs"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
+)
+
+const (
+	// dotIdleConnTimeout is how long to keep an idle connection to a
+	// DNS-over-TLS server open. See dohIdleConnTimeout for why it's short.
+	dotIdleConnTimeout = 10 * time.Second
+
+	// dotMaxPipelined is the maximum number of queries in flight at once
+	// on a single DNS-over-TLS connection.
+	dotMaxPipelined = 256
+
+	// dotDefaultPort is the port DNS-over-TLS servers listen on, per RFC 7858.
+	dotDefaultPort = "853"
+)
+
+// errDoTConnBroken is returned for queries that were in flight, or about to
+// be sent, on a DNS-over-TLS connection that failed or was closed.
+var errDoTConnBroken = errors.New("DNS-over-TLS connection closed")
+
+// parseDoTAddr parses a DNS-over-TLS resolver address of the form
+// "tls://host" or "tls://host:port", where host is a hostname or an IP
+// address (in square brackets, if IPv6 and a port is given).
+func parseDoTAddr(addr string) (host, port string, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "tls" || u.Host == "" || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", "", fmt.Errorf("invalid DNS-over-TLS resolver %q; want tls://host[:port]", addr)
+	}
+	host, port = u.Hostname(), u.Port()
+	if port == "" {
+		port = dotDefaultPort
+	}
+	return host, port, nil
+}
+
+// dotClient sends queries to a DNS-over-TLS (RFC 7858) server. It keeps a
+// single connection to it open while there are queries to send, and pipelines
+// queries over it (RFC 7766, section 6.2.1.1).
+type dotClient struct {
+	logf      logger.Logf
+	host      string // hostname or IP address, and the name the server's certificate is verified for
+	port      string
+	bootstrap []netip.Addr // from the resolver's BootstrapResolution
+	dial      netx.DialFunc
+	tlsConfig *tls.Config
+
+	mu       syncs.Mutex
+	conn     *dotConn      // or nil if none is usable
+	dialDone chan struct{} // non-nil while a connection is being dialed
+	closed   bool
+}
+
+// newDoTClient returns a client for the DNS-over-TLS resolver r, which must
+// have a tls:// address. Connections are made with dial.
+func newDoTClient(logf logger.Logf, r *dnstype.Resolver, dial netx.DialFunc) (*dotClient, error) {
+	host, port, err := parseDoTAddr(r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &dotClient{
+		logf:      logf,
+		host:      host,
+		port:      port,
+		bootstrap: slices.Clone(r.BootstrapResolution),
+		dial:      dial,
+		tlsConfig: &tls.Config{
+			// For IP addresses, this verifies the server's certificate
+			// is valid for that address, without sending it as SNI.
+			ServerName: host,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	if _, err := netip.ParseAddr(host); err != nil {
+		// A hostname: resolve it using the resolver's bootstrap addresses,
+		// if any, or else the system resolver.
+		dnsRes := &dnscache.Resolver{
+			UseLastGood: true,
+			Logf:        logf,
+		}
+		if len(c.bootstrap) > 0 {
+			dnsRes.SingleHost = host
+			dnsRes.SingleHostStaticResult = c.bootstrap
+		}
+		c.dial = dnscache.Dialer(dial, dnsRes)
+	}
+	return c, nil
+}
+
+// matches reports whether c was created for r.
+func (c *dotClient) matches(r *dnstype.Resolver) bool {
+	return slices.Equal(c.bootstrap, r.BootstrapResolution)
+}
+
+// exchange sends the DNS query packet and returns the response.
+//
+// The response has the same ID as the query; IDs are rewritten on the wire
+// so queries from different clients don't collide.
+func (c *dotClient) exchange(ctx context.Context, packet []byte) ([]byte, error) {
+	if len(packet) < headerBytes || len(packet) > 0xffff {
+		return nil, fmt.Errorf("invalid DNS query of %d bytes", len(packet))
+	}
+	for attempt := 0; ; attempt++ {
+		dc, reused, err := c.getConn(ctx)
+		if err != nil {
+			metricDNSFwdDoTErrorDial.Add(1)
+			return nil, err
+		}
+		if reused {
+			metricDNSFwdDoTReused.Add(1)
+		}
+		res, err := dc.exchange(ctx, packet)
+		if errors.Is(err, errDoTConnBroken) && reused && attempt == 0 {
+			// The server may have closed the connection while it was
+			// idle, before it saw our query. Try once more on a new one.
+			continue
+		}
+		return res, err
+	}
+}
+
+// getConn returns an open connection to the server, dialing one if needed.
+// It reports whether the connection was already open.
+func (c *dotClient) getConn(ctx context.Context) (dc *dotConn, reused bool, err error) {
+	c.mu.Lock()
+	for {
+		if c.closed {
+			c.mu.Unlock()
+			return nil, false, net.ErrClosed
+		}
+		if c.conn != nil && c.conn.usable() {
+			dc := c.conn
+			c.mu.Unlock()
+			return dc, true, nil
+		}
+		if c.dialDone == nil {
+			break
+		}
+		// Wait for the dial in progress, so that concurrent queries share
+		// its connection.
+		ch := c.dialDone
+		c.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		c.mu.Lock()
+	}
+	ch := make(chan struct{})
+	c.dialDone = ch
+	c.mu.Unlock()
+
+	dc, err = c.dialConn(ctx)
+
+	c.mu.Lock()
+	c.dialDone = nil
+	close(ch)
+	closed := c.closed
+	if err == nil && !closed {
+		c.conn = dc
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, false, err
+	}
+	if closed {
+		dc.close(net.ErrClosed)
+		return nil, false, net.ErrClosed
+	}
+	return dc, false, nil
+}
+
+func (c *dotClient) dialConn(ctx context.Context) (*dotConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, tcpQueryTimeout)
+	defer cancel()
+	nc, err := c.dial(ctx, "tcp", net.JoinHostPort(c.host, c.port))
+	if err != nil {
+		return nil, err
+	}
+	tc := tls.Client(nc, c.tlsConfig)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	dc := &dotConn{
+		client:  c,
+		conn:    tc,
+		pending: map[uint16]chan []byte{},
+	}
+	dc.idle = time.AfterFunc(dotIdleConnTimeout, dc.closeIfIdle)
+	go dc.readLoop()
+	return dc, nil
+}
+
+// Close closes c's connection, failing the queries in flight on it.
+func (c *dotClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	dc := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if dc != nil {
+		dc.close(net.ErrClosed)
+	}
+	return nil
+}
+
+// dotConn is a connection to a DNS-over-TLS server, on which queries are
+// pipelined.
+type dotConn struct {
+	client *dotClient
+	conn   net.Conn
+	idle   *time.Timer // closes the connection once idle for dotIdleConnTimeout
+
+	wmu sync.Mutex // serializes writes to conn
+
+	mu      syncs.Mutex
+	pending map[uint16]chan []byte // by query ID on the wire
+	nextID  uint16
+	err     error // non-nil once the connection is closed
+}
+
+// usable reports whether dc can take another query.
+func (dc *dotConn) usable() bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.err == nil && len(dc.pending) < dotMaxPipelined
+}
+
+func (dc *dotConn) exchange(ctx context.Context, packet []byte) ([]byte, error) {
+	resc := make(chan []byte, 1)
+	dc.mu.Lock()
+	if dc.err != nil {
+		dc.mu.Unlock()
+		return nil, errDoTConnBroken
+	}
+	if len(dc.pending) >= dotMaxPipelined {
+		dc.mu.Unlock()
+		return nil, errors.New("too many DNS-over-TLS queries in flight")
+	}
+	id := dc.nextID
+	for {
+		if _, ok := dc.pending[id]; !ok {
+			break
+		}
+		id++
+	}
+	dc.nextID = id + 1
+	dc.pending[id] = resc
+	dc.idle.Stop()
+	dc.mu.Unlock()
+
+	defer func() {
+		dc.mu.Lock()
+		defer dc.mu.Unlock()
+		delete(dc.pending, id)
+		if dc.err == nil && len(dc.pending) == 0 {
+			dc.idle.Reset(dotIdleConnTimeout)
+		}
+	}()
+
+	msg := make([]byte, 2+len(packet))
+	binary.BigEndian.PutUint16(msg, uint16(len(packet)))
+	copy(msg[2:], packet)
+	binary.BigEndian.PutUint16(msg[2:], id)
+
+	dc.wmu.Lock()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(tcpQueryTimeout)
+	}
+	dc.conn.SetWriteDeadline(deadline)
+	_, err := dc.conn.Write(msg)
+	dc.wmu.Unlock()
+	if err != nil {
+		// A partial write leaves the connection unusable for the
+		// other queries, too.
+		metricDNSFwdDoTErrorWrite.Add(1)
+		dc.close(err)
+		return nil, fmt.Errorf("%w: %w", errDoTConnBroken, err)
+	}
+
+	select {
+	case res, ok := <-resc:
+		if !ok {
+			return nil, errDoTConnBroken
+		}
+		copy(res, packet[:2]) // restore the query's ID
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readLoop reads responses from dc and hands them to the queries waiting
+// for them, until the connection fails or is closed.
+func (dc *dotConn) readLoop() {
+	var lenBuf [2]byte
+	for {
+		if _, err := io.ReadFull(dc.conn, lenBuf[:]); err != nil {
+			dc.readFailed(err)
+			return
+		}
+		res := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(dc.conn, res); err != nil {
+			dc.readFailed(err)
+			return
+		}
+		if len(res) < headerBytes {
+			dc.readFailed(fmt.Errorf("short response of %d bytes", len(res)))
+			return
+		}
+		id := binary.BigEndian.Uint16(res)
+		dc.mu.Lock()
+		resc, ok := dc.pending[id]
+		delete(dc.pending, id)
+		dc.mu.Unlock()
+		if ok {
+			resc <- res
+		}
+		// Otherwise, the query was abandoned; drop its response.
+	}
+}
+
+func (dc *dotConn) readFailed(err error) {
+	dc.mu.Lock()
+	closing := dc.err != nil
+	dc.mu.Unlock()
+	if !closing {
+		metricDNSFwdDoTErrorRead.Add(1)
+	}
+	dc.close(err)
+}
+
+// closeIfIdle closes dc if there are no queries in flight on it.
+func (dc *dotConn) closeIfIdle() {
+	dc.mu.Lock()
+	idle := len(dc.pending) == 0
+	dc.mu.Unlock()
+	if idle {
+		dc.close(errors.New("idle"))
+	}
+}
+
+// close closes dc because of err, failing the queries in flight on it.
+// Only the first call has any effect.
+func (dc *dotConn) close(err error) {
+	dc.mu.Lock()
+	if dc.err != nil {
+		dc.mu.Unlock()
+		return
+	}
+	dc.err = err
+	for id, resc := range dc.pending {
+		close(resc)
+		delete(dc.pending, id)
+	}
+	dc.idle.Stop()
+	dc.mu.Unlock()
+
+	dc.conn.Close()
+
+	c := dc.client
+	c.mu.Lock()
+	if c.conn == dc {
+		c.conn = nil
+	}
+	c.mu.Unlock()
+}
+
+// getDoTClient returns a client for the DNS-over-TLS resolver r.
+//
+// Clients for resolvers in the current routes are cached, so that queries
+// share their connections. For other resolvers, such as those given to
+// QueryUpstream for one-off queries, it returns a new client that the caller
+// must close when done with it, as reported by cached being false.
+func (f *forwarder) getDoTClient(r *dnstype.Resolver) (c *dotClient, cached bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.dotClient[r.Addr]; ok {
+		if c.matches(r) {
+			return c, true, nil
+		}
+		c.Close()
+		delete(f.dotClient, r.Addr)
+	}
+	// The dialer is looked up for each connection, as the control knobs
+	// it depends on can change.
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return f.getDialerType()(ctx, network, addr)
+	}
+	c, err = newDoTClient(f.logf, r, dial)
+	if err != nil {
+		return nil, false, err
+	}
+	if !routesHaveResolver(f.routes, r.Addr) {
+		return c, false, nil
+	}
+	mak.Set(&f.dotClient, r.Addr, c)
+	return c, true, nil
+}
+
+// pruneDoTClientsLocked closes and forgets the cached DNS-over-TLS clients
+// whose resolvers are no longer in f.routes.
+//
+// f.mu must be held.
+func (f *forwarder) pruneDoTClientsLocked() {
+	for addr, c := range f.dotClient {
+		if !routesHaveResolver(f.routes, addr) {
+			c.Close()
+			delete(f.dotClient, addr)
+		}
+	}
+}
+
+// routesHaveResolver reports whether any of routes uses the resolver with
+// address addr.
+func routesHaveResolver(routes []route, addr string) bool {
+	for _, rt := range routes {
+		for _, rr := range rt.Resolvers {
+			if rr.name.Addr == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *forwarder) sendDoT(ctx context.Context, fq *forwardQuery, rr resolverAndDelay) ([]byte, error) {
+	c, cached, err := f.getDoTClient(rr.name)
+	if err != nil {
+		metricDNSFwdErrorType.Add(1)
+		return nil, err
+	}
+	if !cached {
+		defer c.Close()
+	}
+	ctx = sockstats.WithSockStats(ctx, sockstats.LabelDNSForwarderDoT, f.logf)
+	metricDNSFwdDoT.Add(1)
+
+	ctx, cancel := context.WithTimeout(ctx, tcpQueryTimeout)
+	defer cancel()
+	res, err := c.exchange(ctx, fq.packet)
+	if err != nil {
+		return nil, err
+	}
+	if getTxID(res) != fq.txid {
+		metricDNSFwdDoTErrorTxID.Add(1)
+		return nil, errTxIDMismatch
+	}
+	// Don't forward transient errors back to the client when the server
+	// fails.
+	if rcode := getRCode(res); rcode == dns.RCodeServerFailure {
+		f.logf("sendDoT: response code indicating server failure: %d", rcode)
+		metricDNSFwdDoTErrorServer.Add(1)
+		return nil, errServerFailure
+	}
+	if truncatedFlagSet(res) {
+		metricDNSFwdTruncated.Add(1)
+	}
+	metricDNSFwdDoTSuccess.Add(1)
+	// Check response size and set TC flag if needed (only for UDP queries)
+	return checkResponseSizeAndSetTC(res, fq.packet, fq.family, f.logf), nil
+}
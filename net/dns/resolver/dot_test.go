@@ -0,0 +1,388 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/health"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/tstest"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/eventbus/eventbustest"
+)
+
+func TestParseDoTAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		host    string
+		port    string
+		wantErr bool
+	}{
+		{addr: "tls://1.1.1.1", host: "1.1.1.1", port: "853"},
+		{addr: "tls://1.1.1.1:8853", host: "1.1.1.1", port: "8853"},
+		{addr: "tls://[2606:4700:4700::1111]", host: "2606:4700:4700::1111", port: "853"},
+		{addr: "tls://[2606:4700:4700::1111]:853", host: "2606:4700:4700::1111", port: "853"},
+		{addr: "tls://dns.example.com/", host: "dns.example.com", port: "853"},
+		{addr: "tls://", wantErr: true},
+		{addr: "tls://dns.example.com/path", wantErr: true},
+		{addr: "tls://user@dns.example.com", wantErr: true},
+		{addr: "https://dns.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		host, port, err := parseDoTAddr(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDoTAddr(%q) error = %v; want error: %v", tt.addr, err, tt.wantErr)
+			continue
+		}
+		if host != tt.host || port != tt.port {
+			t.Errorf("parseDoTAddr(%q) = %q, %q; want %q, %q", tt.addr, host, port, tt.host, tt.port)
+		}
+	}
+}
+
+// testDoTServer is a DNS-over-TLS server that echoes queries back as
+// responses.
+type testDoTServer struct {
+	port    uint16
+	roots   *x509.CertPool
+	accepts atomic.Int32
+	queries chan []byte // each query, as received
+
+	mu          sync.Mutex
+	serverNames []string // SNI of each connection
+	conns       []net.Conn
+
+	// hold, if non-nil, is waited on before each response is written.
+	// Responses are written from separate goroutines, so they may be
+	// reordered.
+	hold chan struct{}
+}
+
+func newTestDoTServer(t *testing.T) *testDoTServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns.example.test"},
+		DNSNames:              []string{"dns.example.test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testDoTServer{
+		roots:   x509.NewCertPool(),
+		queries: make(chan []byte, 100),
+	}
+	s.roots.AddCert(cert)
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.serverNames = append(s.serverNames, hello.ServerName)
+			return nil, nil
+		},
+	}
+	ln, err := tls.Listen("tcp4", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s.port = uint16(ln.Addr().(*net.TCPAddr).Port)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.accepts.Add(1)
+			s.mu.Lock()
+			s.conns = append(s.conns, c)
+			s.mu.Unlock()
+			go s.serveConn(c)
+		}
+	}()
+	t.Cleanup(s.closeConns)
+	return s
+}
+
+func (s *testDoTServer) serveConn(c net.Conn) {
+	defer c.Close()
+	var wmu sync.Mutex
+	for {
+		var n uint16
+		if err := binary.Read(c, binary.BigEndian, &n); err != nil {
+			return
+		}
+		msg := make([]byte, 2+int(n))
+		if _, err := io.ReadFull(c, msg[2:]); err != nil {
+			return
+		}
+		s.queries <- msg[2:]
+		binary.BigEndian.PutUint16(msg, n)
+		msg[4] |= 0x80 // QR: response
+		go func() {
+			if s.hold != nil {
+				<-s.hold
+			}
+			wmu.Lock()
+			defer wmu.Unlock()
+			c.Write(msg)
+		}()
+	}
+}
+
+// closeConns closes the server's side of all connections.
+func (s *testDoTServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testDoTServer) newClient(t *testing.T, r *dnstype.Resolver) *dotClient {
+	var d net.Dialer
+	c, err := newDoTClient(t.Logf, r, d.DialContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.tlsConfig.RootCAs = s.roots
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestDoTClientPipelining(t *testing.T) {
+	s := newTestDoTServer(t)
+	s.hold = make(chan struct{})
+	c := s.newClient(t, &dnstype.Resolver{Addr: fmt.Sprintf("tls://127.0.0.1:%d", s.port)})
+
+	// Queries from different clients may use the same ID.
+	const n = 8
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	errc := make(chan error, n)
+	for i := range n {
+		domain := fmt.Sprintf("host%d.example.com.", i)
+		query := makeTestRequest(t, domain, dns.TypeA, 0)
+		query[0], query[1] = 0x12, 0x34
+		wg.Go(func() {
+			res, err := c.exchange(ctx, query)
+			if err != nil {
+				errc <- err
+				return
+			}
+			if getTxID(res) != getTxID(query) {
+				errc <- fmt.Errorf("response for %s doesn't match query", domain)
+			}
+		})
+	}
+	seen := map[uint16]bool{}
+	for range n {
+		q := <-s.queries
+		id := binary.BigEndian.Uint16(q)
+		if seen[id] {
+			t.Errorf("ID %#x used twice on the connection", id)
+		}
+		seen[id] = true
+	}
+	close(s.hold)
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+	if got := s.accepts.Load(); got != 1 {
+		t.Errorf("server accepted %d connections; want 1", got)
+	}
+}
+
+func TestDoTClientReconnect(t *testing.T) {
+	s := newTestDoTServer(t)
+	c := s.newClient(t, &dnstype.Resolver{Addr: fmt.Sprintf("tls://127.0.0.1:%d", s.port)})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	query := makeTestRequest(t, "example.com.", dns.TypeA, 0)
+
+	if _, err := c.exchange(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.exchange(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.accepts.Load(); got != 1 {
+		t.Fatalf("server accepted %d connections; want 1", got)
+	}
+
+	// The server closing the connection between queries isn't an error.
+	s.closeConns()
+	if _, err := c.exchange(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.accepts.Load(); got != 2 {
+		t.Fatalf("server accepted %d connections; want 2", got)
+	}
+}
+
+func TestDoTClientServerName(t *testing.T) {
+	s := newTestDoTServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	query := makeTestRequest(t, "example.com.", dns.TypeA, 0)
+	bootstrap := []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+
+	c := s.newClient(t, &dnstype.Resolver{
+		Addr:                fmt.Sprintf("tls://dns.example.test:%d", s.port),
+		BootstrapResolution: bootstrap,
+	})
+	if _, err := c.exchange(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	names := s.serverNames
+	s.mu.Unlock()
+	if len(names) != 1 || names[0] != "dns.example.test" {
+		t.Errorf("server names = %q; want [dns.example.test]", names)
+	}
+
+	// A certificate for another name is rejected.
+	c = s.newClient(t, &dnstype.Resolver{
+		Addr:                fmt.Sprintf("tls://other.example.test:%d", s.port),
+		BootstrapResolution: bootstrap,
+	})
+	if _, err := c.exchange(ctx, query); err == nil {
+		t.Error("query succeeded despite certificate for the wrong name")
+	}
+}
+
+func TestForwarderDoT(t *testing.T) {
+	s := newTestDoTServer(t)
+
+	logf := tstest.WhileTestRunningLogger(t)
+	bus := eventbustest.NewBus(t)
+	netMon, err := netmon.New(bus, logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	dialer.SetBus(bus)
+	fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), nil)
+	defer fwd.Close()
+
+	r := &dnstype.Resolver{Addr: fmt.Sprintf("tls://127.0.0.1:%d", s.port)}
+	fwd.setRoutes(map[dnsname.FQDN][]*dnstype.Resolver{"example.com.": {r}}, true)
+	c, cached, err := fwd.getDoTClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached {
+		t.Fatal("client for routed resolver not cached")
+	}
+	c.tlsConfig.RootCAs = s.roots
+
+	ch := make(chan packet, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	query := makeTestRequest(t, "example.com.", dns.TypeA, 0)
+	q := packet{query, "udp", netip.MustParseAddrPort("127.0.0.1:12345")}
+	if err := fwd.forwardWithDestChan(ctx, q, ch, resolverAndDelay{name: r}); err != nil {
+		t.Fatal(err)
+	}
+	res := <-ch
+	if getTxID(res.bs) != getTxID(query) {
+		t.Errorf("bad response %x", res.bs)
+	}
+}
+
+func TestForwarderDoTClientCache(t *testing.T) {
+	logf := tstest.WhileTestRunningLogger(t)
+	bus := eventbustest.NewBus(t)
+	netMon, err := netmon.New(bus, logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	dialer.SetBus(bus)
+	fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), nil)
+	defer fwd.Close()
+
+	routed := &dnstype.Resolver{Addr: "tls://192.0.2.1"}
+	oneOff := &dnstype.Resolver{Addr: "tls://192.0.2.2"}
+	fwd.setRoutes(map[dnsname.FQDN][]*dnstype.Resolver{"example.com.": {routed}}, true)
+
+	c1, cached, err := fwd.getDoTClient(routed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached {
+		t.Error("client for routed resolver not cached")
+	}
+	c2, _, err := fwd.getDoTClient(routed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Error("client for routed resolver not reused")
+	}
+
+	c3, cached, err := fwd.getDoTClient(oneOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c3.Close()
+	if cached {
+		t.Error("client for one-off resolver cached")
+	}
+	if _, ok := fwd.dotClient[oneOff.Addr]; ok {
+		t.Error("client for one-off resolver in cache")
+	}
+
+	// Removing the route closes and forgets its client.
+	fwd.setRoutes(map[dnsname.FQDN][]*dnstype.Resolver{"example.com.": {oneOff}}, true)
+	if _, ok := fwd.dotClient[routed.Addr]; ok {
+		t.Error("client for removed resolver still cached")
+	}
+	if _, _, err := c1.getConn(context.Background()); err != net.ErrClosed {
+		t.Errorf("getConn on pruned client = %v; want %v", err, net.ErrClosed)
+	}
+}
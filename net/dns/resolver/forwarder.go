@@ -295,7 +295,7 @@ type resolverAndDelay struct {
 // forwarder forwards DNS packets to a number of upstream nameservers.
 type forwarder struct {
 	logf       logger.Logf
-	netMon     *netmon.Monitor     // always non-nil
+	netMon     *netmon.Monitor     // or nil
 	linkSel    ForwardLinkSelector // TODO(bradfitz): remove this when tsdial.Dialer absorbs it
 	dialer     *tsdial.Dialer
 	health     *health.Tracker // always non-nil
@@ -306,9 +306,14 @@ type forwarder struct {
 	ctx       context.Context    // good until Close
 	ctxCancel context.CancelFunc // closes ctx
 
+	// cache caches responses when enabled by cacheEnabled.
+	cache           *respCache
+	unregLinkChange func() // or nil
+
 	mu syncs.Mutex // guards following
 
 	dohClient map[string]*http.Client // urlBase -> client
+	dotClient map[string]*dotClient   // tls:// resolver address -> client
 
 	// routes are per-suffix resolvers to use, with
 	// the most specific routes first.
@@ -335,9 +340,6 @@ func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkS
 	if !buildfeatures.HasDNS {
 		return nil
 	}
-	if netMon == nil {
-		panic("nil netMon")
-	}
 	f := &forwarder{
 		logf:         logger.WithPrefix(logf, "forward: "),
 		netMon:       netMon,
@@ -346,13 +348,33 @@ func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkS
 		health:       health,
 		controlKnobs: knobs,
 		verboseFwd:   verboseDNSForward(),
+		cache:        newRespCache(),
 	}
 	f.ctx, f.ctxCancel = context.WithCancel(context.Background())
+	if netMon != nil {
+		f.unregLinkChange = netMon.RegisterChangeCallback(func(delta *netmon.ChangeDelta) {
+			// Responses may depend on the network we're on, even when the
+			// upstream resolvers' addresses stay the same.
+			if delta.DefaultInterfaceChanged {
+				f.cache.flush()
+			}
+		})
+	}
+	registerDebugCache(f.cache)
 	return f
 }
 
 func (f *forwarder) Close() error {
 	f.ctxCancel()
+	if f.unregLinkChange != nil {
+		f.unregLinkChange()
+	}
+	unregisterDebugCache(f.cache)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.dotClient {
+		c.Close()
+	}
 	return nil
 }
 
@@ -472,17 +494,22 @@ func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolve
 		return routes[i].Suffix.NumLabels() > routes[j].Suffix.NumLabels()
 	})
 
+	if !f.cacheEnabled() {
+		f.cache.flush()
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	f.acceptDNS = acceptDNS
 	f.routes = routes
 	f.cloudHostFallback = cloudHostFallback
+	f.pruneDoTClientsLocked()
 }
 
 var stdNetPacketListener nettype.PacketListenerWithNetIP = nettype.MakePacketListenerWithNetIP(new(net.ListenConfig))
 
 func (f *forwarder) packetListener(ip netip.Addr) (nettype.PacketListenerWithNetIP, error) {
-	if f.linkSel == nil || initListenConfig == nil {
+	if f.linkSel == nil || initListenConfig == nil || f.netMon == nil {
 		return stdNetPacketListener, nil
 	}
 	linkName := f.linkSel.PickLink(ip)
@@ -594,6 +621,7 @@ func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client,
 var (
 	verboseDNSForward = envknob.RegisterBool("TS_DEBUG_DNS_FORWARD_SEND")
 	skipTCPRetry      = envknob.RegisterBool("TS_DNS_FORWARD_SKIP_TCP_RETRY")
+	optForwardCache   = envknob.RegisterOptBool("TS_DNS_FORWARD_CACHE")
 
 	// For correlating log messages in the send() function; only used when
 	// verboseDNSForward() is true.
@@ -645,8 +673,7 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 		return nil, fmt.Errorf("arbitrary https:// resolvers not supported yet")
 	}
 	if strings.HasPrefix(rr.name.Addr, "tls://") {
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("tls:// resolvers not supported yet")
+		return f.sendDoT(ctx, fq, rr)
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -871,6 +898,44 @@ func ShouldUseRoutes(knobs *controlknobs.Knobs) bool {
 	}
 }
 
+// cacheEnabled reports whether responses from upstream resolvers should be
+// cached. It's off by default, and can be turned on with a node attribute or
+// either way with the TS_DNS_FORWARD_CACHE envknob.
+func (f *forwarder) cacheEnabled() bool {
+	if v, ok := optForwardCache().Get(); ok {
+		return v
+	}
+	return f.controlKnobs != nil && f.controlKnobs.DNSForwarderCache.Load()
+}
+
+// prefetch refreshes the cached response to cq, the query in query, by
+// forwarding it to resolvers in the background.
+func (f *forwarder) prefetch(cq cacheQuery, query packet, resolvers []resolverAndDelay) {
+	fq := &forwardQuery{
+		txid:           getTxID(query.bs),
+		packet:         bytes.Clone(query.bs),
+		family:         query.family,
+		src:            query.addr,
+		closeOnCtxDone: new(closePool),
+	}
+	go func() {
+		defer f.cache.prefetchDone(cq.key)
+		defer fq.closeOnCtxDone.Close()
+		ctx, cancel := context.WithTimeout(f.ctx, dnsQueryTimeout)
+		defer cancel()
+		for _, rr := range resolvers {
+			res, err := f.send(ctx, fq, rr)
+			if err == nil {
+				f.cache.put(cq.key, res, time.Now())
+				return
+			}
+			if f.verboseFwd {
+				f.logf("prefetch(%d) using %q: %v", fq.txid, rr.name.Addr, err)
+			}
+		}
+	}()
+}
+
 func (f *forwarder) getDialerType() netx.DialFunc {
 	if ShouldUseRoutes(f.controlKnobs) {
 		return f.dialer.UserDial
@@ -1083,6 +1148,30 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 		}
 	}
 
+	var cq cacheQuery
+	cacheable := false
+	if f.cacheEnabled() {
+		cq, cacheable = newCacheQuery(query.bs, resolvers)
+	}
+	if cacheable {
+		if res, prefetch := f.cache.get(cq, time.Now()); res != nil {
+			if prefetch {
+				f.prefetch(cq, query, resolvers)
+			}
+			res = checkResponseSizeAndSetTC(res, query.bs, query.family, f.logf)
+			select {
+			case <-ctx.Done():
+				metricDNSFwdErrorContext.Add(1)
+				return fmt.Errorf("waiting to send cached response: %w", ctx.Err())
+			case responseChan <- packet{res, query.family, query.addr}:
+				if f.verboseFwd {
+					f.logf("cached response(%d, %v, %d) = %d", getTxID(query.bs), typ, len(domain), len(res))
+				}
+				return nil
+			}
+		}
+	}
+
 	fq := &forwardQuery{
 		txid:           getTxID(query.bs),
 		packet:         query.bs,
@@ -1132,6 +1221,9 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	for {
 		select {
 		case v := <-resc:
+			if cacheable {
+				f.cache.put(cq.key, v, time.Now())
+			}
 			select {
 			case <-ctx.Done():
 				metricDNSFwdErrorContext.Add(1)
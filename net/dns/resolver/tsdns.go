@@ -348,6 +348,30 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 	return out, nil
 }
 
+// QueryUpstream forwards the query bs to upstream, rather than to the
+// resolvers configured for its name. It's for testing upstream resolvers,
+// and so doesn't answer queries for MagicDNS names itself.
+func (r *Resolver) QueryUpstream(ctx context.Context, bs []byte, family string, from netip.AddrPort, upstream *dnstype.Resolver) ([]byte, error) {
+	if !buildfeatures.HasDNS {
+		return nil, feature.ErrUnavailable
+	}
+	select {
+	case <-r.closed:
+		metricDNSQueryErrorClosed.Add(1)
+		return nil, net.ErrClosed
+	default:
+	}
+
+	responses := make(chan packet, 1)
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer close(responses)
+	defer cancel()
+	if err := r.forwarder.forwardWithDestChan(ctx, packet{bs, family, from}, responses, resolverAndDelay{name: upstream}); err != nil {
+		return nil, err
+	}
+	return (<-responses).bs, nil
+}
+
 // GetUpstreamResolvers returns the resolvers that would be used to resolve
 // the given FQDN.
 func (r *Resolver) GetUpstreamResolvers(name dnsname.FQDN) []*dnstype.Resolver {
@@ -1402,6 +1426,10 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdErrorType = clientmetric.NewCounter("dns_query_fwd_error_type")
 	metricDNSFwdTruncated = clientmetric.NewCounter("dns_query_fwd_truncated")
 
+	metricDNSFwdCacheHit      = clientmetric.NewCounter("dns_query_fwd_cache_hit")
+	metricDNSFwdCacheMiss     = clientmetric.NewCounter("dns_query_fwd_cache_miss")
+	metricDNSFwdCachePrefetch = clientmetric.NewCounter("dns_query_fwd_cache_prefetch")
+
 	metricDNSFwdUDP            = clientmetric.NewCounter("dns_query_fwd_udp")       // on entry
 	metricDNSFwdUDPWrote       = clientmetric.NewCounter("dns_query_fwd_udp_wrote") // sent UDP packet
 	metricDNSFwdUDPErrorWrite  = clientmetric.NewCounter("dns_query_fwd_udp_error_write")
@@ -1424,6 +1452,15 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdDoHErrorTransport = clientmetric.NewCounter("dns_query_fwd_doh_error_transport")
 	metricDNSFwdDoHErrorBody      = clientmetric.NewCounter("dns_query_fwd_doh_error_body")
 
+	metricDNSFwdDoT            = clientmetric.NewCounter("dns_query_fwd_dot")        // on entry
+	metricDNSFwdDoTReused      = clientmetric.NewCounter("dns_query_fwd_dot_reused") // sent on an already open connection
+	metricDNSFwdDoTErrorDial   = clientmetric.NewCounter("dns_query_fwd_dot_error_dial")
+	metricDNSFwdDoTErrorWrite  = clientmetric.NewCounter("dns_query_fwd_dot_error_write")
+	metricDNSFwdDoTErrorRead   = clientmetric.NewCounter("dns_query_fwd_dot_error_read")
+	metricDNSFwdDoTErrorServer = clientmetric.NewCounter("dns_query_fwd_dot_error_server")
+	metricDNSFwdDoTErrorTxID   = clientmetric.NewCounter("dns_query_fwd_dot_error_txid")
+	metricDNSFwdDoTSuccess     = clientmetric.NewCounter("dns_query_fwd_dot_success")
+
 	metricDNSResolveLocal             = clientmetric.NewCounter("dns_resolve_local")
 	metricDNSResolveLocalErrorOnion   = clientmetric.NewCounter("dns_resolve_local_error_onion")
 	metricDNSResolveLocalErrorMissing = clientmetric.NewCounter("dns_resolve_local_error_missing")
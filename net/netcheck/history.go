@@ -0,0 +1,98 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"slices"
+	"strings"
+
+	"tailscale.com/net/netcheck/netchecktype"
+	"tailscale.com/net/netmon"
+)
+
+// Summary returns the part of r that's kept in a Client's history.
+func (r *Report) Summary() netchecktype.Summary {
+	s := netchecktype.Summary{
+		UDP:           r.UDP,
+		IPv4:          r.IPv4,
+		IPv6:          r.IPv6,
+		GlobalV4:      r.GlobalV4.Addr(),
+		GlobalV6:      r.GlobalV6.Addr(),
+		PreferredDERP: r.PreferredDERP,
+		CaptivePortal: r.CaptivePortal,
+	}
+	if v, ok := r.MappingVariesByDestIP.Get(); ok {
+		s.NAT = "easy"
+		if v {
+			s.NAT = "hard"
+		}
+	}
+	if r.AnyPortMappingChecked() {
+		var got []string
+		if r.UPnP.EqualBool(true) {
+			got = append(got, "UPnP")
+		}
+		if r.PMP.EqualBool(true) {
+			got = append(got, "NAT-PMP")
+		}
+		if r.PCP.EqualBool(true) {
+			got = append(got, "PCP")
+		}
+		s.PortMapping = "none"
+		if len(got) > 0 {
+			s.PortMapping = strings.Join(got, ", ")
+		}
+	}
+	return s
+}
+
+// networkSummary returns a summary of st for a history entry.
+func networkSummary(st *netmon.State) netchecktype.Network {
+	if st == nil {
+		return netchecktype.Network{}
+	}
+	return netchecktype.Network{
+		DefaultRoute: st.DefaultRouteInterface,
+		Addrs:        slices.Clone(st.InterfaceIPs[st.DefaultRouteInterface]),
+		HaveV4:       st.HaveV4,
+		HaveV6:       st.HaveV6,
+		Expensive:    st.IsExpensive,
+	}
+}
+
+// addHistoryLocked records r in c's history, if c keeps one. c.mu must be
+// held.
+func (c *Client) addHistoryLocked(r *Report) {
+	if c.HistorySize <= 0 {
+		return
+	}
+	e := netchecktype.HistoryEntry{
+		Summary: r.Summary(),
+		First:   r.Now,
+		Last:    r.Now,
+		Count:   1,
+	}
+	if c.NetMon != nil {
+		e.Network = networkSummary(c.NetMon.InterfaceState())
+	}
+	if n := len(c.history); n > 0 {
+		if last := &c.history[n-1]; netchecktype.Diff(last, &e) == nil {
+			last.Last = r.Now
+			last.Count++
+			return
+		}
+	}
+	if len(c.history) >= c.HistorySize {
+		c.history = slices.Delete(c.history, 0, len(c.history)-c.HistorySize+1)
+	}
+	c.history = append(c.history, e)
+}
+
+// History returns the history of the Client's reports, oldest first. It's
+// empty unless HistorySize is set.
+func (c *Client) History() []netchecktype.HistoryEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.history)
+}
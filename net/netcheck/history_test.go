@@ -0,0 +1,65 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"tailscale.com/net/netcheck/netchecktype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/opt"
+)
+
+func TestHistory(t *testing.T) {
+	fakeTime := time.Unix(123, 0)
+	c := &Client{
+		TimeNow:     func() time.Time { return fakeTime },
+		HistorySize: 2,
+	}
+	dm := &tailcfg.DERPMap{}
+	report := func(udp bool, nat opt.Bool) *Report {
+		return &Report{
+			UDP:                   udp,
+			IPv4:                  udp,
+			MappingVariesByDestIP: nat,
+			RegionLatency:         map[int]time.Duration{1: 10 * time.Millisecond},
+		}
+	}
+	add := func(r *Report) {
+		fakeTime = fakeTime.Add(time.Minute)
+		rs := &reportState{c: c, start: fakeTime.Add(-100 * time.Millisecond)}
+		c.addReportHistoryAndSetPreferredDERP(rs, r, dm.View())
+	}
+
+	add(report(true, "false"))
+	add(report(true, "false"))
+	hist := c.History()
+	if len(hist) != 1 {
+		t.Fatalf("got %d entries; want 1 for identical reports", len(hist))
+	}
+	if e := hist[0]; e.Count != 2 || e.Last.Sub(e.First) != time.Minute {
+		t.Errorf("entry = %+v; want 2 reports a minute apart", e)
+	}
+
+	add(report(true, "true"))
+	add(report(false, ""))
+	hist = c.History()
+	if len(hist) != 2 {
+		t.Fatalf("got %d entries; want 2, the HistorySize", len(hist))
+	}
+	if got := hist[0].Summary.NAT; got != "hard" {
+		t.Errorf("oldest entry NAT = %q; want %q", got, "hard")
+	}
+
+	got := fmt.Sprint(netchecktype.Diff(&hist[0], &hist[1]))
+	want := "[UDP: true -> false IPv4: true -> false NAT: hard -> unknown]"
+	if got != want {
+		t.Errorf("Diff = %s; want %s", got, want)
+	}
+	if d := netchecktype.Diff(&hist[1], &hist[1]); d != nil {
+		t.Errorf("Diff of entry with itself = %v; want nil", d)
+	}
+}
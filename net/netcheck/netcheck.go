@@ -30,6 +30,7 @@
 	"tailscale.com/feature/buildfeatures"
 	"tailscale.com/hostinfo"
 	"tailscale.com/net/dnscache"
+	"tailscale.com/net/netcheck/netchecktype"
 	"tailscale.com/net/neterror"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
@@ -231,6 +232,11 @@ type Client struct {
 	// the DERP is found to be reachable.
 	ForcePreferredDERP int
 
+	// HistorySize, if positive, is the maximum number of entries to keep
+	// in the history of reports returned by History. Consecutive reports
+	// with the same results on the same network share an entry.
+	HistorySize int
+
 	// For tests
 	testEnoughRegions      int
 	testCaptivePortalDelay time.Duration
@@ -242,6 +248,7 @@ type Client struct {
 	lastFull time.Time             // time of last full (non-incremental) report
 	curState *reportState          // non-nil if we're in a call to GetReport
 	resolver *dnscache.Resolver    // only set if UseDNSCache is true
+	history  []netchecktype.HistoryEntry
 }
 
 func (c *Client) enoughRegions() int {
@@ -1373,6 +1380,7 @@ func (c *Client) addReportHistoryAndSetPreferredDERP(rs *reportState, r *Report,
 	r.Now = now.UTC()
 	c.prev[now] = r
 	c.last = r
+	defer c.addHistoryLocked(r) // after r.PreferredDERP is set
 
 	const maxAge = 5 * time.Minute
 
@@ -0,0 +1,133 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package netchecktype defines the types of the netcheck report history,
+// without depending on the net/netcheck package.
+package netchecktype
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"time"
+
+	"tailscale.com/types/opt"
+)
+
+// Summary is the part of a netcheck report that describes this node's
+// connectivity, which is compared between reports to find what changed.
+type Summary struct {
+	UDP  bool // a UDP STUN round trip completed
+	IPv4 bool // an IPv4 STUN round trip completed
+	IPv6 bool // an IPv6 STUN round trip completed
+
+	// GlobalV4 and GlobalV6 are the public addresses seen by STUN
+	// servers, without ports, which vary between reports behind some
+	// NATs.
+	GlobalV4 netip.Addr `json:",omitzero"`
+	GlobalV6 netip.Addr `json:",omitzero"`
+
+	// NAT is "easy" if the public IPv4 address and port don't depend on
+	// the destination, "hard" if they do, or empty if unknown.
+	NAT string `json:",omitempty"`
+
+	PreferredDERP int // or 0 for unknown
+
+	// PortMapping lists the port mapping protocols found on the LAN,
+	// such as "UPnP, PCP", or is "none" if none were found, or empty if
+	// they weren't checked.
+	PortMapping string `json:",omitempty"`
+
+	CaptivePortal opt.Bool `json:",omitempty"`
+}
+
+// Network summarizes the state of the network interfaces.
+type Network struct {
+	// DefaultRoute is the name of the interface with the default route,
+	// if known.
+	DefaultRoute string `json:",omitempty"`
+	// Addrs are the addresses of the DefaultRoute interface.
+	Addrs []netip.Prefix `json:",omitempty"`
+
+	HaveV4    bool // there's a non-Tailscale IPv4 address
+	HaveV6    bool // there's a non-Tailscale global IPv6 address
+	Expensive bool `json:",omitempty"` // the network is metered, such as LTE
+}
+
+// HistoryEntry is a run of consecutive netcheck reports with the same
+// Summary, made on the same Network.
+type HistoryEntry struct {
+	Summary Summary
+	Network Network
+
+	First time.Time // when the first report was made
+	Last  time.Time // when the last report was made
+	Count int       // number of reports
+}
+
+// Change is a difference between two HistoryEntry values.
+type Change struct {
+	What string // what changed, such as "NAT"
+	Old  string
+	New  string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.What, c.Old, c.New)
+}
+
+// Diff returns what changed from prev to cur, in a fixed order. It returns
+// nil if they have the same Summary and Network.
+func Diff(prev, cur *HistoryEntry) []Change {
+	var ret []Change
+	add := func(what string, old, new any) {
+		o, n := diffString(old), diffString(new)
+		if o != n {
+			ret = append(ret, Change{What: what, Old: o, New: n})
+		}
+	}
+	ps, cs := &prev.Summary, &cur.Summary
+	add("UDP", ps.UDP, cs.UDP)
+	add("IPv4", ps.IPv4, cs.IPv4)
+	add("IPv6", ps.IPv6, cs.IPv6)
+	add("public IPv4", ps.GlobalV4, cs.GlobalV4)
+	add("public IPv6", ps.GlobalV6, cs.GlobalV6)
+	add("NAT", ps.NAT, cs.NAT)
+	add("preferred DERP", ps.PreferredDERP, cs.PreferredDERP)
+	add("port mapping", ps.PortMapping, cs.PortMapping)
+	add("captive portal", ps.CaptivePortal, cs.CaptivePortal)
+
+	pn, cn := &prev.Network, &cur.Network
+	add("default route", pn.DefaultRoute, cn.DefaultRoute)
+	if !slices.Equal(pn.Addrs, cn.Addrs) {
+		add("addresses", fmt.Sprint(pn.Addrs), fmt.Sprint(cn.Addrs))
+	}
+	add("have IPv4", pn.HaveV4, cn.HaveV4)
+	add("have IPv6", pn.HaveV6, cn.HaveV6)
+	add("expensive", pn.Expensive, cn.Expensive)
+	return ret
+}
+
+// diffString returns v formatted for a Change.
+func diffString(v any) string {
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return "unknown"
+		}
+		return v
+	case int:
+		if v == 0 {
+			return "unknown"
+		}
+	case netip.Addr:
+		if !v.IsValid() {
+			return "none"
+		}
+	case opt.Bool:
+		if v == "" {
+			return "unknown"
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -8,6 +8,7 @@
 import (
 	"bufio"
 	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -29,6 +30,7 @@
 
 func init() {
 	likelyHomeRouterIP = likelyHomeRouterIPLinux
+	likelyHomeRouterIPv6 = likelyHomeRouterIPv6Linux
 }
 
 var procNetRouteErr atomic.Bool
@@ -126,6 +128,72 @@ func likelyHomeRouterIPLinux() (ret netip.Addr, myIP netip.Addr, ok bool) {
 	return netip.Addr{}, netip.Addr{}, false
 }
 
+var procNetIPv6RoutePath = "/proc/net/ipv6_route"
+
+/*
+Parse fe80::211:22ff:fe33:4455%eth0 out of:
+
+$ cat /proc/net/ipv6_route
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe80000000000000021122fffe334455 00000400 00000001 00000000 00000003     eth0
+fe800000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+
+If there are several default routes, the one with the lowest metric wins.
+*/
+func likelyHomeRouterIPv6Linux() (gw netip.Addr, ifName string, ok bool) {
+	if !buildfeatures.HasPortMapper {
+		return
+	}
+	var bestMetric uint64
+	lineNum := 0
+	var f []mem.RO
+	for lr := range lineiter.File(procNetIPv6RoutePath) {
+		line, err := lr.Value()
+		if err != nil {
+			// IPv6 is likely disabled; there's nothing to find.
+			return netip.Addr{}, "", false
+		}
+		lineNum++
+		if lineNum > maxProcNetRouteRead {
+			break
+		}
+		f = mem.AppendFields(f[:0], mem.B(line))
+		if len(f) < 10 {
+			continue
+		}
+		dst, dstLen, nextHop, metricHex, flagsHex, dev := f[0], f[1], f[4], f[5], f[8], f[9]
+		if !dstLen.EqualString("00") || strings.Trim(dst.StringCopy(), "0") != "" {
+			continue // not a default route
+		}
+		flags, err := mem.ParseUint(flagsHex, 16, 32)
+		if err != nil {
+			continue
+		}
+		if flags&(unix.RTF_UP|unix.RTF_GATEWAY) != unix.RTF_UP|unix.RTF_GATEWAY {
+			continue
+		}
+		name := dev.StringCopy()
+		if strings.HasPrefix(name, "tailscale") || strings.HasPrefix(name, "wg") {
+			continue
+		}
+		metric, err := mem.ParseUint(metricHex, 16, 32)
+		if err != nil {
+			continue
+		}
+		var ip16 [16]byte
+		if n, err := hex.Decode(ip16[:], []byte(nextHop.StringCopy())); err != nil || n != 16 {
+			continue
+		}
+		ip := netip.AddrFrom16(ip16)
+		if ip.IsLinkLocalUnicast() {
+			ip = ip.WithZone(name)
+		}
+		if !ok || metric < bestMetric {
+			gw, ifName, bestMetric, ok = ip, name, metric, true
+		}
+	}
+	return gw, ifName, ok
+}
+
 func defaultRoute() (d DefaultRouteDetails, err error) {
 	v, err := defaultRouteInterfaceProcNet()
 	if err == nil {
@@ -7,6 +7,7 @@
 	"errors"
 	"fmt"
 	"io/fs"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"testing"
@@ -95,6 +96,33 @@ func TestAwsAppRunnerDefaultRouteInterface(t *testing.T) {
 	}
 }
 
+func TestLikelyHomeRouterIPv6Linux(t *testing.T) {
+	dir := t.TempDir()
+	tstest.Replace(t, &procNetIPv6RoutePath, filepath.Join(dir, "ipv6_route"))
+	buf := []byte("" +
+		// Default routes via WireGuard and the loopback interface are ignored.
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fd7a115ca1e0ab120000000000000001 00000001 00000001 00000000 00000003 tailscale0\n" +
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo\n" +
+		"fe800000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0\n" +
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000099 00000600 00000001 00000000 00000003    wlan0\n" +
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe80000000000000021122fffe334455 00000400 00000001 00000000 00000003     eth0\n")
+	if err := os.WriteFile(procNetIPv6RoutePath, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	gw, ifName, ok := likelyHomeRouterIPv6Linux()
+	if !ok {
+		t.Fatal("no default route found")
+	}
+	if want := netip.MustParseAddr("fe80::211:22ff:fe33:4455%eth0"); gw != want || ifName != "eth0" {
+		t.Errorf("got %v, %q; want %v, %q", gw, ifName, want, "eth0")
+	}
+
+	tstest.Replace(t, &procNetIPv6RoutePath, filepath.Join(dir, "missing"))
+	if gw, _, ok := likelyHomeRouterIPv6Linux(); ok {
+		t.Errorf("got %v from missing file; want none", gw)
+	}
+}
+
 func BenchmarkDefaultRouteInterface(b *testing.B) {
 	b.ReportAllocs()
 	for range b.N {
@@ -671,6 +671,37 @@ func LikelyHomeRouterIP() (gateway, myIP netip.Addr, ok bool) {
 	return gateway, myIP, myIP.IsValid()
 }
 
+// likelyHomeRouterIPv6, if present, is a platform-specific function that is
+// used to determine the IPv6 default gateway of the current system. It
+// returns the gateway, which is typically a link-local address with its
+// zone set, and the name of the interface used to reach it.
+var likelyHomeRouterIPv6 func() (gw netip.Addr, ifName string, ok bool)
+
+// LikelyHomeRouterIPv6 returns the likely IPv6 address of the residential
+// router, which is usually also the firewall in front of the LAN.
+// In addition, it returns a global unicast IPv6 address of the current
+// machine on the interface facing that router.
+// This is used as the destination for PCP requests to open IPv6 firewall
+// pinholes.
+func LikelyHomeRouterIPv6() (gateway, myIP netip.Addr, ok bool) {
+	if !buildfeatures.HasPortMapper || likelyHomeRouterIPv6 == nil {
+		return
+	}
+	gateway, ifName, ok := likelyHomeRouterIPv6()
+	if !ok {
+		return
+	}
+	ForeachInterfaceAddress(func(i Interface, pfx netip.Prefix) {
+		if myIP.IsValid() || !i.IsUp() || i.Name != ifName {
+			return
+		}
+		if ip := pfx.Addr(); ip.Is6() && v6Global1.Contains(ip) {
+			myIP = ip
+		}
+	})
+	return gateway, myIP, myIP.IsValid()
+}
+
 // isUsableV4 reports whether ip is a usable IPv4 address which could
 // conceivably be used to get Internet connectivity. Globally routable and
 // private IPv4 addresses are always Usable, and link local 169.254.x.x
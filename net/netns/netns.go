@@ -15,6 +15,7 @@
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/netip"
 	"runtime"
@@ -85,6 +86,29 @@ func Listener(logf logger.Logf, netMon *netmon.Monitor) *net.ListenConfig {
 	return &net.ListenConfig{Control: control(logf, netMon)}
 }
 
+// errBindToInterfaceUnsupported is returned by sockets of
+// ListenerOnInterface on platforms where we can't bind them to an interface.
+var errBindToInterfaceUnsupported = errors.New("netns: binding sockets to an interface is not supported on " + runtime.GOOS)
+
+// ListenerOnInterface returns a new net.ListenConfig whose sockets are bound
+// to the network interface named ifName, so that their packets leave
+// through it even if another interface holds the default route. Like
+// Listener's, its sockets don't route back into Tailscale.
+//
+// Binding to an interface is done even if netns is disabled. It returns an
+// error if there's no such interface, and its sockets fail to listen if
+// binding isn't supported on this platform.
+func ListenerOnInterface(logf logger.Logf, netMon *netmon.Monitor, ifName string) (*net.ListenConfig, error) {
+	if netMon == nil {
+		panic("netns.ListenerOnInterface called with nil netMon")
+	}
+	ifc, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil, err
+	}
+	return &net.ListenConfig{Control: controlOnInterface(logf, ifc)}, nil
+}
+
 // NewDialer returns a new Dialer using a net.Dialer with its Control
 // hook func initialized as necessary to run in a logical network
 // namespace that doesn't route back into Tailscale. It also handles
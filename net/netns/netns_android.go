@@ -7,6 +7,7 @@
 
 import (
 	"fmt"
+	"net"
 	"sync"
 	"syscall"
 
@@ -50,6 +51,14 @@ func SetAndroidProtectFunc(f func(fd int) error) {
 	androidProtectFunc = f
 }
 
+// controlOnInterface returns a Control func that fails: sockets can't be
+// bound to an interface from within the VpnService sandbox.
+func controlOnInterface(logger.Logf, *net.Interface) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return errBindToInterfaceUnsupported
+	}
+}
+
 func control(logger.Logf, *netmon.Monitor) func(network, address string, c syscall.RawConn) error {
 	return controlC
 }
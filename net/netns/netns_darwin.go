@@ -292,6 +292,14 @@ func SetListenConfigInterfaceIndex(lc *net.ListenConfig, ifIndex int) error {
 	return nil
 }
 
+// controlOnInterface returns a Control func binding sockets to ifc with
+// IP_BOUND_IF or IPV6_BOUND_IF.
+func controlOnInterface(logf logger.Logf, ifc *net.Interface) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return bindConnToInterface(c, network, address, ifc.Index, logf)
+	}
+}
+
 func bindConnToInterface(c syscall.RawConn, network, address string, ifIndex int, logf logger.Logf) error {
 	v6 := strings.Contains(address, "]:") || strings.HasSuffix(network, "6") // hacky test for v6
 	proto := unix.IPPROTO_IP
@@ -6,6 +6,7 @@
 package netns
 
 import (
+	"net"
 	"syscall"
 
 	"tailscale.com/net/netmon"
@@ -21,6 +22,12 @@ func controlC(network, address string, c syscall.RawConn) error {
 	return nil
 }
 
+func controlOnInterface(logger.Logf, *net.Interface) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return errBindToInterfaceUnsupported
+	}
+}
+
 func UseSocketMark() bool {
 	return false
 }
@@ -117,6 +117,30 @@ func setBypassMark(fd uintptr) error {
 	return nil
 }
 
+// controlOnInterface returns a Control func binding sockets to ifc with
+// SO_BINDTODEVICE. They also get the bypass mark, if in use, so that our
+// own routing rules don't send them into Tailscale.
+func controlOnInterface(_ logger.Logf, ifc *net.Interface) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			if UseSocketMark() {
+				if err := setBypassMark(fd); err != nil && !ignoreErrors() {
+					sockErr = err
+					return
+				}
+			}
+			if err := unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifc.Name); err != nil {
+				sockErr = fmt.Errorf("setting SO_BINDTODEVICE to %q: %w", ifc.Name, err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("RawConn.Control on %T: %w", c, err)
+		}
+		return sockErr
+	}
+}
+
 func bindToDevice(fd uintptr) error {
 	ifc, err := netmon.DefaultRouteInterface()
 	if err != nil {
@@ -6,6 +6,7 @@
 import (
 	"fmt"
 	"math/bits"
+	"net"
 	"net/netip"
 	"strings"
 	"syscall"
@@ -172,6 +173,17 @@ func interfaceIndexFor(addr netip.Addr) (uint32, error) {
 	return idx, nil
 }
 
+// controlOnInterface returns a Control func binding sockets to ifc with
+// IP_UNICAST_IF or IPV6_UNICAST_IF.
+func controlOnInterface(_ logger.Logf, ifc *net.Interface) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if strings.HasSuffix(network, "6") {
+			return bindSocket6(c, uint32(ifc.Index))
+		}
+		return bindSocket4(c, uint32(ifc.Index))
+	}
+}
+
 // sockoptBoundInterface is the value of IP_UNICAST_IF and IPV6_UNICAST_IF.
 //
 // See https://docs.microsoft.com/en-us/windows/win32/winsock/ipproto-ip-socket-options
@@ -0,0 +1,381 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/portmapper/portmappertest"
+	"tailscale.com/net/portmapper/portmappertype"
+	"tailscale.com/tstest"
+	"tailscale.com/util/eventbus/eventbustest"
+)
+
+// testLocalPort is the local port the conformance tests map.
+const testLocalPort = 12345
+
+// fakeClock is a clock for a portmappertest.Gateway, so tests can move its
+// epoch forward without waiting.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newConformanceClient returns a Client that talks to srv.
+func newConformanceClient(t *testing.T, srv *portmappertest.Server) *Client {
+	c := NewClient(Config{
+		Logf:     tstest.WhileTestRunningLogger(t),
+		NetMon:   netmon.NewStatic(),
+		EventBus: eventbustest.NewBus(t),
+	})
+	c.testPxPPort = srv.PxPPort()
+	c.testUPnPPort = srv.UPnPPort()
+	c.SetGatewayLookupFunc(testIPAndGateway)
+	c.SetLocalPort(testLocalPort)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// forceRenewal makes c's current mapping due for renewal.
+func forceRenewal(t *testing.T, c *Client) {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	past := time.Now().Add(-time.Second)
+	switch m := c.mapping.(type) {
+	case *pmpMapping:
+		m.renewAfter = past
+	case *pcpMapping:
+		m.renewAfter = past
+	case *upnpMapping:
+		m.renewAfter = past
+	default:
+		t.Fatalf("unexpected mapping type %T", m)
+	}
+}
+
+// TestConformance runs the Client against fake gateways that speak each
+// protocol, with the quirks of real gateways, through the lifecycle of a
+// mapping: probing, creating it, and renewing it after the gateway changed.
+func TestConformance(t *testing.T) {
+	reportedIP := netip.MustParseAddr("198.51.100.7")
+	newIP := netip.MustParseAddr("198.51.100.99")
+
+	tests := []struct {
+		name string
+		conf portmappertest.Config
+
+		wantProbe   portmappertype.ProbeResult
+		wantType    string // MappingType of the mapping, or empty for none
+		wantIP      netip.Addr
+		wantPerm    bool          // whether the gateway's mapping is permanent
+		wantTTL     time.Duration // if non-zero, the mapping's lifetime
+		wantRenewIP netip.Addr    // if valid, the external IP after SetExternalIP(newIP)
+
+		// gatewayChange, if non-nil, changes the gateway before the
+		// mapping is renewed.
+		gatewayChange func(*testing.T, *portmappertest.Server, *fakeClock, *Client)
+		// wantSamePort is whether the renewed mapping must have the same
+		// external port as the first.
+		wantSamePort bool
+	}{
+		{
+			name:      "pmp",
+			conf:      portmappertest.Config{PMP: true},
+			wantProbe: portmappertype.ProbeResult{PMP: true},
+			wantType:  "pmp",
+		},
+		{
+			name:      "pcp",
+			conf:      portmappertest.Config{PCP: true},
+			wantProbe: portmappertype.ProbeResult{PCP: true},
+			wantType:  "pcp",
+		},
+		{
+			name:      "upnp",
+			conf:      portmappertest.Config{UPnP: true},
+			wantProbe: portmappertype.ProbeResult{UPnP: true},
+			wantType:  "upnp",
+		},
+		{
+			name:      "upnp-v2",
+			conf:      portmappertest.Config{UPnP: true, UPnPv2: true},
+			wantProbe: portmappertype.ProbeResult{UPnP: true},
+			wantType:  "upnp",
+		},
+		{
+			name:      "all-protocols-prefers-pmp",
+			conf:      portmappertest.Config{PMP: true, PCP: true, UPnP: true},
+			wantProbe: portmappertype.ProbeResult{PMP: true, PCP: true, UPnP: true},
+			wantType:  "pmp",
+		},
+		{
+			name:      "upnp-only-permanent-leases",
+			conf:      portmappertest.Config{UPnP: true, Quirks: portmappertest.QuirksOnlyPermanentLeases},
+			wantProbe: portmappertype.ProbeResult{UPnP: true},
+			wantType:  "upnp",
+			wantPerm:  true,
+		},
+		{
+			name:      "upnp-v2-lease-invalid-args",
+			conf:      portmappertest.Config{UPnP: true, UPnPv2: true, Quirks: portmappertest.QuirksLeaseInvalidArgs},
+			wantProbe: portmappertype.ProbeResult{UPnP: true},
+			wantType:  "upnp",
+			wantPerm:  true,
+		},
+		{
+			name:      "upnp-read-only",
+			conf:      portmappertest.Config{UPnP: true, Quirks: portmappertest.QuirksUPnPReadOnly},
+			wantProbe: portmappertype.ProbeResult{UPnP: true},
+		},
+		{
+			name: "pcp-disabled-falls-back-to-upnp",
+			conf: portmappertest.Config{PCP: true, UPnP: true, Quirks: portmappertest.QuirksPCPDisabled},
+			// The probe hears PCP's refusal, so it doesn't report it.
+			wantProbe: portmappertype.ProbeResult{UPnP: true},
+			wantType:  "upnp",
+		},
+		{
+			name: "pmp-not-authorized",
+			conf: portmappertest.Config{PMP: true, Quirks: portmappertest.Quirks{PMPResultCode: portmappertest.PMPCodeNotAuthorized}},
+		},
+		{
+			name:      "pmp-short-lifetime",
+			conf:      portmappertest.Config{PMP: true, Quirks: portmappertest.Quirks{MaxLifetime: time.Minute}},
+			wantProbe: portmappertype.ProbeResult{PMP: true},
+			wantType:  "pmp",
+			wantTTL:   time.Minute,
+		},
+		{
+			name:      "pcp-short-lifetime",
+			conf:      portmappertest.Config{PCP: true, Quirks: portmappertest.Quirks{MaxLifetime: time.Minute}},
+			wantProbe: portmappertype.ProbeResult{PCP: true},
+			wantType:  "pcp",
+			wantTTL:   time.Minute,
+		},
+		{
+			name:      "pmp-reported-ip",
+			conf:      portmappertest.Config{PMP: true, Quirks: portmappertest.Quirks{ReportedExternalIP: reportedIP}},
+			wantProbe: portmappertype.ProbeResult{PMP: true},
+			wantType:  "pmp",
+			wantIP:    reportedIP,
+		},
+		{
+			name:      "pcp-reported-ip",
+			conf:      portmappertest.Config{PCP: true, Quirks: portmappertest.Quirks{ReportedExternalIP: reportedIP}},
+			wantProbe: portmappertype.ProbeResult{PCP: true},
+			wantType:  "pcp",
+			wantIP:    reportedIP,
+		},
+		{
+			name:      "upnp-double-nat",
+			conf:      portmappertest.Config{UPnP: true, Quirks: portmappertest.QuirksDoubleNAT},
+			wantProbe: portmappertype.ProbeResult{UPnP: true},
+			wantType:  "upnp",
+			wantIP:    portmappertest.QuirksDoubleNAT.ReportedExternalIP,
+		},
+		{
+			name:      "pmp-reboot",
+			conf:      portmappertest.Config{PMP: true},
+			wantProbe: portmappertype.ProbeResult{PMP: true},
+			wantType:  "pmp",
+			gatewayChange: func(t *testing.T, s *portmappertest.Server, clock *fakeClock, c *Client) {
+				s.Reboot()
+				clock.Advance(5 * time.Second)
+				probe(t, c) // notices the epoch went backwards
+			},
+		},
+		{
+			name:      "pcp-reboot",
+			conf:      portmappertest.Config{PCP: true},
+			wantProbe: portmappertype.ProbeResult{PCP: true},
+			wantType:  "pcp",
+			gatewayChange: func(t *testing.T, s *portmappertest.Server, clock *fakeClock, c *Client) {
+				s.Reboot()
+				clock.Advance(5 * time.Second)
+				probe(t, c)
+			},
+		},
+		{
+			name:         "pmp-expired",
+			conf:         portmappertest.Config{PMP: true},
+			wantProbe:    portmappertype.ProbeResult{PMP: true},
+			wantType:     "pmp",
+			wantSamePort: true,
+			gatewayChange: func(t *testing.T, s *portmappertest.Server, clock *fakeClock, c *Client) {
+				s.ExpireMappings()
+				forceRenewal(t, c)
+			},
+		},
+		{
+			name:         "pcp-expired",
+			conf:         portmappertest.Config{PCP: true},
+			wantProbe:    portmappertype.ProbeResult{PCP: true},
+			wantType:     "pcp",
+			wantSamePort: true,
+			gatewayChange: func(t *testing.T, s *portmappertest.Server, clock *fakeClock, c *Client) {
+				s.ExpireMappings()
+				forceRenewal(t, c)
+			},
+		},
+		{
+			name:         "upnp-expired",
+			conf:         portmappertest.Config{UPnP: true},
+			wantProbe:    portmappertype.ProbeResult{UPnP: true},
+			wantType:     "upnp",
+			wantSamePort: true,
+			gatewayChange: func(t *testing.T, s *portmappertest.Server, clock *fakeClock, c *Client) {
+				s.ExpireMappings()
+				forceRenewal(t, c)
+			},
+		},
+		{
+			name:         "pcp-new-external-ip",
+			conf:         portmappertest.Config{PCP: true},
+			wantProbe:    portmappertype.ProbeResult{PCP: true},
+			wantType:     "pcp",
+			wantRenewIP:  newIP,
+			wantSamePort: true,
+			gatewayChange: func(t *testing.T, s *portmappertest.Server, clock *fakeClock, c *Client) {
+				s.SetExternalIP(newIP)
+				forceRenewal(t, c)
+			},
+		},
+		{
+			name:         "upnp-new-external-ip",
+			conf:         portmappertest.Config{UPnP: true},
+			wantProbe:    portmappertype.ProbeResult{UPnP: true},
+			wantType:     "upnp",
+			wantRenewIP:  newIP,
+			wantSamePort: true,
+			gatewayChange: func(t *testing.T, s *portmappertest.Server, clock *fakeClock, c *Client) {
+				s.SetExternalIP(newIP)
+				forceRenewal(t, c)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+			conf := tt.conf
+			conf.Logf = tstest.WhileTestRunningLogger(t)
+			conf.Now = clock.Now
+			srv, err := portmappertest.NewServer(conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+			c := newConformanceClient(t, srv)
+
+			if res := probe(t, c); res != tt.wantProbe {
+				t.Errorf("Probe = %+v; want %+v", res, tt.wantProbe)
+			}
+
+			ctx := context.Background()
+			m, ext, err := c.createOrGetMapping(ctx)
+			if tt.wantType == "" {
+				if err == nil {
+					t.Fatalf("got mapping %v; want none", ext)
+				}
+				if gm := srv.Mappings(); len(gm) != 0 {
+					t.Errorf("gateway has mappings %+v; want none", gm)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("createOrGetMapping: %v", err)
+			}
+			if m == nil {
+				c.mu.Lock()
+				m = c.mapping
+				c.mu.Unlock()
+			}
+			if got := m.MappingType(); got != tt.wantType {
+				t.Errorf("mapping type = %q; want %q", got, tt.wantType)
+			}
+			gms := srv.Mappings()
+			if len(gms) != 1 {
+				t.Fatalf("gateway has %d mappings; want 1: %+v", len(gms), gms)
+			}
+			gm := gms[0]
+			if gm.Internal.Port() != testLocalPort {
+				t.Errorf("gateway mapping internal = %v; want port %d", gm.Internal, testLocalPort)
+			}
+			wantIP := tt.wantIP
+			if !wantIP.IsValid() {
+				wantIP = gm.External.Addr()
+			}
+			if want := netip.AddrPortFrom(wantIP, gm.External.Port()); ext != want {
+				t.Errorf("external = %v; want %v", ext, want)
+			}
+			if got := gm.Expires.IsZero(); got != tt.wantPerm {
+				t.Errorf("gateway mapping permanent = %v; want %v", got, tt.wantPerm)
+			}
+			if tt.wantTTL != 0 {
+				if got := gm.Expires.Sub(clock.Now()); got != tt.wantTTL {
+					t.Errorf("gateway mapping lifetime = %v; want %v", got, tt.wantTTL)
+				}
+				if ttl := time.Until(m.GoodUntil()); ttl > tt.wantTTL {
+					t.Errorf("client mapping good for %v; want at most %v", ttl, tt.wantTTL)
+				}
+			}
+
+			if tt.gatewayChange == nil {
+				return
+			}
+			tt.gatewayChange(t, srv, clock, c)
+			_, ext2, err := c.createOrGetMapping(ctx)
+			if err != nil {
+				t.Fatalf("renewing mapping: %v", err)
+			}
+			gms = srv.Mappings()
+			if len(gms) != 1 {
+				t.Fatalf("after renewal, gateway has %d mappings; want 1: %+v", len(gms), gms)
+			}
+			wantRenewIP := tt.wantRenewIP
+			if !wantRenewIP.IsValid() {
+				wantRenewIP = ext.Addr()
+			}
+			if want := netip.AddrPortFrom(wantRenewIP, gms[0].External.Port()); ext2 != want {
+				t.Errorf("renewed external = %v; want %v", ext2, want)
+			}
+			if tt.wantSamePort && ext2.Port() != ext.Port() {
+				t.Errorf("renewed external port = %d; want %d, as before", ext2.Port(), ext.Port())
+			}
+		})
+	}
+}
+
+// probe probes c's gateway and returns the result.
+func probe(t *testing.T, c *Client) (res portmappertype.ProbeResult) {
+	t.Helper()
+	// Forget what was seen recently, so each probe asks the gateway.
+	c.mu.Lock()
+	c.pmpPubIPTime = time.Time{}
+	c.pcpSawTime = time.Time{}
+	c.uPnPSawTime = time.Time{}
+	c.mu.Unlock()
+	res, err := c.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	return res
+}
@@ -57,7 +57,12 @@ type pcpMapping struct {
 	epoch uint32
 }
 
-func (p *pcpMapping) MappingType() string      { return "pcp" }
+func (p *pcpMapping) MappingType() string {
+	if p.internal.Addr().Is6() {
+		return "pcp6" // an IPv6 firewall pinhole
+	}
+	return "pcp"
+}
 func (p *pcpMapping) GoodUntil() time.Time     { return p.goodUntil }
 func (p *pcpMapping) RenewAfter() time.Time    { return p.renewAfter }
 func (p *pcpMapping) External() netip.AddrPort { return p.external }
@@ -68,7 +73,11 @@ func (p *pcpMapping) MappingDebug() string {
 }
 
 func (p *pcpMapping) Release(ctx context.Context) {
-	uc, err := p.c.listenPacket(ctx, "udp4", ":0")
+	network := "udp4"
+	if p.gw.Addr().Is6() {
+		network = "udp6"
+	}
+	uc, err := p.c.listenPacket(ctx, network, ":0")
 	if err != nil {
 		return
 	}
@@ -9,6 +9,8 @@
 	"testing"
 
 	"tailscale.com/net/netaddr"
+	"tailscale.com/net/portmapper/portmappertest"
+	"tailscale.com/tstest"
 )
 
 var examplePCPMapResponse = []byte{2, 129, 0, 0, 0, 0, 28, 32, 0, 2, 155, 237, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 129, 112, 9, 24, 241, 208, 251, 45, 157, 76, 10, 188, 17, 0, 0, 0, 4, 210, 4, 210, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255, 255, 135, 180, 175, 246}
@@ -60,3 +62,26 @@ func buildPCPMapResponse(req []byte) []byte {
 	copy(mapResp[20:36], assignedIP16[:])
 	return out
 }
+
+// TestProbePCPNotAuthorized verifies that a PCP server that refuses to map
+// ports isn't remembered as available, which would make createOrGetMapping
+// try it before UPnP and NAT-PMP.
+func TestProbePCPNotAuthorized(t *testing.T) {
+	srv, err := portmappertest.NewServer(portmappertest.Config{
+		Logf:   tstest.WhileTestRunningLogger(t),
+		PCP:    true,
+		Quirks: portmappertest.QuirksPCPDisabled,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	c := newConformanceClient(t, srv)
+
+	if res := probe(t, c); res.PCP {
+		t.Errorf("Probe = %+v; want no PCP", res)
+	}
+	if c.sawPCPRecently() {
+		t.Error("sawPCPRecently = true after PCP refused; want false")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"time"
+
+	"tailscale.com/net/neterror"
+	"tailscale.com/net/portmapper/portmappertype"
+)
+
+// IPv6 needs no address translation, but home routers commonly run a
+// stateful firewall that drops unsolicited inbound IPv6 traffic. A pinhole
+// is a hole in that firewall letting the world reach a local address and
+// port, so its external address is the same as its internal one.
+//
+// We ask for pinholes with PCP (RFC 6887), whose MAP opcode also serves
+// IPv6 firewalls, or with the UPnP IGDv2 WANIPv6FirewallControl service.
+
+// errNoIPv6Gateway is returned when there's no IPv6 default route or no
+// global IPv6 address to open a pinhole to.
+var errNoIPv6Gateway = errors.New("skipping pinhole; no IPv6 gateway or global IPv6 address")
+
+// SetLocalPort6 updates the local IPv6 UDP port number for which we want
+// to open a firewall pinhole. Zero means no pinhole is wanted.
+func (c *Client) SetLocalPort6(localPort uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.localPort6 == localPort {
+		return
+	}
+	c.localPort6 = localPort
+	c.invalidatePinholeLocked(true)
+}
+
+func (c *Client) invalidatePinholeLocked(releaseOld bool) {
+	if c.pinhole != nil {
+		if releaseOld {
+			c.pinhole.Release(context.Background())
+		}
+		c.pinhole = nil
+	}
+}
+
+// gatewayAndSelfIPv6 is like gatewayAndSelfIP, but for IPv6.
+func (c *Client) gatewayAndSelfIPv6() (gw, myIP netip.Addr, ok bool) {
+	if c.ip6AndGateway != nil {
+		gw, myIP, ok = c.ip6AndGateway()
+	}
+	if !ok {
+		gw = netip.Addr{}
+		myIP = netip.Addr{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gw != c.lastGW6 || myIP != c.lastMyIP6 || !ok {
+		c.lastMyIP6 = myIP
+		c.lastGW6 = gw
+		c.invalidatePinholeLocked(true)
+	}
+	return
+}
+
+// GetCachedPinholeOrStartCreatingOne quickly returns with our current cached
+// IPv6 firewall pinhole, if any. If there's not one, it starts up a
+// background goroutine to create one, which publishes a
+// [portmappertype.Mapping] event if it succeeds.
+func (c *Client) GetCachedPinholeOrStartCreatingOne() (external netip.AddrPort, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.localPort6 == 0 || c.closed {
+		return netip.AddrPort{}, false
+	}
+
+	// Do we have an existing pinhole that's valid?
+	now := time.Now()
+	if p := c.pinhole; p != nil {
+		if now.Before(p.GoodUntil()) {
+			if now.After(p.RenewAfter()) {
+				c.maybeStartPinholeLocked()
+			}
+			return p.External(), true
+		}
+	}
+
+	c.maybeStartPinholeLocked()
+	return netip.AddrPort{}, false
+}
+
+// maybeStartPinholeLocked starts a createPinhole goroutine up, if one isn't
+// already running.
+//
+// c.mu must be held.
+func (c *Client) maybeStartPinholeLocked() {
+	if !c.runningPinhole {
+		c.runningPinhole = true
+		go c.createPinhole()
+	}
+}
+
+func (c *Client) createPinhole() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.runningPinhole = false
+	}()
+
+	pinhole, err := c.createOrGetPinhole(ctx)
+	if err != nil {
+		if !IsNoMappingError(err) {
+			c.logf("createOrGetPinhole: %v", err)
+		}
+		return
+	}
+	c.updates.Publish(portmappertype.Mapping{
+		External:  pinhole.External(),
+		Type:      pinhole.MappingType(),
+		GoodUntil: pinhole.GoodUntil(),
+	})
+	if c.onChange != nil {
+		go c.onChange()
+	}
+}
+
+// createOrGetPinhole either creates or renews an IPv6 firewall pinhole, or
+// returns a cached valid one.
+//
+// If no pinhole is available, the error will be of type NoMappingError;
+// see IsNoMappingError.
+func (c *Client) createOrGetPinhole(ctx context.Context) (mapping, error) {
+	if c.debug.disableAll() {
+		return nil, NoMappingError{ErrPortMappingDisabled}
+	}
+	if c.debug.DisableUPnP() && c.debug.DisablePCP() {
+		return nil, NoMappingError{ErrNoPortMappingServices}
+	}
+	gw, myIP, ok := c.gatewayAndSelfIPv6()
+	if !ok {
+		return nil, NoMappingError{errNoIPv6Gateway}
+	}
+
+	c.mu.Lock()
+	localPort := c.localPort6
+	old := c.pinhole
+	c.mu.Unlock()
+	if localPort == 0 {
+		return nil, NoMappingError{ErrNoPortMappingServices}
+	}
+	if old != nil && time.Now().Before(old.RenewAfter()) {
+		return old, nil
+	}
+	internal := netip.AddrPortFrom(myIP, localPort)
+
+	var (
+		pinhole mapping
+		err     error
+	)
+	if !c.debug.DisablePCP() {
+		pinhole, err = c.getPCPPinhole(ctx, gw, internal)
+		if err != nil {
+			c.vlogf("PCP pinhole: %v", err)
+		}
+	}
+	if pinhole == nil {
+		pinhole, err = c.getUPnPPinhole(ctx, internal, old)
+		if err != nil {
+			c.vlogf("UPnP pinhole: %v", err)
+		}
+	}
+	if pinhole == nil {
+		return nil, NoMappingError{ErrNoPortMappingServices}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.localPort6 != localPort {
+		// We raced with Close or SetLocalPort6; don't leak the pinhole.
+		go pinhole.Release(context.Background())
+		return nil, NoMappingError{ErrNoPortMappingServices}
+	}
+	c.pinhole = pinhole
+	if c.debug.VerboseLogs {
+		c.logf("successfully obtained pinhole: external=%v type=%s pinhole=%s",
+			pinhole.External(), pinhole.MappingType(), pinhole.MappingDebug())
+	} else {
+		c.logf("[v1] successfully obtained pinhole: external=%v type=%s goodUntil=%d renewAfter=%d",
+			pinhole.External(), pinhole.MappingType(),
+			pinhole.GoodUntil().Unix(), pinhole.RenewAfter().Unix())
+	}
+	return pinhole, nil
+}
+
+// getPCPPinhole asks the PCP server at gw to open a pinhole to internal. It
+// returns (nil, nil) if there's no answer.
+func (c *Client) getPCPPinhole(ctx context.Context, gw netip.Addr, internal netip.AddrPort) (mapping, error) {
+	uc, err := c.listenPacket(ctx, "udp6", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+
+	uc.SetReadDeadline(time.Now().Add(portMapServiceTimeout))
+	defer closeCloserOnContextDone(ctx, uc)()
+
+	pxpAddr := netip.AddrPortFrom(gw, c.pxpPort())
+
+	// A firewall doesn't translate addresses, so suggest the internal
+	// address and port as the external ones.
+	pkt := buildPCPRequestMappingPacket(internal.Addr(), internal.Port(), internal.Port(), pcpMapLifetimeSec, internal.Addr())
+	if _, err := uc.WriteToUDPAddrPort(pkt, pxpAddr); err != nil {
+		if neterror.TreatAsLostUDP(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	res := make([]byte, 1500)
+	for {
+		n, src, err := uc.ReadFromUDPAddrPort(res)
+		if err != nil {
+			if ctx.Err() == context.Canceled {
+				return nil, err
+			}
+			return nil, nil // timeout; no PCP server
+		}
+		if src.Addr().WithZone("") != gw.WithZone("") || src.Port() != pxpAddr.Port() || res[0] != pcpVersion {
+			continue
+		}
+		p, err := parsePCPMapResponse(res[:n])
+		if err != nil {
+			return nil, err
+		}
+		p.c = c
+		p.gw = pxpAddr
+		p.internal = internal
+		if !p.external.Addr().Is6() || p.external.Port() == 0 {
+			p.external = internal
+		}
+		return p, nil
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/net/portmapper/portmappertest"
+	"tailscale.com/tstest"
+)
+
+// testLocalPort6 is the local IPv6 port the pinhole tests open.
+const testLocalPort6 = 23456
+
+func TestPinhole(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     portmappertest.Config
+		wantType string // MappingType of the pinhole, or empty for none
+	}{
+		{
+			name:     "pcp",
+			conf:     portmappertest.Config{PCP: true, IPv6Firewall: true},
+			wantType: "pcp6",
+		},
+		{
+			name:     "upnp",
+			conf:     portmappertest.Config{UPnP: true, UPnPv2: true, IPv6Firewall: true},
+			wantType: "upnp6",
+		},
+		{
+			name:     "pcp-disabled-upnp-fallback",
+			conf:     portmappertest.Config{PCP: true, UPnP: true, UPnPv2: true, IPv6Firewall: true, Quirks: portmappertest.QuirksPCPDisabled},
+			wantType: "upnp6",
+		},
+		{
+			name: "upnp-pinholes-disallowed",
+			conf: portmappertest.Config{UPnP: true, UPnPv2: true, IPv6Firewall: true, Quirks: portmappertest.Quirks{UPnPPinholesDisallowed: true}},
+		},
+		{
+			name: "upnp-v1",
+			conf: portmappertest.Config{UPnP: true, IPv6Firewall: true},
+		},
+		{
+			name: "no-firewall",
+			conf: portmappertest.Config{PCP: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := tt.conf
+			conf.Logf = tstest.WhileTestRunningLogger(t)
+			srv, err := portmappertest.NewServer(conf)
+			if err != nil {
+				if conf.IPv6Firewall {
+					t.Skipf("no IPv6 loopback: %v", err)
+				}
+				t.Fatal(err)
+			}
+			defer srv.Close()
+			c := newConformanceClient(t, srv)
+			c.ip6AndGateway = func() (gw, ip netip.Addr, ok bool) {
+				return netip.IPv6Loopback(), netip.IPv6Loopback(), true
+			}
+			c.SetLocalPort6(testLocalPort6)
+			probe(t, c) // for UPnP discovery
+
+			ctx := context.Background()
+			p, err := c.createOrGetPinhole(ctx)
+			if tt.wantType == "" {
+				if err == nil {
+					t.Fatalf("got pinhole %v; want none", p.External())
+				}
+				if gp := srv.Pinholes(); len(gp) != 0 {
+					t.Errorf("gateway has pinholes %+v; want none", gp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("createOrGetPinhole: %v", err)
+			}
+			if got := p.MappingType(); got != tt.wantType {
+				t.Errorf("pinhole type = %q; want %q", got, tt.wantType)
+			}
+			want := netip.AddrPortFrom(netip.IPv6Loopback(), testLocalPort6)
+			if got := p.External(); got != want {
+				t.Errorf("external = %v; want %v", got, want)
+			}
+			gps := srv.Pinholes()
+			if len(gps) != 1 || gps[0].Internal != want || gps[0].Proto != tt.wantType {
+				t.Fatalf("gateway pinholes = %+v; want one %s pinhole to %v", gps, tt.wantType, want)
+			}
+			if ext, ok := c.GetCachedPinholeOrStartCreatingOne(); !ok || ext != want {
+				t.Errorf("GetCachedPinholeOrStartCreatingOne = %v, %v; want %v, true", ext, ok, want)
+			}
+
+			// Renewing keeps the same pinhole.
+			c.mu.Lock()
+			switch p := c.pinhole.(type) {
+			case *pcpMapping:
+				p.renewAfter = time.Now().Add(-time.Second)
+			case *upnpPinhole:
+				p.renewAfter = time.Now().Add(-time.Second)
+			}
+			c.mu.Unlock()
+			p2, err := c.createOrGetPinhole(ctx)
+			if err != nil {
+				t.Fatalf("renewing pinhole: %v", err)
+			}
+			if p2 == p {
+				t.Errorf("pinhole not renewed")
+			}
+			if gps2 := srv.Pinholes(); len(gps2) != 1 || gps2[0].ID != gps[0].ID {
+				t.Errorf("after renewal, gateway pinholes = %+v; want %+v", gps2, gps)
+			}
+
+			// Changing the local port closes the pinhole. PCP releases
+			// are fire-and-forget, so wait for the gateway to get it.
+			c.SetLocalPort6(0)
+			if err := tstest.WaitFor(5*time.Second, func() error {
+				if gp := srv.Pinholes(); len(gp) != 0 {
+					return fmt.Errorf("gateway has pinholes %+v; want none", gp)
+				}
+				return nil
+			}); err != nil {
+				t.Errorf("after SetLocalPort6(0): %v", err)
+			}
+		})
+	}
+}
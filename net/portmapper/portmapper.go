@@ -114,13 +114,14 @@ type Client struct {
 	pubClient *eventbus.Client
 	updates   *eventbus.Publisher[portmappertype.Mapping]
 
-	logf         logger.Logf
-	netMon       *netmon.Monitor // optional; nil means interfaces will be looked up on-demand
-	ipAndGateway func() (gw, ip netip.Addr, ok bool)
-	onChange     func() // or nil
-	debug        DebugKnobs
-	testPxPPort  uint16 // if non-zero, pxpPort to use for tests
-	testUPnPPort uint16 // if non-zero, uPnPPort to use for tests
+	logf          logger.Logf
+	netMon        *netmon.Monitor // optional; nil means interfaces will be looked up on-demand
+	ipAndGateway  func() (gw, ip netip.Addr, ok bool)
+	ip6AndGateway func() (gw, ip netip.Addr, ok bool) // or nil if IPv6 pinholes are unsupported
+	onChange      func()                              // or nil
+	debug         DebugKnobs
+	testPxPPort   uint16 // if non-zero, pxpPort to use for tests
+	testUPnPPort  uint16 // if non-zero, uPnPPort to use for tests
 
 	mu syncs.Mutex // guards following, and all fields thereof
 
@@ -151,6 +152,13 @@ type Client struct {
 	localPort uint16
 
 	mapping mapping // non-nil if we have a mapping
+
+	// The following fields are for IPv6 firewall pinholes; see pinhole.go.
+	runningPinhole bool       // whether a createPinhole goroutine is running
+	lastMyIP6      netip.Addr // IPv6 address of the last pinhole attempt
+	lastGW6        netip.Addr // IPv6 gateway of the last pinhole attempt
+	localPort6     uint16
+	pinhole        mapping // non-nil if we have a pinhole
 }
 
 var _ portmappertype.Client = (*Client)(nil)
@@ -269,6 +277,7 @@ func NewClient(c Config) *Client {
 	if buildfeatures.HasPortMapper {
 		// TODO(bradfitz): move this to method on netMon
 		ret.ipAndGateway = netmon.LikelyHomeRouterIP
+		ret.ip6AndGateway = netmon.LikelyHomeRouterIPv6
 	}
 	ret.pubClient = c.EventBus.Client("portmapper")
 	ret.updates = eventbus.Publish[portmappertype.Mapping](ret.pubClient)
@@ -296,6 +305,7 @@ func (c *Client) NoteNetworkDown() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.invalidateMappingsLocked(false)
+	c.invalidatePinholeLocked(false)
 }
 
 func (c *Client) Close() error {
@@ -306,6 +316,7 @@ func (c *Client) Close() error {
 	}
 	c.closed = true
 	c.invalidateMappingsLocked(true)
+	c.invalidatePinholeLocked(true)
 	c.updates.Close()
 	c.pubClient.Close()
 
@@ -1096,7 +1107,12 @@ func (c *Client) Probe(ctx context.Context) (res portmappertype.ProbeResult, err
 					pcpHeard = true
 					c.mu.Lock()
 					c.maybeInvalidatePCPMappingLocked(pres.Epoch) // must be before we write to c.pcp*
-					c.pcpSawTime = time.Now()
+					if pres.ResultCode == pcpCodeOK {
+						// Only remember PCP as available if it'll map
+						// ports for us, so createOrGetMapping doesn't
+						// prefer a PCP server that refuses to.
+						c.pcpSawTime = time.Now()
+					}
 					c.pcpLastEpoch = pres.Epoch
 					c.mu.Unlock()
 					switch pres.ResultCode {
@@ -0,0 +1,673 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package portmappertest provides a fake Internet gateway device that speaks
+// NAT-PMP, PCP and UPnP IGD, with configurable misbehavior, for testing port
+// mapping clients. It can also play an IPv6 firewall that opens pinholes
+// over PCP and UPnP.
+//
+// The protocol logic lives in [Gateway], which doesn't do any I/O so it can
+// be plumbed into a virtual network such as tstest/natlab/vnet. [Server] runs
+// a Gateway on localhost sockets.
+package portmappertest
+
+import (
+	"cmp"
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+// Protocol constants, from RFC 6886 (NAT-PMP) and RFC 6887 (PCP).
+const (
+	pmpVersion         = 0
+	pmpOpMapPublicAddr = 0
+	pmpOpMapUDP        = 1
+	pmpOpMapTCP        = 2
+
+	pcpVersion    = 2
+	pcpOpAnnounce = 0
+	pcpOpMap      = 1
+
+	opReply = 0x80 // OR'd into request's op code on response
+
+	pcpProtoUDP = 17
+	pcpProtoTCP = 6
+)
+
+// NAT-PMP result codes, from RFC 6886 section 3.5.
+const (
+	PMPCodeOK                 = 0
+	PMPCodeUnsupportedVersion = 1
+	PMPCodeNotAuthorized      = 2
+	PMPCodeNetworkFailure     = 3
+	PMPCodeOutOfResources     = 4
+	PMPCodeUnsupportedOpcode  = 5
+)
+
+// PCP result codes, from RFC 6887 section 7.4.
+const (
+	PCPCodeOK               = 0
+	PCPCodeUnsuppVersion    = 1
+	PCPCodeNotAuthorized    = 2
+	PCPCodeMalformedRequest = 3
+	PCPCodeUnsuppOpcode     = 4
+	PCPCodeNetworkFailure   = 7
+	PCPCodeNoResources      = 8
+	PCPCodeUnsuppProtocol   = 9
+	PCPCodeAddressMismatch  = 12
+)
+
+// UPnP error codes returned in SOAP faults, from the WANIPConnection:2
+// service specification.
+const (
+	UPnPErrInvalidAction                = 401
+	UPnPErrInvalidArgs                  = 402
+	UPnPErrActionFailed                 = 501
+	UPnPErrActionNotAuthorized          = 606
+	UPnPErrNoSuchEntryInArray           = 714
+	UPnPErrConflictInMappingEntry       = 718
+	UPnPErrOnlyPermanentLeasesSupported = 725
+)
+
+// UPnP error codes returned in SOAP faults, from the
+// WANIPv6FirewallControl:1 service specification.
+const (
+	UPnPErrFirewallDisabled         = 702
+	UPnPErrInboundPinholeNotAllowed = 703
+	UPnPErrNoSuchEntry              = 704
+	UPnPErrProtocolNotSupported     = 705
+)
+
+// DefaultExternalIP is the external address of a Gateway whose Config doesn't
+// specify one.
+var DefaultExternalIP = netip.MustParseAddr("203.0.113.1")
+
+// firstDynamicPort is the first external port the Gateway hands out when the
+// client doesn't ask for one or the one it asks for is taken.
+const firstDynamicPort = 41000
+
+// Config configures a Gateway.
+type Config struct {
+	// PMP, PCP and UPnP are which protocols the Gateway speaks.
+	// Requests for the others are ignored, as if the Gateway didn't
+	// listen for them.
+	PMP  bool
+	PCP  bool
+	UPnP bool
+
+	// UPnPv2 makes the Gateway an InternetGatewayDevice:2 with a
+	// WANIPConnection:2 service, which supports AddAnyPortMapping.
+	// Otherwise it's an InternetGatewayDevice:1 with WANIPConnection:1.
+	UPnPv2 bool
+
+	// IPv6Firewall makes the Gateway also an IPv6 firewall, which opens
+	// pinholes for PCP MAP requests from IPv6 clients if PCP is set,
+	// and with a WANIPv6FirewallControl:1 service if UPnPv2 is set.
+	IPv6Firewall bool
+
+	// ExternalIP is the Gateway's WAN address. If zero,
+	// DefaultExternalIP is used.
+	ExternalIP netip.Addr
+
+	// Quirks are the ways in which the Gateway misbehaves.
+	Quirks Quirks
+
+	// Logf, if non-nil, logs the requests the Gateway gets.
+	Logf logger.Logf
+
+	// Now, if non-nil, returns the current time, for epochs and lease
+	// expiry. If nil, time.Now is used.
+	Now func() time.Time
+
+	// MapPort, if non-nil, is called to install a mapping from an external
+	// port to internal, for Gateways plumbed into a virtual network. It
+	// returns the external port it used, which might differ from
+	// wantExternal. A zero lifetime deletes the mapping. If MapPort is
+	// nil, the Gateway picks the external ports itself.
+	MapPort func(internal netip.AddrPort, wantExternal uint16, lifetime time.Duration) (external uint16, ok bool)
+}
+
+// Quirks are ways in which real gateways deviate from the protocols, or
+// fail in ways clients must handle. The zero value is a well-behaved
+// gateway.
+type Quirks struct {
+	// ReportedExternalIP, if valid, is the external address the Gateway
+	// reports to clients instead of its real one, as gateways behind
+	// another NAT or with a stale WAN address do.
+	ReportedExternalIP netip.Addr
+
+	// MaxLifetime, if non-zero, caps the lifetime of the mappings the
+	// Gateway grants.
+	MaxLifetime time.Duration
+
+	// PMPResultCode and PCPResultCode, if non-zero, are returned to every
+	// NAT-PMP or PCP request instead of serving it.
+	PMPResultCode uint16
+	PCPResultCode uint8
+
+	// UPnPLeaseError, if non-zero, is the UPnP error code with which
+	// AddPortMapping and AddAnyPortMapping fail for non-permanent
+	// leases. Routers that only support permanent leases use
+	// UPnPErrOnlyPermanentLeasesSupported; some use UPnPErrInvalidArgs.
+	UPnPLeaseError int
+
+	// UPnPAddPortMappingError, if non-zero, is the UPnP error code with
+	// which every AddPortMapping and AddAnyPortMapping call fails, such
+	// as UPnPErrActionNotAuthorized from routers with UPnP in read-only
+	// mode.
+	UPnPAddPortMappingError int
+
+	// UPnPConnectionStatus, if non-empty, is the WAN connection status
+	// returned by GetStatusInfo instead of "Connected".
+	UPnPConnectionStatus string
+
+	// UPnPPinholesDisallowed makes the Gateway's WANIPv6FirewallControl
+	// service report that inbound pinholes aren't allowed, and fail
+	// AddPinhole.
+	UPnPPinholesDisallowed bool
+}
+
+// Common quirks of router firmwares seen in the wild.
+var (
+	// QuirksOnlyPermanentLeases is a router, such as some miniupnpd
+	// builds, that rejects UPnP mappings with a lease duration.
+	// See https://github.com/tailscale/tailscale/issues/9343.
+	QuirksOnlyPermanentLeases = Quirks{UPnPLeaseError: UPnPErrOnlyPermanentLeasesSupported}
+
+	// QuirksLeaseInvalidArgs is a router that rejects UPnP mappings with
+	// a lease duration as invalid arguments.
+	// See https://github.com/tailscale/tailscale/issues/15223.
+	QuirksLeaseInvalidArgs = Quirks{UPnPLeaseError: UPnPErrInvalidArgs}
+
+	// QuirksUPnPReadOnly is a router that answers UPnP queries but
+	// doesn't let clients add mappings.
+	QuirksUPnPReadOnly = Quirks{UPnPAddPortMappingError: UPnPErrActionNotAuthorized}
+
+	// QuirksPCPDisabled is a router with PCP implemented but turned off.
+	QuirksPCPDisabled = Quirks{PCPResultCode: PCPCodeNotAuthorized}
+
+	// QuirksDoubleNAT is a router behind another NAT, which reports a
+	// private external address.
+	QuirksDoubleNAT = Quirks{ReportedExternalIP: netip.MustParseAddr("100.64.0.1")}
+)
+
+// Mapping is a port mapping or IPv6 firewall pinhole held by a Gateway.
+// A pinhole's External address is the same as its Internal one.
+type Mapping struct {
+	Proto    string // "pmp", "pcp" or "upnp"; or "pcp6" or "upnp6" for pinholes
+	Internal netip.AddrPort
+	External netip.AddrPort
+	Expires  time.Time // or zero for a permanent mapping
+	ID       uint16    // for UPnP pinholes, the UniqueID of the pinhole
+}
+
+// Stats counts the requests a Gateway got, including ones for protocols it
+// doesn't speak.
+type Stats struct {
+	PMPPublicAddr int // NAT-PMP public address requests
+	PMPMap        int // NAT-PMP mapping requests
+	PCPAnnounce   int // PCP ANNOUNCE requests
+	PCPMap        int // PCP MAP requests
+	PxPOther      int // unknown or malformed NAT-PMP or PCP requests
+	SSDP          int // UPnP discovery requests
+	SOAP          int // UPnP control requests
+	AddPortMap    int // UPnP AddPortMapping and AddAnyPortMapping requests
+	AddPinhole    int // UPnP AddPinhole requests
+}
+
+// Gateway is a fake Internet gateway device. Its methods may be called
+// concurrently.
+type Gateway struct {
+	conf Config
+	logf logger.Logf
+	now  func() time.Time
+
+	mu         sync.Mutex // guards following
+	externalIP netip.Addr
+	quirks     Quirks
+	epochStart time.Time
+	mappings   map[uint16]*Mapping // by external port
+	nextPort   uint16
+	pinholes   map[netip.AddrPort]*Mapping // by internal address
+	nextID     uint16                      // next UPnP pinhole UniqueID
+	stats      Stats
+}
+
+// NewGateway returns a new Gateway. Its epoch starts an hour before now, as
+// if it had been up for an hour.
+func NewGateway(c Config) *Gateway {
+	g := &Gateway{
+		conf:       c,
+		logf:       c.Logf,
+		now:        c.Now,
+		externalIP: cmp.Or(c.ExternalIP, DefaultExternalIP),
+		quirks:     c.Quirks,
+		mappings:   make(map[uint16]*Mapping),
+		nextPort:   firstDynamicPort,
+		pinholes:   make(map[netip.AddrPort]*Mapping),
+		nextID:     1,
+	}
+	if g.logf == nil {
+		g.logf = logger.Discard
+	}
+	if g.now == nil {
+		g.now = time.Now
+	}
+	g.epochStart = g.now().Add(-time.Hour)
+	return g
+}
+
+// SetExternalIP changes the Gateway's WAN address, as after the ISP assigns
+// a new one. Existing mappings move to the new address.
+func (g *Gateway) SetExternalIP(ip netip.Addr) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.externalIP = ip
+	for _, m := range g.mappings {
+		m.External = netip.AddrPortFrom(ip, m.External.Port())
+	}
+}
+
+// SetQuirks changes the ways in which the Gateway misbehaves.
+func (g *Gateway) SetQuirks(q Quirks) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.quirks = q
+}
+
+// Reboot simulates the Gateway restarting: it forgets all its mappings and
+// pinholes and its NAT-PMP and PCP epoch starts over, so clients that notice the epoch
+// going backwards know to recreate their mappings.
+func (g *Gateway) Reboot() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.epochStart = g.now()
+	g.deleteMappingsLocked()
+}
+
+// ExpireMappings makes the Gateway forget all its mappings and pinholes
+// without changing its epoch, as gateways that silently drop mappings before their lifetime
+// is up do.
+func (g *Gateway) ExpireMappings() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.deleteMappingsLocked()
+}
+
+func (g *Gateway) deleteMappingsLocked() {
+	for port, m := range g.mappings {
+		g.unmapLocked(port, m)
+	}
+	clear(g.pinholes)
+}
+
+// Mappings returns the Gateway's unexpired mappings, sorted by external port.
+func (g *Gateway) Mappings() []Mapping {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expireLocked()
+	ret := make([]Mapping, 0, len(g.mappings))
+	for _, m := range g.mappings {
+		ret = append(ret, *m)
+	}
+	slices.SortFunc(ret, func(a, b Mapping) int {
+		return cmp.Compare(a.External.Port(), b.External.Port())
+	})
+	return ret
+}
+
+// Pinholes returns the Gateway's unexpired IPv6 firewall pinholes, sorted by
+// internal address.
+func (g *Gateway) Pinholes() []Mapping {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expireLocked()
+	ret := make([]Mapping, 0, len(g.pinholes))
+	for _, m := range g.pinholes {
+		ret = append(ret, *m)
+	}
+	slices.SortFunc(ret, func(a, b Mapping) int {
+		return a.Internal.Compare(b.Internal)
+	})
+	return ret
+}
+
+// Stats returns the counts of requests the Gateway got.
+func (g *Gateway) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
+
+// epochLocked returns the seconds since the start of the Gateway's epoch.
+func (g *Gateway) epochLocked() uint32 {
+	return uint32(g.now().Sub(g.epochStart) / time.Second)
+}
+
+// reportedIPLocked returns the external address to report to clients.
+func (g *Gateway) reportedIPLocked() netip.Addr {
+	return cmp.Or(g.quirks.ReportedExternalIP, g.externalIP)
+}
+
+// lifetimeLocked returns the lifetime to grant for a requested one.
+func (g *Gateway) lifetimeLocked(want time.Duration) time.Duration {
+	if max := g.quirks.MaxLifetime; max > 0 && (want == 0 || want > max) {
+		return max
+	}
+	return want
+}
+
+func (g *Gateway) expireLocked() {
+	now := g.now()
+	for port, m := range g.mappings {
+		if !m.Expires.IsZero() && !now.Before(m.Expires) {
+			g.unmapLocked(port, m)
+		}
+	}
+	for internal, m := range g.pinholes {
+		if !m.Expires.IsZero() && !now.Before(m.Expires) {
+			delete(g.pinholes, internal)
+		}
+	}
+}
+
+func (g *Gateway) unmapLocked(port uint16, m *Mapping) {
+	delete(g.mappings, port)
+	if g.conf.MapPort != nil {
+		g.conf.MapPort(m.Internal, port, 0)
+	}
+}
+
+// mapLocked creates or renews a mapping to internal, preferring the external
+// port wantExternal. A zero lifetime means a permanent mapping. If
+// wantExternal is held by another client, mapLocked keeps the client's
+// existing port or, if pickOther, picks a free one; otherwise it fails.
+func (g *Gateway) mapLocked(proto string, internal netip.AddrPort, wantExternal uint16, lifetime time.Duration, pickOther bool) (_ *Mapping, ok bool) {
+	g.expireLocked()
+
+	oldPort, old := g.findLocked(internal)
+	port := oldPort
+	if old == nil || (wantExternal != 0 && wantExternal != oldPort) {
+		switch {
+		case g.portFreeLocked(wantExternal, internal):
+			port = wantExternal
+		case !pickOther:
+			return nil, false
+		case old == nil:
+			port = g.freePortLocked()
+		}
+	}
+	if old != nil && port != oldPort {
+		g.unmapLocked(oldPort, old)
+	}
+	if g.conf.MapPort != nil {
+		port, ok = g.conf.MapPort(internal, port, cmp.Or(lifetime, 24*time.Hour))
+		if !ok {
+			return nil, false
+		}
+	}
+	m := &Mapping{
+		Proto:    proto,
+		Internal: internal,
+		External: netip.AddrPortFrom(g.externalIP, port),
+	}
+	if lifetime > 0 {
+		m.Expires = g.now().Add(lifetime)
+	}
+	g.mappings[port] = m
+	g.logf("portmappertest: %s mapping %v -> %v for %v", proto, m.External, internal, lifetime)
+	return m, true
+}
+
+// portFreeLocked reports whether the external port can be mapped to
+// internal.
+func (g *Gateway) portFreeLocked(port uint16, internal netip.AddrPort) bool {
+	if port == 0 {
+		return false
+	}
+	m, ok := g.mappings[port]
+	return !ok || m.Internal == internal
+}
+
+// findLocked returns the mapping to internal, if any.
+func (g *Gateway) findLocked(internal netip.AddrPort) (port uint16, m *Mapping) {
+	for port, m := range g.mappings {
+		if m.Internal == internal {
+			return port, m
+		}
+	}
+	return 0, nil
+}
+
+func (g *Gateway) freePortLocked() uint16 {
+	for {
+		port := g.nextPort
+		g.nextPort++
+		if g.nextPort == 0 {
+			g.nextPort = firstDynamicPort
+		}
+		if _, ok := g.mappings[port]; !ok {
+			return port
+		}
+	}
+}
+
+// deleteLocked deletes the mapping to internal, if any.
+func (g *Gateway) deleteLocked(internal netip.AddrPort) {
+	if port, m := g.findLocked(internal); m != nil {
+		g.unmapLocked(port, m)
+	}
+}
+
+// pinholeLocked opens or renews an IPv6 firewall pinhole to internal. A zero
+// lifetime means a permanent pinhole.
+func (g *Gateway) pinholeLocked(proto string, internal netip.AddrPort, lifetime time.Duration) *Mapping {
+	g.expireLocked()
+	m, ok := g.pinholes[internal]
+	if !ok {
+		m = &Mapping{
+			Proto:    proto,
+			Internal: internal,
+			External: internal,
+		}
+		if proto == "upnp6" {
+			m.ID = g.nextID
+			g.nextID++
+		}
+		g.pinholes[internal] = m
+	}
+	m.Expires = time.Time{}
+	if lifetime > 0 {
+		m.Expires = g.now().Add(lifetime)
+	}
+	g.logf("portmappertest: %s pinhole to %v for %v", proto, internal, lifetime)
+	return m
+}
+
+// HandlePxP handles a NAT-PMP or PCP request from src and returns the
+// response to send back to src, or nil for none.
+func (g *Gateway) HandlePxP(src netip.AddrPort, pkt []byte) []byte {
+	if len(pkt) < 2 {
+		g.mu.Lock()
+		g.stats.PxPOther++
+		g.mu.Unlock()
+		return nil
+	}
+	switch pkt[0] {
+	case pmpVersion:
+		return g.handlePMP(src, pkt)
+	case pcpVersion:
+		return g.handlePCP(src, pkt)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.PxPOther++
+	if !g.conf.PMP && !g.conf.PCP {
+		return nil
+	}
+	// RFC 6887 section 9: reply with the highest version we support.
+	if g.conf.PCP {
+		return g.pcpHeaderLocked(pkt[1], PCPCodeUnsuppVersion, 0)
+	}
+	return g.pmpHeaderLocked(pkt[1], PMPCodeUnsupportedVersion)
+}
+
+// pmpHeaderLocked returns the common header of a NAT-PMP response to op.
+func (g *Gateway) pmpHeaderLocked(op byte, code uint16) []byte {
+	res := make([]byte, 0, 16)
+	res = append(res, pmpVersion, op|opReply)
+	res = binary.BigEndian.AppendUint16(res, code)
+	return binary.BigEndian.AppendUint32(res, g.epochLocked())
+}
+
+func (g *Gateway) handlePMP(src netip.AddrPort, pkt []byte) []byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	op := pkt[1]
+	switch {
+	case op == pmpOpMapPublicAddr && len(pkt) == 2:
+		g.stats.PMPPublicAddr++
+	case (op == pmpOpMapUDP || op == pmpOpMapTCP) && len(pkt) == 12:
+		g.stats.PMPMap++
+	default:
+		g.stats.PxPOther++
+		if !g.conf.PMP {
+			return nil
+		}
+		return g.pmpHeaderLocked(op, PMPCodeUnsupportedOpcode)
+	}
+	if !g.conf.PMP {
+		return nil
+	}
+	if code := g.quirks.PMPResultCode; code != 0 {
+		return g.pmpHeaderLocked(op, code)
+	}
+
+	if op == pmpOpMapPublicAddr {
+		ip := g.reportedIPLocked().As4()
+		return append(g.pmpHeaderLocked(op, PMPCodeOK), ip[:]...)
+	}
+
+	internal := netip.AddrPortFrom(src.Addr(), binary.BigEndian.Uint16(pkt[4:6]))
+	wantExternal := binary.BigEndian.Uint16(pkt[6:8])
+	lifetime := time.Duration(binary.BigEndian.Uint32(pkt[8:12])) * time.Second
+	var gotPort uint16
+	if lifetime == 0 {
+		// RFC 6886 section 3.4: a zero lifetime deletes the mapping,
+		// and the response has zero external port and lifetime.
+		g.deleteLocked(internal)
+	} else {
+		lifetime = g.lifetimeLocked(lifetime)
+		m, ok := g.mapLocked("pmp", internal, wantExternal, lifetime, true)
+		if !ok {
+			return g.pmpHeaderLocked(op, PMPCodeOutOfResources)
+		}
+		gotPort = m.External.Port()
+	}
+	res := g.pmpHeaderLocked(op, PMPCodeOK)
+	res = binary.BigEndian.AppendUint16(res, internal.Port())
+	res = binary.BigEndian.AppendUint16(res, gotPort)
+	return binary.BigEndian.AppendUint32(res, uint32(lifetime/time.Second))
+}
+
+// pcpHeaderLocked returns the common header of a PCP response to op.
+func (g *Gateway) pcpHeaderLocked(op byte, code uint8, lifetime time.Duration) []byte {
+	res := make([]byte, 24, 60)
+	res[0] = pcpVersion
+	res[1] = op | opReply
+	res[3] = code
+	binary.BigEndian.PutUint32(res[4:8], uint32(lifetime/time.Second))
+	binary.BigEndian.PutUint32(res[8:12], g.epochLocked())
+	return res
+}
+
+func (g *Gateway) handlePCP(src netip.AddrPort, pkt []byte) []byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	op := pkt[1] &^ opReply
+	switch {
+	case op == pcpOpAnnounce && len(pkt) >= 24:
+		g.stats.PCPAnnounce++
+	case op == pcpOpMap && len(pkt) >= 60:
+		g.stats.PCPMap++
+	default:
+		g.stats.PxPOther++
+		if !g.conf.PCP {
+			return nil
+		}
+		if len(pkt) < 24 {
+			return g.pcpHeaderLocked(op, PCPCodeMalformedRequest, 0)
+		}
+		return g.pcpHeaderLocked(op, PCPCodeUnsuppOpcode, 0)
+	}
+	if !g.conf.PCP {
+		return nil
+	}
+	if code := g.quirks.PCPResultCode; code != 0 {
+		res := g.pcpHeaderLocked(op, code, 0)
+		if op == pcpOpMap {
+			res = append(res, pkt[24:60]...)
+		}
+		return res
+	}
+	if op == pcpOpAnnounce {
+		return g.pcpHeaderLocked(op, PCPCodeOK, 0)
+	}
+
+	req := pkt[24:60]
+	res := append(g.pcpHeaderLocked(op, PCPCodeOK, 0), req...)
+	switch req[12] {
+	case pcpProtoUDP, pcpProtoTCP:
+	default:
+		res[3] = PCPCodeUnsuppProtocol
+		return res
+	}
+	internal := netip.AddrPortFrom(src.Addr(), binary.BigEndian.Uint16(req[16:18]))
+	wantExternal := binary.BigEndian.Uint16(req[18:20])
+	lifetime := time.Duration(binary.BigEndian.Uint32(pkt[4:8])) * time.Second
+	if internal.Addr().Is6() {
+		return g.handlePCPPinholeLocked(res, internal, lifetime)
+	}
+	if lifetime == 0 {
+		g.deleteLocked(internal)
+		return res
+	}
+	lifetime = g.lifetimeLocked(lifetime)
+	m, ok := g.mapLocked("pcp", internal, wantExternal, lifetime, true)
+	if !ok {
+		res[3] = PCPCodeNoResources
+		return res
+	}
+	binary.BigEndian.PutUint32(res[4:8], uint32(lifetime/time.Second))
+	resMap := res[24:]
+	binary.BigEndian.PutUint16(resMap[18:20], m.External.Port())
+	ip := g.reportedIPLocked().As16()
+	copy(resMap[20:36], ip[:])
+	return res
+}
+
+// handlePCPPinholeLocked serves a PCP MAP request from an IPv6 client, whose
+// response so far is res, by opening a firewall pinhole to internal.
+func (g *Gateway) handlePCPPinholeLocked(res []byte, internal netip.AddrPort, lifetime time.Duration) []byte {
+	if !g.conf.IPv6Firewall {
+		res[3] = PCPCodeNotAuthorized
+		return res
+	}
+	if lifetime == 0 {
+		delete(g.pinholes, internal)
+		return res
+	}
+	lifetime = g.lifetimeLocked(lifetime)
+	g.pinholeLocked("pcp6", internal, lifetime)
+	binary.BigEndian.PutUint32(res[4:8], uint32(lifetime/time.Second))
+	resMap := res[24:]
+	binary.BigEndian.PutUint16(resMap[18:20], internal.Port())
+	ip := internal.Addr().As16()
+	copy(resMap[20:36], ip[:])
+	return res
+}
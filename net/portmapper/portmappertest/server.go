@@ -0,0 +1,114 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmappertest
+
+import (
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+
+	"tailscale.com/net/netaddr"
+)
+
+// Server runs a Gateway on localhost: NAT-PMP and PCP on one UDP port, UPnP
+// discovery on another, and the UPnP HTTP server on a TCP port. If the
+// Gateway is an IPv6 firewall, it also serves PCP on the same UDP port of
+// the IPv6 loopback address.
+type Server struct {
+	*Gateway
+
+	pxpConn  net.PacketConn // for NAT-PMP and PCP
+	pxpConn6 net.PacketConn // for PCP over IPv6, or nil
+	upnpConn net.PacketConn // for UPnP discovery
+	ts       *httptest.Server
+	closed   atomic.Bool
+}
+
+// NewServer starts a Gateway configured by c on localhost. The caller must
+// Close it when done.
+func NewServer(c Config) (*Server, error) {
+	s := &Server{Gateway: NewGateway(c)}
+	var err error
+	if s.pxpConn, s.pxpConn6, err = listenPxP(c.IPv6Firewall); err != nil {
+		return nil, err
+	}
+	if s.upnpConn, err = net.ListenPacket("udp4", "127.0.0.1:0"); err != nil {
+		s.pxpConn.Close()
+		if s.pxpConn6 != nil {
+			s.pxpConn6.Close()
+		}
+		return nil, err
+	}
+	s.ts = httptest.NewServer(s.Gateway)
+	go s.serve(s.pxpConn, func(src net.Addr, pkt []byte) []byte {
+		return s.HandlePxP(netaddr.Unmap(src.(*net.UDPAddr).AddrPort()), pkt)
+	})
+	if s.pxpConn6 != nil {
+		go s.serve(s.pxpConn6, func(src net.Addr, pkt []byte) []byte {
+			return s.HandlePxP(src.(*net.UDPAddr).AddrPort(), pkt)
+		})
+	}
+	go s.serve(s.upnpConn, func(_ net.Addr, pkt []byte) []byte {
+		return s.HandleSSDP(pkt, s.ts.URL)
+	})
+	return s, nil
+}
+
+// listenPxP listens for NAT-PMP and PCP on 127.0.0.1 and, if v6, on the
+// same port of ::1, since clients under test use a single port number for
+// both address families.
+func listenPxP(v6 bool) (pc4, pc6 net.PacketConn, err error) {
+	for range 10 {
+		pc4, err = net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil || !v6 {
+			return pc4, nil, err
+		}
+		port := pc4.LocalAddr().(*net.UDPAddr).Port
+		pc6, err = net.ListenPacket("udp6", net.JoinHostPort("::1", strconv.Itoa(port)))
+		if err == nil {
+			return pc4, pc6, nil
+		}
+		pc4.Close() // port taken on ::1, or no IPv6 at all; retry
+	}
+	return nil, nil, err
+}
+
+// PxPPort returns the UDP port on which s serves NAT-PMP and PCP.
+func (s *Server) PxPPort() uint16 {
+	return uint16(s.pxpConn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// UPnPPort returns the UDP port on which s serves UPnP discovery.
+func (s *Server) UPnPPort() uint16 {
+	return uint16(s.upnpConn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// Close stops s.
+func (s *Server) Close() error {
+	s.closed.Store(true)
+	s.ts.Close()
+	s.upnpConn.Close()
+	if s.pxpConn6 != nil {
+		s.pxpConn6.Close()
+	}
+	return s.pxpConn.Close()
+}
+
+// serve replies to the packets on pc with handle until pc is closed.
+func (s *Server) serve(pc net.PacketConn, handle func(src net.Addr, pkt []byte) []byte) {
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			if !s.closed.Load() {
+				s.logf("portmappertest: %v", err)
+			}
+			return
+		}
+		if res := handle(src, buf[:n]); res != nil {
+			pc.WriteTo(res, src)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmappertest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// rootDescPath, controlPath and firewallControlPath are the HTTP paths at
+// which the Gateway serves its UPnP root device description, its
+// WANIPConnection service and its WANIPv6FirewallControl service.
+const (
+	rootDescPath        = "/rootDesc.xml"
+	controlPath         = "/ctl/IPConn"
+	firewallControlPath = "/ctl/IP6FCtl"
+)
+
+const firewallServiceType = "urn:schemas-upnp-org:service:WANIPv6FirewallControl:1"
+
+// HandleSSDP handles a UPnP discovery (SSDP M-SEARCH) request and returns
+// the response to send back, or nil for none. baseURL is the URL of the
+// Gateway's HTTP server (see ServeHTTP), such as "http://192.168.1.1:5000".
+func (g *Gateway) HandleSSDP(pkt []byte, baseURL string) []byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.SSDP++
+	if !g.conf.UPnP || !bytes.HasPrefix(pkt, []byte("M-SEARCH ")) || !bytes.Contains(pkt, []byte("ssdp:discover")) {
+		return nil
+	}
+	dev := g.deviceType()
+	return fmt.Appendf(nil, "HTTP/1.1 200 OK\r\n"+
+		"CACHE-CONTROL: max-age=120\r\n"+
+		"ST: %s\r\n"+
+		"USN: uuid:1974e83b-6dc7-4635-92b3-6a85a4037294::%s\r\n"+
+		"EXT:\r\n"+
+		"SERVER: Tailscale-Test/1.0 UPnP/1.1 MiniUPnPd/2.2.1\r\n"+
+		"LOCATION: %s%s\r\n"+
+		"OPT: \"http://schemas.upnp.org/upnp/1/0/\"; ns=01\r\n"+
+		"01-NLS: 1627958564\r\n"+
+		"BOOTID.UPNP.ORG: 1627958564\r\n"+
+		"CONFIGID.UPNP.ORG: 1337\r\n"+
+		"\r\n", dev, dev, baseURL, rootDescPath)
+}
+
+func (g *Gateway) deviceType() string {
+	if g.conf.UPnPv2 {
+		return "urn:schemas-upnp-org:device:InternetGatewayDevice:2"
+	}
+	return "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+}
+
+func (g *Gateway) serviceType() string {
+	if g.conf.UPnPv2 {
+		return "urn:schemas-upnp-org:service:WANIPConnection:2"
+	}
+	return "urn:schemas-upnp-org:service:WANIPConnection:1"
+}
+
+// ServeHTTP serves the Gateway's UPnP device description and SOAP control
+// endpoint. The HTTP server must be reachable at the baseURL passed to
+// HandleSSDP.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !g.conf.UPnP {
+		http.NotFound(w, r)
+		return
+	}
+	hasFirewall := g.conf.UPnPv2 && g.conf.IPv6Firewall
+	switch r.URL.Path {
+	case rootDescPath:
+		var firewallService string
+		if hasFirewall {
+			firewallService = fmt.Sprintf(firewallServiceTemplate, firewallServiceType, firewallControlPath)
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, rootDescTemplate, g.deviceType(), g.serviceType(), controlPath, firewallService)
+	case controlPath:
+		g.serveControl(w, r)
+	case firewallControlPath:
+		if !hasFirewall {
+			http.NotFound(w, r)
+			return
+		}
+		g.serveFirewallControl(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// soapRequest is a SOAP request to the WANIPConnection service. Only the
+// arguments the Gateway uses are decoded.
+type soapRequest struct {
+	Body struct {
+		Action struct {
+			XMLName        xml.Name
+			ExternalPort   string `xml:"NewExternalPort"`
+			Protocol       string `xml:"NewProtocol"`
+			InternalPort   string `xml:"NewInternalPort"`
+			InternalClient string `xml:"NewInternalClient"`
+			LeaseDuration  string `xml:"NewLeaseDuration"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+func (g *Gateway) serveControl(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req soapRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	a := &req.Body.Action
+	action := a.XMLName.Local
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.SOAP++
+	g.logf("portmappertest: UPnP %s", action)
+
+	var args []string // alternating response argument names and values
+	switch action {
+	case "GetStatusInfo":
+		status := g.quirks.UPnPConnectionStatus
+		if status == "" {
+			status = "Connected"
+		}
+		uptime := strconv.Itoa(int(g.epochLocked()))
+		args = []string{"NewConnectionStatus", status, "NewLastConnectionError", "ERROR_NONE", "NewUptime", uptime}
+	case "GetExternalIPAddress":
+		args = []string{"NewExternalIPAddress", g.reportedIPLocked().String()}
+	case "AddPortMapping", "AddAnyPortMapping":
+		g.stats.AddPortMap++
+		port, code := g.addPortMappingLocked(a.Protocol, a.InternalClient, a.InternalPort, a.ExternalPort, a.LeaseDuration, action == "AddAnyPortMapping")
+		if code != 0 {
+			writeSOAPFault(w, code)
+			return
+		}
+		if action == "AddAnyPortMapping" {
+			args = []string{"NewReservedPort", strconv.Itoa(int(port))}
+		}
+	case "DeletePortMapping":
+		port, err := strconv.ParseUint(a.ExternalPort, 10, 16)
+		if err != nil {
+			writeSOAPFault(w, UPnPErrInvalidArgs)
+			return
+		}
+		m, ok := g.mappings[uint16(port)]
+		if !ok {
+			writeSOAPFault(w, UPnPErrNoSuchEntryInArray)
+			return
+		}
+		g.unmapLocked(uint16(port), m)
+	default:
+		writeSOAPFault(w, UPnPErrInvalidAction)
+		return
+	}
+
+	writeSOAPResponse(w, g.serviceType(), action, args)
+}
+
+// firewallRequest is a SOAP request to the WANIPv6FirewallControl service.
+// Only the arguments the Gateway uses are decoded.
+type firewallRequest struct {
+	Body struct {
+		Action struct {
+			XMLName        xml.Name
+			InternalClient string `xml:"InternalClient"`
+			InternalPort   string `xml:"InternalPort"`
+			Protocol       string `xml:"Protocol"`
+			LeaseTime      string `xml:"LeaseTime"`
+			NewLeaseTime   string `xml:"NewLeaseTime"`
+			UniqueID       string `xml:"UniqueID"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+func (g *Gateway) serveFirewallControl(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req firewallRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	a := &req.Body.Action
+	action := a.XMLName.Local
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.SOAP++
+	g.logf("portmappertest: UPnP %s", action)
+
+	var args []string // alternating response argument names and values
+	switch action {
+	case "GetFirewallStatus":
+		args = []string{"FirewallEnabled", "1", "InboundPinholeAllowed", "1"}
+		if g.quirks.UPnPPinholesDisallowed {
+			args[3] = "0"
+		}
+	case "AddPinhole":
+		g.stats.AddPinhole++
+		if g.quirks.UPnPPinholesDisallowed {
+			writeSOAPFault(w, UPnPErrInboundPinholeNotAllowed)
+			return
+		}
+		ip, err1 := netip.ParseAddr(a.InternalClient)
+		port, err2 := strconv.ParseUint(a.InternalPort, 10, 16)
+		proto, err3 := strconv.ParseUint(a.Protocol, 10, 16)
+		lease, err4 := strconv.ParseUint(a.LeaseTime, 10, 32)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || !ip.Is6() || port == 0 || lease == 0 || lease > 86400 {
+			writeSOAPFault(w, UPnPErrInvalidArgs)
+			return
+		}
+		if proto != pcpProtoUDP && proto != pcpProtoTCP {
+			writeSOAPFault(w, UPnPErrProtocolNotSupported)
+			return
+		}
+		lifetime := g.lifetimeLocked(time.Duration(lease) * time.Second)
+		m := g.pinholeLocked("upnp6", netip.AddrPortFrom(ip, uint16(port)), lifetime)
+		args = []string{"UniqueID", strconv.Itoa(int(m.ID))}
+	case "UpdatePinhole", "DeletePinhole":
+		g.expireLocked()
+		m := g.pinholeByIDLocked(a.UniqueID)
+		if m == nil {
+			writeSOAPFault(w, UPnPErrNoSuchEntry)
+			return
+		}
+		if action == "DeletePinhole" {
+			delete(g.pinholes, m.Internal)
+			break
+		}
+		lease, err := strconv.ParseUint(a.NewLeaseTime, 10, 32)
+		if err != nil || lease == 0 || lease > 86400 {
+			writeSOAPFault(w, UPnPErrInvalidArgs)
+			return
+		}
+		g.pinholeLocked("upnp6", m.Internal, g.lifetimeLocked(time.Duration(lease)*time.Second))
+	default:
+		writeSOAPFault(w, UPnPErrInvalidAction)
+		return
+	}
+	writeSOAPResponse(w, firewallServiceType, action, args)
+}
+
+// pinholeByIDLocked returns the UPnP pinhole with the UniqueID id, or nil.
+func (g *Gateway) pinholeByIDLocked(id string) *Mapping {
+	n, err := strconv.ParseUint(id, 10, 16)
+	if err != nil {
+		return nil
+	}
+	for _, m := range g.pinholes {
+		if m.Proto == "upnp6" && m.ID == uint16(n) {
+			return m
+		}
+	}
+	return nil
+}
+
+// writeSOAPResponse writes the response to a SOAP action of the service, with
+// args alternating response argument names and values.
+func writeSOAPResponse(w http.ResponseWriter, service, action string, args []string) {
+	var resp bytes.Buffer
+	fmt.Fprintf(&resp, "<u:%sResponse xmlns:u=\"%s\">", action, service)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&resp, "<%s>%s</%s>", args[i], html.EscapeString(args[i+1]), args[i])
+	}
+	fmt.Fprintf(&resp, "</u:%sResponse>", action)
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, soapEnvelopeTemplate, resp.Bytes())
+}
+
+// addPortMappingLocked serves AddPortMapping or, if anyPort,
+// AddAnyPortMapping. It returns the external port mapped, or a UPnP error
+// code.
+func (g *Gateway) addPortMappingLocked(proto, client, internalPort, externalPort, lease string, anyPort bool) (port uint16, errCode int) {
+	if code := g.quirks.UPnPAddPortMappingError; code != 0 {
+		return 0, code
+	}
+	ip, err := netip.ParseAddr(client)
+	if err != nil || (proto != "UDP" && proto != "TCP") {
+		return 0, UPnPErrInvalidArgs
+	}
+	iport, err1 := strconv.ParseUint(internalPort, 10, 16)
+	eport, err2 := strconv.ParseUint(externalPort, 10, 16)
+	leaseSec, err3 := strconv.ParseUint(lease, 10, 32)
+	if err1 != nil || err2 != nil || err3 != nil || iport == 0 {
+		return 0, UPnPErrInvalidArgs
+	}
+	if leaseSec != 0 && g.quirks.UPnPLeaseError != 0 {
+		return 0, g.quirks.UPnPLeaseError
+	}
+	if eport == 0 && !anyPort {
+		// A wildcard external port would forward every unmapped port;
+		// don't bother modeling that.
+		return 0, UPnPErrActionNotAuthorized
+	}
+	internal := netip.AddrPortFrom(ip, uint16(iport))
+	lifetime := g.lifetimeLocked(time.Duration(leaseSec) * time.Second)
+	m, ok := g.mapLocked("upnp", internal, uint16(eport), lifetime, anyPort)
+	if !ok {
+		return 0, UPnPErrConflictInMappingEntry
+	}
+	return m.External.Port(), 0
+}
+
+// writeSOAPFault writes a SOAP fault for the UPnP error code.
+func writeSOAPFault(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, soapEnvelopeTemplate, fmt.Sprintf(soapFaultTemplate, code, upnpErrorDescription(code)))
+}
+
+func upnpErrorDescription(code int) string {
+	switch code {
+	case UPnPErrInvalidAction:
+		return "Invalid Action"
+	case UPnPErrInvalidArgs:
+		return "Invalid Args"
+	case UPnPErrActionFailed:
+		return "Action Failed"
+	case UPnPErrActionNotAuthorized:
+		return "Action not authorized"
+	case UPnPErrNoSuchEntryInArray:
+		return "NoSuchEntryInArray"
+	case UPnPErrConflictInMappingEntry:
+		return "ConflictInMappingEntry"
+	case UPnPErrOnlyPermanentLeasesSupported:
+		return "OnlyPermanentLeasesSupported"
+	case UPnPErrFirewallDisabled:
+		return "FirewallDisabled"
+	case UPnPErrInboundPinholeNotAllowed:
+		return "InboundPinholeNotAllowed"
+	case UPnPErrNoSuchEntry:
+		return "NoSuchEntry"
+	case UPnPErrProtocolNotSupported:
+		return "ProtocolNotSupported"
+	}
+	return "UPnPError"
+}
+
+const soapEnvelopeTemplate = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>%s</s:Body>
+</s:Envelope>
+`
+
+const soapFaultTemplate = `<s:Fault>
+      <faultcode>s:Client</faultcode>
+      <faultstring>UPnPError</faultstring>
+      <detail>
+        <UPnPError xmlns="urn:schemas-upnp-org:control-1-0">
+          <errorCode>%d</errorCode>
+          <errorDescription>%s</errorDescription>
+        </UPnPError>
+      </detail>
+    </s:Fault>`
+
+// rootDescTemplate is the root device description, with the device type,
+// the service type, the control URL and any further services to fill in.
+const rootDescTemplate = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0" configId="1337">
+  <specVersion>
+    <major>1</major>
+    <minor>1</minor>
+  </specVersion>
+  <device>
+    <deviceType>%s</deviceType>
+    <friendlyName>Tailscale Test Gateway</friendlyName>
+    <manufacturer>Tailscale</manufacturer>
+    <modelName>portmappertest</modelName>
+    <UDN>uuid:1974e83b-6dc7-4635-92b3-6a85a4037294</UDN>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <friendlyName>WANDevice</friendlyName>
+        <manufacturer>Tailscale</manufacturer>
+        <modelName>WAN Device</modelName>
+        <UDN>uuid:1974e83b-6dc7-4635-92b3-6a85a4037295</UDN>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <friendlyName>WANConnectionDevice</friendlyName>
+            <manufacturer>Tailscale</manufacturer>
+            <modelName>WAN Connection Device</modelName>
+            <UDN>uuid:1974e83b-6dc7-4635-92b3-6a85a4037296</UDN>
+            <serviceList>
+              <service>
+                <serviceType>%s</serviceType>
+                <serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+                <SCPDURL>/WANIPCn.xml</SCPDURL>
+                <controlURL>%s</controlURL>
+                <eventSubURL>/evt/IPConn</eventSubURL>
+              </service>%s
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>
+`
+
+// firewallServiceTemplate is the service description of the
+// WANIPv6FirewallControl service, with the service type and the control URL
+// to fill in.
+const firewallServiceTemplate = `
+              <service>
+                <serviceType>%s</serviceType>
+                <serviceId>urn:upnp-org:serviceId:WANIPv6Firewall1</serviceId>
+                <SCPDURL>/WANIP6FC.xml</SCPDURL>
+                <controlURL>%s</controlURL>
+                <eventSubURL>/evt/IP6FCtl</eventSubURL>
+              </service>`
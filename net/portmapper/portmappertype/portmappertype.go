@@ -75,6 +75,16 @@ type Client interface {
 	// map UDP traffic
 	SetLocalPort(localPort uint16)
 
+	// GetCachedPinholeOrStartCreatingOne is like
+	// GetCachedMappingOrStartCreatingOne, but for an IPv6 firewall pinhole
+	// to the port set by SetLocalPort6. The returned address is the
+	// IPv6 address and port at which the pinhole can be reached.
+	GetCachedPinholeOrStartCreatingOne() (external netip.AddrPort, ok bool)
+
+	// SetLocalPort6 updates the local IPv6 UDP port number for which we
+	// want to open a firewall pinhole. Zero means no pinhole is wanted.
+	SetLocalPort6(localPort uint16)
+
 	Close() error
 }
 
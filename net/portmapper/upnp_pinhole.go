@@ -0,0 +1,164 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+package portmapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/huin/goupnp/dcps/internetgateway2"
+)
+
+// References:
+//
+// WANIPv6FirewallControl v1: http://upnp.org/specs/gw/UPnP-gw-WANIPv6FirewallControl-v1-Service.pdf
+
+// upnpPinholeLeaseSec is the lease time we ask for UPnP pinholes. The
+// service caps it at 86400.
+const upnpPinholeLeaseSec = pcpMapLifetimeSec
+
+// upnpProtocolNumberUDP is the IANA protocol number of UDP, which the
+// WANIPv6FirewallControl service uses instead of the "UDP" string of
+// WANIPConnection.
+const upnpProtocolNumberUDP = 17
+
+// upnpPinholeClient is the subset of the WANIPv6FirewallControl service we
+// use to open pinholes, implemented by goupnp's generated client.
+type upnpPinholeClient interface {
+	GetFirewallStatusCtx(ctx context.Context) (firewallEnabled, inboundPinholeAllowed bool, err error)
+	AddPinholeCtx(ctx context.Context, remoteHost string, remotePort uint16, internalClient string, internalPort uint16, protocol uint16, leaseTime uint32) (uniqueID uint16, err error)
+	UpdatePinholeCtx(ctx context.Context, uniqueID uint16, newLeaseTime uint32) error
+	DeletePinholeCtx(ctx context.Context, uniqueID uint16) error
+}
+
+var _ upnpPinholeClient = (*internetgateway2.WANIPv6FirewallControl1)(nil)
+
+// upnpPinhole is an IPv6 firewall pinhole opened over UPnP. After being
+// created it is immutable.
+type upnpPinhole struct {
+	external   netip.AddrPort // also the internal address
+	uniqueID   uint16
+	goodUntil  time.Time
+	renewAfter time.Time
+
+	// loc is the location of the UPnP root device the pinhole came from.
+	loc *url.URL
+	// client is the UPnP client used to open the pinhole, and is used to
+	// renew and release it.
+	client upnpPinholeClient
+}
+
+func (u *upnpPinhole) MappingType() string      { return "upnp6" }
+func (u *upnpPinhole) GoodUntil() time.Time     { return u.goodUntil }
+func (u *upnpPinhole) RenewAfter() time.Time    { return u.renewAfter }
+func (u *upnpPinhole) External() netip.AddrPort { return u.external }
+func (u *upnpPinhole) MappingDebug() string {
+	return fmt.Sprintf("upnpPinhole{external:%v, id:%d, renewAfter:%d, goodUntil:%d, loc:%q}",
+		u.external, u.uniqueID,
+		u.renewAfter.Unix(), u.goodUntil.Unix(),
+		u.loc)
+}
+func (u *upnpPinhole) Release(ctx context.Context) {
+	u.client.DeletePinholeCtx(ctx, u.uniqueID)
+}
+
+// withLease returns a copy of u leased for d from now.
+func (u *upnpPinhole) withLease(d time.Duration) *upnpPinhole {
+	now := time.Now()
+	u2 := *u
+	u2.goodUntil = now.Add(d)
+	u2.renewAfter = now.Add(d / 2)
+	return &u2
+}
+
+// getUPnPPinhole attempts to open or renew a pinhole to internal with the
+// WANIPv6FirewallControl service of a UPnP gateway found by Probe. old is
+// the current pinhole, if any. It returns (nil, nil) if there's no such
+// service.
+//
+// UPnP gateways are discovered over IPv4 only, even for IPv6 pinholes.
+func (c *Client) getUPnPPinhole(ctx context.Context, internal netip.AddrPort, old mapping) (mapping, error) {
+	if disableUPnpEnv() || c.debug.DisableUPnP() {
+		return nil, nil
+	}
+
+	if old, ok := old.(*upnpPinhole); ok && old.external == internal {
+		err := old.client.UpdatePinholeCtx(ctx, old.uniqueID, upnpPinholeLeaseSec)
+		if err == nil {
+			return old.withLease(upnpPinholeLeaseSec * time.Second), nil
+		}
+		c.vlogf("UpdatePinhole: id=%d err=%v; opening a new pinhole", old.uniqueID, err)
+	}
+
+	c.mu.Lock()
+	gw := c.lastGW
+	metas := c.uPnPMetas
+	ctx = upnpHTTPClientKey.WithValue(ctx, c.upnpHTTPClientLocked())
+	c.mu.Unlock()
+	if !gw.IsValid() {
+		return nil, nil
+	}
+
+	var errs []error
+	for _, meta := range metas {
+		rootDev, loc, err := getUPnPRootDevice(ctx, c.logf, c.debug, gw, meta)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if rootDev == nil {
+			continue
+		}
+		clients, _ := internetgateway2.NewWANIPv6FirewallControl1ClientsFromRootDevice(rootDev, loc)
+		for _, client := range clients {
+			p, err := c.tryUPnPPinhole(ctx, client, internal)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			p.loc = loc
+			return p, nil
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// tryUPnPPinhole opens a pinhole to internal with client.
+func (c *Client) tryUPnPPinhole(ctx context.Context, client upnpPinholeClient, internal netip.AddrPort) (*upnpPinhole, error) {
+	enabled, allowed, err := client.GetFirewallStatusCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		// Nothing to punch a hole in; the address is already reachable
+		// as far as this gateway is concerned.
+		return nil, errors.New("UPnP IPv6 firewall is disabled")
+	}
+	if !allowed {
+		return nil, errors.New("UPnP IPv6 firewall doesn't allow inbound pinholes")
+	}
+
+	// An empty remote host and a zero remote port are wildcards, letting
+	// any peer in.
+	id, err := client.AddPinholeCtx(ctx, "", 0, internal.Addr().String(), internal.Port(), upnpProtocolNumberUDP, upnpPinholeLeaseSec)
+	c.vlogf("AddPinhole: id=%d err=%v", id, err)
+	if err != nil {
+		if code, ok := getUPnPErrorCode(err); ok {
+			getUPnPErrorsMetric(code).Add(1)
+		}
+		return nil, err
+	}
+	p := &upnpPinhole{
+		external: internal,
+		uniqueID: id,
+		client:   client,
+	}
+	return p.withLease(upnpPinholeLeaseSec * time.Second), nil
+}
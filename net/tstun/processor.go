@@ -0,0 +1,142 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"fmt"
+	"slices"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/wgengine/filter"
+)
+
+// Direction is the direction of a packet through a Wrapper.
+type Direction int
+
+const (
+	Inbound  Direction = iota // from a peer to the local host
+	Outbound                  // from the local host to a peer
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "inbound"
+	case Outbound:
+		return "outbound"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+// PacketProcessor processes a packet passing through a Wrapper. It returns
+// filter.Accept to pass the packet on, or filter.Drop or
+// filter.DropSilently to drop it.
+//
+// A PacketProcessor may modify the packet's bytes, p.Buffer(), in place,
+// but not its length. It's then responsible for fixing up the checksums,
+// and for calling p.Decode(p.Buffer()) if it changed any headers, so later
+// processors see the changes.
+//
+// It runs synchronously on the packet's path, so must be fast, and must
+// not retain p, as its storage is reused.
+type PacketProcessor func(p *packet.Parsed) filter.Response
+
+// packetProcessor is a registered PacketProcessor. Its pointer identifies
+// it for removal.
+type packetProcessor struct {
+	fn PacketProcessor
+}
+
+// AddPacketProcessor adds fn to the processors of the packets flowing
+// through t in direction dir. Processors run in the order they were added,
+// until one drops the packet. It returns a function that removes fn.
+//
+// Inbound processors see packets received from peers that the packet
+// filter accepted, after destination NAT, before they're delivered to the
+// local host or netstack. Outbound processors see packets sent to peers,
+// after the packet filter and source NAT, including those injected with
+// InjectOutbound. Packets injected with InjectPacket skip the processors.
+//
+// Custom builds of tailscaled can add processors with the
+// feature/packetprocessor package.
+func (t *Wrapper) AddPacketProcessor(dir Direction, fn PacketProcessor) (remove func()) {
+	procs := &t.processors[dir]
+	pp := &packetProcessor{fn: fn}
+
+	t.processorsMu.Lock()
+	defer t.processorsMu.Unlock()
+	procs.Store(append(slices.Clip(procs.Load()), pp))
+	return func() {
+		t.processorsMu.Lock()
+		defer t.processorsMu.Unlock()
+		procs.Store(slices.DeleteFunc(slices.Clone(procs.Load()), func(e *packetProcessor) bool {
+			return e == pp
+		}))
+	}
+}
+
+// runPacketProcessors runs procs on p, returning the verdict of the first
+// one to drop it, or filter.Accept.
+func runPacketProcessors(procs []*packetProcessor, p *packet.Parsed) filter.Response {
+	for _, pp := range procs {
+		if r := pp.fn(p); r.IsDrop() {
+			return r
+		}
+	}
+	return filter.Accept
+}
+
+// InjectPacket injects pkt as if it were received from a peer (Inbound)
+// or sent by the local host (Outbound). Inbound packets are delivered to
+// netstack, if it handles them, or else to the local host. The packet
+// filter and packet processors don't see pkt.
+//
+// It doesn't block, but takes ownership of pkt. Injecting an empty packet
+// is a no-op.
+func (t *Wrapper) InjectPacket(dir Direction, pkt []byte) error {
+	switch dir {
+	case Inbound:
+		return t.injectInboundPostFilter(pkt)
+	case Outbound:
+		if len(pkt) > MaxPacketSize {
+			return errPacketTooBig
+		}
+		if len(pkt) == 0 {
+			return nil
+		}
+		t.injectOutbound(tunInjectedRead{data: pkt, skipProcessors: true})
+		return nil
+	default:
+		return fmt.Errorf("invalid direction %v", dir)
+	}
+}
+
+// injectInboundPostFilter injects pkt inbound, running the hooks that
+// follow the packet filter, such as delivery to netstack.
+func (t *Wrapper) injectInboundPostFilter(pkt []byte) error {
+	if len(pkt) > MaxPacketSize {
+		return errPacketTooBig
+	}
+	if len(pkt) == 0 {
+		return nil
+	}
+	buf := make([]byte, PacketStartOffset+len(pkt))
+	copy(buf[PacketStartOffset:], pkt)
+
+	if t.PostFilterPacketInboundFromWireGuard != nil {
+		p := parsedPacketPool.Get().(*packet.Parsed)
+		defer parsedPacketPool.Put(p)
+		p.Decode(buf[PacketStartOffset:])
+		res, gro := t.PostFilterPacketInboundFromWireGuard(p, t, nil)
+		if gro != nil {
+			gro.Flush()
+		}
+		if res.IsDrop() {
+			// Handled, such as by netstack.
+			return nil
+		}
+	}
+	return t.InjectInboundDirect(buf, PacketStartOffset)
+}
@@ -216,6 +216,15 @@ type Wrapper struct {
 
 	captureHook syncs.AtomicValue[packet.CaptureCallback]
 
+	// filterTraceHook, if non-nil, is called with each packet the
+	// packet filter is run on. See InstallFilterTraceHook.
+	filterTraceHook syncs.AtomicValue[FilterTraceFunc]
+
+	// processors are the packet processors added by AddPacketProcessor,
+	// by Direction. processorsMu serializes their modification.
+	processorsMu sync.Mutex
+	processors   [2]syncs.AtomicValue[[]*packetProcessor]
+
 	metrics *metrics
 
 	eventClient              *eventbus.Client
@@ -240,6 +249,10 @@ type tunInjectedRead struct {
 	// precedence.
 	packet *netstack_PacketBuffer
 	data   []byte
+
+	// skipProcessors is whether the packet bypasses the outbound
+	// packet processors, as it was injected with InjectPacket.
+	skipProcessors bool
 }
 
 // tunVectorReadResult is the result of a tun.Read(), or an injected packet
@@ -886,6 +899,10 @@ func (t *Wrapper) filterPacketOutboundToWireGuard(p *packet.Parsed, pc *peerConf
 		}
 	}
 
+	if hook := t.filterTraceHook.Load(); hook != nil {
+		hook(p, false)
+	}
+
 	// If the outbound packet is to a jailed peer, use our jailed peer
 	// packet filter.
 	var filt *filter.Filter
@@ -966,6 +983,7 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 	defer parsedPacketPool.Put(p)
 	captHook := t.captureHook.Load()
 	pc := t.peerConfig.Load()
+	procs := t.processors[Outbound].Load()
 	var buffsGRO *gro.GRO
 	for _, data := range res.data {
 		p.Decode(data[res.dataOffset:])
@@ -997,6 +1015,13 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 		// Make sure to do SNAT after filtering, so that any flow tracking in
 		// the filter sees the original source address. See #12133.
 		pc.snat(p)
+		if len(procs) > 0 {
+			if res := runPacketProcessors(procs, p); res.IsDrop() {
+				metricPacketOutDrop.Add(1)
+				metricPacketOutDropProcessor.Add(1)
+				continue
+			}
+		}
 		n := copy(buffs[buffsPos][offset:], p.Buffer())
 		if n != len(data)-res.dataOffset {
 			panic(fmt.Sprintf("short copy: %d != %d", n, len(data)-res.dataOffset))
@@ -1126,6 +1151,26 @@ func (t *Wrapper) injectedRead(res tunInjectedRead, outBuffs [][]byte, sizes []i
 		n, err = tun.GSOSplit(pkt, gsoOptions, outBuffs, sizes, offset)
 	}
 
+	if procs := t.processors[Outbound].Load(); len(procs) > 0 && !res.skipProcessors {
+		// Process each packet after GSO splitting, as it'll be sent,
+		// compacting outBuffs over any dropped.
+		kept := 0
+		for i := range n {
+			b := outBuffs[i][offset : offset+sizes[i]]
+			p.Decode(b)
+			if r := runPacketProcessors(procs, p); r.IsDrop() {
+				metricPacketOutDrop.Add(1)
+				metricPacketOutDropProcessor.Add(1)
+				continue
+			}
+			if kept != i {
+				sizes[kept] = copy(outBuffs[kept][offset:], b)
+			}
+			kept++
+		}
+		n = kept
+	}
+
 	if buildfeatures.HasNetLog {
 		if update := t.connCounter.Load(); update != nil {
 			for i := 0; i < n; i++ {
@@ -1194,6 +1239,10 @@ func (t *Wrapper) filterPacketInboundFromWireGuard(p *packet.Parsed, captHook pa
 		}
 	}
 
+	if hook := t.filterTraceHook.Load(); hook != nil {
+		hook(p, true)
+	}
+
 	var filt *filter.Filter
 	if pc.inboundPacketIsJailed(p) {
 		filt = t.jailedFilter.Load()
@@ -1207,13 +1256,8 @@ func (t *Wrapper) filterPacketInboundFromWireGuard(p *packet.Parsed, captHook pa
 
 	// Let peerapi through the filter; its ACLs are handled at L7,
 	// not at the packet level.
-	if outcome != filter.Accept &&
-		p.IPProto == ipproto.TCP &&
-		p.TCPFlags&packet.TCPSyn != 0 &&
-		t.PeerAPIPort != nil {
-		if port, ok := t.PeerAPIPort(p.Dst.Addr()); ok && port == p.Dst.Port() {
-			outcome = filter.Accept
-		}
+	if outcome != filter.Accept && t.isPeerAPISyn(p) {
+		outcome = filter.Accept
 	}
 
 	if outcome != filter.Accept {
@@ -1247,6 +1291,13 @@ func (t *Wrapper) filterPacketInboundFromWireGuard(p *packet.Parsed, captHook pa
 		return filter.Drop, gro
 	}
 
+	if procs := t.processors[Inbound].Load(); len(procs) > 0 {
+		if res := runPacketProcessors(procs, p); res.IsDrop() {
+			metricPacketInDropProcessor.Add(1)
+			return res, gro
+		}
+	}
+
 	if t.PostFilterPacketInboundFromWireGuardAppConnector != nil {
 		if res := t.PostFilterPacketInboundFromWireGuardAppConnector(p, t); res.IsDrop() {
 			// Handled by userspaceEngine's configured hook for Connectors 2025 app connectors.
@@ -1326,6 +1377,72 @@ func (t *Wrapper) tdevWrite(buffs [][]byte, offset int) (int, error) {
 	return t.tdev.Write(buffs, offset)
 }
 
+// isPeerAPISyn reports whether p is a TCP SYN to this node's PeerAPI.
+func (t *Wrapper) isPeerAPISyn(p *packet.Parsed) bool {
+	if p.IPProto != ipproto.TCP || p.TCPFlags&packet.TCPSyn == 0 || t.PeerAPIPort == nil {
+		return false
+	}
+	port, ok := t.PeerAPIPort(p.Dst.Addr())
+	return ok && port == p.Dst.Port()
+}
+
+// TraceFilter reports how the packet filter treats p, which is from a peer
+// if inbound is true, or to a peer otherwise. Like the data path, it uses
+// the jailed filter for jailed peers and lets inbound PeerAPI connections
+// through.
+func (t *Wrapper) TraceFilter(p *packet.Parsed, inbound bool) filter.Trace {
+	pc := t.peerConfig.Load()
+	var jailed bool
+	if inbound {
+		jailed = pc.inboundPacketIsJailed(p)
+	} else {
+		jailed = pc.outboundPacketIsJailed(p)
+	}
+	filt := t.filter.Load()
+	if jailed {
+		filt = t.jailedFilter.Load()
+	}
+
+	var tr filter.Trace
+	switch {
+	case filt == nil:
+		tr = filter.Trace{
+			Dir:     "out",
+			Packet:  p.String(),
+			Verdict: filter.Drop.String(),
+			Reason:  "no filter",
+			Rule:    -1,
+		}
+		if inbound {
+			tr.Dir = "in"
+		}
+	case inbound:
+		tr = filt.TraceIn(p)
+	default:
+		tr = filt.TraceOut(p)
+	}
+	tr.Jailed = jailed
+	if inbound && filt != nil && tr.Verdict != filter.Accept.String() && t.isPeerAPISyn(p) {
+		tr.Verdict = filter.Accept.String()
+		tr.PeerAPI = true
+	}
+	return tr
+}
+
+// FilterTraceFunc is called with a packet the packet filter is about to
+// run on, which is from a peer if inbound is true, or to a peer otherwise.
+// It's called synchronously on the data path, so must be fast, and must
+// not retain p.
+type FilterTraceFunc func(p *packet.Parsed, inbound bool)
+
+// InstallFilterTraceHook sets the function called with each packet the
+// packet filter runs on, replacing any previous one. A nil fn removes it.
+//
+// It's meant for sampling live traffic to pass to TraceFilter.
+func (t *Wrapper) InstallFilterTraceHook(fn FilterTraceFunc) {
+	t.filterTraceHook.Store(fn)
+}
+
 func (t *Wrapper) GetFilter() *filter.Filter {
 	return t.filter.Load()
 }
@@ -1552,11 +1669,13 @@ func (t *Wrapper) SetConnectionCounter(fn netlogfunc.ConnectionCounter) {
 	metricPacketInDrop          = clientmetric.NewCounter("tstun_in_from_wg_drop")
 	metricPacketInDropFilter    = clientmetric.NewCounter("tstun_in_from_wg_drop_filter")
 	metricPacketInDropSelfDisco = clientmetric.NewCounter("tstun_in_from_wg_drop_self_disco")
+	metricPacketInDropProcessor = clientmetric.NewCounter("tstun_in_from_wg_drop_processor")
 
 	metricPacketOut              = clientmetric.NewCounter("tstun_out_to_wg")
 	metricPacketOutDrop          = clientmetric.NewCounter("tstun_out_to_wg_drop")
 	metricPacketOutDropFilter    = clientmetric.NewCounter("tstun_out_to_wg_drop_filter")
 	metricPacketOutDropSelfDisco = clientmetric.NewCounter("tstun_out_to_wg_drop_self_disco")
+	metricPacketOutDropProcessor = clientmetric.NewCounter("tstun_out_to_wg_drop_processor")
 )
 
 func (t *Wrapper) InstallCaptureHook(cb packet.CaptureCallback) {
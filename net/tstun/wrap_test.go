@@ -11,6 +11,7 @@
 	"fmt"
 	"net/netip"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
@@ -26,6 +27,7 @@
 	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"tailscale.com/disco"
+	"tailscale.com/net/ipset"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/packet"
 	"tailscale.com/tstest"
@@ -601,6 +603,101 @@ func TestPeerAPIBypass(t *testing.T) {
 	}
 }
 
+func TestTraceFilter(t *testing.T) {
+	reg := new(usermetric.Registry)
+	w := &Wrapper{
+		PeerAPIPort: func(ip netip.Addr) (port uint16, ok bool) {
+			if ip == netip.MustParseAddr("100.64.1.2") {
+				return 60000, true
+			}
+			return
+		},
+		metrics:             registerMetrics(reg),
+		disableTSMPRejected: true,
+		logf:                t.Logf,
+	}
+	var localNets netipx.IPSetBuilder
+	localNets.AddPrefix(netip.MustParsePrefix("100.64.1.2/32"))
+	localNetsSet, _ := localNets.IPSet()
+	w.SetFilter(filter.New([]filter.Match{{
+		IPProto:      views.SliceOf([]ipproto.Proto{ipproto.TCP}),
+		Srcs:         nets("1.2.3.4"),
+		SrcsContains: ipset.NewContainsIPFunc(views.SliceOf(nets("1.2.3.4"))),
+		Dsts:         netports("100.64.1.2:22"),
+	}}, nil, localNetsSet, nil, nil, t.Logf))
+	w.SetJailedFilter(filter.NewShieldsUpFilter(localNetsSet, nil, nil, t.Logf))
+	w.SetWGConfig(&wgcfg.Config{
+		Addresses: nets("100.64.1.2"),
+		Peers: []wgcfg.Peer{{
+			AllowedIPs: nets("1.2.3.5"),
+			IsJailed:   true,
+		}},
+	})
+
+	var hooked []string
+	w.InstallFilterTraceHook(func(p *packet.Parsed, inbound bool) {
+		tr := w.TraceFilter(p, inbound)
+		hooked = append(hooked, tr.Verdict)
+	})
+
+	tests := []struct {
+		name       string
+		pkt        []byte
+		wantRule   int
+		wantJailed bool
+		wantPeer   bool // PeerAPI
+		want       filter.Response
+	}{
+		{
+			name:     "accept_rule",
+			pkt:      tcp4syn("1.2.3.4", "100.64.1.2", 1234, 22),
+			wantRule: 0,
+			want:     filter.Accept,
+		},
+		{
+			name:     "drop",
+			pkt:      tcp4syn("1.2.3.4", "100.64.1.2", 1234, 23),
+			wantRule: -1,
+			want:     filter.Drop,
+		},
+		{
+			name:     "peerapi",
+			pkt:      tcp4syn("1.2.3.6", "100.64.1.2", 1234, 60000),
+			wantRule: -1,
+			wantPeer: true,
+			want:     filter.Accept,
+		},
+		{
+			name:       "jailed",
+			pkt:        tcp4syn("1.2.3.5", "100.64.1.2", 1234, 22),
+			wantRule:   -1,
+			wantJailed: true,
+			want:       filter.Drop,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hooked = nil
+			p := new(packet.Parsed)
+			p.Decode(tt.pkt)
+			tr := w.TraceFilter(p, true)
+			if tr.Verdict != tt.want.String() || tr.Rule != tt.wantRule || tr.Jailed != tt.wantJailed || tr.PeerAPI != tt.wantPeer {
+				t.Errorf("TraceFilter = %+v; want verdict %v, rule %d, jailed %v, peerapi %v", tr, tt.want, tt.wantRule, tt.wantJailed, tt.wantPeer)
+			}
+			if tr.Jailed != tr.ShieldsUp {
+				t.Errorf("TraceFilter used the wrong filter: %+v", tr)
+			}
+			got, _ := w.filterPacketInboundFromWireGuard(p, nil, w.peerConfig.Load(), nil)
+			if got != tt.want {
+				t.Errorf("filterPacketInboundFromWireGuard = %v; want %v", got, tt.want)
+			}
+			if want := []string{tt.want.String()}; !slices.Equal(hooked, want) {
+				t.Errorf("trace hook saw %q; want %q", hooked, want)
+			}
+		})
+	}
+}
+
 // Issue 1526: drop disco frames from ourselves.
 func TestFilterDiscoLoop(t *testing.T) {
 	var memLog tstest.MemLogger
@@ -1056,3 +1153,93 @@ func TestInterceptOrdering(t *testing.T) {
 		t.Errorf("got number of intercepts run in Read(): %d; want: %d", seq, numOutboundIntercepts)
 	}
 }
+
+func TestPacketProcessors(t *testing.T) {
+	bus := eventbustest.NewBus(t)
+	_, tun := newFakeTUN(t.Logf, bus, true)
+	defer tun.Close()
+	tun.Start()
+
+	// Stand in for netstack, which takes inbound packets after the filter.
+	var delivered []string
+	tun.PostFilterPacketInboundFromWireGuard = func(p *packet.Parsed, _ *Wrapper, g *gro.GRO) (filter.Response, *gro.GRO) {
+		delivered = append(delivered, fmt.Sprintf("%v %s", p.Dst, p.Payload()))
+		return filter.DropSilently, g
+	}
+
+	var order []string
+	dropPort := func(port uint16) PacketProcessor {
+		return func(p *packet.Parsed) filter.Response {
+			order = append(order, fmt.Sprintf("drop %d", port))
+			if p.Dst.Port() == port || p.Src.Port() == port {
+				return filter.Drop
+			}
+			return filter.Accept
+		}
+	}
+	uppercase := func(p *packet.Parsed) filter.Response {
+		order = append(order, "uppercase")
+		b := p.Payload()
+		copy(b, bytes.ToUpper(b))
+		return filter.Accept
+	}
+	removeUpper := tun.AddPacketProcessor(Inbound, uppercase)
+	removeDrop := tun.AddPacketProcessor(Inbound, dropPort(90))
+	tun.AddPacketProcessor(Outbound, dropPort(98))
+
+	write := func(pkt []byte) {
+		t.Helper()
+		if _, err := tun.Write([][]byte{pkt}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(udp4("5.6.7.8", "1.2.3.4", 89, 89))
+	write(udp4("5.6.7.8", "1.2.3.4", 90, 90))
+	write(udp4("5.6.7.8", "1.2.3.4", 22, 22)) // dropped by the filter first
+	if want := []string{"1.2.3.4:89 UDP_PAYLOAD"}; !slices.Equal(delivered, want) {
+		t.Errorf("delivered = %q; want %q", delivered, want)
+	}
+	if want := []string{"uppercase", "drop 90", "uppercase", "drop 90"}; !slices.Equal(order, want) {
+		t.Errorf("processors ran in order %q; want %q", order, want)
+	}
+
+	// Injected inbound packets skip the processors.
+	delivered, order = nil, nil
+	if err := tun.InjectPacket(Inbound, udp4("5.6.7.8", "1.2.3.4", 90, 90)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.2.3.4:90 udp_payload"}; !slices.Equal(delivered, want) || len(order) != 0 {
+		t.Errorf("injected: delivered = %q after processors %q; want %q with none", delivered, order, want)
+	}
+
+	removeUpper()
+	removeDrop()
+	delivered, order = nil, nil
+	write(udp4("5.6.7.8", "1.2.3.4", 90, 90))
+	if want := []string{"1.2.3.4:90 udp_payload"}; !slices.Equal(delivered, want) || len(order) != 0 {
+		t.Errorf("after removal: delivered = %q after processors %q; want %q with none", delivered, order, want)
+	}
+
+	read := func() int {
+		t.Helper()
+		var buf [MaxPacketSize]byte
+		sizes := make([]int, 1)
+		n, err := tun.Read([][]byte{buf[:]}, sizes, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	tun.InjectOutbound(udp4("1.2.3.4", "5.6.7.8", 98, 98))
+	if n := read(); n != 0 {
+		t.Errorf("read %d packets; want outbound processor to drop it", n)
+	}
+	tun.InjectOutbound(udp4("1.2.3.4", "5.6.7.8", 97, 97))
+	if n := read(); n != 1 {
+		t.Errorf("read %d packets; want 1", n)
+	}
+	tun.InjectPacket(Outbound, udp4("1.2.3.4", "5.6.7.8", 98, 98))
+	if n := read(); n != 1 {
+		t.Errorf("read %d injected packets; want 1, skipping processors", n)
+	}
+}
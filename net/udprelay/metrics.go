@@ -23,6 +23,12 @@
 	cMetricForwarded64Bytes = clientmetric.NewAggregateCounter("udprelay_forwarded_bytes_udp6_udp4")
 	cMetricForwarded66Bytes = clientmetric.NewAggregateCounter("udprelay_forwarded_bytes_udp6_udp6")
 
+	// cMetricRejectedAllocations counts endpoint allocations rejected by
+	// [Policy], and cMetricRateLimitedPackets packets dropped by its rate
+	// limit.
+	cMetricRejectedAllocations = clientmetric.NewCounter("udprelay_rejected_allocations")
+	cMetricRateLimitedPackets  = clientmetric.NewCounter("udprelay_rate_limited_packets")
+
 	// cMetricEndpoints is initialized here with no other writes, making it safe for concurrent reads.
 	//
 	// [clientmetric.Gauge] does not let us embed existing counters, so
@@ -0,0 +1,162 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"tailscale.com/net/udprelay/status"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+)
+
+// allocationLogSize is how many allocation events a [Server] remembers.
+const allocationLogSize = 256
+
+// Policy is a [Server]'s local policy for the endpoints it allocates, on top
+// of control's authorization of which clients may use it. The zero Policy
+// imposes no limits.
+type Policy struct {
+	// AllowClient, if non-nil, reports whether the client with the given
+	// disco key may be part of an endpoint, returning an error saying why
+	// not if it may not.
+	AllowClient func(key.DiscoPublic) error
+	// MaxEndpointsPerClient is how many endpoints a client, by disco key,
+	// can be part of at once. Zero means no limit.
+	MaxEndpointsPerClient int
+	// MaxBytesPerSecond is the rate at which each endpoint forwards bytes
+	// from each of its clients. Packets over it are dropped. Zero means no
+	// limit.
+	MaxBytesPerSecond int
+	// IdleTimeout is how long a bound endpoint lasts without packets from
+	// one of its clients, and is advertised to clients as its steady state
+	// lifetime. Zero means the default of 5 minutes.
+	IdleTimeout time.Duration
+}
+
+var (
+	// ErrClientNotAllowed is returned by [Server.AllocateEndpoint] when
+	// [Policy.AllowClient] rejects one of the clients.
+	ErrClientNotAllowed = errors.New("client not allowed by peer relay policy")
+	// ErrClientQuotaExceeded is returned by [Server.AllocateEndpoint] when
+	// one of the clients is already part of [Policy.MaxEndpointsPerClient]
+	// endpoints.
+	ErrClientQuotaExceeded = errors.New("client endpoint quota exceeded")
+)
+
+// SetPolicy sets the policy for endpoints allocated from now on. Endpoints
+// already allocated keep the policy they were allocated under.
+func (s *Server) SetPolicy(p Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+}
+
+// checkAllowedClients returns an error wrapping [ErrClientNotAllowed] if p
+// doesn't allow both clients of pair.
+func (p Policy) checkAllowedClients(pair key.SortedPairOfDiscoPublic) error {
+	if p.AllowClient == nil {
+		return nil
+	}
+	for _, d := range pair.Get() {
+		if err := p.AllowClient(d); err != nil {
+			return fmt.Errorf("%w: %v: %v", ErrClientNotAllowed, d.ShortString(), err)
+		}
+	}
+	return nil
+}
+
+// checkClientQuotasLocked returns an error wrapping [ErrClientQuotaExceeded]
+// if either client of pair is already part of as many endpoints as the
+// policy allows.
+//
+// s.mu must be held.
+func (s *Server) checkClientQuotasLocked(pair key.SortedPairOfDiscoPublic) error {
+	limit := s.policy.MaxEndpointsPerClient
+	if limit <= 0 {
+		return nil
+	}
+	// Like endpointGC, scan all endpoints and keep it simple for now.
+	var n [2]int
+	for other := range s.serverEndpointByDisco {
+		for i, d := range pair.Get() {
+			if o := other.Get(); o[0] == d || o[1] == d {
+				n[i]++
+			}
+		}
+	}
+	for i, d := range pair.Get() {
+		if n[i] >= limit {
+			return fmt.Errorf("%w: %v is part of %d endpoints", ErrClientQuotaExceeded, d.ShortString(), n[i])
+		}
+	}
+	return nil
+}
+
+// noteAllocationEvent records ev in s's allocation log, and logs it.
+func (s *Server) noteAllocationEvent(ev status.AllocationEvent) {
+	ev.When = time.Now()
+	s.allocLog.Add(ev)
+	s.logf("allocation log: event=%s vni=%d disco[0]=%v disco[1]=%v reason=%q packets=%d bytes=%d",
+		ev.Event, ev.VNI, ev.ShortDisco[0], ev.ShortDisco[1], ev.Reason, ev.Packets, ev.Bytes)
+}
+
+// GetAllocationLog returns the recent allocation events, oldest first.
+func (s *Server) GetAllocationLog() []status.AllocationEvent {
+	return s.allocLog.GetAll()
+}
+
+// allocationEvent returns an allocation log event about e.
+func (e *serverEndpoint) allocationEvent(event, reason string) status.AllocationEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ev := status.AllocationEvent{
+		Event:  event,
+		VNI:    e.vni,
+		Reason: reason,
+	}
+	for i, d := range e.discoPubKeys.Get() {
+		ev.ShortDisco[i] = d.ShortString()
+		ev.Packets += e.packetsRx[i]
+		ev.Bytes += e.bytesRx[i]
+	}
+	return ev
+}
+
+// expiryReason returns why e, which has expired, did.
+func (e *serverEndpoint) expiryReason(bindLifetime, steadyStateLifetime time.Duration) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.isBoundLocked() {
+		return fmt.Sprintf("clients didn't bind within %v", bindLifetime)
+	}
+	return fmt.Sprintf("idle for over %v", steadyStateLifetime)
+}
+
+// allowBytesLocked reports whether e's rate limit lets it forward n bytes
+// from the client at index i, taking them from its budget if so.
+//
+// e.mu must be held.
+func (e *serverEndpoint) allowBytesLocked(i, n int, now mono.Time) bool {
+	if e.maxBytesPerSecond <= 0 {
+		return true
+	}
+	rate := float64(e.maxBytesPerSecond)
+	// Allow bursts of a second's worth, but at least of a full-sized
+	// packet.
+	burst := max(rate, 1<<16)
+	if e.budgetAt[i].IsZero() {
+		e.budget[i] = burst
+	} else {
+		e.budget[i] = min(burst, e.budget[i]+now.Sub(e.budgetAt[i]).Seconds()*rate)
+	}
+	e.budgetAt[i] = now
+	if e.budget[i] < float64(n) {
+		return false
+	}
+	e.budget[i] -= float64(n)
+	return true
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
+	"tailscale.com/util/usermetric"
+)
+
+func TestServer_policy(t *testing.T) {
+	discoA := key.NewDisco().Public()
+	discoB := key.NewDisco().Public()
+	discoC := key.NewDisco().Public()
+	denied := key.NewDisco().Public()
+
+	deregisterMetrics()
+	server, err := NewServer(t.Logf, 0, true, new(usermetric.Registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.SetStaticAddrPorts(views.SliceOf([]netip.AddrPort{
+		netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), server.uc4Port),
+	}))
+	server.SetPolicy(Policy{
+		AllowClient: func(d key.DiscoPublic) error {
+			if d == denied {
+				return errors.New("denied")
+			}
+			return nil
+		},
+		MaxEndpointsPerClient: 1,
+		IdleTimeout:           time.Minute,
+	})
+
+	ep, err := server.AllocateEndpoint(discoA, discoB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep.SteadyStateLifetime.Duration != time.Minute {
+		t.Errorf("SteadyStateLifetime = %v, want %v", ep.SteadyStateLifetime.Duration, time.Minute)
+	}
+	// Reallocating the same pair doesn't count against the quota.
+	if _, err := server.AllocateEndpoint(discoA, discoB); err != nil {
+		t.Errorf("reallocating: %v", err)
+	}
+	if _, err := server.AllocateEndpoint(discoA, discoC); !errors.Is(err, ErrClientQuotaExceeded) {
+		t.Errorf("over quota: got err %v, want %v", err, ErrClientQuotaExceeded)
+	}
+	if _, err := server.AllocateEndpoint(discoC, denied); !errors.Is(err, ErrClientNotAllowed) {
+		t.Errorf("denied client: got err %v, want %v", err, ErrClientNotAllowed)
+	}
+
+	var got []string
+	for _, ev := range server.GetAllocationLog() {
+		got = append(got, ev.Event)
+	}
+	want := []string{"allocated", "rejected", "rejected"}
+	if len(got) != len(want) {
+		t.Fatalf("allocation log events = %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("allocation log events = %q, want %q", got, want)
+			break
+		}
+	}
+}
+
+func TestServerEndpoint_allowBytesLocked(t *testing.T) {
+	const rate = 1 << 17 // more than the minimum burst
+	e := &serverEndpoint{maxBytesPerSecond: rate}
+	now := mono.Now()
+	if !e.allowBytesLocked(0, rate, now) {
+		t.Fatal("first second's worth of bytes dropped")
+	}
+	if e.allowBytesLocked(0, 1000, now) {
+		t.Error("bytes over the burst allowed")
+	}
+	if !e.allowBytesLocked(1, 1000, now) {
+		t.Error("other client's bytes dropped")
+	}
+	if !e.allowBytesLocked(0, rate/2, now.Add(time.Second/2)) {
+		t.Error("bytes dropped after refill")
+	}
+
+	unlimited := &serverEndpoint{}
+	if !unlimited.allowBytesLocked(0, 1<<30, now) {
+		t.Error("unlimited endpoint dropped bytes")
+	}
+}
@@ -8,6 +8,7 @@
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/rand"
 	"encoding/binary"
@@ -45,6 +46,7 @@
 	"tailscale.com/types/views"
 	"tailscale.com/util/cloudinfo"
 	"tailscale.com/util/eventbus"
+	"tailscale.com/util/ringlog"
 	"tailscale.com/util/set"
 	"tailscale.com/util/usermetric"
 )
@@ -83,6 +85,7 @@ type Server struct {
 	metrics             *metrics
 	netMon              *netmon.Monitor
 	cloudInfo           *cloudinfo.CloudInfo // used to query cloud metadata services
+	allocLog            *ringlog.RingLog[status.AllocationEvent]
 
 	mu                  sync.Mutex                      // guards the following fields
 	macSecrets          views.Slice[[blake2s.Size]byte] // [0] is most recent, max 2 elements
@@ -91,6 +94,7 @@ type Server struct {
 	onlyStaticAddrPorts bool                        // no dynamic addr port discovery when set
 	staticAddrPorts     views.Slice[netip.AddrPort] // static ip:port pairs set with [Server.SetStaticAddrPorts]
 	dynamicAddrPorts    []netip.AddrPort            // dynamically discovered ip:port pairs
+	policy              Policy
 	closed              bool
 	lamportID           uint64
 	nextVNI             uint32
@@ -120,14 +124,22 @@ type serverEndpoint struct {
 	lamportID          uint64
 	vni                uint32
 	allocatedAt        mono.Time
+	// steadyStateLifetime and maxBytesPerSecond come from the [Policy] the
+	// endpoint was allocated under. Zero values mean the [Server] defaults
+	// and no limit, respectively.
+	steadyStateLifetime time.Duration
+	maxBytesPerSecond   int
 
 	mu                   sync.Mutex        // guards the following fields
 	closed               bool              // signals that no new data should be accepted
 	inProgressGeneration [2]uint32         // or zero if a handshake has never started, or has just completed
 	boundAddrPorts       [2]netip.AddrPort // or zero value if a handshake has never completed for that relay leg
 	lastSeen             [2]mono.Time
-	packetsRx            [2]uint64 // num packets received from/sent by each client after they are bound
-	bytesRx              [2]uint64 // num bytes received from/sent by each client after they are bound
+	packetsRx            [2]uint64    // num packets received from/sent by each client after they are bound
+	bytesRx              [2]uint64    // num bytes received from/sent by each client after they are bound
+	packetsDropped       [2]uint64    // num packets from each client dropped by the rate limit
+	budget               [2]float64   // bytes each client may still send under the rate limit
+	budgetAt             [2]mono.Time // when budget was last topped up
 }
 
 func blakeMACFromBindMsg(blakeKey [blake2s.Size]byte, src netip.AddrPort, msg disco.BindUDPRelayEndpointCommon) ([blake2s.Size]byte, error) {
@@ -299,11 +311,21 @@ func (e *serverEndpoint) handleDataPacket(from netip.AddrPort, b []byte, now mon
 	switch {
 	case from == e.boundAddrPorts[0]:
 		e.lastSeen[0] = now
+		if !e.allowBytesLocked(0, len(b), now) {
+			e.packetsDropped[0]++
+			cMetricRateLimitedPackets.Add(1)
+			return nil, netip.AddrPort{}
+		}
 		e.packetsRx[0]++
 		e.bytesRx[0] += uint64(len(b))
 		return b, e.boundAddrPorts[1]
 	case from == e.boundAddrPorts[1]:
 		e.lastSeen[1] = now
+		if !e.allowBytesLocked(1, len(b), now) {
+			e.packetsDropped[1]++
+			cMetricRateLimitedPackets.Add(1)
+			return nil, netip.AddrPort{}
+		}
 		e.packetsRx[1]++
 		e.bytesRx[1] += uint64(len(b))
 		return b, e.boundAddrPorts[0]
@@ -388,6 +410,7 @@ func NewServer(logf logger.Logf, port uint16, onlyStaticAddrPorts bool, metrics
 		serverEndpointByDisco: make(map[key.SortedPairOfDiscoPublic]*serverEndpoint),
 		nextVNI:               minVNI,
 		cloudInfo:             cloudinfo.New(logf),
+		allocLog:              ringlog.New[status.AllocationEvent](allocationLogSize),
 	}
 	s.discoPublic = s.disco.Public()
 	s.metrics = registerMetrics(metrics)
@@ -764,9 +787,11 @@ func (s *Server) endpointGC(bindLifetime, steadyStateLifetime time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	for k, v := range s.serverEndpointByDisco {
-		if v.maybeExpire(now, bindLifetime, steadyStateLifetime, s.metrics) {
+		lifetime := cmp.Or(v.steadyStateLifetime, steadyStateLifetime)
+		if v.maybeExpire(now, bindLifetime, lifetime, s.metrics) {
 			delete(s.serverEndpointByDisco, k)
 			s.serverEndpointByVNI.Delete(v.vni)
+			s.noteAllocationEvent(v.allocationEvent("expired", v.expiryReason(bindLifetime, lifetime)))
 		}
 	}
 }
@@ -1006,7 +1031,20 @@ func (s *Server) getAllAddrPortsCopyLocked() []netip.AddrPort {
 // the following notable errors:
 //  1. [ErrServerClosed] if the server has been closed.
 //  2. [ErrServerNotReady] if the server is not ready.
+//  3. [ErrClientNotAllowed] or [ErrClientQuotaExceeded] if the [Policy]
+//     doesn't allow the allocation.
 func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.ServerEndpoint, error) {
+	pair := key.NewSortedPairOfDiscoPublic(discoA, discoB)
+	s.mu.Lock()
+	policy := s.policy
+	s.mu.Unlock()
+	// Check the allowed clients without holding s.mu, as AllowClient may
+	// be slow.
+	if err := policy.checkAllowedClients(pair); err != nil {
+		s.noteRejection(pair, err)
+		return endpoint.ServerEndpoint{}, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.closed {
@@ -1021,7 +1059,6 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 		return endpoint.ServerEndpoint{}, fmt.Errorf("client disco equals server disco: %s", s.discoPublic.ShortString())
 	}
 
-	pair := key.NewSortedPairOfDiscoPublic(discoA, discoB)
 	e, ok := s.serverEndpointByDisco[pair]
 	if ok {
 		// Return the existing allocation. Clients can resolve duplicate
@@ -1041,10 +1078,14 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 			VNI:                 e.vni,
 			LamportID:           e.lamportID,
 			BindLifetime:        tstime.GoDuration{Duration: s.bindLifetime},
-			SteadyStateLifetime: tstime.GoDuration{Duration: s.steadyStateLifetime},
+			SteadyStateLifetime: tstime.GoDuration{Duration: cmp.Or(e.steadyStateLifetime, s.steadyStateLifetime)},
 		}, nil
 	}
 
+	if err := s.checkClientQuotasLocked(pair); err != nil {
+		s.noteRejection(pair, err)
+		return endpoint.ServerEndpoint{}, err
+	}
 	vni, err := s.getNextVNILocked()
 	if err != nil {
 		return endpoint.ServerEndpoint{}, err
@@ -1052,10 +1093,12 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 
 	s.lamportID++
 	e = &serverEndpoint{
-		discoPubKeys: pair,
-		lamportID:    s.lamportID,
-		allocatedAt:  mono.Now(),
-		vni:          vni,
+		discoPubKeys:        pair,
+		lamportID:           s.lamportID,
+		allocatedAt:         mono.Now(),
+		vni:                 vni,
+		steadyStateLifetime: cmp.Or(s.policy.IdleTimeout, s.steadyStateLifetime),
+		maxBytesPerSecond:   s.policy.MaxBytesPerSecond,
 	}
 	e.discoSharedSecrets[0] = s.disco.Shared(e.discoPubKeys.Get()[0])
 	e.discoSharedSecrets[1] = s.disco.Shared(e.discoPubKeys.Get()[1])
@@ -1063,7 +1106,7 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 	s.serverEndpointByDisco[pair] = e
 	s.serverEndpointByVNI.Store(e.vni, e)
 
-	s.logf("allocated endpoint vni=%d lamportID=%d disco[0]=%v disco[1]=%v", e.vni, e.lamportID, pair.Get()[0].ShortString(), pair.Get()[1].ShortString())
+	s.noteAllocationEvent(e.allocationEvent("allocated", ""))
 	s.metrics.updateEndpoint(endpointClosed, endpointConnecting)
 	return endpoint.ServerEndpoint{
 		ServerDisco:         s.discoPublic,
@@ -1072,10 +1115,21 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 		VNI:                 e.vni,
 		LamportID:           e.lamportID,
 		BindLifetime:        tstime.GoDuration{Duration: s.bindLifetime},
-		SteadyStateLifetime: tstime.GoDuration{Duration: s.steadyStateLifetime},
+		SteadyStateLifetime: tstime.GoDuration{Duration: e.steadyStateLifetime},
 	}, nil
 }
 
+// noteRejection records in the allocation log that an endpoint for pair
+// wasn't allocated because of err.
+func (s *Server) noteRejection(pair key.SortedPairOfDiscoPublic, err error) {
+	cMetricRejectedAllocations.Add(1)
+	ev := status.AllocationEvent{Event: "rejected", Reason: err.Error()}
+	for i, d := range pair.Get() {
+		ev.ShortDisco[i] = d.ShortString()
+	}
+	s.noteAllocationEvent(ev)
+}
+
 // extractClientInfo constructs a [status.ClientInfo] for both relay clients
 // involved in this session.
 func (e *serverEndpoint) extractClientInfo() [2]status.ClientInfo {
@@ -1087,6 +1141,7 @@ func (e *serverEndpoint) extractClientInfo() [2]status.ClientInfo {
 		ret[i].ShortDisco = e.discoPubKeys.Get()[i].ShortString()
 		ret[i].PacketsTx = e.packetsRx[i]
 		ret[i].BytesTx = e.bytesRx[i]
+		ret[i].PacketsDropped = e.packetsDropped[i]
 	}
 	return ret
 }
@@ -480,6 +480,7 @@ func TestServer_endpointGC(t *testing.T) {
 		addrs       [2]netip.AddrPort
 		lastSeen    [2]mono.Time
 		allocatedAt mono.Time
+		idleTimeout time.Duration // per-endpoint steady state lifetime, if any
 		wantRemoved bool
 	}{
 		{
@@ -510,6 +511,13 @@ func TestServer_endpointGC(t *testing.T) {
 			lastSeen:    [2]mono.Time{mono.Now(), mono.Now()},
 			wantRemoved: false,
 		},
+		{
+			name:        "bound_endpoint_idle_timeout",
+			addrs:       [2]netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:1"), netip.MustParseAddrPort("192.0.2.2:1")},
+			lastSeen:    [2]mono.Time{mono.Now(), mono.Now().Add(-2 * time.Minute)},
+			idleTimeout: time.Minute,
+			wantRemoved: true,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			disco1 := key.NewDisco()
@@ -521,8 +529,10 @@ func TestServer_endpointGC(t *testing.T) {
 				lastSeen:       tc.lastSeen,
 				boundAddrPorts: tc.addrs,
 				allocatedAt:    tc.allocatedAt,
+
+				steadyStateLifetime: tc.idleTimeout,
 			}
-			s := &Server{serverEndpointByVNI: sync.Map{}, metrics: &metrics{}}
+			s := &Server{logf: t.Logf, serverEndpointByVNI: sync.Map{}, metrics: &metrics{}}
 			mak.Set(&s.serverEndpointByDisco, pair, ep)
 			s.serverEndpointByVNI.Store(ep.vni, ep)
 			s.endpointGC(defaultBindLifetime, defaultSteadyStateLifetime)
@@ -7,6 +7,7 @@
 
 import (
 	"net/netip"
+	"time"
 )
 
 // ServerStatus contains the listening UDP port and active sessions (if any) for
@@ -21,6 +22,53 @@ type ServerStatus struct {
 	// relay session that this node's peer relay server is involved with. It
 	// may be empty.
 	Sessions []ServerSession
+	// Policy is the local policy the peer relay server applies on top of
+	// control's authorization, or nil if the server isn't running.
+	Policy *ServerPolicy
+	// Allocations is the peer relay server's recent allocation log, oldest
+	// first. It may be empty.
+	Allocations []AllocationEvent
+}
+
+// ServerPolicy is the local policy a peer relay server applies to the
+// sessions it relays, on top of control's authorization of which clients
+// may use it. Zero values impose no limit.
+//
+// It is set by tailscaled's TS_PEER_RELAY_* environment variables.
+type ServerPolicy struct {
+	// AllowClients are the clients, by Tailscale IP, tag or node name, that
+	// may use the peer relay server. If empty, any client control
+	// authorizes may.
+	AllowClients []string
+	// MaxSessionsPerClient is how many sessions a client can be part of at
+	// once.
+	MaxSessionsPerClient int
+	// MaxBytesPerSecond is the rate at which each session forwards bytes
+	// from each of its clients. Packets over it are dropped.
+	MaxBytesPerSecond int
+	// IdleTimeout is how long a session lasts without packets from one of
+	// its clients. Zero means the server's default.
+	IdleTimeout time.Duration
+}
+
+// AllocationEvent is an entry in a peer relay server's allocation log.
+type AllocationEvent struct {
+	// When is when the event happened.
+	When time.Time
+	// Event is what happened to the session: "allocated", "rejected" or
+	// "expired".
+	Event string
+	// VNI is the session's VNI, or zero if Event is "rejected".
+	VNI uint32
+	// ShortDisco are the string representations of the disco public keys
+	// of the session's two clients.
+	ShortDisco [2]string
+	// Reason is why the session was rejected or expired, or empty.
+	Reason string `json:",omitempty"`
+	// Packets and Bytes are the total overlay packets and bytes the session
+	// forwarded in both directions, as of an "expired" event.
+	Packets uint64 `json:",omitempty"`
+	Bytes   uint64 `json:",omitempty"`
 }
 
 // ClientInfo contains status-related information about a single peer relay
@@ -51,6 +99,9 @@ type ClientInfo struct {
 	// is identical to the total overlay bytes that the peer relay server has
 	// received from this client.
 	BytesTx uint64
+	// PacketsDropped is the number of packets from this peer relay client
+	// that the relay server dropped for exceeding the session's rate limit.
+	PacketsDropped uint64 `json:",omitempty"`
 }
 
 // ServerSession contains status information for a single session between two
@@ -0,0 +1,203 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux && !android
+
+package tailssh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tailscale.com/types/logger"
+)
+
+// cgroupRoot is where the unified (v2) cgroup hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// Resource-limited sessions get a cgroup in the cgroup subtree that is
+// delegated to tailscaled, e.g. by Delegate=yes in its systemd unit:
+//
+//	<tailscaled's cgroup>/
+//		tailscaled/          tailscaled's own processes
+//		ssh/
+//			<session ID>/    one per resource-limited session
+//
+// cgroup v2 doesn't allow distributing CPU and memory to children of a
+// cgroup that itself contains processes, so tailscaled moves itself into a
+// child cgroup first.
+const (
+	daemonCgroup   = "tailscaled"
+	sessionCgroups = "ssh"
+)
+
+// cpuMaxPeriod is the cgroup v2 cpu.max period, in microseconds.
+const cpuMaxPeriod = 100_000
+
+func init() {
+	prepareSessionCgroup = prepareSessionCgroupLinux
+	applySessionLimits = applySessionLimitsLinux
+	removeSessionCgroup = removeSessionCgroupLinux
+}
+
+var (
+	sessionCgroupsOnce sync.Once
+	sessionCgroupsDir  string // or empty if sessionCgroupsErr is set
+	sessionCgroupsErr  error
+)
+
+// cpuMaxValue returns the cgroup v2 cpu.max value that limits a cgroup to
+// percent of a single CPU.
+func cpuMaxValue(percent int) string {
+	return fmt.Sprintf("%d %d", percent*cpuMaxPeriod/100, cpuMaxPeriod)
+}
+
+// parseCgroupV2Path returns the path of the cgroup v2 hierarchy entry in b,
+// the contents of /proc/<pid>/cgroup.
+func parseCgroupV2Path(b []byte) (string, error) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		if p, ok := strings.CutPrefix(s.Text(), "0::"); ok && strings.HasPrefix(p, "/") {
+			return p, nil
+		}
+	}
+	return "", errors.New("not in a cgroup v2 hierarchy")
+}
+
+// setupSessionCgroups prepares the cgroup cg, relative to root, that is
+// delegated to tailscaled for session cgroups. It returns the directory in
+// which to create them.
+func setupSessionCgroups(root, cg string) (string, error) {
+	if filepath.Base(cg) == daemonCgroup {
+		// Already moved into the daemon cgroup, e.g. by a previous
+		// instance of tailscaled in the same systemd unit.
+		cg = filepath.Dir(cg)
+	}
+	if cg == "/" {
+		return "", errors.New("tailscaled is running in the root cgroup; run it in a delegated cgroup, e.g. with Delegate=yes in its systemd unit")
+	}
+	own := filepath.Join(root, cg)
+	controllers, err := os.ReadFile(filepath.Join(own, "cgroup.controllers"))
+	if err != nil {
+		return "", err
+	}
+	for _, c := range []string{"cpu", "memory"} {
+		if !slices.Contains(strings.Fields(string(controllers)), c) {
+			return "", fmt.Errorf("the %s controller is not available in tailscaled's cgroup %s; delegate it, e.g. with Delegate=yes in tailscaled's systemd unit", c, cg)
+		}
+	}
+
+	daemon := filepath.Join(own, daemonCgroup)
+	if err := os.Mkdir(daemon, 0755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("tailscaled's cgroup %s is not delegated to it: %w", cg, err)
+	}
+	procs, err := os.ReadFile(filepath.Join(own, "cgroup.procs"))
+	if err != nil {
+		return "", err
+	}
+	for _, pid := range strings.Fields(string(procs)) {
+		if err := os.WriteFile(filepath.Join(daemon, "cgroup.procs"), []byte(pid), 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("moving process %s to %s: %w", pid, daemon, err)
+		}
+	}
+
+	sessions := filepath.Join(own, sessionCgroups)
+	if err := os.Mkdir(sessions, 0755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	for _, dir := range []string{own, sessions} {
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+cpu +memory"), 0); err != nil {
+			return "", fmt.Errorf("enabling controllers in %s: %w", dir, err)
+		}
+	}
+	return sessions, nil
+}
+
+// prepareSessionCgroupLinux is the Linux implementation of
+// prepareSessionCgroup.
+func prepareSessionCgroupLinux(logf logger.Logf, sessionID string, cpuQuotaPercent int, memoryLimit int64) (string, error) {
+	sessionCgroupsOnce.Do(func() {
+		if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+			sessionCgroupsErr = errors.New("cgroup v2 is not available")
+			return
+		}
+		b, err := os.ReadFile("/proc/self/cgroup")
+		if err != nil {
+			sessionCgroupsErr = err
+			return
+		}
+		cg, err := parseCgroupV2Path(b)
+		if err != nil {
+			sessionCgroupsErr = err
+			return
+		}
+		sessionCgroupsDir, sessionCgroupsErr = setupSessionCgroups(cgroupRoot, cg)
+		if sessionCgroupsErr == nil {
+			logf("created session cgroups in %s", sessionCgroupsDir)
+		}
+	})
+	if sessionCgroupsErr != nil {
+		return "", sessionCgroupsErr
+	}
+
+	dir := filepath.Join(sessionCgroupsDir, sessionID)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := setSessionLimits(dir, cpuQuotaPercent, memoryLimit); err != nil {
+		os.Remove(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// setSessionLimits sets the cpu.max and memory.max of the cgroup in dir.
+// Zero values leave the corresponding limit unset.
+func setSessionLimits(dir string, cpuQuotaPercent int, memoryLimit int64) error {
+	if cpuQuotaPercent > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(cpuMaxValue(cpuQuotaPercent)), 0); err != nil {
+			return fmt.Errorf("setting cpu.max: %w", err)
+		}
+	}
+	if memoryLimit > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(memoryLimit, 10)), 0); err != nil {
+			return fmt.Errorf("setting memory.max: %w", err)
+		}
+	}
+	return nil
+}
+
+// applySessionLimitsLinux is the Linux implementation of applySessionLimits.
+// It moves the current process into the session cgroup that tailscaled
+// prepared.
+func applySessionLimitsLinux(dlogf logger.Logf, ia incubatorArgs) error {
+	if !ia.hasResourceLimits() {
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(ia.cgroupDir, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		return fmt.Errorf("joining cgroup: %w", err)
+	}
+	dlogf("joined session cgroup %s", ia.cgroupDir)
+	return nil
+}
+
+// removeSessionCgroupLinux is the Linux implementation of
+// removeSessionCgroup.
+//
+// Removal fails if processes started by the session are still running
+// (for example, because they were daemonized). In that case the cgroup, and
+// thus its limits, is left in place.
+func removeSessionCgroupLinux(logf logger.Logf, dir string) {
+	err := os.Remove(dir)
+	if err != nil && !os.IsNotExist(err) {
+		logf("failed to remove session cgroup: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux && !android
+
+package tailssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCPUMaxValue(t *testing.T) {
+	tests := []struct {
+		percent int
+		want    string
+	}{
+		{1, "1000 100000"},
+		{50, "50000 100000"},
+		{100, "100000 100000"},
+		{250, "250000 100000"},
+	}
+	for _, tt := range tests {
+		if got := cpuMaxValue(tt.percent); got != tt.want {
+			t.Errorf("cpuMaxValue(%d) = %q; want %q", tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestParseCgroupV2Path(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "0::/system.slice/tailscaled.service\n", want: "/system.slice/tailscaled.service"},
+		{in: "0::/\n", want: "/"},
+		{in: "12:memory:/system.slice/tailscaled.service\n0::/system.slice/tailscaled.service\n", want: "/system.slice/tailscaled.service"},
+		{in: "12:memory:/system.slice/tailscaled.service\n", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCgroupV2Path([]byte(tt.in))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseCgroupV2Path(%q) = %q, %v; want %q, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSetupSessionCgroups(t *testing.T) {
+	const cg = "/system.slice/tailscaled.service"
+	newRoot := func(t *testing.T, controllers string) string {
+		root := t.TempDir()
+		own := filepath.Join(root, cg)
+		if err := os.MkdirAll(own, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, contents := range map[string]string{
+			"cgroup.controllers":     controllers,
+			"cgroup.procs":           "123\n",
+			"cgroup.subtree_control": "",
+		} {
+			if err := os.WriteFile(filepath.Join(own, name), []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return root
+	}
+	readFile := func(t *testing.T, path string) string {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	t.Run("delegated", func(t *testing.T) {
+		root := newRoot(t, "cpuset cpu io memory pids")
+		for range 2 {
+			dir, err := setupSessionCgroups(root, cg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			own := filepath.Join(root, cg)
+			if want := filepath.Join(own, sessionCgroups); dir != want {
+				t.Errorf("dir = %q; want %q", dir, want)
+			}
+			if got := readFile(t, filepath.Join(own, daemonCgroup, "cgroup.procs")); got != "123" {
+				t.Errorf("daemon cgroup.procs = %q; want %q", got, "123")
+			}
+			for _, d := range []string{own, dir} {
+				if got := readFile(t, filepath.Join(d, "cgroup.subtree_control")); got != "+cpu +memory" {
+					t.Errorf("%s cgroup.subtree_control = %q; want %q", d, got, "+cpu +memory")
+				}
+			}
+		}
+		// The root cgroup is never touched.
+		if _, err := os.Stat(filepath.Join(root, "cgroup.subtree_control")); !os.IsNotExist(err) {
+			t.Errorf("root cgroup.subtree_control was written: %v", err)
+		}
+	})
+	t.Run("already_in_daemon_cgroup", func(t *testing.T) {
+		root := newRoot(t, "cpu memory")
+		dir, err := setupSessionCgroups(root, cg+"/"+daemonCgroup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(root, cg, sessionCgroups); dir != want {
+			t.Errorf("dir = %q; want %q", dir, want)
+		}
+	})
+	t.Run("root_cgroup", func(t *testing.T) {
+		root := newRoot(t, "cpu memory")
+		if _, err := setupSessionCgroups(root, "/"); err == nil {
+			t.Fatal("got no error for the root cgroup")
+		}
+	})
+	t.Run("not_delegated", func(t *testing.T) {
+		root := newRoot(t, "pids")
+		if _, err := setupSessionCgroups(root, cg); err == nil || !strings.Contains(err.Error(), "Delegate=yes") {
+			t.Fatalf("got error %v; want one mentioning Delegate=yes", err)
+		}
+		if _, err := os.Stat(filepath.Join(root, cg, daemonCgroup)); !os.IsNotExist(err) {
+			t.Errorf("daemon cgroup was created: %v", err)
+		}
+	})
+}
@@ -74,6 +74,15 @@ func init() {
 	return nil
 }
 
+// applySessionLimits confines the current process, and thus the processes it
+// goes on to start, to the session cgroup in ia.
+// It must be called while still running as root.
+// On platforms without support for resource limits, it does nothing.
+// See applySessionLimitsLinux.
+var applySessionLimits = func(dlogf logger.Logf, ia incubatorArgs) error {
+	return nil
+}
+
 // truePaths are the common locations to find the true binary, in likelihood order.
 var truePaths = [...]string{"/usr/bin/true", "/bin/true"}
 
@@ -213,6 +222,19 @@ func (ss *sshSession) newIncubatorCommand(logf logger.Logf) (cmd *exec.Cmd, err
 		incubatorArgs = append(incubatorArgs, "--debug-test")
 	}
 
+	if ss.hasResourceLimits() {
+		// attachSessionToConnIfNotShutdown rejected the session if
+		// prepareSessionCgroup is nil.
+		metricSessionResourceLimited.Add(1)
+		a := ss.conn.finalAction
+		dir, err := prepareSessionCgroup(logf, ss.sharedID, a.CPUQuotaPercent, a.MemoryLimitBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up session resource limits: %w", err)
+		}
+		ss.cgroupDir = dir
+		incubatorArgs = append(incubatorArgs, "--cgroup="+dir)
+	}
+
 	switch {
 	case isSFTP:
 		// Note that we include both the `--sftp` flag and a command to launch
@@ -286,6 +308,15 @@ type incubatorArgs struct {
 	debugTest          bool
 	isSELinuxEnforcing bool
 	encodedEnv         string
+
+	// cgroupDir is the cgroup that tailscaled created with the session's
+	// resource limits; see tailcfg.SSHAction.
+	cgroupDir string
+}
+
+// hasResourceLimits reports whether ia asks for CPU or memory limits.
+func (ia incubatorArgs) hasResourceLimits() bool {
+	return ia.cgroupDir != ""
 }
 
 func parseIncubatorArgs(args []string) (incubatorArgs, error) {
@@ -310,6 +341,7 @@ func parseIncubatorArgs(args []string) (incubatorArgs, error) {
 	flags.BoolVar(&ia.debugTest, "debug-test", false, "should debug in test mode")
 	flags.BoolVar(&ia.isSELinuxEnforcing, "is-selinux-enforcing", false, "whether SELinux is in enforcing mode")
 	flags.StringVar(&ia.encodedEnv, "encoded-env", "", "JSON encoded array of environment variables in '['key=value']' format")
+	flags.StringVar(&ia.cgroupDir, "cgroup", "", "the cgroup with the session's resource limits to run in")
 	flags.Parse(args)
 
 	for _, g := range strings.Split(groups, ",") {
@@ -442,6 +474,10 @@ func handleSFTPInProcess(dlogf logger.Logf, ia incubatorArgs) error {
 		defer sessionCloser()
 	}
 
+	if err := applySessionLimits(dlogf, ia); err != nil {
+		return fmt.Errorf("failed to apply session resource limits: %w", err)
+	}
+
 	if err := dropPrivileges(dlogf, ia); err != nil {
 		return err
 	}
@@ -473,18 +509,27 @@ func serveSFTP() error {
 //
 // - We are running as root
 // - This is not an SELinuxEnforcing host
+// - No resource limits were requested
 //
-// The last condition exists because if we're running on a SELinux-enabled
+// The second condition exists because if we're running on a SELinux-enabled
 // system, neiher login nor su will be able to set the correct context for the
 // shell. So, we don't bother trying to run them and instead fall back to using
 // the incubator to launch the shell.
 // See http://github.com/tailscale/tailscale/issues/4908.
+//
+// The last condition exists because PAM modules run by login and su (such as
+// pam_systemd) may move the session into a new cgroup, escaping the limits
+// that the incubator sets up.
 func shouldAttemptLoginShell(dlogf logger.Logf, ia incubatorArgs) bool {
 	if ia.forceV1Behavior && ia.isSFTP {
 		// v1 behavior did not run SFTP within a login shell.
 		dlogf("Forcing v1 behavior, won't use login shell for SFTP")
 		return false
 	}
+	if ia.hasResourceLimits() {
+		dlogf("resource limits requested, won't use login shell")
+		return false
+	}
 
 	return runningAsRoot() && !ia.isSELinuxEnforcing
 }
@@ -659,6 +704,10 @@ func handleSSHInProcess(dlogf logger.Logf, ia incubatorArgs) error {
 		defer sessionCloser()
 	}
 
+	if err := applySessionLimits(dlogf, ia); err != nil {
+		return fmt.Errorf("failed to apply session resource limits: %w", err)
+	}
+
 	if err := dropPrivileges(dlogf, ia); err != nil {
 		return err
 	}
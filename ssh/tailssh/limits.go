@@ -0,0 +1,123 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd || plan9
+
+package tailssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+// prepareSessionCgroup, if non-nil, creates a cgroup with the given CPU and
+// memory limits for the session with the given ID, and returns its
+// directory. The incubator moves itself into it.
+// It is set by platform-specific code (e.g., cgroup_linux.go).
+var prepareSessionCgroup func(logf logger.Logf, sessionID string, cpuQuotaPercent int, memoryLimit int64) (dir string, err error)
+
+// removeSessionCgroup, if non-nil, removes the session cgroup in dir that
+// prepareSessionCgroup created.
+// It is set by platform-specific code (e.g., cgroup_linux.go).
+var removeSessionCgroup func(logf logger.Logf, dir string)
+
+// sessionOwner returns the identity that sessions are counted against when
+// enforcing SSHAction.MaxSessionsPerUser: the Tailscale user, or for tagged
+// nodes (which have no meaningful user), the node itself.
+func (ci *sshConnInfo) sessionOwner() string {
+	if ci.node.IsTagged() {
+		return "node:" + string(ci.node.StableID())
+	}
+	return fmt.Sprintf("user:%d", ci.uprof.ID)
+}
+
+// numSessionsForOwnerLocked returns the number of active sessions across
+// all conns whose sessionOwner is owner.
+//
+// srv.mu must be held.
+func (srv *server) numSessionsForOwnerLocked(owner string) int {
+	n := 0
+	for c := range srv.activeConns {
+		if c.info == nil || c.info.sessionOwner() != owner {
+			continue
+		}
+		c.mu.Lock()
+		n += len(c.sessions)
+		c.mu.Unlock()
+	}
+	return n
+}
+
+// hasResourceLimits reports whether the session's SSHAction asks for
+// OS-level CPU or memory limits to be applied to its processes.
+func (ss *sshSession) hasResourceLimits() bool {
+	a := ss.conn.finalAction
+	return a.CPUQuotaPercent > 0 || a.MemoryLimitBytes > 0
+}
+
+// canLimitResources reports whether srv can enforce the CPU and memory
+// limits of an SSHAction. The limits are applied by the incubator, so
+// they're unsupported when tailscaled runs commands directly.
+func (srv *server) canLimitResources() bool {
+	return prepareSessionCgroup != nil && srv.tailscaledPath != ""
+}
+
+// removeCgroup removes the session's cgroup, if launchProcess created one.
+func (ss *sshSession) removeCgroup() {
+	if ss.cgroupDir != "" && removeSessionCgroup != nil {
+		removeSessionCgroup(ss.logf, ss.cgroupDir)
+	}
+}
+
+// touch records that there was activity on the session just now.
+func (ss *sshSession) touch() {
+	ss.lastActivity.Store(time.Now().UnixNano())
+}
+
+// trackActivity returns a writer that wraps w and marks the session as
+// active on every write. If the session has no idle timeout, it returns w
+// unmodified.
+func (ss *sshSession) trackActivity(w io.Writer) io.Writer {
+	if ss.conn.finalAction.IdleTimeout == 0 {
+		return w
+	}
+	return activityWriter{ss, w}
+}
+
+type activityWriter struct {
+	ss *sshSession
+	w  io.Writer
+}
+
+func (w activityWriter) Write(p []byte) (int, error) {
+	w.ss.touch()
+	return w.w.Write(p)
+}
+
+// enforceIdleTimeout terminates the session once it has seen no input or
+// output for d. It returns when the session is done.
+func (ss *sshSession) enforceIdleTimeout(d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ss.ctx.Done():
+			return
+		case <-t.C:
+		}
+		idle := time.Since(time.Unix(0, ss.lastActivity.Load()))
+		if idle >= d {
+			metricSessionIdleTimeout.Add(1)
+			ss.cancelCtx(userVisibleError{
+				fmt.Sprintf("Session idle timeout of %v elapsed.", d),
+				context.DeadlineExceeded,
+			})
+			return
+		}
+		t.Reset(d - idle)
+	}
+}
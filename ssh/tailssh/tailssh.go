@@ -127,16 +127,37 @@ func init() {
 // attachSessionToConnIfNotShutdown ensures that srv is not shutdown before
 // attaching the session to the conn. This ensures that once Shutdown is called,
 // new sessions are not allowed and existing ones are cleaned up.
-// It reports whether ss was attached to the conn.
-func (srv *server) attachSessionToConnIfNotShutdown(ss *sshSession) bool {
+// It also enforces the SSHAction.MaxSessionsPerUser limit, if any, and
+// rejects sessions whose SSHAction asks for resource limits that can't be
+// enforced on this host.
+//
+// It returns a nil error if ss was attached to the conn, or a
+// userVisibleError explaining why it was not.
+func (srv *server) attachSessionToConnIfNotShutdown(ss *sshSession) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 	if srv.shutdownCalled {
 		// Do not start any new sessions.
-		return false
+		return userVisibleError{"Tailscale SSH is shutting down", errSessionDone}
+	}
+	if ss.hasResourceLimits() && !srv.canLimitResources() {
+		metricSessionResourceLimitsUnsupported.Add(1)
+		return userVisibleError{
+			"Session resource limits are not supported on this host.",
+			errResourceLimitsUnsupported,
+		}
+	}
+	if max := ss.conn.finalAction.MaxSessionsPerUser; max > 0 {
+		if n := srv.numSessionsForOwnerLocked(ss.conn.info.sessionOwner()); n >= max {
+			metricSessionLimitRejected.Add(1)
+			return userVisibleError{
+				fmt.Sprintf("Too many concurrent sessions; at most %d allowed.", max),
+				errTooManySessions,
+			}
+		}
 	}
 	ss.conn.attachSession(ss)
-	return true
+	return nil
 }
 
 func (srv *server) trackActiveConn(c *conn, add bool) {
@@ -704,6 +725,15 @@ type sshSession struct {
 	// We use this sync.Once to ensure that we only terminate the process once,
 	// either it exits itself or is terminated
 	exitOnce sync.Once
+
+	// cgroupDir is the cgroup with the session's resource limits, if any.
+	// It is set by newIncubatorCommand.
+	cgroupDir string
+
+	// lastActivity is the time (in Unix nanoseconds) of the most recent
+	// input or output on the session. It is only maintained when the
+	// SSHAction has an IdleTimeout.
+	lastActivity atomic.Int64
 }
 
 func (ss *sshSession) vlogf(format string, args ...any) {
@@ -843,7 +873,12 @@ func (c *conn) detachSession(ss *sshSession) {
 	}
 }
 
-var errSessionDone = errors.New("session is done")
+var (
+	errSessionDone     = errors.New("session is done")
+	errTooManySessions = errors.New("too many concurrent sessions")
+
+	errResourceLimitsUnsupported = errors.New("session resource limits not supported")
+)
 
 // handleSSHAgentForwarding starts a Unix socket listener and in the background
 // forwards agent connections between the listener and the ssh.Session.
@@ -905,8 +940,12 @@ func (ss *sshSession) run() {
 	defer metricActiveSessions.Add(-1)
 	defer ss.cancelCtx(errSessionDone)
 
-	if attached := ss.conn.srv.attachSessionToConnIfNotShutdown(ss); !attached {
-		fmt.Fprintf(ss, "Tailscale SSH is shutting down\r\n")
+	if err := ss.conn.srv.attachSessionToConnIfNotShutdown(ss); err != nil {
+		ss.logf("not starting session: %v", err)
+		var uve userVisibleError
+		if errors.As(err, &uve) {
+			fmt.Fprintf(ss, "%s\r\n", uve.SSHTerminationMessage())
+		}
 		ss.Exit(1)
 		return
 	}
@@ -917,6 +956,7 @@ func (ss *sshSession) run() {
 
 	if ss.conn.finalAction.SessionDuration != 0 {
 		t := time.AfterFunc(ss.conn.finalAction.SessionDuration, func() {
+			metricSessionDurationTimeout.Add(1)
 			ss.cancelCtx(userVisibleError{
 				fmt.Sprintf("Session timeout of %v elapsed.", ss.conn.finalAction.SessionDuration),
 				context.DeadlineExceeded,
@@ -924,6 +964,10 @@ func (ss *sshSession) run() {
 		})
 		defer t.Stop()
 	}
+	if d := ss.conn.finalAction.IdleTimeout; d != 0 {
+		ss.touch()
+		go ss.enforceIdleTimeout(d)
+	}
 
 	if euid := os.Geteuid(); euid != 0 && runtime.GOOS != "plan9" {
 		if lu.Uid != fmt.Sprint(euid) {
@@ -971,6 +1015,7 @@ func (ss *sshSession) run() {
 	err := ss.launchProcess()
 	if err != nil {
 		logf("start failed: %v", err.Error())
+		ss.removeCgroup()
 		if errors.Is(err, context.Canceled) {
 			err := context.Cause(ss.ctx)
 			var uve userVisibleError
@@ -986,7 +1031,7 @@ func (ss *sshSession) run() {
 	var processDone atomic.Bool
 	go func() {
 		defer ss.wrStdin.Close()
-		if _, err := io.Copy(rec.writer("i", ss.wrStdin), ss); err != nil {
+		if _, err := io.Copy(ss.trackActivity(rec.writer("i", ss.wrStdin)), ss); err != nil {
 			logf("stdin copy: %v", err)
 			ss.cancelCtx(err)
 		}
@@ -1000,7 +1045,7 @@ func (ss *sshSession) run() {
 	}
 	go func() {
 		defer ss.rdStdout.Close()
-		_, err := io.Copy(rec.writer("o", ss), ss.rdStdout)
+		_, err := io.Copy(ss.trackActivity(rec.writer("o", ss)), ss.rdStdout)
 		if err != nil && !errors.Is(err, io.EOF) {
 			isErrBecauseProcessExited := processDone.Load() && errors.Is(err, syscall.EIO)
 			if !isErrBecauseProcessExited {
@@ -1017,7 +1062,7 @@ func (ss *sshSession) run() {
 	if ss.rdStderr != nil {
 		go func() {
 			defer ss.rdStderr.Close()
-			_, err := io.Copy(ss.Stderr(), ss.rdStderr)
+			_, err := io.Copy(ss.trackActivity(ss.Stderr()), ss.rdStderr)
 			if err != nil {
 				logf("stderr copy: %v", err)
 			}
@@ -1030,6 +1075,7 @@ func (ss *sshSession) run() {
 
 	err = ss.cmd.Wait()
 	processDone.Store(true)
+	ss.removeCgroup()
 
 	// This will either make the SSH Termination goroutine be a no-op,
 	// or itself will be a no-op because the process was killed by the
@@ -1551,6 +1597,12 @@ func envEq(a, b string) bool {
 	metricSFTP                = clientmetric.NewCounter("ssh_sftp_sessions")
 	metricLocalPortForward    = clientmetric.NewCounter("ssh_local_port_forward_requests")
 	metricRemotePortForward   = clientmetric.NewCounter("ssh_remote_port_forward_requests")
+
+	metricSessionIdleTimeout               = clientmetric.NewCounter("ssh_session_idle_timeout")
+	metricSessionDurationTimeout           = clientmetric.NewCounter("ssh_session_duration_timeout")
+	metricSessionLimitRejected             = clientmetric.NewCounter("ssh_session_limit_rejected")
+	metricSessionResourceLimited           = clientmetric.NewCounter("ssh_session_resource_limited")
+	metricSessionResourceLimitsUnsupported = clientmetric.NewCounter("ssh_session_resource_limits_unsupported")
 )
 
 // userVisibleError is a wrapper around an error that implements
@@ -48,6 +48,7 @@
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
 	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/ptr"
@@ -1224,6 +1225,123 @@ func TestSSH(t *testing.T) {
 	})
 }
 
+func TestMaxSessionsPerUser(t *testing.T) {
+	srv := &server{logf: t.Logf}
+	action := &tailcfg.SSHAction{Accept: true, MaxSessionsPerUser: 2}
+	newConn := func(uid tailcfg.UserID) *conn {
+		c := &conn{
+			srv:         srv,
+			finalAction: action,
+			info: &sshConnInfo{
+				node:  (&tailcfg.Node{}).View(),
+				uprof: tailcfg.UserProfile{ID: uid},
+			},
+		}
+		srv.trackActiveConn(c, true)
+		return c
+	}
+	attach := func(c *conn) error {
+		return srv.attachSessionToConnIfNotShutdown(&sshSession{conn: c, sharedID: "sess"})
+	}
+
+	alice1, alice2, bob := newConn(1), newConn(1), newConn(2)
+	for _, c := range []*conn{alice1, alice1, bob} {
+		if err := attach(c); err != nil {
+			t.Fatalf("attach: %v", err)
+		}
+	}
+	// alice already has two sessions, on a different conn.
+	var uve userVisibleError
+	if err := attach(alice2); !errors.As(err, &uve) || uve.error != errTooManySessions {
+		t.Fatalf("attach = %v; want %v", err, errTooManySessions)
+	}
+	// bob only has one.
+	if err := attach(bob); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+
+	alice1.detachSession(alice1.sessions[0])
+	if err := attach(alice2); err != nil {
+		t.Fatalf("attach after detach: %v", err)
+	}
+}
+
+func TestResourceLimitsUnsupported(t *testing.T) {
+	srv := &server{logf: t.Logf, tailscaledPath: "/usr/sbin/tailscaled"}
+	c := &conn{
+		srv:         srv,
+		finalAction: &tailcfg.SSHAction{Accept: true, MemoryLimitBytes: 1 << 30},
+		info: &sshConnInfo{
+			node:  (&tailcfg.Node{}).View(),
+			uprof: tailcfg.UserProfile{ID: 1},
+		},
+	}
+	attach := func() error {
+		return srv.attachSessionToConnIfNotShutdown(&sshSession{conn: c, sharedID: "sess"})
+	}
+
+	tstest.Replace(t, &prepareSessionCgroup, nil)
+	var uve userVisibleError
+	if err := attach(); !errors.As(err, &uve) || uve.error != errResourceLimitsUnsupported {
+		t.Fatalf("attach without cgroup support = %v; want %v", err, errResourceLimitsUnsupported)
+	}
+
+	tstest.Replace(t, &prepareSessionCgroup, func(logger.Logf, string, int, int64) (string, error) {
+		return "", nil
+	})
+	if err := attach(); err != nil {
+		t.Fatalf("attach with cgroup support: %v", err)
+	}
+	srv.tailscaledPath = ""
+	if err := attach(); !errors.As(err, &uve) || uve.error != errResourceLimitsUnsupported {
+		t.Fatalf("attach without incubator = %v; want %v", err, errResourceLimitsUnsupported)
+	}
+}
+
+func TestEnforceIdleTimeout(t *testing.T) {
+	const idleTimeout = 200 * time.Millisecond
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	ss := &sshSession{
+		ctx:       ctx,
+		cancelCtx: cancel,
+		conn: &conn{
+			finalAction: &tailcfg.SSHAction{Accept: true, IdleTimeout: idleTimeout},
+		},
+	}
+	w := ss.trackActivity(io.Discard)
+	ss.touch()
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ss.enforceIdleTimeout(idleTimeout)
+	}()
+
+	// Output keeps the session alive past the idle timeout.
+	for time.Since(start) < 2*idleTimeout {
+		io.WriteString(w, "output")
+		time.Sleep(idleTimeout / 10)
+	}
+	if err := context.Cause(ctx); err != nil {
+		t.Fatalf("session ended while active: %v", err)
+	}
+
+	// Once idle, the session ends.
+	select {
+	case <-done:
+	case <-time.After(10 * idleTimeout):
+		t.Fatal("session did not end after idle timeout")
+	}
+	var uve userVisibleError
+	if err := context.Cause(ctx); !errors.As(err, &uve) || uve.error != context.DeadlineExceeded {
+		t.Fatalf("session ended with %v; want idle timeout", err)
+	}
+	if !strings.Contains(uve.SSHTerminationMessage(), "idle timeout") {
+		t.Errorf("termination message = %q; want idle timeout", uve.SSHTerminationMessage())
+	}
+}
+
 func parseEnv(out []byte) map[string]string {
 	e := map[string]string{}
 	for line := range lineiter.Bytes(out) {
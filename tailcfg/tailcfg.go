@@ -179,7 +179,10 @@
 //   - 130: 2025-10-06: client can send key.HardwareAttestationPublic and key.HardwareAttestationKeySignature in MapRequest
 //   - 131: 2025-11-25: client respects [NodeAttrDefaultAutoUpdate]
 //   - 132: 2026-02-13: client respects [NodeAttrDisableHostsFileUpdates]
-const CurrentCapabilityVersion CapabilityVersion = 132
+//   - 133: 2026-10-18: Client understands SSHAction.{IdleTimeout,MaxSessionsPerUser,CPUQuotaPercent,MemoryLimitBytes}
+//   - 134: 2026-10-18: client respects [NodeAttrDNSForwarderCache]
+//   - 135: 2026-10-18: client enforces AllowFrom of serve config TCP and HTTP handlers
+const CurrentCapabilityVersion CapabilityVersion = 135
 
 // ID is an integer ID for a user, node, or login allocated by the
 // control plane.
@@ -2748,6 +2751,10 @@ type Oauth2Token struct {
 	// primary domain takes precedence over MagicDNS. As of 2026-02-12, it is only
 	// used on Windows.
 	NodeAttrDisableHostsFileUpdates NodeCapability = "disable-hosts-file-updates"
+
+	// NodeAttrDNSForwarderCache makes the DNS forwarder at 100.100.100.100
+	// cache responses from upstream resolvers, honoring their TTLs.
+	NodeAttrDNSForwarderCache NodeCapability = "dns-forwarder-cache"
 )
 
 // SetDNSRequest is a request to add a DNS record.
@@ -2940,6 +2947,32 @@ type SSHAction struct {
 	// before being forcefully terminated.
 	SessionDuration time.Duration `json:"sessionDuration,omitempty,format:nano"`
 
+	// IdleTimeout, if non-zero, is how long a session can go without any
+	// input from the client or output from the remote process before being
+	// forcefully terminated.
+	IdleTimeout time.Duration `json:"idleTimeout,omitempty,format:nano"`
+
+	// MaxSessionsPerUser, if non-zero, is the maximum number of concurrent
+	// SSH sessions that a single Tailscale user (or, for tagged nodes, a
+	// single node) may have open on this node. Sessions beyond the limit are
+	// rejected.
+	MaxSessionsPerUser int `json:"maxSessionsPerUser,omitempty"`
+
+	// CPUQuotaPercent, if non-zero, is the maximum CPU time the session's
+	// processes may use, as a percentage of a single CPU. For example, 50
+	// means half a CPU and 200 means two CPUs.
+	// It is currently only enforced on Linux hosts using cgroup v2, where
+	// tailscaled runs in a delegated cgroup (e.g. Delegate=yes in its systemd
+	// unit). On other hosts, sessions are rejected.
+	CPUQuotaPercent int `json:"cpuQuotaPercent,omitempty"`
+
+	// MemoryLimitBytes, if non-zero, is the maximum amount of memory the
+	// session's processes may use, in bytes.
+	// It is currently only enforced on Linux hosts using cgroup v2, where
+	// tailscaled runs in a delegated cgroup (e.g. Delegate=yes in its systemd
+	// unit). On other hosts, sessions are rejected.
+	MemoryLimitBytes int64 `json:"memoryLimitBytes,omitempty"`
+
 	// AllowAgentForwarding, if true, allows accepted connections to forward
 	// the ssh agent if requested.
 	AllowAgentForwarding bool `json:"allowAgentForwarding,omitempty"`
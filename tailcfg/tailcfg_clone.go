@@ -545,6 +545,10 @@ func (src *SSHAction) Clone() *SSHAction {
 	Reject                    bool
 	Accept                    bool
 	SessionDuration           time.Duration
+	IdleTimeout               time.Duration
+	MaxSessionsPerUser        int
+	CPUQuotaPercent           int
+	MemoryLimitBytes          int64
 	AllowAgentForwarding      bool
 	HoldAndDelegate           string
 	AllowLocalPortForwarding  bool
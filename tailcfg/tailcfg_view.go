@@ -2097,6 +2097,32 @@ func (v SSHActionView) Accept() bool { return v.ж.Accept }
 // before being forcefully terminated.
 func (v SSHActionView) SessionDuration() time.Duration { return v.ж.SessionDuration }
 
+// IdleTimeout, if non-zero, is how long a session can go without any
+// input from the client or output from the remote process before being
+// forcefully terminated.
+func (v SSHActionView) IdleTimeout() time.Duration { return v.ж.IdleTimeout }
+
+// MaxSessionsPerUser, if non-zero, is the maximum number of concurrent
+// SSH sessions that a single Tailscale user (or, for tagged nodes, a
+// single node) may have open on this node. Sessions beyond the limit are
+// rejected.
+func (v SSHActionView) MaxSessionsPerUser() int { return v.ж.MaxSessionsPerUser }
+
+// CPUQuotaPercent, if non-zero, is the maximum CPU time the session's
+// processes may use, as a percentage of a single CPU. For example, 50
+// means half a CPU and 200 means two CPUs.
+// It is currently only enforced on Linux hosts using cgroup v2, where
+// tailscaled runs in a delegated cgroup (e.g. Delegate=yes in its systemd
+// unit). On other hosts, sessions are rejected.
+func (v SSHActionView) CPUQuotaPercent() int { return v.ж.CPUQuotaPercent }
+
+// MemoryLimitBytes, if non-zero, is the maximum amount of memory the
+// session's processes may use, in bytes.
+// It is currently only enforced on Linux hosts using cgroup v2, where
+// tailscaled runs in a delegated cgroup (e.g. Delegate=yes in its systemd
+// unit). On other hosts, sessions are rejected.
+func (v SSHActionView) MemoryLimitBytes() int64 { return v.ж.MemoryLimitBytes }
+
 // AllowAgentForwarding, if true, allows accepted connections to forward
 // the ssh agent if requested.
 func (v SSHActionView) AllowAgentForwarding() bool { return v.ж.AllowAgentForwarding }
@@ -2144,6 +2170,10 @@ func (v SSHActionView) OnRecordingFailure() views.ValuePointer[SSHRecorderFailur
 	Reject                    bool
 	Accept                    bool
 	SessionDuration           time.Duration
+	IdleTimeout               time.Duration
+	MaxSessionsPerUser        int
+	CPUQuotaPercent           int
+	MemoryLimitBytes          int64
 	AllowAgentForwarding      bool
 	HoldAndDelegate           string
 	AllowLocalPortForwarding  bool
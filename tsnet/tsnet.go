@@ -53,6 +53,7 @@
 	"tailscale.com/net/proxymux"
 	"tailscale.com/net/socks5"
 	"tailscale.com/net/tsdial"
+	"tailscale.com/net/tstun"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/types/bools"
@@ -199,6 +200,11 @@ type Server struct {
 	fallbackTCPHandlers set.HandleSet[FallbackTCPHandler]
 	dialer              *tsdial.Dialer
 	closed              bool
+
+	// tun is the Wrapper that packet processors are added to, once
+	// started. Until then, processors are kept in pendingProcessors.
+	tun               *tstun.Wrapper
+	pendingProcessors []*pendingPacketProcessor
 }
 
 // FallbackTCPHandler describes the callback which
@@ -687,6 +693,13 @@ func (s *Server) start() (reterr error) {
 	if err != nil {
 		return fmt.Errorf("netstack.Create: %w", err)
 	}
+	s.mu.Lock()
+	s.tun = sys.Tun.Get()
+	for _, pp := range s.pendingProcessors {
+		pp.remove = s.tun.AddPacketProcessor(pp.dir, pp.fn)
+	}
+	s.pendingProcessors = nil
+	s.mu.Unlock()
 	sys.Tun.Get().Start()
 	sys.Set(ns)
 	if s.Tun == nil {
@@ -1163,6 +1176,57 @@ func (s *Server) RegisterFallbackTCPHandler(cb FallbackTCPHandler) func() {
 	}
 }
 
+// pendingPacketProcessor is a packet processor added before the Server
+// started.
+type pendingPacketProcessor struct {
+	dir    tstun.Direction
+	fn     tstun.PacketProcessor
+	remove func() // once added to the Wrapper
+}
+
+// AddPacketProcessor adds fn to the processors of packets flowing in
+// direction dir between this node and its peers. Processors can inspect,
+// modify or drop packets; see [tstun.PacketProcessor] and
+// [tstun.Wrapper.AddPacketProcessor] for details. Processors run in the
+// order they were added.
+//
+// Processors added before the Server starts see all of its traffic.
+//
+// The returned function removes fn.
+func (s *Server) AddPacketProcessor(dir tstun.Direction, fn tstun.PacketProcessor) (remove func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tun != nil {
+		return s.tun.AddPacketProcessor(dir, fn)
+	}
+	pp := &pendingPacketProcessor{dir: dir, fn: fn}
+	s.pendingProcessors = append(s.pendingProcessors, pp)
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if pp.remove != nil {
+			pp.remove()
+			return
+		}
+		s.pendingProcessors = slices.DeleteFunc(s.pendingProcessors, func(e *pendingPacketProcessor) bool {
+			return e == pp
+		})
+	}
+}
+
+// InjectPacket injects the raw IP packet pkt as if it were received from a
+// peer (tstun.Inbound), to be handled by this node's listeners, or sent by
+// this node to a peer (tstun.Outbound). It skips the packet filter and
+// packet processors. It takes ownership of pkt.
+//
+// It will start the server if it has not been started yet.
+func (s *Server) InjectPacket(dir tstun.Direction, pkt []byte) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	return s.sys.Tun.Get().InjectPacket(dir, pkt)
+}
+
 // getCert is the GetCertificate function used by ListenTLS.
 //
 // It calls GetCertificate on the localClient, passing in the ClientHelloInfo.
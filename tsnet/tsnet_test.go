@@ -50,6 +50,7 @@
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/packet"
+	"tailscale.com/net/tstun"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
 	"tailscale.com/tstest/deptest"
@@ -61,6 +62,7 @@
 	"tailscale.com/types/views"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/must"
+	"tailscale.com/wgengine/filter"
 )
 
 // TestListener_Server ensures that the listener type always keeps the Server
@@ -1405,6 +1407,64 @@ func TestCapturePcap(t *testing.T) {
 	}
 }
 
+func TestPacketProcessor(t *testing.T) {
+	tstest.Shard(t)
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	s1, s1ip, _ := startServer(t, ctx, controlURL, "s1")
+	s2, _, _ := startServer(t, ctx, controlURL, "s2")
+
+	// ping to make sure the connection is up.
+	lc2 := must.Get(s2.LocalClient())
+	if _, err := lc2.Ping(ctx, s1ip, tailcfg.PingICMP); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := netip.AddrPortFrom(s1ip, 8081)
+	var sent atomic.Int32
+	s2.AddPacketProcessor(tstun.Outbound, func(p *packet.Parsed) filter.Response {
+		if p.Dst == dst {
+			sent.Add(1)
+		}
+		return filter.Accept
+	})
+	remove := s1.AddPacketProcessor(tstun.Inbound, func(p *packet.Parsed) filter.Response {
+		if p.Dst == dst && string(p.Payload()) == "drop" {
+			return filter.Drop
+		}
+		return filter.Accept
+	})
+	defer remove()
+
+	pc := must.Get(s1.ListenPacket("udp", dst.String()))
+	defer pc.Close()
+	w, err := s2.Dial(ctx, "udp", dst.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, msg := range []string{"drop", "keep"} {
+		if _, err := io.WriteString(w, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(got[:n]); got != "keep" {
+		t.Errorf("got %q; want %q", got, "keep")
+	}
+	if n := sent.Load(); n != 2 {
+		t.Errorf("outbound processor saw %d packets; want 2", n)
+	}
+}
+
 func TestUDPConn(t *testing.T) {
 	tstest.Shard(t)
 	tstest.ResourceCheck(t)
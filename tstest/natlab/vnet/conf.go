@@ -14,6 +14,7 @@
 
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcapgo"
+	"tailscale.com/net/portmapper/portmappertest"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/must"
 	"tailscale.com/util/set"
@@ -388,6 +389,16 @@ func (s *Server) initFromConfig(c *Config) error {
 			nodesByMAC: map[MAC]*node{},
 			logf:       logger.WithPrefix(s.logf, fmt.Sprintf("[net-%v] ", conf.mac)),
 		}
+		if conf.svcs.Contains(PCP) {
+			n.pcp = portmappertest.NewGateway(portmappertest.Config{
+				PCP:        true,
+				ExternalIP: conf.wanIP4,
+				Logf:       n.logf,
+				MapPort: func(internal netip.AddrPort, wantExternal uint16, lifetime time.Duration) (uint16, bool) {
+					return n.doPortMap(internal.Addr(), internal.Port(), wantExternal, int(lifetime/time.Second))
+				},
+			})
+		}
 		netOfConf[conf] = n
 		s.networks.Add(n)
 		if conf.wanIP4.IsValid() {
@@ -54,6 +54,7 @@
 	"tailscale.com/derp/derpserver"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/netx"
+	"tailscale.com/net/portmapper/portmappertest"
 	"tailscale.com/net/stun"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
@@ -524,6 +525,9 @@ type network struct {
 	nodesByMAC     map[MAC]*node
 	logf           func(format string, args ...any)
 
+	// pcp, if non-nil, serves PCP requests to the router.
+	pcp *portmappertest.Gateway
+
 	ns     *stack.Stack
 	linkEP *channel.Endpoint
 
@@ -1351,6 +1355,15 @@ func (n *network) handleUDPPacketForRouter(ep EthernetPacket, udp *layers.UDP, t
 		return
 	}
 
+	if dstIP == n.lanIP4.Addr() && isPCP(udp) && n.pcp != nil {
+		n.handlePCPRequest(UDPPacket{
+			Src:     netip.AddrPortFrom(srcIP, uint16(udp.SrcPort)),
+			Dst:     netip.AddrPortFrom(dstIP, uint16(udp.DstPort)),
+			Payload: udp.Payload,
+		})
+		return
+	}
+
 	if toForward {
 		if dstIP.Is4() && n.breakWAN4 {
 			// Blackhole the packet.
@@ -1403,7 +1416,7 @@ func (n *network) handleUDPPacketForRouter(ep EthernetPacket, udp *layers.UDP, t
 	}
 
 	if udp.DstPort == pcpPort || udp.DstPort == ssdpPort {
-		// We handle NAT-PMP, but not these yet.
+		// We handle NAT-PMP and PCP, but not UPnP yet.
 		// TODO(bradfitz): handle? marginal utility so far.
 		// Don't log about them being unknown.
 		return
@@ -1705,6 +1718,10 @@ func isNATPMP(udp *layers.UDP) bool {
 	return udp.DstPort == 5351 && len(udp.Payload) > 0 && udp.Payload[0] == 0 // version 0, not 2 for PCP
 }
 
+func isPCP(udp *layers.UDP) bool {
+	return udp.DstPort == pcpPort && len(udp.Payload) > 0 && udp.Payload[0] == 2 // version 2, not 0 for NAT-PMP
+}
+
 func makeSTUNReply(req UDPPacket) (res UDPPacket, ok bool) {
 	txid, err := stun.ParseBindingRequest(req.Payload)
 	if err != nil {
@@ -1883,7 +1900,7 @@ func (n *network) doPortMap(src netip.Addr, dstLANPort, wantExtPort uint16, sec
 	n.natMu.Lock()
 	defer n.natMu.Unlock()
 
-	if !n.portmap {
+	if !n.portmap && n.pcp == nil {
 		return 0, false
 	}
 
@@ -2036,6 +2053,20 @@ func (n *network) handleNATPMPRequest(req UDPPacket) {
 	n.logf("TODO: handle NAT-PMP packet % 02x", req.Payload)
 }
 
+// handlePCPRequest handles a PCP request to the router, which is served by
+// n.pcp.
+func (n *network) handlePCPRequest(req UDPPacket) {
+	res := n.pcp.HandlePxP(req.Src, req.Payload)
+	if res == nil {
+		return
+	}
+	n.WriteUDPPacketNoNAT(UDPPacket{
+		Src:     req.Dst,
+		Dst:     req.Src,
+		Payload: res,
+	})
+}
+
 // UDPPacket is a UDP packet.
 //
 // For the purposes of this project, a UDP packet
@@ -55,6 +55,16 @@ type Filter struct {
 	matches4 matches
 	matches6 matches
 
+	// rules4 and rules6 are, for each Match in matches4 and matches6,
+	// its index in the Matches the filter was created with.
+	rules4, rules6 []int
+
+	// hits are the packet counters of each rule, by its index in the
+	// Matches the filter was created with, if it's tracked by a
+	// RuleMetrics. The counters of rules not in matches4 or matches6
+	// are nil.
+	hits []*ruleCounters
+
 	// cap4 and cap6 are the subsets of the matches that are about
 	// capability grants, partitioned by source IP address family.
 	cap4, cap6 matches
@@ -121,6 +131,8 @@ func (r Response) IsDrop() bool {
 	NetPortRange = filtertype.NetPortRange
 	PortRange    = filtertype.PortRange
 	CapMatch     = filtertype.CapMatch
+	Trace        = filtertype.Trace
+	TraceCap     = filtertype.TraceCap
 )
 
 // NewAllowAllForTest returns a packet filter that accepts
@@ -202,10 +214,14 @@ func New(matches []Match, capTest CapTestFunc, localNets, logIPs *netipx.IPSet,
 		}
 	}
 
+	matches4, rules4 := matchesFamily(matches, netip.Addr.Is4)
+	matches6, rules6 := matchesFamily(matches, netip.Addr.Is6)
 	f := &Filter{
 		logf:        logf,
-		matches4:    matchesFamily(matches, netip.Addr.Is4),
-		matches6:    matchesFamily(matches, netip.Addr.Is6),
+		matches4:    matches4,
+		matches6:    matches6,
+		rules4:      rules4,
+		rules6:      rules6,
 		cap4:        capMatchesFunc(matches, netip.Addr.Is4),
 		cap6:        capMatchesFunc(matches, netip.Addr.Is6),
 		local4:      ipset.FalseContainsIPFunc(),
@@ -232,10 +248,10 @@ func New(matches []Match, capTest CapTestFunc, localNets, logIPs *netipx.IPSet,
 }
 
 // matchesFamily returns the subset of ms for which keep(srcNet.IP)
-// and keep(dstNet.IP) are both true.
-func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
-	var ret matches
-	for _, m := range ms {
+// and keep(dstNet.IP) are both true, along with the index in ms of each
+// returned Match.
+func matchesFamily(ms matches, keep func(netip.Addr) bool) (ret matches, idx []int) {
+	for i, m := range ms {
 		var retm Match
 		retm.IPProto = m.IPProto
 		retm.SrcCaps = m.SrcCaps
@@ -253,9 +269,10 @@ func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
 		if (len(retm.Srcs) > 0 || len(retm.SrcCaps) > 0) && len(retm.Dsts) > 0 {
 			retm.SrcsContains = ipset.NewContainsIPFunc(views.SliceOf(retm.Srcs))
 			ret = append(ret, retm)
+			idx = append(idx, i)
 		}
 	}
-	return ret
+	return ret, idx
 }
 
 // capMatchesFunc returns a copy of the subset of ms for which keep(srcNet.IP)
@@ -344,28 +361,39 @@ func (f *Filter) logRateLimit(runflags RunFlags, q *packet.Parsed, dir direction
 // Check determines whether traffic from srcIP to dstIP:dstPort is allowed
 // using protocol proto.
 func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) Response {
-	pkt := &packet.Parsed{}
-	pkt.Decode(dummyPacket) // initialize private fields
-	switch {
-	case (srcIP.Is4() && dstIP.Is6()) || (srcIP.Is6() && srcIP.Is4()):
+	pkt := SynthesizePacket(netip.AddrPortFrom(srcIP, 0), netip.AddrPortFrom(dstIP, dstPort), proto)
+	if pkt == nil {
 		// Mismatched address families, no filters will
 		// match.
 		return Drop
-	case srcIP.Is4():
+	}
+	return f.runIn(pkt, 0, false)
+}
+
+// SynthesizePacket returns a packet from src to dst using protocol proto,
+// with enough fields set to be evaluated by a Filter. A TCP packet is a SYN,
+// starting a connection. It returns nil if src and dst are of different
+// address families.
+func SynthesizePacket(src, dst netip.AddrPort, proto ipproto.Proto) *packet.Parsed {
+	pkt := &packet.Parsed{}
+	pkt.Decode(dummyPacket) // initialize private fields
+	switch {
+	case src.Addr().Is4() != dst.Addr().Is4():
+		return nil
+	case src.Addr().Is4():
 		pkt.IPVersion = 4
-	case srcIP.Is6():
+	case src.Addr().Is6():
 		pkt.IPVersion = 6
 	default:
 		panic("unreachable")
 	}
-	pkt.Src = netip.AddrPortFrom(srcIP, 0)
-	pkt.Dst = netip.AddrPortFrom(dstIP, dstPort)
+	pkt.Src = src
+	pkt.Dst = dst
 	pkt.IPProto = proto
 	if proto == ipproto.TCP {
 		pkt.TCPFlags = packet.TCPSyn
 	}
-
-	return f.RunIn(pkt, 0)
+	return pkt
 }
 
 // CheckTCP determines whether TCP traffic from srcIP to dstIP:dstPort
@@ -410,6 +438,12 @@ func (f *Filter) ShieldsUp() bool { return f.shieldsUp }
 // RunIn determines whether this node is allowed to receive q from a
 // Tailscale peer.
 func (f *Filter) RunIn(q *packet.Parsed, rf RunFlags) Response {
+	return f.runIn(q, rf, true)
+}
+
+// runIn implements RunIn. If count is false, q isn't counted in the
+// filter's rule counters, as it's not a real packet.
+func (f *Filter) runIn(q *packet.Parsed, rf RunFlags, count bool) Response {
 	dir := in
 	r, _ := f.pre(q, rf, dir)
 	if r == Accept || r == Drop {
@@ -418,11 +452,18 @@ func (f *Filter) RunIn(q *packet.Parsed, rf RunFlags) Response {
 	}
 
 	var why string
+	var match int
 	switch q.IPVersion {
 	case 4:
-		r, why = f.runIn4(q)
+		r, why, match = f.runIn4(q, nil)
+		if count {
+			f.countRule(q, r, f.matches4, f.rules4, match)
+		}
 	case 6:
-		r, why = f.runIn6(q)
+		r, why, match = f.runIn6(q, nil)
+		if count {
+			f.countRule(q, r, f.matches6, f.rules6, match)
+		}
 	default:
 		r, why = Drop, "not-ip"
 	}
@@ -455,14 +496,18 @@ func unknownProtoString(proto ipproto.Proto) string {
 	return s
 }
 
-func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
+// runIn4 runs the IPv4-specific part of the inbound filter logic. If tr
+// is non-nil, it records how the verdict was reached in tr. match is the
+// index in matches4 of the Match that accepted q, or -1.
+func (f *Filter) runIn4(q *packet.Parsed, tr *Trace) (r Response, why string, match int) {
 	// A compromised peer could try to send us packets for
 	// destinations we didn't explicitly advertise. This check is to
 	// prevent that.
 	if !f.local4(q.Dst.Addr()) {
-		return Drop, "destination not allowed"
+		return Drop, "destination not allowed", -1
 	}
 
+	hasCap := traceCapTest(tr, f.srcIPHasCap)
 	switch q.IPProto {
 	case ipproto.ICMPv4:
 		if q.IsEchoResponse() || q.IsError() {
@@ -471,10 +516,10 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 			//  We could choose to reject all packets that aren't
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
-			return Accept, "icmp response ok"
-		} else if f.matches4.matchIPsOnly(q, f.srcIPHasCap) {
+			return Accept, "icmp response ok", -1
+		} else if i := f.matches4.matchIPsOnly(q, hasCap); i >= 0 {
 			// If any port is open to an IP, allow ICMP to it.
-			return Accept, "icmp ok"
+			return Accept, "icmp ok", i
 		}
 	case ipproto.TCP:
 		// For TCP, we want to allow *outgoing* connections,
@@ -486,10 +531,10 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		// It happens to also be much faster.
 		// TODO(apenwarr): Skip the rest of decoding in this path?
 		if !q.IsTCPSyn() {
-			return Accept, "tcp non-syn"
+			return Accept, "tcp non-syn", -1
 		}
-		if f.matches4.match(q, f.srcIPHasCap) {
-			return Accept, "tcp ok"
+		if i := f.matches4.match(q, hasCap); i >= 0 {
+			return Accept, "tcp ok", i
 		}
 	case ipproto.UDP, ipproto.SCTP:
 		t := flowtrack.MakeTuple(q.IPProto, q.Src, q.Dst)
@@ -499,30 +544,37 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		f.state.mu.Unlock()
 
 		if ok {
-			return Accept, "cached"
+			if tr != nil {
+				tr.Conntrack = true
+			}
+			return Accept, "cached", -1
 		}
-		if f.matches4.match(q, f.srcIPHasCap) {
-			return Accept, "ok"
+		if i := f.matches4.match(q, hasCap); i >= 0 {
+			return Accept, "ok", i
 		}
 	case ipproto.TSMP:
-		return Accept, "tsmp ok"
+		return Accept, "tsmp ok", -1
 	default:
-		if f.matches4.matchProtoAndIPsOnlyIfAllPorts(q) {
-			return Accept, "other-portless ok"
+		if i := f.matches4.matchProtoAndIPsOnlyIfAllPorts(q); i >= 0 {
+			return Accept, "other-portless ok", i
 		}
-		return Drop, unknownProtoString(q.IPProto)
+		return Drop, unknownProtoString(q.IPProto), -1
 	}
-	return Drop, "no rules matched"
+	return Drop, "no rules matched", -1
 }
 
-func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
+// runIn6 runs the IPv6-specific part of the inbound filter logic. If tr
+// is non-nil, it records how the verdict was reached in tr. match is the
+// index in matches6 of the Match that accepted q, or -1.
+func (f *Filter) runIn6(q *packet.Parsed, tr *Trace) (r Response, why string, match int) {
 	// A compromised peer could try to send us packets for
 	// destinations we didn't explicitly advertise. This check is to
 	// prevent that.
 	if !f.local6(q.Dst.Addr()) {
-		return Drop, "destination not allowed"
+		return Drop, "destination not allowed", -1
 	}
 
+	hasCap := traceCapTest(tr, f.srcIPHasCap)
 	switch q.IPProto {
 	case ipproto.ICMPv6:
 		if q.IsEchoResponse() || q.IsError() {
@@ -531,10 +583,10 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 			//  We could choose to reject all packets that aren't
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
-			return Accept, "icmp response ok"
-		} else if f.matches6.matchIPsOnly(q, f.srcIPHasCap) {
+			return Accept, "icmp response ok", -1
+		} else if i := f.matches6.matchIPsOnly(q, hasCap); i >= 0 {
 			// If any port is open to an IP, allow ICMP to it.
-			return Accept, "icmp ok"
+			return Accept, "icmp ok", i
 		}
 	case ipproto.TCP:
 		// For TCP, we want to allow *outgoing* connections,
@@ -546,10 +598,10 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		// It happens to also be much faster.
 		// TODO(apenwarr): Skip the rest of decoding in this path?
 		if q.IPProto == ipproto.TCP && !q.IsTCPSyn() {
-			return Accept, "tcp non-syn"
+			return Accept, "tcp non-syn", -1
 		}
-		if f.matches6.match(q, f.srcIPHasCap) {
-			return Accept, "tcp ok"
+		if i := f.matches6.match(q, hasCap); i >= 0 {
+			return Accept, "tcp ok", i
 		}
 	case ipproto.UDP, ipproto.SCTP:
 		t := flowtrack.MakeTuple(q.IPProto, q.Src, q.Dst)
@@ -559,20 +611,23 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		f.state.mu.Unlock()
 
 		if ok {
-			return Accept, "cached"
+			if tr != nil {
+				tr.Conntrack = true
+			}
+			return Accept, "cached", -1
 		}
-		if f.matches6.match(q, f.srcIPHasCap) {
-			return Accept, "ok"
+		if i := f.matches6.match(q, hasCap); i >= 0 {
+			return Accept, "ok", i
 		}
 	case ipproto.TSMP:
-		return Accept, "tsmp ok"
+		return Accept, "tsmp ok", -1
 	default:
-		if f.matches6.matchProtoAndIPsOnlyIfAllPorts(q) {
-			return Accept, "other-portless ok"
+		if i := f.matches6.matchProtoAndIPsOnlyIfAllPorts(q); i >= 0 {
+			return Accept, "other-portless ok", i
 		}
-		return Drop, unknownProtoString(q.IPProto)
+		return Drop, unknownProtoString(q.IPProto), -1
 	}
-	return Drop, "no rules matched"
+	return Drop, "no rules matched", -1
 }
 
 // runIn runs the output-specific part of the filter logic.
@@ -612,37 +667,42 @@ func (d direction) String() string {
 // pre runs the direction-agnostic filter logic. dir is only used for
 // logging.
 func (f *Filter) pre(q *packet.Parsed, rf RunFlags, dir direction) (Response, usermetric.DropReason) {
+	r, reason, why := preVerdict(q)
+	if r != noVerdict && len(q.Buffer()) > 0 {
+		f.logRateLimit(rf, q, dir, r, why)
+	}
+	return r, reason
+}
+
+// preVerdict returns the verdict of the direction-agnostic filter logic on
+// q, or noVerdict, along with why.
+func preVerdict(q *packet.Parsed) (_ Response, _ usermetric.DropReason, why string) {
 	if len(q.Buffer()) == 0 {
 		// wireguard keepalive packet, always permit.
-		return Accept, ""
+		return Accept, "", "keepalive"
 	}
 	if len(q.Buffer()) < 20 {
-		f.logRateLimit(rf, q, dir, Drop, "too short")
-		return Drop, usermetric.ReasonTooShort
+		return Drop, usermetric.ReasonTooShort, "too short"
 	}
 
 	if q.IPProto == ipproto.Unknown {
-		f.logRateLimit(rf, q, dir, Drop, "unknown proto")
-		return Drop, usermetric.ReasonUnknownProtocol
+		return Drop, usermetric.ReasonUnknownProtocol, "unknown proto"
 	}
 
 	if q.Dst.Addr().IsMulticast() {
-		f.logRateLimit(rf, q, dir, Drop, "multicast")
-		return Drop, usermetric.ReasonMulticast
+		return Drop, usermetric.ReasonMulticast, "multicast"
 	}
 	if q.Dst.Addr().IsLinkLocalUnicast() && q.Dst.Addr() != gcpDNSAddr {
-		f.logRateLimit(rf, q, dir, Drop, "link-local-unicast")
-		return Drop, usermetric.ReasonLinkLocalUnicast
+		return Drop, usermetric.ReasonLinkLocalUnicast, "link-local-unicast"
 	}
 
 	if q.IPProto == ipproto.Fragment {
 		// Fragments after the first always need to be passed through.
 		// Very small fragments are considered Junk by Parsed.
-		f.logRateLimit(rf, q, dir, Accept, "fragment")
-		return Accept, ""
+		return Accept, "", "fragment"
 	}
 
-	return noVerdict, ""
+	return noVerdict, "", ""
 }
 
 // loggingAllowed reports whether p can appear in logs at all.
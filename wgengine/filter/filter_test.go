@@ -175,7 +175,7 @@ type InOut struct {
 		if test.p.IPVersion == 6 {
 			aclFunc = filt.runIn6
 		}
-		if got, why := aclFunc(&test.p); test.want != got {
+		if got, why, _ := aclFunc(&test.p, nil); test.want != got {
 			t.Errorf("#%d runIn got=%v want=%v why=%q packet:%v", i, got, test.want, why, test.p)
 			continue
 		}
@@ -191,7 +191,7 @@ type InOut struct {
 			}
 			// TCP and UDP are treated equivalently in the filter - verify that.
 			test.p.IPProto = ipproto.UDP
-			if got, why := aclFunc(&test.p); test.want != got {
+			if got, why, _ := aclFunc(&test.p, nil); test.want != got {
 				t.Errorf("#%d runIn (UDP) got=%v want=%v why=%q packet:%v", i, got, test.want, why, test.p)
 			}
 		}
@@ -920,7 +920,7 @@ func TestMatchesMatchProtoAndIPsOnlyIfAllPorts(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			matches := matches{tt.m}
-			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p)
+			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p) >= 0
 			if got != tt.want {
 				t.Errorf("got = %v; want %v", got, tt.want)
 			}
@@ -1142,3 +1142,166 @@ func benchmarkFile(b *testing.B, file string, opt benchOpt) {
 		}
 	}
 }
+
+func TestTrace(t *testing.T) {
+	filt := newFilter(t.Logf)
+	ipWithCap := netip.MustParseAddr("10.0.0.1")
+	filt.srcIPHasCap = func(ip netip.Addr, cap tailcfg.NodeCapability) bool {
+		return cap == "cap-hit-1234-ssh" && ip == ipWithCap
+	}
+
+	tests := []struct {
+		name string
+		p    packet.Parsed
+		want Trace
+	}{
+		{
+			name: "rule",
+			p:    parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22),
+			want: Trace{Verdict: "Accept", Reason: "tcp ok", Rule: 0, Match: "{[6 17 1 58]}[8.1.1.1/32,8.2.2.2/32]=>[1.2.3.4/32:22,5.6.7.8/32:23-24]"},
+		},
+		{
+			name: "rule_v6",
+			p:    parsed(ipproto.UDP, "::2", "2001::2", 999, 22),
+			want: Trace{Verdict: "Accept", Reason: "ok", Rule: 7, Match: "{[6 17 1 58]}[::1/128,::2/128]=>[2001::1/128:22,2001::2/128:22]"},
+		},
+		{
+			name: "icmp",
+			p:    parsed(ipproto.ICMPv4, "153.1.1.2", "1.2.3.4", 0, 0),
+			// ICMP is allowed to any IP with an open port.
+			want: Trace{Verdict: "Accept", Reason: "icmp ok", Rule: 5, Match: "{[6 17 1 58]}0.0.0.0/0=>0.0.0.0/0:443"},
+		},
+		{
+			name: "cap",
+			p:    parsed(ipproto.TCP, "10.0.0.1", "1.2.3.4", 999, 22),
+			want: Trace{Verdict: "Accept", Reason: "tcp ok", Rule: 11, Match: "{[6 17 1 58]}[]=>1.2.3.4/32:22", Caps: []TraceCap{
+				{Src: ipWithCap, Cap: "cap-hit-1234-ssh", Has: true},
+			}},
+		},
+		{
+			name: "no_cap",
+			p:    parsed(ipproto.TCP, "10.0.0.2", "1.2.3.4", 999, 22),
+			want: Trace{Verdict: "Drop", Reason: "no rules matched", Rule: -1, Caps: []TraceCap{
+				{Src: netip.MustParseAddr("10.0.0.2"), Cap: "cap-hit-1234-ssh", Has: false},
+			}},
+		},
+		{
+			name: "not_local",
+			p:    parsed(ipproto.TCP, "8.1.1.1", "1.2.3.5", 999, 22),
+			want: Trace{Verdict: "Drop", Reason: "destination not allowed", Rule: -1},
+		},
+		{
+			name: "multicast",
+			p:    parsed(ipproto.UDP, "8.1.1.1", "224.0.0.1", 999, 22),
+			want: Trace{Verdict: "Drop", Reason: "multicast", Rule: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.Dir = "in"
+			tt.want.Packet = tt.p.String()
+			got := filt.TraceIn(&tt.p)
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(func(a, b netip.Addr) bool { return a == b })); diff != "" {
+				t.Errorf("TraceIn mismatch (-want +got):\n%s", diff)
+			}
+			if r := filt.RunIn(&tt.p, 0); r.String() != got.Verdict {
+				t.Errorf("RunIn = %v; TraceIn verdict = %v", r, got.Verdict)
+			}
+		})
+	}
+
+	t.Run("conntrack", func(t *testing.T) {
+		filt := newFilter(t.Logf)
+		a4 := parsed(ipproto.UDP, "119.119.119.119", "102.102.102.102", 4242, 4343)
+		b4 := parsed(ipproto.UDP, "102.102.102.102", "119.119.119.119", 4343, 4242)
+
+		// Tracing outbound traffic doesn't allow replies.
+		if tr := filt.TraceOut(&b4); tr.Verdict != "Accept" || tr.Dir != "out" {
+			t.Errorf("TraceOut = %+v; want accepted outbound", tr)
+		}
+		if tr := filt.TraceIn(&a4); tr.Verdict != "Drop" || tr.Conntrack {
+			t.Errorf("TraceIn before RunOut = %+v; want untracked drop", tr)
+		}
+		filt.RunOut(&b4, 0)
+		if tr := filt.TraceIn(&a4); tr.Verdict != "Accept" || !tr.Conntrack || tr.Rule != -1 {
+			t.Errorf("TraceIn after RunOut = %+v; want accepted by conntrack", tr)
+		}
+	})
+
+	t.Run("shields_up", func(t *testing.T) {
+		filt := NewShieldsUpFilter(&netipx.IPSet{}, nil, nil, t.Logf)
+		p := parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22)
+		if tr := filt.TraceIn(&p); !tr.ShieldsUp || tr.Verdict != "Drop" {
+			t.Errorf("TraceIn = %+v; want shields-up drop", tr)
+		}
+	})
+}
+
+func TestRuleMetrics(t *testing.T) {
+	var reg usermetric.Registry
+	rm := NewRuleMetrics(&reg)
+
+	matches := []Match{
+		m(nets("100.64.0.1"), netports("100.64.0.2:22")),
+		m(nets("100.64.0.3", "fd7a::3"), netports("100.64.0.2:80", "fd7a::2:80")),
+		m(nets("100.64.0.4", "100.64.0.5", "100.64.0.6", "100.64.0.7"), netports("100.64.0.2:443")),
+	}
+	var localNets netipx.IPSetBuilder
+	localNets.AddPrefix(netip.MustParsePrefix("100.64.0.2/32"))
+	localNets.AddPrefix(netip.MustParsePrefix("fd7a::2/128"))
+	localNetsSet := must.Get(localNets.IPSet())
+	filt := New(matches, nil, localNetsSet, localNetsSet, nil, t.Logf)
+	rm.Track(filt)
+
+	for _, p := range []packet.Parsed{
+		parsed(ipproto.TCP, "100.64.0.1", "100.64.0.2", 999, 22), // accepted by rule 0
+		parsed(ipproto.TCP, "100.64.0.1", "100.64.0.2", 999, 22), // accepted by rule 0
+		parsed(ipproto.TCP, "100.64.0.1", "100.64.0.2", 999, 23), // dropped near rule 0
+		parsed(ipproto.TCP, "fd7a::3", "fd7a::2", 999, 80),       // accepted by rule 1
+		parsed(ipproto.TCP, "100.64.0.3", "100.64.0.2", 999, 80), // accepted by rule 1
+		parsed(ipproto.TCP, "100.64.0.9", "100.64.0.2", 999, 80), // dropped; no rule
+	} {
+		filt.RunIn(&p, 0)
+	}
+	// Checks and traces aren't real packets, so aren't counted.
+	filt.CheckTCP(netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("100.64.0.2"), 22)
+	p := parsed(ipproto.TCP, "100.64.0.1", "100.64.0.2", 999, 22)
+	filt.TraceIn(&p)
+
+	labels := []RuleLabels{
+		{Rule: 0, Src: "100.64.0.1/32", Dst: "100.64.0.2/32:22"},
+		{Rule: 1, Src: "100.64.0.3/32,fd7a::3/128", Dst: "100.64.0.2/32:80,fd7a::2/128:80"},
+		{Rule: 2, Src: "100.64.0.4/32,100.64.0.5/32,100.64.0.6/32,+1 more", Dst: "100.64.0.2/32:443"},
+	}
+	counts := func() (accepted, dropped []string) {
+		for _, l := range labels {
+			a, d := rm.accepted.Get(l), rm.dropped.Get(l)
+			if a == nil || d == nil {
+				accepted = append(accepted, "missing")
+				dropped = append(dropped, "missing")
+				continue
+			}
+			accepted = append(accepted, a.String())
+			dropped = append(dropped, d.String())
+		}
+		return accepted, dropped
+	}
+	accepted, dropped := counts()
+	if want := []string{"2", "2", "0"}; !slices.Equal(accepted, want) {
+		t.Errorf("accepted = %q; want %q", accepted, want)
+	}
+	if want := []string{"1", "0", "0"}; !slices.Equal(dropped, want) {
+		t.Errorf("dropped = %q; want %q", dropped, want)
+	}
+
+	// A new filter with an unchanged rule keeps its counts; changed
+	// rules are removed.
+	filt2 := New(matches[:1], nil, localNetsSet, localNetsSet, nil, t.Logf)
+	rm.Track(filt2)
+	p = parsed(ipproto.TCP, "100.64.0.1", "100.64.0.2", 999, 22)
+	filt2.RunIn(&p, 0)
+	accepted, _ = counts()
+	if want := []string{"3", "missing", "missing"}; !slices.Equal(accepted, want) {
+		t.Errorf("after Track, accepted = %q; want %q", accepted, want)
+	}
+}
@@ -114,3 +114,52 @@ func (m Match) String() string {
 	}
 	return fmt.Sprintf("%v%v=>%v", m.IPProto, ss, ds)
 }
+
+// Trace describes how a packet filter reached its verdict on a packet, for
+// debugging ACLs.
+type Trace struct {
+	// Dir is "in" for a packet from a Tailscale peer, or "out" for a
+	// packet to one.
+	Dir string
+	// Packet summarizes the packet, as in the filter's logs.
+	Packet string
+	// Verdict is the filter's response: "Accept", "Drop" or
+	// "DropSilently".
+	Verdict string
+	// Reason is why the packet got its verdict, as logged by the filter,
+	// such as "tcp ok", "cached" or "no rules matched".
+	Reason string
+
+	// Rule is the index, in the Matches the filter was created with, of
+	// the Match that accepted the packet, or -1 if no Match decided the
+	// verdict.
+	Rule int
+	// Match is the part of the Match at index Rule for the packet's
+	// address family, if Rule isn't -1.
+	Match string `json:",omitempty"`
+	// Caps are the capability tests the filter made for Matches with
+	// SrcCaps, in order.
+	Caps []TraceCap `json:",omitempty"`
+	// Conntrack is whether the packet was accepted by the filter's
+	// connection tracking state, as a reply to a flow this node started.
+	Conntrack bool `json:",omitempty"`
+
+	// ShieldsUp is whether the filter is a shields-up filter, which
+	// accepts no incoming connections.
+	ShieldsUp bool `json:",omitempty"`
+	// Jailed is whether the peer is jailed, so the jailed filter was used
+	// instead of the node's packet filter.
+	Jailed bool `json:",omitempty"`
+	// PeerAPI is whether the packet was accepted despite the filter,
+	// because it opens a connection to this node's PeerAPI, whose access
+	// control happens at L7.
+	PeerAPI bool `json:",omitempty"`
+}
+
+// TraceCap is a test of whether a source IP has a node capability, made by
+// a packet filter while tracing a packet.
+type TraceCap struct {
+	Src netip.Addr
+	Cap tailcfg.NodeCapability
+	Has bool
+}
@@ -14,7 +14,8 @@
 
 type matches []filtertype.Match
 
-func (ms matches) match(q *packet.Parsed, hasCap CapTestFunc) bool {
+// match returns the index of the first Match in ms that q matches, or -1.
+func (ms matches) match(q *packet.Parsed, hasCap CapTestFunc) int {
 	for i := range ms {
 		m := &ms[i]
 		if !views.SliceContains(m.IPProto, q.IPProto) {
@@ -30,10 +31,10 @@ func (ms matches) match(q *packet.Parsed, hasCap CapTestFunc) bool {
 			if !dst.Ports.Contains(q.Dst.Port()) {
 				continue
 			}
-			return true
+			return i
 		}
 	}
-	return false
+	return -1
 }
 
 // srcMatches reports whether srcAddr matche the src requirements in m, either
@@ -59,35 +60,38 @@ func srcMatches(m *filtertype.Match, srcAddr netip.Addr, hasCap CapTestFunc) boo
 // It it used in the fast path of evaluating filter rules so should be fast.
 type CapTestFunc = func(srcIP netip.Addr, cap tailcfg.NodeCapability) bool
 
-func (ms matches) matchIPsOnly(q *packet.Parsed, hasCap CapTestFunc) bool {
+// matchIPsOnly returns the index of the first Match in ms that q's source
+// and destination IP addresses match, ignoring the protocol and ports, or
+// -1.
+func (ms matches) matchIPsOnly(q *packet.Parsed, hasCap CapTestFunc) int {
 	srcAddr := q.Src.Addr()
-	for _, m := range ms {
+	for i, m := range ms {
 		if !m.SrcsContains(srcAddr) {
 			continue
 		}
 		for _, dst := range m.Dsts {
 			if dst.Net.Contains(q.Dst.Addr()) {
-				return true
+				return i
 			}
 		}
 	}
 	if hasCap != nil {
-		for _, m := range ms {
+		for i, m := range ms {
 			for _, c := range m.SrcCaps {
 				if hasCap(srcAddr, c) {
-					return true
+					return i
 				}
 			}
 		}
 	}
-	return false
+	return -1
 }
 
-// matchProtoAndIPsOnlyIfAllPorts reports q matches any Match in ms where the
-// Match if for the right IP Protocol and IP address, but ports are
-// ignored, as long as the match is for the entire uint16 port range.
-func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
-	for _, m := range ms {
+// matchProtoAndIPsOnlyIfAllPorts returns the index of the first Match in ms
+// that is for q's IP protocol and IP addresses, or -1. Ports are ignored, as
+// long as the Match is for the entire uint16 port range.
+func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) int {
+	for i, m := range ms {
 		if !views.SliceContains(m.IPProto, q.IPProto) {
 			continue
 		}
@@ -99,9 +103,9 @@ func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
 				continue
 			}
 			if dst.Net.Contains(q.Dst.Addr()) {
-				return true
+				return i
 			}
 		}
 	}
-	return false
+	return -1
 }
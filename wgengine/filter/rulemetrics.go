@@ -0,0 +1,194 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"tailscale.com/feature/buildfeatures"
+	"tailscale.com/net/packet"
+	"tailscale.com/syncs"
+	"tailscale.com/util/usermetric"
+)
+
+// RuleLabels are the labels of the per-rule packet counters in
+// [RuleMetrics].
+type RuleLabels struct {
+	// Rule is the index of the rule in the Matches the filter was
+	// created with, as shown by "tailscale debug packet-filter-matches".
+	Rule int
+	// Src and Dst summarize the rule's sources and destinations.
+	Src string
+	Dst string
+}
+
+// ruleCounters are the packet counters of a single rule.
+type ruleCounters struct {
+	accepted *syncs.ShardedInt
+	dropped  *syncs.ShardedInt
+}
+
+// RuleMetrics counts the packets accepted and dropped by each rule of the
+// Filter it's tracking, and exports the counts as user metrics.
+//
+// A packet is counted as accepted by the rule that accepted it. A packet
+// dropped because no rule accepted it is counted as dropped by the first
+// rule that matches its source and destination IP addresses, ignoring its
+// protocol and ports; such a drop is usually a peer trying a port the
+// rule doesn't open. Drops that match no rule at all are only counted in
+// the inbound dropped packets metric.
+type RuleMetrics struct {
+	accepted *usermetric.MultiLabelMap[RuleLabels]
+	dropped  *usermetric.MultiLabelMap[RuleLabels]
+
+	mu       sync.Mutex
+	counters map[RuleLabels]*ruleCounters // for the filter last tracked
+}
+
+// NewRuleMetrics returns a new RuleMetrics, registering its metrics in reg.
+// It returns nil if user metrics are omitted from the build.
+func NewRuleMetrics(reg *usermetric.Registry) *RuleMetrics {
+	if !buildfeatures.HasUserMetrics {
+		return nil
+	}
+	return &RuleMetrics{
+		accepted: usermetric.NewMultiLabelMapWithRegistry[RuleLabels](
+			reg,
+			"tailscaled_filter_rule_accepted_packets_total",
+			"counter",
+			"Counts the number of packets from other peers accepted by each packet filter rule",
+		),
+		dropped: usermetric.NewMultiLabelMapWithRegistry[RuleLabels](
+			reg,
+			"tailscaled_filter_rule_dropped_packets_total",
+			"counter",
+			"Counts the number of packets from other peers dropped despite matching the addresses of each packet filter rule",
+		),
+	}
+}
+
+// Track starts counting packets against the rules of f, and stops counting
+// them against the rules of any filter tracked previously. The counts of a
+// rule are kept across filters if its labels are unchanged.
+//
+// Track must be called before f is in use. It is a no-op if m is nil.
+func (m *RuleMetrics) Track(f *Filter) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A rule with both IPv4 and IPv6 addresses is split across matches4
+	// and matches6; put it back together for its labels.
+	var parts [][]*Match
+	add := func(ms matches, rules []int) {
+		for i, rule := range rules {
+			if rule >= len(parts) {
+				parts = append(parts, make([][]*Match, rule+1-len(parts))...)
+			}
+			parts[rule] = append(parts[rule], &ms[i])
+		}
+	}
+	add(f.matches4, f.rules4)
+	add(f.matches6, f.rules6)
+
+	hits := make([]*ruleCounters, len(parts))
+	counters := make(map[RuleLabels]*ruleCounters)
+	for rule, ms := range parts {
+		if len(ms) == 0 {
+			continue
+		}
+		labels := RuleLabels{
+			Rule: rule,
+			Src:  srcSummary(ms),
+			Dst:  dstSummary(ms),
+		}
+		rc, ok := m.counters[labels]
+		if !ok {
+			rc = &ruleCounters{
+				accepted: syncs.NewShardedInt(),
+				dropped:  syncs.NewShardedInt(),
+			}
+			m.accepted.Set(labels, rc.accepted)
+			m.dropped.Set(labels, rc.dropped)
+		}
+		counters[labels] = rc
+		hits[rule] = rc
+	}
+	for labels := range m.counters {
+		if _, ok := counters[labels]; !ok {
+			m.accepted.Delete(labels)
+			m.dropped.Delete(labels)
+		}
+	}
+	m.counters = counters
+	f.hits = hits
+}
+
+// maxSummaryItems is the maximum number of sources or destinations listed
+// in a rule's labels.
+const maxSummaryItems = 3
+
+// srcSummary returns a summary of the sources of the rule split into ms.
+func srcSummary(ms []*Match) string {
+	var items []string
+	for _, m := range ms {
+		for _, p := range m.Srcs {
+			items = append(items, p.String())
+		}
+	}
+	// SrcCaps are the same in every part.
+	for _, c := range ms[0].SrcCaps {
+		items = append(items, "cap:"+string(c))
+	}
+	return summary(items)
+}
+
+// dstSummary returns a summary of the destinations of the rule split into
+// ms.
+func dstSummary(ms []*Match) string {
+	var items []string
+	for _, m := range ms {
+		for _, d := range m.Dsts {
+			items = append(items, d.String())
+		}
+	}
+	return summary(items)
+}
+
+// summary joins items, eliding all but the first maxSummaryItems so that
+// labels stay short for large rules.
+func summary(items []string) string {
+	if len(items) <= maxSummaryItems {
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprintf("%s,+%d more", strings.Join(items[:maxSummaryItems], ","), len(items)-maxSummaryItems)
+}
+
+// countRule counts q, to which the inbound filter gave verdict r, in the
+// counters of the rule that decided it. match is the index in ms of the
+// Match that accepted q, or -1. rules are the rule indexes of ms.
+func (f *Filter) countRule(q *packet.Parsed, r Response, ms matches, rules []int, match int) {
+	if f.hits == nil {
+		return
+	}
+	if match >= 0 {
+		if rc := f.hits[rules[match]]; rc != nil {
+			rc.accepted.Add(1)
+		}
+		return
+	}
+	if !r.IsDrop() {
+		// Accepted without a rule, such as a reply to an outbound flow.
+		return
+	}
+	if i := ms.matchIPsOnly(q, f.srcIPHasCap); i >= 0 {
+		if rc := f.hits[rules[i]]; rc != nil {
+			rc.dropped.Add(1)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"net/netip"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/tailcfg"
+)
+
+// TraceIn is like RunIn, but reports how the filter reached its verdict on
+// q. It doesn't log, nor count q in the filter's rule counters.
+func (f *Filter) TraceIn(q *packet.Parsed) Trace {
+	tr := f.newTrace(q, in)
+	r, _, why := preVerdict(q)
+	if r == noVerdict {
+		var match int
+		switch q.IPVersion {
+		case 4:
+			r, why, match = f.runIn4(q, &tr)
+			traceRule(&tr, f.matches4, f.rules4, match)
+		case 6:
+			r, why, match = f.runIn6(q, &tr)
+			traceRule(&tr, f.matches6, f.rules6, match)
+		default:
+			r, why = Drop, "not-ip"
+		}
+	}
+	tr.Verdict, tr.Reason = r.String(), why
+	return tr
+}
+
+// TraceOut is like RunOut, but reports how the filter reached its verdict
+// on q. Unlike RunOut, it doesn't log or add q's flow to the filter's
+// connection tracking state.
+func (f *Filter) TraceOut(q *packet.Parsed) Trace {
+	tr := f.newTrace(q, out)
+	r, _, why := preVerdict(q)
+	if r == noVerdict {
+		// runOut accepts everything; it only tracks flows.
+		r, why = Accept, "ok out"
+	}
+	tr.Verdict, tr.Reason = r.String(), why
+	return tr
+}
+
+func (f *Filter) newTrace(q *packet.Parsed, dir direction) Trace {
+	return Trace{
+		Dir:       dir.String(),
+		Packet:    q.String(),
+		Rule:      -1,
+		ShieldsUp: f.shieldsUp,
+	}
+}
+
+// traceRule records in tr that ms[i] decided the verdict, if i is
+// non-negative. rules are the indexes of ms in the filter's original
+// Matches.
+func traceRule(tr *Trace, ms matches, rules []int, i int) {
+	if i < 0 {
+		return
+	}
+	tr.Rule = rules[i]
+	tr.Match = ms[i].String()
+}
+
+// traceCapTest returns hasCap, wrapped to record its results in tr if tr
+// is non-nil.
+func traceCapTest(tr *Trace, hasCap CapTestFunc) CapTestFunc {
+	if tr == nil || hasCap == nil {
+		return hasCap
+	}
+	return func(src netip.Addr, c tailcfg.NodeCapability) bool {
+		has := hasCap(src, c)
+		tr.Caps = append(tr.Caps, TraceCap{Src: src, Cap: c, Has: has})
+		return has
+	}
+}
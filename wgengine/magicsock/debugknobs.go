@@ -66,6 +66,13 @@
 	// suppressing/dropping inbound/outbound [disco.Ping] messages, forcing
 	// all peer communication over DERP or peer relay.
 	debugNeverDirectUDP = envknob.RegisterBool("TS_DEBUG_NEVER_DIRECT_UDP")
+	// multipathInterfaces is a comma-separated list of local interfaces
+	// to turn on multipath mode over. See multipath.go.
+	multipathInterfaces = envknob.RegisterString("TS_EXPERIMENTAL_MULTIPATH_INTERFACES")
+	// multipathDuplicatePeers is a comma-separated list of peers, by
+	// Tailscale IP, MagicDNS name or hostname, whose packets multipath
+	// mode sends over two interfaces at once.
+	multipathDuplicatePeers = envknob.RegisterString("TS_EXPERIMENTAL_MULTIPATH_DUPLICATE_PEERS")
 	// Hey you! Adding a new debugknob? Make sure to stub it out in the
 	// debugknobs_stubs.go file too.
 )
@@ -32,3 +32,5 @@ func inTest() bool                     { return false }
 func debugPeerMap() bool               { return false }
 func pretendpoints() []netip.AddrPort  { return []netip.AddrPort{} }
 func debugNeverDirectUDP() bool        { return false }
+func multipathInterfaces() string      { return "" }
+func multipathDuplicatePeers() string  { return "" }
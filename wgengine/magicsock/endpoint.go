@@ -61,6 +61,7 @@ type endpoint struct {
 	lastRecvUDPAny        mono.Time // last time there were incoming UDP packets from this peer of any kind
 	numStopAndResetAtomic int64
 	debugUpdates          *ringlog.RingLog[EndpointChange]
+	pathHistory           *ringlog.RingLog[ipnstate.PeerPathChange]
 
 	// These fields are initialized once and never modified.
 	c            *Conn
@@ -86,9 +87,11 @@ type endpoint struct {
 	bestAddr           addrQuality // best non-DERP path; zero if none; mutate via setBestAddrLocked()
 	bestAddrAt         mono.Time   // time best address re-confirmed
 	trustBestAddrUntil mono.Time   // time when bestAddr expires
+	pathAddr           epAddr      // path last recorded in pathHistory; zero for DERP
 	sentPing           map[stun.TxID]sentPing
 	endpointState      map[netip.AddrPort]*endpointState // netip.AddrPort type for key (instead of [epAddr]) as [endpointState] is irrelevant for Geneve-encapsulated paths
 	isCallMeMaybeEP    map[netip.AddrPort]bool
+	multipath          *endpointMultipath // multipath mode state; nil if off
 
 	// The following fields are related to the new "silent disco"
 	// implementation that's a WIP as of 2022-10-20.
@@ -125,16 +128,29 @@ func (de *endpoint) udpRelayEndpointReady(maybeBest addrQuality) {
 		// TODO(jwhited): add observability around !curBestAddrTrusted and sameRelayServer
 		// TODO(jwhited): collapse path change logging with endpoint.handlePongConnLocked()
 		de.c.logf("magicsock: disco: node %v %v now using %v mtu=%v", de.publicKey.ShortString(), de.discoShort(), maybeBest.epAddr, maybeBest.wireMTU)
-		de.setBestAddrLocked(maybeBest)
+		var why string
+		switch {
+		case !curBestAddrTrusted:
+			why = "peer relay path ready; no trusted path"
+		case sameRelayServer:
+			why = "peer relay path re-established with the same relay server"
+		default:
+			why = "peer relay path ready and better than the current path"
+		}
+		de.setBestAddrLocked(maybeBest, why)
+		de.bestAddrAt = now
 		de.trustBestAddrUntil = now.Add(trustUDPAddrDuration)
 	}
 }
 
-func (de *endpoint) setBestAddrLocked(v addrQuality) {
+// setBestAddrLocked sets de.bestAddr to v, recording why in de's path
+// history if the path changes.
+func (de *endpoint) setBestAddrLocked(v addrQuality, why string) {
 	if v.epAddr != de.bestAddr.epAddr {
 		de.probeUDPLifetime.resetCycleEndpointLocked()
 	}
 	de.bestAddr = v
+	de.notePathLocked(v, why)
 }
 
 const (
@@ -372,6 +388,8 @@ type sentPing struct {
 	purpose discoPingPurpose
 	size    int                    // size of the disco message
 	resCB   *pingResultAndCallback // or nil for internal use
+
+	multipath *multipathPath // path probed in multipath mode, or nil
 }
 
 // endpointState is some state and history for a specific endpoint of
@@ -485,7 +503,7 @@ func (de *endpoint) deleteEndpointLocked(why string, ep netip.AddrPort) {
 			What: "deleteEndpointLocked-bestAddr-" + why,
 			From: de.bestAddr,
 		})
-		de.setBestAddrLocked(addrQuality{})
+		de.setBestAddrLocked(addrQuality{}, "best path's endpoint removed ("+why+")")
 	}
 }
 
@@ -572,6 +590,9 @@ func (de *endpoint) addrForSendLocked(now mono.Time) (udpAddr epAddr, derpAddr n
 	if udpAddr.ap.IsValid() && !now.After(de.trustBestAddrUntil) {
 		return udpAddr, netip.AddrPort{}, false
 	}
+	if udpAddr.ap.IsValid() {
+		de.notePathLocked(addrQuality{}, "best path not confirmed by a pong in time; falling back to DERP while probing it")
+	}
 
 	if de.isWireguardOnly {
 		// If the endpoint is wireguard-only, we don't have a DERP
@@ -1057,8 +1078,12 @@ func (de *endpoint) send(buffs [][]byte, offset int) error {
 	}
 	de.noteTxActivityExtTriggerLocked(now)
 	de.lastSendAny = now
+	mp1, mp2 := de.multipathSendPathsLocked(now)
 	de.mu.Unlock()
 
+	if mp1 != nil && de.sendMultipath(mp1, mp2, buffs, offset) {
+		return nil
+	}
 	if !udpAddr.ap.IsValid() && !derpAddr.IsValid() {
 		// Make a last ditch effort to see if we have a DERP route for them. If
 		// they contacted us over DERP and we don't know their UDP endpoints or
@@ -1177,10 +1202,16 @@ func (de *endpoint) discoPingTimeout(txid stun.TxID) {
 	if !ok {
 		return
 	}
+	if sp.multipath != nil {
+		de.removeSentDiscoPingLocked(txid, sp, discoPingTimedOut)
+		sp.multipath.addResult(false)
+		de.selectMultipathLocked(mono.Now())
+		return
+	}
 	bestUntrusted := mono.Now().After(de.trustBestAddrUntil)
 	if sp.to == de.bestAddr.epAddr && sp.to.vni.IsSet() && bestUntrusted {
 		// TODO(jwhited): consider applying this to direct UDP paths as well
-		de.clearBestAddrLocked()
+		de.clearBestAddrLocked("ping over untrusted peer relay path timed out")
 	}
 	if debugDisco() || !de.bestAddr.ap.IsValid() || bestUntrusted {
 		de.c.dlogf("[v1] magicsock: disco: timeout waiting for pong %x from %v (%v, %v)", txid[:6], sp.to, de.publicKey.ShortString(), de.discoShort())
@@ -1481,6 +1512,12 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 		de.setProbeUDPLifetimeConfigLocked(nil)
 	}
 	de.expired = n.Expired()
+	if len(de.c.multipath) > 0 && !de.isWireguardOnly {
+		if de.multipath == nil {
+			de.multipath = &endpointMultipath{paths: make(map[multipathKey]*multipathPath)}
+		}
+		de.multipath.duplicate = de.c.isMultipathDuplicatePeer(n)
+	}
 
 	epDisco := de.disco.Load()
 	var discoKey key.DiscoPublic
@@ -1613,11 +1650,12 @@ func (de *endpoint) addCandidateEndpoint(ep netip.AddrPort, forRxPingTxID stun.T
 }
 
 // clearBestAddrLocked clears the bestAddr and related fields such that future
-// packets will re-evaluate the best address to send to next.
+// packets will re-evaluate the best address to send to next. why is recorded
+// in de's path history.
 //
 // de.mu must be held.
-func (de *endpoint) clearBestAddrLocked() {
-	de.setBestAddrLocked(addrQuality{})
+func (de *endpoint) clearBestAddrLocked(why string) {
+	de.setBestAddrLocked(addrQuality{}, why)
 	de.bestAddrAt = 0
 	de.trustBestAddrUntil = 0
 }
@@ -1629,7 +1667,7 @@ func (de *endpoint) noteBadEndpoint(udpAddr epAddr) {
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
-	de.clearBestAddrLocked()
+	de.clearBestAddrLocked(fmt.Sprintf("send to %v failed", udpAddr))
 
 	if !udpAddr.vni.IsSet() {
 		if st, ok := de.endpointState[udpAddr.ap]; ok {
@@ -1645,11 +1683,12 @@ func (de *endpoint) noteConnectivityChange() {
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
-	de.clearBestAddrLocked()
+	de.clearBestAddrLocked("local network connectivity changed")
 
 	for k := range de.endpointState {
 		de.endpointState[k].clear()
 	}
+	de.multipath.resetLocked()
 }
 
 // pingSizeToPktLen calculates the minimum path MTU that would permit
@@ -1694,7 +1733,8 @@ func pktLenToPingSize(mtu tstun.WireMTU, is6 bool) int {
 // It should be called with the Conn.mu held.
 //
 // It reports whether m.TxID corresponds to a ping that this endpoint sent.
-func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src epAddr) (knownTxID bool) {
+// mi is non-nil if the pong arrived over a multipath socket.
+func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src epAddr, mi *multipathIface) (knownTxID bool) {
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
@@ -1706,6 +1746,13 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src epAdd
 		return false
 	}
 	knownTxID = true // for naked returns below
+	if sp.multipath != nil && sp.multipath.mi != mi || sp.multipath == nil && mi != nil {
+		// The pong came back over a different socket than the ping went
+		// out on, so it doesn't show that either path works. Let the
+		// ping time out.
+		de.c.dlogf("[v1] magicsock: disco: ignoring pong tx=%x from %v (%v) over another socket than its ping", m.TxID[:6], de.publicKey.ShortString(), src)
+		return
+	}
 	de.removeSentDiscoPingLocked(m.TxID, sp, discoPongReceived)
 
 	pktLen := int(pingSizeToPktLen(sp.size, src))
@@ -1720,6 +1767,13 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src epAdd
 	now := mono.Now()
 	latency := now.Sub(sp.at)
 
+	if sp.multipath != nil {
+		// Not a pong over the regular sockets, so it says nothing about
+		// de.endpointState.
+		de.handleMultipathPongLocked(sp.multipath, latency, now)
+		return
+	}
+
 	if !isDerp && !src.vni.IsSet() {
 		// Note: we check vni.isSet() as relay [epAddr]'s are not stored in
 		// endpointState, they are either de.bestAddr or not.
@@ -1776,9 +1830,10 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src epAdd
 				From: de.bestAddr,
 				To:   thisPong,
 			})
-			de.setBestAddrLocked(thisPong)
+			de.setBestAddrLocked(thisPong, "pong: lower latency or preferred address")
 		}
 		if de.bestAddr.epAddr == thisPong.epAddr {
+			de.notePathLocked(thisPong, "pong re-confirmed best path")
 			de.debugUpdates.Add(EndpointChange{
 				When: time.Now(),
 				What: "handlePongConnLocked-bestAddr-latency",
@@ -2006,6 +2061,9 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 	defer de.mu.Unlock()
 
 	ps.Relay = de.c.derpRegionCodeOfIDLocked(int(de.derpAddr.Port()))
+	if de.multipath != nil {
+		ps.Multipath = de.multipath.statusLocked(mono.Now())
+	}
 
 	if de.lastSendExt.IsZero() {
 		return
@@ -2058,7 +2116,7 @@ func (de *endpoint) stopAndReset() {
 func (de *endpoint) resetLocked() {
 	de.lastSendExt = 0
 	de.lastFullPing = 0
-	de.clearBestAddrLocked()
+	de.clearBestAddrLocked("peer state reset")
 	for _, es := range de.endpointState {
 		es.lastPing = 0
 	}
@@ -2068,6 +2126,7 @@ func (de *endpoint) resetLocked() {
 		}
 	}
 	de.probeUDPLifetime.resetCycleEndpointLocked()
+	de.multipath.resetLocked()
 	de.c.relayManager.stopWork(de)
 }
 
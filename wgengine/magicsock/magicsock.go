@@ -38,6 +38,7 @@
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/batching"
 	"tailscale.com/net/netcheck"
+	"tailscale.com/net/netcheck/netchecktype"
 	"tailscale.com/net/neterror"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
@@ -187,6 +188,13 @@ type Conn struct {
 	pconn4 RebindingUDPConn
 	pconn6 RebindingUDPConn
 
+	// multipath are the interfaces multipath mode sends over, in the
+	// order configured, and multipathDuplicatePeers are the peers whose
+	// packets it also duplicates over a second interface. Both are empty
+	// unless multipath mode is on. See multipath.go.
+	multipath               []*multipathIface
+	multipathDuplicatePeers []string
+
 	receiveBatchPool sync.Pool
 
 	// closeDisco4 and closeDisco6 are io.Closers to shut down the raw
@@ -668,10 +676,14 @@ func NewConn(opts Options) (*Conn, error) {
 	c.netMon = opts.NetMon
 	c.health = opts.HealthTracker
 	c.getPeerByKey = opts.PeerByKeyFunc
+	c.initMultipath()
 
 	if err := c.rebind(keepCurrentPort); err != nil {
 		return nil, err
 	}
+	if len(c.multipath) > 0 {
+		eventbus.SubscribeFunc(ec, c.onLinkChangeForMultipath)
+	}
 
 	c.netChecker = &netcheck.Client{
 		Logf:                logger.WithPrefix(c.logf, "netcheck: "),
@@ -680,6 +692,7 @@ func NewConn(opts Options) (*Conn, error) {
 		SkipExternalNetwork: inTest(),
 		PortMapper:          c.portMapper,
 		UseDNSCache:         true,
+		HistorySize:         netcheckHistorySize,
 	}
 
 	c.metrics = registerMetrics(opts.Metrics)
@@ -1114,6 +1127,34 @@ func (c *Conn) LastRecvActivityOfNodeKey(nk key.NodePublic) string {
 	return mono.Since(saw).Round(time.Second).String()
 }
 
+// PeerPathOfNodeKey describes the path packets to the peer with node key nk
+// are currently sent on: "direct <ip:port>", "peer-relay <addr>" or
+// "derp-<region>". It returns the empty string if nk isn't a known peer.
+func (c *Conn) PeerPathOfNodeKey(nk key.NodePublic) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	de, ok := c.peerMap.endpointForNodeKey(nk)
+	if !ok {
+		return ""
+	}
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	if best := de.bestAddr.epAddr; best.ap.IsValid() && !mono.Now().After(de.trustBestAddrUntil) {
+		if best.vni.IsSet() {
+			return "peer-relay " + best.String()
+		}
+		return "direct " + best.ap.String()
+	}
+	if !de.derpAddr.IsValid() {
+		return ""
+	}
+	region := int(de.derpAddr.Port())
+	if code := c.derpRegionCodeOfIDLocked(region); code != "" {
+		return "derp-" + code
+	}
+	return fmt.Sprintf("derp-%d", region)
+}
+
 // Ping handles a "tailscale ping" CLI query.
 func (c *Conn) Ping(peer tailcfg.NodeView, res *ipnstate.PingResult, size int, cb func(*ipnstate.PingResult)) {
 	c.mu.Lock()
@@ -1220,10 +1261,11 @@ func (c *Conn) RotateDiscoKey() {
 //
 // c.mu must NOT be held.
 func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, error) {
-	var havePortmap bool
-	var portmapExt netip.AddrPort
+	var havePortmap, havePinhole bool
+	var portmapExt, pinholeExt netip.AddrPort
 	if runtime.GOOS != "js" && c.portMapper != nil {
 		portmapExt, havePortmap = c.portMapper.GetCachedMappingOrStartCreatingOne()
+		pinholeExt, havePinhole = c.portMapper.GetCachedPinholeOrStartCreatingOne()
 	}
 
 	nr, err := c.updateNetInfo(ctx)
@@ -1270,6 +1312,16 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 		c.setNetInfoHavePortMap()
 	}
 
+	// Likewise for an IPv6 firewall pinhole. Its address is also one of
+	// our local addresses, but add it first so it's marked as known to be
+	// reachable from the outside.
+	if !havePinhole && runtime.GOOS != "js" && c.portMapper != nil {
+		pinholeExt, havePinhole = c.portMapper.GetCachedPinholeOrStartCreatingOne()
+	}
+	if havePinhole {
+		addAddr(pinholeExt, tailcfg.EndpointPortmapped)
+	}
+
 	v4Addrs, v6Addrs := nr.GetGlobalAddrs()
 	for _, addr := range v4Addrs {
 		addAddr(addr, tailcfg.EndpointSTUN)
@@ -1277,6 +1329,10 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 	for _, addr := range v6Addrs {
 		addAddr(addr, tailcfg.EndpointSTUN)
 	}
+	for _, addr := range c.multipathEndpoints() {
+		addAddr(addr, tailcfg.EndpointSTUN)
+	}
+	c.stunMultipath()
 
 	if len(v4Addrs) >= 1 {
 		// If they're behind a hard NAT and are using a fixed
@@ -1656,7 +1712,7 @@ func (c *Conn) putReceiveBatch(batch *receiveBatch) {
 }
 
 func (c *Conn) receiveIPv4() conn.ReceiveFunc {
-	return c.mkReceiveFunc(&c.pconn4, c.health.ReceiveFuncStats(health.ReceiveIPv4),
+	return c.mkReceiveFunc(&c.pconn4, c.health.ReceiveFuncStats(health.ReceiveIPv4), discoRXPathUDP, nil,
 		&c.metrics.inboundPacketsIPv4Total,
 		&c.metrics.inboundPacketsPeerRelayIPv4Total,
 		&c.metrics.inboundBytesIPv4Total,
@@ -1666,7 +1722,7 @@ func (c *Conn) receiveIPv4() conn.ReceiveFunc {
 
 // receiveIPv6 creates an IPv6 ReceiveFunc reading from c.pconn6.
 func (c *Conn) receiveIPv6() conn.ReceiveFunc {
-	return c.mkReceiveFunc(&c.pconn6, c.health.ReceiveFuncStats(health.ReceiveIPv6),
+	return c.mkReceiveFunc(&c.pconn6, c.health.ReceiveFuncStats(health.ReceiveIPv6), discoRXPathUDP, nil,
 		&c.metrics.inboundPacketsIPv6Total,
 		&c.metrics.inboundPacketsPeerRelayIPv6Total,
 		&c.metrics.inboundBytesIPv6Total,
@@ -1674,9 +1730,10 @@ func (c *Conn) receiveIPv6() conn.ReceiveFunc {
 	)
 }
 
-// mkReceiveFunc creates a ReceiveFunc reading from ruc.
+// mkReceiveFunc creates a ReceiveFunc reading from ruc, whose disco
+// messages arrive via via.
 // The provided healthItem and metrics are updated if non-nil.
-func (c *Conn) mkReceiveFunc(ruc *RebindingUDPConn, healthItem *health.ReceiveFuncStats, directPacketMetric, peerRelayPacketMetric, directBytesMetric, peerRelayBytesMetric *expvar.Int) conn.ReceiveFunc {
+func (c *Conn) mkReceiveFunc(ruc *RebindingUDPConn, healthItem *health.ReceiveFuncStats, via discoRXPath, mi *multipathIface, directPacketMetric, peerRelayPacketMetric, directBytesMetric, peerRelayBytesMetric *expvar.Int) conn.ReceiveFunc {
 	// epCache caches an epAddr->endpoint for hot flows.
 	var epCache epAddrEndpointCache
 
@@ -1712,7 +1769,7 @@ func (c *Conn) mkReceiveFunc(ruc *RebindingUDPConn, healthItem *health.ReceiveFu
 					continue
 				}
 				ipp := msg.Addr.(*net.UDPAddr).AddrPort()
-				if ep, size, isGeneveEncap, ok := c.receiveIP(msg.Buffers[0][:msg.N], ipp, &epCache); ok {
+				if ep, size, isGeneveEncap, ok := c.receiveIP(msg.Buffers[0][:msg.N], ipp, via, mi, &epCache); ok {
 					if isGeneveEncap {
 						if peerRelayPacketMetric != nil {
 							peerRelayPacketMetric.Add(1)
@@ -1759,7 +1816,10 @@ func looksLikeInitiationMsg(b []byte) bool {
 //
 // ok is whether this read should be reported up to wireguard-go (our
 // caller).
-func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, cache *epAddrEndpointCache) (_ conn.Endpoint, size int, isGeneveEncap bool, ok bool) {
+//
+// via is the socket b was read from, as far as disco is concerned, and mi
+// is its multipath interface if it's a multipath socket.
+func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, via discoRXPath, mi *multipathIface, cache *epAddrEndpointCache) (_ conn.Endpoint, size int, isGeneveEncap bool, ok bool) {
 	var ep *endpoint
 	size = len(b)
 
@@ -1786,9 +1846,13 @@ func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, cache *epAddrEndpointCach
 		// have yet to open the encrypted disco payload to determine the
 		// [disco.MessageType], but we assert it should be handshake-related.
 		shouldByRelayHandshakeMsg := geneve.Control == true
-		c.handleDiscoMessage(b, src, shouldByRelayHandshakeMsg, key.NodePublic{}, discoRXPathUDP)
+		c.handleDiscoMessage(b, src, shouldByRelayHandshakeMsg, key.NodePublic{}, via, mi)
 		return nil, 0, false, false
 	case packetLooksLikeSTUNBinding:
+		if mi != nil {
+			c.handleMultipathSTUN(mi, b)
+			return nil, 0, false, false
+		}
 		c.netChecker.ReceiveSTUNPacket(b, ipp)
 		return nil, 0, false, false
 	default:
@@ -1899,6 +1963,12 @@ func (c *Conn) sendDiscoAllocateUDPRelayEndpointRequest(dst epAddr, dstKey key.N
 // The dstKey should only be non-zero if the dstDisco key
 // unambiguously maps to exactly one peer.
 func (c *Conn) sendDiscoMessage(dst epAddr, dstKey key.NodePublic, dstDisco key.DiscoPublic, m disco.Message, logLevel discoLogLevel) (sent bool, err error) {
+	return c.sendDiscoMessageVia(nil, dst, dstKey, dstDisco, m, logLevel)
+}
+
+// sendDiscoMessageVia is like sendDiscoMessage, but sends over the multipath
+// interface mi if it's non-nil.
+func (c *Conn) sendDiscoMessageVia(mi *multipathIface, dst epAddr, dstKey key.NodePublic, dstDisco key.DiscoPublic, m disco.Message, logLevel discoLogLevel) (sent bool, err error) {
 	isDERP := dst.ap.Addr() == tailcfg.DerpMagicIPAddr
 	if _, isPong := m.(*disco.Pong); isPong && !isDERP && dst.ap.Addr().Is4() {
 		time.Sleep(debugIPv4DiscoPingPenalty())
@@ -1961,8 +2031,12 @@ func (c *Conn) sendDiscoMessage(dst epAddr, dstKey key.NodePublic, dstDisco key.
 
 	box := di.sharedKey.Seal(m.AppendMarshal(nil))
 	pkt = append(pkt, box...)
-	const isDisco = true
-	sent, err = c.sendAddr(dst.ap, dstKey, pkt, isDisco, dst.vni.IsSet())
+	if mi != nil {
+		sent, err = mi.sendUDP(dst.ap, pkt)
+	} else {
+		const isDisco = true
+		sent, err = c.sendAddr(dst.ap, dstKey, pkt, isDisco, dst.vni.IsSet())
+	}
 	if sent {
 		if logLevel == discoLog || (logLevel == discoVerboseLog && debugDisco()) {
 			node := "?"
@@ -2010,6 +2084,7 @@ func (c *Conn) sendDiscoMessage(dst epAddr, dstKey key.NodePublic, dstDisco key.
 	discoRXPathUDP       discoRXPath = "UDP socket"
 	discoRXPathDERP      discoRXPath = "DERP"
 	discoRXPathRawSocket discoRXPath = "raw socket"
+	discoRXPathMultipath discoRXPath = "multipath socket"
 )
 
 const discoHeaderLen = len(disco.Magic) + key.DiscoPublicRawLen
@@ -2122,7 +2197,7 @@ func packetLooksLike(msg []byte) (t packetLooksLikeType, isGeneveEncap bool) {
 //
 // 'shouldBeRelayHandshakeMsg' will be true if 'msg' was encapsulated
 // by a Geneve header with the control bit set.
-func (c *Conn) handleDiscoMessage(msg []byte, src epAddr, shouldBeRelayHandshakeMsg bool, derpNodeSrc key.NodePublic, via discoRXPath) {
+func (c *Conn) handleDiscoMessage(msg []byte, src epAddr, shouldBeRelayHandshakeMsg bool, derpNodeSrc key.NodePublic, via discoRXPath, mi *multipathIface) {
 	sender := key.DiscoPublicFromRaw32(mem.B(msg[len(disco.Magic):discoHeaderLen]))
 
 	c.mu.Lock()
@@ -2237,7 +2312,7 @@ func (c *Conn) handleDiscoMessage(msg []byte, src epAddr, shouldBeRelayHandshake
 	switch dm := dm.(type) {
 	case *disco.Ping:
 		metricRecvDiscoPing.Add(1)
-		c.handlePingLocked(dm, src, di, derpNodeSrc)
+		c.handlePingLocked(dm, src, di, derpNodeSrc, mi)
 	case *disco.Pong:
 		metricRecvDiscoPong.Add(1)
 		// There might be multiple nodes for the sender's DiscoKey.
@@ -2245,7 +2320,7 @@ func (c *Conn) handleDiscoMessage(msg []byte, src epAddr, shouldBeRelayHandshake
 		// the Pong's TxID was theirs.
 		knownTxID := false
 		c.peerMap.forEachEndpointWithDiscoKey(sender, func(ep *endpoint) (keepGoing bool) {
-			if ep.handlePongConnLocked(dm, di, src) {
+			if ep.handlePongConnLocked(dm, di, src, mi) {
 				knownTxID = true
 				return false
 			}
@@ -2466,7 +2541,8 @@ func (c *Conn) unambiguousNodeKeyOfPingLocked(dm *disco.Ping, dk key.DiscoPublic
 
 // di is the discoInfo of the source of the ping.
 // derpNodeSrc is non-zero if the ping arrived via DERP.
-func (c *Conn) handlePingLocked(dm *disco.Ping, src epAddr, di *discoInfo, derpNodeSrc key.NodePublic) {
+// mi is non-nil if the ping arrived over a multipath socket.
+func (c *Conn) handlePingLocked(dm *disco.Ping, src epAddr, di *discoInfo, derpNodeSrc key.NodePublic, mi *multipathIface) {
 	likelyHeartBeat := src == di.lastPingFrom && time.Since(di.lastPingTime) < 5*time.Second
 	di.lastPingFrom = src
 	di.lastPingTime = time.Now()
@@ -2559,10 +2635,17 @@ func (c *Conn) handlePingLocked(dm *disco.Ping, src epAddr, di *discoInfo, derpN
 
 	ipDst := src
 	discoDest := di.discoKey
-	go c.sendDiscoMessage(ipDst, dstKey, discoDest, &disco.Pong{
+	go c.sendDiscoMessageVia(mi, ipDst, dstKey, discoDest, &disco.Pong{
 		TxID: dm.TxID,
 		Src:  src.ap,
 	}, discoVerboseLog)
+
+	if mi != nil {
+		c.peerMap.forEachEndpointWithDiscoKey(di.discoKey, func(ep *endpoint) (keepGoing bool) {
+			ep.handleMultipathPing(mi, src.ap)
+			return true
+		})
+	}
 }
 
 // enqueueCallMeMaybe schedules a send of disco.CallMeMaybe to de via derpAddr
@@ -3112,6 +3195,7 @@ func (c *Conn) updateNodes(self tailcfg.NodeView, peers []tailcfg.NodeView) (pee
 		default:
 			ep.debugUpdates = ringlog.New[EndpointChange](entriesPerBuffer)
 		}
+		ep.pathHistory = ringlog.New[ipnstate.PeerPathChange](pathHistorySize)
 		if n.Addresses().Len() > 0 {
 			ep.nodeAddr = n.Addresses().At(0).Addr()
 		}
@@ -3249,6 +3333,7 @@ func (c *connBind) Open(ignoredPort uint16) ([]conn.ReceiveFunc, uint16, error)
 	}
 	c.closed = false
 	fns := []conn.ReceiveFunc{c.receiveIPv4(), c.receiveIPv6(), c.receiveDERP}
+	fns = append(fns, c.receiveMultipath()...)
 	if runtime.GOOS == "js" {
 		fns = []conn.ReceiveFunc{c.receiveDERP}
 	}
@@ -3278,6 +3363,7 @@ func (c *connBind) Close() error {
 	// Unblock all outstanding receives.
 	c.pconn4.Close()
 	c.pconn6.Close()
+	c.closeMultipath()
 	if c.closeDisco4 != nil {
 		c.closeDisco4.Close()
 	}
@@ -3334,6 +3420,7 @@ func (c *Conn) Close() error {
 	// They will frequently have been closed already by a call to connBind.Close.
 	c.pconn6.Close()
 	c.pconn4.Close()
+	c.closeMultipath()
 	if c.closeDisco4 != nil {
 		c.closeDisco4.Close()
 	}
@@ -3579,6 +3666,10 @@ func (c *Conn) rebind(curPortFate currentPortFate) error {
 	}
 	if c.portMapper != nil {
 		c.portMapper.SetLocalPort(c.LocalPort())
+		c.portMapper.SetLocalPort6(uint16(c.pconn6.LocalAddr().Port))
+	}
+	if len(c.multipath) > 0 {
+		c.rebindMultipath(c.netMon.InterfaceState())
 	}
 	c.UpdatePMTUD()
 	return nil
@@ -4094,6 +4185,17 @@ func (c *Conn) GetLastNetcheckReport(ctx context.Context) *netcheck.Report {
 	return c.lastNetCheckReport.Load()
 }
 
+// netcheckHistorySize is the number of entries kept in the history of
+// netcheck reports returned by NetcheckHistory.
+const netcheckHistorySize = 64
+
+// NetcheckHistory returns the history of netcheck reports, oldest first.
+// Consecutive reports with the same results on the same network share an
+// entry.
+func (c *Conn) NetcheckHistory() []netchecktype.HistoryEntry {
+	return c.netChecker.History()
+}
+
 // SetLastNetcheckReportForTest sets the magicsock conn's last netcheck report.
 // Used for testing purposes.
 func (c *Conn) SetLastNetcheckReportForTest(ctx context.Context, report *netcheck.Report) {
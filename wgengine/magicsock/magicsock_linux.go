@@ -458,7 +458,7 @@ func (c *Conn) receiveDisco(pc *socket.Conn, isIPV6 bool) {
 			// The BPF program matching on disco does not currently support
 			// Geneve encapsulation. isGeneveEncap should not return true if
 			// payload is disco.
-			c.handleDiscoMessage(payload, epAddr{ap: srcAddr}, false, key.NodePublic{}, discoRXPathRawSocket)
+			c.handleDiscoMessage(payload, epAddr{ap: srcAddr}, false, key.NodePublic{}, discoRXPathRawSocket, nil)
 		}
 	}
 }
@@ -0,0 +1,724 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/netip"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tailscale/wireguard-go/conn"
+	"tailscale.com/disco"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/neterror"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/netns"
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+	"tailscale.com/types/nettype"
+)
+
+// Multipath mode is an experimental, opt-in mode for nodes with more than
+// one uplink, such as a vehicle with both LTE and satellite. It's turned on
+// by listing the uplinks' interfaces in TS_EXPERIMENTAL_MULTIPATH_INTERFACES.
+//
+// For each listed interface, we keep a UDP socket bound to it and probe
+// each peer's direct endpoints over it with disco pings. Packets to a peer
+// go over the lowest-latency path that's up, failing over to another as
+// soon as probes stop coming back, and falling back to the regular sockets
+// (and so to the regular direct path, peer relay or DERP) when no path is
+// up. For peers listed in TS_EXPERIMENTAL_MULTIPATH_DUPLICATE_PEERS,
+// packets are also sent over the best path on a second interface, and
+// WireGuard's replay protection drops whichever copy arrives last.
+//
+// The multipath sockets also receive: they answer peers' disco pings, so
+// peers can pick them as paths to us like any other endpoint. To let peers
+// behind NATs reach them, each socket learns its address as seen from the
+// internet by STUN to our home DERP region, and we advertise that as one
+// of our endpoints. A peer then pings it while we probe the peer over the
+// socket, opening both NATs as for the regular sockets. A probe's pong only
+// counts if it comes back over the socket the probe went out on.
+
+const (
+	// multipathProbeInterval is how often we probe the best path over
+	// each multipath interface while the peer's session is active. Other
+	// paths are probed every discoPingInterval.
+	multipathProbeInterval = 1 * time.Second
+	// multipathPathTimeout is how long a path stays up without a pong,
+	// and how long we wait for the pong to each probe.
+	multipathPathTimeout = 3 * time.Second
+	// multipathResultCount is how many recent probe results we compute a
+	// path's loss from. It must be at most 32.
+	multipathResultCount = 16
+	// multipathMaxLoss is the highest loss a path can have and be up.
+	multipathMaxLoss = 0.5
+)
+
+// multipathIface is a local interface multipath mode sends over.
+type multipathIface struct {
+	name   string
+	pconn4 RebindingUDPConn
+	pconn6 RebindingUDPConn
+	up4    atomic.Bool // whether pconn4 is bound to the interface
+	up6    atomic.Bool // whether pconn6 is bound to the interface
+
+	// mu guards the following fields, for learning the sockets'
+	// addresses as seen from the internet.
+	mu       sync.Mutex
+	stunTxID [2]stun.TxID      // last STUN request over pconn4, pconn6
+	ext      [2]netip.AddrPort // pconn4's, pconn6's address per STUN; zero if unknown
+}
+
+// multipathFamily returns the index of ap's address family in mi.stunTxID and mi.ext.
+func multipathFamily(ap netip.AddrPort) int {
+	if ap.Addr().Is4() {
+		return 0
+	}
+	return 1
+}
+
+// conn returns mi's socket for sending to dst, and whether it's bound.
+func (mi *multipathIface) conn(dst netip.AddrPort) (_ *RebindingUDPConn, bound bool) {
+	if dst.Addr().Is4() {
+		return &mi.pconn4, mi.up4.Load()
+	}
+	return &mi.pconn6, mi.up6.Load()
+}
+
+// canSendTo reports whether mi has a bound socket for sending to dst.
+func (mi *multipathIface) canSendTo(dst netip.AddrPort) bool {
+	_, bound := mi.conn(dst)
+	return bound
+}
+
+// sendUDP sends b to dst over mi. See sendAddr's docs on the return value
+// meanings.
+func (mi *multipathIface) sendUDP(dst netip.AddrPort, b []byte) (sent bool, err error) {
+	ruc, bound := mi.conn(dst)
+	if !bound {
+		return false, nil
+	}
+	_, err = ruc.WriteToUDPAddrPort(b, dst)
+	if neterror.TreatAsLostUDP(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// splitMultipathList splits the comma-separated list s, dropping empty and
+// duplicate entries.
+func splitMultipathList(s string) []string {
+	var ret []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" && !slices.Contains(ret, f) {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// initMultipath turns on multipath mode if it's configured. It must be
+// called before the first rebind.
+func (c *Conn) initMultipath() {
+	names := splitMultipathList(multipathInterfaces())
+	if len(names) == 0 || runtime.GOOS == "js" {
+		return
+	}
+	for _, name := range names {
+		mi := &multipathIface{name: name}
+		for _, ruc := range []*RebindingUDPConn{&mi.pconn4, &mi.pconn6} {
+			ruc.mu.Lock()
+			ruc.setConnLocked(newBlockForeverConn(), "", c.bind.BatchSize())
+			ruc.mu.Unlock()
+		}
+		c.multipath = append(c.multipath, mi)
+	}
+	c.multipathDuplicatePeers = splitMultipathList(multipathDuplicatePeers())
+	c.logf("magicsock: multipath mode on over %q; duplicating packets to %q", names, c.multipathDuplicatePeers)
+}
+
+// isMultipathDuplicatePeer reports whether n is one of the peers whose
+// packets multipath mode duplicates.
+func (c *Conn) isMultipathDuplicatePeer(n tailcfg.NodeView) bool {
+	name := strings.TrimSuffix(n.Name(), ".")
+	short, _, _ := strings.Cut(name, ".")
+	var hostname string
+	if hi := n.Hostinfo(); hi.Valid() {
+		hostname = hi.Hostname()
+	}
+	for _, s := range c.multipathDuplicatePeers {
+		if ip, err := netip.ParseAddr(s); err == nil {
+			for _, pfx := range n.Addresses().All() {
+				if pfx.Addr() == ip {
+					return true
+				}
+			}
+			continue
+		}
+		s = strings.TrimSuffix(s, ".")
+		if strings.EqualFold(s, name) || strings.EqualFold(s, short) || strings.EqualFold(s, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// onLinkChangeForMultipath binds and unbinds the multipath sockets as
+// their interfaces come and go.
+func (c *Conn) onLinkChangeForMultipath(delta netmon.ChangeDelta) {
+	c.rebindMultipath(delta.CurrentState())
+}
+
+// rebindMultipath binds each multipath socket to its interface if the
+// interface is up with an address of the socket's family, and unbinds it
+// otherwise.
+func (c *Conn) rebindMultipath(st *netmon.State) {
+	for _, mi := range c.multipath {
+		c.bindMultipathSocket(mi, &mi.pconn4, &mi.up4, "udp4", st)
+		c.bindMultipathSocket(mi, &mi.pconn6, &mi.up6, "udp6", st)
+	}
+}
+
+// bindMultipathSocket binds or unbinds ruc, mi's socket for network, as
+// rebindMultipath says, if that changes whether it's bound, per up.
+func (c *Conn) bindMultipathSocket(mi *multipathIface, ruc *RebindingUDPConn, up *atomic.Bool, network string, st *netmon.State) {
+	want := c.testOnlyPacketListener != nil
+	if ifc, ok := st.Interface[mi.name]; ok && ifc.IsUp() {
+		for _, pfx := range st.InterfaceIPs[mi.name] {
+			if a := pfx.Addr(); a.IsGlobalUnicast() && a.Is4() == (network == "udp4") {
+				want = true
+			}
+		}
+	}
+	if want == up.Load() {
+		return
+	}
+
+	ruc.mu.Lock()
+	defer ruc.mu.Unlock()
+	var ports []uint16
+	if ruc.pconn != nil {
+		ports = append(ports, uint16(ruc.localAddrLocked().Port))
+	}
+	ports = append(ports, 0)
+	ports = slices.Compact(ports)
+
+	up.Store(false)
+	mi.mu.Lock()
+	mi.ext[multipathFamilyOf(network)] = netip.AddrPort{}
+	mi.mu.Unlock()
+	if err := ruc.closeLocked(); err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, errNilPConn) {
+		c.logf("magicsock: multipath: closing %v socket on %s: %v", network, mi.name, err)
+	}
+	if !want {
+		c.logf("magicsock: multipath: %s has no %v address; not sending over it", mi.name, network)
+		ruc.setConnLocked(newBlockForeverConn(), "", c.bind.BatchSize())
+		return
+	}
+	for _, port := range ports {
+		pconn, err := c.listenMultipathPacket(mi.name, network, port)
+		if err != nil {
+			c.logf("magicsock: multipath: unable to bind %v port %d on %s: %v", network, port, mi.name, err)
+			continue
+		}
+		trySetUDPSocketOptions(pconn, c.logf)
+		ruc.setConnLocked(pconn, network, c.bind.BatchSize())
+		up.Store(true)
+		c.logf("magicsock: multipath: sending over %s with %v port %d", mi.name, network, ruc.port)
+		return
+	}
+	ruc.setConnLocked(newBlockForeverConn(), "", c.bind.BatchSize())
+}
+
+// listenMultipathPacket opens a socket for network on port, bound to the
+// interface ifName.
+func (c *Conn) listenMultipathPacket(ifName, network string, port uint16) (nettype.PacketConn, error) {
+	ctx := context.Background()
+	addr := net.JoinHostPort("", fmt.Sprint(port))
+	if c.testOnlyPacketListener != nil {
+		return nettype.MakePacketListenerWithNetIP(c.testOnlyPacketListener).ListenPacket(ctx, network, addr)
+	}
+	lc, err := netns.ListenerOnInterface(c.logf, c.netMon, ifName)
+	if err != nil {
+		return nil, err
+	}
+	return nettype.MakePacketListenerWithNetIP(lc).ListenPacket(ctx, network, addr)
+}
+
+// multipathFamilyOf is like multipathFamily, for network "udp4" or "udp6".
+func multipathFamilyOf(network string) int {
+	if network == "udp4" {
+		return 0
+	}
+	return 1
+}
+
+// multipathSTUNServersLocked returns the IPv4 and IPv6 STUN servers of our
+// home DERP region, or zero values if there's none.
+func (c *Conn) multipathSTUNServersLocked() (srv4, srv6 netip.AddrPort) {
+	if c.derpMap == nil {
+		return
+	}
+	reg := c.derpMap.Regions[c.myDerp]
+	if reg == nil {
+		return
+	}
+	for _, n := range reg.Nodes {
+		if n.STUNPort < 0 {
+			continue
+		}
+		port := uint16(cmp.Or(n.STUNPort, 3478))
+		ip4, ip6 := n.IPv4, n.IPv6
+		if n.STUNTestIP != "" {
+			ip4, ip6 = n.STUNTestIP, n.STUNTestIP
+		}
+		if ip, err := netip.ParseAddr(ip4); err == nil && ip.Is4() && !srv4.IsValid() {
+			srv4 = netip.AddrPortFrom(ip, port)
+		}
+		if ip, err := netip.ParseAddr(ip6); err == nil && ip.Is6() && !srv6.IsValid() {
+			srv6 = netip.AddrPortFrom(ip, port)
+		}
+	}
+	return srv4, srv6
+}
+
+// stunMultipath sends a STUN request over each bound multipath socket to
+// our home DERP region. handleMultipathSTUN handles the responses.
+func (c *Conn) stunMultipath() {
+	if len(c.multipath) == 0 {
+		return
+	}
+	c.mu.Lock()
+	srv4, srv6 := c.multipathSTUNServersLocked()
+	c.mu.Unlock()
+	for _, mi := range c.multipath {
+		for _, srv := range [...]netip.AddrPort{srv4, srv6} {
+			if !srv.IsValid() || !mi.canSendTo(srv) {
+				continue
+			}
+			txid := stun.NewTxID()
+			mi.mu.Lock()
+			mi.stunTxID[multipathFamily(srv)] = txid
+			mi.mu.Unlock()
+			if _, err := mi.sendUDP(srv, stun.Request(txid)); err != nil {
+				c.dlogf("[v1] magicsock: multipath: STUN to %v over %s: %v", srv, mi.name, err)
+			}
+		}
+	}
+}
+
+// handleMultipathSTUN handles the STUN packet b, which arrived over mi. If
+// it's a response to stunMultipath that tells us a new address for one of
+// mi's sockets, we advertise the new address.
+func (c *Conn) handleMultipathSTUN(mi *multipathIface, b []byte) {
+	txid, ext, err := stun.ParseResponse(b)
+	if err != nil || !ext.IsValid() {
+		return
+	}
+	f := multipathFamily(ext)
+	mi.mu.Lock()
+	changed := txid == mi.stunTxID[f] && mi.ext[f] != ext
+	if changed {
+		mi.ext[f] = ext
+	}
+	mi.mu.Unlock()
+	if changed {
+		c.logf("magicsock: multipath: %s is reachable at %v", mi.name, ext)
+		c.ReSTUN("multipath-endpoint-changed")
+	}
+}
+
+// multipathEndpoints returns the multipath sockets' addresses as seen from
+// the internet, as far as we know them.
+func (c *Conn) multipathEndpoints() []netip.AddrPort {
+	var eps []netip.AddrPort
+	for _, mi := range c.multipath {
+		mi.mu.Lock()
+		for _, ap := range mi.ext {
+			if ap.IsValid() {
+				eps = append(eps, ap)
+			}
+		}
+		mi.mu.Unlock()
+	}
+	return eps
+}
+
+// receiveMultipath returns the ReceiveFuncs reading from the multipath
+// sockets.
+func (c *Conn) receiveMultipath() []conn.ReceiveFunc {
+	var fns []conn.ReceiveFunc
+	for _, mi := range c.multipath {
+		fns = append(fns,
+			c.mkReceiveFunc(&mi.pconn4, nil, discoRXPathMultipath, mi,
+				&c.metrics.inboundPacketsIPv4Total,
+				&c.metrics.inboundPacketsPeerRelayIPv4Total,
+				&c.metrics.inboundBytesIPv4Total,
+				&c.metrics.inboundBytesPeerRelayIPv4Total,
+			),
+			c.mkReceiveFunc(&mi.pconn6, nil, discoRXPathMultipath, mi,
+				&c.metrics.inboundPacketsIPv6Total,
+				&c.metrics.inboundPacketsPeerRelayIPv6Total,
+				&c.metrics.inboundBytesIPv6Total,
+				&c.metrics.inboundBytesPeerRelayIPv6Total,
+			),
+		)
+	}
+	return fns
+}
+
+// closeMultipath closes the multipath sockets, unblocking their receives.
+func (c *Conn) closeMultipath() {
+	for _, mi := range c.multipath {
+		mi.up4.Store(false)
+		mi.up6.Store(false)
+		mi.pconn4.Close()
+		mi.pconn6.Close()
+	}
+}
+
+// endpointMultipath is an endpoint's multipath mode state. It's guarded by
+// endpoint.mu.
+type endpointMultipath struct {
+	duplicate bool // whether to also send over second
+	paths     map[multipathKey]*multipathPath
+	active    *multipathPath // path packets go over; nil for the regular path
+	second    *multipathPath // path duplicate packets go over, or nil
+	timer     *time.Timer    // next round of probes; nil while idle
+}
+
+type multipathKey struct {
+	iface string
+	dst   netip.AddrPort
+}
+
+// multipathPath is a path to one of a peer's direct endpoints over a
+// multipath interface.
+type multipathPath struct {
+	mi  *multipathIface
+	dst netip.AddrPort
+
+	// The following fields are guarded by endpoint.mu.
+	lastPing mono.Time
+	lastPong mono.Time
+	latency  time.Duration // as of the last pong
+	results  uint32        // recent probe results, newest in bit 0; 1 for a pong
+	nresults int           // number of valid bits in results
+
+	txPackets atomic.Int64
+	txBytes   atomic.Int64
+}
+
+func (p *multipathPath) key() multipathKey { return multipathKey{p.mi.name, p.dst} }
+
+func (p *multipathPath) String() string {
+	if p == nil {
+		return "regular path"
+	}
+	return p.mi.name + "/" + p.dst.String()
+}
+
+// addResult records whether a probe of p got a pong.
+func (p *multipathPath) addResult(pong bool) {
+	p.results <<= 1
+	if pong {
+		p.results |= 1
+	}
+	p.nresults = min(p.nresults+1, multipathResultCount)
+}
+
+// loss returns the fraction of p's recent probes that got no pong.
+func (p *multipathPath) loss() float64 {
+	if p.nresults == 0 {
+		return 0
+	}
+	mask := uint32(1)<<p.nresults - 1
+	lost := p.nresults - bits.OnesCount32(p.results&mask)
+	return float64(lost) / float64(p.nresults)
+}
+
+// isUp reports whether p can carry packets.
+func (p *multipathPath) isUp(now mono.Time) bool {
+	return !p.lastPong.IsZero() && now.Sub(p.lastPong) < multipathPathTimeout &&
+		p.loss() <= multipathMaxLoss && p.mi.canSendTo(p.dst)
+}
+
+// resetLocked forgets mp's paths and stops its probes. mp may be nil.
+func (mp *endpointMultipath) resetLocked() {
+	if mp == nil {
+		return
+	}
+	clear(mp.paths)
+	mp.active, mp.second = nil, nil
+	if mp.timer != nil {
+		mp.timer.Stop()
+		mp.timer = nil
+	}
+}
+
+// statusLocked returns mp as reported in status.
+func (mp *endpointMultipath) statusLocked(now mono.Time) *ipnstate.PeerMultipath {
+	st := &ipnstate.PeerMultipath{Duplicate: mp.duplicate}
+	if mp.active != nil {
+		st.Active = mp.active.String()
+	}
+	if mp.second != nil {
+		st.Second = mp.second.String()
+	}
+	for _, p := range mp.paths {
+		ps := ipnstate.PeerMultipathPath{
+			Interface: p.mi.name,
+			Addr:      p.dst.String(),
+			Up:        p.isUp(now),
+			Loss:      p.loss(),
+			TxPackets: p.txPackets.Load(),
+			TxBytes:   p.txBytes.Load(),
+		}
+		if !p.lastPong.IsZero() {
+			ps.LastPong = p.lastPong.WallTime()
+			ps.LatencySeconds = p.latency.Seconds()
+		}
+		st.Paths = append(st.Paths, ps)
+	}
+	slices.SortFunc(st.Paths, func(a, b ipnstate.PeerMultipathPath) int {
+		return cmp.Or(cmp.Compare(a.Interface, b.Interface), cmp.Compare(a.Addr, b.Addr))
+	})
+	return st
+}
+
+// syncMultipathPathsLocked makes de's multipath paths those from each bound
+// multipath socket to each of de's direct endpoints.
+func (de *endpoint) syncMultipathPathsLocked() {
+	mp := de.multipath
+	for k, p := range mp.paths {
+		if _, ok := de.endpointState[k.dst]; !ok || !p.mi.canSendTo(k.dst) {
+			delete(mp.paths, k)
+		}
+	}
+	for dst := range de.endpointState {
+		for _, mi := range de.c.multipath {
+			k := multipathKey{mi.name, dst}
+			if _, ok := mp.paths[k]; !ok && mi.canSendTo(dst) {
+				mp.paths[k] = &multipathPath{mi: mi, dst: dst}
+			}
+		}
+	}
+}
+
+// probeMultipathLocked pings those of de's multipath paths that are due
+// for a probe, and schedules the next round of probes.
+func (de *endpoint) probeMultipathLocked(now mono.Time) {
+	mp := de.multipath
+	epDisco := de.disco.Load()
+	if epDisco == nil {
+		return
+	}
+	de.syncMultipathPathsLocked()
+
+	// Probe the best path over each interface often enough to notice
+	// quickly when it goes down, and the others like the regular
+	// sockets' candidates.
+	best := make(map[*multipathIface]*multipathPath)
+	for _, p := range mp.paths {
+		if b := best[p.mi]; !p.lastPong.IsZero() && (b == nil || p.latency < b.latency) {
+			best[p.mi] = p
+		}
+	}
+	for _, p := range mp.paths {
+		interval := discoPingInterval
+		if best[p.mi] == p {
+			interval = multipathProbeInterval
+		}
+		if p.lastPing.IsZero() || now.Sub(p.lastPing) >= interval {
+			de.startMultipathPingLocked(p, epDisco.key, now)
+		}
+	}
+	if mp.timer == nil {
+		mp.timer = time.AfterFunc(multipathProbeInterval, de.multipathProbeTimer)
+	}
+}
+
+// multipathProbeTimer runs a round of multipath probes, for as long as de's
+// session is active.
+func (de *endpoint) multipathProbeTimer() {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	mp := de.multipath
+	if mp == nil || mp.timer == nil {
+		// Stopped by resetLocked.
+		return
+	}
+	mp.timer = nil
+	now := mono.Now()
+	if de.lastSendExt.IsZero() || now.Sub(de.lastSendExt) > sessionActiveTimeout {
+		return
+	}
+	de.probeMultipathLocked(now)
+}
+
+// startMultipathPingLocked sends a disco ping to probe p.
+func (de *endpoint) startMultipathPingLocked(p *multipathPath, discoKey key.DiscoPublic, now mono.Time) {
+	p.lastPing = now
+	de.lastSendAny = now
+	txid := stun.NewTxID()
+	de.sentPing[txid] = sentPing{
+		to:        epAddr{ap: p.dst},
+		at:        now,
+		timer:     time.AfterFunc(multipathPathTimeout, func() { de.discoPingTimeout(txid) }),
+		purpose:   pingHeartbeat,
+		multipath: p,
+	}
+	go de.sendMultipathPing(p, discoKey, txid)
+}
+
+func (de *endpoint) sendMultipathPing(p *multipathPath, discoKey key.DiscoPublic, txid stun.TxID) {
+	sent, _ := de.c.sendDiscoMessageVia(p.mi, epAddr{ap: p.dst}, de.publicKey, discoKey, &disco.Ping{
+		TxID:    [12]byte(txid),
+		NodeKey: de.c.publicKeyAtomic.Load(),
+	}, discoVerboseLog)
+	if !sent {
+		de.forgetDiscoPing(txid)
+	}
+}
+
+// handleMultipathPing probes de's path over mi to src, which one of de's
+// pings just arrived over, unless it's been probed lately. de is likely
+// probing the reverse path at the same time, so this opens both sides'
+// NATs to each other.
+func (de *endpoint) handleMultipathPing(mi *multipathIface, src netip.AddrPort) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	mp := de.multipath
+	epDisco := de.disco.Load()
+	if mp == nil || epDisco == nil {
+		return
+	}
+	de.syncMultipathPathsLocked()
+	now := mono.Now()
+	if p := mp.paths[multipathKey{mi.name, src}]; p != nil && (p.lastPing.IsZero() || now.Sub(p.lastPing) >= multipathProbeInterval) {
+		de.startMultipathPingLocked(p, epDisco.key, now)
+	}
+}
+
+// handleMultipathPongLocked records a pong to a probe of p sent latency
+// ago.
+func (de *endpoint) handleMultipathPongLocked(p *multipathPath, latency time.Duration, now mono.Time) {
+	p.lastPong = now
+	p.latency = latency
+	p.addResult(true)
+	de.selectMultipathLocked(now)
+}
+
+// selectMultipathLocked picks the paths packets to de go over.
+func (de *endpoint) selectMultipathLocked(now mono.Time) {
+	mp := de.multipath
+	var best *multipathPath
+	for _, p := range mp.paths {
+		if p.isUp(now) && (best == nil || p.latency < best.latency) {
+			best = p
+		}
+	}
+	// Stick with the current path unless another is at least a quarter
+	// faster, so that jitter doesn't make us flap between similar paths.
+	if cur := mp.active; cur != nil && best != nil && mp.paths[cur.key()] == cur && cur.isUp(now) &&
+		best.latency*4 > cur.latency*3 {
+		best = cur
+	}
+	var second *multipathPath
+	if mp.duplicate && best != nil {
+		for _, p := range mp.paths {
+			if p.mi != best.mi && p.isUp(now) && (second == nil || p.latency < second.latency) {
+				second = p
+			}
+		}
+	}
+	if best != mp.active {
+		de.c.logf("magicsock: multipath: node %v now using %v (was %v)", de.publicKey.ShortString(), best, mp.active)
+	}
+	mp.active, mp.second = best, second
+}
+
+// multipathSendPathsLocked returns the paths to send packets to de over,
+// with first nil if they should go over the regular path.
+func (de *endpoint) multipathSendPathsLocked(now mono.Time) (first, second *multipathPath) {
+	mp := de.multipath
+	if mp == nil {
+		return nil, nil
+	}
+	if mp.timer == nil {
+		de.probeMultipathLocked(now)
+	}
+	if mp.active != nil && !mp.active.isUp(now) || mp.second != nil && !mp.second.isUp(now) {
+		de.selectMultipathLocked(now)
+	}
+	return mp.active, mp.second
+}
+
+// sendMultipath sends buffs over first and, if non-nil, second. It reports
+// whether either send worked.
+func (de *endpoint) sendMultipath(first, second *multipathPath, buffs [][]byte, offset int) bool {
+	var ok bool
+	for _, p := range [2]*multipathPath{first, second} {
+		if p == nil {
+			continue
+		}
+		ruc, bound := p.mi.conn(p.dst)
+		if !bound {
+			continue
+		}
+		err := ruc.WriteWireGuardBatchTo(buffs, epAddr{ap: p.dst}, offset)
+		var errGSO neterror.ErrUDPGSODisabled
+		if errors.As(err, &errGSO) {
+			// The packets went out without GSO.
+			err = errGSO.RetryErr
+		}
+		if err != nil {
+			de.noteMultipathSendError(p, err)
+			continue
+		}
+		ok = true
+
+		var txBytes int
+		for _, b := range buffs {
+			txBytes += len(b[offset:])
+		}
+		p.txPackets.Add(int64(len(buffs)))
+		p.txBytes.Add(int64(txBytes))
+		if p.dst.Addr().Is4() {
+			de.c.metrics.outboundPacketsIPv4Total.Add(int64(len(buffs)))
+			de.c.metrics.outboundBytesIPv4Total.Add(int64(txBytes))
+		} else {
+			de.c.metrics.outboundPacketsIPv6Total.Add(int64(len(buffs)))
+			de.c.metrics.outboundBytesIPv6Total.Add(int64(txBytes))
+		}
+		if update := de.c.connCounter.Load(); update != nil {
+			update(0, netip.AddrPortFrom(de.nodeAddr, 0), p.dst, len(buffs), txBytes, false)
+		}
+	}
+	return ok
+}
+
+// noteMultipathSendError marks p as down after a failed send, so that the
+// next packets go over another path.
+func (de *endpoint) noteMultipathSendError(p *multipathPath, err error) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	de.c.logf("[v1] magicsock: multipath: send to %v over %v failed: %v", de.publicKey.ShortString(), p, err)
+	p.lastPong = 0
+	if de.multipath != nil {
+		de.selectMultipathLocked(mono.Now())
+	}
+}
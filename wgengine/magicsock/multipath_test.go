@@ -0,0 +1,221 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"tailscale.com/disco"
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/mono"
+)
+
+func TestSplitMultipathList(t *testing.T) {
+	got := splitMultipathList(" wwan0,, eth1 ,wwan0")
+	if want := []string{"wwan0", "eth1"}; !slices.Equal(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestIsMultipathDuplicatePeer(t *testing.T) {
+	n := (&tailcfg.Node{
+		Name:      "truck-7.example.ts.net.",
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.7/32")},
+		Hostinfo:  (&tailcfg.Hostinfo{Hostname: "Truck7"}).View(),
+	}).View()
+	tests := []struct {
+		peers []string
+		want  bool
+	}{
+		{nil, false},
+		{[]string{"100.64.0.7"}, true},
+		{[]string{"100.64.0.8"}, false},
+		{[]string{"truck-7.example.ts.net"}, true},
+		{[]string{"truck-7"}, true},
+		{[]string{"truck7"}, true},
+		{[]string{"truck-8", "TRUCK-7.example.ts.net."}, true},
+		{[]string{"example"}, false},
+	}
+	for _, tt := range tests {
+		c := &Conn{multipathDuplicatePeers: tt.peers}
+		if got := c.isMultipathDuplicatePeer(n); got != tt.want {
+			t.Errorf("isMultipathDuplicatePeer with %q = %v; want %v", tt.peers, got, tt.want)
+		}
+	}
+}
+
+func TestMultipathPathLoss(t *testing.T) {
+	var p multipathPath
+	if got := p.loss(); got != 0 {
+		t.Errorf("loss with no results = %v; want 0", got)
+	}
+	for range 4 {
+		p.addResult(true)
+	}
+	p.addResult(false)
+	p.addResult(false)
+	if got, want := p.loss(), 2.0/6; got != want {
+		t.Errorf("loss = %v; want %v", got, want)
+	}
+	for range multipathResultCount {
+		p.addResult(true)
+	}
+	if got := p.loss(); got != 0 {
+		t.Errorf("loss after %d pongs = %v; want 0", multipathResultCount, got)
+	}
+}
+
+func TestMultipathSelect(t *testing.T) {
+	lte := &multipathIface{name: "lte"}
+	sat := &multipathIface{name: "sat"}
+	lte.up4.Store(true)
+	sat.up4.Store(true)
+	dst := netip.MustParseAddrPort("1.2.3.4:41641")
+
+	de := newPathTestEndpoint()
+	de.c.multipath = []*multipathIface{lte, sat}
+	de.endpointState[dst] = &endpointState{}
+	de.multipath = &endpointMultipath{paths: make(map[multipathKey]*multipathPath)}
+	mp := de.multipath
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	de.syncMultipathPathsLocked()
+	if len(mp.paths) != 2 {
+		t.Fatalf("got %d paths; want 2", len(mp.paths))
+	}
+	pl := mp.paths[multipathKey{"lte", dst}]
+	ps := mp.paths[multipathKey{"sat", dst}]
+	check := func(step string, wantActive, wantSecond *multipathPath) {
+		t.Helper()
+		if mp.active != wantActive || mp.second != wantSecond {
+			t.Errorf("%s: active, second = %v, %v; want %v, %v", step, mp.active, mp.second, wantActive, wantSecond)
+		}
+	}
+
+	now := mono.Now()
+	check("no pongs", nil, nil)
+	de.handleMultipathPongLocked(ps, 600*time.Millisecond, now)
+	check("satellite pong", ps, nil)
+	de.handleMultipathPongLocked(pl, 50*time.Millisecond, now)
+	check("LTE pong", pl, nil)
+	de.handleMultipathPongLocked(ps, 45*time.Millisecond, now)
+	check("slightly faster satellite", pl, nil)
+
+	later := now.Add(multipathPathTimeout)
+	de.handleMultipathPongLocked(ps, 600*time.Millisecond, later)
+	check("LTE timed out", ps, nil)
+
+	for range multipathResultCount / 2 {
+		pl.addResult(false)
+	}
+	de.handleMultipathPongLocked(pl, 50*time.Millisecond, later)
+	check("lossy LTE", ps, nil)
+	pl.results, pl.nresults = 0, 0
+	de.handleMultipathPongLocked(pl, 50*time.Millisecond, later)
+	check("LTE back", pl, nil)
+
+	mp.duplicate = true
+	de.selectMultipathLocked(later)
+	check("duplicate", pl, ps)
+	sat.up4.Store(false)
+	de.selectMultipathLocked(later)
+	check("satellite unbound", pl, nil)
+
+	st := mp.statusLocked(later)
+	if st.Active != "lte/1.2.3.4:41641" || !st.Duplicate || st.Second != "" {
+		t.Errorf("status = %+v", st)
+	}
+	if len(st.Paths) != 2 || st.Paths[0].Interface != "lte" || !st.Paths[0].Up || st.Paths[1].Up {
+		t.Errorf("status paths = %+v", st.Paths)
+	}
+
+	delete(de.endpointState, dst)
+	de.syncMultipathPathsLocked()
+	de.selectMultipathLocked(later)
+	check("endpoint gone", nil, nil)
+}
+
+func TestMultipathSTUN(t *testing.T) {
+	lte := &multipathIface{name: "lte"}
+	c := &Conn{
+		logf:      t.Logf,
+		closed:    true, // so that ReSTUN is a no-op
+		multipath: []*multipathIface{lte},
+		myDerp:    1,
+		derpMap: &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+			1: {RegionID: 1, Nodes: []*tailcfg.DERPNode{
+				{Name: "1a", IPv4: "none", IPv6: "2001:db8::1", STUNPort: -1},
+				{Name: "1b", IPv4: "192.0.2.1", IPv6: "2001:db8::2"},
+				{Name: "1c", IPv4: "192.0.2.2", STUNPort: 3479},
+			}},
+		}},
+	}
+	srv4, srv6 := c.multipathSTUNServersLocked()
+	if want := netip.MustParseAddrPort("192.0.2.1:3478"); srv4 != want {
+		t.Errorf("IPv4 STUN server = %v; want %v", srv4, want)
+	}
+	if want := netip.MustParseAddrPort("[2001:db8::2]:3478"); srv6 != want {
+		t.Errorf("IPv6 STUN server = %v; want %v", srv6, want)
+	}
+
+	ext := netip.MustParseAddrPort("203.0.113.5:1234")
+	txid := stun.NewTxID()
+	c.handleMultipathSTUN(lte, stun.Response(txid, ext))
+	if eps := c.multipathEndpoints(); len(eps) != 0 {
+		t.Errorf("endpoints after unsolicited response = %v; want none", eps)
+	}
+	lte.stunTxID[multipathFamily(ext)] = txid
+	c.handleMultipathSTUN(lte, stun.Response(txid, ext))
+	if eps := c.multipathEndpoints(); !slices.Equal(eps, []netip.AddrPort{ext}) {
+		t.Errorf("endpoints = %v; want [%v]", eps, ext)
+	}
+}
+
+func TestMultipathPongFromOtherSocket(t *testing.T) {
+	lte := &multipathIface{name: "lte"}
+	sat := &multipathIface{name: "sat"}
+	lte.up4.Store(true)
+	sat.up4.Store(true)
+	dst := netip.MustParseAddrPort("1.2.3.4:41641")
+
+	de := newPathTestEndpoint()
+	de.c.multipath = []*multipathIface{lte, sat}
+	de.endpointState[dst] = &endpointState{}
+	de.sentPing = make(map[stun.TxID]sentPing)
+	de.multipath = &endpointMultipath{paths: make(map[multipathKey]*multipathPath)}
+	de.mu.Lock()
+	de.syncMultipathPathsLocked()
+	p := de.multipath.paths[multipathKey{"lte", dst}]
+	txid := stun.NewTxID()
+	de.sentPing[txid] = sentPing{
+		to:        epAddr{ap: dst},
+		at:        mono.Now(),
+		timer:     time.AfterFunc(time.Hour, func() {}),
+		purpose:   pingHeartbeat,
+		multipath: p,
+	}
+	de.mu.Unlock()
+
+	pong := &disco.Pong{TxID: txid}
+	for _, mi := range []*multipathIface{nil, sat} {
+		if !de.handlePongConnLocked(pong, nil, epAddr{ap: dst}, mi) {
+			t.Fatalf("pong over %v: unknown TxID", mi)
+		}
+		if !p.lastPong.IsZero() || de.multipath.active != nil {
+			t.Fatalf("pong over %v marked %v up", mi, p)
+		}
+	}
+	de.handlePongConnLocked(pong, nil, epAddr{ap: dst}, lte)
+	if p.lastPong.IsZero() || de.multipath.active != p {
+		t.Errorf("pong over lte: active = %v; want %v", de.multipath.active, p)
+	}
+	if _, ok := de.sentPing[txid]; ok {
+		t.Errorf("ping still pending after its pong")
+	}
+}
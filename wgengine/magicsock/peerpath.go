@@ -0,0 +1,162 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/mono"
+)
+
+// pathHistorySize is how many path changes we remember per peer. The
+// history grows as changes happen, so peers whose path never changes cost
+// nothing.
+const pathHistorySize = 32
+
+// pathTypeOf returns the kind of path that sending to a is, where the zero
+// epAddr means DERP.
+func pathTypeOf(a epAddr) ipnstate.PathType {
+	switch {
+	case !a.ap.IsValid():
+		return ipnstate.PathDERP
+	case a.vni.IsSet():
+		return ipnstate.PathPeerRelay
+	}
+	return ipnstate.PathDirect
+}
+
+// pathAddrString returns a as a PeerPath address, or "" for DERP.
+func pathAddrString(a epAddr) string {
+	if !a.ap.IsValid() {
+		return ""
+	}
+	return a.String()
+}
+
+// notePathLocked records in de's path history that packets to de now go
+// over to, with the zero addrQuality meaning DERP, if that's not the path
+// recorded last.
+//
+// de.mu must be held.
+func (de *endpoint) notePathLocked(to addrQuality, why string) {
+	if de.isWireguardOnly || to.epAddr == de.pathAddr {
+		// WireGuard-only peers pick a random path until they have
+		// latencies, which would just be noise.
+		return
+	}
+	de.pathHistory.Add(ipnstate.PeerPathChange{
+		When:           time.Now(),
+		From:           pathTypeOf(de.pathAddr),
+		FromAddr:       pathAddrString(de.pathAddr),
+		To:             pathTypeOf(to.epAddr),
+		ToAddr:         pathAddrString(to.epAddr),
+		LatencySeconds: to.latency.Seconds(),
+		Reason:         why,
+	})
+	de.pathAddr = to.epAddr
+}
+
+// peerPathLocked returns the explanation of de's current path.
+//
+// c.mu and de.mu must be held.
+func (de *endpoint) peerPathLocked(now mono.Time) *ipnstate.PeerPath {
+	regionID := int(de.derpAddr.Port())
+	pp := &ipnstate.PeerPath{
+		Path:           ipnstate.PathDERP,
+		BestAddr:       pathAddrString(de.bestAddr.epAddr),
+		DERPRegionID:   regionID,
+		DERPRegionCode: de.c.derpRegionCodeOfIDLocked(regionID),
+		History:        de.pathHistory.GetAll(),
+	}
+	if de.bestAddr.ap.IsValid() {
+		pp.BestAddrLatencySeconds = de.bestAddr.latency.Seconds()
+		pp.BestAddrTrustedUntil = de.trustBestAddrUntil.WallTime()
+	}
+
+	var pongs int // total number of pongs from all candidates
+	for ap, st := range de.endpointState {
+		c := ipnstate.PeerPathCandidate{
+			Addr:   ap.String(),
+			Source: "netmap",
+			Pongs:  len(st.recentPongs),
+		}
+		switch {
+		case !st.callMeMaybeTime.IsZero():
+			c.Source = "call-me-maybe"
+		case !st.lastGotPing.IsZero():
+			c.Source = "disco-ping"
+		}
+		if !st.lastPing.IsZero() {
+			c.LastPing = st.lastPing.WallTime()
+		}
+		if len(st.recentPongs) > 0 {
+			r := st.recentPongs[st.recentPong]
+			c.LastPong = r.pongAt.WallTime()
+			c.LatencySeconds = r.latency.Seconds()
+		}
+		pongs += c.Pongs
+		pp.Candidates = append(pp.Candidates, c)
+	}
+	slices.SortFunc(pp.Candidates, func(a, b ipnstate.PeerPathCandidate) int {
+		return cmp.Compare(a.Addr, b.Addr)
+	})
+
+	trusted := de.bestAddr.ap.IsValid() && !now.After(de.trustBestAddrUntil)
+	switch {
+	case trusted:
+		pp.Path = pathTypeOf(de.bestAddr.epAddr)
+		pp.Addr = pp.BestAddr
+		if de.isWireguardOnly {
+			pp.Reason = "WireGuard-only peer; using the candidate with the lowest latency"
+		} else {
+			pp.Reason = fmt.Sprintf("best path confirmed by a pong %v ago", now.Sub(de.bestAddrAt).Round(time.Second))
+		}
+	case de.isWireguardOnly:
+		pp.Path = pathTypeOf(de.bestAddr.epAddr)
+		pp.Addr = pp.BestAddr
+		pp.Reason = "WireGuard-only peer; no latency measurements yet"
+	case de.bestAddr.ap.IsValid():
+		pp.Reason = fmt.Sprintf("best path %v not confirmed by a pong in the last %v; sending over DERP while probing it",
+			de.bestAddr.epAddr, trustUDPAddrDuration)
+	case de.lastSendExt.IsZero():
+		pp.Reason = "no recent traffic to the peer, so no paths probed"
+	case len(de.endpointState) == 0 && !de.relayCapable:
+		pp.Reason = "peer has no known direct UDP endpoints"
+	case len(de.endpointState) == 0:
+		pp.Reason = "peer has no known direct UDP endpoints and no peer relay path was found"
+	case pongs == 0:
+		pp.Reason = fmt.Sprintf("no pong from any of %d direct candidates; UDP is likely blocked or the NATs are too hard to traverse", len(de.endpointState))
+	default:
+		pp.Reason = "no current direct path; waiting for pongs from candidates after a path was dropped"
+	}
+	if pp.Path == ipnstate.PathDERP && !de.derpAddr.IsValid() && !de.isWireguardOnly {
+		pp.Reason += "; peer has no home DERP region either"
+	}
+	if mp := de.multipath; mp != nil && mp.active != nil {
+		pp.Reason += fmt.Sprintf("; multipath mode sends over %v instead", mp.active)
+	}
+	return pp
+}
+
+// PeerPath returns the explanation of how packets are sent to peer and its
+// recent path changes.
+func (c *Conn) PeerPath(peer tailcfg.NodeView) (*ipnstate.PeerPath, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.privateKey.IsZero() {
+		return nil, fmt.Errorf("tailscaled stopped")
+	}
+	ep, ok := c.peerMap.endpointForNodeKey(peer.Key())
+	if !ok {
+		return nil, fmt.Errorf("unknown peer")
+	}
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return ep.peerPathLocked(mono.Now()), nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/packet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/util/ringlog"
+)
+
+func newPathTestEndpoint() *endpoint {
+	return &endpoint{
+		c: &Conn{
+			logf: func(msg string, args ...any) {},
+			derpMap: &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+				1: {RegionID: 1, RegionCode: "nyc"},
+			}},
+		},
+		derpAddr:      netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, 1),
+		endpointState: map[netip.AddrPort]*endpointState{},
+		pathHistory:   ringlog.New[ipnstate.PeerPathChange](pathHistorySize),
+	}
+}
+
+func TestNotePath(t *testing.T) {
+	direct := addrQuality{epAddr: epAddr{ap: netip.MustParseAddrPort("1.2.3.4:41641")}, latency: 5 * time.Millisecond}
+	var vni packet.VirtualNetworkID
+	vni.Set(7)
+	relay := addrQuality{epAddr: epAddr{ap: netip.MustParseAddrPort("5.6.7.8:7777"), vni: vni}}
+
+	de := newPathTestEndpoint()
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	de.setBestAddrLocked(direct, "pong")
+	de.notePathLocked(direct, "pong again")
+	de.setBestAddrLocked(relay, "relay")
+	de.clearBestAddrLocked("gone")
+	de.clearBestAddrLocked("gone again")
+
+	got := de.pathHistory.GetAll()
+	want := []ipnstate.PeerPathChange{
+		{From: ipnstate.PathDERP, To: ipnstate.PathDirect, ToAddr: "1.2.3.4:41641", LatencySeconds: 0.005, Reason: "pong"},
+		{From: ipnstate.PathDirect, FromAddr: "1.2.3.4:41641", To: ipnstate.PathPeerRelay, ToAddr: relay.epAddr.String(), Reason: "relay"},
+		{From: ipnstate.PathPeerRelay, FromAddr: relay.epAddr.String(), To: ipnstate.PathDERP, Reason: "gone"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d path changes, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range got {
+		if got[i].When.IsZero() {
+			t.Errorf("change %d has no time", i)
+		}
+		got[i].When = time.Time{}
+		if got[i] != want[i] {
+			t.Errorf("change %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPeerPathReason(t *testing.T) {
+	now := mono.Now()
+	ap := netip.MustParseAddrPort("1.2.3.4:41641")
+	tests := []struct {
+		name       string
+		setup      func(*endpoint)
+		wantPath   ipnstate.PathType
+		wantReason string // substring
+	}{
+		{
+			name:       "idle",
+			setup:      func(de *endpoint) {},
+			wantPath:   ipnstate.PathDERP,
+			wantReason: "no recent traffic",
+		},
+		{
+			name: "no-endpoints",
+			setup: func(de *endpoint) {
+				de.lastSendExt = now
+			},
+			wantPath:   ipnstate.PathDERP,
+			wantReason: "no known direct UDP endpoints",
+		},
+		{
+			name: "no-pongs",
+			setup: func(de *endpoint) {
+				de.lastSendExt = now
+				de.endpointState[ap] = &endpointState{lastPing: now}
+			},
+			wantPath:   ipnstate.PathDERP,
+			wantReason: "no pong from any of 1 direct candidates",
+		},
+		{
+			name: "untrusted",
+			setup: func(de *endpoint) {
+				de.lastSendExt = now
+				de.bestAddr = addrQuality{epAddr: epAddr{ap: ap}}
+				de.trustBestAddrUntil = now.Add(-time.Second)
+			},
+			wantPath:   ipnstate.PathDERP,
+			wantReason: "not confirmed by a pong",
+		},
+		{
+			name: "trusted",
+			setup: func(de *endpoint) {
+				de.lastSendExt = now
+				de.bestAddr = addrQuality{epAddr: epAddr{ap: ap}}
+				de.bestAddrAt = now.Add(-2 * time.Second)
+				de.trustBestAddrUntil = now.Add(time.Second)
+			},
+			wantPath:   ipnstate.PathDirect,
+			wantReason: "confirmed by a pong 2s ago",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			de := newPathTestEndpoint()
+			tt.setup(de)
+			pp := de.peerPathLocked(now)
+			if pp.Path != tt.wantPath {
+				t.Errorf("Path = %q, want %q", pp.Path, tt.wantPath)
+			}
+			if !strings.Contains(pp.Reason, tt.wantReason) {
+				t.Errorf("Reason = %q, want it to contain %q", pp.Reason, tt.wantReason)
+			}
+			if pp.DERPRegionCode != "nyc" {
+				t.Errorf("DERPRegionCode = %q, want nyc", pp.DERPRegionCode)
+			}
+		})
+	}
+}